@@ -76,40 +76,11 @@ func (s *Server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.CheckConfig(newconfig.ServerConfig)
+	err = s.Reconfigure(newconfig.ServerConfig)
 	if err != nil {
-		httpError(r, w, http.StatusBadRequest, "Invalid DHCP configuration: %s", err)
-		return
-	}
-
-	err = s.Stop()
-	if err != nil {
-		log.Error("failed to stop the DHCP server: %s", err)
-	}
-
-	err = s.Init(newconfig.ServerConfig)
-	if err != nil {
-		httpError(r, w, http.StatusBadRequest, "Invalid DHCP configuration: %s", err)
+		httpError(r, w, http.StatusBadRequest, "%s", err)
 		return
 	}
-	s.conf.ConfigModified()
-
-	if newconfig.Enabled {
-		staticIP, err := HasStaticIP(newconfig.InterfaceName)
-		if !staticIP && err == nil {
-			err = SetStaticIP(newconfig.InterfaceName)
-			if err != nil {
-				httpError(r, w, http.StatusInternalServerError, "Failed to configure static IP: %s", err)
-				return
-			}
-		}
-
-		err = s.Start()
-		if err != nil {
-			httpError(r, w, http.StatusBadRequest, "Failed to start DHCP server: %s", err)
-			return
-		}
-	}
 }
 
 type netInterfaceJSON struct {
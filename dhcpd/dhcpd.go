@@ -144,6 +144,44 @@ func (s *Server) Init(config ServerConfig) error {
 	return nil
 }
 
+// Reconfigure - apply the new configuration and restart the server if it's enabled
+// A static IP address is configured on the interface if necessary.
+func (s *Server) Reconfigure(config ServerConfig) error {
+	err := s.CheckConfig(config)
+	if err != nil {
+		return fmt.Errorf("Invalid DHCP configuration: %s", err)
+	}
+
+	err = s.Stop()
+	if err != nil {
+		log.Error("failed to stop the DHCP server: %s", err)
+	}
+
+	err = s.Init(config)
+	if err != nil {
+		return fmt.Errorf("Invalid DHCP configuration: %s", err)
+	}
+	if s.conf.ConfigModified != nil {
+		s.conf.ConfigModified()
+	}
+
+	if config.Enabled {
+		staticIP, err := HasStaticIP(config.InterfaceName)
+		if !staticIP && err == nil {
+			err = SetStaticIP(config.InterfaceName)
+			if err != nil {
+				return fmt.Errorf("Failed to configure static IP: %s", err)
+			}
+		}
+
+		err = s.Start()
+		if err != nil {
+			return fmt.Errorf("Failed to start DHCP server: %s", err)
+		}
+	}
+	return nil
+}
+
 // SetOnLeaseChanged - set callback
 func (s *Server) SetOnLeaseChanged(onLeaseChanged onLeaseChangedT) {
 	s.onLeaseChanged = append(s.onLeaseChanged, onLeaseChanged)
@@ -719,6 +757,36 @@ func (s *Server) RemoveStaticLease(l Lease) error {
 	return nil
 }
 
+// ReplaceLeases - replace the lease table, e.g. with the leases received from another DHCP server (thread-safe)
+// The expired dynamic leases are skipped.
+func (s *Server) ReplaceLeases(leases []Lease) {
+	staticLeases := []*Lease{}
+	dynLeases := []*Lease{}
+	now := time.Now().Unix()
+	for i := range leases {
+		l := leases[i]
+		l.IP = normalizeIP(l.IP)
+		if len(l.IP) != 4 || len(l.HWAddr) != 6 {
+			continue
+		}
+		if l.Expiry.Unix() == leaseExpireStatic {
+			staticLeases = append(staticLeases, &l)
+		} else if l.Expiry.Unix() > now {
+			dynLeases = append(dynLeases, &l)
+		}
+	}
+
+	s.leasesLock.Lock()
+	s.leases = normalizeLeases(staticLeases, dynLeases)
+	s.IPpool = make(map[[4]byte]net.HardwareAddr)
+	for _, l := range s.leases {
+		s.reserveIP(l.IP, l.HWAddr)
+	}
+	s.dbStore()
+	s.leasesLock.Unlock()
+	s.notify(LeaseChangedAdded)
+}
+
 // flags for Leases() function
 const (
 	LeasesDynamic = 1
@@ -275,3 +275,28 @@ func TestNormalizeLeases(t *testing.T) {
 	assert.True(t, bytes.Equal(leases[1].HWAddr, []byte{2, 2, 3, 4}))
 	assert.True(t, bytes.Equal(leases[2].HWAddr, []byte{1, 2, 3, 5}))
 }
+
+func TestReplaceLeases(t *testing.T) {
+	s := Server{}
+	s.conf.DBFilePath = dbFilename
+	s.reset()
+	notified := 0
+	s.SetOnLeaseChanged(func(flags int) {
+		notified++
+	})
+
+	s.ReplaceLeases([]Lease{
+		{HWAddr: []byte{1, 2, 3, 4, 5, 6}, IP: net.ParseIP("192.168.1.2"), Hostname: "static", Expiry: time.Unix(leaseExpireStatic, 0)},
+		{HWAddr: []byte{1, 2, 3, 4, 5, 7}, IP: net.ParseIP("192.168.1.100"), Hostname: "dynamic", Expiry: time.Now().Add(time.Hour)},
+		{HWAddr: []byte{1, 2, 3, 4, 5, 8}, IP: net.ParseIP("192.168.1.101"), Expiry: time.Unix(100, 0)}, // expired
+		{HWAddr: []byte{1, 2, 3}, IP: net.ParseIP("192.168.1.102"), Expiry: time.Now().Add(time.Hour)}, // invalid MAC
+	})
+	_ = os.Remove(dbFilename)
+
+	assert.Equal(t, 1, notified)
+	assert.Equal(t, 2, len(s.Leases(LeasesAll)))
+	assert.Equal(t, "static", s.Leases(LeasesStatic)[0].Hostname)
+	assert.Equal(t, "dynamic", s.Leases(LeasesDynamic)[0].Hostname)
+	assert.Equal(t, net.HardwareAddr{1, 2, 3, 4, 5, 7}, s.findReservedHWaddr(net.IP{192, 168, 1, 100}))
+	assert.Nil(t, s.findReservedHWaddr(net.IP{192, 168, 1, 101}))
+}
@@ -213,6 +213,15 @@ func BlockedSvcKnown(s string) bool {
 	return ok
 }
 
+// BlockedSvcNames - get the names of all known services
+func BlockedSvcNames() []string {
+	names := []string{}
+	for _, s := range serviceRulesArray {
+		names = append(names, s.name)
+	}
+	return names
+}
+
 // ApplyBlockedServices - set blocked services settings for this DNS request
 func (d *Dnsfilter) ApplyBlockedServices(setts *RequestFilteringSettings, list []string, global bool) {
 	setts.ServicesRules = []ServiceEntry{}
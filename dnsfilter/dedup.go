@@ -0,0 +1,116 @@
+package dnsfilter
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DedupStats - the result of the deduplication of the rules of the filter lists
+type DedupStats struct {
+	Rules      int `json:"rules"`      // the number of the rules in all lists
+	Duplicates int `json:"duplicates"` // the number of the removed duplicate rules
+}
+
+// ruleDedup - the results of the last deduplication
+type ruleDedup struct {
+	lock  sync.Mutex
+	block DedupStats
+	allow DedupStats
+}
+
+// dedupFilters - remove the rules that are contained in the previous lists or earlier in the same list
+// The data of the lists is read into memory.  The user rules (ID 0) aren't changed.
+// Return the new lists and the other lists that contain the kept rules: (filter ID, rule) -> filter IDs.
+func dedupFilters(filters []Filter) ([]Filter, map[ruleHitKey][]int64, DedupStats, error) {
+	var stats DedupStats
+	seen := map[string]int64{} // rule -> the ID of the list that keeps it
+	owners := map[ruleHitKey][]int64{}
+	result := []Filter{}
+	for _, f := range filters {
+		if f.ID == 0 || !fileExists(f.FilePath) {
+			result = append(result, f)
+			continue
+		}
+
+		file, err := os.Open(f.FilePath)
+		if err != nil {
+			return nil, nil, stats, err
+		}
+		buf := bytes.Buffer{}
+		r := bufio.NewReader(file)
+		for {
+			line, err := r.ReadString('\n')
+			rule := strings.TrimSpace(line)
+			if len(rule) != 0 && rule[0] != '!' && rule[0] != '#' {
+				stats.Rules++
+				id, ok := seen[rule]
+				if !ok {
+					seen[rule] = f.ID
+					buf.WriteString(rule)
+					buf.WriteByte('\n')
+				} else {
+					stats.Duplicates++
+					if id != f.ID {
+						k := ruleHitKey{filterID: id, rule: rule}
+						owners[k] = append(owners[k], f.ID)
+					}
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		_ = file.Close()
+		result = append(result, Filter{ID: f.ID, Data: buf.Bytes()})
+	}
+	return result, owners, stats, nil
+}
+
+// DedupStats - get the results of the last deduplication of the blocklists and the whitelists
+func (d *Dnsfilter) DedupStats() (block, allow DedupStats) {
+	d.dedup.lock.Lock()
+	defer d.dedup.lock.Unlock()
+	return d.dedup.block, d.dedup.allow
+}
+
+// dedupResult - the result of the deduplication that is applied with the new filtering engine
+type dedupResult struct {
+	owners map[ruleHitKey][]int64
+	block  DedupStats
+	allow  DedupStats
+}
+
+// dedupAll - remove the duplicate rules from the blocklists and the whitelists if it's enabled
+func (d *Dnsfilter) dedupAll(allowFilters, blockFilters []Filter) ([]Filter, []Filter, dedupResult, error) {
+	res := dedupResult{}
+	if !d.Config.DeduplicateRules {
+		return allowFilters, blockFilters, res, nil
+	}
+
+	var err error
+	var allowOwners map[ruleHitKey][]int64
+	blockFilters, res.owners, res.block, err = dedupFilters(blockFilters)
+	if err != nil {
+		return nil, nil, res, err
+	}
+	allowFilters, allowOwners, res.allow, err = dedupFilters(allowFilters)
+	if err != nil {
+		return nil, nil, res, err
+	}
+	for k, v := range allowOwners {
+		res.owners[k] = append(res.owners[k], v...)
+	}
+	return allowFilters, blockFilters, res, nil
+}
+
+// setDedupResult - use the result of the deduplication for the statistics and the hit counters
+func (d *Dnsfilter) setDedupResult(res dedupResult) {
+	d.dedup.lock.Lock()
+	d.dedup.block = res.block
+	d.dedup.allow = res.allow
+	d.dedup.lock.Unlock()
+	d.ruleHits.setOwners(res.owners)
+}
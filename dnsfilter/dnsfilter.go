@@ -10,6 +10,8 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/dnsproxy/upstream"
@@ -39,6 +41,12 @@ type RequestFilteringSettings struct {
 	ClientTags []string
 
 	ServicesRules []ServiceEntry
+
+	EncryptedDNSCheck bool // false: the client is exempt from encrypted DNS bypass protection
+
+	InternetOff bool // all requests are blocked by the "internet off" switch
+
+	FilterProfile string // the name of the filter profile used instead of all filter lists ("": all lists)
 }
 
 // Config allows you to configure DNS filtering with New() or just change variables directly.
@@ -48,6 +56,12 @@ type Config struct {
 	SafeBrowsingEnabled bool   `yaml:"safebrowsing_enabled"`
 	ResolverAddress     string `yaml:"-"` // DNS server address
 
+	// Upstream servers for Safe Browsing and Parental Control hash checks ("": AdGuard servers)
+	// They may point to a self-hosted hash server, e.g. "udp://192.168.1.2:5354"
+	SafeBrowsingServer string   `yaml:"safebrowsing_server"`
+	ParentalServer     string   `yaml:"parental_server"`
+	SecurityBootstrap  []string `yaml:"-"` // bootstrap DNS servers for the custom servers
+
 	SafeBrowsingCacheSize uint `yaml:"safebrowsing_cache_size"` // (in bytes)
 	SafeSearchCacheSize   uint `yaml:"safesearch_cache_size"`   // (in bytes)
 	ParentalCacheSize     uint `yaml:"parental_cache_size"`     // (in bytes)
@@ -59,6 +73,15 @@ type Config struct {
 	// Per-client settings can override this configuration.
 	BlockedServices []string `yaml:"blocked_services"`
 
+	// Remove the rules that are contained in several filter lists before building the filtering engine
+	// The lists are kept in memory instead of being read from the files.
+	DeduplicateRules bool `yaml:"filters_deduplicate"`
+
+	// Encrypted DNS bypass protection: "off" (or empty), "detect" or "block"
+	EncryptedDNSMode  string   `yaml:"encrypted_dns_mode"`
+	EncryptedDNSHosts []string `yaml:"encrypted_dns_hosts"` // additional host names and IP addresses of DoH/DoT servers
+	EncryptedDNSFeeds []string `yaml:"encrypted_dns_feeds"` // URLs of the lists of DoH/DoT servers
+
 	// IP-hostname pairs taken from system configuration (e.g. /etc/hosts) files
 	AutoHosts *util.AutoHosts `yaml:"-"`
 
@@ -88,15 +111,25 @@ type Stats struct {
 type filtersInitializerParams struct {
 	allowFilters []Filter
 	blockFilters []Filter
+	profiles     []FilterProfile
+}
+
+// FilterProfile - the set of filter lists used for the requests of some clients instead of all filter lists
+type FilterProfile struct {
+	Name    string
+	Filters []Filter
+}
+
+// filterProfileEngine - the filtering engine of the filter profile
+type filterProfileEngine struct {
+	rulesStorage    *filterlist.RuleStorage
+	filteringEngine *urlfilter.DNSEngine
 }
 
 // Dnsfilter holds added rules and performs hostname matches against the rules
 type Dnsfilter struct {
-	rulesStorage         *filterlist.RuleStorage
-	filteringEngine      *urlfilter.DNSEngine
-	rulesStorageWhite    *filterlist.RuleStorage
-	filteringEngineWhite *urlfilter.DNSEngine
-	engineLock           sync.RWMutex
+	engines     atomic.Value // *filteringEngines: the current filtering engines
+	enginesLock sync.Mutex   // serialize the updates of the engines
 
 	parentalServer       string // access via methods
 	safeBrowsingServer   string // access via methods
@@ -109,6 +142,14 @@ type Dnsfilter struct {
 	// Channel for passing data to filters-initializer goroutine
 	filtersInitializerChan chan filtersInitializerParams
 	filtersInitializerLock sync.Mutex
+
+	encDNS encryptedDNS // DoH/DoT servers list
+
+	ruleHits ruleHits // the hit counters of the filtering rules
+
+	dedup ruleDedup // the results of the deduplication of the rules
+
+	ruleProfileRunning int32 // 1: the rules are being profiled
 }
 
 // Filter represents a filter list
@@ -151,6 +192,12 @@ const (
 
 	// RewriteEtcHosts - rewrite by /etc/hosts rule
 	RewriteEtcHosts
+
+	// FilteredEncryptedDNS - the host is a DoH/DoT server or a canary domain
+	FilteredEncryptedDNS
+
+	// FilteredInternetOff - the client's internet access is turned off
+	FilteredInternetOff
 )
 
 var reasonNames = []string{
@@ -167,6 +214,10 @@ var reasonNames = []string{
 
 	"Rewrite",
 	"RewriteEtcHosts",
+
+	"FilteredEncryptedDNS",
+
+	"FilteredInternetOff",
 }
 
 func (r Reason) String() string {
@@ -183,6 +234,7 @@ func (d *Dnsfilter) GetConfig() RequestFilteringSettings {
 	c.SafeSearchEnabled = d.Config.SafeSearchEnabled
 	c.SafeBrowsingEnabled = d.Config.SafeBrowsingEnabled
 	c.ParentalEnabled = d.Config.ParentalEnabled
+	c.EncryptedDNSCheck = true
 	// d.confLock.RUnlock()
 	return c
 }
@@ -192,6 +244,8 @@ func (d *Dnsfilter) WriteDiskConfig(c *Config) {
 	d.confLock.Lock()
 	*c = d.Config
 	c.Rewrites = rewriteArrayDup(d.Config.Rewrites)
+	c.EncryptedDNSHosts = append([]string{}, d.Config.EncryptedDNSHosts...)
+	c.EncryptedDNSFeeds = append([]string{}, d.Config.EncryptedDNSFeeds...)
 	// BlockedServices
 	d.confLock.Unlock()
 }
@@ -199,11 +253,14 @@ func (d *Dnsfilter) WriteDiskConfig(c *Config) {
 // SetFilters - set new filters (synchronously or asynchronously)
 // When filters are set asynchronously, the old filters continue working until the new filters are ready.
 //  In this case the caller must ensure that the old filter files are intact.
-func (d *Dnsfilter) SetFilters(blockFilters []Filter, allowFilters []Filter, async bool) error {
+// profiles: the filter lists for the requests with RequestFilteringSettings.FilterProfile set;
+//  a separate engine is created for each profile.
+func (d *Dnsfilter) SetFilters(blockFilters []Filter, allowFilters []Filter, profiles []FilterProfile, async bool) error {
 	if async {
 		params := filtersInitializerParams{
 			allowFilters: allowFilters,
 			blockFilters: blockFilters,
+			profiles:     profiles,
 		}
 
 		d.filtersInitializerLock.Lock() // prevent multiple writers from adding more than 1 task
@@ -223,7 +280,7 @@ func (d *Dnsfilter) SetFilters(blockFilters []Filter, allowFilters []Filter, asy
 		return nil
 	}
 
-	err := d.initFiltering(allowFilters, blockFilters)
+	err := d.initFiltering(allowFilters, blockFilters, profiles)
 	if err != nil {
 		log.Error("Can't initialize filtering subsystem: %s", err)
 		return err
@@ -236,7 +293,7 @@ func (d *Dnsfilter) SetFilters(blockFilters []Filter, allowFilters []Filter, asy
 func (d *Dnsfilter) filtersInitializer() {
 	for {
 		params := <-d.filtersInitializerChan
-		err := d.initFiltering(params.allowFilters, params.blockFilters)
+		err := d.initFiltering(params.allowFilters, params.blockFilters, params.profiles)
 		if err != nil {
 			log.Error("Can't initialize filtering subsystem: %s", err)
 			continue
@@ -246,18 +303,7 @@ func (d *Dnsfilter) filtersInitializer() {
 
 // Close - close the object
 func (d *Dnsfilter) Close() {
-	d.engineLock.Lock()
-	defer d.engineLock.Unlock()
-	d.reset()
-}
-
-func (d *Dnsfilter) reset() {
-	if d.rulesStorage != nil {
-		_ = d.rulesStorage.Close()
-	}
-	if d.rulesStorageWhite != nil {
-		d.rulesStorageWhite.Close()
-	}
+	d.swapEngines(nil)
 }
 
 type dnsFilterContext struct {
@@ -295,8 +341,15 @@ func (r Reason) Matched() bool {
 	return r != NotFilteredNotFound
 }
 
-// CheckHostRules tries to match the host against filtering rules only
+// CheckHostRules tries to match the host against filtering rules and the list of DoH/DoT servers only
 func (d *Dnsfilter) CheckHostRules(host string, qtype uint16, setts *RequestFilteringSettings) (Result, error) {
+	if setts.EncryptedDNSCheck {
+		res := d.checkEncryptedDNS(host, setts)
+		if res.IsFiltered {
+			return res, nil
+		}
+	}
+
 	if !setts.FilteringEnabled {
 		return Result{}, nil
 	}
@@ -313,6 +366,10 @@ func (d *Dnsfilter) CheckHost(host string, qtype uint16, setts *RequestFiltering
 	}
 	host = strings.ToLower(host)
 
+	if setts.InternetOff {
+		return Result{IsFiltered: true, Reason: FilteredInternetOff}, nil
+	}
+
 	var result Result
 	var err error
 
@@ -355,6 +412,13 @@ func (d *Dnsfilter) CheckHost(host string, qtype uint16, setts *RequestFiltering
 		}
 	}
 
+	if setts.EncryptedDNSCheck {
+		result = d.checkEncryptedDNS(host, setts)
+		if result.Reason.Matched() {
+			return result, nil
+		}
+	}
+
 	if setts.SafeSearchEnabled {
 		result, err = d.checkSafeSearch(host)
 		if err != nil {
@@ -486,9 +550,10 @@ func createFilteringEngine(filters []Filter) (*filterlist.RuleStorage, *urlfilte
 	for _, f := range filters {
 		var list filterlist.RuleList
 
-		if f.ID == 0 {
+		if f.ID == 0 || f.Data != nil {
+			// the user rules or the deduplicated rules of the list
 			list = &filterlist.StringRuleList{
-				ID:             0,
+				ID:             int(f.ID),
 				RulesText:      string(f.Data),
 				IgnoreCosmetic: true,
 			}
@@ -531,36 +596,71 @@ func createFilteringEngine(filters []Filter) (*filterlist.RuleStorage, *urlfilte
 }
 
 // Initialize urlfilter objects
-func (d *Dnsfilter) initFiltering(allowFilters, blockFilters []Filter) error {
-	d.engineLock.Lock()
-	defer d.engineLock.Unlock()
-	d.reset()
+// The new filtering engines are built while the old ones continue processing requests.
+// Then the engines are swapped, and the old ones are closed when the requests using them are finished.
+func (d *Dnsfilter) initFiltering(allowFilters, blockFilters []Filter, profiles []FilterProfile) error {
+	start := time.Now()
+	allowFilters, blockFilters, dedup, err := d.dedupAll(allowFilters, blockFilters)
+	if err != nil {
+		return err
+	}
 	rulesStorage, filteringEngine, err := createFilteringEngine(blockFilters)
 	if err != nil {
 		return err
 	}
 	rulesStorageWhite, filteringEngineWhite, err := createFilteringEngine(allowFilters)
 	if err != nil {
+		_ = rulesStorage.Close()
 		return err
 	}
-	d.rulesStorage = rulesStorage
-	d.filteringEngine = filteringEngine
-	d.rulesStorageWhite = rulesStorageWhite
-	d.filteringEngineWhite = filteringEngineWhite
+	profileEngines, err := createProfileEngines(profiles)
+	if err != nil {
+		_ = rulesStorage.Close()
+		_ = rulesStorageWhite.Close()
+		return err
+	}
+
+	d.swapEngines(&filteringEngines{
+		rulesStorage:         rulesStorage,
+		filteringEngine:      filteringEngine,
+		rulesStorageWhite:    rulesStorageWhite,
+		filteringEngineWhite: filteringEngineWhite,
+		profileEngines:       profileEngines,
+	})
+	d.setDedupResult(dedup)
 
 	// Make sure that the OS reclaims memory as soon as possible
 	debug.FreeOSMemory()
-	log.Debug("initialized filtering engine")
+	log.Debug("initialized filtering engine in %v", time.Since(start))
 
 	return nil
 }
 
+// createProfileEngines - create the filtering engines of the filter profiles
+func createProfileEngines(profiles []FilterProfile) (map[string]filterProfileEngine, error) {
+	engines := map[string]filterProfileEngine{}
+	for _, p := range profiles {
+		rulesStorage, filteringEngine, err := createFilteringEngine(p.Filters)
+		if err != nil {
+			for _, e := range engines {
+				_ = e.rulesStorage.Close()
+			}
+			return nil, fmt.Errorf("filter profile %s: %s", p.Name, err)
+		}
+		engines[p.Name] = filterProfileEngine{rulesStorage: rulesStorage, filteringEngine: filteringEngine}
+	}
+	return engines, nil
+}
+
 // matchHost is a low-level way to check only if hostname is filtered by rules, skipping expensive safebrowsing and parental lookups
 func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringSettings) (Result, error) {
-	d.engineLock.RLock()
-	// Keep in mind that this lock must be held no just when calling Match()
+	e := d.acquireEngines()
+	if e == nil {
+		return Result{}, nil
+	}
+	// Keep in mind that the engines must be referenced not just when calling Match()
 	//  but also while using the rules returned by it.
-	defer d.engineLock.RUnlock()
+	defer e.release()
 
 	ureq := urlfilter.DNSRequest{}
 	ureq.Hostname = host
@@ -568,8 +668,8 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringS
 	ureq.ClientName = setts.ClientName
 	ureq.SortedClientTags = setts.ClientTags
 
-	if d.filteringEngineWhite != nil {
-		rr, ok := d.filteringEngineWhite.MatchRequest(ureq)
+	if e.filteringEngineWhite != nil {
+		rr, ok := e.filteringEngineWhite.MatchRequest(ureq)
 		if ok {
 			var rule rules.Rule
 			if rr.NetworkRule != nil {
@@ -587,11 +687,18 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringS
 		}
 	}
 
-	if d.filteringEngine == nil {
+	engine := e.filteringEngine
+	if len(setts.FilterProfile) != 0 {
+		// the profile that isn't ready yet is replaced with all filter lists
+		if p, ok := e.profileEngines[setts.FilterProfile]; ok {
+			engine = p.filteringEngine
+		}
+	}
+	if engine == nil {
 		return Result{}, nil
 	}
 
-	rr, ok := d.filteringEngine.MatchRequest(ureq)
+	rr, ok := engine.MatchRequest(ureq)
 	if !ok {
 		return Result{}, nil
 	}
@@ -689,16 +796,17 @@ func New(c *Config, blockFilters []Filter) *Dnsfilter {
 
 	d := new(Dnsfilter)
 
+	if c != nil {
+		d.Config = *c
+		d.prepareRewrites()
+	}
+
 	err := d.initSecurityServices()
 	if err != nil {
 		log.Error("dnsfilter: initialize services: %s", err)
 		return nil
 	}
-
-	if c != nil {
-		d.Config = *c
-		d.prepareRewrites()
-	}
+	d.prepareEncryptedDNS()
 
 	bsvcs := []string{}
 	for _, s := range d.BlockedServices {
@@ -711,7 +819,7 @@ func New(c *Config, blockFilters []Filter) *Dnsfilter {
 	d.BlockedServices = bsvcs
 
 	if blockFilters != nil {
-		err := d.initFiltering(nil, blockFilters)
+		err := d.initFiltering(nil, blockFilters, nil)
 		if err != nil {
 			log.Error("Can't initialize filtering subsystem: %s", err)
 			d.Close()
@@ -733,6 +841,8 @@ func (d *Dnsfilter) Start() {
 		d.registerSecurityHandlers()
 		d.registerRewritesHandlers()
 		d.registerBlockedServicesHandlers()
+		d.registerRuleHitsHandlers()
+		d.registerRuleProfileHandlers()
 	}
 }
 
@@ -2,10 +2,13 @@ package dnsfilter
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"path"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/AdguardTeam/urlfilter/rules"
@@ -447,7 +450,7 @@ func TestWhitelist(t *testing.T) {
 		ID: 0, Data: []byte(whiteRules),
 	}}
 	d := NewForTest(nil, filters)
-	d.SetFilters(filters, whiteFilters, false)
+	d.SetFilters(filters, whiteFilters, nil, false)
 	defer d.Close()
 
 	// matched by white filter
@@ -464,6 +467,101 @@ func TestWhitelist(t *testing.T) {
 
 }
 
+// Requests are processed while the filters are being rebuilt
+func TestSetFiltersConcurrent(t *testing.T) {
+	filters := []Filter{Filter{
+		ID: 0, Data: []byte("||host1^\n"),
+	}}
+	d := NewForTest(nil, filters)
+	defer d.Close()
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ret, err := d.CheckHost("host1", dns.TypeA, &setts)
+			assert.Nil(t, err)
+			assert.True(t, ret.IsFiltered)
+		}
+	}()
+
+	for i := 0; i != 50; i++ {
+		data := fmt.Sprintf("||host1^\n||host%d.example^\n", i)
+		err := d.SetFilters([]Filter{Filter{ID: 0, Data: []byte(data)}}, nil, nil, false)
+		assert.Nil(t, err)
+	}
+	close(stop)
+	<-done
+
+	d.checkMatch(t, "host49.example")
+	d.checkMatchEmpty(t, "host48.example")
+}
+
+func TestEnginesRetire(t *testing.T) {
+	d := NewForTest(nil, []Filter{{ID: 0, Data: []byte("||host1^\n")}})
+
+	// the old engines are used by a request while the new ones are set
+	old := d.acquireEngines()
+	err := d.SetFilters([]Filter{{ID: 0, Data: []byte("||host2^\n")}}, nil, nil, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&old.retired))
+	_, ok := old.filteringEngine.Match("host1")
+	assert.True(t, ok)
+	old.release()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&old.refs))
+
+	d.checkMatch(t, "host2")
+	d.checkMatchEmpty(t, "host1")
+
+	e := d.acquireEngines()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&e.refs))
+	e.release()
+
+	d.Close()
+	assert.Nil(t, d.acquireEngines())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&e.retired))
+}
+
+// The requests with a filter profile are matched against the filter lists of the profile
+func TestFilterProfiles(t *testing.T) {
+	filters := []Filter{{ID: 0, Data: []byte("||base.example^\n")}}
+	profiles := []FilterProfile{
+		{Name: "kids", Filters: []Filter{{ID: 0, Data: []byte("||base.example^\n||strict.example^\n")}}},
+		{Name: "admin", Filters: []Filter{{ID: 0, Data: []byte("")}}},
+	}
+	d := NewForTest(nil, filters)
+	defer d.Close()
+	assert.Nil(t, d.SetFilters(filters, nil, profiles, false))
+
+	s := setts
+	s.FilterProfile = "kids"
+	ret, err := d.CheckHost("strict.example", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.True(t, ret.IsFiltered)
+
+	s.FilterProfile = "admin"
+	ret, err = d.CheckHost("base.example", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.False(t, ret.IsFiltered)
+
+	// unknown profile: all filter lists
+	s.FilterProfile = "unknown"
+	ret, err = d.CheckHost("base.example", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.True(t, ret.IsFiltered)
+
+	s.FilterProfile = ""
+	ret, err = d.CheckHost("strict.example", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.False(t, ret.IsFiltered)
+}
+
 // CLIENT SETTINGS
 
 func applyClientSettings(setts *RequestFilteringSettings) {
@@ -608,3 +706,89 @@ func BenchmarkSafeSearchParallel(b *testing.B) {
 		}
 	})
 }
+
+func TestRuleHits(t *testing.T) {
+	d := NewForTest(nil, nil)
+	defer d.Close()
+
+	d.CountRuleHit(Result{})
+	d.CountRuleHit(Result{IsFiltered: true, Reason: FilteredBlackList, Rule: "||a.example^", FilterID: 1})
+	d.CountRuleHit(Result{IsFiltered: true, Reason: FilteredBlackList, Rule: "||b.example^", FilterID: 1})
+	d.CountRuleHit(Result{IsFiltered: true, Reason: FilteredBlackList, Rule: "||b.example^", FilterID: 1})
+	d.CountRuleHit(Result{Reason: NotFilteredWhiteList, Rule: "@@||c.example^", FilterID: 0})
+
+	list := d.TopRules(-1, 0)
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, "||b.example^", list[0].Rule)
+	assert.Equal(t, uint64(2), list[0].Hits)
+	assert.Equal(t, "FilteredBlackList", list[0].Reason)
+	// the same number of hits: the latest hit goes first
+	assert.Equal(t, "@@||c.example^", list[1].Rule)
+
+	list = d.TopRules(1, 1)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "||b.example^", list[0].Rule)
+
+	filters := d.FilterHits()
+	assert.Equal(t, []FilterHitsJSON{{FilterID: 1, Hits: 3}, {FilterID: 0, Hits: 1}}, filters)
+
+	d.ResetRuleHits()
+	assert.Equal(t, 0, len(d.TopRules(-1, 0)))
+	assert.Equal(t, 0, len(d.FilterHits()))
+}
+
+func TestDedupRules(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn1 := dir + "/1.txt"
+	fn2 := dir + "/2.txt"
+	_ = ioutil.WriteFile(fn1, []byte("! Title: 1\n||a.example^\n||b.example^\n||b.example^\n"), 0644)
+	_ = ioutil.WriteFile(fn2, []byte("! Title: 2\n||b.example^\n||c.example^\n"), 0644)
+
+	filters := []Filter{{ID: 1, FilePath: fn1}, {ID: 2, FilePath: fn2}}
+	d := NewForTest(&Config{DeduplicateRules: true}, filters)
+	defer d.Close()
+
+	block, allow := d.DedupStats()
+	assert.Equal(t, DedupStats{Rules: 5, Duplicates: 2}, block)
+	assert.Equal(t, DedupStats{}, allow)
+
+	res, err := d.CheckHost("b.example", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, int64(1), res.FilterID)
+	res, _ = d.CheckHost("c.example", dns.TypeA, &setts)
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, int64(2), res.FilterID)
+
+	// the hit is counted for both lists
+	d.CountRuleHit(Result{IsFiltered: true, Reason: FilteredBlackList, Rule: "||b.example^", FilterID: 1})
+	assert.Equal(t, []FilterHitsJSON{{FilterID: 1, Hits: 1}, {FilterID: 2, Hits: 1}}, d.FilterHits())
+	assert.Equal(t, 1, len(d.TopRules(2, 0)))
+}
+
+func TestRuleProfile(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := dir + "/1.txt"
+	_ = ioutil.WriteFile(fn, []byte(`||ads.example^
+/^([a-z0-9-]+\.){1,10}(ad|ads|banner)[0-9]*\.(com|net|org)$/
+0.0.0.0 host.example
+`), 0644)
+
+	filters := []Filter{{ID: 1, FilePath: fn}}
+	d := NewForTest(&Config{}, filters)
+	defer d.Close()
+
+	resp, err := d.ProfileRules()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, resp.Rules) // host rules are skipped
+	assert.Equal(t, ruleProfileHosts, resp.Hosts)
+	assert.Equal(t, 2, len(resp.Slowest))
+	assert.Equal(t, int64(1), resp.Slowest[0].FilterID)
+	assert.True(t, strings.HasPrefix(resp.Slowest[0].Rule, "/^"))
+	assert.Equal(t, "||ads.example^", resp.Slowest[1].Rule)
+
+	// the host names are the same on each run
+	assert.Equal(t, ruleProfileHostnames(), ruleProfileHostnames())
+}
@@ -0,0 +1,262 @@
+package dnsfilter
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Encrypted DNS bypass protection modes
+const (
+	EncryptedDNSOff    = "off"
+	EncryptedDNSDetect = "detect" // the requests for DoH/DoT servers are logged, but not blocked
+	EncryptedDNSBlock  = "block"  // the requests for DoH/DoT servers are blocked
+)
+
+// Canary domains: if they aren't resolved, the clients don't enable encrypted DNS automatically
+//  use-application-dns.net: Firefox
+//  mask.icloud.com, mask-h2.icloud.com: iCloud Private Relay
+var encryptedDNSCanaries = []string{
+	"use-application-dns.net",
+	"mask.icloud.com",
+	"mask-h2.icloud.com",
+}
+
+// Well-known public DoH/DoT servers (the subdomains are matched too)
+var encryptedDNSDefaultHosts = []string{
+	"dns.google",
+	"dns.google.com",
+	"8888.google",
+	"cloudflare-dns.com",
+	"one.one.one.one",
+	"dns.quad9.net",
+	"dns9.quad9.net",
+	"dns10.quad9.net",
+	"dns11.quad9.net",
+	"dns12.quad9.net",
+	"doh.opendns.com",
+	"doh.familyshield.opendns.com",
+	"dns.adguard.com",
+	"dns-family.adguard.com",
+	"dns-unfiltered.adguard.com",
+	"dns.adguard-dns.com",
+	"doh.cleanbrowsing.org",
+	"dns.nextdns.io",
+	"doh.dns.sb",
+	"doh.mullvad.net",
+	"dns.controld.com",
+	"freedns.controld.com",
+	"doh.xfinity.com",
+	"doh.libredns.gr",
+	"doh.applied-privacy.net",
+	"dns.switch.ch",
+	"odvr.nic.cz",
+	"dns0.eu",
+}
+
+// IP addresses of well-known public DoH/DoT servers: the responses containing them are blocked
+var encryptedDNSDefaultIPs = []string{
+	"1.1.1.1",
+	"1.0.0.1",
+	"2606:4700:4700::1111",
+	"2606:4700:4700::1001",
+	"8.8.8.8",
+	"8.8.4.4",
+	"2001:4860:4860::8888",
+	"2001:4860:4860::8844",
+	"9.9.9.9",
+	"149.112.112.112",
+	"2620:fe::fe",
+	"2620:fe::9",
+	"94.140.14.14",
+	"94.140.15.15",
+	"208.67.222.222",
+	"208.67.220.220",
+}
+
+// The maximum number of clients in the detections list
+const encryptedDNSMaxDetections = 1000
+
+// EncryptedDNSDetection - the requests of the client for DoH/DoT servers
+type EncryptedDNSDetection struct {
+	ClientIP   string    `json:"client_ip"`
+	ClientName string    `json:"client_name,omitempty"`
+	Host       string    `json:"host"` // the last requested host name or IP address
+	Count      uint64    `json:"count"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// encryptedDNS - the list of DoH/DoT servers and the detected clients
+type encryptedDNS struct {
+	lock        sync.RWMutex
+	hosts       map[string]bool
+	ips         map[string]bool
+	feedEntries []string // the entries from the feeds
+
+	detectionsLock sync.Mutex
+	detections     map[string]*EncryptedDNSDetection // client IP -> detection
+}
+
+// prepareEncryptedDNS - build the list from the built-in entries, the custom entries and the feeds
+func (d *Dnsfilter) prepareEncryptedDNS() {
+	d.confLock.RLock()
+	custom := d.Config.EncryptedDNSHosts
+	d.confLock.RUnlock()
+
+	e := &d.encDNS
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.hosts = map[string]bool{}
+	e.ips = map[string]bool{}
+	lists := [][]string{encryptedDNSDefaultHosts, encryptedDNSDefaultIPs, custom, e.feedEntries}
+	for _, list := range lists {
+		for _, s := range list {
+			s = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), "."))
+			if len(s) == 0 {
+				continue
+			}
+			ip := net.ParseIP(s)
+			if ip != nil {
+				e.ips[ip.String()] = true
+			} else {
+				e.hosts[s] = true
+			}
+		}
+	}
+	log.Debug("Encrypted DNS: %d host names, %d IP addresses", len(e.hosts), len(e.ips))
+}
+
+// SetEncryptedDNSFeedEntries - set the host names and IP addresses of DoH/DoT servers received from the feeds
+func (d *Dnsfilter) SetEncryptedDNSFeedEntries(entries []string) {
+	d.encDNS.lock.Lock()
+	d.encDNS.feedEntries = entries
+	d.encDNS.lock.Unlock()
+	d.prepareEncryptedDNS()
+}
+
+// EncryptedDNSListSize - get the number of host names and IP addresses in the list
+func (d *Dnsfilter) EncryptedDNSListSize() int {
+	d.encDNS.lock.RLock()
+	defer d.encDNS.lock.RUnlock()
+	return len(d.encDNS.hosts) + len(d.encDNS.ips)
+}
+
+// matchEncryptedDNS - get the list entry matching the host name (or its parent domain) or IP address
+func (d *Dnsfilter) matchEncryptedDNS(host string) (string, bool) {
+	e := &d.encDNS
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	ip := net.ParseIP(host)
+	if ip != nil {
+		s := ip.String()
+		return s, e.ips[s]
+	}
+	for {
+		if e.hosts[host] {
+			return host, true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return "", false
+		}
+		host = host[i+1:]
+	}
+}
+
+func isEncryptedDNSCanary(host string) bool {
+	for _, c := range encryptedDNSCanaries {
+		if host == c {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEncryptedDNS - check the host name or IP address
+// The canary domains are blocked in both modes.
+func (d *Dnsfilter) checkEncryptedDNS(host string, setts *RequestFilteringSettings) Result {
+	d.confLock.RLock()
+	mode := d.Config.EncryptedDNSMode
+	d.confLock.RUnlock()
+	if mode != EncryptedDNSDetect && mode != EncryptedDNSBlock {
+		return Result{}
+	}
+
+	if isEncryptedDNSCanary(host) {
+		return Result{IsFiltered: true, Reason: FilteredEncryptedDNS, Rule: host}
+	}
+
+	entry, ok := d.matchEncryptedDNS(host)
+	if !ok {
+		return Result{}
+	}
+	d.addEncryptedDNSDetection(setts, host)
+	if mode != EncryptedDNSBlock {
+		log.Debug("Encrypted DNS: %s: detected request for %s", setts.ClientIP, host)
+		return Result{}
+	}
+	log.Debug("Encrypted DNS: %s: blocked request for %s", setts.ClientIP, host)
+	return Result{IsFiltered: true, Reason: FilteredEncryptedDNS, Rule: entry}
+}
+
+func (d *Dnsfilter) addEncryptedDNSDetection(setts *RequestFilteringSettings, host string) {
+	if len(setts.ClientIP) == 0 {
+		return
+	}
+	e := &d.encDNS
+	e.detectionsLock.Lock()
+	defer e.detectionsLock.Unlock()
+	if e.detections == nil {
+		e.detections = map[string]*EncryptedDNSDetection{}
+	}
+	det, ok := e.detections[setts.ClientIP]
+	if !ok {
+		if len(e.detections) >= encryptedDNSMaxDetections {
+			return
+		}
+		det = &EncryptedDNSDetection{ClientIP: setts.ClientIP}
+		e.detections[setts.ClientIP] = det
+	}
+	det.ClientName = setts.ClientName
+	det.Host = host
+	det.Count++
+	det.LastSeen = time.Now()
+}
+
+// EncryptedDNSDetections - get the clients that have requested DoH/DoT servers (the most recent first)
+func (d *Dnsfilter) EncryptedDNSDetections() []EncryptedDNSDetection {
+	e := &d.encDNS
+	e.detectionsLock.Lock()
+	list := []EncryptedDNSDetection{}
+	for _, det := range e.detections {
+		list = append(list, *det)
+	}
+	e.detectionsLock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+// ClearEncryptedDNSDetections - clear the detections list
+func (d *Dnsfilter) ClearEncryptedDNSDetections() {
+	d.encDNS.detectionsLock.Lock()
+	d.encDNS.detections = nil
+	d.encDNS.detectionsLock.Unlock()
+}
+
+// SetEncryptedDNSConfig - set the mode, the custom entries and the feeds
+func (d *Dnsfilter) SetEncryptedDNSConfig(mode string, hosts, feeds []string) {
+	d.confLock.Lock()
+	d.Config.EncryptedDNSMode = mode
+	d.Config.EncryptedDNSHosts = hosts
+	d.Config.EncryptedDNSFeeds = feeds
+	d.confLock.Unlock()
+	d.prepareEncryptedDNS()
+}
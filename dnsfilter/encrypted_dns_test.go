@@ -0,0 +1,71 @@
+package dnsfilter
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedDNS(t *testing.T) {
+	d := Dnsfilter{}
+	d.EncryptedDNSHosts = []string{"doh.example.org", "192.0.2.53"}
+	d.prepareEncryptedDNS()
+	setts := &RequestFilteringSettings{EncryptedDNSCheck: true, ClientIP: "192.168.1.2"}
+
+	// disabled
+	r, _ := d.CheckHost("dns.google", dns.TypeA, setts)
+	assert.False(t, r.Reason.Matched())
+	assert.Equal(t, 0, len(d.EncryptedDNSDetections()))
+
+	// detect mode: the canary domain is blocked, DoH server is only logged
+	d.EncryptedDNSMode = EncryptedDNSDetect
+	r, _ = d.CheckHost("use-application-dns.net", dns.TypeA, setts)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, FilteredEncryptedDNS, r.Reason)
+	r, _ = d.CheckHost("dns.google", dns.TypeA, setts)
+	assert.False(t, r.IsFiltered)
+	det := d.EncryptedDNSDetections()
+	assert.Equal(t, 1, len(det))
+	assert.Equal(t, "192.168.1.2", det[0].ClientIP)
+	assert.Equal(t, "dns.google", det[0].Host)
+	assert.Equal(t, uint64(1), det[0].Count)
+
+	// block mode: the subdomains and the custom entries are matched
+	d.EncryptedDNSMode = EncryptedDNSBlock
+	r, _ = d.CheckHost("mozilla.cloudflare-dns.com", dns.TypeA, setts)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, FilteredEncryptedDNS, r.Reason)
+	assert.Equal(t, "cloudflare-dns.com", r.Rule)
+	r, _ = d.CheckHost("doh.example.org", dns.TypeAAAA, setts)
+	assert.True(t, r.IsFiltered)
+	r, _ = d.CheckHost("example.org", dns.TypeA, setts)
+	assert.False(t, r.IsFiltered)
+	det = d.EncryptedDNSDetections()
+	assert.Equal(t, uint64(3), det[0].Count)
+	assert.Equal(t, "doh.example.org", det[0].Host)
+
+	// IP address in the response
+	r, _ = d.CheckHostRules("192.0.2.53", dns.TypeA, setts)
+	assert.True(t, r.IsFiltered)
+	r, _ = d.CheckHostRules("2606:4700:4700:0::1111", dns.TypeAAAA, setts)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, "2606:4700:4700::1111", r.Rule)
+	r, _ = d.CheckHostRules("192.0.2.54", dns.TypeA, setts)
+	assert.False(t, r.IsFiltered)
+
+	// feeds
+	d.SetEncryptedDNSFeedEntries([]string{"doh.feed.example"})
+	r, _ = d.CheckHost("doh.feed.example", dns.TypeA, setts)
+	assert.True(t, r.IsFiltered)
+
+	// exempt client
+	setts.EncryptedDNSCheck = false
+	r, _ = d.CheckHost("dns.google", dns.TypeA, setts)
+	assert.False(t, r.IsFiltered)
+	r, _ = d.CheckHost("use-application-dns.net", dns.TypeA, setts)
+	assert.False(t, r.IsFiltered)
+
+	d.ClearEncryptedDNSDetections()
+	assert.Equal(t, 0, len(d.EncryptedDNSDetections()))
+}
@@ -0,0 +1,84 @@
+package dnsfilter
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+)
+
+// filteringEngines - the immutable set of the filtering engines built from the filter lists
+// A new set is built in background on each update, then the pointer to the current set is swapped.
+// The requests don't take any lock:  the set is referenced while the rules returned by its engines are used,
+// and the old set is closed when it's replaced and the last request using it is finished.
+type filteringEngines struct {
+	rulesStorage         *filterlist.RuleStorage
+	filteringEngine      *urlfilter.DNSEngine
+	rulesStorageWhite    *filterlist.RuleStorage
+	filteringEngineWhite *urlfilter.DNSEngine
+	profileEngines       map[string]filterProfileEngine // filter profile name -> engine
+
+	refs      int32 // the number of the requests using the engines
+	retired   int32 // 1: the set has been replaced with a new one
+	closeOnce sync.Once
+}
+
+// close - close the rule storages
+func (e *filteringEngines) close() {
+	e.closeOnce.Do(func() {
+		if e.rulesStorage != nil {
+			_ = e.rulesStorage.Close()
+		}
+		if e.rulesStorageWhite != nil {
+			_ = e.rulesStorageWhite.Close()
+		}
+		for _, p := range e.profileEngines {
+			_ = p.rulesStorage.Close()
+		}
+	})
+}
+
+// release - finish using the engines
+func (e *filteringEngines) release() {
+	if atomic.AddInt32(&e.refs, -1) == 0 && atomic.LoadInt32(&e.retired) == 1 {
+		e.close()
+	}
+}
+
+// retire - close the engines when nobody uses them
+func (e *filteringEngines) retire() {
+	atomic.StoreInt32(&e.retired, 1)
+	if atomic.LoadInt32(&e.refs) == 0 {
+		e.close()
+	}
+}
+
+// acquireEngines - get the current set of the filtering engines
+// The caller must call release() when it's done with the rules returned by the engines.
+// Return nil if the filtering engines haven't been created.
+func (d *Dnsfilter) acquireEngines() *filteringEngines {
+	for {
+		e, _ := d.engines.Load().(*filteringEngines)
+		if e == nil {
+			return nil
+		}
+		atomic.AddInt32(&e.refs, 1)
+		if cur, _ := d.engines.Load().(*filteringEngines); cur == e {
+			return e
+		}
+		// the set has been replaced while we were referencing it
+		e.release()
+	}
+}
+
+// swapEngines - use the new set of the filtering engines and retire the old one
+func (d *Dnsfilter) swapEngines(e *filteringEngines) {
+	d.enginesLock.Lock()
+	old, _ := d.engines.Load().(*filteringEngines)
+	d.engines.Store(e)
+	d.enginesLock.Unlock()
+	if old != nil {
+		old.retire()
+	}
+}
@@ -4,11 +4,13 @@ package dnsfilter
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"sort"
 	"strings"
 
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/miekg/dns"
 )
@@ -95,8 +97,42 @@ func (r *RewriteEntry) prepare() {
 	}
 }
 
+// normalize - convert the domain name and the canonical name to the form they have in DNS requests
+// (lower case, punycode), otherwise the internationalized names never match
+func (r *RewriteEntry) normalize() error {
+	domain, err := util.NormalizeDomain(r.Domain)
+	if err != nil {
+		return err
+	}
+	answer := strings.TrimSpace(r.Answer)
+	if answer != "A" && answer != "AAAA" && net.ParseIP(answer) == nil {
+		answer, err = util.NormalizeDomain(answer)
+		if err != nil {
+			return fmt.Errorf("answer: %s", err)
+		}
+	}
+	r.Domain = domain
+	r.Answer = answer
+	return nil
+}
+
+// ValidateRewrites - check the domain names and the answers of the rewrite entries
+func ValidateRewrites(list []RewriteEntry) error {
+	for _, r := range list {
+		err := r.normalize()
+		if err != nil {
+			return fmt.Errorf("%s: %s", r.Domain, err)
+		}
+	}
+	return nil
+}
+
 func (d *Dnsfilter) prepareRewrites() {
 	for i := range d.Rewrites {
+		err := d.Rewrites[i].normalize()
+		if err != nil {
+			log.Debug("Rewrites: %s: %s", d.Rewrites[i].Domain, err)
+		}
 		d.Rewrites[i].prepare()
 	}
 }
@@ -183,6 +219,11 @@ func (d *Dnsfilter) handleRewriteAdd(w http.ResponseWriter, r *http.Request) {
 		Domain: jsent.Domain,
 		Answer: jsent.Answer,
 	}
+	err = ent.normalize()
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
 	ent.prepare()
 	d.confLock.Lock()
 	d.Config.Rewrites = append(d.Config.Rewrites, ent)
@@ -206,6 +247,8 @@ func (d *Dnsfilter) handleRewriteDelete(w http.ResponseWriter, r *http.Request)
 		Domain: jsent.Domain,
 		Answer: jsent.Answer,
 	}
+	// the entries with invalid names are deleted as they are
+	_ = entDel.normalize()
 	arr := []RewriteEntry{}
 	d.confLock.Lock()
 	for _, ent := range d.Config.Rewrites {
@@ -213,3 +213,27 @@ func TestRewritesExceptionIP(t *testing.T) {
 	assert.Equal(t, ReasonRewrite, r.Reason)
 	assert.Equal(t, 0, len(r.IPList))
 }
+
+func TestRewritesIDN(t *testing.T) {
+	d := Dnsfilter{}
+	d.Rewrites = []RewriteEntry{
+		{Domain: "Пример.РФ", Answer: "Bücher.example."},
+		{Domain: "bücher.example", Answer: "1.2.3.4"},
+		{Domain: "*.Example.ORG", Answer: "1.2.3.5"},
+	}
+	d.prepareRewrites()
+	assert.Equal(t, "xn--e1afmkfd.xn--p1ai", d.Rewrites[0].Domain)
+	assert.Equal(t, "xn--bcher-kva.example", d.Rewrites[0].Answer)
+
+	r := d.processRewrites("xn--e1afmkfd.xn--p1ai", dns.TypeA)
+	assert.Equal(t, ReasonRewrite, r.Reason)
+	assert.Equal(t, "xn--bcher-kva.example", r.CanonName)
+	assert.Equal(t, 1, len(r.IPList))
+
+	r = d.processRewrites("www.example.org", dns.TypeA)
+	assert.Equal(t, ReasonRewrite, r.Reason)
+
+	assert.Nil(t, ValidateRewrites(d.Rewrites))
+	assert.NotNil(t, ValidateRewrites([]RewriteEntry{{Domain: "bad name.рф", Answer: "1.2.3.4"}}))
+	assert.NotNil(t, ValidateRewrites([]RewriteEntry{{Domain: "example.org", Answer: "bad name"}}))
+}
@@ -0,0 +1,212 @@
+package dnsfilter
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The maximum number of the rules with hit counters
+// The hits of the other rules are counted only in the totals of their filters.
+const ruleHitsMaxRules = 10000
+
+// The default number of the rules in the report
+const ruleHitsDefaultLimit = 100
+
+type ruleHitKey struct {
+	filterID int64
+	rule     string
+}
+
+type ruleHit struct {
+	reason  Reason // the latest result of the rule
+	hits    uint64
+	lastHit time.Time
+}
+
+// ruleHits - the counters of the requests matched by the filtering rules since startup
+type ruleHits struct {
+	lock    sync.Mutex
+	rules   map[ruleHitKey]*ruleHit
+	filters map[int64]uint64 // filter ID -> hits
+
+	// The other filters that contain the rule removed from them by the deduplication
+	owners map[ruleHitKey][]int64
+}
+
+// setOwners - set the other filters that contain the rules
+func (h *ruleHits) setOwners(owners map[ruleHitKey][]int64) {
+	h.lock.Lock()
+	h.owners = owners
+	h.lock.Unlock()
+}
+
+// CountRuleHit - count the request processed with this result
+// Only the results of the filtering rules (blocking, allowing and modifying) are counted.
+func (d *Dnsfilter) CountRuleHit(res Result) {
+	if len(res.Rule) == 0 {
+		return
+	}
+	h := &d.ruleHits
+	key := ruleHitKey{filterID: res.FilterID, rule: res.Rule}
+	now := time.Now()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.rules == nil {
+		h.rules = map[ruleHitKey]*ruleHit{}
+		h.filters = map[int64]uint64{}
+	}
+	h.count(key, res.Reason, now)
+	// the hit is counted for all filters that contain the rule
+	for _, id := range h.owners[key] {
+		h.count(ruleHitKey{filterID: id, rule: res.Rule}, res.Reason, now)
+	}
+}
+
+func (h *ruleHits) count(key ruleHitKey, reason Reason, now time.Time) {
+	h.filters[key.filterID]++
+	e, ok := h.rules[key]
+	if !ok {
+		if len(h.rules) >= ruleHitsMaxRules {
+			return
+		}
+		e = &ruleHit{}
+		h.rules[key] = e
+	}
+	e.reason = reason
+	e.hits++
+	e.lastHit = now
+}
+
+// RuleHitJSON - the hit counter of a filtering rule
+type RuleHitJSON struct {
+	Rule     string    `json:"rule"`
+	FilterID int64     `json:"filter_id"`
+	Reason   string    `json:"reason"`
+	Hits     uint64    `json:"hits"`
+	LastHit  time.Time `json:"last_hit"`
+}
+
+// FilterHitsJSON - the total number of hits of the rules of a filter
+type FilterHitsJSON struct {
+	FilterID int64  `json:"filter_id"`
+	Hits     uint64 `json:"hits"`
+}
+
+// TopRules - get the rules with the most hits (sorted by the number of hits, then by the last hit time)
+// filterID: only the rules of this filter (-1: all filters)
+// limit: the maximum number of the rules (0: all)
+func (d *Dnsfilter) TopRules(filterID int64, limit int) []RuleHitJSON {
+	h := &d.ruleHits
+	h.lock.Lock()
+	list := []RuleHitJSON{}
+	for k, e := range h.rules {
+		if filterID >= 0 && k.filterID != filterID {
+			continue
+		}
+		list = append(list, RuleHitJSON{
+			Rule:     k.rule,
+			FilterID: k.filterID,
+			Reason:   e.reason.String(),
+			Hits:     e.hits,
+			LastHit:  e.lastHit,
+		})
+	}
+	h.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Hits != list[j].Hits {
+			return list[i].Hits > list[j].Hits
+		}
+		if !list[i].LastHit.Equal(list[j].LastHit) {
+			return list[i].LastHit.After(list[j].LastHit)
+		}
+		return list[i].Rule < list[j].Rule
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// FilterHits - get the total number of hits for each filter (sorted by the number of hits)
+func (d *Dnsfilter) FilterHits() []FilterHitsJSON {
+	h := &d.ruleHits
+	h.lock.Lock()
+	list := []FilterHitsJSON{}
+	for id, n := range h.filters {
+		list = append(list, FilterHitsJSON{FilterID: id, Hits: n})
+	}
+	h.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Hits != list[j].Hits {
+			return list[i].Hits > list[j].Hits
+		}
+		return list[i].FilterID < list[j].FilterID
+	})
+	return list
+}
+
+// ResetRuleHits - clear all hit counters
+func (d *Dnsfilter) ResetRuleHits() {
+	h := &d.ruleHits
+	h.lock.Lock()
+	h.rules = nil
+	h.filters = nil
+	h.lock.Unlock()
+}
+
+type topRulesJSON struct {
+	Rules   []RuleHitJSON    `json:"rules"`
+	Filters []FilterHitsJSON `json:"filters"`
+}
+
+// GET /control/filtering/top_rules?limit=100&filter_id=1
+func (d *Dnsfilter) handleTopRules(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := ruleHitsDefaultLimit
+	if s := q.Get("limit"); len(s) != 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			httpError(r, w, http.StatusBadRequest, "invalid limit: %q", s)
+			return
+		}
+		limit = n
+	}
+	filterID := int64(-1)
+	if s := q.Get("filter_id"); len(s) != 0 {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			httpError(r, w, http.StatusBadRequest, "invalid filter_id: %q", s)
+			return
+		}
+		filterID = n
+	}
+
+	resp := topRulesJSON{
+		Rules:   d.TopRules(filterID, limit),
+		Filters: d.FilterHits(),
+	}
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// POST /control/filtering/top_rules/reset
+func (d *Dnsfilter) handleTopRulesReset(w http.ResponseWriter, r *http.Request) {
+	d.ResetRuleHits()
+}
+
+func (d *Dnsfilter) registerRuleHitsHandlers() {
+	d.Config.HTTPRegister("GET", "/control/filtering/top_rules", d.handleTopRules)
+	d.Config.HTTPRegister("POST", "/control/filtering/top_rules/reset", d.handleTopRulesReset)
+}
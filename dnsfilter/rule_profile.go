@@ -0,0 +1,158 @@
+package dnsfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+const (
+	ruleProfileHosts   = 200                  // the number of the host names each rule is matched against
+	ruleProfileLimit   = 20                   // the number of the slowest rules in the report
+	ruleProfileSlowAvg = 5 * time.Microsecond // the rule is slow if its average matching time is longer
+)
+
+// RuleCostJSON - the matching time of a rule
+type RuleCostJSON struct {
+	FilterID int64   `json:"filter_id"`
+	Rule     string  `json:"rule"`
+	AvgNs    float64 `json:"avg_ns"` // the average time of matching a host name
+	Slow     bool    `json:"slow"`
+}
+
+// RuleProfileJSON - the report of the matching time of the filtering rules
+type RuleProfileJSON struct {
+	Rules     int            `json:"rules"` // the number of the network rules checked
+	Hosts     int            `json:"hosts"` // the number of the host names each rule is matched against
+	ElapsedMs float64        `json:"elapsed_ms"`
+	Slowest   []RuleCostJSON `json:"slowest"` // the slowest first
+}
+
+// ruleProfileHostnames - get the host names to match the rules against
+// The names are the same on each run, so the reports can be compared.
+func ruleProfileHostnames() []string {
+	words := []string{"www", "api", "cdn", "static", "ads", "img", "mail", "news", "shop", "login",
+		"example", "tracker", "analytics", "video", "app", "m", "metrics", "media", "s3", "edge"}
+	tlds := []string{"com", "net", "org", "io", "de", "co.uk", "ru", "info"}
+	rnd := rand.New(rand.NewSource(1))
+	hosts := make([]string, 0, ruleProfileHosts)
+	for len(hosts) != ruleProfileHosts {
+		n := 1 + rnd.Intn(4)
+		if len(hosts)%20 == 0 {
+			n = 20 // a long name
+		}
+		labels := make([]string, 0, n+1)
+		for i := 0; i != n; i++ {
+			w := words[rnd.Intn(len(words))]
+			if rnd.Intn(3) == 0 {
+				w += fmt.Sprintf("-%d", rnd.Intn(1000))
+			}
+			labels = append(labels, w)
+		}
+		labels = append(labels, tlds[rnd.Intn(len(tlds))])
+		hosts = append(hosts, strings.Join(labels, "."))
+	}
+	return hosts
+}
+
+// profileStorage - measure the matching time of each network rule of the storage
+func profileStorage(storage *filterlist.RuleStorage, reqs []*rules.Request, costs []RuleCostJSON) []RuleCostJSON {
+	if storage == nil {
+		return costs
+	}
+	scanner := storage.NewRuleStorageScanner()
+	for scanner.Scan() {
+		r, _ := scanner.Rule()
+		nr, ok := r.(*rules.NetworkRule)
+		if !ok {
+			continue // host rules are matched by the host name only
+		}
+		_ = nr.Match(reqs[0]) // the regular expression is compiled on the first use
+		start := time.Now()
+		for _, req := range reqs {
+			_ = nr.Match(req)
+		}
+		costs = append(costs, RuleCostJSON{
+			FilterID: int64(nr.GetFilterListID()),
+			Rule:     nr.Text(),
+			AvgNs:    float64(time.Since(start).Nanoseconds()) / float64(len(reqs)),
+		})
+	}
+	return costs
+}
+
+// ProfileRules - measure the matching time of the network rules of the blocklists and the allowlists
+// The regular expressions and the rules without a substring to look up are matched against each request,
+// so one complex rule may slow down the filtering of all requests.
+func (d *Dnsfilter) ProfileRules() (RuleProfileJSON, error) {
+	resp := RuleProfileJSON{}
+	if !atomic.CompareAndSwapInt32(&d.ruleProfileRunning, 0, 1) {
+		return resp, fmt.Errorf("the rules are being profiled")
+	}
+	defer atomic.StoreInt32(&d.ruleProfileRunning, 0)
+
+	e := d.acquireEngines()
+	if e == nil {
+		return resp, fmt.Errorf("the filters aren't loaded")
+	}
+	defer e.release()
+
+	hosts := ruleProfileHostnames()
+	reqs := make([]*rules.Request, 0, len(hosts))
+	for _, h := range hosts {
+		reqs = append(reqs, rules.NewRequestForHostname(h))
+	}
+
+	start := time.Now()
+	costs := profileStorage(e.rulesStorage, reqs, nil)
+	costs = profileStorage(e.rulesStorageWhite, reqs, costs)
+	resp.ElapsedMs = float64(time.Since(start).Nanoseconds()) / 1000000
+	resp.Rules = len(costs)
+	resp.Hosts = len(hosts)
+
+	sort.Slice(costs, func(i, j int) bool {
+		return costs[i].AvgNs > costs[j].AvgNs
+	})
+	if len(costs) > ruleProfileLimit {
+		costs = costs[:ruleProfileLimit]
+	}
+	for i := range costs {
+		c := &costs[i]
+		c.Slow = c.AvgNs > float64(ruleProfileSlowAvg.Nanoseconds())
+		if c.Slow {
+			log.Info("filtering: slow rule in filter %d: %q: %.0fns per request", c.FilterID, c.Rule, c.AvgNs)
+		}
+	}
+	resp.Slowest = costs
+	return resp, nil
+}
+
+// POST /control/filtering/profile_rules
+func (d *Dnsfilter) handleProfileRules(w http.ResponseWriter, r *http.Request) {
+	resp, err := d.ProfileRules()
+	if err != nil {
+		httpError(r, w, http.StatusConflict, "%s", err)
+		return
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+func (d *Dnsfilter) registerRuleProfileHandlers() {
+	d.Config.HTTPRegister("POST", "/control/filtering/profile_rules", d.handleProfileRules)
+}
@@ -31,18 +31,39 @@ const defaultParentalServer = "https://dns-family.adguard.com/dns-query"
 const sbTXTSuffix = "sb.dns.adguard.com."
 const pcTXTSuffix = "pc.dns.adguard.com."
 
-func (d *Dnsfilter) initSecurityServices() error {
-	var err error
-	d.safeBrowsingServer = defaultSafebrowsingServer
-	d.parentalServer = defaultParentalServer
+// ValidateSecurityServer - check the address of the Safe Browsing or Parental Control server ("": default)
+func ValidateSecurityServer(addr string) error {
+	if len(addr) == 0 {
+		return nil
+	}
+	_, err := upstream.AddressToUpstream(addr, upstream.Options{Timeout: dnsTimeout})
+	return err
+}
+
+// securityUpstream - create the upstream for the server address ("": the default server)
+func (d *Dnsfilter) securityUpstream(addr, defaultAddr string) (string, upstream.Upstream, error) {
 	opts := upstream.Options{Timeout: dnsTimeout, Bootstrap: bootstrapServers}
+	if len(addr) == 0 {
+		addr = defaultAddr
+	} else if len(d.Config.SecurityBootstrap) != 0 {
+		// a self-hosted server must be resolved without access to AdGuard servers
+		opts.Bootstrap = d.Config.SecurityBootstrap
+	}
+	u, err := upstream.AddressToUpstream(addr, opts)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %s", addr, err)
+	}
+	return addr, u, nil
+}
 
-	d.parentalUpstream, err = upstream.AddressToUpstream(d.parentalServer, opts)
+func (d *Dnsfilter) initSecurityServices() error {
+	var err error
+	d.parentalServer, d.parentalUpstream, err = d.securityUpstream(d.Config.ParentalServer, defaultParentalServer)
 	if err != nil {
 		return err
 	}
 
-	d.safeBrowsingUpstream, err = upstream.AddressToUpstream(d.safeBrowsingServer, opts)
+	d.safeBrowsingServer, d.safeBrowsingUpstream, err = d.securityUpstream(d.Config.SafeBrowsingServer, defaultSafebrowsingServer)
 	if err != nil {
 		return err
 	}
@@ -320,6 +341,7 @@ func (d *Dnsfilter) handleSafeBrowsingDisable(w http.ResponseWriter, r *http.Req
 func (d *Dnsfilter) handleSafeBrowsingStatus(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{
 		"enabled": d.Config.SafeBrowsingEnabled,
+		"server":  d.safeBrowsingServer,
 	}
 	jsonVal, err := json.Marshal(data)
 	if err != nil {
@@ -347,6 +369,7 @@ func (d *Dnsfilter) handleParentalDisable(w http.ResponseWriter, r *http.Request
 func (d *Dnsfilter) handleParentalStatus(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{
 		"enabled": d.Config.ParentalEnabled,
+		"server":  d.parentalServer,
 	}
 	jsonVal, err := json.Marshal(data)
 	if err != nil {
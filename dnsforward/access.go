@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/urlfilter"
 	"github.com/AdguardTeam/urlfilter/filterlist"
@@ -23,6 +24,26 @@ type accessCtx struct {
 	disallowedClientsIPNet []net.IPNet // CIDRs of clients that should be blocked
 
 	blockedHostsEngine *urlfilter.DNSEngine // finds hosts that should be blocked
+
+	qnameRules []qnameAccess // query name restrictions for the groups of clients
+}
+
+// QNameAccessRule - restricts the query names for the clients from the specified networks
+// Allowed and Disallowed have the same syntax as BlockedHosts.
+type QNameAccessRule struct {
+	Clients    []string `yaml:"clients" json:"clients"`       // IP addresses and CIDRs (empty: all clients)
+	Protocols  []string `yaml:"protocols" json:"protocols"`   // "udp", "tcp", "tls", "https" (empty: all protocols)
+	Allowed    []string `yaml:"allowed" json:"allowed"`       // if not empty, only these names may be resolved
+	Disallowed []string `yaml:"disallowed" json:"disallowed"` // these names may not be resolved
+}
+
+type qnameAccess struct {
+	clients      map[string]bool
+	clientsIPNet []net.IPNet
+	protocols    []string
+
+	allowed    *urlfilter.DNSEngine // nil: all names are allowed
+	disallowed *urlfilter.DNSEngine
 }
 
 func (a *accessCtx) Init(allowedClients, disallowedClients, blockedHosts []string) error {
@@ -36,8 +57,18 @@ func (a *accessCtx) Init(allowedClients, disallowedClients, blockedHosts []strin
 		return err
 	}
 
+	a.blockedHostsEngine, err = newHostsEngine(blockedHosts)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// newHostsEngine - create the engine that matches the host names and wildcards
+func newHostsEngine(hosts []string) (*urlfilter.DNSEngine, error) {
 	buf := strings.Builder{}
-	for _, s := range blockedHosts {
+	for _, s := range hosts {
 		buf.WriteString(s)
 		buf.WriteString("\n")
 	}
@@ -51,21 +82,60 @@ func (a *accessCtx) Init(allowedClients, disallowedClients, blockedHosts []strin
 	listArray = append(listArray, list)
 	rulesStorage, err := filterlist.NewRuleStorage(listArray)
 	if err != nil {
-		return fmt.Errorf("filterlist.NewRuleStorage(): %s", err)
+		return nil, fmt.Errorf("filterlist.NewRuleStorage(): %s", err)
 	}
-	a.blockedHostsEngine = urlfilter.NewDNSEngine(rulesStorage)
+	return urlfilter.NewDNSEngine(rulesStorage), nil
+}
+
+// InitQNameAccess - prepare query name restrictions
+func (a *accessCtx) InitQNameAccess(rules []QNameAccessRule) error {
+	a.qnameRules = nil
+	for i, r := range rules {
+		q := qnameAccess{}
+		err := processIPCIDRArray(&q.clients, &q.clientsIPNet, r.Clients)
+		if err != nil {
+			return fmt.Errorf("qname_access[%d]: %s", i, err)
+		}
+
+		for _, p := range r.Protocols {
+			switch p {
+			case "udp", "tcp", "tls", "https":
+				q.protocols = append(q.protocols, p)
+			default:
+				return fmt.Errorf("qname_access[%d]: invalid protocol %q", i, p)
+			}
+		}
+
+		if len(r.Allowed) != 0 {
+			q.allowed, err = newHostsEngine(r.Allowed)
+			if err != nil {
+				return fmt.Errorf("qname_access[%d]: %s", i, err)
+			}
+		}
+		q.disallowed, err = newHostsEngine(r.Disallowed)
+		if err != nil {
+			return fmt.Errorf("qname_access[%d]: %s", i, err)
+		}
 
+		a.qnameRules = append(a.qnameRules, q)
+	}
 	return nil
 }
 
+// ValidateQNameAccess - check query name restrictions
+func ValidateQNameAccess(rules []QNameAccessRule) error {
+	a := &accessCtx{}
+	return a.InitQNameAccess(rules)
+}
+
 // Split array of IP or CIDR into 2 containers for fast search
 func processIPCIDRArray(dst *map[string]bool, dstIPNet *[]net.IPNet, src []string) error {
 	*dst = make(map[string]bool)
 
 	for _, s := range src {
-		ip := net.ParseIP(s)
+		ip := net.ParseIP(util.TrimBrackets(s))
 		if ip != nil {
-			(*dst)[s] = true
+			(*dst)[ip.String()] = true // IPv6 address may be written in a non-canonical form
 			continue
 		}
 
@@ -127,10 +197,63 @@ func (a *accessCtx) IsBlockedDomain(host string) bool {
 	return ok
 }
 
+// match - return TRUE if the rule applies to this client
+func (q *qnameAccess) match(ip net.IP, proto string) bool {
+	if len(q.protocols) != 0 && !util.ContainsString(q.protocols, proto) {
+		return false
+	}
+
+	if len(q.clients) == 0 && len(q.clientsIPNet) == 0 {
+		return true
+	}
+	if q.clients[ip.String()] {
+		return true
+	}
+	for _, ipnet := range q.clientsIPNet {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBlockedQName - return TRUE if the client may not resolve this host
+// Only the first rule that matches the client is applied.
+func (a *accessCtx) IsBlockedQName(ip string, proto string, host string) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if len(a.qnameRules) == 0 {
+		return false
+	}
+
+	ipAddr := net.ParseIP(ip)
+	for i := range a.qnameRules {
+		q := &a.qnameRules[i]
+		if !q.match(ipAddr, proto) {
+			continue
+		}
+
+		if q.allowed != nil {
+			_, ok := q.allowed.Match(host)
+			if !ok {
+				return true
+			}
+		}
+		_, ok := q.disallowed.Match(host)
+		return ok
+	}
+
+	return false
+}
+
 type accessListJSON struct {
 	AllowedClients    []string `json:"allowed_clients"`
 	DisallowedClients []string `json:"disallowed_clients"`
 	BlockedHosts      []string `json:"blocked_hosts"`
+
+	// nil: don't change the current settings
+	QNameAccess []QNameAccessRule `json:"qname_access"`
 }
 
 func (s *Server) handleAccessList(w http.ResponseWriter, r *http.Request) {
@@ -139,8 +262,12 @@ func (s *Server) handleAccessList(w http.ResponseWriter, r *http.Request) {
 		AllowedClients:    s.conf.AllowedClients,
 		DisallowedClients: s.conf.DisallowedClients,
 		BlockedHosts:      s.conf.BlockedHosts,
+		QNameAccess:       s.conf.QNameAccess,
 	}
 	s.RUnlock()
+	if j.QNameAccess == nil {
+		j.QNameAccess = []QNameAccessRule{}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(j)
@@ -191,9 +318,19 @@ func (s *Server) handleAccessSet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.Lock()
+	if j.QNameAccess == nil {
+		j.QNameAccess = s.conf.QNameAccess
+	}
+	err = a.InitQNameAccess(j.QNameAccess)
+	if err != nil {
+		s.Unlock()
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
 	s.conf.AllowedClients = j.AllowedClients
 	s.conf.DisallowedClients = j.DisallowedClients
 	s.conf.BlockedHosts = j.BlockedHosts
+	s.conf.QNameAccess = j.QNameAccess
 	s.access = a
 	s.Unlock()
 	s.conf.ConfigModified()
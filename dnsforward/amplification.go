@@ -0,0 +1,223 @@
+package dnsforward
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// DNS cookies (RFC 7873)
+const (
+	clientCookieLen    = 8
+	serverCookieLen    = 8 // the length of the server cookies we generate
+	serverCookieMinLen = 8
+	serverCookieMaxLen = 32
+	cookieSecretLen    = 32
+)
+
+// The TTL of HINFO record in the minimal response to ANY request (RFC 8482)
+const minimalAnyTTL = 3600
+
+// The minimum size of UDP responses to unverified clients
+const minUnverifiedUDPSize = dns.MinMsgSize
+
+// dnsCookie - DNS cookie received from the client
+type dnsCookie struct {
+	client []byte
+	server []byte // empty if the client doesn't know our server cookie yet
+}
+
+// newCookieSecret - generate the secret for server cookies
+// The secret isn't stored anywhere: the clients get new server cookies after restart.
+func newCookieSecret() []byte {
+	secret := make([]byte, cookieSecretLen)
+	_, err := rand.Read(secret)
+	if err != nil {
+		log.Error("DNS: cookie secret: %s", err)
+	}
+	return secret
+}
+
+// serverCookie - get the server cookie for this client
+func (s *Server) serverCookie(client []byte, ip net.IP) []byte {
+	h := hmac.New(sha256.New, s.cookieSecret)
+	_, _ = h.Write(client)
+	_, _ = h.Write(ip)
+	return h.Sum(nil)[:serverCookieLen]
+}
+
+// getCookie - get DNS cookie from the request and remove it, so it isn't sent to upstream servers
+// Return nil if the request has no cookie.
+func getCookie(req *dns.Msg) (*dnsCookie, error) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return nil, nil
+	}
+
+	var cookie *dnsCookie
+	options := []dns.EDNS0{}
+	for _, o := range opt.Option {
+		c, ok := o.(*dns.EDNS0_COOKIE)
+		if !ok {
+			options = append(options, o)
+			continue
+		}
+		if cookie != nil {
+			return nil, fmt.Errorf("duplicate cookie")
+		}
+		data, err := hex.DecodeString(c.Cookie)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie: %s", err)
+		}
+		n := len(data) - clientCookieLen
+		if n != 0 && (n < serverCookieMinLen || n > serverCookieMaxLen) {
+			return nil, fmt.Errorf("invalid cookie length %d", len(data))
+		}
+		cookie = &dnsCookie{client: data[:clientCookieLen], server: data[clientCookieLen:]}
+	}
+	opt.Option = options
+	return cookie, nil
+}
+
+// setCookie - replace DNS cookie received from upstream server with our own one
+// No cookie is added if client is nil.
+func (s *Server) setCookie(resp *dns.Msg, client []byte, ip net.IP) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		if client == nil {
+			return
+		}
+		resp.SetEdns0(dns.DefaultMsgSize, false)
+		opt = resp.IsEdns0()
+	}
+
+	options := []dns.EDNS0{}
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0COOKIE {
+			options = append(options, o)
+		}
+	}
+	if client != nil {
+		data := make([]byte, 0, clientCookieLen+serverCookieLen)
+		data = append(data, client...)
+		data = append(data, s.serverCookie(client, ip)...)
+		options = append(options, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: hex.EncodeToString(data)})
+	}
+	opt.Option = options
+}
+
+// isUnverifiedUDP - return TRUE if the request is received over UDP and the client hasn't sent a valid server cookie
+// The source IP address of such request may be spoofed.
+func (ctx *dnsContext) isUnverifiedUDP() bool {
+	return ctx.proxyCtx.Proto == proxy.ProtoUDP && !ctx.cookieVerified
+}
+
+// Get DNS cookie from the request;  respond with FORMERR if it's malformed
+func processCookies(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	if !s.conf.EnableDNSCookies {
+		return resultDone
+	}
+
+	c, err := getCookie(d.Req)
+	if err != nil {
+		log.Debug("DNS: %s: %s", ipFromAddr(d.Addr), err)
+		d.Res = s.genFormatError(d.Req)
+		return resultFinish
+	}
+	if c == nil {
+		return resultDone
+	}
+
+	ctx.cookie = c
+	ctx.cookieVerified = hmac.Equal(c.server, s.serverCookie(c.client, getIP(d.Addr)))
+	return resultDone
+}
+
+// Respond to ANY requests from unverified UDP clients with a minimal response (RFC 8482)
+func processMinimalAny(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	if !s.conf.MinimalAnyResponses ||
+		d.Req.Question[0].Qtype != dns.TypeANY ||
+		!ctx.isUnverifiedUDP() {
+		return resultDone
+	}
+
+	resp := s.makeResponse(d.Req)
+	hinfo := &dns.HINFO{
+		Hdr: dns.RR_Header{
+			Name:   d.Req.Question[0].Name,
+			Rrtype: dns.TypeHINFO,
+			Class:  dns.ClassINET,
+			Ttl:    minimalAnyTTL,
+		},
+		Cpu: "RFC8482",
+	}
+	resp.Answer = append(resp.Answer, hinfo)
+	d.Res = resp
+	return resultDone
+}
+
+// finishResponse - set our DNS cookie in the response and limit the size of UDP responses to unverified clients
+func (ctx *dnsContext) finishResponse() {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	if d.Res == nil {
+		return
+	}
+
+	if s.conf.EnableDNSCookies {
+		var client []byte
+		if ctx.cookie != nil {
+			client = ctx.cookie.client
+		}
+		s.setCookie(d.Res, client, getIP(d.Addr))
+	}
+
+	if s.conf.UnverifiedUDPMaxSize != 0 && ctx.isUnverifiedUDP() {
+		truncateResponse(d.Res, int(s.conf.UnverifiedUDPMaxSize))
+	}
+}
+
+// truncateResponse - if the response is larger than size bytes, remove its records and set TC flag
+// The client retries the request over TCP then.
+func truncateResponse(m *dns.Msg, size int) {
+	m.Compress = true
+	if m.Len() <= size {
+		return
+	}
+
+	m.Truncated = true
+	m.Answer = nil
+	m.Ns = nil
+	opt := m.IsEdns0()
+	m.Extra = nil
+	if opt != nil {
+		m.Extra = append(m.Extra, opt)
+	}
+}
+
+// genFormatError - generate FORMERR response
+func (s *Server) genFormatError(request *dns.Msg) *dns.Msg {
+	resp := dns.Msg{}
+	resp.SetRcode(request, dns.RcodeFormatError)
+	resp.RecursionAvailable = true
+	return &resp
+}
+
+// ValidateUnverifiedUDPMaxSize - check the size limit of UDP responses to unverified clients
+func ValidateUnverifiedUDPMaxSize(size uint32) error {
+	if size != 0 && size < minUnverifiedUDPSize {
+		return fmt.Errorf("unverified_udp_max_size: must be 0 or at least %d", minUnverifiedUDPSize)
+	}
+	return nil
+}
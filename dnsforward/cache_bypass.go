@@ -0,0 +1,55 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/utils"
+)
+
+// ValidateCacheBypass - check the domains and wildcards whose responses are never cached
+func ValidateCacheBypass(list []string) error {
+	for _, n := range list {
+		h := strings.TrimSuffix(n, ".")
+		if isWildcard(h) {
+			h = h[2:]
+		}
+		if utils.IsValidHostname(h) != nil {
+			return fmt.Errorf("invalid domain %q", n)
+		}
+	}
+	return nil
+}
+
+// isCacheBypassed - return TRUE if the responses for this host must never be cached
+// "example.org" matches the host itself, "*.example.org" matches its subdomains.
+func isCacheBypassed(list []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, n := range list {
+		n = strings.ToLower(strings.TrimSuffix(n, "."))
+		if host == n || matchDomainWildcard(host, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCacheBypass - make the proxy skip the cache lookup and store for this request
+// dnsproxy doesn't use the cache for the requests with custom upstreams, so the main upstream configuration is passed as the custom one.
+func (s *Server) setCacheBypass(d *proxy.DNSContext) bool {
+	if d.CustomUpstreamConfig != nil {
+		return false // the cache isn't used anyway
+	}
+
+	host := d.Req.Question[0].Name
+	s.RLock()
+	bypass := (isCacheBypassed(s.conf.CacheBypass, host) || s.cacheIndex.isPurged(host, time.Now())) &&
+		s.dnsProxy != nil
+	if bypass {
+		d.CustomUpstreamConfig = s.dnsProxy.UpstreamConfig
+	}
+	s.RUnlock()
+	return bypass
+}
@@ -0,0 +1,302 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// The maximum number of the entries in the cache index
+const cacheIndexMaxEntries = 100000
+
+const (
+	cacheEntriesDefaultLimit = 100
+	cacheEntriesMaxLimit     = 1000
+)
+
+type cacheIndexKey struct {
+	name  string // lower case, without the trailing dot
+	qtype uint16
+}
+
+type cacheIndexEntry struct {
+	upstream string
+	rcode    int
+	expire   time.Time
+}
+
+// cacheIndex - the index of the responses stored in the DNS cache of the proxy
+// dnsproxy doesn't allow to read or delete its cache entries, so the server keeps track of the responses
+// that have been cached.  A purged name is resolved bypassing the cache until its cached responses expire.
+type cacheIndex struct {
+	lock    sync.Mutex
+	entries map[cacheIndexKey]cacheIndexEntry
+	purged  map[string]time.Time // "example.org" or "*.example.org" -> the time until the cache is bypassed
+}
+
+// reset - clear the index when the proxy with a new cache is created
+func (c *cacheIndex) reset() {
+	c.lock.Lock()
+	c.entries = nil
+	c.purged = nil
+	c.lock.Unlock()
+}
+
+// lowestTTL - get the TTL of the cached response:  the lowest TTL of its records
+func lowestTTL(m *dns.Msg) uint32 {
+	var ttl uint32 = math.MaxUint32
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range rrs {
+			h := rr.Header()
+			if h.Rrtype != dns.TypeOPT && h.Ttl < ttl {
+				ttl = h.Ttl
+			}
+		}
+	}
+	if ttl == math.MaxUint32 {
+		return 0
+	}
+	return ttl
+}
+
+// isCacheableResponse - return TRUE if the proxy stores this response in its cache
+func isCacheableResponse(m *dns.Msg) bool {
+	if m.Truncated || len(m.Question) != 1 || lowestTTL(m) == 0 {
+		return false
+	}
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		return false
+	}
+	qtype := m.Question[0].Qtype
+	if m.Rcode == dns.RcodeSuccess && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+		return hasRecords(m.Answer, dns.TypeA) || hasRecords(m.Answer, dns.TypeAAAA)
+	}
+	return true
+}
+
+// add - add the response received from the upstream server
+func (c *cacheIndex) add(m *dns.Msg, upstream string, now time.Time) {
+	if !isCacheableResponse(m) {
+		return
+	}
+	q := m.Question[0]
+	key := cacheIndexKey{
+		name:  strings.ToLower(strings.TrimSuffix(q.Name, ".")),
+		qtype: q.Qtype,
+	}
+	e := cacheIndexEntry{
+		upstream: upstream,
+		rcode:    m.Rcode,
+		expire:   now.Add(time.Duration(lowestTTL(m)) * time.Second),
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.entries == nil {
+		c.entries = map[cacheIndexKey]cacheIndexEntry{}
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= cacheIndexMaxEntries {
+		c.removeExpired(now)
+		if len(c.entries) >= cacheIndexMaxEntries {
+			return
+		}
+	}
+	c.entries[key] = e
+}
+
+func (c *cacheIndex) removeExpired(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expire) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// purge - remove the entries for the names matching the patterns
+// "example.org" matches the host itself, "*.example.org" matches its subdomains.
+// Return the number of the removed entries.
+func (c *cacheIndex) purge(patterns []string, now time.Time) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removeExpired(now)
+	if c.purged == nil {
+		c.purged = map[string]time.Time{}
+	}
+
+	n := 0
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSuffix(p, "."))
+		until := c.purged[p]
+		for k, e := range c.entries {
+			if k.name != p && !matchDomainWildcard(k.name, p) {
+				continue
+			}
+			if e.expire.After(until) {
+				until = e.expire
+			}
+			delete(c.entries, k)
+			n++
+		}
+		if until.After(now) {
+			c.purged[p] = until
+		}
+	}
+	return n
+}
+
+// isPurged - return TRUE if the host has been purged and its old responses may still be in the cache
+func (c *cacheIndex) isPurged(host string, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.purged) == 0 {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	found := false
+	for p, until := range c.purged {
+		if !now.Before(until) {
+			delete(c.purged, p)
+			continue
+		}
+		if host == p || matchDomainWildcard(host, p) {
+			found = true
+		}
+	}
+	return found
+}
+
+// CacheEntryJSON - the entry of the DNS cache
+type CacheEntryJSON struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Rcode    string `json:"rcode"`
+	TTL      uint32 `json:"ttl"`      // the remaining TTL (in seconds)
+	Upstream string `json:"upstream"` // the upstream server the response has been received from
+}
+
+// list - get the entries whose names contain the search string, sorted by name
+func (c *cacheIndex) list(search string, now time.Time) []CacheEntryJSON {
+	search = strings.ToLower(search)
+	list := []CacheEntryJSON{}
+	c.lock.Lock()
+	c.removeExpired(now)
+	for k, e := range c.entries {
+		if !strings.Contains(k.name, search) {
+			continue
+		}
+		list = append(list, CacheEntryJSON{
+			Name:     k.name,
+			Type:     dns.Type(k.qtype).String(),
+			Rcode:    dns.RcodeToString[e.rcode],
+			TTL:      uint32(e.expire.Sub(now) / time.Second),
+			Upstream: e.upstream,
+		})
+	}
+	c.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Type < list[j].Type
+	})
+	return list
+}
+
+// onUpstreamResponse - add the response stored in the cache by the proxy to the index
+func (s *Server) onUpstreamResponse(d *proxy.DNSContext, err error) {
+	if err != nil || d.Res == nil || d.Upstream == nil || d.CustomUpstreamConfig != nil || s.conf.CacheSize == 0 {
+		return // the response isn't cached
+	}
+	s.cacheIndex.add(d.Res, d.Upstream.Address(), time.Now())
+}
+
+type cacheEntriesJSON struct {
+	Enabled bool             `json:"enabled"`
+	Total   int              `json:"total"` // the number of the entries matching the query
+	Entries []CacheEntryJSON `json:"entries"`
+}
+
+// GET /control/cache/entries?search=example&offset=0&limit=100
+func (s *Server) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset := 0
+	limit := cacheEntriesDefaultLimit
+	var err error
+	if v := q.Get("offset"); len(v) != 0 {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			httpError(r, w, http.StatusBadRequest, "invalid offset %q", v)
+			return
+		}
+	}
+	if v := q.Get("limit"); len(v) != 0 {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > cacheEntriesMaxLimit {
+			httpError(r, w, http.StatusBadRequest, "invalid limit %q", v)
+			return
+		}
+	}
+
+	s.RLock()
+	resp := cacheEntriesJSON{Enabled: s.conf.CacheSize != 0}
+	s.RUnlock()
+	list := s.cacheIndex.list(q.Get("search"), time.Now())
+	resp.Total = len(list)
+	if offset > len(list) {
+		offset = len(list)
+	}
+	if offset+limit < len(list) {
+		list = list[:offset+limit]
+	}
+	resp.Entries = list[offset:]
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+type cachePurgeJSON struct {
+	Names []string `json:"names"`
+}
+
+// POST /control/cache/purge
+func (s *Server) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	req := cachePurgeJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+	if len(req.Names) == 0 {
+		httpError(r, w, http.StatusBadRequest, "no names")
+		return
+	}
+	err = ValidateCacheBypass(req.Names)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	n := s.cacheIndex.purge(req.Names, time.Now())
+	log.Info("DNS: purged %d cache entries for %v", n, req.Names)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]int{"purged": n})
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
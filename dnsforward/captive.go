@@ -0,0 +1,92 @@
+package dnsforward
+
+import (
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// The hosts used by operating systems and browsers to detect a captive portal.
+// If they can't be resolved, the device never shows the portal's login page
+// and stays without Internet access.
+var captivePortalHosts = []string{
+	// Android, ChromeOS
+	"connectivitycheck.gstatic.com",
+	"clients3.google.com",
+	"connectivitycheck.android.com",
+	// iOS, macOS
+	"captive.apple.com",
+	"www.appleiphonecell.com",
+	// Windows
+	"www.msftconnecttest.com",
+	"ipv6.msftconnecttest.com",
+	"www.msftncsi.com",
+	"dns.msftncsi.com",
+	// Firefox
+	"detectportal.firefox.com",
+	// Linux (NetworkManager)
+	"connectivity-check.ubuntu.com",
+	"nmcheck.gnome.org",
+	"network-test.debian.org",
+	// Xiaomi, Huawei
+	"connect.rom.miui.com",
+	"connectivitycheck.platform.hicloud.com",
+}
+
+// isCaptivePortalHost - return TRUE if this is a captive portal detection host
+func isCaptivePortalHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range captivePortalHosts {
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// Mark the requests for captive portal detection hosts:
+// they aren't filtered and, if enabled, aren't cached
+func processCaptivePortal(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	if !s.conf.CaptivePortalMode || d.Res != nil {
+		return resultDone
+	}
+
+	host := strings.TrimSuffix(d.Req.Question[0].Name, ".")
+	if isCaptivePortalHost(host) {
+		log.Debug("DNS: %s: captive portal detection host", host)
+		ctx.captivePortal = true
+	}
+	return resultDone
+}
+
+// resolveCaptivePortal - send the request directly to upstream servers bypassing the cache
+// and limit TTL of the records in the response
+func (s *Server) resolveCaptivePortal(d *proxy.DNSContext) error {
+	upstreams := s.conf.UpstreamConfig.Upstreams
+	if d.CustomUpstreamConfig != nil && len(d.CustomUpstreamConfig.Upstreams) != 0 {
+		upstreams = d.CustomUpstreamConfig.Upstreams
+	}
+
+	resp, u, err := upstream.ExchangeParallel(upstreams, d.Req)
+	if err != nil {
+		return err
+	}
+
+	ttl := s.conf.CaptivePortalTTL
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			if rr.Header().Rrtype != dns.TypeOPT && rr.Header().Ttl > ttl {
+				rr.Header().Ttl = ttl
+			}
+		}
+	}
+
+	d.Res = resp
+	d.Upstream = u
+	return nil
+}
@@ -0,0 +1,90 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// coalescer - the identical requests being resolved
+// While a request is sent to upstream servers, the identical requests wait for its response instead of being sent too.
+type coalescer struct {
+	lock  sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall - the request being resolved
+type coalescedCall struct {
+	done     chan struct{} // closed when the response is received
+	res      *dns.Msg      // a copy of the response:  the original one is modified by the next processing stages
+	upstream upstream.Upstream
+	err      error
+}
+
+// coalesceKey - get the key of the identical requests
+// The requests must have the same question, the same flags that affect the response, and the same upstream servers.
+func (s *Server) coalesceKey(d *proxy.DNSContext) string {
+	q := d.Req.Question[0]
+	sb := strings.Builder{}
+	_, _ = fmt.Fprintf(&sb, "%s/%d/%d/%t/%p", strings.ToLower(q.Name), q.Qtype, q.Qclass, d.Req.CheckingDisabled,
+		d.CustomUpstreamConfig)
+	opt := d.Req.IsEdns0()
+	if opt != nil {
+		_, _ = fmt.Fprintf(&sb, "/%t", opt.Do())
+	}
+	if s.conf.EnableEDNSClientSubnet {
+		// the response depends on the client's subnet
+		_, _ = fmt.Fprintf(&sb, "/%s", ipFromAddr(d.Addr))
+	}
+	return sb.String()
+}
+
+// resolveCoalesced - resolve the request, or wait for the response to the identical request being resolved
+func (s *Server) resolveCoalesced(d *proxy.DNSContext) error {
+	key := s.coalesceKey(d)
+
+	c := &s.coalescer
+	c.lock.Lock()
+	call, ok := c.calls[key]
+	if ok {
+		c.lock.Unlock()
+		<-call.done
+
+		s.countersLock.Lock()
+		s.counters.Coalesced++
+		s.countersLock.Unlock()
+		log.Debug("DNS: %s: using the response to the identical request", d.Req.Question[0].Name)
+
+		if call.res != nil {
+			d.Res = call.res.Copy()
+			d.Res.Id = d.Req.Id
+			d.Res.Question = d.Req.Question // keep the case of the name
+		}
+		d.Upstream = call.upstream
+		return call.err
+	}
+	call = &coalescedCall{done: make(chan struct{})}
+	if c.calls == nil {
+		c.calls = map[string]*coalescedCall{}
+	}
+	c.calls[key] = call
+	c.lock.Unlock()
+
+	err := s.dnsProxy.Resolve(d)
+
+	if d.Res != nil {
+		call.res = d.Res.Copy()
+	}
+	call.upstream = d.Upstream
+	call.err = err
+	c.lock.Lock()
+	delete(c.calls, key)
+	c.lock.Unlock()
+	close(call.done)
+	return err
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/joomcode/errorx"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/tracing"
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 )
@@ -45,6 +47,10 @@ type FilteringConfig struct {
 	ParentalBlockHost     string `yaml:"parental_block_host"`
 	SafeBrowsingBlockHost string `yaml:"safebrowsing_block_host"`
 
+	// The response to the requests of the clients whose internet access is turned off:
+	// "refused", "nxdomain", "null_ip" or "" (the same as for the blocked hosts)
+	InternetOffResponse string `yaml:"internet_off_response"`
+
 	// Anti-DNS amplification
 	// --
 
@@ -52,6 +58,14 @@ type FilteringConfig struct {
 	RatelimitWhitelist []string `yaml:"ratelimit_whitelist"` // a list of whitelisted client IP addresses
 	RefuseAny          bool     `yaml:"refuse_any"`          // if true, refuse ANY requests
 
+	// Respond to ANY requests received over UDP from unverified clients with a single HINFO record (RFC 8482)
+	MinimalAnyResponses bool `yaml:"minimal_any_responses"`
+	// Support DNS cookies (RFC 7873): the clients that send a valid server cookie are verified
+	EnableDNSCookies bool `yaml:"dns_cookies"`
+	// The maximum size of UDP responses to unverified clients (0: no limit).
+	// Larger responses are truncated, so the client retries the request over TCP.
+	UnverifiedUDPMaxSize uint32 `yaml:"unverified_udp_max_size"`
+
 	// Upstream DNS servers configuration
 	// --
 
@@ -60,6 +74,24 @@ type FilteringConfig struct {
 	AllServers   bool     `yaml:"all_servers"`   // if true, parallel queries to all configured upstream servers are enabled
 	FastestAddr  bool     `yaml:"fastest_addr"`  // use Fastest Address algorithm
 
+	// The proxy for DNS-over-HTTPS upstream servers: "http://[user:password@]host:port" or "socks5://..."
+	// Empty: connect directly.
+	UpstreamProxy string `yaml:"upstream_proxy"`
+
+	// Randomize the case of the query names sent to plain UDP upstream servers (DNS 0x20)
+	// and reject the responses with another case
+	Upstream0x20 bool `yaml:"upstream_0x20"`
+	// Retry the request over TCP if the case of the response doesn't match, instead of rejecting it
+	Upstream0x20TCPFallback bool `yaml:"upstream_0x20_tcp_fallback"`
+
+	// Connect to all plain DNS upstream servers over TCP (e.g. if the fragmented UDP responses are dropped)
+	UpstreamTCPOnly bool `yaml:"upstream_tcp_only"`
+	// The EDNS UDP payload size advertised to upstream servers (0: the size from the client's request)
+	EDNSUDPSize uint32 `yaml:"edns_udp_size"`
+
+	// What to answer when all upstream servers for a domain fail (e.g. the domains of conditional forwarding rules)
+	UpstreamFailurePolicies []UpstreamFailurePolicy `yaml:"upstream_failure_policies"`
+
 	// Access settings
 	// --
 
@@ -67,6 +99,9 @@ type FilteringConfig struct {
 	DisallowedClients []string `yaml:"disallowed_clients"` // IP addresses of clients that should be blocked
 	BlockedHosts      []string `yaml:"blocked_hosts"`      // hosts that should be blocked
 
+	// Query name restrictions for the clients from the specified networks
+	QNameAccess []QNameAccessRule `yaml:"qname_access"`
+
 	// DNS cache settings
 	// --
 
@@ -74,6 +109,10 @@ type FilteringConfig struct {
 	CacheMinTTL uint32 `yaml:"cache_ttl_min"` // override TTL value (minimum) received from upstream server
 	CacheMaxTTL uint32 `yaml:"cache_ttl_max"` // override TTL value (maximum) received from upstream server
 
+	// The responses for these domains ("example.org") and their subdomains ("*.example.org") are never cached,
+	// e.g. dynamic DNS names or internal services with fast failover
+	CacheBypass []string `yaml:"cache_bypass"`
+
 	// Other settings
 	// --
 
@@ -81,6 +120,24 @@ type FilteringConfig struct {
 	AAAADisabled           bool     `yaml:"aaaa_disabled"`      // Respond with an empty answer to all AAAA requests
 	EnableDNSSEC           bool     `yaml:"enable_dnssec"`      // Set DNSSEC flag in outcoming DNS request
 	EnableEDNSClientSubnet bool     `yaml:"edns_client_subnet"` // Enable EDNS Client Subnet option
+
+	// Remove the authority and additional sections from the responses, except the SOA records of negative responses
+	MinimalResponses bool `yaml:"minimal_responses"`
+
+	// Send a copy of the requests of the selected clients to a secondary resolver (e.g. for security monitoring)
+	QueryMirror QueryMirrorConfig `yaml:"query_mirror"`
+
+	// The handling of special-use domains (e.g. "onion", "home.arpa", "test", "internal")
+	SpecialUseDomains []SpecialUseDomain `yaml:"special_use_domains"`
+
+	// The changes to the responses for the specified domains (for compatibility with the devices that can't handle some responses)
+	ResponseRules []ResponseRule `yaml:"response_rules"`
+
+	// Captive portal compatibility mode: never block the requests for captive portal detection hosts
+	CaptivePortalMode bool `yaml:"captive_portal_mode"`
+	// If not 0, the requests for captive portal detection hosts bypass the cache
+	//  and TTL of the records in the response is limited to this value (in seconds)
+	CaptivePortalTTL uint32 `yaml:"captive_portal_ttl"`
 }
 
 // TLSConfig is the TLS configuration for HTTPS, DNS-over-HTTPS, and DNS-over-TLS
@@ -88,6 +145,13 @@ type TLSConfig struct {
 	TLSListenAddr  *net.TCPAddr `yaml:"-" json:"-"`
 	StrictSNICheck bool         `yaml:"strict_sni_check" json:"-"` // Reject connection if the client uses server name (in SNI) that doesn't match the certificate
 
+	// Server names (e.g. "dns.example.org" or "*.dns.example.org") the clients may use to connect over DoT/DoH.
+	// If not empty, connections with other server names are rejected.
+	AllowedServerNames []string `yaml:"allowed_server_names" json:"-"`
+	// ClientID patterns (e.g. "laptop" or "kid-*"): ClientID is the first label of the server name matching a wildcard.
+	// If not empty, connections without an allowed ClientID are rejected.
+	AllowedClientIDs []string `yaml:"allowed_client_ids" json:"-"`
+
 	CertificateChain string `yaml:"certificate_chain" json:"certificate_chain"` // PEM-encoded certificates chain
 	PrivateKey       string `yaml:"private_key" json:"private_key"`             // PEM-encoded private key
 
@@ -96,6 +160,7 @@ type TLSConfig struct {
 
 	CertificateChainData []byte `yaml:"-" json:"-"`
 	PrivateKeyData       []byte `yaml:"-" json:"-"`
+	OCSPStaple           []byte `yaml:"-" json:"-"` // DER-encoded OCSP response stapled to the certificate
 
 	cert     tls.Certificate // nolint(structcheck) - linter thinks that this field is unused, while TLSConfig is directly included into ServerConfig
 	dnsNames []string        // nolint(structcheck) // DNS names from certificate (SAN) or CN value from Subject
@@ -106,9 +171,19 @@ type TLSConfig struct {
 type ServerConfig struct {
 	UDPListenAddr  *net.UDPAddr          // UDP listen address
 	TCPListenAddr  *net.TCPAddr          // TCP listen address
+	Listeners      []Listener            // additional listen addresses with their own access settings
 	UpstreamConfig *proxy.UpstreamConfig // Upstream DNS servers config
 	OnDNSRequest   func(d *proxy.DNSContext)
 
+	// "[/domain/]address" upstream servers from the filtering rules with $upstream modifier
+	UpstreamRules []string
+
+	// Called when the request is processed
+	OnDNSResponse func(q QueryInfo)
+
+	// Records the spans of the request processing stages (nil: tracing is disabled)
+	Tracer *tracing.Tracer
+
 	FilteringConfig
 	TLSConfig
 	TLSAllowUnencryptedDOH bool
@@ -116,6 +191,8 @@ type ServerConfig struct {
 	TLSv12Roots *x509.CertPool // list of root CAs for TLSv1.2
 	TLSCiphers  []uint16       // list of TLS ciphers to use
 
+	MaxGoroutines int // the maximum number of goroutines processing DNS requests (0: unlimited)
+
 	// Called when the configuration is changed by HTTP request
 	ConfigModified func()
 
@@ -143,7 +220,9 @@ func (s *Server) createProxyConfig() (proxy.Config, error) {
 		UpstreamConfig:         s.conf.UpstreamConfig,
 		BeforeRequestHandler:   s.beforeRequestHandler,
 		RequestHandler:         s.handleDNSRequest,
+		ResponseHandler:        s.onUpstreamResponse,
 		EnableEDNSClientSubnet: s.conf.EnableEDNSClientSubnet,
+		MaxGoroutines:          s.conf.MaxGoroutines,
 	}
 
 	if s.conf.CacheSize != 0 {
@@ -198,6 +277,10 @@ func (s *Server) initDefaultSettings() {
 	if len(s.conf.SafeBrowsingBlockHost) == 0 {
 		s.conf.SafeBrowsingBlockHost = safeBrowsingBlockHost
 	}
+	if len(s.conf.Listeners) != 0 {
+		// listen on the listeners' addresses only
+		return
+	}
 	if s.conf.UDPListenAddr == nil {
 		s.conf.UDPListenAddr = defaultValues.UDPListenAddr
 	}
@@ -208,10 +291,18 @@ func (s *Server) initDefaultSettings() {
 
 // prepareUpstreamSettings - prepares upstream DNS server settings
 func (s *Server) prepareUpstreamSettings() error {
-	upstreamConfig, err := proxy.ParseUpstreamsConfig(s.conf.UpstreamDNS, s.conf.BootstrapDNS, DefaultTimeout)
+	upstreams := append(append([]string{}, s.conf.UpstreamDNS...), s.conf.UpstreamRules...)
+	upstreamConfig, err := proxy.ParseUpstreamsConfig(util.NormalizeUpstreams(upstreams),
+		util.NormalizeUpstreams(s.conf.BootstrapDNS), DefaultTimeout)
 	if err != nil {
 		return fmt.Errorf("DNS: proxy.ParseUpstreamsConfig: %s", err)
 	}
+	err = SetUpstreamProxy(&upstreamConfig, s.conf.UpstreamProxy)
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+	SetUpstreamTCPOnly(&upstreamConfig, s.conf.UpstreamTCPOnly)
+	HardenUpstreams(&upstreamConfig, s.conf.Upstream0x20, s.conf.Upstream0x20TCPFallback)
 	s.conf.UpstreamConfig = &upstreamConfig
 	return nil
 }
@@ -228,13 +319,15 @@ func (s *Server) prepareIntlProxy() {
 
 // prepareTLS - prepares TLS configuration for the DNS proxy
 func (s *Server) prepareTLS(proxyConfig *proxy.Config) error {
-	if s.conf.TLSListenAddr != nil && len(s.conf.CertificateChainData) != 0 && len(s.conf.PrivateKeyData) != 0 {
+	if (s.conf.TLSListenAddr != nil || s.listenersTLS()) &&
+		len(s.conf.CertificateChainData) != 0 && len(s.conf.PrivateKeyData) != 0 {
 		proxyConfig.TLSListenAddr = s.conf.TLSListenAddr
 		var err error
 		s.conf.cert, err = tls.X509KeyPair(s.conf.CertificateChainData, s.conf.PrivateKeyData)
 		if err != nil {
 			return errorx.Decorate(err, "Failed to parse TLS keypair")
 		}
+		s.conf.cert.OCSPStaple = s.conf.OCSPStaple
 
 		if s.conf.StrictSNICheck {
 			x, err := x509.ParseCertificate(s.conf.cert.Certificate[0])
@@ -268,5 +361,9 @@ func (s *Server) onGetCertificate(ch *tls.ClientHelloInfo) (*tls.Certificate, er
 		log.Info("DNS: TLS: unknown SNI in Client Hello: %s", ch.ServerName)
 		return nil, fmt.Errorf("invalid SNI")
 	}
+	if !s.checkServerName(ch.ServerName) {
+		log.Info("DNS: TLS: server name isn't allowed: %s", ch.ServerName)
+		return nil, fmt.Errorf("server name isn't allowed")
+	}
 	return &s.conf.cert, nil
 }
@@ -45,6 +45,7 @@ var webRegistered bool
 // The zero Server is empty and ready for use.
 type Server struct {
 	dnsProxy   *proxy.Proxy         // DNS proxy instance
+	listeners  []*listener          // DNS proxy instances for the additional listen addresses
 	dnsFilter  *dnsfilter.Dnsfilter // DNS filter instance
 	dhcpServer *dhcpd.Server        // DHCP server instance (optional)
 	queryLog   querylog.QueryLog    // Query log instance
@@ -52,14 +53,37 @@ type Server struct {
 	access     *accessCtx
 
 	tablePTR     map[string]string // "IP -> hostname" table for reverse lookup
+	tableHost    map[string]net.IP // "hostname -> IP" table for the local zone of special-use domains
 	tablePTRLock sync.Mutex
 
+	specialUse []specialUseDomain // the handling of special-use domains
+
+	failurePolicies []failurePolicy       // what to answer when all upstream servers fail
+	responseRules   []responseRule        // the changes to the responses for the specified domains
+	stale           map[string]staleEntry // the last successful responses for "stale" failure policy
+	staleLock       sync.Mutex
+
+	cookieSecret []byte // the secret for server cookies
+
+	cacheIndex cacheIndex   // the responses stored in the DNS cache
+	recorder   recorder     // the recording of the requests for debugging
+	verboseLog verboseLog   // the full logging of the requests for the specified domains or upstream servers
+	coalescer  coalescer    // the identical requests being resolved
+	mirror     *queryMirror // the sending of a copy of the requests to a secondary resolver (nil: disabled)
+
+	counters     Counters // the monotonic counters
+	countersLock sync.Mutex
+
 	// DNS proxy instance for internal usage
 	// We don't Start() it and so no listen port is required.
 	internalProxy *proxy.Proxy
 
 	isRunning bool
 
+	inflightLock sync.Mutex
+	inflight     int  // the number of the requests being processed
+	draining     bool // the server is being stopped: new requests are refused
+
 	sync.RWMutex
 	conf ServerConfig
 }
@@ -80,6 +104,7 @@ func NewServer(p DNSCreateParams) *Server {
 	s.stats = p.Stats
 	s.queryLog = p.QueryLog
 	s.dhcpServer = p.DHCPServer
+	s.cookieSecret = newCookieSecret()
 
 	if s.dhcpServer != nil {
 		s.dhcpServer.SetOnLeaseChanged(s.onDHCPLeaseChanged)
@@ -113,10 +138,22 @@ func (s *Server) WriteDiskConfig(c *FilteringConfig) {
 	c.AllowedClients = stringArrayDup(sc.AllowedClients)
 	c.DisallowedClients = stringArrayDup(sc.DisallowedClients)
 	c.BlockedHosts = stringArrayDup(sc.BlockedHosts)
+	c.QNameAccess = append([]QNameAccessRule(nil), sc.QNameAccess...)
+	c.SpecialUseDomains = append([]SpecialUseDomain(nil), sc.SpecialUseDomains...)
+	c.UpstreamFailurePolicies = append([]UpstreamFailurePolicy(nil), sc.UpstreamFailurePolicies...)
+	c.ResponseRules = append([]ResponseRule(nil), sc.ResponseRules...)
 	c.UpstreamDNS = stringArrayDup(sc.UpstreamDNS)
 	s.RUnlock()
 }
 
+// SetProtectionEnabled - enable or disable the protection
+func (s *Server) SetProtectionEnabled(enabled bool) {
+	s.Lock()
+	s.conf.ProtectionEnabled = enabled
+	s.Unlock()
+	s.conf.ConfigModified()
+}
+
 // Resolve - get IP addresses by host name from an upstream server.
 // No request/response filtering is performed.
 // Query log and Stats are not updated.
@@ -156,11 +193,27 @@ func (s *Server) Start() error {
 
 // startInternal starts without locking
 func (s *Server) startInternal() error {
-	err := s.dnsProxy.Start()
-	if err == nil {
-		s.isRunning = true
+	s.inflightLock.Lock()
+	s.draining = false
+	s.inflightLock.Unlock()
+
+	if s.hasListenAddr() {
+		err := s.dnsProxy.Start()
+		if err != nil {
+			return err
+		}
+	} else {
+		// the requests are received by the listeners only
+		s.dnsProxy.Init()
 	}
-	return err
+
+	err := s.startListeners()
+	if err != nil {
+		_ = s.dnsProxy.Stop()
+		return err
+	}
+	s.isRunning = true
+	return nil
 }
 
 // Prepare the object
@@ -208,6 +261,26 @@ func (s *Server) Prepare(config *ServerConfig) error {
 	if err != nil {
 		return err
 	}
+	err = s.access.InitQNameAccess(s.conf.QNameAccess)
+	if err != nil {
+		return err
+	}
+	err = s.prepareSpecialUse()
+	if err != nil {
+		return err
+	}
+	err = s.prepareFailurePolicies()
+	if err != nil {
+		return err
+	}
+	err = s.prepareResponseRules()
+	if err != nil {
+		return err
+	}
+	err = s.prepareQueryMirror()
+	if err != nil {
+		return err
+	}
 
 	// 6. Register web handlers if necessary
 	// --
@@ -219,7 +292,11 @@ func (s *Server) Prepare(config *ServerConfig) error {
 	// 7. Create the main DNS proxy instance
 	// --
 	s.dnsProxy = &proxy.Proxy{Config: proxyConfig}
-	return nil
+	s.cacheIndex.reset()
+
+	// 8. Create DNS proxy instances for the listeners
+	// --
+	return s.prepareListeners(proxyConfig)
 }
 
 // Stop stops the DNS server
@@ -231,6 +308,8 @@ func (s *Server) Stop() error {
 
 // stopInternal stops without locking
 func (s *Server) stopInternal() error {
+	s.stopListeners()
+	s.stopQueryMirror()
 	if s.dnsProxy != nil {
 		err := s.dnsProxy.Stop()
 		if err != nil {
@@ -8,11 +8,10 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/jsonutil"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/utils"
-	"github.com/miekg/dns"
 )
 
 func httpError(r *http.Request, w http.ResponseWriter, code int, format string, args ...interface{}) {
@@ -37,6 +36,14 @@ type dnsConfigJSON struct {
 	CacheSize         uint32 `json:"cache_size"`
 	CacheMinTTL       uint32 `json:"cache_ttl_min"`
 	CacheMaxTTL       uint32 `json:"cache_ttl_max"`
+
+	CacheBypass []string `json:"cache_bypass"` // domains and wildcards whose responses are never cached
+
+	CaptivePortalMode bool   `json:"captive_portal_mode"`
+	CaptivePortalTTL  uint32 `json:"captive_portal_ttl"`
+
+	EDNSUDPSize     uint32 `json:"edns_udp_size"`     // the EDNS UDP payload size advertised to upstream servers (0: client's)
+	UpstreamTCPOnly bool   `json:"upstream_tcp_only"` // connect to plain DNS upstream servers over TCP
 }
 
 func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
@@ -56,6 +63,11 @@ func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	resp.CacheSize = s.conf.CacheSize
 	resp.CacheMinTTL = s.conf.CacheMinTTL
 	resp.CacheMaxTTL = s.conf.CacheMaxTTL
+	resp.CacheBypass = stringArrayDup(s.conf.CacheBypass)
+	resp.CaptivePortalMode = s.conf.CaptivePortalMode
+	resp.CaptivePortalTTL = s.conf.CaptivePortalTTL
+	resp.EDNSUDPSize = s.conf.EDNSUDPSize
+	resp.UpstreamTCPOnly = s.conf.UpstreamTCPOnly
 	if s.conf.FastestAddr {
 		resp.UpstreamMode = "fastest_addr"
 	} else if s.conf.AllServers {
@@ -137,6 +149,22 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if js.Exists("cache_bypass") {
+		err = ValidateCacheBypass(req.CacheBypass)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "cache_bypass: %s", err)
+			return
+		}
+	}
+
+	if js.Exists("edns_udp_size") {
+		err = ValidateEDNSUDPSize(req.EDNSUDPSize)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
 	restart := false
 	s.Lock()
 
@@ -203,6 +231,27 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 		restart = true
 	}
 
+	if js.Exists("cache_bypass") {
+		s.conf.CacheBypass = req.CacheBypass
+	}
+
+	if js.Exists("captive_portal_mode") {
+		s.conf.CaptivePortalMode = req.CaptivePortalMode
+	}
+
+	if js.Exists("captive_portal_ttl") {
+		s.conf.CaptivePortalTTL = req.CaptivePortalTTL
+	}
+
+	if js.Exists("edns_udp_size") {
+		s.conf.EDNSUDPSize = req.EDNSUDPSize
+	}
+
+	if js.Exists("upstream_tcp_only") {
+		s.conf.UpstreamTCPOnly = req.UpstreamTCPOnly
+		restart = true
+	}
+
 	if js.Exists("upstream_mode") {
 		s.conf.FastestAddr = false
 		s.conf.AllServers = false
@@ -233,6 +282,7 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 type upstreamJSON struct {
 	Upstreams    []string `json:"upstream_dns"`  // Upstreams
 	BootstrapDNS []string `json:"bootstrap_dns"` // Bootstrap DNS
+	Detailed     bool     `json:"detailed"`      // respond with the diagnostics of each server instead of the status
 }
 
 // ValidateUpstreams validates each upstream and returns an error if any upstream is invalid or if there are no default upstreams specified
@@ -303,6 +353,11 @@ func separateUpstream(upstream string) (string, bool, error) {
 		// split domains list and validate each one
 		for _, host := range strings.Split(domainsAndUpstream[0], "/") {
 			if host != "" {
+				// internationalized names are converted to punycode
+				host, err := util.NormalizeDomain(host)
+				if err != nil {
+					return "", defaultUpstream, err
+				}
 				if err := utils.IsValidHostname(host); err != nil {
 					return "", defaultUpstream, err
 				}
@@ -315,8 +370,8 @@ func separateUpstream(upstream string) (string, bool, error) {
 
 // checkPlainDNS checks if host is plain DNS
 func checkPlainDNS(upstream string) error {
-	// Check if host is ip without port
-	if net.ParseIP(upstream) != nil {
+	// Check if host is ip without port (IPv6 address may be in square brackets)
+	if net.ParseIP(util.TrimBrackets(upstream)) != nil {
 		return nil
 	}
 
@@ -351,10 +406,25 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.RLock()
+	upstreamProxy := s.conf.UpstreamProxy
+	s.RUnlock()
+
+	if req.Detailed {
+		jsonVal, err := json.Marshal(diagnoseUpstreams(req.Upstreams, req.BootstrapDNS, upstreamProxy))
+		if err != nil {
+			httpError(r, w, http.StatusInternalServerError, "Unable to marshal status json: %s", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(jsonVal)
+		return
+	}
+
 	result := map[string]string{}
 
 	for _, host := range req.Upstreams {
-		err = checkDNS(host, req.BootstrapDNS)
+		err = checkDNS(host, req.BootstrapDNS, upstreamProxy)
 		if err != nil {
 			log.Info("%v", err)
 			result[host] = err.Error()
@@ -377,60 +447,35 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func checkDNS(input string, bootstrap []string) error {
-	// separate upstream from domains list
-	input, defaultUpstream, err := separateUpstream(input)
-	if err != nil {
-		return fmt.Errorf("wrong upstream format: %s", err)
-	}
-
-	// No need to check this DNS server
-	if input == "#" || !defaultUpstream {
-		return nil
-	}
-
-	if _, err := validateUpstream(input); err != nil {
-		return fmt.Errorf("wrong upstream format: %s", err)
-	}
-
-	if len(bootstrap) == 0 {
-		bootstrap = defaultBootstrap
-	}
+// CheckUpstream - check if the upstream DNS server works
+// Domain-specific upstreams aren't checked.
+// DNS-over-HTTPS upstream is connected through upstreamProxy if it's set.
+func CheckUpstream(upstream string, bootstrap []string, upstreamProxy string) error {
+	return checkDNS(upstream, bootstrap, upstreamProxy)
+}
 
-	log.Debug("Checking if DNS %s works...", input)
-	u, err := upstream.AddressToUpstream(input, upstream.Options{Bootstrap: bootstrap, Timeout: DefaultTimeout})
-	if err != nil {
-		return fmt.Errorf("failed to choose upstream for %s: %s", input, err)
+func checkDNS(input string, bootstrap []string, upstreamProxy string) error {
+	u, addr, err := newCheckUpstream(input, bootstrap, upstreamProxy)
+	if err != nil || u == nil {
+		return err
 	}
 
-	req := dns.Msg{}
-	req.Id = dns.Id()
-	req.RecursionDesired = true
-	req.Question = []dns.Question{
-		{Name: "google-public-dns-a.google.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
-	}
-	reply, err := u.Exchange(&req)
+	log.Debug("Checking if DNS %s works...", addr)
+	err = checkUpstreamAnswer(u, addr)
 	if err != nil {
-		return fmt.Errorf("couldn't communicate with DNS server %s: %s", input, err)
-	}
-	if len(reply.Answer) != 1 {
-		return fmt.Errorf("DNS server %s returned wrong answer", input)
-	}
-	if t, ok := reply.Answer[0].(*dns.A); ok {
-		if !net.IPv4(8, 8, 8, 8).Equal(t.A) {
-			return fmt.Errorf("DNS server %s returned wrong answer: %v", input, t.A)
-		}
+		return err
 	}
 
-	log.Debug("DNS %s works OK", input)
+	log.Debug("DNS %s works OK", addr)
 	return nil
 }
 
 // Control flow:
 // web
-//  -> dnsforward.handleDOH -> dnsforward.ServeHTTP
-//  -> proxy.ServeHTTP -> proxy.handleDNSRequest
-//  -> dnsforward.handleDNSRequest
+//
+//	-> dnsforward.handleDOH -> dnsforward.ServeHTTP
+//	-> proxy.ServeHTTP -> proxy.handleDNSRequest
+//	-> dnsforward.handleDNSRequest
 func (s *Server) handleDOH(w http.ResponseWriter, r *http.Request) {
 	if !s.conf.TLSAllowUnencryptedDOH && r.TLS == nil {
 		httpError(r, w, http.StatusNotFound, "Not Found")
@@ -442,6 +487,11 @@ func (s *Server) handleDOH(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.checkServerName(dohServerName(r)) {
+		httpError(r, w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
 	s.ServeHTTP(w, r)
 }
 
@@ -453,5 +503,17 @@ func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister("GET", "/control/access/list", s.handleAccessList)
 	s.conf.HTTPRegister("POST", "/control/access/set", s.handleAccessSet)
 
+	s.conf.HTTPRegister("GET", "/control/cache/entries", s.handleCacheEntries)
+	s.conf.HTTPRegister("POST", "/control/cache/purge", s.handleCachePurge)
+
+	s.conf.HTTPRegister("GET", "/control/dns_record/status", s.handleRecordStatus)
+	s.conf.HTTPRegister("POST", "/control/dns_record/start", s.handleRecordStart)
+	s.conf.HTTPRegister("POST", "/control/dns_record/stop", s.handleRecordStop)
+	s.conf.HTTPRegister("GET", "/control/dns_record/entries", s.handleRecordEntries)
+	s.conf.HTTPRegister("POST", "/control/dns_record/replay", s.handleRecordReplay)
+
+	s.conf.HTTPRegister("GET", "/control/dns_verbose_log", s.handleVerboseLogStatus)
+	s.conf.HTTPRegister("POST", "/control/dns_verbose_log/set", s.handleVerboseLogSet)
+
 	s.conf.HTTPRegister("", "/dns-query", s.handleDOH)
 }
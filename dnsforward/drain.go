@@ -0,0 +1,72 @@
+package dnsforward
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// The time for the response to be written after the request has been processed:
+// dnsproxy sends the response after our handler returns.
+const drainRespondDelay = 100 * time.Millisecond
+
+// beginRequest - register the request being processed
+// Return FALSE if the server is draining and the request must not be processed.
+func (s *Server) beginRequest() bool {
+	s.inflightLock.Lock()
+	defer s.inflightLock.Unlock()
+	if s.draining {
+		return false
+	}
+	s.inflight++
+	return true
+}
+
+// endRequest - the request has been processed
+func (s *Server) endRequest() {
+	s.inflightLock.Lock()
+	s.inflight--
+	s.inflightLock.Unlock()
+}
+
+// inflightRequests - get the number of the requests being processed
+func (s *Server) inflightRequests() int {
+	s.inflightLock.Lock()
+	defer s.inflightLock.Unlock()
+	return s.inflight
+}
+
+// Drain - stop processing new DNS requests and wait until the requests being processed are completed
+// The new requests are answered with REFUSED, so the clients switch to another DNS server right away
+// instead of waiting for a timeout.
+// Return FALSE if the timeout has expired before all requests were completed.
+// The server must be stopped after this.
+func (s *Server) Drain(timeout time.Duration) bool {
+	s.inflightLock.Lock()
+	s.draining = true
+	s.inflightLock.Unlock()
+
+	n := s.inflightRequests()
+	log.Info("DNS: draining %d requests", n)
+	deadline := time.Now().Add(timeout)
+	for n != 0 {
+		if time.Now().After(deadline) {
+			log.Info("DNS: drain timeout: %d requests are still being processed", n)
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+		n = s.inflightRequests()
+	}
+	time.Sleep(drainRespondDelay)
+	log.Info("DNS: drained")
+	return true
+}
+
+// genRefused - generate REFUSED response
+func (s *Server) genRefused(request *dns.Msg) *dns.Msg {
+	resp := dns.Msg{}
+	resp.SetRcode(request, dns.RcodeRefused)
+	resp.RecursionAvailable = true
+	return &resp
+}
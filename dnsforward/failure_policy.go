@@ -0,0 +1,236 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// What to answer when all upstream servers for the domain fail
+const (
+	failureServFail = "servfail" // respond with SERVFAIL
+	failureStale    = "stale"    // respond with the last successful response
+	failureStatic   = "static"   // respond with the specified IP addresses
+)
+
+// The TTL of the records in the responses generated by the failure policy (RFC 8767 recommends 30 seconds):
+// the client gets the actual response soon after the upstream servers are back
+const failureAnswerTTL = 30
+
+// The limits of the store of the last successful responses
+const (
+	staleMaxEntries = 1000
+	staleMaxAge     = 24 * time.Hour
+)
+
+// UpstreamFailurePolicy - what to answer when all upstream servers for the domain and its subdomains fail
+type UpstreamFailurePolicy struct {
+	Domain  string   `yaml:"domain"`
+	Action  string   `yaml:"action"`  // "servfail", "stale" or "static"
+	Answers []string `yaml:"answers"` // IP addresses for "static"
+}
+
+type failurePolicy struct {
+	domain string // lower-case, without the trailing dot
+	action string
+	ips    []net.IP
+}
+
+type staleEntry struct {
+	msg  *dns.Msg
+	time time.Time // when the response was received
+}
+
+// newFailurePolicies - parse the upstream failure policies
+func newFailurePolicies(list []UpstreamFailurePolicy) ([]failurePolicy, error) {
+	var policies []failurePolicy
+	names := map[string]bool{}
+	for i, p := range list {
+		fp := failurePolicy{
+			domain: strings.ToLower(strings.TrimSuffix(p.Domain, ".")),
+			action: p.Action,
+		}
+		_, ok := dns.IsDomainName(fp.domain)
+		if len(fp.domain) == 0 || !ok {
+			return nil, fmt.Errorf("upstream_failure_policies[%d]: invalid domain %q", i, p.Domain)
+		}
+		if names[fp.domain] {
+			return nil, fmt.Errorf("upstream_failure_policies[%d]: duplicate domain %q", i, p.Domain)
+		}
+		names[fp.domain] = true
+
+		switch p.Action {
+		case failureServFail, failureStale:
+			if len(p.Answers) != 0 {
+				return nil, fmt.Errorf("upstream_failure_policies[%d]: answers are used with action %q only",
+					i, failureStatic)
+			}
+
+		case failureStatic:
+			if len(p.Answers) == 0 {
+				return nil, fmt.Errorf("upstream_failure_policies[%d]: no answers", i)
+			}
+			for _, a := range p.Answers {
+				ip := net.ParseIP(a)
+				if ip == nil {
+					return nil, fmt.Errorf("upstream_failure_policies[%d]: invalid IP address %q", i, a)
+				}
+				fp.ips = append(fp.ips, ip)
+			}
+
+		default:
+			return nil, fmt.Errorf("upstream_failure_policies[%d]: invalid action %q", i, p.Action)
+		}
+
+		policies = append(policies, fp)
+	}
+	return policies, nil
+}
+
+// ValidateUpstreamFailurePolicies - check the upstream failure policies
+func ValidateUpstreamFailurePolicies(list []UpstreamFailurePolicy) error {
+	_, err := newFailurePolicies(list)
+	return err
+}
+
+// prepareFailurePolicies - initialize upstream failure policies
+func (s *Server) prepareFailurePolicies() error {
+	var err error
+	s.failurePolicies, err = newFailurePolicies(s.conf.UpstreamFailurePolicies)
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+	return nil
+}
+
+// findFailurePolicy - get the failure policy of the domain this host belongs to
+// The most specific domain wins.
+func (s *Server) findFailurePolicy(host string) *failurePolicy {
+	host = strings.ToLower(host)
+	var found *failurePolicy
+	for i := range s.failurePolicies {
+		p := &s.failurePolicies[i]
+		if host != p.domain && !strings.HasSuffix(host, "."+p.domain) {
+			continue
+		}
+		if found == nil || len(p.domain) > len(found.domain) {
+			found = p
+		}
+	}
+	return found
+}
+
+// resolveWithFailurePolicy - send the request to upstream servers
+// If all of them fail, answer according to the failure policy of the host's domain.
+func (s *Server) resolveWithFailurePolicy(ctx *dnsContext) error {
+	d := ctx.proxyCtx
+	err := s.resolveCoalesced(d)
+
+	host := strings.TrimSuffix(d.Req.Question[0].Name, ".")
+	s.RLock()
+	p := s.findFailurePolicy(host)
+	s.RUnlock()
+	if p == nil {
+		return err
+	}
+
+	failed := err != nil || d.Res == nil || d.Res.Rcode == dns.RcodeServerFailure
+	if !failed {
+		if p.action == failureStale {
+			s.saveStale(d.Res)
+		}
+		return nil
+	}
+
+	var resp *dns.Msg
+	switch p.action {
+	case failureStale:
+		resp = s.getStale(d.Req)
+	case failureStatic:
+		resp = s.genFailureAnswer(d.Req, p.ips)
+	}
+	if resp == nil {
+		return err
+	}
+
+	log.Debug("DNS: %s: upstream servers for %s have failed: %s", host, p.domain, p.action)
+	ctx.modSpan.SetAttr("dns.upstream_failure_policy", p.action)
+	d.Res = resp
+	return nil
+}
+
+// staleKey - get the key of the response in the store of the last successful responses
+func staleKey(q dns.Question) string {
+	return strings.ToLower(q.Name) + "/" + dns.Type(q.Qtype).String()
+}
+
+// saveStale - store the successful response
+func (s *Server) saveStale(resp *dns.Msg) {
+	if len(resp.Question) != 1 ||
+		(resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError) {
+		return
+	}
+
+	key := staleKey(resp.Question[0])
+	s.staleLock.Lock()
+	defer s.staleLock.Unlock()
+	if s.stale == nil {
+		s.stale = map[string]staleEntry{}
+	}
+	_, ok := s.stale[key]
+	if !ok && len(s.stale) >= staleMaxEntries {
+		// remove any entry
+		for k := range s.stale {
+			delete(s.stale, k)
+			break
+		}
+	}
+	s.stale[key] = staleEntry{msg: resp.Copy(), time: time.Now()}
+}
+
+// getStale - get the last successful response to this request
+// Return nil if there's no such response or it's too old.
+func (s *Server) getStale(req *dns.Msg) *dns.Msg {
+	key := staleKey(req.Question[0])
+	s.staleLock.Lock()
+	e, ok := s.stale[key]
+	s.staleLock.Unlock()
+	if !ok || time.Since(e.time) > staleMaxAge {
+		return nil
+	}
+
+	resp := e.msg.Copy()
+	resp.Id = req.Id
+	resp.Question = req.Question
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				rr.Header().Ttl = failureAnswerTTL
+			}
+		}
+	}
+	return resp
+}
+
+// genFailureAnswer - generate the response with the IP addresses of the "static" policy
+// The response to the requests of other types is empty.
+func (s *Server) genFailureAnswer(req *dns.Msg, ips []net.IP) *dns.Msg {
+	resp := s.makeResponse(req)
+	for _, ip := range ips {
+		var rr dns.RR
+		if req.Question[0].Qtype == dns.TypeA && ip.To4() != nil {
+			rr = s.genAAnswer(req, ip.To4())
+		} else if req.Question[0].Qtype == dns.TypeAAAA && ip.To4() == nil {
+			rr = s.genAAAAAnswer(req, ip)
+		} else {
+			continue
+		}
+		rr.Header().Ttl = failureAnswerTTL
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
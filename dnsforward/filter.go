@@ -19,7 +19,8 @@ func (s *Server) beforeRequestHandler(_ *proxy.Proxy, d *proxy.DNSContext) (bool
 
 	if len(d.Req.Question) == 1 {
 		host := strings.TrimSuffix(d.Req.Question[0].Name, ".")
-		if s.access.IsBlockedDomain(host) {
+		if s.access.IsBlockedDomain(host) &&
+			!(s.conf.CaptivePortalMode && isCaptivePortalHost(host)) {
 			log.Tracef("Domain %s is blocked by settings", host)
 			return false, nil
 		}
@@ -100,47 +101,57 @@ func (s *Server) filterDNSRequest(ctx *dnsContext) (*dnsfilter.Result, error) {
 	return &res, err
 }
 
-// If response contains CNAME, A or AAAA records, we apply filtering to each canonical host name or IP address.
+// If response contains CNAME, A, AAAA, SVCB or HTTPS records, we apply filtering to each canonical host name or IP address.
 // If this is a match, we set a new response in d.Res and return.
 func (s *Server) filterDNSResponse(ctx *dnsContext) (*dnsfilter.Result, error) {
 	d := ctx.proxyCtx
 	for _, a := range d.Res.Answer {
-		host := ""
+		hosts := []string{}
 
 		switch v := a.(type) {
 		case *dns.CNAME:
 			log.Debug("DNSFwd: Checking CNAME %s for %s", v.Target, v.Hdr.Name)
-			host = strings.TrimSuffix(v.Target, ".")
+			hosts = append(hosts, strings.TrimSuffix(v.Target, "."))
 
 		case *dns.A:
-			host = v.A.String()
-			log.Debug("DNSFwd: Checking record A (%s) for %s", host, v.Hdr.Name)
+			hosts = append(hosts, v.A.String())
+			log.Debug("DNSFwd: Checking record A (%s) for %s", v.A, v.Hdr.Name)
 
 		case *dns.AAAA:
-			host = v.AAAA.String()
-			log.Debug("DNSFwd: Checking record AAAA (%s) for %s", host, v.Hdr.Name)
+			hosts = append(hosts, v.AAAA.String())
+			log.Debug("DNSFwd: Checking record AAAA (%s) for %s", v.AAAA, v.Hdr.Name)
+
+		case *dns.RFC3597:
+			if !isSVCBType(v.Hdr.Rrtype) {
+				continue
+			}
+			// target name and IP address hints
+			hosts = svcbHosts(v)
+			log.Debug("DNSFwd: Checking record %s %v for %s", dns.Type(v.Hdr.Rrtype), hosts, v.Hdr.Name)
 
 		default:
 			continue
 		}
 
-		s.RLock()
-		// Synchronize access to s.dnsFilter so it won't be suddenly uninitialized while in use.
-		// This could happen after proxy server has been stopped, but its workers are not yet exited.
-		if !s.conf.ProtectionEnabled || s.dnsFilter == nil {
+		for _, host := range hosts {
+			s.RLock()
+			// Synchronize access to s.dnsFilter so it won't be suddenly uninitialized while in use.
+			// This could happen after proxy server has been stopped, but its workers are not yet exited.
+			if !s.conf.ProtectionEnabled || s.dnsFilter == nil {
+				s.RUnlock()
+				continue
+			}
+			res, err := s.dnsFilter.CheckHostRules(host, d.Req.Question[0].Qtype, ctx.setts)
 			s.RUnlock()
-			continue
-		}
-		res, err := s.dnsFilter.CheckHostRules(host, d.Req.Question[0].Qtype, ctx.setts)
-		s.RUnlock()
 
-		if err != nil {
-			return nil, err
+			if err != nil {
+				return nil, err
 
-		} else if res.IsFiltered {
-			d.Res = s.genDNSFilterMessage(d, &res)
-			log.Debug("DNSFwd: Matched %s by response: %s", d.Req.Question[0].Name, host)
-			return &res, nil
+			} else if res.IsFiltered {
+				d.Res = s.genDNSFilterMessage(d, &res)
+				log.Debug("DNSFwd: Matched %s by response: %s", d.Req.Question[0].Name, host)
+				return &res, nil
+			}
 		}
 	}
 
@@ -1,11 +1,13 @@
 package dnsforward
 
 import (
+	"net"
 	"strings"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/tracing"
 	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/log"
@@ -19,12 +21,20 @@ type dnsContext struct {
 	setts                *dnsfilter.RequestFilteringSettings // filtering settings for this client
 	startTime            time.Time
 	result               *dnsfilter.Result
-	origResp             *dns.Msg     // response received from upstream servers.  Set when response is modified by filtering
-	origQuestion         dns.Question // question received from client.  Set when Rewrites are used.
-	err                  error        // error returned from the module
-	protectionEnabled    bool         // filtering is enabled, dnsfilter object is ready
-	responseFromUpstream bool         // response is received from upstream servers
-	origReqDNSSEC        bool         // DNSSEC flag in the original request from user
+	origResp             *dns.Msg          // response received from upstream servers.  Set when response is modified by filtering
+	origQuestion         dns.Question      // question received from client.  Set when Rewrites are used.
+	err                  error             // error returned from the module
+	protectionEnabled    bool              // filtering is enabled, dnsfilter object is ready
+	responseFromUpstream bool              // response is received from upstream servers
+	origReqDNSSEC        bool              // DNSSEC flag in the original request from user
+	captivePortal        bool              // the request is for a captive portal detection host
+	specialUse           *specialUseDomain // the request is for a special-use domain
+	cookie               *dnsCookie        // DNS cookie received from the client
+	cookieVerified       bool              // the client has sent a valid server cookie
+	lookup               *LookupResult     // the request is sent by the lookup tool (not nil): it isn't logged
+
+	span    *tracing.Span // the trace of the request (nil: not traced)
+	modSpan *tracing.Span // the span of the current processing stage
 }
 
 const (
@@ -35,22 +45,56 @@ const (
 
 // handleDNSRequest filters the incoming DNS requests and writes them to the query log
 func (s *Server) handleDNSRequest(_ *proxy.Proxy, d *proxy.DNSContext) error {
+	if !s.beginRequest() {
+		d.Res = s.genRefused(d.Req)
+		return nil
+	}
+	defer s.endRequest()
+
 	ctx := &dnsContext{srv: s, proxyCtx: d}
+	return s.processRequest(ctx)
+}
+
+// processRequest - pass the request through the processing modules
+func (s *Server) processRequest(ctx *dnsContext) error {
+	d := ctx.proxyCtx
 	ctx.result = &dnsfilter.Result{}
 	ctx.startTime = time.Now()
 
+	ctx.span = s.conf.Tracer.NewTrace("dns.request")
+	defer ctx.endSpan()
+	defer ctx.logVerbose()
+	defer ctx.finishResponse()
+
 	type modProcessFunc func(ctx *dnsContext) int
-	mods := []modProcessFunc{
-		processInitial,
-		processInternalIPAddrs,
-		processFilteringBeforeRequest,
-		processUpstream,
-		processDNSSECAfterResponse,
-		processFilteringAfterResponse,
-		processQueryLogsAndStats,
-	}
-	for _, process := range mods {
-		r := process(ctx)
+	mods := []struct {
+		name    string
+		process modProcessFunc
+	}{
+		{"cookies", processCookies},
+		{"mirror", processQueryMirror},
+		{"initial", processInitial},
+		{"qname_access", processQNameAccess},
+		{"minimal_any", processMinimalAny},
+		{"internal_ptr", processInternalIPAddrs},
+		{"captive_portal", processCaptivePortal},
+		{"special_use", processSpecialUse},
+		{"filtering_request", processFilteringBeforeRequest},
+		{"upstream", processUpstream},
+		{"dnssec", processDNSSECAfterResponse},
+		{"filtering_response", processFilteringAfterResponse},
+		{"response_rules", processResponseRules},
+		{"minimal_responses", processMinimalResponses},
+		{"log", processQueryLogsAndStats},
+	}
+	for _, mod := range mods {
+		ctx.modSpan = ctx.span.NewChild("dns." + mod.name)
+		start := time.Now()
+		r := mod.process(ctx)
+		ctx.modSpan.End()
+		if ctx.lookup != nil {
+			ctx.lookup.Stages = append(ctx.lookup.Stages, LookupStage{Name: mod.name, Elapsed: time.Since(start)})
+		}
 		switch r {
 		case resultDone:
 			// continue: call the next filter
@@ -59,6 +103,8 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, d *proxy.DNSContext) error {
 			return nil
 
 		case resultError:
+			ctx.modSpan.SetError(ctx.err)
+			ctx.span.SetError(ctx.err)
 			return ctx.err
 		}
 	}
@@ -69,6 +115,25 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, d *proxy.DNSContext) error {
 	return nil
 }
 
+// endSpan - set the attributes of the request and finish its trace
+func (ctx *dnsContext) endSpan() {
+	if ctx.span == nil {
+		return
+	}
+	d := ctx.proxyCtx
+	if len(d.Req.Question) != 0 {
+		q := d.Req.Question[0]
+		ctx.span.SetAttr("dns.question.name", strings.TrimSuffix(q.Name, "."))
+		ctx.span.SetAttr("dns.question.type", dns.Type(q.Qtype).String())
+	}
+	ctx.span.SetAttr("client.address", ipFromAddr(d.Addr))
+	ctx.span.SetAttr("network.protocol.name", d.Proto)
+	if d.Res != nil {
+		ctx.span.SetAttr("dns.response.code", dns.RcodeToString[d.Res.Rcode])
+	}
+	ctx.span.End()
+}
+
 // Perform initial checks;  process WHOIS & rDNS
 func processInitial(ctx *dnsContext) int {
 	s := ctx.srv
@@ -92,6 +157,21 @@ func processInitial(ctx *dnsContext) int {
 	return resultDone
 }
 
+// Respond with REFUSED if the client may not resolve this name
+func processQNameAccess(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	ip := ipFromAddr(d.Addr)
+	host := strings.TrimSuffix(d.Req.Question[0].Name, ".")
+	if !s.access.IsBlockedQName(ip, d.Proto, host) {
+		return resultDone
+	}
+
+	log.Tracef("Domain %s is not allowed for client %s (%s)", host, ip, d.Proto)
+	d.Res = s.genRefused(d.Req)
+	return resultFinish
+}
+
 func (s *Server) onDHCPLeaseChanged(flags int) {
 	switch flags {
 	case dhcpd.LeaseChangedAdded,
@@ -103,16 +183,19 @@ func (s *Server) onDHCPLeaseChanged(flags int) {
 	}
 
 	m := make(map[string]string)
+	hosts := make(map[string]net.IP)
 	ll := s.dhcpServer.Leases(dhcpd.LeasesAll)
 	for _, l := range ll {
 		if len(l.Hostname) == 0 {
 			continue
 		}
 		m[l.IP.String()] = l.Hostname
+		hosts[strings.ToLower(l.Hostname)] = l.IP
 	}
 	log.Debug("DNS: added %d PTR entries from DHCP", len(m))
 	s.tablePTRLock.Lock()
 	s.tablePTR = m
+	s.tableHost = hosts
 	s.tablePTRLock.Unlock()
 }
 
@@ -177,13 +260,21 @@ func processFilteringBeforeRequest(ctx *dnsContext) int {
 	//  (to prevent from hanging while waiting for unresponsive DNS server to respond).
 
 	var err error
-	ctx.protectionEnabled = s.conf.ProtectionEnabled && s.dnsFilter != nil
+	ctx.protectionEnabled = s.conf.ProtectionEnabled && s.dnsFilter != nil && !ctx.captivePortal
 	if ctx.protectionEnabled {
 		ctx.setts = s.getClientRequestFilteringSettings(d)
 		ctx.result, err = s.filterDNSRequest(ctx)
 	}
 	s.RUnlock()
 
+	if ctx.result != nil && ctx.result.Reason != dnsfilter.NotFilteredNotFound {
+		ctx.modSpan.SetAttr("dns.filter.reason", ctx.result.Reason.String())
+		ctx.modSpan.SetAttr("dns.filter.rule", ctx.result.Rule)
+		if len(ctx.result.CanonName) != 0 {
+			ctx.modSpan.SetAttr("dns.rewrite.cname", ctx.result.CanonName)
+		}
+	}
+
 	if err != nil {
 		ctx.err = err
 		return resultError
@@ -199,6 +290,12 @@ func processUpstream(ctx *dnsContext) int {
 		return resultDone // response is already set - nothing to do
 	}
 
+	if ctx.specialUse != nil && ctx.specialUse.action == specialUseLocal {
+		// the host isn't in the local zone, but the request must never leave it
+		d.Res = s.genNXDomain(d.Req)
+		return resultDone
+	}
+
 	if d.Addr != nil && s.conf.GetCustomUpstreamByClient != nil {
 		clientIP := ipFromAddr(d.Addr)
 		upstreamsConf := s.conf.GetCustomUpstreamByClient(clientIP)
@@ -208,25 +305,43 @@ func processUpstream(ctx *dnsContext) int {
 		}
 	}
 
+	if s.setCacheBypass(d) {
+		log.Debug("DNS: %s: bypassing the cache", d.Req.Question[0].Name)
+		ctx.modSpan.SetAttr("dns.cache_bypass", true)
+	}
+
 	if s.conf.EnableDNSSEC {
 		opt := d.Req.IsEdns0()
 		if opt == nil {
 			log.Debug("DNS: Adding OPT record with DNSSEC flag")
-			d.Req.SetEdns0(4096, true)
+			d.Req.SetEdns0(s.ednsUDPSize(), true)
 		} else if !opt.Do() {
 			opt.SetDo(true)
 		} else {
 			ctx.origReqDNSSEC = true
 		}
 	}
+	s.setEDNSUDPSize(d.Req)
 
 	// request was not filtered so let it be processed further
-	err := s.dnsProxy.Resolve(d)
+	var err error
+	if ctx.specialUse != nil {
+		err = s.resolveSpecialUse(d, ctx.specialUse)
+	} else if ctx.captivePortal && s.conf.CaptivePortalTTL != 0 {
+		err = s.resolveCaptivePortal(d)
+	} else {
+		err = s.resolveWithFailurePolicy(ctx)
+	}
 	if err != nil {
 		ctx.err = err
 		return resultError
 	}
 
+	ctx.modSpan.SetAttr("dns.cache_hit", d.Upstream == nil)
+	if d.Upstream != nil {
+		ctx.modSpan.SetAttr("dns.upstream", d.Upstream.Address())
+	}
+
 	ctx.responseFromUpstream = true
 	return resultDone
 }
@@ -294,6 +409,11 @@ func processFilteringAfterResponse(ctx *dnsContext) int {
 		d.Req.Question[0] = ctx.origQuestion
 		d.Res.Question[0] = ctx.origQuestion
 
+		if isSVCBType(ctx.origQuestion.Qtype) {
+			// the parameters of the canonical name's server don't apply to the original host
+			d.Res.Answer = stripSVCBParams(d.Res.Answer)
+		}
+
 		if len(d.Res.Answer) != 0 {
 			answer := []dns.RR{}
 			answer = append(answer, s.genCNAMEAnswer(d.Req, res.CanonName))
@@ -317,6 +437,8 @@ func processFilteringAfterResponse(ctx *dnsContext) int {
 		}
 		if ctx.result != nil {
 			ctx.origResp = origResp2 // matched by response
+			ctx.modSpan.SetAttr("dns.filter.reason", ctx.result.Reason.String())
+			ctx.modSpan.SetAttr("dns.filter.rule", ctx.result.Rule)
 		} else {
 			ctx.result = &dnsfilter.Result{}
 		}
@@ -0,0 +1,45 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// The responses to the requests of the clients whose internet access is turned off
+const (
+	internetOffDefault  = ""         // the same response as for the blocked hosts (blocking_mode)
+	internetOffRefused  = "refused"  // REFUSED
+	internetOffNXDomain = "nxdomain" // NXDOMAIN
+	internetOffNullIP   = "null_ip"  // 0.0.0.0 or ::, empty response for the other types
+)
+
+// ValidateInternetOffResponse - check the response to the requests of the clients whose internet access is turned off
+func ValidateInternetOffResponse(resp string) error {
+	switch resp {
+	case internetOffDefault, internetOffRefused, internetOffNXDomain, internetOffNullIP:
+		return nil
+	}
+	return fmt.Errorf("internet_off_response: unknown value %q", resp)
+}
+
+// genInternetOffResponse - get the response to the request of the client whose internet access is turned off
+// Return nil if the response for the blocked hosts is used.
+func (s *Server) genInternetOffResponse(m *dns.Msg) *dns.Msg {
+	switch s.conf.InternetOffResponse {
+	case internetOffRefused:
+		return s.genRefused(m)
+	case internetOffNXDomain:
+		return s.genNXDomain(m)
+	case internetOffNullIP:
+		switch m.Question[0].Qtype {
+		case dns.TypeA:
+			return s.genARecord(m, []byte{0, 0, 0, 0})
+		case dns.TypeAAAA:
+			return s.genAAAARecord(m, net.IPv6zero)
+		}
+		return s.makeResponse(m)
+	}
+	return nil
+}
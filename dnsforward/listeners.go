@@ -0,0 +1,105 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Listener - a set of listen addresses with its own access settings
+// The access settings are checked in addition to the global ones.
+type Listener struct {
+	UDPListenAddr *net.UDPAddr // plain DNS over UDP (nil: don't listen)
+	TCPListenAddr *net.TCPAddr // plain DNS over TCP (nil: don't listen)
+	TLSListenAddr *net.TCPAddr // DNS-over-TLS (nil: don't listen)
+
+	AllowedClients    []string // IP addresses and CIDRs of the clients that may use this listener (empty: all)
+	DisallowedClients []string // IP addresses and CIDRs of the clients that may not use this listener
+}
+
+// listener - DNS proxy instance that accepts the requests on the listener's addresses
+// The requests are resolved by the main proxy instance, so the cache is shared.
+type listener struct {
+	conf   Listener
+	access *accessCtx
+	proxy  *proxy.Proxy
+}
+
+// hasListenAddr - return TRUE if the main proxy instance has its own listen addresses
+func (s *Server) hasListenAddr() bool {
+	return s.conf.UDPListenAddr != nil || s.conf.TCPListenAddr != nil || s.conf.TLSListenAddr != nil
+}
+
+// listenersTLS - return TRUE if any of the listeners accepts DNS-over-TLS connections
+func (s *Server) listenersTLS() bool {
+	for _, l := range s.conf.Listeners {
+		if l.TLSListenAddr != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareListeners - create DNS proxy instances for the listeners
+func (s *Server) prepareListeners(proxyConfig proxy.Config) error {
+	s.listeners = nil
+	for i, lc := range s.conf.Listeners {
+		l := &listener{
+			conf:   lc,
+			access: &accessCtx{},
+		}
+		err := l.access.Init(lc.AllowedClients, lc.DisallowedClients, nil)
+		if err != nil {
+			return fmt.Errorf("listener #%d: %s", i, err)
+		}
+
+		c := proxyConfig
+		c.UDPListenAddr = lc.UDPListenAddr
+		c.TCPListenAddr = lc.TCPListenAddr
+		c.TLSListenAddr = nil
+		if proxyConfig.TLSConfig != nil {
+			// DNS-over-TLS is disabled if there's no certificate
+			c.TLSListenAddr = lc.TLSListenAddr
+		}
+		if c.UDPListenAddr == nil && c.TCPListenAddr == nil && c.TLSListenAddr == nil {
+			continue
+		}
+		c.HTTPSListenAddr = nil
+		c.CacheEnabled = false
+		c.BeforeRequestHandler = func(p *proxy.Proxy, d *proxy.DNSContext) (bool, error) {
+			ip := ipFromAddr(d.Addr)
+			if l.access.IsBlockedIP(ip) {
+				log.Tracef("Client IP %s is blocked by listener settings", ip)
+				return false, nil
+			}
+			return s.beforeRequestHandler(p, d)
+		}
+		l.proxy = &proxy.Proxy{Config: c}
+		s.listeners = append(s.listeners, l)
+	}
+	return nil
+}
+
+// startListeners - start listening on the listeners' addresses
+func (s *Server) startListeners() error {
+	for i, l := range s.listeners {
+		err := l.proxy.Start()
+		if err != nil {
+			s.stopListeners()
+			return fmt.Errorf("listener #%d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// stopListeners - stop listening on the listeners' addresses
+func (s *Server) stopListeners() {
+	for i, l := range s.listeners {
+		err := l.proxy.Stop()
+		if err != nil {
+			log.Error("DNS: listener #%d: %s", i, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+)
+
+// LookupStage - the time spent in a processing module
+type LookupStage struct {
+	Name    string
+	Elapsed time.Duration
+}
+
+// LookupResult - the result of the request processed as if it were received from the client
+type LookupResult struct {
+	Response      *dns.Msg         // nil: the request is dropped
+	OrigResponse  *dns.Msg         // the response of upstream servers if it was modified by filtering
+	Result        dnsfilter.Result // filtering verdict
+	AccessBlocked bool             // the client or the host is blocked by access settings:  the request is dropped
+	Upstream      string           // the upstream server that has resolved the request ("": not sent to upstream servers)
+	Cached        bool             // the response is taken from the cache
+	Elapsed       time.Duration
+	Stages        []LookupStage
+}
+
+// Lookup - pass the request through the processing pipeline as if it were received from the client
+// The request is sent over TCP, so the response isn't truncated.  It isn't written to the query log and statistics.
+func (s *Server) Lookup(name string, qtype uint16, clientIP net.IP) (*LookupResult, error) {
+	s.RLock()
+	running := s.isRunning && s.dnsProxy != nil
+	s.RUnlock()
+	if !running {
+		return nil, fmt.Errorf("DNS server isn't running")
+	}
+	if !s.beginRequest() {
+		return nil, fmt.Errorf("DNS server is shutting down")
+	}
+	defer s.endRequest()
+
+	req := &dns.Msg{}
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	d := &proxy.DNSContext{
+		Proto:     proxy.ProtoTCP,
+		Req:       req,
+		Addr:      &net.TCPAddr{IP: clientIP},
+		StartTime: time.Now(),
+	}
+
+	lr := &LookupResult{}
+	ok, _ := s.beforeRequestHandler(nil, d)
+	if !ok {
+		lr.AccessBlocked = true
+		lr.Elapsed = time.Since(d.StartTime)
+		return lr, nil
+	}
+
+	ctx := &dnsContext{srv: s, proxyCtx: d, lookup: lr}
+	err := s.processRequest(ctx)
+	lr.Elapsed = time.Since(d.StartTime)
+	if err != nil {
+		return nil, err
+	}
+
+	lr.Response = d.Res
+	lr.OrigResponse = ctx.origResp
+	if ctx.result != nil {
+		lr.Result = *ctx.result
+	}
+	if d.Upstream != nil {
+		lr.Upstream = d.Upstream.Address()
+	}
+	lr.Cached = ctx.responseFromUpstream && d.Upstream == nil
+	return lr, nil
+}
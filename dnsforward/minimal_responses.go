@@ -0,0 +1,29 @@
+package dnsforward
+
+import "github.com/miekg/dns"
+
+// processMinimalResponses - remove the records that aren't required by the client from the response
+func processMinimalResponses(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	if !s.conf.MinimalResponses || d.Res == nil {
+		return resultDone
+	}
+	minimizeResponse(d.Res)
+	return resultDone
+}
+
+// minimizeResponse - remove the authority and additional sections from the response
+// The authority section of the negative responses is kept:  its SOA record sets the negative caching TTL (RFC 2308).
+// OPT record is kept too.
+func minimizeResponse(m *dns.Msg) {
+	if m.Rcode == dns.RcodeSuccess && len(m.Answer) != 0 {
+		m.Ns = nil
+	}
+
+	opt := m.IsEdns0()
+	m.Extra = nil
+	if opt != nil {
+		m.Extra = append(m.Extra, opt)
+	}
+}
@@ -0,0 +1,191 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// QueryMirrorConfig - the settings of sending a copy of the clients' requests to a secondary resolver
+type QueryMirrorConfig struct {
+	Upstream string   `yaml:"upstream"` // the secondary resolver or collector ("": disabled)
+	Clients  []string `yaml:"clients"`  // IP addresses and CIDR ranges of the clients whose requests are mirrored
+}
+
+const (
+	mirrorQueueSize = 1000 // the maximum number of the requests waiting to be mirrored
+	mirrorWorkers   = 4    // the number of the requests being mirrored at the same time
+)
+
+// queryMirror - the sending of a copy of the clients' requests to a secondary resolver
+// The responses of the secondary resolver are ignored, and the requests are dropped if it's too slow:
+// it never affects the responses to the clients.
+type queryMirror struct {
+	upstream  upstream.Upstream
+	clients   map[string]bool
+	clientNet []net.IPNet
+	dropped   uint64 // the number of the requests dropped because the queue is full
+	queue     chan *dns.Msg
+	closeOnce sync.Once
+}
+
+// newQueryMirror - check the settings and create the mirror
+// Return nil if mirroring is disabled.
+func newQueryMirror(conf QueryMirrorConfig, bootstrap []string, upstreamProxy string) (*queryMirror, error) {
+	if len(conf.Upstream) == 0 {
+		if len(conf.Clients) != 0 {
+			return nil, fmt.Errorf("query_mirror: no upstream")
+		}
+		return nil, nil
+	}
+	if len(conf.Clients) == 0 {
+		return nil, fmt.Errorf("query_mirror: no clients")
+	}
+
+	proxyURL, err := parseUpstreamProxy(upstreamProxy)
+	if err != nil {
+		return nil, err
+	}
+	m := &queryMirror{}
+	err = processIPCIDRArray(&m.clients, &m.clientNet, conf.Clients)
+	if err != nil {
+		return nil, fmt.Errorf("query_mirror: clients: %s", err)
+	}
+	opts := upstream.Options{Bootstrap: bootstrap, Timeout: DefaultTimeout}
+	u, err := upstream.AddressToUpstream(util.NormalizeUpstream(conf.Upstream), opts)
+	if err != nil {
+		return nil, fmt.Errorf("query_mirror: upstream: %s", err)
+	}
+	m.upstream = proxyUpstream(u, proxyURL)
+	return m, nil
+}
+
+// ValidateQueryMirror - check the settings of query mirroring
+func ValidateQueryMirror(conf QueryMirrorConfig) error {
+	_, err := newQueryMirror(conf, nil, "")
+	return err
+}
+
+// prepareQueryMirror - initialize query mirroring and start sending the requests
+func (s *Server) prepareQueryMirror() error {
+	m, err := newQueryMirror(s.conf.QueryMirror, util.NormalizeUpstreams(s.conf.BootstrapDNS), s.conf.UpstreamProxy)
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+	s.stopQueryMirror()
+	s.mirror = m
+	if m != nil {
+		m.start()
+		log.Info("DNS: mirroring the requests of %s to %s",
+			strings.Join(s.conf.QueryMirror.Clients, ", "), m.upstream.Address())
+	}
+	return nil
+}
+
+// stopQueryMirror - stop sending the requests
+func (s *Server) stopQueryMirror() {
+	if s.mirror != nil {
+		s.mirror.close()
+		s.mirror = nil
+	}
+}
+
+func (m *queryMirror) start() {
+	m.queue = make(chan *dns.Msg, mirrorQueueSize)
+	for i := 0; i != mirrorWorkers; i++ {
+		go m.worker(m.queue)
+	}
+}
+
+func (m *queryMirror) close() {
+	m.closeOnce.Do(func() {
+		if m.queue != nil {
+			close(m.queue)
+		}
+	})
+}
+
+func (m *queryMirror) worker(queue chan *dns.Msg) {
+	for req := range queue {
+		_, err := m.upstream.Exchange(req)
+		if err != nil {
+			log.Debug("DNS: query mirror: %s: %s", req.Question[0].Name, err)
+		}
+	}
+}
+
+// matches - return TRUE if the requests of this client are mirrored
+func (m *queryMirror) matches(ip net.IP) bool {
+	if m.clients[ip.String()] {
+		return true
+	}
+	for _, n := range m.clientNet {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorRequest - get the copy of the request to send to the secondary resolver
+// The client's address is passed in EDNS Client Subnet option, so the collector knows where the request came from.
+func mirrorRequest(req *dns.Msg, ip net.IP) *dns.Msg {
+	m := req.Copy()
+	m.Id = dns.Id()
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(4096, false)
+		opt = m.IsEdns0()
+	}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0SUBNET {
+			return m // keep the subnet set by the client
+		}
+	}
+
+	e := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+	if ip4 := ip.To4(); ip4 != nil {
+		e.Family = 1
+		e.SourceNetmask = 32
+		e.Address = ip4
+	} else {
+		e.Family = 2
+		e.SourceNetmask = 128
+		e.Address = ip
+	}
+	opt.Option = append(opt.Option, e)
+	return m
+}
+
+// processQueryMirror - send a copy of the request to the secondary resolver if the client is selected
+func processQueryMirror(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	ip := net.ParseIP(ipFromAddr(d.Addr))
+	if ip == nil {
+		return resultDone
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	m := s.mirror
+	if m == nil || !m.matches(ip) {
+		return resultDone
+	}
+	select {
+	case m.queue <- mirrorRequest(d.Req, ip):
+	default:
+		n := atomic.AddUint64(&m.dropped, 1)
+		if n%mirrorQueueSize == 1 {
+			log.Info("DNS: query mirror: %s is too slow, %d requests have been dropped", m.upstream.Address(), n)
+		}
+	}
+	return resultDone
+}
@@ -23,7 +23,17 @@ func (s *Server) makeResponse(req *dns.Msg) *dns.Msg {
 func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *dnsfilter.Result) *dns.Msg {
 	m := d.Req
 
+	if result.Reason == dnsfilter.FilteredInternetOff {
+		if resp := s.genInternetOffResponse(m); resp != nil {
+			return resp
+		}
+	}
+
 	if m.Question[0].Qtype != dns.TypeA && m.Question[0].Qtype != dns.TypeAAAA {
+		if isSVCBType(m.Question[0].Qtype) && s.isBlockedWithIP(result) {
+			// the client must use A/AAAA records of the blocked host, not the original server's parameters
+			return s.makeResponse(m)
+		}
 		return s.genNXDomain(m)
 	}
 
@@ -32,6 +42,9 @@ func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *dnsfilter.Resu
 		return s.genBlockedHost(m, s.conf.SafeBrowsingBlockHost, d)
 	case dnsfilter.FilteredParental:
 		return s.genBlockedHost(m, s.conf.ParentalBlockHost, d)
+	case dnsfilter.FilteredEncryptedDNS:
+		// the clients fall back to plain DNS only if the host isn't resolved
+		return s.genNXDomain(m)
 	default:
 		// If the query was filtered by "Safe search", dnsfilter also must return
 		// the IP address that must be used in response.
@@ -76,6 +89,28 @@ func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *dnsfilter.Resu
 	}
 }
 
+// isBlockedWithIP - return TRUE if A/AAAA requests for the blocked host are answered with an IP address
+func (s *Server) isBlockedWithIP(result *dnsfilter.Result) bool {
+	switch result.Reason {
+	case dnsfilter.FilteredSafeBrowsing, dnsfilter.FilteredParental:
+		return true
+	case dnsfilter.FilteredEncryptedDNS:
+		return false
+	case dnsfilter.FilteredSafeSearch:
+		if result.IP != nil {
+			return true
+		}
+	}
+
+	switch s.conf.BlockingMode {
+	case "null_ip", "custom_ip":
+		return true
+	case "nxdomain":
+		return false
+	}
+	return result.IP != nil
+}
+
 func (s *Server) genServerFailure(request *dns.Msg) *dns.Msg {
 	resp := dns.Msg{}
 	resp.SetRcode(request, dns.RcodeServerFailure)
@@ -0,0 +1,436 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/utils"
+	"github.com/miekg/dns"
+)
+
+// The limits of the recording of DNS requests
+const (
+	recordDefaultEntries  = 1000
+	recordMaxEntries      = 10000
+	recordMaxSize         = 4 * 1024 * 1024 // the maximum total size of the recorded messages (in bytes)
+	recordDefaultDuration = 10              // in minutes
+	recordMaxDuration     = 60              // in minutes
+)
+
+// recordEntry - the recorded request and its responses
+type recordEntry struct {
+	time     time.Time
+	clientIP net.IP
+	proto    string
+	req      []byte // the request received from the client
+	resp     []byte // the response sent to the client
+	origResp []byte // the response received from upstream servers (nil: the response isn't from upstream servers)
+	upstream string
+	result   dnsfilter.Result
+}
+
+// recorder - the recording of the requests of a client or for a domain, for diagnosing the reports of the breakage
+// The recorded requests may be replayed against the current filtering rules.
+type recorder struct {
+	lock       sync.Mutex
+	active     bool
+	client     net.IP // nil: all clients
+	domain     string // "": all domains
+	redact     bool   // mask the client IP addresses in the API responses
+	until      time.Time
+	duration   uint32 // in minutes
+	maxEntries int
+	size       int // the total size of the recorded messages
+	entries    []recordEntry
+}
+
+// redactMsg - pack the message without EDNS options (client subnet, cookies, padding)
+func redactMsg(m *dns.Msg) []byte {
+	if m == nil {
+		return nil
+	}
+	if opt := m.IsEdns0(); opt != nil && len(opt.Option) != 0 {
+		m = m.Copy()
+		m.IsEdns0().Option = nil
+	}
+	data, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// matches - return TRUE if the request of this client for this host must be recorded
+func (r *recorder) matches(clientIP net.IP, host string, now time.Time) bool {
+	if !r.active {
+		return false
+	}
+	if !now.Before(r.until) {
+		r.active = false
+		log.Info("DNS: recording has been finished: %d requests", len(r.entries))
+		return false
+	}
+	if r.client != nil && !r.client.Equal(clientIP) {
+		return false
+	}
+	return len(r.domain) == 0 || host == r.domain || strings.HasSuffix(host, "."+r.domain)
+}
+
+// record - record the processed request if it matches the settings of the recording
+func (s *Server) record(ctx *dnsContext) {
+	d := ctx.proxyCtx
+	if len(d.Req.Question) != 1 {
+		return
+	}
+	r := &s.recorder
+	clientIP := getIP(d.Addr)
+	host := strings.ToLower(strings.TrimSuffix(d.Req.Question[0].Name, "."))
+	now := time.Now()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.matches(clientIP, host, now) {
+		return
+	}
+
+	e := recordEntry{
+		time:     now,
+		clientIP: clientIP,
+		proto:    d.Proto,
+		req:      redactMsg(d.Req),
+		resp:     redactMsg(d.Res),
+	}
+	if ctx.responseFromUpstream {
+		e.origResp = e.resp
+		if ctx.origResp != nil {
+			e.origResp = redactMsg(ctx.origResp)
+		}
+	}
+	if d.Upstream != nil {
+		e.upstream = d.Upstream.Address()
+	}
+	if ctx.result != nil {
+		e.result = *ctx.result
+	}
+
+	size := len(e.req) + len(e.resp)
+	if ctx.origResp != nil {
+		size += len(e.origResp)
+	}
+	if len(r.entries) >= r.maxEntries || r.size+size > recordMaxSize {
+		r.active = false
+		log.Info("DNS: recording has been finished: the limit is reached: %d requests", len(r.entries))
+		return
+	}
+	r.size += size
+	r.entries = append(r.entries, e)
+}
+
+// replayEntry - filter the recorded request and its upstream response with the current settings and rules
+func (s *Server) replayEntry(e *recordEntry) (dnsfilter.Result, error) {
+	req := &dns.Msg{}
+	err := req.Unpack(e.req)
+	if err != nil || len(req.Question) != 1 {
+		return dnsfilter.Result{}, fmt.Errorf("invalid request")
+	}
+	d := &proxy.DNSContext{
+		Proto:     e.proto,
+		Req:       req,
+		Addr:      &net.UDPAddr{IP: e.clientIP},
+		StartTime: time.Now(),
+	}
+	ctx := &dnsContext{srv: s, proxyCtx: d, startTime: d.StartTime}
+
+	s.RLock()
+	ctx.protectionEnabled = s.conf.ProtectionEnabled && s.dnsFilter != nil
+	var res *dnsfilter.Result
+	if ctx.protectionEnabled {
+		ctx.setts = s.getClientRequestFilteringSettings(d)
+		res, err = s.filterDNSRequest(ctx)
+	}
+	s.RUnlock()
+	if err != nil {
+		return dnsfilter.Result{}, err
+	}
+	if !ctx.protectionEnabled {
+		return dnsfilter.Result{}, nil
+	}
+
+	if d.Res == nil && len(e.origResp) != 0 &&
+		res.Reason != dnsfilter.NotFilteredWhiteList && res.Reason != dnsfilter.ReasonRewrite {
+		resp := &dns.Msg{}
+		if resp.Unpack(e.origResp) == nil {
+			d.Res = resp
+			res2, err := s.filterDNSResponse(ctx)
+			if err != nil {
+				return dnsfilter.Result{}, err
+			}
+			if res2 != nil {
+				res = res2
+			}
+		}
+	}
+	return *res, nil
+}
+
+type recordConfigJSON struct {
+	Client     string `json:"client"`      // the IP address of the client ("": all clients)
+	Domain     string `json:"domain"`      // the domain and its subdomains ("": all domains)
+	Redact     bool   `json:"redact"`      // mask the client IP addresses in the API responses
+	MaxEntries int    `json:"max_entries"` // the maximum number of the recorded requests (0: default)
+	Duration   uint32 `json:"duration"`    // the duration of the recording in minutes (0: default)
+}
+
+type recordStatusJSON struct {
+	recordConfigJSON
+	Active  bool   `json:"active"`
+	Until   string `json:"until"`   // the time when the recording is finished
+	Entries int    `json:"entries"` // the number of the recorded requests
+	Size    int    `json:"size"`    // the total size of the recorded messages
+}
+
+type recordResultJSON struct {
+	Reason   string `json:"reason"`
+	Rule     string `json:"rule"`
+	FilterID int64  `json:"filter_id"`
+}
+
+type recordEntryJSON struct {
+	Time     string           `json:"time"`
+	Client   string           `json:"client"`
+	Proto    string           `json:"proto"`
+	Name     string           `json:"name"`
+	Type     string           `json:"type"`
+	Rcode    string           `json:"rcode"`
+	Upstream string           `json:"upstream"`
+	Result   recordResultJSON `json:"result"`
+
+	// The messages in DNS wire format
+	Request          []byte `json:"request"`
+	Response         []byte `json:"response"`
+	UpstreamResponse []byte `json:"upstream_response"`
+}
+
+type recordReplayJSON struct {
+	Time    string           `json:"time"`
+	Client  string           `json:"client"`
+	Name    string           `json:"name"`
+	Type    string           `json:"type"`
+	Old     recordResultJSON `json:"old"` // the result when the request was recorded
+	New     recordResultJSON `json:"new"` // the result with the current rules
+	Changed bool             `json:"changed"`
+	Error   string           `json:"error,omitempty"`
+}
+
+func recordResultToJSON(res dnsfilter.Result) recordResultJSON {
+	return recordResultJSON{
+		Reason:   res.Reason.String(),
+		Rule:     res.Rule,
+		FilterID: res.FilterID,
+	}
+}
+
+// recordClientString - get the client IP address for the API response
+func recordClientString(ip net.IP, redact bool) string {
+	if !redact {
+		return ip.String()
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(112, 128)).String()
+}
+
+// question - get the name and the type of the recorded request
+func (e *recordEntry) question() (string, string) {
+	req := &dns.Msg{}
+	if req.Unpack(e.req) != nil || len(req.Question) != 1 {
+		return "", ""
+	}
+	q := req.Question[0]
+	return strings.TrimSuffix(q.Name, "."), dns.Type(q.Qtype).String()
+}
+
+func (r *recorder) statusJSON(now time.Time) recordStatusJSON {
+	st := recordStatusJSON{
+		recordConfigJSON: recordConfigJSON{
+			Domain:     r.domain,
+			Redact:     r.redact,
+			MaxEntries: r.maxEntries,
+			Duration:   r.duration,
+		},
+		Active:  r.active && now.Before(r.until),
+		Entries: len(r.entries),
+		Size:    r.size,
+	}
+	if r.client != nil {
+		st.Client = r.client.String()
+	}
+	if !r.until.IsZero() {
+		st.Until = r.until.Format(time.RFC3339)
+	}
+	return st
+}
+
+func writeJSON(r *http.Request, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+	}
+}
+
+// GET /control/dns_record/status
+func (s *Server) handleRecordStatus(w http.ResponseWriter, r *http.Request) {
+	s.recorder.lock.Lock()
+	st := s.recorder.statusJSON(time.Now())
+	s.recorder.lock.Unlock()
+	writeJSON(r, w, st)
+}
+
+// POST /control/dns_record/start
+func (s *Server) handleRecordStart(w http.ResponseWriter, r *http.Request) {
+	req := recordConfigJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	var client net.IP
+	if len(req.Client) != 0 {
+		client = net.ParseIP(req.Client)
+		if client == nil {
+			httpError(r, w, http.StatusBadRequest, "invalid client IP %q", req.Client)
+			return
+		}
+	}
+	domain := strings.ToLower(strings.TrimSuffix(req.Domain, "."))
+	if len(domain) != 0 && utils.IsValidHostname(domain) != nil {
+		httpError(r, w, http.StatusBadRequest, "invalid domain %q", req.Domain)
+		return
+	}
+	if client == nil && len(domain) == 0 {
+		httpError(r, w, http.StatusBadRequest, "client or domain must be specified")
+		return
+	}
+	if req.MaxEntries == 0 {
+		req.MaxEntries = recordDefaultEntries
+	}
+	if req.MaxEntries < 0 || req.MaxEntries > recordMaxEntries {
+		httpError(r, w, http.StatusBadRequest, "max_entries must be 1..%d", recordMaxEntries)
+		return
+	}
+	if req.Duration == 0 {
+		req.Duration = recordDefaultDuration
+	}
+	if req.Duration > recordMaxDuration {
+		httpError(r, w, http.StatusBadRequest, "duration must be 1..%d", recordMaxDuration)
+		return
+	}
+
+	rec := &s.recorder
+	rec.lock.Lock()
+	rec.active = true
+	rec.client = client
+	rec.domain = domain
+	rec.redact = req.Redact
+	rec.until = time.Now().Add(time.Duration(req.Duration) * time.Minute)
+	rec.duration = req.Duration
+	rec.maxEntries = req.MaxEntries
+	rec.size = 0
+	rec.entries = nil
+	st := rec.statusJSON(time.Now())
+	rec.lock.Unlock()
+
+	log.Info("DNS: recording the requests: client: %q, domain: %q, for %d minutes", req.Client, domain, req.Duration)
+	writeJSON(r, w, st)
+}
+
+// POST /control/dns_record/stop
+func (s *Server) handleRecordStop(w http.ResponseWriter, r *http.Request) {
+	rec := &s.recorder
+	rec.lock.Lock()
+	rec.active = false
+	st := rec.statusJSON(time.Now())
+	rec.lock.Unlock()
+	writeJSON(r, w, st)
+}
+
+// GET /control/dns_record/entries
+func (s *Server) handleRecordEntries(w http.ResponseWriter, r *http.Request) {
+	rec := &s.recorder
+	rec.lock.Lock()
+	list := []recordEntryJSON{}
+	for i := range rec.entries {
+		e := &rec.entries[i]
+		name, qtype := e.question()
+		j := recordEntryJSON{
+			Time:     e.time.Format(time.RFC3339Nano),
+			Client:   recordClientString(e.clientIP, rec.redact),
+			Proto:    e.proto,
+			Name:     name,
+			Type:     qtype,
+			Upstream: e.upstream,
+			Result:   recordResultToJSON(e.result),
+
+			Request:          e.req,
+			Response:         e.resp,
+			UpstreamResponse: e.origResp,
+		}
+		resp := &dns.Msg{}
+		if resp.Unpack(e.resp) == nil {
+			j.Rcode = dns.RcodeToString[resp.Rcode]
+		}
+		list = append(list, j)
+	}
+	rec.lock.Unlock()
+	writeJSON(r, w, list)
+}
+
+// POST /control/dns_record/replay
+func (s *Server) handleRecordReplay(w http.ResponseWriter, r *http.Request) {
+	rec := &s.recorder
+	rec.lock.Lock()
+	entries := append([]recordEntry{}, rec.entries...)
+	redact := rec.redact
+	rec.lock.Unlock()
+
+	list := []recordReplayJSON{}
+	changed := 0
+	for i := range entries {
+		e := &entries[i]
+		name, qtype := e.question()
+		j := recordReplayJSON{
+			Time:   e.time.Format(time.RFC3339Nano),
+			Client: recordClientString(e.clientIP, redact),
+			Name:   name,
+			Type:   qtype,
+			Old:    recordResultToJSON(e.result),
+		}
+		res, err := s.replayEntry(e)
+		if err != nil {
+			j.Error = err.Error()
+		} else {
+			j.New = recordResultToJSON(res)
+			j.Changed = j.New != j.Old
+		}
+		if j.Changed {
+			changed++
+		}
+		list = append(list, j)
+	}
+
+	writeJSON(r, w, map[string]interface{}{
+		"total":   len(list),
+		"changed": changed,
+		"entries": list,
+	})
+}
@@ -0,0 +1,275 @@
+package dnsforward
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Which IP addresses to keep if the host has both IPv4 and IPv6 addresses
+const (
+	preferIPv4 = "ipv4" // strip AAAA records if the host has A records
+	preferIPv6 = "ipv6" // strip A records if the host has AAAA records
+)
+
+// ResponseRule - the changes to the responses for the domain and its subdomains
+// Domain "." matches all hosts.
+type ResponseRule struct {
+	Domain     string   `yaml:"domain"`
+	MaxAnswers int      `yaml:"max_answers"` // the maximum number of answer records except CNAME (0: no limit)
+	PreferIP   string   `yaml:"prefer_ip"`   // "ipv4", "ipv6" or "" (keep all addresses)
+	StripTypes []string `yaml:"strip_types"` // the types of records to remove (e.g. "HTTPS")
+	Sort       bool     `yaml:"sort"`        // sort answer records: CNAME first, then by type and value
+}
+
+type responseRule struct {
+	domain     string // lower-case, without the trailing dot;  empty for "."
+	maxAnswers int
+	preferIP   string
+	stripTypes map[uint16]bool
+	sort       bool
+}
+
+// newResponseRules - parse the response rules
+func newResponseRules(list []ResponseRule) ([]responseRule, error) {
+	var rules []responseRule
+	names := map[string]bool{}
+	for i, r := range list {
+		rr := responseRule{
+			domain:     strings.ToLower(strings.TrimSuffix(r.Domain, ".")),
+			maxAnswers: r.MaxAnswers,
+			preferIP:   r.PreferIP,
+			stripTypes: map[uint16]bool{},
+			sort:       r.Sort,
+		}
+		_, ok := dns.IsDomainName(rr.domain)
+		if (len(rr.domain) == 0 && r.Domain != ".") || (len(rr.domain) != 0 && !ok) {
+			return nil, fmt.Errorf("response_rules[%d]: invalid domain %q", i, r.Domain)
+		}
+		if names[rr.domain] {
+			return nil, fmt.Errorf("response_rules[%d]: duplicate domain %q", i, r.Domain)
+		}
+		names[rr.domain] = true
+
+		if r.MaxAnswers < 0 {
+			return nil, fmt.Errorf("response_rules[%d]: invalid max_answers %d", i, r.MaxAnswers)
+		}
+
+		switch r.PreferIP {
+		case "", preferIPv4, preferIPv6:
+		default:
+			return nil, fmt.Errorf("response_rules[%d]: invalid prefer_ip %q", i, r.PreferIP)
+		}
+
+		for _, t := range r.StripTypes {
+			qtype, ok := parseRRType(t)
+			if !ok || qtype == dns.TypeOPT {
+				return nil, fmt.Errorf("response_rules[%d]: invalid record type %q", i, t)
+			}
+			rr.stripTypes[qtype] = true
+		}
+
+		rules = append(rules, rr)
+	}
+	return rules, nil
+}
+
+// parseRRType - get the record type by its name (e.g. "A", "HTTPS" or "TYPE65")
+func parseRRType(s string) (uint16, bool) {
+	s = strings.ToUpper(s)
+	switch s {
+	case "SVCB":
+		return typeSVCB, true
+	case "HTTPS":
+		return typeHTTPS, true
+	}
+
+	t, ok := dns.StringToType[s]
+	if ok {
+		return t, true
+	}
+	if !strings.HasPrefix(s, "TYPE") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[len("TYPE"):], 10, 16)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return uint16(n), true
+}
+
+// ValidateResponseRules - check the response rules
+func ValidateResponseRules(list []ResponseRule) error {
+	_, err := newResponseRules(list)
+	return err
+}
+
+// prepareResponseRules - initialize response rules
+func (s *Server) prepareResponseRules() error {
+	var err error
+	s.responseRules, err = newResponseRules(s.conf.ResponseRules)
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+	return nil
+}
+
+// findResponseRule - get the response rule of the domain this host belongs to
+// The most specific domain wins.
+func (s *Server) findResponseRule(host string) *responseRule {
+	host = strings.ToLower(host)
+	var found *responseRule
+	for i := range s.responseRules {
+		r := &s.responseRules[i]
+		if len(r.domain) != 0 && host != r.domain && !strings.HasSuffix(host, "."+r.domain) {
+			continue
+		}
+		if found == nil || len(r.domain) > len(found.domain) {
+			found = r
+		}
+	}
+	return found
+}
+
+// Apply the response rule of the host's domain
+func processResponseRules(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	if d.Res == nil || len(d.Res.Question) == 0 {
+		return resultDone
+	}
+
+	host := strings.TrimSuffix(d.Req.Question[0].Name, ".")
+	s.RLock()
+	r := s.findResponseRule(host)
+	s.RUnlock()
+	if r == nil {
+		return resultDone
+	}
+
+	if len(r.stripTypes) != 0 {
+		d.Res.Answer = stripRecords(d.Res.Answer, r.stripTypes)
+		d.Res.Ns = stripRecords(d.Res.Ns, r.stripTypes)
+		d.Res.Extra = stripRecords(d.Res.Extra, r.stripTypes)
+	}
+
+	if len(r.preferIP) != 0 {
+		d.Res.Answer = s.preferIP(ctx, r.preferIP)
+	}
+
+	if r.sort {
+		sortAnswer(d.Res.Answer)
+	}
+
+	if r.maxAnswers != 0 {
+		d.Res.Answer = limitAnswer(d.Res.Answer, r.maxAnswers)
+	}
+
+	log.Tracef("DNS: %s: applied response rule for %q", host, r.domain)
+	return resultDone
+}
+
+// stripRecords - remove the records of these types
+func stripRecords(rrs []dns.RR, types map[uint16]bool) []dns.RR {
+	result := []dns.RR{}
+	for _, rr := range rrs {
+		if !types[rr.Header().Rrtype] {
+			result = append(result, rr)
+		}
+	}
+	return result
+}
+
+// preferIP - remove the records with the IP addresses of the other family if the host has preferred addresses
+func (s *Server) preferIP(ctx *dnsContext, prefer string) []dns.RR {
+	d := ctx.proxyCtx
+	keep, strip := dns.TypeA, dns.TypeAAAA
+	if prefer == preferIPv6 {
+		keep, strip = dns.TypeAAAA, dns.TypeA
+	}
+
+	if !hasRecords(d.Res.Answer, strip) {
+		return d.Res.Answer
+	}
+	if !hasRecords(d.Res.Answer, keep) {
+		// e.g. the response to AAAA request:  check whether the host has A records
+		if !ctx.responseFromUpstream || !s.hostHasRecords(d, keep) {
+			return d.Res.Answer
+		}
+	}
+	return stripRecords(d.Res.Answer, map[uint16]bool{strip: true})
+}
+
+// hasRecords - return TRUE if there are records of this type
+func hasRecords(rrs []dns.RR, rrtype uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// hostHasRecords - resolve the requested host for the records of another type
+// The request isn't filtered and isn't written to query log.
+func (s *Server) hostHasRecords(d *proxy.DNSContext, qtype uint16) bool {
+	req := d.Req.Copy()
+	req.Id = dns.Id()
+	req.Question[0].Qtype = qtype
+	ctx := &proxy.DNSContext{
+		Proto:                d.Proto,
+		Addr:                 d.Addr,
+		Req:                  req,
+		StartTime:            time.Now(),
+		CustomUpstreamConfig: d.CustomUpstreamConfig,
+	}
+	err := s.dnsProxy.Resolve(ctx)
+	if err != nil {
+		log.Debug("DNS: %s: %s", req.Question[0].Name, err)
+		return false
+	}
+	return hasRecords(ctx.Res.Answer, qtype)
+}
+
+// sortAnswer - move CNAME records to the beginning keeping their order, sort other records by type and value
+func sortAnswer(rrs []dns.RR) {
+	sort.SliceStable(rrs, func(i, j int) bool {
+		a, b := rrs[i], rrs[j]
+		ta, tb := a.Header().Rrtype, b.Header().Rrtype
+		if ta == dns.TypeCNAME || tb == dns.TypeCNAME {
+			return ta == dns.TypeCNAME && tb != dns.TypeCNAME
+		}
+		if ta != tb {
+			return ta < tb
+		}
+		switch v := a.(type) {
+		case *dns.A:
+			return bytes.Compare(v.A.To4(), b.(*dns.A).A.To4()) < 0
+		case *dns.AAAA:
+			return bytes.Compare(v.AAAA, b.(*dns.AAAA).AAAA) < 0
+		}
+		return a.String() < b.String()
+	})
+}
+
+// limitAnswer - keep CNAME records and the first n records of other types
+func limitAnswer(rrs []dns.RR, n int) []dns.RR {
+	result := []dns.RR{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeCNAME {
+			if n == 0 {
+				continue
+			}
+			n--
+		}
+		result = append(result, rr)
+	}
+	return result
+}
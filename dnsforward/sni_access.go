@@ -0,0 +1,110 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/utils"
+)
+
+// ValidateServerNameAccess - check the allowed server names and ClientID patterns
+func ValidateServerNameAccess(names, clientIDs []string) error {
+	hasWildcard := false
+	for _, n := range names {
+		h := n
+		if isWildcard(n) {
+			h = n[2:]
+			hasWildcard = true
+		}
+		if utils.IsValidHostname(h) != nil {
+			return fmt.Errorf("invalid server name %q", n)
+		}
+	}
+
+	for _, id := range clientIDs {
+		_, err := path.Match(id, "")
+		if len(id) == 0 || err != nil || strings.IndexByte(id, '.') >= 0 {
+			return fmt.Errorf("invalid ClientID pattern %q", id)
+		}
+	}
+	if len(clientIDs) != 0 && !hasWildcard {
+		return fmt.Errorf("ClientID requires a wildcard server name, e.g. \"*.dns.example.org\"")
+	}
+	return nil
+}
+
+// matchServerName - check the server name presented by the client against the allowed names
+// Return ClientID (the first label of the name matching a wildcard, e.g. "laptop" for "laptop.dns.example.org")
+// and FALSE if the name isn't allowed.
+// All names are allowed if the lists are empty.
+func matchServerName(names, clientIDs []string, serverName string) (string, bool) {
+	if len(names) == 0 && len(clientIDs) == 0 {
+		return "", true
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if len(host) == 0 {
+		return "", false
+	}
+
+	clientID := ""
+	matched := false
+	for _, n := range names {
+		n = strings.ToLower(n)
+		if !isWildcard(n) {
+			if host == n {
+				matched = true
+				break
+			}
+			continue
+		}
+		if matchDomainWildcard(host, n) {
+			label := host[:len(host)-len(n)+1]
+			if strings.IndexByte(label, '.') < 0 {
+				clientID = label
+				matched = true
+				break
+			}
+		}
+	}
+	if !matched && len(names) != 0 {
+		return "", false
+	}
+
+	if len(clientIDs) == 0 {
+		return clientID, true
+	}
+	for _, id := range clientIDs {
+		ok, _ := path.Match(strings.ToLower(id), clientID)
+		if ok && len(clientID) != 0 {
+			return clientID, true
+		}
+	}
+	return "", false
+}
+
+// checkServerName - return FALSE if the server name isn't allowed by settings
+func (s *Server) checkServerName(serverName string) bool {
+	clientID, ok := matchServerName(s.conf.AllowedServerNames, s.conf.AllowedClientIDs, serverName)
+	if len(clientID) != 0 {
+		log.Debug("DNS: TLS: ClientID: %s", clientID)
+	}
+	return ok
+}
+
+// dohServerName - get the server name from DoH request:
+// SNI or, for unencrypted DoH behind a reverse proxy, Host header
+func dohServerName(r *http.Request) string {
+	if r.TLS != nil {
+		return r.TLS.ServerName
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return r.Host
+	}
+	return host
+}
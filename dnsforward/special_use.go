@@ -0,0 +1,176 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// The ways to handle the requests for special-use domains
+const (
+	specialUseNXDomain = "nxdomain" // respond with NXDOMAIN
+	specialUseForward  = "forward"  // send the request to the specified upstream servers only
+	specialUseLocal    = "local"    // resolve from the local zone, never forward the request
+)
+
+// SpecialUseDomain - the handling of a special-use domain (e.g. "onion" or "home.arpa") and its subdomains
+type SpecialUseDomain struct {
+	Domain    string   `yaml:"domain"`
+	Action    string   `yaml:"action"`    // "nxdomain", "forward" or "local"
+	Upstreams []string `yaml:"upstreams"` // upstream servers for "forward"
+}
+
+type specialUseDomain struct {
+	domain    string // lower-case, without the trailing dot
+	action    string
+	upstreams []upstream.Upstream
+}
+
+// newSpecialUseDomains - parse the special-use domains settings
+func newSpecialUseDomains(list []SpecialUseDomain, bootstrap []string, upstreamProxy string) ([]specialUseDomain, error) {
+	proxyURL, err := parseUpstreamProxy(upstreamProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []specialUseDomain
+	names := map[string]bool{}
+	for i, d := range list {
+		su := specialUseDomain{
+			domain: strings.ToLower(strings.TrimSuffix(d.Domain, ".")),
+			action: d.Action,
+		}
+		_, ok := dns.IsDomainName(su.domain)
+		if len(su.domain) == 0 || !ok {
+			return nil, fmt.Errorf("special_use_domains[%d]: invalid domain %q", i, d.Domain)
+		}
+		if names[su.domain] {
+			return nil, fmt.Errorf("special_use_domains[%d]: duplicate domain %q", i, d.Domain)
+		}
+		names[su.domain] = true
+
+		switch d.Action {
+		case specialUseNXDomain, specialUseLocal:
+			if len(d.Upstreams) != 0 {
+				return nil, fmt.Errorf("special_use_domains[%d]: upstreams are used with action %q only",
+					i, specialUseForward)
+			}
+
+		case specialUseForward:
+			if len(d.Upstreams) == 0 {
+				return nil, fmt.Errorf("special_use_domains[%d]: no upstreams", i)
+			}
+			opts := upstream.Options{Bootstrap: bootstrap, Timeout: DefaultTimeout}
+			for _, addr := range d.Upstreams {
+				u, err := upstream.AddressToUpstream(util.NormalizeUpstream(addr), opts)
+				if err != nil {
+					return nil, fmt.Errorf("special_use_domains[%d]: %s", i, err)
+				}
+				su.upstreams = append(su.upstreams, proxyUpstream(u, proxyURL))
+			}
+
+		default:
+			return nil, fmt.Errorf("special_use_domains[%d]: invalid action %q", i, d.Action)
+		}
+
+		domains = append(domains, su)
+	}
+	return domains, nil
+}
+
+// ValidateSpecialUseDomains - check the special-use domains settings
+func ValidateSpecialUseDomains(list []SpecialUseDomain) error {
+	_, err := newSpecialUseDomains(list, nil, "")
+	return err
+}
+
+// findSpecialUse - get the settings of the special-use domain this host belongs to
+// The most specific domain wins.
+func (s *Server) findSpecialUse(host string) *specialUseDomain {
+	host = strings.ToLower(host)
+	var found *specialUseDomain
+	for i := range s.specialUse {
+		su := &s.specialUse[i]
+		if host != su.domain && !strings.HasSuffix(host, "."+su.domain) {
+			continue
+		}
+		if found == nil || len(su.domain) > len(found.domain) {
+			found = su
+		}
+	}
+	return found
+}
+
+// lookupLocalHost - get the IP address of "hostname.domain" from DHCP leases
+func (s *Server) lookupLocalHost(host string, domain string) net.IP {
+	name := strings.TrimSuffix(strings.ToLower(host), "."+domain)
+	if name == host || strings.Contains(name, ".") {
+		return nil
+	}
+
+	s.tablePTRLock.Lock()
+	ip := s.tableHost[name]
+	s.tablePTRLock.Unlock()
+	return ip
+}
+
+// Apply the handling policy for special-use domains
+func processSpecialUse(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	if d.Res != nil {
+		return resultDone
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(d.Req.Question[0].Name, "."))
+	s.RLock()
+	su := s.findSpecialUse(host)
+	s.RUnlock()
+	if su == nil {
+		return resultDone
+	}
+
+	ctx.specialUse = su
+	ctx.modSpan.SetAttr("dns.special_use.action", su.action)
+	switch su.action {
+	case specialUseNXDomain:
+		log.Debug("DNS: %s: special-use domain %s: NXDOMAIN", host, su.domain)
+		d.Res = s.genNXDomain(d.Req)
+
+	case specialUseLocal:
+		ip := s.lookupLocalHost(host, su.domain)
+		if ip != nil {
+			log.Debug("DNS: %s: special-use domain %s: %s", host, su.domain, ip)
+			d.Res = s.genResponseWithIP(d.Req, ip)
+		}
+	}
+	return resultDone
+}
+
+// resolveSpecialUse - send the request to the upstream servers of the special-use domain
+func (s *Server) resolveSpecialUse(d *proxy.DNSContext, su *specialUseDomain) error {
+	resp, u, err := upstream.ExchangeParallel(su.upstreams, d.Req)
+	if err != nil {
+		return err
+	}
+	d.Res = resp
+	d.Upstream = u
+	return nil
+}
+
+// prepareSpecialUse - initialize special-use domains handling
+func (s *Server) prepareSpecialUse() error {
+	var err error
+	s.specialUse, err = newSpecialUseDomains(s.conf.SpecialUseDomains,
+		util.NormalizeUpstreams(s.conf.BootstrapDNS), s.conf.UpstreamProxy)
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+	return nil
+}
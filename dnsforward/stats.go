@@ -15,6 +15,10 @@ import (
 
 // Write Stats data and logs
 func processQueryLogsAndStats(ctx *dnsContext) int {
+	if ctx.lookup != nil {
+		return resultDone // the requests of the lookup tool aren't logged or counted
+	}
+
 	elapsed := time.Since(ctx.startTime)
 	s := ctx.srv
 	d := ctx.proxyCtx
@@ -53,11 +57,86 @@ func processQueryLogsAndStats(ctx *dnsContext) int {
 	}
 
 	s.updateStats(d, elapsed, *ctx.result)
+	if s.dnsFilter != nil {
+		s.dnsFilter.CountRuleHit(*ctx.result)
+	}
 	s.RUnlock()
 
+	if s.conf.OnDNSResponse != nil && len(msg.Question) != 0 {
+		s.conf.OnDNSResponse(queryInfo(ctx, elapsed))
+	}
+	s.record(ctx)
+
+	s.countersLock.Lock()
+	s.counters.Requests++
+	if ctx.result.IsFiltered {
+		s.counters.Blocked++
+	}
+	if ctx.responseFromUpstream && d.Upstream == nil && d.Res != nil { // resolved without upstream servers
+		s.counters.CacheHits++
+	}
+	s.countersLock.Unlock()
+
 	return resultDone
 }
 
+// QueryInfo - information about the processed request
+type QueryInfo struct {
+	Time       time.Time // when the request was received
+	ClientIP   net.IP
+	ClientPort int
+	Proto      string // "udp", "tcp", "tls" or "https"
+	Host       string // the question name without the last dot
+	QType      uint16
+	Rcode      int // -1: no response
+	ReqSize    int // the size of the request message (in bytes)
+	RespSize   int // the size of the response message (in bytes)
+	Filtered   bool
+	Elapsed    time.Duration
+}
+
+func queryInfo(ctx *dnsContext, elapsed time.Duration) QueryInfo {
+	d := ctx.proxyCtx
+	q := d.Req.Question[0]
+	info := QueryInfo{
+		Time:     ctx.startTime,
+		ClientIP: getIP(d.Addr),
+		Proto:    d.Proto,
+		Host:     strings.TrimSuffix(strings.ToLower(q.Name), "."),
+		QType:    q.Qtype,
+		Rcode:    -1,
+		ReqSize:  d.Req.Len(),
+		Filtered: ctx.result.IsFiltered,
+		Elapsed:  elapsed,
+	}
+	switch addr := d.Addr.(type) {
+	case *net.UDPAddr:
+		info.ClientPort = addr.Port
+	case *net.TCPAddr:
+		info.ClientPort = addr.Port
+	}
+	if d.Res != nil {
+		info.Rcode = d.Res.Rcode
+		info.RespSize = d.Res.Len()
+	}
+	return info
+}
+
+// Counters - the monotonic counters of the processed requests since the server was created
+type Counters struct {
+	Requests  uint64
+	Blocked   uint64
+	CacheHits uint64 // responses from the cache
+	Coalesced uint64 // responses to the identical requests that were being resolved
+}
+
+// GetCounters - get the current values of the counters
+func (s *Server) GetCounters() Counters {
+	s.countersLock.Lock()
+	defer s.countersLock.Unlock()
+	return s.counters
+}
+
 func (s *Server) updateStats(d *proxy.DNSContext, elapsed time.Duration, res dnsfilter.Result) {
 	if s.stats == nil {
 		return
@@ -91,6 +170,10 @@ func (s *Server) updateStats(d *proxy.DNSContext, elapsed time.Duration, res dns
 	case dnsfilter.FilteredInvalid:
 		fallthrough
 	case dnsfilter.FilteredBlockedService:
+		fallthrough
+	case dnsfilter.FilteredEncryptedDNS:
+		fallthrough
+	case dnsfilter.FilteredInternetOff:
 		e.Result = stats.RFiltered
 	}
 
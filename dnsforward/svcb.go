@@ -0,0 +1,182 @@
+package dnsforward
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// SVCB and HTTPS record types (RFC 9460)
+// Our version of miekg/dns doesn't know them, so these records are parsed from *dns.RFC3597.
+const (
+	typeSVCB  = 64
+	typeHTTPS = 65
+)
+
+// SvcParamKeys
+const (
+	svcParamMandatory     = 0
+	svcParamALPN          = 1
+	svcParamNoDefaultALPN = 2
+	svcParamIPv4Hint      = 4
+	svcParamECH           = 5
+	svcParamIPv6Hint      = 6
+)
+
+// The parameters that describe the original server and must not be used with another one:
+// its protocols, addresses and ECH configuration.
+// "mandatory" is removed too because it may refer to the removed parameters.
+var svcParamsStrip = map[uint16]bool{
+	svcParamMandatory:     true,
+	svcParamALPN:          true,
+	svcParamNoDefaultALPN: true,
+	svcParamIPv4Hint:      true,
+	svcParamECH:           true,
+	svcParamIPv6Hint:      true,
+}
+
+type svcParam struct {
+	key   uint16
+	value []byte
+}
+
+// svcbRecord - RDATA of SVCB or HTTPS record
+type svcbRecord struct {
+	priority uint16
+	target   string // FQDN
+	params   []svcParam
+}
+
+// isSVCBType - return TRUE if this is SVCB or HTTPS record type
+func isSVCBType(t uint16) bool {
+	return t == typeSVCB || t == typeHTTPS
+}
+
+// parseSVCB - parse RDATA of SVCB or HTTPS record
+func parseSVCB(rr *dns.RFC3597) (*svcbRecord, error) {
+	data, err := hex.DecodeString(rr.Rdata)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 3 {
+		return nil, fmt.Errorf("svcb: too short")
+	}
+
+	r := &svcbRecord{}
+	r.priority = binary.BigEndian.Uint16(data)
+	var off int
+	r.target, off, err = dns.UnpackDomainName(data, 2)
+	if err != nil {
+		return nil, fmt.Errorf("svcb: target: %s", err)
+	}
+
+	for off != len(data) {
+		if len(data)-off < 4 {
+			return nil, fmt.Errorf("svcb: invalid parameter")
+		}
+		p := svcParam{key: binary.BigEndian.Uint16(data[off:])}
+		n := int(binary.BigEndian.Uint16(data[off+2:]))
+		off += 4
+		if len(data)-off < n {
+			return nil, fmt.Errorf("svcb: invalid parameter %d", p.key)
+		}
+		p.value = data[off : off+n]
+		off += n
+		r.params = append(r.params, p)
+	}
+	return r, nil
+}
+
+// pack - create SVCB or HTTPS record with this RDATA
+func (r *svcbRecord) pack(hdr dns.RR_Header) (*dns.RFC3597, error) {
+	data := make([]byte, 2+255)
+	binary.BigEndian.PutUint16(data, r.priority)
+	off, err := dns.PackDomainName(r.target, data, 2, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("svcb: target: %s", err)
+	}
+	data = data[:off]
+
+	for _, p := range r.params {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint16(b, p.key)
+		binary.BigEndian.PutUint16(b[2:], uint16(len(p.value)))
+		data = append(data, b...)
+		data = append(data, p.value...)
+	}
+
+	return &dns.RFC3597{Hdr: hdr, Rdata: hex.EncodeToString(data)}, nil
+}
+
+// hosts - get the target name and the IP address hints
+func (r *svcbRecord) hosts() []string {
+	var hosts []string
+	if r.target != "." {
+		hosts = append(hosts, strings.TrimSuffix(r.target, "."))
+	}
+
+	for _, p := range r.params {
+		size := 0
+		switch p.key {
+		case svcParamIPv4Hint:
+			size = net.IPv4len
+		case svcParamIPv6Hint:
+			size = net.IPv6len
+		default:
+			continue
+		}
+		for i := 0; i+size <= len(p.value); i += size {
+			hosts = append(hosts, net.IP(p.value[i:i+size]).String())
+		}
+	}
+	return hosts
+}
+
+// svcbHosts - get the hosts that must be checked by filtering rules
+func svcbHosts(rr *dns.RFC3597) []string {
+	r, err := parseSVCB(rr)
+	if err != nil {
+		log.Debug("DNSFwd: %s: %s", rr.Hdr.Name, err)
+		return nil
+	}
+	return r.hosts()
+}
+
+// stripSVCBParams - remove the parameters describing the original server from SVCB and HTTPS records
+// The records that can't be parsed are removed.
+func stripSVCBParams(answer []dns.RR) []dns.RR {
+	result := []dns.RR{}
+	for _, a := range answer {
+		v, ok := a.(*dns.RFC3597)
+		if !ok || !isSVCBType(v.Hdr.Rrtype) {
+			result = append(result, a)
+			continue
+		}
+
+		r, err := parseSVCB(v)
+		if err != nil {
+			log.Debug("DNSFwd: %s: %s", v.Hdr.Name, err)
+			continue
+		}
+		params := []svcParam{}
+		for _, p := range r.params {
+			if !svcParamsStrip[p.key] {
+				params = append(params, p)
+			}
+		}
+		r.params = params
+
+		rr, err := r.pack(v.Hdr)
+		if err != nil {
+			log.Debug("DNSFwd: %s: %s", v.Hdr.Name, err)
+			continue
+		}
+		result = append(result, rr)
+	}
+	return result
+}
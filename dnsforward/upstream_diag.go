@@ -0,0 +1,203 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// The DNSSEC-signed domain used to check DNSSEC and ECS support
+const diagProbeHost = "example.com."
+
+// The client subnet sent in the ECS probe (TEST-NET-3)
+var diagECSSubnet = net.IPv4(203, 0, 113, 0)
+
+// upstreamDiagnostics - the result of the detailed check of an upstream server
+type upstreamDiagnostics struct {
+	Status   string `json:"status"`   // "OK" or the error
+	Protocol string `json:"protocol"` // "udp", "tcp", "tls", "https", "quic" or "dnscrypt"
+	RTT      int    `json:"rtt_ms"`   // the time of the test request (in milliseconds)
+
+	DNSSEC          bool `json:"dnssec"`           // RRSIG records are returned for the requests with DO flag
+	DNSSECValidated bool `json:"dnssec_validated"` // the server sets AD flag:  it validates the responses
+
+	// "supported": the server returns ECS option in the response;  "ignored": it doesn't
+	ECS      string `json:"ecs"`
+	ECSScope int    `json:"ecs_scope"` // the scope prefix length of the response
+
+	EDNSUDPSize int `json:"edns_udp_size"` // the UDP payload size advertised by the server (0: EDNS isn't supported)
+
+	ProbeError string `json:"probe_error,omitempty"` // the error of DNSSEC or ECS probe
+}
+
+// upstreamProtocol - get the name of the upstream server's protocol
+func upstreamProtocol(addr string) string {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return "udp"
+	}
+	switch scheme := addr[:i]; scheme {
+	case "sdns":
+		return "dnscrypt"
+	default:
+		return scheme
+	}
+}
+
+// diagnoseUpstreams - check the upstream servers in parallel
+func diagnoseUpstreams(list []string, bootstrap []string, upstreamProxy string) map[string]upstreamDiagnostics {
+	result := map[string]upstreamDiagnostics{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, host := range list {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			d := diagnoseUpstream(host, bootstrap, upstreamProxy)
+			lock.Lock()
+			result[host] = d
+			lock.Unlock()
+		}(host)
+	}
+	wg.Wait()
+	return result
+}
+
+// diagnoseUpstream - check that the upstream server works and detect its features
+func diagnoseUpstream(input string, bootstrap []string, upstreamProxy string) upstreamDiagnostics {
+	d := upstreamDiagnostics{}
+	u, addr, err := newCheckUpstream(input, bootstrap, upstreamProxy)
+	if err != nil {
+		d.Status = err.Error()
+		return d
+	}
+	if u == nil {
+		d.Status = "OK" // the server isn't checked
+		return d
+	}
+	d.Protocol = upstreamProtocol(addr)
+	d.probe(u, addr)
+	return d
+}
+
+// probe - send the test requests to the upstream server
+func (d *upstreamDiagnostics) probe(u upstream.Upstream, addr string) {
+	start := time.Now()
+	err := checkUpstreamAnswer(u, addr)
+	d.RTT = int(time.Since(start) / time.Millisecond)
+	if err != nil {
+		d.Status = err.Error()
+		return
+	}
+	d.Status = "OK"
+
+	// DNSSEC and EDNS
+	req := &dns.Msg{}
+	req.SetQuestion(diagProbeHost, dns.TypeA)
+	req.SetEdns0(4096, true)
+	resp, err := u.Exchange(req)
+	if err != nil {
+		d.ProbeError = fmt.Sprintf("DNSSEC: %s", err)
+		return
+	}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			d.DNSSEC = true
+		}
+	}
+	d.DNSSECValidated = resp.AuthenticatedData
+	if opt := resp.IsEdns0(); opt != nil {
+		d.EDNSUDPSize = int(opt.UDPSize())
+	}
+
+	// ECS
+	req = &dns.Msg{}
+	req.SetQuestion(diagProbeHost, dns.TypeA)
+	req.SetEdns0(4096, false)
+	req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       diagECSSubnet,
+	})
+	resp, err = u.Exchange(req)
+	if err != nil {
+		d.ProbeError = fmt.Sprintf("ECS: %s", err)
+		return
+	}
+	d.ECS = "ignored"
+	if opt := resp.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				d.ECS = "supported"
+				d.ECSScope = int(ecs.SourceScope)
+			}
+		}
+	}
+	log.Debug("DNS %s: %+v", addr, *d)
+}
+
+// newCheckUpstream - create the upstream to check
+// Return nil if the server isn't checked (e.g. a domain-specific upstream).
+func newCheckUpstream(input string, bootstrap []string, upstreamProxy string) (upstream.Upstream, string, error) {
+	// separate upstream from domains list
+	input, defaultUpstream, err := separateUpstream(input)
+	if err != nil {
+		return nil, "", fmt.Errorf("wrong upstream format: %s", err)
+	}
+
+	// No need to check this DNS server
+	if input == "#" || !defaultUpstream {
+		return nil, "", nil
+	}
+
+	if _, err := validateUpstream(input); err != nil {
+		return nil, "", fmt.Errorf("wrong upstream format: %s", err)
+	}
+
+	if len(bootstrap) == 0 {
+		bootstrap = defaultBootstrap
+	}
+	input = util.NormalizeUpstream(input)
+	bootstrap = util.NormalizeUpstreams(bootstrap)
+
+	u, err := upstream.AddressToUpstream(input, upstream.Options{Bootstrap: bootstrap, Timeout: DefaultTimeout})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to choose upstream for %s: %s", input, err)
+	}
+	proxyURL, err := parseUpstreamProxy(upstreamProxy)
+	if err != nil {
+		return nil, "", err
+	}
+	return proxyUpstream(u, proxyURL), input, nil
+}
+
+// checkUpstreamAnswer - check that the upstream server returns the correct answer for the test request
+func checkUpstreamAnswer(u upstream.Upstream, addr string) error {
+	req := dns.Msg{}
+	req.Id = dns.Id()
+	req.RecursionDesired = true
+	req.Question = []dns.Question{
+		{Name: "google-public-dns-a.google.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+	}
+	reply, err := u.Exchange(&req)
+	if err != nil {
+		return fmt.Errorf("couldn't communicate with DNS server %s: %s", addr, err)
+	}
+	if len(reply.Answer) != 1 {
+		return fmt.Errorf("DNS server %s returned wrong answer", addr)
+	}
+	if t, ok := reply.Answer[0].(*dns.A); ok {
+		if !net.IPv4(8, 8, 8, 8).Equal(t.A) {
+			return fmt.Errorf("DNS server %s returned wrong answer: %v", addr, t.A)
+		}
+	}
+	return nil
+}
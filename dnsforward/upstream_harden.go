@@ -0,0 +1,133 @@
+package dnsforward
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// hardenedUpstream - plain DNS upstream server protected against cache poisoning by off-path attackers
+// The request has a new random ID, so the ID of the client's request isn't sent to the upstream server.
+// DNS 0x20: the letters of the query name sent over UDP have random case, and the response must have the same case.
+// The source port is random:  each request is sent from a new UDP socket with the port chosen by OS.
+type hardenedUpstream struct {
+	upstream.Upstream
+	use0x20 bool
+	tcp     upstream.Upstream // retry over TCP if the case doesn't match (nil: the response is rejected)
+}
+
+// isPlainUpstream - return TRUE if this is plain DNS upstream server
+// Plain UDP servers have no scheme in their address ("1.2.3.4:53"), plain TCP servers have "tcp://".
+func isPlainUpstream(u upstream.Upstream) bool {
+	a := u.Address()
+	return !strings.Contains(a, "://") || strings.HasPrefix(a, "tcp://")
+}
+
+// randomizeCase - randomize the case of the letters of the name
+func randomizeCase(name string) string {
+	buf := make([]byte, (len(name)+7)/8)
+	_, _ = rand.Read(buf)
+	b := []byte(name)
+	for i, c := range b {
+		if (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') && buf[i/8]&(1<<(i%8)) != 0 {
+			b[i] ^= 0x20
+		}
+	}
+	return string(b)
+}
+
+// restoreNames - set the original case of the query name in the response
+func restoreNames(reply *dns.Msg, sent, orig string) {
+	for i := range reply.Question {
+		if strings.EqualFold(reply.Question[i].Name, sent) {
+			reply.Question[i].Name = orig
+		}
+	}
+	for _, rrs := range [][]dns.RR{reply.Answer, reply.Ns, reply.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if strings.EqualFold(hdr.Name, sent) {
+				hdr.Name = orig
+			}
+		}
+	}
+}
+
+// Exchange - send the request with a random ID and the query name with random case
+func (u *hardenedUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	req := m.Copy()
+	req.Id = dns.Id()
+	use0x20 := u.use0x20 && len(req.Question) == 1 && !strings.HasPrefix(u.Address(), "tcp://")
+	orig := ""
+	sent := ""
+	if use0x20 {
+		orig = req.Question[0].Name
+		sent = randomizeCase(orig)
+		req.Question[0].Name = sent
+	}
+
+	reply, err := u.Upstream.Exchange(req)
+	if err == nil && use0x20 && (len(reply.Question) != 1 || reply.Question[0].Name != sent) {
+		if u.tcp == nil {
+			return nil, fmt.Errorf("%s: the case of the query name in the response doesn't match", u.Address())
+		}
+		log.Debug("DNS: %s: the case of the query name %s in the response doesn't match, retrying over TCP",
+			u.Address(), orig)
+		reply, err = u.tcp.Exchange(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reply.Id = m.Id
+	if use0x20 {
+		restoreNames(reply, sent, orig)
+	}
+	return reply, nil
+}
+
+// hardenUpstream - protect plain DNS upstream server against cache poisoning
+// Other upstream servers are returned as is.
+func hardenUpstream(u upstream.Upstream, use0x20, tcpFallback bool) upstream.Upstream {
+	if !isPlainUpstream(u) {
+		return u
+	}
+	h := &hardenedUpstream{Upstream: u, use0x20: use0x20}
+	if use0x20 && tcpFallback && !strings.HasPrefix(u.Address(), "tcp://") {
+		tcp, err := upstream.AddressToUpstream("tcp://"+u.Address(), upstream.Options{Timeout: DefaultTimeout})
+		if err != nil {
+			log.Error("DNS: %s", err)
+		} else {
+			h.tcp = tcp
+		}
+	}
+	return h
+}
+
+// hardenUpstreams - protect plain DNS upstream servers from the list against cache poisoning
+func hardenUpstreams(list []upstream.Upstream, use0x20, tcpFallback bool) []upstream.Upstream {
+	if list == nil {
+		return nil
+	}
+	result := []upstream.Upstream{}
+	for _, u := range list {
+		result = append(result, hardenUpstream(u, use0x20, tcpFallback))
+	}
+	return result
+}
+
+// HardenUpstreams - protect plain DNS upstream servers from the configuration against cache poisoning
+// use0x20: randomize the case of the query names sent over UDP (DNS 0x20)
+// tcpFallback: retry the request over TCP if the case of the response doesn't match (instead of failing)
+func HardenUpstreams(c *proxy.UpstreamConfig, use0x20, tcpFallback bool) {
+	c.Upstreams = hardenUpstreams(c.Upstreams, use0x20, tcpFallback)
+	for domain, list := range c.DomainReservedUpstreams {
+		// nil: the domain is excluded from reserved upstreams
+		c.DomainReservedUpstreams[domain] = hardenUpstreams(list, use0x20, tcpFallback)
+	}
+}
@@ -0,0 +1,163 @@
+package dnsforward
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+const dohMimeType = "application/dns-message"
+
+// dohProxyUpstream - DNS-over-HTTPS upstream server that is connected through HTTP or SOCKS5 proxy
+type dohProxyUpstream struct {
+	address string // DoH URL
+	client  *http.Client
+}
+
+// parseUpstreamProxy - parse the proxy URL: "(http|https|socks5)://[user:password@]host:port"
+// Return nil if the URL is empty.
+func parseUpstreamProxy(s string) (*url.URL, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("upstream_proxy: %s", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("upstream_proxy: unsupported scheme %q", u.Scheme)
+	}
+	if len(u.Hostname()) == 0 || len(u.Port()) == 0 {
+		return nil, fmt.Errorf("upstream_proxy: host and port must be specified")
+	}
+	if len(u.Path) > 1 || len(u.RawQuery) != 0 {
+		return nil, fmt.Errorf("upstream_proxy: unexpected path or query")
+	}
+	return u, nil
+}
+
+// ValidateUpstreamProxy - check the proxy URL for DNS-over-HTTPS upstream servers
+func ValidateUpstreamProxy(s string) error {
+	_, err := parseUpstreamProxy(s)
+	return err
+}
+
+// newDOHProxyUpstream - create DNS-over-HTTPS upstream server that is connected through the proxy
+// The host name of the server is resolved by the proxy, bootstrap DNS servers aren't used.
+func newDOHProxyUpstream(address string, proxyURL *url.URL) *dohProxyUpstream {
+	transport := &http.Transport{
+		Proxy: http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{
+			RootCAs:      upstream.RootCAs,
+			CipherSuites: upstream.CipherSuites,
+			MinVersion:   tls.VersionTLS12,
+		},
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     5 * time.Minute,
+		TLSHandshakeTimeout: DefaultTimeout,
+	}
+	return &dohProxyUpstream{
+		address: address,
+		client:  &http.Client{Transport: transport, Timeout: DefaultTimeout},
+	}
+}
+
+// Address - get DoH URL
+func (u *dohProxyUpstream) Address() string {
+	return u.address
+}
+
+// Exchange - send the request using POST method (RFC 8484)
+func (u *dohProxyUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't pack request for %s: %s", u.address, err)
+	}
+
+	req, err := http.NewRequest("POST", u.address, bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create request for %s: %s", u.address, err)
+	}
+	req.Header.Set("Content-Type", dohMimeType)
+	req.Header.Set("Accept", dohMimeType)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't do a POST request to %s: %s", u.address, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", u.address, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read body from %s: %s", u.address, err)
+	}
+
+	reply := &dns.Msg{}
+	err = reply.Unpack(body)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unpack response from %s: %s", u.address, err)
+	}
+	if reply.Id != m.Id {
+		return nil, dns.ErrId
+	}
+	return reply, nil
+}
+
+// isDOHUpstream - return TRUE if this is DNS-over-HTTPS upstream server
+func isDOHUpstream(u upstream.Upstream) bool {
+	return strings.HasPrefix(u.Address(), "https://")
+}
+
+// proxyUpstream - use the proxy for DNS-over-HTTPS upstream server
+// Other upstream servers are returned as is.
+func proxyUpstream(u upstream.Upstream, proxyURL *url.URL) upstream.Upstream {
+	if proxyURL == nil || !isDOHUpstream(u) {
+		return u
+	}
+	return newDOHProxyUpstream(u.Address(), proxyURL)
+}
+
+// proxyUpstreams - use the proxy for DNS-over-HTTPS upstream servers from the list
+func proxyUpstreams(list []upstream.Upstream, proxyURL *url.URL) []upstream.Upstream {
+	if list == nil {
+		return nil
+	}
+	result := []upstream.Upstream{}
+	for _, u := range list {
+		result = append(result, proxyUpstream(u, proxyURL))
+	}
+	return result
+}
+
+// SetUpstreamProxy - use the proxy for DNS-over-HTTPS upstream servers from the configuration
+// The configuration isn't changed if proxyURL is empty.
+func SetUpstreamProxy(c *proxy.UpstreamConfig, proxyURL string) error {
+	u, err := parseUpstreamProxy(proxyURL)
+	if err != nil || u == nil {
+		return err
+	}
+
+	c.Upstreams = proxyUpstreams(c.Upstreams, u)
+	for domain, list := range c.DomainReservedUpstreams {
+		// nil: the domain is excluded from reserved upstreams
+		c.DomainReservedUpstreams[domain] = proxyUpstreams(list, u)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+)
+
+// The modifier of the filtering rule that sends the requests for the domain and its subdomains to the upstream server:
+// "||example.org^$upstream=tls://dns.example.net"
+const upstreamModifier = "$upstream="
+
+// ParseUpstreamRule - convert "||domain^$upstream=address" filtering rule to "[/domain/]address" upstream
+// Return false if the line isn't an upstream rule.
+func ParseUpstreamRule(line string) (string, bool, error) {
+	line = strings.TrimSpace(line)
+	i := strings.Index(line, upstreamModifier)
+	if i < 0 || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "#") {
+		return "", false, nil
+	}
+
+	pattern := line[:i]
+	addr := strings.TrimSpace(line[i+len(upstreamModifier):])
+	if !strings.HasPrefix(pattern, "||") || !strings.HasSuffix(pattern, "^") {
+		return "", true, fmt.Errorf("%q: only ||domain^ rules may have $upstream modifier", line)
+	}
+	domain := strings.TrimSuffix(strings.TrimPrefix(pattern, "||"), "^")
+	if len(domain) == 0 || len(addr) == 0 {
+		return "", true, fmt.Errorf("%q: domain and upstream server must be specified", line)
+	}
+	domain, err := util.NormalizeDomain(domain)
+	if err != nil {
+		return "", true, fmt.Errorf("%q: %s", line, err)
+	}
+
+	u := "[/" + domain + "/]" + addr
+	_, err = validateUpstream(u)
+	if err != nil {
+		return "", true, fmt.Errorf("%q: %s", line, err)
+	}
+	return u, true, nil
+}
@@ -0,0 +1,87 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// The EDNS UDP payload size advertised to upstream servers by default (when the OPT record is added for DNSSEC)
+const defaultEDNSUDPSize = 4096
+
+// The limits of the configurable EDNS UDP payload size
+const (
+	minEDNSUDPSize = dns.MinMsgSize
+	maxEDNSUDPSize = 4096
+)
+
+// ValidateEDNSUDPSize - check the EDNS UDP payload size advertised to upstream servers
+func ValidateEDNSUDPSize(size uint32) error {
+	if size != 0 && (size < minEDNSUDPSize || size > maxEDNSUDPSize) {
+		return fmt.Errorf("edns_udp_size: must be 0 or %d..%d", minEDNSUDPSize, maxEDNSUDPSize)
+	}
+	return nil
+}
+
+// ednsUDPSize - get the EDNS UDP payload size advertised to upstream servers
+func (s *Server) ednsUDPSize() uint16 {
+	if s.conf.EDNSUDPSize != 0 {
+		return uint16(s.conf.EDNSUDPSize)
+	}
+	return defaultEDNSUDPSize
+}
+
+// setEDNSUDPSize - advertise the configured EDNS UDP payload size in the request to upstream servers
+// The size from the client's request is replaced, so the upstream server doesn't send the responses
+// that would be fragmented on the way back.
+func (s *Server) setEDNSUDPSize(req *dns.Msg) {
+	if s.conf.EDNSUDPSize == 0 {
+		return
+	}
+	opt := req.IsEdns0()
+	if opt != nil {
+		opt.SetUDPSize(uint16(s.conf.EDNSUDPSize))
+	}
+}
+
+// isPlainUDPUpstream - return TRUE if this is plain DNS upstream server connected over UDP
+func isPlainUDPUpstream(u upstream.Upstream) bool {
+	return !strings.Contains(u.Address(), "://")
+}
+
+// tcpUpstreams - connect to plain DNS upstream servers from the list over TCP
+func tcpUpstreams(list []upstream.Upstream) []upstream.Upstream {
+	if list == nil {
+		return nil
+	}
+	result := []upstream.Upstream{}
+	for _, u := range list {
+		if isPlainUDPUpstream(u) {
+			tcp, err := upstream.AddressToUpstream("tcp://"+u.Address(), upstream.Options{Timeout: DefaultTimeout})
+			if err != nil {
+				log.Error("DNS: %s", err)
+			} else {
+				u = tcp
+			}
+		}
+		result = append(result, u)
+	}
+	return result
+}
+
+// SetUpstreamTCPOnly - connect to plain DNS upstream servers from the configuration over TCP
+// A single upstream server may be connected over TCP with "tcp://" prefix.
+func SetUpstreamTCPOnly(c *proxy.UpstreamConfig, tcpOnly bool) {
+	if !tcpOnly {
+		return
+	}
+	c.Upstreams = tcpUpstreams(c.Upstreams)
+	for domain, list := range c.DomainReservedUpstreams {
+		// nil: the domain is excluded from reserved upstreams
+		c.DomainReservedUpstreams[domain] = tcpUpstreams(list)
+	}
+}
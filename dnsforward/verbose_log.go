@@ -0,0 +1,148 @@
+package dnsforward
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/utils"
+	"github.com/miekg/dns"
+)
+
+// verboseLog - the full logging of the requests for the specified domains or upstream servers
+// It's set at runtime, so debugging one domain doesn't require the debug logging of the whole server.
+type verboseLog struct {
+	lock      sync.RWMutex
+	domains   []string // the domains and their subdomains (lower case, without the trailing dot)
+	upstreams []string // the substrings of the upstream server addresses
+}
+
+// matches - return TRUE if the request for this host resolved via this upstream server must be logged
+func (v *verboseLog) matches(host, upstream string) bool {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	for _, d := range v.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	if len(upstream) != 0 {
+		for _, u := range v.upstreams {
+			if strings.Contains(upstream, u) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dumpMsg - get the text representation of the message and the hex dump of its wire format
+func dumpMsg(title string, m *dns.Msg) string {
+	data, err := m.Pack()
+	if err != nil {
+		return fmt.Sprintf(";; %s: %s\n%s\n", title, err, m)
+	}
+	return fmt.Sprintf(";; %s (%d bytes):\n%s\n%s", title, len(data), m, hex.Dump(data))
+}
+
+// logVerbose - write the request and the responses to the log if they match the verbose logging settings
+func (ctx *dnsContext) logVerbose() {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	q := ctx.origQuestion
+	if len(q.Name) == 0 {
+		if len(d.Req.Question) != 1 {
+			return
+		}
+		q = d.Req.Question[0]
+	}
+	host := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	upstream := ""
+	if d.Upstream != nil {
+		upstream = d.Upstream.Address()
+	}
+	if !s.verboseLog.matches(host, upstream) {
+		return
+	}
+
+	sb := strings.Builder{}
+	_, _ = fmt.Fprintf(&sb, "DNS: %s %s from %s via %s (%s) in %s",
+		host, dns.Type(q.Qtype), getIP(d.Addr), d.Proto, upstream, time.Since(ctx.startTime))
+	if ctx.result != nil && ctx.result.Reason != 0 {
+		_, _ = fmt.Fprintf(&sb, ": %s %q", ctx.result.Reason, ctx.result.Rule)
+	}
+	if ctx.err != nil {
+		_, _ = fmt.Fprintf(&sb, ": %s", ctx.err)
+	}
+	sb.WriteString("\n")
+	sb.WriteString(dumpMsg("request", d.Req))
+	if ctx.origResp != nil {
+		sb.WriteString(dumpMsg("upstream response", ctx.origResp))
+	}
+	if d.Res != nil {
+		sb.WriteString(dumpMsg("response", d.Res))
+	}
+	log.Info("%s", sb.String())
+}
+
+type verboseLogJSON struct {
+	Domains   []string `json:"domains"`
+	Upstreams []string `json:"upstreams"`
+}
+
+// GET /control/dns_verbose_log
+func (s *Server) handleVerboseLogStatus(w http.ResponseWriter, r *http.Request) {
+	s.verboseLog.lock.RLock()
+	resp := verboseLogJSON{
+		Domains:   append([]string{}, s.verboseLog.domains...),
+		Upstreams: append([]string{}, s.verboseLog.upstreams...),
+	}
+	s.verboseLog.lock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+// POST /control/dns_verbose_log/set
+func (s *Server) handleVerboseLogSet(w http.ResponseWriter, r *http.Request) {
+	req := verboseLogJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	domains := []string{}
+	for _, d := range req.Domains {
+		d = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(d), "."))
+		if utils.IsValidHostname(d) != nil {
+			httpError(r, w, http.StatusBadRequest, "invalid domain %q", d)
+			return
+		}
+		domains = append(domains, d)
+	}
+	upstreams := []string{}
+	for _, u := range req.Upstreams {
+		u = strings.TrimSpace(u)
+		if len(u) == 0 {
+			httpError(r, w, http.StatusBadRequest, "empty upstream")
+			return
+		}
+		upstreams = append(upstreams, u)
+	}
+
+	s.verboseLog.lock.Lock()
+	s.verboseLog.domains = domains
+	s.verboseLog.upstreams = upstreams
+	s.verboseLog.lock.Unlock()
+	log.Info("DNS: verbose logging: domains: %v, upstreams: %v", domains, upstreams)
+}
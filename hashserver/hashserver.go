@@ -0,0 +1,309 @@
+// Package hashserver implements a self-hosted server of Safe Browsing and Parental Control hashes
+// compatible with the checks of dnsfilter module (for networks without access to AdGuard servers).
+//
+// The client sends TXT request "<prefix>.<prefix>...sb.dns.adguard.com." where each prefix is
+// the first 4 bytes (hex) of SHA-256 hash of the host name or one of its parent domains.
+// The server responds with the full hashes (hex) of the listed hosts that have these prefixes.
+package hashserver
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// The suffixes of the requests (the same as used by dnsfilter module)
+const (
+	SafeBrowsingSuffix = "sb.dns.adguard.com."
+	ParentalSuffix     = "pc.dns.adguard.com."
+)
+
+// The length of the hash prefix in the request (hex)
+const prefixLen = 8
+
+// TTL of the responses (in seconds)
+const respTTL = 3600
+
+// How often the files are checked for changes
+const reloadInterval = 1 * time.Minute
+
+// Config - module configuration
+type Config struct {
+	Addr              string   // UDP and TCP address to listen on, e.g. "0.0.0.0:5354"
+	SafeBrowsingFiles []string // files with the host names of malicious sites
+	ParentalFiles     []string // files with the host names of adult sites
+}
+
+// hashTable - hash prefix (hex) -> full hashes (hex)
+type hashTable map[string][]string
+
+// Server - the server of Safe Browsing and Parental Control hashes
+type Server struct {
+	conf Config
+
+	lock    sync.RWMutex
+	sb      hashTable
+	pc      hashTable
+	modTime time.Time // the latest modification time of the files
+
+	udp  *dns.Server
+	tcp  *dns.Server
+	stop chan bool
+}
+
+// New - create the server and load the files
+func New(conf Config) (*Server, error) {
+	if len(conf.SafeBrowsingFiles) == 0 && len(conf.ParentalFiles) == 0 {
+		return nil, fmt.Errorf("no host lists")
+	}
+	s := &Server{conf: conf}
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// filesModTime - get the latest modification time of the files
+func (s *Server) filesModTime() time.Time {
+	var t time.Time
+	for _, fn := range append(append([]string{}, s.conf.SafeBrowsingFiles...), s.conf.ParentalFiles...) {
+		st, err := os.Stat(fn)
+		if err == nil && st.ModTime().After(t) {
+			t = st.ModTime()
+		}
+	}
+	return t
+}
+
+// load - read the files and replace the hash tables
+func (s *Server) load() error {
+	modTime := s.filesModTime()
+	sb, err := loadFiles(s.conf.SafeBrowsingFiles)
+	if err != nil {
+		return err
+	}
+	pc, err := loadFiles(s.conf.ParentalFiles)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.sb = sb
+	s.pc = pc
+	s.modTime = modTime
+	s.lock.Unlock()
+	log.Info("hashserver: loaded %d safe browsing and %d parental hash prefixes", len(sb), len(pc))
+	return nil
+}
+
+// loadFiles - read the host names from the files
+func loadFiles(files []string) (hashTable, error) {
+	t := hashTable{}
+	for _, fn := range files {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		n, invalid := t.addHosts(f)
+		_ = f.Close()
+		log.Debug("hashserver: %s: %d hosts (%d invalid)", fn, n, invalid)
+	}
+	return t, nil
+}
+
+// parseHostLine - get the host name from the line of the list
+// Supported formats: "example.org", "0.0.0.0 example.org", "||example.org^"
+// Return "" if it's a comment or an empty line.
+func parseHostLine(line string) string {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' || line[0] == '!' {
+		return ""
+	}
+	fields := strings.Fields(line)
+	host := fields[0]
+	if len(fields) >= 2 && net.ParseIP(fields[0]) != nil {
+		host = fields[1]
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "||"), "^")
+	return host
+}
+
+// addHosts - add the hashes of the host names from the reader
+// Return the number of added hosts and the number of invalid lines.
+func (t hashTable) addHosts(r io.Reader) (int, int) {
+	n := 0
+	invalid := 0
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		host := parseHostLine(sc.Text())
+		if len(host) == 0 {
+			continue
+		}
+		host, err := util.NormalizeDomain(host)
+		if err != nil || strings.HasPrefix(host, "*.") {
+			invalid++
+			continue
+		}
+		t.add(host)
+		n++
+	}
+	return n, invalid
+}
+
+// add - add the hash of the host name
+func (t hashTable) add(host string) {
+	sum := sha256.Sum256([]byte(host))
+	h := hex.EncodeToString(sum[:])
+	prefix := h[:prefixLen]
+	for _, e := range t[prefix] {
+		if e == h {
+			return
+		}
+	}
+	t[prefix] = append(t[prefix], h)
+}
+
+// Start - start listening
+func (s *Server) Start() error {
+	s.udp = &dns.Server{Addr: s.conf.Addr, Net: "udp", Handler: s}
+	s.tcp = &dns.Server{Addr: s.conf.Addr, Net: "tcp", Handler: s}
+
+	// bind the sockets synchronously, so the error is returned
+	pc, err := net.ListenPacket("udp", s.conf.Addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", s.conf.Addr)
+	if err != nil {
+		_ = pc.Close()
+		return err
+	}
+	s.udp.PacketConn = pc
+	s.tcp.Listener = l
+	go func() { _ = s.udp.ActivateAndServe() }()
+	go func() { _ = s.tcp.ActivateAndServe() }()
+
+	s.stop = make(chan bool)
+	go s.watch()
+	log.Info("hashserver: listening on %s", s.conf.Addr)
+	return nil
+}
+
+// Close - stop the server
+func (s *Server) Close() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	if s.udp != nil {
+		_ = s.udp.Shutdown()
+		s.udp = nil
+	}
+	if s.tcp != nil {
+		_ = s.tcp.Shutdown()
+		s.tcp = nil
+	}
+}
+
+// watch - reload the files when they change
+func (s *Server) watch() {
+	stop := s.stop
+	t := time.NewTicker(reloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		s.lock.RLock()
+		modTime := s.modTime
+		s.lock.RUnlock()
+		if !s.filesModTime().After(modTime) {
+			continue
+		}
+		err := s.load()
+		if err != nil {
+			log.Error("hashserver: %s", err)
+		}
+	}
+}
+
+// lookup - get the full hashes for the request name
+// Return FALSE if the name isn't a request of hashes.
+func (s *Server) lookup(name string) ([]string, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	var t hashTable
+	var prefixes string
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if strings.HasSuffix(name, "."+SafeBrowsingSuffix) {
+		t = s.sb
+		prefixes = strings.TrimSuffix(name, "."+SafeBrowsingSuffix)
+	} else if strings.HasSuffix(name, "."+ParentalSuffix) {
+		t = s.pc
+		prefixes = strings.TrimSuffix(name, "."+ParentalSuffix)
+	} else {
+		return nil, false
+	}
+
+	var hashes []string
+	for _, p := range strings.Split(prefixes, ".") {
+		if len(p) != prefixLen {
+			continue
+		}
+		hashes = append(hashes, t[p]...)
+	}
+	return hashes, true
+}
+
+// ServeDNS - respond with TXT records of the matched hashes
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := &dns.Msg{}
+	if len(req.Question) != 1 {
+		resp.SetRcode(req, dns.RcodeFormatError)
+		_ = w.WriteMsg(resp)
+		return
+	}
+	q := req.Question[0]
+	hashes, ok := s.lookup(q.Name)
+	if !ok {
+		resp.SetRcode(req, dns.RcodeRefused)
+		_ = w.WriteMsg(resp)
+		return
+	}
+
+	resp.SetReply(req)
+	resp.Authoritative = true
+	if q.Qtype == dns.TypeTXT {
+		for _, h := range hashes {
+			resp.Answer = append(resp.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: respTTL},
+				Txt: []string{h},
+			})
+		}
+	}
+
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		// the client retries over TCP
+		resp.Truncate(size)
+	}
+	_ = w.WriteMsg(resp)
+}
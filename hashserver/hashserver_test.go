@@ -0,0 +1,115 @@
+package hashserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func hashHex(host string) string {
+	sum := sha256.Sum256([]byte(host))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseHostLine(t *testing.T) {
+	assert.Equal(t, "", parseHostLine(""))
+	assert.Equal(t, "", parseHostLine("# comment"))
+	assert.Equal(t, "", parseHostLine("! comment"))
+	assert.Equal(t, "example.org", parseHostLine("  example.org  "))
+	assert.Equal(t, "example.org", parseHostLine("0.0.0.0 example.org # comment"))
+	assert.Equal(t, "example.org", parseHostLine("::1 example.org"))
+	assert.Equal(t, "example.org", parseHostLine("||example.org^"))
+}
+
+func TestHashTable(t *testing.T) {
+	tbl := hashTable{}
+	n, invalid := tbl.addHosts(strings.NewReader("# list\nEvil.Example.org\n0.0.0.0 пример.рф\n*.example.net\nevil.example.org\n"))
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 1, invalid)
+	assert.Equal(t, 2, len(tbl))
+
+	h := hashHex("evil.example.org")
+	assert.Equal(t, []string{h}, tbl[h[:prefixLen]])
+	h = hashHex("xn--e1afmkfd.xn--p1ai")
+	assert.Equal(t, []string{h}, tbl[h[:prefixLen]])
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashserver")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	sbFile := filepath.Join(dir, "sb.txt")
+	pcFile := filepath.Join(dir, "pc.txt")
+	assert.Nil(t, ioutil.WriteFile(sbFile, []byte("malware.example.org\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(pcFile, []byte("||adult.example.org^\n"), 0644))
+
+	s, err := New(Config{
+		Addr:              "127.0.0.1:0",
+		SafeBrowsingFiles: []string{sbFile},
+		ParentalFiles:     []string{pcFile},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Close()
+	addr := s.udp.PacketConn.LocalAddr().String()
+
+	// a request of the hash prefix
+	h := hashHex("malware.example.org")
+	req := &dns.Msg{}
+	req.SetQuestion(h[:prefixLen]+"."+SafeBrowsingSuffix, dns.TypeTXT)
+	resp, err := dns.Exchange(req, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Equal(t, 1, len(resp.Answer))
+	assert.Equal(t, []string{h}, resp.Answer[0].(*dns.TXT).Txt)
+
+	// the other list
+	req.SetQuestion(h[:prefixLen]+"."+ParentalSuffix, dns.TypeTXT)
+	resp, err = dns.Exchange(req, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(resp.Answer))
+
+	// not a request of hashes
+	req.SetQuestion("example.org.", dns.TypeA)
+	resp, err = dns.Exchange(req, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+
+	// the checks of dnsfilter module
+	d := dnsfilter.New(&dnsfilter.Config{
+		SafeBrowsingServer:    "udp://" + addr,
+		ParentalServer:        "udp://" + addr,
+		SafeBrowsingCacheSize: 1000,
+		ParentalCacheSize:     1000,
+		CacheTime:             30,
+	}, nil)
+	assert.NotNil(t, d)
+	defer d.Close()
+	setts := &dnsfilter.RequestFilteringSettings{
+		FilteringEnabled:    true,
+		SafeBrowsingEnabled: true,
+		ParentalEnabled:     true,
+	}
+
+	r, err := d.CheckHost("www.malware.example.org", dns.TypeA, setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, dnsfilter.FilteredSafeBrowsing, r.Reason)
+
+	r, err = d.CheckHost("adult.example.org", dns.TypeA, setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, dnsfilter.FilteredParental, r.Reason)
+
+	r, err = d.CheckHost("example.org", dns.TypeA, setts)
+	assert.Nil(t, err)
+	assert.False(t, r.IsFiltered)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,8 +21,15 @@ import (
 const cookieTTL = 365 * 24 // in hours
 const sessionCookieName = "agh_session"
 
+// User roles
+const (
+	roleAdmin  = "admin"  // full access
+	roleViewer = "viewer" // read-only access
+)
+
 type session struct {
 	userName string
+	role     string // user role (roleAdmin if empty)
 	expire   uint32 // expiration time (in seconds)
 }
 
@@ -29,13 +37,18 @@ type session struct {
 expire byte[4]
 name_len byte[2]
 name byte[]
+role_len byte[2] (optional)
+role byte[] (optional)
 */
 func (s *session) serialize() []byte {
 	var data []byte
-	data = make([]byte, 4+2+len(s.userName))
+	data = make([]byte, 4+2+len(s.userName)+2+len(s.role))
 	binary.BigEndian.PutUint32(data[0:4], s.expire)
 	binary.BigEndian.PutUint16(data[4:6], uint16(len(s.userName)))
 	copy(data[6:], []byte(s.userName))
+	i := 6 + len(s.userName)
+	binary.BigEndian.PutUint16(data[i:i+2], uint16(len(s.role)))
+	copy(data[i+2:], []byte(s.role))
 	return data
 }
 
@@ -50,7 +63,19 @@ func (s *session) deserialize(data []byte) bool {
 	if len(data) < int(nameLen) {
 		return false
 	}
-	s.userName = string(data)
+	s.userName = string(data[:nameLen])
+	data = data[nameLen:]
+
+	// sessions created by the older versions don't have a role
+	if len(data) < 2 {
+		return true
+	}
+	roleLen := binary.BigEndian.Uint16(data[0:2])
+	data = data[2:]
+	if len(data) < int(roleLen) {
+		return false
+	}
+	s.role = string(data[:roleLen])
 	return true
 }
 
@@ -61,20 +86,66 @@ type Auth struct {
 	lock       sync.Mutex
 	users      []User
 	sessionTTL uint32 // in seconds
+
+	ldap *ldapAuth // LDAP authentication backend (optional)
+	oidc *oidcAuth // OpenID Connect authentication backend (optional)
+
+	oidcStates map[string]uint32 // OpenID Connect login state -> expiration time (in seconds)
+
+	rateLimiter *authRateLimiter // limits failed log-in attempts (nil: disabled)
 }
 
 // User object
 type User struct {
 	Name         string `yaml:"name"`
 	PasswordHash string `yaml:"password"` // bcrypt hash
+	Role         string `yaml:"-"`        // user role: roleAdmin or roleViewer
+}
+
+// authRoleMapping maps the groups received from an authentication backend to user roles
+type authRoleMapping struct {
+	AdminGroups  []string `yaml:"admin_groups"`  // members of these groups get full access
+	ViewerGroups []string `yaml:"viewer_groups"` // members of these groups get read-only access
+}
+
+// role returns the role of a user who is a member of the specified groups
+// If no groups are configured, every authenticated user is an administrator.
+// Return an empty string if the user isn't allowed to log in.
+func (m *authRoleMapping) role(groups []string) string {
+	if len(m.AdminGroups) == 0 && len(m.ViewerGroups) == 0 {
+		return roleAdmin
+	}
+
+	for _, g := range groups {
+		if containsStringFold(m.AdminGroups, g) {
+			return roleAdmin
+		}
+	}
+	for _, g := range groups {
+		if containsStringFold(m.ViewerGroups, g) {
+			return roleViewer
+		}
+	}
+	return ""
+}
+
+// containsStringFold checks if "v" is in the array "arr" (case-insensitive)
+func containsStringFold(arr []string, v string) bool {
+	for _, i := range arr {
+		if strings.EqualFold(i, v) {
+			return true
+		}
+	}
+	return false
 }
 
 // InitAuth - create a global object
-func InitAuth(dbFilename string, users []User, sessionTTL uint32) *Auth {
+func InitAuth(dbFilename string, users []User, sessionTTL uint32, rateLimiter *authRateLimiter) *Auth {
 	log.Info("Initializing auth module: %s", dbFilename)
 
 	a := Auth{}
 	a.sessionTTL = sessionTTL
+	a.rateLimiter = rateLimiter
 	a.sessions = make(map[string]*session)
 	rand.Seed(time.Now().UTC().Unix())
 	var err error
@@ -88,10 +159,21 @@ func InitAuth(dbFilename string, users []User, sessionTTL uint32) *Auth {
 	}
 	a.loadSessions()
 	a.users = users
+	a.oidcStates = make(map[string]uint32)
 	log.Info("Auth: initialized.  users:%d  sessions:%d", len(a.users), len(a.sessions))
 	return &a
 }
 
+// InitBackends - initialize remote authentication backends
+func (a *Auth) InitBackends(ldapConf ldapConfig, oidcConf oidcConfig) {
+	if ldapConf.Enabled {
+		a.ldap = newLDAPAuth(ldapConf)
+	}
+	if oidcConf.Enabled {
+		a.oidc = newOIDCAuth(oidcConf)
+	}
+}
+
 // Close - close module
 func (a *Auth) Close() {
 	_ = a.db.Close()
@@ -282,11 +364,16 @@ func getSession(u *User) []byte {
 }
 
 func (a *Auth) httpCookie(req loginJSON) string {
-	u := a.UserFind(req.Name, req.Password)
+	u := a.authenticate(req.Name, req.Password)
 	if len(u.Name) == 0 {
 		return ""
 	}
 
+	return a.newSessionCookie(u)
+}
+
+// newSessionCookie creates a new session for the authenticated user and returns the cookie value
+func (a *Auth) newSessionCookie(u User) string {
 	sess := getSession(&u)
 
 	now := time.Now().UTC()
@@ -297,6 +384,7 @@ func (a *Auth) httpCookie(req loginJSON) string {
 
 	s := session{}
 	s.userName = u.Name
+	s.role = u.Role
 	s.expire = uint32(now.Unix()) + a.sessionTTL
 	a.addSession(sess, &s)
 
@@ -305,6 +393,15 @@ func (a *Auth) httpCookie(req loginJSON) string {
 }
 
 func handleLogin(w http.ResponseWriter, r *http.Request) {
+	ip := remoteIP(r)
+	left := Context.auth.rateLimiter.check(ip)
+	if left != 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(left.Seconds())+1))
+		httpError(w, http.StatusTooManyRequests, "Auth: too many failed log-in attempts from %s, try again in %s",
+			ip, left.Round(time.Second))
+		return
+	}
+
 	req := loginJSON{}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -314,11 +411,13 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	cookie := Context.auth.httpCookie(req)
 	if len(cookie) == 0 {
-		log.Info("Auth: invalid user name or password: name='%s'", req.Name)
+		logFailedAuth(ip, req.Name)
+		Context.auth.rateLimiter.inc(ip)
 		time.Sleep(1 * time.Second)
 		http.Error(w, "invalid user name or password", http.StatusBadRequest)
 		return
 	}
+	Context.auth.rateLimiter.remove(ip)
 
 	w.Header().Set("Set-Cookie", cookie)
 
@@ -347,6 +446,8 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 // RegisterAuthHandlers - register handlers
 func RegisterAuthHandlers() {
 	http.Handle("/control/login", postInstallHandler(ensureHandler("POST", handleLogin)))
+	http.Handle("/control/login/oidc", postInstallHandler(ensureHandler("GET", handleLoginOIDC)))
+	http.Handle("/control/login/oidc/callback", postInstallHandler(ensureHandler("GET", handleLoginOIDCCallback)))
 	httpRegister("GET", "/control/logout", handleLogout)
 }
 
@@ -365,6 +466,15 @@ func parseCookie(cookie string) string {
 	return ""
 }
 
+// roleAllowed - return TRUE if a user with this role may perform the request
+// Read-only users may only retrieve data.
+func roleAllowed(role string, r *http.Request) bool {
+	if role != roleViewer {
+		return true
+	}
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
 // nolint(gocyclo)
 func optionalAuth(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -391,6 +501,7 @@ func optionalAuth(handler func(http.ResponseWriter, *http.Request)) func(http.Re
 		} else if Context.auth != nil && Context.auth.AuthRequired() {
 			// redirect to login page if not authenticated
 			ok := false
+			role := roleAdmin
 			cookie, err := r.Cookie(sessionCookieName)
 
 			if glProcessCookie(r) {
@@ -401,18 +512,26 @@ func optionalAuth(handler func(http.ResponseWriter, *http.Request)) func(http.Re
 				r := Context.auth.CheckSession(cookie.Value)
 				if r == 0 {
 					ok = true
+					role = Context.auth.sessionRole(cookie.Value)
 				} else if r < 0 {
 					log.Debug("Auth: invalid cookie value: %s", cookie)
 				}
 			} else {
 				// there's no Cookie, check Basic authentication
 				user, pass, ok2 := r.BasicAuth()
-				if ok2 {
-					u := Context.auth.UserFind(user, pass)
+				ip := remoteIP(r)
+				if ok2 && Context.auth.rateLimiter.check(ip) != 0 {
+					log.Debug("Auth: log-in attempts from %s are blocked", ip)
+				} else if ok2 {
+					u := Context.auth.authenticate(user, pass)
 					if len(u.Name) != 0 {
 						ok = true
+						role = u.Role
+						Context.auth.rateLimiter.remove(ip)
 					} else {
 						log.Info("Auth: invalid Basic Authorization value")
+						logFailedAuth(ip, user)
+						Context.auth.rateLimiter.inc(ip)
 					}
 				}
 			}
@@ -421,6 +540,10 @@ func optionalAuth(handler func(http.ResponseWriter, *http.Request)) func(http.Re
 					if glProcessRedirect(w, r) {
 						log.Debug("Auth: redirected to login page by GL-Inet submodule")
 
+					} else if Context.auth.oidcOnly() {
+						w.Header().Set("Location", "/control/login/oidc")
+						w.WriteHeader(http.StatusFound)
+
 					} else {
 						w.Header().Set("Location", "/login.html")
 						w.WriteHeader(http.StatusFound)
@@ -431,6 +554,13 @@ func optionalAuth(handler func(http.ResponseWriter, *http.Request)) func(http.Re
 				}
 				return
 			}
+
+			if !roleAllowed(role, r) {
+				log.Debug("Auth: %s %s is not allowed for role %s", r.Method, r.URL.Path, role)
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte("Forbidden"))
+				return
+			}
 		}
 
 		handler(w, r)
@@ -482,6 +612,36 @@ func (a *Auth) UserFind(login string, password string) User {
 	return User{}
 }
 
+// authenticate - check the user name and password against the local users and then against LDAP
+func (a *Auth) authenticate(login string, password string) User {
+	u := a.UserFind(login, password)
+	if len(u.Name) != 0 {
+		u.Role = roleAdmin
+		return u
+	}
+
+	if a.ldap == nil {
+		return User{}
+	}
+	u, err := a.ldap.authenticate(login, password)
+	if err != nil {
+		log.Info("Auth: LDAP: %s", err)
+		return User{}
+	}
+	return u
+}
+
+// sessionRole - get the role of the user who owns the session
+func (a *Auth) sessionRole(sess string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	s, ok := a.sessions[sess]
+	if !ok || len(s.role) == 0 {
+		return roleAdmin
+	}
+	return s.role
+}
+
 // GetCurrentUser - get the current user
 func (a *Auth) GetCurrentUser(r *http.Request) User {
 	cookie, err := r.Cookie(sessionCookieName)
@@ -489,7 +649,7 @@ func (a *Auth) GetCurrentUser(r *http.Request) User {
 		// there's no Cookie, check Basic authentication
 		user, pass, ok := r.BasicAuth()
 		if ok {
-			u := Context.auth.UserFind(user, pass)
+			u := Context.auth.authenticate(user, pass)
 			return u
 		}
 		return User{}
@@ -501,14 +661,24 @@ func (a *Auth) GetCurrentUser(r *http.Request) User {
 		a.lock.Unlock()
 		return User{}
 	}
+	role := s.role
+	if len(role) == 0 {
+		role = roleAdmin
+	}
 	for _, u := range a.users {
 		if u.Name == s.userName {
 			a.lock.Unlock()
+			u.Role = role
 			return u
 		}
 	}
 	a.lock.Unlock()
-	return User{}
+
+	if a.ldap == nil && a.oidc == nil {
+		return User{}
+	}
+	// the user was authenticated by a remote backend
+	return User{Name: s.userName, Role: role}
 }
 
 // GetUsers - get users
@@ -528,5 +698,17 @@ func (a *Auth) AuthRequired() bool {
 	a.lock.Lock()
 	r := (len(a.users) != 0)
 	a.lock.Unlock()
+	return r || a.ldap != nil || a.oidc != nil
+}
+
+// oidcOnly - if OpenID Connect is the only available authentication method
+func (a *Auth) oidcOnly() bool {
+	if a.oidc == nil || a.ldap != nil {
+		return false
+	}
+
+	a.lock.Lock()
+	r := (len(a.users) == 0)
+	a.lock.Unlock()
 	return r
 }
@@ -0,0 +1,115 @@
+package home
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const ldapTimeout = 10 * time.Second
+
+// ldapConfig - LDAP authentication settings
+type ldapConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url"` // e.g. "ldaps://ldap.example.org:636"
+
+	// Use StartTLS to encrypt an "ldap://" connection
+	StartTLS bool `yaml:"start_tls"`
+
+	// Account that is used to search for users.  If empty, anonymous bind is used.
+	BindDN       string `yaml:"bind_dn"`
+	BindPassword string `yaml:"bind_password"`
+
+	BaseDN         string `yaml:"base_dn"`         // the search for users starts here
+	UserFilter     string `yaml:"user_filter"`     // e.g. "(uid=%s)", %s is replaced by the user name
+	GroupAttribute string `yaml:"group_attribute"` // user's attribute that contains the groups, e.g. "memberOf"
+
+	authRoleMapping `yaml:",inline"`
+}
+
+// ldapAuth - LDAP authentication backend
+type ldapAuth struct {
+	conf ldapConfig
+}
+
+func newLDAPAuth(conf ldapConfig) *ldapAuth {
+	if len(conf.URL) == 0 || len(conf.BaseDN) == 0 {
+		log.Error("Auth: LDAP: url and base_dn must be specified, LDAP authentication is disabled")
+		return nil
+	}
+	if len(conf.UserFilter) == 0 {
+		conf.UserFilter = "(uid=%s)"
+	}
+	if len(conf.GroupAttribute) == 0 {
+		conf.GroupAttribute = "memberOf"
+	}
+	log.Debug("Auth: LDAP: using %s", conf.URL)
+	return &ldapAuth{conf: conf}
+}
+
+// authenticate - find the user in the directory and check the password
+func (l *ldapAuth) authenticate(login, password string) (User, error) {
+	if len(login) == 0 || len(password) == 0 {
+		// an empty password means an unauthenticated bind which always succeeds
+		return User{}, fmt.Errorf("empty user name or password")
+	}
+
+	conn, err := ldap.DialURL(l.conf.URL)
+	if err != nil {
+		return User{}, fmt.Errorf("connect: %s", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(ldapTimeout)
+
+	if l.conf.StartTLS {
+		u, _ := url.Parse(l.conf.URL)
+		err = conn.StartTLS(&tls.Config{
+			ServerName: u.Hostname(),
+			RootCAs:    Context.tlsRoots,
+		})
+		if err != nil {
+			return User{}, fmt.Errorf("StartTLS: %s", err)
+		}
+	}
+
+	if len(l.conf.BindDN) != 0 {
+		err = conn.Bind(l.conf.BindDN, l.conf.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("bind: %s: %s", l.conf.BindDN, err)
+	}
+
+	req := ldap.NewSearchRequest(l.conf.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		fmt.Sprintf(l.conf.UserFilter, ldap.EscapeFilter(login)),
+		[]string{"dn", l.conf.GroupAttribute},
+		nil)
+	sr, err := conn.Search(req)
+	if err != nil {
+		return User{}, fmt.Errorf("search: %s", err)
+	}
+	if len(sr.Entries) != 1 {
+		return User{}, fmt.Errorf("user %s: found %d entries", login, len(sr.Entries))
+	}
+	entry := sr.Entries[0]
+
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		return User{}, fmt.Errorf("user %s: bind: %s", login, err)
+	}
+
+	groups := entry.GetAttributeValues(l.conf.GroupAttribute)
+	role := l.conf.role(groups)
+	if len(role) == 0 {
+		return User{}, fmt.Errorf("user %s isn't a member of any allowed group", login)
+	}
+
+	log.Debug("Auth: LDAP: authenticated %s (%s)", login, role)
+	return User{Name: login, Role: role}, nil
+}
@@ -0,0 +1,406 @@
+package home
+
+import (
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The time a user has to complete the log-in at the OpenID Connect provider (in seconds)
+const oidcStateTTL = 10 * 60
+
+// The cookie that ties the log-in state and the nonce to the browser that has started the log-in
+const oidcStateCookie = "agh_oidc_state"
+
+// oidcConfig - OpenID Connect authentication settings
+type oidcConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Issuer URL, e.g. "https://accounts.example.org".
+	// Used to discover the endpoints that aren't set explicitly.
+	Issuer      string `yaml:"issuer"`
+	AuthURL     string `yaml:"auth_url"`
+	TokenURL    string `yaml:"token_url"`
+	UserInfoURL string `yaml:"userinfo_url"`
+
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+
+	// URL of our callback handler, e.g. "https://adguard.example.org/control/login/oidc/callback"
+	RedirectURL string   `yaml:"redirect_url"`
+	Scopes      []string `yaml:"scopes"`
+
+	UsernameClaim string `yaml:"username_claim"` // default: "preferred_username"
+	GroupsClaim   string `yaml:"groups_claim"`   // default: "groups"
+
+	authRoleMapping `yaml:",inline"`
+}
+
+// oidcAuth - OpenID Connect authentication backend
+type oidcAuth struct {
+	conf oidcConfig
+	lock sync.Mutex // protects endpoints discovery
+}
+
+func newOIDCAuth(conf oidcConfig) *oidcAuth {
+	if len(conf.ClientID) == 0 || len(conf.RedirectURL) == 0 {
+		log.Error("Auth: OIDC: client_id and redirect_url must be specified, OIDC authentication is disabled")
+		return nil
+	}
+	if len(conf.Issuer) == 0 &&
+		(len(conf.AuthURL) == 0 || len(conf.TokenURL) == 0 || len(conf.UserInfoURL) == 0) {
+		log.Error("Auth: OIDC: either issuer or all the endpoints must be specified, OIDC authentication is disabled")
+		return nil
+	}
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{"openid", "profile", "groups"}
+	}
+	if len(conf.UsernameClaim) == 0 {
+		conf.UsernameClaim = "preferred_username"
+	}
+	if len(conf.GroupsClaim) == 0 {
+		conf.GroupsClaim = "groups"
+	}
+	return &oidcAuth{conf: conf}
+}
+
+// discover - get the endpoints from the provider's configuration document if they aren't set
+func (o *oidcAuth) discover() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.conf.AuthURL) != 0 && len(o.conf.TokenURL) != 0 && len(o.conf.UserInfoURL) != 0 {
+		return nil
+	}
+
+	u := strings.TrimSuffix(o.conf.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := Context.client.Get(u)
+	if err != nil {
+		return fmt.Errorf("discovery: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("discovery: %s: status code %d", u, resp.StatusCode)
+	}
+
+	doc := struct {
+		AuthURL     string `json:"authorization_endpoint"`
+		TokenURL    string `json:"token_endpoint"`
+		UserInfoURL string `json:"userinfo_endpoint"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	if err != nil {
+		return fmt.Errorf("discovery: %s: %s", u, err)
+	}
+
+	if len(o.conf.AuthURL) == 0 {
+		o.conf.AuthURL = doc.AuthURL
+	}
+	if len(o.conf.TokenURL) == 0 {
+		o.conf.TokenURL = doc.TokenURL
+	}
+	if len(o.conf.UserInfoURL) == 0 {
+		o.conf.UserInfoURL = doc.UserInfoURL
+	}
+	log.Debug("Auth: OIDC: discovered endpoints: %s %s %s",
+		o.conf.AuthURL, o.conf.TokenURL, o.conf.UserInfoURL)
+	return nil
+}
+
+// authURL - get the URL of the provider's log-in page
+func (o *oidcAuth) authURL(state, nonce string) (string, error) {
+	err := o.discover()
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(o.conf.AuthURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", o.conf.ClientID)
+	q.Set("redirect_uri", o.conf.RedirectURL)
+	q.Set("scope", strings.Join(o.conf.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// exchange - exchange the authorization code for the user's info
+// nonce: the value sent to the provider with the log-in request:  the ID token must contain it
+func (o *oidcAuth) exchange(code, nonce string) (User, error) {
+	err := o.discover()
+	if err != nil {
+		return User{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.conf.RedirectURL)
+	form.Set("client_id", o.conf.ClientID)
+	form.Set("client_secret", o.conf.ClientSecret)
+	resp, err := Context.client.PostForm(o.conf.TokenURL, form)
+	if err != nil {
+		return User{}, fmt.Errorf("token: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return User{}, fmt.Errorf("token: status code %d", resp.StatusCode)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil || len(token.AccessToken) == 0 || len(token.IDToken) == 0 {
+		return User{}, fmt.Errorf("token: invalid response: %v", err)
+	}
+	sub, err := o.checkIDToken(token.IDToken, nonce, time.Now())
+	if err != nil {
+		return User{}, fmt.Errorf("id_token: %s", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, o.conf.UserInfoURL, nil)
+	if err != nil {
+		return User{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp2, err := Context.client.Do(req)
+	if err != nil {
+		return User{}, fmt.Errorf("userinfo: %s", err)
+	}
+	defer resp2.Body.Close()
+	if resp2.StatusCode != http.StatusOK {
+		return User{}, fmt.Errorf("userinfo: status code %d", resp2.StatusCode)
+	}
+	claims := map[string]interface{}{}
+	err = json.NewDecoder(resp2.Body).Decode(&claims)
+	if err != nil {
+		return User{}, fmt.Errorf("userinfo: %s", err)
+	}
+	if s, _ := claims["sub"].(string); s != sub {
+		return User{}, fmt.Errorf("userinfo: sub %q doesn't match the ID token", s)
+	}
+
+	return o.userFromClaims(claims)
+}
+
+// checkIDToken - check the claims of the ID token and get the user's subject identifier
+// The token is received from the token endpoint over TLS, so its signature isn't checked
+// (see OpenID Connect Core 1.0, 3.1.3.7).
+func (o *oidcAuth) checkIDToken(idToken, nonce string, now time.Time) (string, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid format")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", fmt.Errorf("invalid payload: %s", err)
+	}
+	claims := struct {
+		Iss   string      `json:"iss"`
+		Sub   string      `json:"sub"`
+		Aud   interface{} `json:"aud"` // a string or an array of strings
+		Exp   int64       `json:"exp"`
+		Nonce string      `json:"nonce"`
+	}{}
+	err = json.Unmarshal(data, &claims)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload: %s", err)
+	}
+
+	if len(nonce) == 0 || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return "", fmt.Errorf("nonce doesn't match")
+	}
+	if len(o.conf.Issuer) != 0 && strings.TrimSuffix(claims.Iss, "/") != strings.TrimSuffix(o.conf.Issuer, "/") {
+		return "", fmt.Errorf("unexpected issuer %q", claims.Iss)
+	}
+	audOK := false
+	switch v := claims.Aud.(type) {
+	case string:
+		audOK = v == o.conf.ClientID
+	case []interface{}:
+		for _, a := range v {
+			if s, _ := a.(string); s == o.conf.ClientID {
+				audOK = true
+			}
+		}
+	}
+	if !audOK {
+		return "", fmt.Errorf("the token isn't issued to client %q", o.conf.ClientID)
+	}
+	if claims.Exp <= now.Unix() {
+		return "", fmt.Errorf("the token has expired")
+	}
+	if len(claims.Sub) == 0 {
+		return "", fmt.Errorf("no sub")
+	}
+	return claims.Sub, nil
+}
+
+// userFromClaims - get the user name and role from the userinfo claims
+func (o *oidcAuth) userFromClaims(claims map[string]interface{}) (User, error) {
+	name, _ := claims[o.conf.UsernameClaim].(string)
+	if len(name) == 0 {
+		name, _ = claims["sub"].(string)
+	}
+	if len(name) == 0 {
+		return User{}, fmt.Errorf("userinfo: no user name")
+	}
+
+	groups := []string{}
+	switch v := claims[o.conf.GroupsClaim].(type) {
+	case string:
+		groups = append(groups, v)
+	case []interface{}:
+		for _, g := range v {
+			s, ok := g.(string)
+			if ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	role := o.conf.role(groups)
+	if len(role) == 0 {
+		return User{}, fmt.Errorf("user %s isn't a member of any allowed group", name)
+	}
+	return User{Name: name, Role: role}, nil
+}
+
+// newOIDCNonce - generate a random value for the log-in state or the nonce
+func newOIDCNonce() (string, error) {
+	buf := make([]byte, 16)
+	_, err := crand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// newOIDCState - generate a new log-in state value
+func (a *Auth) newOIDCState() (string, error) {
+	state, err := newOIDCNonce()
+	if err != nil {
+		return "", err
+	}
+
+	now := uint32(time.Now().Unix())
+	a.lock.Lock()
+	for k, exp := range a.oidcStates {
+		if exp <= now {
+			delete(a.oidcStates, k)
+		}
+	}
+	a.oidcStates[state] = now + oidcStateTTL
+	a.lock.Unlock()
+	return state, nil
+}
+
+// checkOIDCState - check and remove the log-in state value
+func (a *Auth) checkOIDCState(state string) bool {
+	now := uint32(time.Now().Unix())
+	a.lock.Lock()
+	exp, ok := a.oidcStates[state]
+	delete(a.oidcStates, state)
+	a.lock.Unlock()
+	return ok && exp > now
+}
+
+// oidcCookieNonce - get the nonce from the state cookie if the state matches the one in the cookie
+func oidcCookieNonce(r *http.Request, state string) (string, bool) {
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 2 || len(state) == 0 ||
+		subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// Redirect the user to the OpenID Connect provider's log-in page
+func handleLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if Context.auth.oidc == nil {
+		http.Error(w, "OpenID Connect authentication is disabled", http.StatusNotFound)
+		return
+	}
+
+	state, err := Context.auth.newOIDCState()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Auth: OIDC: state: %s", err)
+		return
+	}
+	nonce, err := newOIDCNonce()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Auth: OIDC: nonce: %s", err)
+		return
+	}
+	u, err := Context.auth.oidc.authURL(state, nonce)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Auth: OIDC: %s", err)
+		return
+	}
+
+	// the callback is accepted only in the browser that has this cookie:
+	// otherwise the user could be logged in with the account of whoever has sent him the callback URL
+	// SameSite=Lax:  the cookie is sent when the provider redirects the user back
+	w.Header().Set("Set-Cookie", fmt.Sprintf("%s=%s.%s; Path=/control/login/oidc; Max-Age=%d; HttpOnly; SameSite=Lax",
+		oidcStateCookie, state, nonce, oidcStateTTL))
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// Complete the log-in operation after the user is redirected back by the OpenID Connect provider
+func handleLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if Context.auth.oidc == nil {
+		http.Error(w, "OpenID Connect authentication is disabled", http.StatusNotFound)
+		return
+	}
+
+	// the state cookie is used once
+	w.Header().Add("Set-Cookie", fmt.Sprintf("%s=; Path=/control/login/oidc; Max-Age=0; HttpOnly; SameSite=Lax",
+		oidcStateCookie))
+
+	q := r.URL.Query()
+	if e := q.Get("error"); len(e) != 0 {
+		httpError(w, http.StatusForbidden, "Auth: OIDC: provider returned an error: %s", e)
+		return
+	}
+	state := q.Get("state")
+	nonce, ok := oidcCookieNonce(r, state)
+	if !ok || !Context.auth.checkOIDCState(state) {
+		httpError(w, http.StatusBadRequest, "Auth: OIDC: invalid state")
+		return
+	}
+
+	u, err := Context.auth.oidc.exchange(q.Get("code"), nonce)
+	if err != nil {
+		log.Info("Auth: OIDC: %s", err)
+		time.Sleep(1 * time.Second)
+		http.Error(w, "authentication failed", http.StatusForbidden)
+		return
+	}
+
+	log.Debug("Auth: OIDC: authenticated %s (%s)", u.Name, u.Role)
+	w.Header().Add("Set-Cookie", Context.auth.newSessionCookie(u))
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, proxy-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	http.Redirect(w, r, "/", http.StatusFound)
+}
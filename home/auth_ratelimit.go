@@ -0,0 +1,103 @@
+package home
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// failedAuth - failed log-in attempts from an IP address
+type failedAuth struct {
+	num   uint      // the number of failed attempts
+	until time.Time // new attempts are blocked (or the counter is reset) after this time
+}
+
+// authRateLimiter - limits the number of failed log-in attempts from one IP address
+type authRateLimiter struct {
+	failedAuths map[string]failedAuth // IP -> failed attempts
+	lock        sync.Mutex
+	blockDur    time.Duration // for how long new attempts are blocked
+	maxAttempts uint          // the number of failed attempts before the IP is blocked
+}
+
+// newAuthRateLimiter - create a new object
+// Return nil if the limit is disabled.
+func newAuthRateLimiter(blockDur time.Duration, maxAttempts uint) *authRateLimiter {
+	if maxAttempts == 0 || blockDur == 0 {
+		return nil
+	}
+	return &authRateLimiter{
+		failedAuths: make(map[string]failedAuth),
+		blockDur:    blockDur,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// cleanupLocked - remove the expired entries
+func (ab *authRateLimiter) cleanupLocked(now time.Time) {
+	for k, v := range ab.failedAuths {
+		if !now.Before(v.until) {
+			delete(ab.failedAuths, k)
+		}
+	}
+}
+
+// check - get the time left until the IP address is unblocked.  0: not blocked
+func (ab *authRateLimiter) check(ip string) time.Duration {
+	if ab == nil {
+		return 0
+	}
+	now := time.Now()
+
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	a, ok := ab.failedAuths[ip]
+	if !ok || a.num < ab.maxAttempts || !now.Before(a.until) {
+		return 0
+	}
+	return a.until.Sub(now)
+}
+
+// inc - count a failed attempt
+func (ab *authRateLimiter) inc(ip string) {
+	if ab == nil {
+		return
+	}
+	now := time.Now()
+
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	a, ok := ab.failedAuths[ip]
+	if !ok || !now.Before(a.until) {
+		a = failedAuth{}
+	}
+	a.num++
+	a.until = now.Add(ab.blockDur)
+	ab.failedAuths[ip] = a
+
+	if a.num == ab.maxAttempts {
+		log.Info("Auth: blocked log-in attempts from ip=%s for %s", ip, ab.blockDur)
+	}
+	ab.cleanupLocked(now)
+}
+
+// remove - reset the counter after a successful log-in
+func (ab *authRateLimiter) remove(ip string) {
+	if ab == nil {
+		return
+	}
+
+	ab.lock.Lock()
+	delete(ab.failedAuths, ip)
+	ab.lock.Unlock()
+}
+
+// logFailedAuth - print a log message about a failed log-in attempt
+// The format must stay stable because it's used by fail2ban filters, e.g.:
+// `Auth: failed login attempt from ip=1.2.3.4 user="name"`
+func logFailedAuth(ip, name string) {
+	log.Info("Auth: failed login attempt from ip=%s user=%q", ip, name)
+}
@@ -0,0 +1,40 @@
+package home
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthRateLimiter(t *testing.T) {
+	assert.Nil(t, newAuthRateLimiter(0, 5))
+	assert.Nil(t, newAuthRateLimiter(time.Minute, 0))
+
+	var ab *authRateLimiter
+	ab.inc("1.2.3.4")
+	assert.Equal(t, time.Duration(0), ab.check("1.2.3.4"))
+
+	ab = newAuthRateLimiter(time.Minute, 2)
+	ab.inc("1.2.3.4")
+	assert.Equal(t, time.Duration(0), ab.check("1.2.3.4"))
+
+	// blocked after the second attempt
+	ab.inc("1.2.3.4")
+	left := ab.check("1.2.3.4")
+	assert.True(t, left > 0 && left <= time.Minute)
+	assert.Equal(t, time.Duration(0), ab.check("1.2.3.5"))
+
+	// a successful log-in resets the counter
+	ab.remove("1.2.3.4")
+	assert.Equal(t, time.Duration(0), ab.check("1.2.3.4"))
+
+	// expired
+	ab.blockDur = -time.Second
+	ab.inc("1.2.3.4")
+	ab.inc("1.2.3.4")
+	assert.Equal(t, time.Duration(0), ab.check("1.2.3.4"))
+	ab.inc("1.2.3.5")
+	_, ok := ab.failedAuths["1.2.3.4"]
+	assert.False(t, ok)
+}
@@ -1,6 +1,7 @@
 package home
 
 import (
+	"encoding/base64"
 	"encoding/hex"
 	"net/http"
 	"net/url"
@@ -27,7 +28,7 @@ func TestAuth(t *testing.T) {
 	users := []User{
 		User{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
 	}
-	a := InitAuth(fn, nil, 60)
+	a := InitAuth(fn, nil, 60, nil)
 	s := session{}
 
 	user := User{Name: "name"}
@@ -54,7 +55,7 @@ func TestAuth(t *testing.T) {
 	a.Close()
 
 	// load saved session
-	a = InitAuth(fn, users, 60)
+	a = InitAuth(fn, users, 60, nil)
 
 	// the session is still alive
 	assert.True(t, a.CheckSession(sessStr) == 0)
@@ -69,7 +70,7 @@ func TestAuth(t *testing.T) {
 	time.Sleep(3 * time.Second)
 
 	// load and remove expired sessions
-	a = InitAuth(fn, users, 60)
+	a = InitAuth(fn, users, 60, nil)
 	assert.True(t, a.CheckSession(sessStr) == -1)
 
 	a.Close()
@@ -100,7 +101,7 @@ func TestAuthHTTP(t *testing.T) {
 	users := []User{
 		User{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
 	}
-	Context.auth = InitAuth(fn, users, 60)
+	Context.auth = InitAuth(fn, users, 60, nil)
 
 	handlerCalled := false
 	handler := func(w http.ResponseWriter, r *http.Request) {
@@ -175,3 +176,156 @@ func TestAuthHTTP(t *testing.T) {
 
 	Context.auth.Close()
 }
+
+func TestSessionSerialize(t *testing.T) {
+	s := session{userName: "name", role: roleViewer, expire: 123}
+	s2 := session{}
+	assert.True(t, s2.deserialize(s.serialize()))
+	assert.Equal(t, s, s2)
+
+	// session created by an older version
+	data := s.serialize()
+	data = data[:4+2+len(s.userName)]
+	s2 = session{}
+	assert.True(t, s2.deserialize(data))
+	assert.Equal(t, "name", s2.userName)
+	assert.Equal(t, "", s2.role)
+}
+
+func TestAuthRoleMapping(t *testing.T) {
+	m := authRoleMapping{}
+	assert.Equal(t, roleAdmin, m.role(nil))
+
+	m.AdminGroups = []string{"cn=admins,dc=example,dc=org"}
+	m.ViewerGroups = []string{"cn=users,dc=example,dc=org"}
+	assert.Equal(t, roleAdmin, m.role([]string{"cn=users,dc=example,dc=org", "CN=Admins,DC=example,DC=org"}))
+	assert.Equal(t, roleViewer, m.role([]string{"cn=users,dc=example,dc=org"}))
+	assert.Equal(t, "", m.role([]string{"cn=guests,dc=example,dc=org"}))
+	assert.Equal(t, "", m.role(nil))
+
+	o := newOIDCAuth(oidcConfig{
+		Enabled:     true,
+		Issuer:      "https://accounts.example.org",
+		ClientID:    "id",
+		RedirectURL: "https://adguard.example.org/control/login/oidc/callback",
+		authRoleMapping: authRoleMapping{
+			ViewerGroups: []string{"family"},
+		},
+	})
+	u, err := o.userFromClaims(map[string]interface{}{
+		"sub":                "123",
+		"preferred_username": "user",
+		"groups":             []interface{}{"family", "friends"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "user", u.Name)
+	assert.Equal(t, roleViewer, u.Role)
+
+	_, err = o.userFromClaims(map[string]interface{}{"sub": "123", "groups": "friends"})
+	assert.NotNil(t, err)
+}
+
+func TestOIDCIDToken(t *testing.T) {
+	o := newOIDCAuth(oidcConfig{
+		Issuer:      "https://accounts.example.org/",
+		ClientID:    "id",
+		RedirectURL: "https://adguard.example.org/control/login/oidc/callback",
+	})
+	now := time.Unix(1600000000, 0)
+	token := func(payload string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+	}
+
+	sub, err := o.checkIDToken(token(`{"iss":"https://accounts.example.org","sub":"123","aud":["other","id"],"exp":1600000100,"nonce":"n1"}`), "n1", now)
+	assert.Nil(t, err)
+	assert.Equal(t, "123", sub)
+
+	for _, payload := range []string{
+		`{"iss":"https://accounts.example.org","sub":"123","aud":"id","exp":1600000100,"nonce":"n2"}`,
+		`{"iss":"https://accounts.example.org","sub":"123","aud":"id","exp":1600000100}`,
+		`{"iss":"https://evil.example.org","sub":"123","aud":"id","exp":1600000100,"nonce":"n1"}`,
+		`{"iss":"https://accounts.example.org","sub":"123","aud":"other","exp":1600000100,"nonce":"n1"}`,
+		`{"iss":"https://accounts.example.org","sub":"123","aud":"id","exp":1599999999,"nonce":"n1"}`,
+	} {
+		_, err = o.checkIDToken(token(payload), "n1", now)
+		assert.NotNil(t, err, payload)
+	}
+	_, err = o.checkIDToken("invalid", "n1", now)
+	assert.NotNil(t, err)
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := filepath.Join(dir, "sessions.db")
+
+	Context.auth = InitAuth(fn, nil, 60, nil)
+	defer func() {
+		Context.auth.Close()
+		Context.auth = nil
+	}()
+	Context.auth.oidc = &oidcAuth{}
+	state, err := Context.auth.newOIDCState()
+	assert.Nil(t, err)
+
+	// the callback URL with a valid state is opened in a browser that hasn't started the log-in
+	w := testResponseWriter{}
+	w.hdr = make(http.Header)
+	r := http.Request{}
+	r.Header = make(http.Header)
+	r.URL = &url.URL{Path: "/control/login/oidc/callback", RawQuery: "code=attacker&state=" + state}
+	handleLoginOIDCCallback(&w, &r)
+	assert.Equal(t, http.StatusBadRequest, w.statusCode)
+
+	// the state in the cookie doesn't match
+	r.Header.Set("Cookie", oidcStateCookie+"=other.nonce")
+	w.statusCode = 0
+	handleLoginOIDCCallback(&w, &r)
+	assert.Equal(t, http.StatusBadRequest, w.statusCode)
+
+	nonce, ok := oidcCookieNonce(&r, state)
+	assert.False(t, ok)
+	r.Header.Set("Cookie", oidcStateCookie+"="+state+".nonce")
+	nonce, ok = oidcCookieNonce(&r, state)
+	assert.True(t, ok)
+	assert.Equal(t, "nonce", nonce)
+}
+
+func TestAuthViewerRole(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := filepath.Join(dir, "sessions.db")
+
+	Context.auth = InitAuth(fn, nil, 60, nil)
+	Context.auth.oidc = &oidcAuth{}
+	cookie := Context.auth.newSessionCookie(User{Name: "viewer", Role: roleViewer})
+
+	handlerCalled := false
+	handler := optionalAuth(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	})
+	w := testResponseWriter{}
+	w.hdr = make(http.Header)
+	r := http.Request{}
+	r.Header = make(http.Header)
+	r.Header.Set("Cookie", cookie)
+	r.URL = &url.URL{Path: "/control/status"}
+
+	r.Method = "GET"
+	handler(&w, &r)
+	assert.True(t, handlerCalled)
+
+	handlerCalled = false
+	r.Method = "POST"
+	handler(&w, &r)
+	assert.False(t, handlerCalled)
+	assert.Equal(t, http.StatusForbidden, w.statusCode)
+
+	r.Method = "GET"
+	u := Context.auth.GetCurrentUser(&r)
+	assert.Equal(t, "viewer", u.Name)
+	assert.Equal(t, roleViewer, u.Role)
+
+	Context.auth.Close()
+	Context.auth = nil
+}
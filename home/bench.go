@@ -0,0 +1,406 @@
+package home
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/miekg/dns"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The domains used for the synthetic query set
+var benchDomains = []string{
+	"google.com", "youtube.com", "facebook.com", "amazon.com", "wikipedia.org",
+	"twitter.com", "instagram.com", "linkedin.com", "netflix.com", "microsoft.com",
+	"apple.com", "github.com", "reddit.com", "yahoo.com", "bing.com",
+	"cloudflare.com", "adguard.com", "doubleclick.net", "googlesyndication.com", "google-analytics.com",
+}
+
+// benchOptions - 'bench' command arguments
+type benchOptions struct {
+	configFilename string        // path to the config file: the DNS server address is taken from it
+	server         string        // DNS server address (overrides the config file)
+	queriesFile    string        // replay the queries from this file
+	count          int           // the number of queries to send
+	duration       time.Duration // for how long to send the queries (overrides count)
+	concurrency    int           // the number of parallel clients
+	uniquePercent  int           // synthetic query set: the percentage of unique (not cached) host names
+	timeout        time.Duration // query timeout
+}
+
+// benchQuery - a query from the query set
+type benchQuery struct {
+	host  string
+	qtype uint16
+}
+
+// benchResult - the results of the benchmark
+type benchResult struct {
+	sent      int
+	errors    int // timeouts and network errors
+	rcodes    map[int]int
+	elapsed   time.Duration
+	latencies []time.Duration // sorted
+}
+
+// runBench - handle 'bench' command: load-test DNS server and print the results
+func runBench(args []string) {
+	for _, a := range args {
+		if a == "--help" {
+			printBenchHelp()
+			os.Exit(0)
+		}
+	}
+
+	o, err := parseBenchOptions(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n", err)
+		printBenchHelp()
+		os.Exit(64)
+	}
+
+	if len(o.server) == 0 {
+		o.server, err = benchServerAddr(o.configFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var queries []benchQuery
+	if len(o.queriesFile) != 0 {
+		queries, err = loadBenchQueries(o.queriesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Sending queries to %s with %d clients...\n", o.server, o.concurrency)
+	r := bench(o, queries)
+	printBenchResult(r)
+}
+
+func printBenchHelp() {
+	fmt.Printf("Usage:\n\n")
+	fmt.Printf("%s bench [options]\n\n", os.Args[0])
+	fmt.Printf("Options:\n")
+	fmt.Printf("  -c, %-30s %s\n", "--config VALUE", "Path to the config file (default: AdGuardHome.yaml)")
+	fmt.Printf("  %-34s %s\n", "--server VALUE", "DNS server address (default: from the config file)")
+	fmt.Printf("  %-34s %s\n", "--queries VALUE", "Replay the queries from file: query log file or 'host [type]' lines")
+	fmt.Printf("  -n, %-30s %s\n", "--count VALUE", "The number of queries (default: 10000)")
+	fmt.Printf("  -d, %-30s %s\n", "--duration VALUE", "Send the queries for this time, e.g. 30s (overrides --count)")
+	fmt.Printf("  %-34s %s\n", "--concurrency VALUE", "The number of parallel clients (default: 10)")
+	fmt.Printf("  %-34s %s\n", "--unique VALUE", "Synthetic queries: the percentage of unique host names (default: 10)")
+	fmt.Printf("  %-34s %s\n", "--timeout VALUE", "Query timeout (default: 2s)")
+}
+
+// parseBenchOptions - parse 'bench' command arguments
+func parseBenchOptions(args []string) (benchOptions, error) {
+	o := benchOptions{
+		configFilename: "AdGuardHome.yaml",
+		count:          10000,
+		concurrency:    10,
+		uniquePercent:  10,
+		timeout:        2 * time.Second,
+	}
+
+	parseInt := func(v string, dst *int) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number: %s", v)
+		}
+		*dst = n
+		return nil
+	}
+	parseDuration := func(v string, dst *time.Duration) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration: %s", v)
+		}
+		*dst = d
+		return nil
+	}
+
+	var opts = []struct {
+		longName  string
+		shortName string
+		callback  func(value string) error
+	}{
+		{"config", "c", func(v string) error { o.configFilename = v; return nil }},
+		{"server", "", func(v string) error { o.server = v; return nil }},
+		{"queries", "", func(v string) error { o.queriesFile = v; return nil }},
+		{"count", "n", func(v string) error { return parseInt(v, &o.count) }},
+		{"duration", "d", func(v string) error { return parseDuration(v, &o.duration) }},
+		{"concurrency", "", func(v string) error { return parseInt(v, &o.concurrency) }},
+		{"unique", "", func(v string) error { return parseInt(v, &o.uniquePercent) }},
+		{"timeout", "", func(v string) error { return parseDuration(v, &o.timeout) }},
+	}
+
+	for i := 0; i < len(args); i++ {
+		v := args[i]
+		knownParam := false
+		for _, opt := range opts {
+			if v != "--"+opt.longName && (opt.shortName == "" || v != "-"+opt.shortName) {
+				continue
+			}
+			if i+1 >= len(args) {
+				return o, fmt.Errorf("got %s without argument", v)
+			}
+			i++
+			err := opt.callback(args[i])
+			if err != nil {
+				return o, fmt.Errorf("%s: %s", v, err)
+			}
+			knownParam = true
+			break
+		}
+		if !knownParam {
+			return o, fmt.Errorf("unknown option %s", v)
+		}
+	}
+
+	if o.concurrency == 0 {
+		return o, fmt.Errorf("--concurrency must be greater than 0")
+	}
+	if o.uniquePercent > 100 {
+		return o, fmt.Errorf("--unique must be in range 0..100")
+	}
+	if o.count == 0 && o.duration == 0 {
+		return o, fmt.Errorf("--count must be greater than 0")
+	}
+	return o, nil
+}
+
+// benchServerAddr - get the address of DNS server from the configuration file
+func benchServerAddr(configFilename string) (string, error) {
+	data, err := ioutil.ReadFile(configFilename)
+	if err != nil {
+		return "", err
+	}
+	c := configuration{}
+	err = yaml.Unmarshal(data, &c)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", configFilename, err)
+	}
+	if c.DNS.Port == 0 {
+		return "", fmt.Errorf("%s: DNS server port isn't set", configFilename)
+	}
+
+	host := util.TrimBrackets(c.DNS.BindHost)
+	if len(host) == 0 || util.IsUnspecifiedHost(host) {
+		host = util.LocalhostIP()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(c.DNS.Port)), nil
+}
+
+// loadBenchQueries - load the queries from file
+// Each line is either a query log entry (JSON) or "host [type]" string.
+func loadBenchQueries(fn string) ([]benchQuery, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	queries := []benchQuery{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		q, ok := parseBenchQuery(sc.Text())
+		if ok {
+			queries = append(queries, q)
+		}
+	}
+	if sc.Err() != nil {
+		return nil, fmt.Errorf("%s: %s", fn, sc.Err())
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%s: no queries", fn)
+	}
+	return queries, nil
+}
+
+// parseBenchQuery - parse the line of the queries file
+func parseBenchQuery(line string) (benchQuery, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return benchQuery{}, false
+	}
+
+	host := ""
+	qtype := "A"
+	if line[0] == '{' {
+		e := struct {
+			QHost string `json:"QH"`
+			QType string `json:"QT"`
+		}{}
+		err := json.Unmarshal([]byte(line), &e)
+		if err != nil {
+			return benchQuery{}, false
+		}
+		host = e.QHost
+		if len(e.QType) != 0 {
+			qtype = e.QType
+		}
+	} else {
+		fields := strings.Fields(line)
+		host = fields[0]
+		if len(fields) > 1 {
+			qtype = strings.ToUpper(fields[1])
+		}
+	}
+
+	t, ok := dns.StringToType[qtype]
+	if len(host) == 0 || !ok {
+		return benchQuery{}, false
+	}
+	return benchQuery{host: dns.Fqdn(host), qtype: t}, true
+}
+
+// syntheticQuery - get the next query of the synthetic query set
+// Some of the host names are unique, so they aren't in cache.
+func syntheticQuery(rnd *rand.Rand, uniquePercent int) benchQuery {
+	host := benchDomains[rnd.Intn(len(benchDomains))]
+	if rnd.Intn(100) < uniquePercent {
+		host = fmt.Sprintf("bench-%x.%s", rnd.Int63(), host)
+	}
+	qtype := dns.TypeA
+	if rnd.Intn(4) == 0 {
+		qtype = dns.TypeAAAA
+	}
+	return benchQuery{host: dns.Fqdn(host), qtype: qtype}
+}
+
+// bench - send the queries to DNS server
+// If the query set is empty, the synthetic queries are used.
+func bench(o benchOptions, queries []benchQuery) benchResult {
+	var next int64 = -1 // the index of the last sent query
+	deadline := time.Time{}
+	if o.duration != 0 {
+		deadline = time.Now().Add(o.duration)
+	}
+
+	type clientResult struct {
+		errors    int
+		rcodes    map[int]int
+		latencies []time.Duration
+	}
+	results := make([]clientResult, o.concurrency)
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i != o.concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res := &results[i]
+			res.rcodes = map[int]int{}
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+			c := dns.Client{Timeout: o.timeout}
+
+			for {
+				n := atomic.AddInt64(&next, 1)
+				if deadline.IsZero() {
+					if n >= int64(o.count) {
+						return
+					}
+				} else if time.Now().After(deadline) {
+					return
+				}
+
+				var q benchQuery
+				if len(queries) != 0 {
+					q = queries[n%int64(len(queries))]
+				} else {
+					q = syntheticQuery(rnd, o.uniquePercent)
+				}
+
+				req := dns.Msg{}
+				req.SetQuestion(q.host, q.qtype)
+				resp, rtt, err := c.Exchange(&req, o.server)
+				if err != nil {
+					res.errors++
+					continue
+				}
+				res.rcodes[resp.Rcode]++
+				res.latencies = append(res.latencies, rtt)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	r := benchResult{
+		rcodes:  map[int]int{},
+		elapsed: time.Since(start),
+	}
+	for _, res := range results {
+		r.errors += res.errors
+		for rcode, n := range res.rcodes {
+			r.rcodes[rcode] += n
+		}
+		r.latencies = append(r.latencies, res.latencies...)
+	}
+	r.sent = r.errors + len(r.latencies)
+	sort.Slice(r.latencies, func(i, j int) bool {
+		return r.latencies[i] < r.latencies[j]
+	})
+	return r
+}
+
+// percentile - get the latency percentile (p: 0..100)
+func (r *benchResult) percentile(p int) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := (len(r.latencies)*p + 99) / 100
+	if i != 0 {
+		i--
+	}
+	return r.latencies[i]
+}
+
+// qps - get the number of answered queries per second
+func (r *benchResult) qps() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(len(r.latencies)) / r.elapsed.Seconds()
+}
+
+func printBenchResult(r benchResult) {
+	fmt.Printf("\n")
+	fmt.Printf("Queries sent:      %d\n", r.sent)
+	fmt.Printf("Queries answered:  %d\n", len(r.latencies))
+	fmt.Printf("Errors/timeouts:   %d\n", r.errors)
+	fmt.Printf("Elapsed:           %v\n", r.elapsed.Round(time.Millisecond))
+	fmt.Printf("QPS:               %.1f\n", r.qps())
+	fmt.Printf("\n")
+	fmt.Printf("Latency:\n")
+	for _, p := range []int{50, 90, 95, 99, 100} {
+		fmt.Printf("  p%-3d %v\n", p, r.percentile(p).Round(time.Microsecond))
+	}
+
+	rcodes := []int{}
+	for rcode := range r.rcodes {
+		rcodes = append(rcodes, rcode)
+	}
+	sort.Ints(rcodes)
+	fmt.Printf("\n")
+	fmt.Printf("Response codes:\n")
+	for _, rcode := range rcodes {
+		fmt.Printf("  %-10s %d\n", dns.RcodeToString[rcode], r.rcodes[rcode])
+	}
+}
@@ -0,0 +1,73 @@
+package home
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBenchQuery(t *testing.T) {
+	q, ok := parseBenchQuery(`{"QH":"example.org","QT":"AAAA","QC":"IN","CP":""}`)
+	assert.True(t, ok)
+	assert.Equal(t, benchQuery{host: "example.org.", qtype: dns.TypeAAAA}, q)
+
+	q, ok = parseBenchQuery("example.org mx")
+	assert.True(t, ok)
+	assert.Equal(t, benchQuery{host: "example.org.", qtype: dns.TypeMX}, q)
+
+	q, ok = parseBenchQuery("example.org")
+	assert.True(t, ok)
+	assert.Equal(t, dns.TypeA, q.qtype)
+
+	_, ok = parseBenchQuery("# comment")
+	assert.False(t, ok)
+	_, ok = parseBenchQuery("example.org XXX")
+	assert.False(t, ok)
+}
+
+func TestBenchPercentile(t *testing.T) {
+	r := benchResult{}
+	for i := 1; i <= 100; i++ {
+		r.latencies = append(r.latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, r.percentile(50))
+	assert.Equal(t, 99*time.Millisecond, r.percentile(99))
+	assert.Equal(t, 100*time.Millisecond, r.percentile(100))
+
+	r.latencies = nil
+	assert.Equal(t, time.Duration(0), r.percentile(50))
+}
+
+func TestBench(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := &dns.Server{
+		PacketConn: conn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := dns.Msg{}
+			resp.SetRcode(req, dns.RcodeNameError)
+			_ = w.WriteMsg(&resp)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	defer func() { _ = srv.Shutdown() }()
+
+	o := benchOptions{
+		server:        conn.LocalAddr().String(),
+		count:         100,
+		concurrency:   4,
+		uniquePercent: 50,
+		timeout:       time.Second,
+	}
+	r := bench(o, nil)
+	assert.Equal(t, 100, r.sent)
+	assert.Equal(t, 0, r.errors)
+	assert.Equal(t, 100, r.rcodes[dns.RcodeNameError])
+
+	queries := []benchQuery{{host: "example.org.", qtype: dns.TypeA}}
+	r = bench(o, queries)
+	assert.Equal(t, 100, len(r.latencies))
+}
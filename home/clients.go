@@ -43,6 +43,11 @@ type Client struct {
 
 	Upstreams []string // list of upstream servers to be used for the client's requests
 
+	EncryptedDNSExempt bool // the client may use DoH/DoT servers
+
+	// IDs of the blocklists used for the client's requests instead of all enabled blocklists (empty: all)
+	FilterIDs []int64
+
 	// Custom upstream config for this client
 	// nil: not yet initialized
 	// not nil, but empty: initialized, no good upstreams
@@ -77,6 +82,10 @@ type clientsContainer struct {
 
 	allTags map[string]bool
 
+	// The clients and tags whose internet access is turned off
+	internetOff     []internetOffEntry
+	internetOffLock sync.Mutex
+
 	// dhcpServer is used for looking up clients IP addresses by MAC addresses
 	dhcpServer *dhcpd.Server
 
@@ -142,6 +151,10 @@ type clientObject struct {
 	BlockedServices          []string `yaml:"blocked_services"`
 
 	Upstreams []string `yaml:"upstreams"`
+
+	EncryptedDNSExempt bool `yaml:"encrypted_dns_exempt"`
+
+	FilterIDs []int64 `yaml:"filter_ids,omitempty"`
 }
 
 func (clients *clientsContainer) tagKnown(tag string) bool {
@@ -163,6 +176,8 @@ func (clients *clientsContainer) addFromConfig(objects []clientObject) {
 			UseOwnBlockedServices: !cy.UseGlobalBlockedServices,
 
 			Upstreams: cy.Upstreams,
+
+			EncryptedDNSExempt: cy.EncryptedDNSExempt,
 		}
 
 		for _, s := range cy.BlockedServices {
@@ -182,6 +197,14 @@ func (clients *clientsContainer) addFromConfig(objects []clientObject) {
 		}
 		sort.Strings(cli.Tags)
 
+		for _, id := range cy.FilterIDs {
+			if !blocklistExists(id) {
+				log.Debug("Clients: skipping unknown filter %d", id)
+				continue
+			}
+			cli.FilterIDs = append(cli.FilterIDs, id)
+		}
+
 		_, err := clients.Add(cli)
 		if err != nil {
 			log.Tracef("clientAdd: %s", err)
@@ -201,12 +224,14 @@ func (clients *clientsContainer) WriteDiskConfig(objects *[]clientObject) {
 			SafeSearchEnabled:        cli.SafeSearchEnabled,
 			SafeBrowsingEnabled:      cli.SafeBrowsingEnabled,
 			UseGlobalBlockedServices: !cli.UseOwnBlockedServices,
+			EncryptedDNSExempt:       cli.EncryptedDNSExempt,
 		}
 
 		cy.Tags = stringArrayDup(cli.Tags)
 		cy.IDs = stringArrayDup(cli.IDs)
 		cy.BlockedServices = stringArrayDup(cli.BlockedServices)
 		cy.Upstreams = stringArrayDup(cli.Upstreams)
+		cy.FilterIDs = append([]int64{}, cli.FilterIDs...)
 
 		*objects = append(*objects, cy)
 	}
@@ -292,15 +317,32 @@ func (clients *clientsContainer) FindUpstreams(ip string) *proxy.UpstreamConfig
 	}
 
 	if c.upstreamConfig == nil {
-		config, err := proxy.ParseUpstreamsConfig(c.Upstreams, config.DNS.BootstrapDNS, dnsforward.DefaultTimeout)
+		// the upstream servers from the user rules are used for all clients
+		upstreams := append(append([]string{}, c.Upstreams...), userUpstreamRules()...)
+		upstreamConfig, err := proxy.ParseUpstreamsConfig(util.NormalizeUpstreams(upstreams),
+			util.NormalizeUpstreams(config.DNS.BootstrapDNS), dnsforward.DefaultTimeout)
 		if err == nil {
-			c.upstreamConfig = &config
+			err = dnsforward.SetUpstreamProxy(&upstreamConfig, config.DNS.UpstreamProxy)
+		}
+		if err == nil {
+			dnsforward.SetUpstreamTCPOnly(&upstreamConfig, config.DNS.UpstreamTCPOnly)
+			dnsforward.HardenUpstreams(&upstreamConfig, config.DNS.Upstream0x20, config.DNS.Upstream0x20TCPFallback)
+			c.upstreamConfig = &upstreamConfig
 		}
 	}
 
 	return c.upstreamConfig
 }
 
+// resetUpstreamConfigs - parse the clients' upstream servers again on the next request
+func (clients *clientsContainer) resetUpstreamConfigs() {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+	for _, c := range clients.list {
+		c.upstreamConfig = nil
+	}
+}
+
 // Find searches for a client by IP (and does not lock anything)
 func (clients *clientsContainer) findByIP(ip string) (Client, bool) {
 	ipAddr := net.ParseIP(ip)
@@ -401,11 +443,17 @@ func (clients *clientsContainer) check(c *Client) error {
 	}
 	sort.Strings(c.Tags)
 
+	err := checkClientFilters(c.FilterIDs)
+	if err != nil {
+		return err
+	}
+
 	if len(c.Upstreams) != 0 {
 		err := dnsforward.ValidateUpstreams(c.Upstreams)
 		if err != nil {
 			return fmt.Errorf("invalid upstream servers: %s", err)
 		}
+		c.Upstreams = util.NormalizeUpstreams(c.Upstreams) // internationalized domain names -> punycode
 	}
 
 	return nil
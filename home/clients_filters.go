@@ -0,0 +1,69 @@
+package home
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filterProfileName - get the name of the filter profile for the set of filter IDs: "1,5,7"
+// The clients with the same set of filters use the same filtering engine.
+func filterProfileName(ids []int64) string {
+	sorted := append([]int64{}, ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	s := make([]string, len(sorted))
+	for i, id := range sorted {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(s, ",")
+}
+
+// filterProfiles - get the filter profiles of the persistent clients:  profile name -> filter IDs
+func (clients *clientsContainer) filterProfiles() map[string][]int64 {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+	profiles := map[string][]int64{}
+	for _, c := range clients.list {
+		if len(c.FilterIDs) != 0 {
+			profiles[filterProfileName(c.FilterIDs)] = c.FilterIDs
+		}
+	}
+	return profiles
+}
+
+// applyFilterProfiles - rebuild the filtering engines if the filter profiles of the clients have changed
+func (clients *clientsContainer) applyFilterProfiles(old map[string][]int64) {
+	if !reflect.DeepEqual(old, clients.filterProfiles()) {
+		enableFilters(true)
+	}
+}
+
+// blocklistExists - return TRUE if there's a blocklist with this ID
+func blocklistExists(id int64) bool {
+	config.RLock()
+	defer config.RUnlock()
+	for _, f := range config.Filters {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkClientFilters - check the filter IDs of the client and sort them
+func checkClientFilters(ids []int64) error {
+	for i, id := range ids {
+		if !blocklistExists(id) {
+			return fmt.Errorf("filter not found: %d", id)
+		}
+		for _, id2 := range ids[:i] {
+			if id == id2 {
+				return fmt.Errorf("duplicate filter: %d", id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return nil
+}
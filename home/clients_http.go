@@ -21,6 +21,10 @@ type clientJSON struct {
 	BlockedServices          []string `json:"blocked_services"`
 
 	Upstreams []string `json:"upstreams"`
+
+	EncryptedDNSExempt bool `json:"encrypted_dns_exempt"`
+
+	FilterIDs []int64 `json:"filter_ids"` // the blocklists used instead of all enabled blocklists (empty: all)
 }
 
 type clientHostJSON struct {
@@ -48,20 +52,9 @@ func (clients *clientsContainer) handleGetClients(w http.ResponseWriter, r *http
 	}
 	for ip, ch := range clients.ipHost {
 		cj := clientHostJSON{
-			IP:   ip,
-			Name: ch.Host,
-		}
-
-		cj.Source = "etc/hosts"
-		switch ch.Source {
-		case ClientSourceDHCP:
-			cj.Source = "DHCP"
-		case ClientSourceRDNS:
-			cj.Source = "rDNS"
-		case ClientSourceARP:
-			cj.Source = "ARP"
-		case ClientSourceWHOIS:
-			cj.Source = "WHOIS"
+			IP:     ip,
+			Name:   ch.Host,
+			Source: clientSourceString(ch.Source),
 		}
 
 		cj.WhoisInfo = make(map[string]interface{})
@@ -99,6 +92,10 @@ func jsonToClient(cj clientJSON) (*Client, error) {
 		BlockedServices:       cj.BlockedServices,
 
 		Upstreams: cj.Upstreams,
+
+		EncryptedDNSExempt: cj.EncryptedDNSExempt,
+
+		FilterIDs: cj.FilterIDs,
 	}
 	return &c, nil
 }
@@ -119,6 +116,10 @@ func clientToJSON(c *Client) clientJSON {
 		BlockedServices:          c.BlockedServices,
 
 		Upstreams: c.Upstreams,
+
+		EncryptedDNSExempt: c.EncryptedDNSExempt,
+
+		FilterIDs: append([]int64{}, c.FilterIDs...),
 	}
 	return cj
 }
@@ -163,6 +164,7 @@ func (clients *clientsContainer) handleAddClient(w http.ResponseWriter, r *http.
 		httpError(w, http.StatusBadRequest, "%s", err)
 		return
 	}
+	profiles := clients.filterProfiles()
 	ok, err := clients.Add(*c)
 	if err != nil {
 		httpError(w, http.StatusBadRequest, "%s", err)
@@ -174,6 +176,7 @@ func (clients *clientsContainer) handleAddClient(w http.ResponseWriter, r *http.
 	}
 
 	onConfigModified()
+	clients.applyFilterProfiles(profiles)
 }
 
 // Remove client
@@ -191,12 +194,14 @@ func (clients *clientsContainer) handleDelClient(w http.ResponseWriter, r *http.
 		return
 	}
 
+	profiles := clients.filterProfiles()
 	if !clients.Del(cj.Name) {
 		httpError(w, http.StatusBadRequest, "Client not found")
 		return
 	}
 
 	onConfigModified()
+	clients.applyFilterProfiles(profiles)
 }
 
 type updateJSON struct {
@@ -229,6 +234,7 @@ func (clients *clientsContainer) handleUpdateClient(w http.ResponseWriter, r *ht
 		return
 	}
 
+	profiles := clients.filterProfiles()
 	err = clients.Update(dj.Name, *c)
 	if err != nil {
 		httpError(w, http.StatusBadRequest, "%s", err)
@@ -236,6 +242,7 @@ func (clients *clientsContainer) handleUpdateClient(w http.ResponseWriter, r *ht
 	}
 
 	onConfigModified()
+	clients.applyFilterProfiles(profiles)
 }
 
 // Get the list of clients by IP address list
@@ -284,4 +291,7 @@ func (clients *clientsContainer) registerWebHandlers() {
 	httpRegister("POST", "/control/clients/delete", clients.handleDelClient)
 	httpRegister("POST", "/control/clients/update", clients.handleUpdateClient)
 	httpRegister("GET", "/control/clients/find", clients.handleFindClient)
+	httpRegister("GET", "/control/clients/search", clients.handleSearchClients)
+	httpRegister("GET", "/control/clients/internet_off", clients.handleGetInternetOff)
+	httpRegister("POST", "/control/clients/set_internet_off", clients.handleSetInternetOff)
 }
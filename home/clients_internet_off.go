@@ -0,0 +1,181 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The maximum duration of the "internet off" switch (in minutes)
+const internetOffMaxDuration = 7 * 24 * 60
+
+// internetOffEntry - all DNS requests of the client or of the clients with the tag are blocked until the specified time
+type internetOffEntry struct {
+	Client string    `yaml:"client,omitempty"` // client name
+	Tag    string    `yaml:"tag,omitempty"`
+	Until  time.Time `yaml:"until"`
+}
+
+// setInternetOff - set the active entries
+func (clients *clientsContainer) setInternetOff(entries []internetOffEntry) {
+	now := time.Now()
+	active := []internetOffEntry{}
+	for _, e := range entries {
+		if e.Until.After(now) {
+			active = append(active, e)
+		}
+	}
+
+	clients.internetOffLock.Lock()
+	clients.internetOff = active
+	clients.internetOffLock.Unlock()
+}
+
+// internetOffEntries - get the active entries
+func (clients *clientsContainer) internetOffEntries() []internetOffEntry {
+	now := time.Now()
+	clients.internetOffLock.Lock()
+	defer clients.internetOffLock.Unlock()
+
+	active := []internetOffEntry{}
+	for _, e := range clients.internetOff {
+		if e.Until.After(now) {
+			active = append(active, e)
+		}
+	}
+	clients.internetOff = active
+	return append([]internetOffEntry{}, active...)
+}
+
+// isInternetOff - return TRUE if the client's internet access is turned off
+// The expired entries are ignored, so the switch is turned on automatically.
+func (clients *clientsContainer) isInternetOff(name string, tags []string) bool {
+	now := time.Now()
+	clients.internetOffLock.Lock()
+	defer clients.internetOffLock.Unlock()
+
+	for _, e := range clients.internetOff {
+		if !e.Until.After(now) {
+			continue
+		}
+		if len(e.Client) != 0 && e.Client == name {
+			return true
+		}
+		if len(e.Tag) != 0 && stringArrayContains(tags, e.Tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// switchInternetOff - turn the internet access off for the client or the tag for the duration (0: turn it on)
+func (clients *clientsContainer) switchInternetOff(client, tag string, d time.Duration) {
+	clients.internetOffLock.Lock()
+	defer clients.internetOffLock.Unlock()
+
+	list := []internetOffEntry{}
+	for _, e := range clients.internetOff {
+		if e.Client != client || e.Tag != tag {
+			list = append(list, e)
+		}
+	}
+	if d != 0 {
+		list = append(list, internetOffEntry{
+			Client: client,
+			Tag:    tag,
+			Until:  time.Now().Add(d).Truncate(time.Second),
+		})
+	}
+	clients.internetOff = list
+}
+
+// stringArrayContains - return TRUE if the array contains the string
+func stringArrayContains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type internetOffJSON struct {
+	Client   string `json:"client,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Until    string `json:"until,omitempty"`    // RFC3339
+	Duration uint32 `json:"duration,omitempty"` // in minutes (request only;  0: turn the internet access on)
+}
+
+type internetOffListJSON struct {
+	Entries []internetOffJSON `json:"entries"`
+}
+
+// GET /control/clients/internet_off
+func (clients *clientsContainer) handleGetInternetOff(w http.ResponseWriter, r *http.Request) {
+	resp := internetOffListJSON{Entries: []internetOffJSON{}}
+	for _, e := range clients.internetOffEntries() {
+		resp.Entries = append(resp.Entries, internetOffJSON{
+			Client: e.Client,
+			Tag:    e.Tag,
+			Until:  e.Until.Format(time.RFC3339),
+		})
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// checkInternetOffReq - check the client or the tag of the request
+func (clients *clientsContainer) checkInternetOffReq(req internetOffJSON) error {
+	if (len(req.Client) == 0) == (len(req.Tag) == 0) {
+		return fmt.Errorf("either client or tag must be specified")
+	}
+	if req.Duration > internetOffMaxDuration {
+		return fmt.Errorf("duration must be 0..%d minutes", internetOffMaxDuration)
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+	if len(req.Client) != 0 {
+		if _, ok := clients.list[req.Client]; !ok {
+			return fmt.Errorf("client not found: %s", req.Client)
+		}
+	} else if !clients.tagKnown(req.Tag) {
+		return fmt.Errorf("unknown tag: %s", req.Tag)
+	}
+	return nil
+}
+
+// POST /control/clients/set_internet_off
+func (clients *clientsContainer) handleSetInternetOff(w http.ResponseWriter, r *http.Request) {
+	req := internetOffJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+	err = clients.checkInternetOffReq(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	d := time.Duration(req.Duration) * time.Minute
+	clients.switchInternetOff(req.Client, req.Tag, d)
+	if d != 0 {
+		log.Info("Internet access is turned off for %s%s for %s", req.Client, req.Tag, d)
+	} else {
+		log.Info("Internet access is turned on for %s%s", req.Client, req.Tag)
+	}
+
+	onConfigModified()
+	returnOK(w)
+}
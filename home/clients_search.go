@@ -0,0 +1,222 @@
+package home
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	clientsSearchDefaultLimit = 100
+	clientsSearchMaxLimit     = 1000
+)
+
+// clientSearchJSON - a persistent client with its runtime data or a runtime client
+type clientSearchJSON struct {
+	Name       string                 `json:"name"`       // the name of the persistent client or the host name of the runtime client
+	Persistent bool                   `json:"persistent"` // the client is added by the user
+	IDs        []string               `json:"ids"`        // the IDs of the persistent client (IP, CIDR or MAC)
+	IPs        []string               `json:"ips"`        // the IP addresses seen by the server (from etc/hosts, DHCP, ARP, rDNS or WHOIS)
+	MACs       []string               `json:"macs"`       // the MAC addresses from the IDs and DHCP leases
+	Hosts      []string               `json:"hosts"`      // the host names of the runtime clients
+	Sources    []string               `json:"sources"`    // the sources of the runtime data
+	Tags       []string               `json:"tags"`
+	WhoisInfo  map[string]interface{} `json:"whois_info"`
+
+	Settings *clientJSON `json:"settings,omitempty"` // the settings of the persistent client
+}
+
+type clientSearchResultJSON struct {
+	Total   int                `json:"total"` // the number of clients matching the query
+	Clients []clientSearchJSON `json:"clients"`
+}
+
+// clientSourceString - get the name of the source of the runtime client
+func clientSourceString(src clientSource) string {
+	switch src {
+	case ClientSourceDHCP:
+		return "DHCP"
+	case ClientSourceRDNS:
+		return "rDNS"
+	case ClientSourceARP:
+		return "ARP"
+	case ClientSourceWHOIS:
+		return "WHOIS"
+	}
+	return "etc/hosts"
+}
+
+// appendUnique - append the string if it isn't in the list yet
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// addRuntime - add the data of the runtime client with this IP address
+func (cj *clientSearchJSON) addRuntime(ip string, ch *ClientHost, mac net.HardwareAddr) {
+	cj.IPs = appendUnique(cj.IPs, ip)
+	if len(ch.Host) != 0 {
+		cj.Hosts = appendUnique(cj.Hosts, ch.Host)
+	}
+	cj.Sources = appendUnique(cj.Sources, clientSourceString(ch.Source))
+	if mac != nil {
+		cj.MACs = appendUnique(cj.MACs, mac.String())
+	}
+	for _, wi := range ch.WhoisInfo {
+		cj.WhoisInfo[wi[0]] = wi[1]
+	}
+}
+
+// matches - return TRUE if the client matches the search query and the tag
+// query: a case-insensitive substring of the name, host name, ID, IP or MAC address
+func (cj *clientSearchJSON) matches(query, tag string) bool {
+	if len(tag) != 0 {
+		found := false
+		for _, t := range cj.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(query) == 0 {
+		return true
+	}
+	values := []string{cj.Name}
+	values = append(values, cj.IDs...)
+	values = append(values, cj.IPs...)
+	values = append(values, cj.MACs...)
+	values = append(values, cj.Hosts...)
+	for _, v := range values {
+		if strings.Contains(strings.ToLower(v), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// search - get the persistent clients merged with their runtime data and the other runtime clients
+// The persistent clients go first, each group is sorted by name.
+func (clients *clientsContainer) search(query, tag string) []clientSearchJSON {
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	clients.lock.Lock()
+	persistent := map[string]*clientSearchJSON{}
+	for _, c := range clients.list {
+		settings := clientToJSON(c)
+		cj := &clientSearchJSON{
+			Name:       c.Name,
+			Persistent: true,
+			IDs:        c.IDs,
+			Tags:       c.Tags,
+			WhoisInfo:  map[string]interface{}{},
+			Settings:   &settings,
+		}
+		for _, id := range c.IDs {
+			mac, err := net.ParseMAC(id)
+			if err == nil {
+				cj.MACs = appendUnique(cj.MACs, mac.String())
+			}
+		}
+		persistent[c.Name] = cj
+	}
+
+	var runtime []*clientSearchJSON
+	for ip, ch := range clients.ipHost {
+		var mac net.HardwareAddr
+		ipAddr := net.ParseIP(ip)
+		if clients.dhcpServer != nil && ipAddr != nil {
+			mac = clients.dhcpServer.FindMACbyIP(ipAddr)
+		}
+
+		c, ok := clients.findByIP(ip)
+		if ok {
+			persistent[c.Name].addRuntime(ip, ch, mac)
+			continue
+		}
+		cj := &clientSearchJSON{
+			Name:      ch.Host,
+			WhoisInfo: map[string]interface{}{},
+		}
+		cj.addRuntime(ip, ch, mac)
+		runtime = append(runtime, cj)
+	}
+	clients.lock.Unlock()
+
+	var result []clientSearchJSON
+	for _, cj := range persistent {
+		if cj.matches(query, tag) {
+			result = append(result, *cj)
+		}
+	}
+	for _, cj := range runtime {
+		if cj.matches(query, tag) {
+			result = append(result, *cj)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := &result[i], &result[j]
+		if a.Persistent != b.Persistent {
+			return a.Persistent
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.IPs[0] < b.IPs[0]
+	})
+	return result
+}
+
+// GET /control/clients/search?q=...&tag=...&offset=0&limit=100
+func (clients *clientsContainer) handleSearchClients(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset := 0
+	limit := clientsSearchDefaultLimit
+	var err error
+	if s := q.Get("offset"); len(s) != 0 {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			httpError(w, http.StatusBadRequest, "invalid offset %q", s)
+			return
+		}
+	}
+	if s := q.Get("limit"); len(s) != 0 {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > clientsSearchMaxLimit {
+			httpError(w, http.StatusBadRequest, "invalid limit %q", s)
+			return
+		}
+	}
+
+	found := clients.search(q.Get("q"), q.Get("tag"))
+	resp := clientSearchResultJSON{
+		Total:   len(found),
+		Clients: []clientSearchJSON{},
+	}
+	if offset < len(found) {
+		end := offset + limit
+		if end > len(found) {
+			end = len(found)
+		}
+		resp.Clients = found[offset:end]
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
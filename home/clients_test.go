@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -264,3 +265,136 @@ func TestClientsCustomUpstream(t *testing.T) {
 	assert.Equal(t, 1, len(config.Upstreams))
 	assert.Equal(t, 1, len(config.DomainReservedUpstreams))
 }
+
+func TestClientsSearch(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+
+	_, _ = clients.Add(Client{
+		IDs:  []string{"1.1.1.0/24", "aa:aa:aa:aa:aa:aa"},
+		Tags: []string{"device_laptop"},
+		Name: "laptop",
+	})
+	_, _ = clients.Add(Client{
+		IDs:  []string{"2.2.2.2"},
+		Name: "phone",
+	})
+	_, _ = clients.AddHost("1.1.1.5", "laptop.lan", ClientSourceRDNS)
+	clients.SetWhoisInfo("3.3.3.3", [][]string{{"orgname", "orgname-val"}})
+	_, _ = clients.AddHost("4.4.4.4", "printer.lan", ClientSourceHostsFile)
+
+	// persistent clients first, runtime data is merged
+	found := clients.search("", "")
+	assert.Equal(t, 4, len(found))
+	assert.Equal(t, "laptop", found[0].Name)
+	assert.True(t, found[0].Persistent)
+	assert.Equal(t, []string{"1.1.1.5"}, found[0].IPs)
+	assert.Equal(t, []string{"laptop.lan"}, found[0].Hosts)
+	assert.Equal(t, []string{"rDNS"}, found[0].Sources)
+	assert.Equal(t, []string{"aa:aa:aa:aa:aa:aa"}, found[0].MACs)
+	assert.NotNil(t, found[0].Settings)
+	assert.Equal(t, "phone", found[1].Name)
+	assert.Equal(t, 0, len(found[1].IPs))
+	assert.Equal(t, "", found[2].Name)
+	assert.Equal(t, []string{"3.3.3.3"}, found[2].IPs)
+	assert.Equal(t, "orgname-val", found[2].WhoisInfo["orgname"])
+	assert.False(t, found[2].Persistent)
+	assert.Equal(t, "printer.lan", found[3].Name)
+
+	// search by name, host, IP and MAC
+	found = clients.search("PRINTER", "")
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "printer.lan", found[0].Name)
+	found = clients.search("laptop.lan", "")
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "laptop", found[0].Name)
+	found = clients.search("2.2.2", "")
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "phone", found[0].Name)
+	found = clients.search("AA:AA", "")
+	assert.Equal(t, 1, len(found))
+
+	// search by tag
+	found = clients.search("", "device_laptop")
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "laptop", found[0].Name)
+	found = clients.search("phone", "device_laptop")
+	assert.Equal(t, 0, len(found))
+}
+
+func TestClientsInternetOff(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+	_, _ = clients.Add(Client{IDs: []string{"1.1.1.1"}, Name: "kid", Tags: []string{"user_child"}})
+	_, _ = clients.Add(Client{IDs: []string{"1.1.1.2"}, Name: "adult"})
+
+	assert.NotNil(t, clients.checkInternetOffReq(internetOffJSON{Client: "unknown", Duration: 1}))
+	assert.NotNil(t, clients.checkInternetOffReq(internetOffJSON{Tag: "unknown", Duration: 1}))
+	assert.NotNil(t, clients.checkInternetOffReq(internetOffJSON{Client: "kid", Tag: "user_child"}))
+	assert.NotNil(t, clients.checkInternetOffReq(internetOffJSON{Client: "kid", Duration: internetOffMaxDuration + 1}))
+	assert.Nil(t, clients.checkInternetOffReq(internetOffJSON{Client: "kid", Duration: 60}))
+
+	clients.switchInternetOff("kid", "", time.Hour)
+	assert.True(t, clients.isInternetOff("kid", nil))
+	assert.False(t, clients.isInternetOff("adult", nil))
+
+	// turn on
+	clients.switchInternetOff("kid", "", 0)
+	assert.False(t, clients.isInternetOff("kid", nil))
+
+	// by tag
+	clients.switchInternetOff("", "user_child", time.Hour)
+	assert.True(t, clients.isInternetOff("kid", []string{"user_child"}))
+	assert.Equal(t, 1, len(clients.internetOffEntries()))
+
+	// the expired entries are removed
+	clients.setInternetOff([]internetOffEntry{
+		{Client: "kid", Until: time.Now().Add(-time.Second)},
+		{Client: "adult", Until: time.Now().Add(time.Hour)},
+	})
+	assert.False(t, clients.isInternetOff("kid", []string{"user_child"}))
+	assert.True(t, clients.isInternetOff("adult", nil))
+	assert.Equal(t, 1, len(clients.internetOffEntries()))
+}
+
+func TestClientsFilterProfiles(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+	oldFilters := config.Filters
+	defer func() { config.Filters = oldFilters }()
+	config.Filters = []filter{
+		{Enabled: true, Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, ClientsOnly: true, Filter: dnsfilter.Filter{ID: 5}},
+		{Enabled: false, Filter: dnsfilter.Filter{ID: 7}},
+	}
+
+	// unknown and duplicate filters
+	_, err := clients.Add(Client{Name: "kids", IDs: []string{"1.1.1.1"}, FilterIDs: []int64{1, 2}})
+	assert.NotNil(t, err)
+	_, err = clients.Add(Client{Name: "kids", IDs: []string{"1.1.1.1"}, FilterIDs: []int64{1, 1}})
+	assert.NotNil(t, err)
+
+	ok, err := clients.Add(Client{Name: "kids", IDs: []string{"1.1.1.1"}, FilterIDs: []int64{5, 1, 7}})
+	assert.True(t, ok && err == nil)
+	ok, err = clients.Add(Client{Name: "tablet", IDs: []string{"1.1.1.2"}, FilterIDs: []int64{7, 5, 1}})
+	assert.True(t, ok && err == nil)
+	ok, err = clients.Add(Client{Name: "admin", IDs: []string{"1.1.1.3"}, FilterIDs: []int64{1}})
+	assert.True(t, ok && err == nil)
+	ok, err = clients.Add(Client{Name: "other", IDs: []string{"1.1.1.4"}})
+	assert.True(t, ok && err == nil)
+
+	// the clients with the same filters share the profile
+	profiles := clients.filterProfiles()
+	assert.Equal(t, map[string][]int64{"1,5,7": {1, 5, 7}, "1": {1}}, profiles)
+
+	// the disabled filters aren't used
+	p := filterProfile("1,5,7", profiles["1,5,7"], []byte("||user.example^"))
+	assert.Equal(t, "1,5,7", p.Name)
+	assert.Equal(t, 3, len(p.Filters))
+	assert.Equal(t, int64(0), p.Filters[0].ID)
+	assert.Equal(t, int64(1), p.Filters[1].ID)
+	assert.Equal(t, int64(5), p.Filters[2].ID)
+}
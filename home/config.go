@@ -9,8 +9,10 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/ipfix"
 	"github.com/AdguardTeam/AdGuardHome/querylog"
 	"github.com/AdguardTeam/AdGuardHome/stats"
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/file"
 	"github.com/AdguardTeam/golibs/log"
 	yaml "gopkg.in/yaml.v2"
@@ -23,13 +25,14 @@ const (
 
 // logSettings
 type logSettings struct {
-	LogCompress   bool   `yaml:"log_compress"`    // Compress determines if the rotated log files should be compressed using gzip (default: false)
-	LogLocalTime  bool   `yaml:"log_localtime"`   // If the time used for formatting the timestamps in is the computer's local time (default: false [UTC])
-	LogMaxBackups int    `yaml:"log_max_backups"` // Maximum number of old log files to retain (MaxAge may still cause them to get deleted)
-	LogMaxSize    int    `yaml:"log_max_size"`    // Maximum size in megabytes of the log file before it gets rotated (default 100 MB)
-	LogMaxAge     int    `yaml:"log_max_age"`     // MaxAge is the maximum number of days to retain old log files
-	LogFile       string `yaml:"log_file"`        // Path to the log file. If empty, write to stdout. If "syslog", writes to syslog
-	Verbose       bool   `yaml:"verbose"`         // If true, verbose logging is enabled
+	LogCompress       bool   `yaml:"log_compress"`        // Compress determines if the rotated log files should be compressed using gzip (default: false)
+	LogLocalTime      bool   `yaml:"log_localtime"`       // If the time used for formatting the timestamps in is the computer's local time (default: false [UTC])
+	LogMaxBackups     int    `yaml:"log_max_backups"`     // Maximum number of old log files to retain (MaxAge may still cause them to get deleted)
+	LogMaxSize        int    `yaml:"log_max_size"`        // Maximum size in megabytes of the log file before it gets rotated (default 100 MB)
+	LogMaxAge         int    `yaml:"log_max_age"`         // MaxAge is the maximum number of days to retain old log files
+	LogRotateInterval int    `yaml:"log_rotate_interval"` // Rotate the log file every N hours even if it hasn't reached the maximum size (0: disabled)
+	LogFile           string `yaml:"log_file"`            // Path to the log file. If empty, write to stdout. If "syslog", writes to syslog
+	Verbose           bool   `yaml:"verbose"`             // If true, verbose logging is enabled
 }
 
 // configuration is loaded from YAML
@@ -39,6 +42,12 @@ type configuration struct {
 	// It's reset after config is parsed
 	fileData []byte
 
+	// The settings overridden by the environment variables
+	envOverrides []envOverride
+
+	// The configuration file has been replaced with a snapshot:  don't write the file until restart
+	rollbackPending bool
+
 	BindHost     string `yaml:"bind_host"`     // BindHost is the IP address of the HTTP server to bind to
 	BindPort     int    `yaml:"bind_port"`     // BindPort is the port the HTTP server
 	Users        []User `yaml:"users"`         // Users that can access HTTP server
@@ -47,10 +56,72 @@ type configuration struct {
 	RlimitNoFile uint   `yaml:"rlimit_nofile"` // Maximum number of opened fd's per process (0: default)
 	DebugPProf   bool   `yaml:"debug_pprof"`   // Enable pprof HTTP server on port 6060
 
+	// Switch to this user after start (Linux only).  The privileged ports may still be bound.
+	User  string `yaml:"user"`  // user name or UID
+	Group string `yaml:"group"` // group name or GID (default: the user's primary group)
+
+	// Constrained device profile (routers, single-board computers): limit memory and CPU usage
+	LowResourceMode bool `yaml:"low_resource_mode"`
+
+	// Store the credentials (proxy and upstream passwords, LDAP and OIDC secrets, TLS private key) encrypted.
+	// The key is stored in data/secrets.key file.
+	EncryptSecrets bool `yaml:"encrypt_secrets"`
+
+	// The number of snapshots of the configuration file kept in data/config_history (0: disabled)
+	ConfigHistory uint32 `yaml:"config_history"`
+
 	// TTL for a web session (in hours)
 	// An active session is automatically refreshed once a day.
 	WebSessionTTLHours uint32 `yaml:"web_session_ttl"`
 
+	// The number of failed log-in attempts from one IP address before further attempts are blocked (0: unlimited)
+	AuthAttempts uint `yaml:"auth_attempts"`
+	// For how long log-in attempts are blocked (in minutes)
+	AuthBlockMin uint `yaml:"block_auth_min"`
+
+	// IP addresses or CIDRs of the clients that may use the web interface.  If empty, all clients are allowed.
+	// This doesn't affect DNS-over-HTTPS requests, they are controlled by DNS access settings.
+	WebAllowedClients []string `yaml:"web_allowed_clients"`
+	// IP addresses or CIDRs of the reverse proxies in front of the web interface.
+	// Client's IP address is taken from X-Forwarded-For or X-Real-IP header only for the requests from these addresses.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// Listen addresses on the specific network interfaces with their own access settings.
+	// If set, DNS server and web interface listen on these addresses instead of dns.bind_host and bind_host.
+	Listeners []listenerConfig `yaml:"listeners"`
+
+	// The time of day when the background jobs run: "HH:MM-HH:MM" ("": any time)
+	// The filter updates, the query log rotation, the statistics compaction and the scheduled updates use it
+	// unless they have their own windows.
+	MaintenanceWindow string `yaml:"maintenance_window"`
+
+	// Scheduled updates
+	AutoUpdate autoUpdateConfig `yaml:"auto_update"`
+
+	Notifications notifyConfig `yaml:"notifications"`
+	MQTT          mqttConfig   `yaml:"mqtt"`
+	SNMP          snmpConfig   `yaml:"snmp"`
+	IPFIX         ipfixConfig  `yaml:"ipfix"`
+
+	// Self-hosted Safe Browsing and Parental Control hash server
+	HashServer hashServerConfig `yaml:"hash_server"`
+
+	// OpenTelemetry tracing of DNS requests
+	Tracing tracingConfig `yaml:"tracing"`
+
+	// Hot-standby high availability
+	Failover failoverConfig `yaml:"failover"`
+
+	// Transparent DNS interception (Linux)
+	DNSIntercept interceptConfig `yaml:"dns_intercept"`
+
+	// The requests of the users to unblock a domain
+	UnblockRequests unblockConfig `yaml:"unblock_requests"`
+
+	// Remote authentication backends
+	LDAP ldapConfig `yaml:"ldap"`
+	OIDC oidcConfig `yaml:"oidc"`
+
 	DNS dnsConfig         `yaml:"dns"`
 	TLS tlsConfigSettings `yaml:"tls"`
 
@@ -63,6 +134,9 @@ type configuration struct {
 	// Note: this array is filled only before file read/write and then it's cleared
 	Clients []clientObject `yaml:"clients"`
 
+	// The clients and tags whose internet access is turned off until the specified time
+	InternetOff []internetOffEntry `yaml:"internet_off"`
+
 	logSettings `yaml:",inline"`
 
 	sync.RWMutex `yaml:"-"`
@@ -75,6 +149,9 @@ type dnsConfig struct {
 	BindHost string `yaml:"bind_host"`
 	Port     int    `yaml:"port"`
 
+	// On shutdown, the time to wait for the requests being processed (in seconds).  0: don't wait.
+	ShutdownTimeout uint32 `yaml:"shutdown_timeout"`
+
 	// time interval for statistics (in days)
 	StatsInterval uint32 `yaml:"statistics_interval"`
 
@@ -84,11 +161,39 @@ type dnsConfig struct {
 	QueryLogMemSize     uint32 `yaml:"querylog_size_memory"`  // number of entries kept in memory before they are flushed to disk
 	AnonymizeClientIP   bool   `yaml:"anonymize_client_ip"`   // anonymize clients' IP addresses in logs and stats
 
+	// The directories for query log and statistics files (e.g. on USB storage) ("": data directory)
+	QueryLogDir string `yaml:"querylog_dir"`
+	StatsDir    string `yaml:"statistics_dir"`
+
+	// Write the query log entries kept in memory to the file at least every N minutes (0: only when the memory buffer is full)
+	QueryLogFlushInterval uint32 `yaml:"querylog_flush_interval"`
+
+	// The time zone for the daily statistics and the query log timestamps ("": the server's local time zone)
+	TimeZone string `yaml:"time_zone"`
+
 	dnsforward.FilteringConfig `yaml:",inline"`
 
 	FilteringEnabled           bool             `yaml:"filtering_enabled"`       // whether or not use filter lists
 	FiltersUpdateIntervalHours uint32           `yaml:"filters_update_interval"` // time period to update filters (in hours)
 	DnsfilterConf              dnsfilter.Config `yaml:",inline"`
+
+	// The time of day when the filters are updated automatically: "HH:MM-HH:MM" ("": use maintenance_window)
+	FiltersUpdateWindow string `yaml:"filters_update_window"`
+
+	// Don't install a new version of the filter if more than N% of its rules are invalid (0: don't check)
+	FiltersMaxInvalidPercent uint32 `yaml:"filters_max_invalid_percent"`
+
+	// The number of previous versions of each filter kept on disk for rollback (0: don't keep)
+	FiltersKeepVersions uint32 `yaml:"filters_keep_versions"`
+
+	// A filter is considered stale if its data hasn't changed for N days (0: don't check)
+	FiltersStaleDays uint32 `yaml:"filters_stale_days"`
+
+	// The URL or the file path of the filter lists registry ("": use the built-in one)
+	FiltersRegistryURL string `yaml:"filters_registry_url"`
+
+	// The proxy for the filter downloads: "(http|https|socks5)://[user:password@]host:port" ("": use http_proxy)
+	FiltersProxy string `yaml:"filters_proxy"`
 }
 
 type tlsConfigSettings struct {
@@ -101,17 +206,61 @@ type tlsConfigSettings struct {
 	// Allow DOH queries via unencrypted HTTP (e.g. for reverse proxying)
 	AllowUnencryptedDOH bool `yaml:"allow_unencrypted_doh" json:"allow_unencrypted_doh"`
 
+	OCSPStapling  bool `yaml:"ocsp_stapling" json:"-"`  // staple OCSP response to the certificate
+	CompleteChain bool `yaml:"complete_chain" json:"-"` // download the missing intermediate certificates
+
 	dnsforward.TLSConfig `yaml:",inline" json:",inline"`
 }
 
 // initialize to default values, will be changed later when reading config or parsing command line
 var config = configuration{
-	BindPort: 3000,
-	BindHost: "0.0.0.0",
+	BindPort:     3000,
+	BindHost:     "0.0.0.0",
+	AuthAttempts: 5,
+	AuthBlockMin: 15,
+	AutoUpdate: autoUpdateConfig{
+		Window: "03:00-05:00",
+	},
+	Notifications: notifyConfig{
+		CertExpiryDays:  14,
+		DiskFreePercent: 5,
+		AnomalyFactor:   5,
+	},
+	MQTT: mqttConfig{
+		ClientID:        "adguardhome",
+		TopicPrefix:     "adguardhome",
+		Discovery:       true,
+		DiscoveryPrefix: "homeassistant",
+		Interval:        60,
+	},
+	SNMP: snmpConfig{
+		Address:   "0.0.0.0:161",
+		Community: "public",
+		BaseOID:   snmpDefaultBaseOID,
+	},
+	IPFIX: ipfixConfig{
+		Version: ipfix.VersionIPFIX,
+		QName:   ipfix.QNameHash,
+	},
+	HashServer: hashServerConfig{
+		Address: "0.0.0.0:5354",
+	},
+	Tracing: tracingConfig{
+		Endpoint:    "http://localhost:4318/v1/traces",
+		ServiceName: "AdGuardHome",
+		SampleRate:  1,
+	},
+	Failover: failoverConfig{
+		Mode:         failoverModeBuiltin,
+		Priority:     100,
+		Interval:     1,
+		DeadInterval: 3,
+	},
 	DNS: dnsConfig{
-		BindHost:      "0.0.0.0",
-		Port:          53,
-		StatsInterval: 1,
+		BindHost:        "0.0.0.0",
+		Port:            53,
+		StatsInterval:   1,
+		ShutdownTimeout: 5,
 		FilteringConfig: dnsforward.FilteringConfig{
 			ProtectionEnabled:  true,      // whether or not use any of dnsfilter features
 			BlockingMode:       "default", // mode how to answer filtered requests
@@ -122,6 +271,9 @@ var config = configuration{
 		},
 		FilteringEnabled:           true, // whether or not use filter lists
 		FiltersUpdateIntervalHours: 24,
+		FiltersMaxInvalidPercent:   50,
+		FiltersKeepVersions:        3,
+		FiltersStaleDays:           30,
 	},
 	TLS: tlsConfigSettings{
 		PortHTTPS:      443,
@@ -156,6 +308,7 @@ func initConfig() {
 	config.DNS.DnsfilterConf.ParentalCacheSize = 1 * 1024 * 1024
 	config.DNS.DnsfilterConf.CacheTime = 30
 	config.Filters = defaultFilters()
+	config.ConfigHistory = 10
 }
 
 // getConfigFilename returns path to the current config file
@@ -175,9 +328,10 @@ func (c *configuration) getConfigFilename() string {
 
 // getLogSettings reads logging settings from the config file.
 // we do it in a separate method in order to configure logger before the actual configuration is parsed and applied.
+// The settings that aren't set in the file keep their default values.
 func getLogSettings() logSettings {
-	l := logSettings{}
-	yamlFile, err := readConfigFile()
+	l := config.logSettings
+	yamlFile, err := readConfigWithOverrides()
 	if err != nil {
 		return l
 	}
@@ -192,21 +346,48 @@ func getLogSettings() logSettings {
 func parseConfig() error {
 	configFile := config.getConfigFilename()
 	log.Debug("Reading config file: %s", configFile)
-	yamlFile, err := readConfigFile()
+	rawFile, err := readConfigFile()
 	if err != nil {
 		return err
 	}
+	yamlFile, err := readConfigWithOverrides()
+	if err != nil {
+		log.Error("Couldn't read config file: %s", err)
+		return err
+	}
 	config.fileData = nil
+
+	// check the file itself so that the line numbers are correct
+	errs := checkConfigStrict(rawFile)
+	if len(errs) != 0 {
+		return configErrors(configFile, errs)
+	}
+
 	err = yaml.Unmarshal(yamlFile, &config)
 	if err != nil {
 		log.Error("Couldn't parse config file: %s", err)
 		return err
 	}
 
+	err = config.decryptSecrets()
+	if err != nil {
+		log.Error("%s", err)
+		return err
+	}
+
+	errs = validateConfig(&config, rawFile)
+	if len(errs) != 0 {
+		return configErrors(configFile, errs)
+	}
+
 	if !checkFiltersUpdateIntervalHours(config.DNS.FiltersUpdateIntervalHours) {
 		config.DNS.FiltersUpdateIntervalHours = 24
 	}
 
+	// IPv6 addresses may be specified in square brackets
+	config.BindHost = util.TrimBrackets(config.BindHost)
+	config.DNS.BindHost = util.TrimBrackets(config.DNS.BindHost)
+
 	return nil
 }
 
@@ -230,7 +411,13 @@ func (c *configuration) write() error {
 	c.Lock()
 	defer c.Unlock()
 
+	if c.rollbackPending {
+		log.Debug("Not writing YAML file: waiting for restart after rollback")
+		return nil
+	}
+
 	Context.clients.WriteDiskConfig(&config.Clients)
+	config.InternetOff = Context.clients.internetOffEntries()
 
 	if Context.auth != nil {
 		config.Users = Context.auth.GetUsers()
@@ -269,25 +456,51 @@ func (c *configuration) write() error {
 		config.DNS.FilteringConfig = c
 	}
 
+	if Context.intercept != nil {
+		Context.intercept.WriteDiskConfig(&config.DNSIntercept)
+	}
+
 	if Context.dhcpServer != nil {
 		c := dhcpd.ServerConfig{}
 		Context.dhcpServer.WriteDiskConfig(&c)
 		config.DHCP = c
 	}
 
+	restoreSecrets, err := config.encryptSecrets()
+	if err != nil {
+		config.Clients = nil
+		log.Error("Couldn't encrypt secrets: %s", err)
+		return err
+	}
+
 	configFile := config.getConfigFilename()
 	log.Debug("Writing YAML file: %s", configFile)
 	yamlText, err := yaml.Marshal(&config)
+	restoreSecrets()
 	config.Clients = nil
 	if err != nil {
 		log.Error("Couldn't generate YAML file: %s", err)
 		return err
 	}
+
+	// the values from the environment variables aren't written to the file
+	yamlText, err = restoreEnvOverrides(yamlText, config.envOverrides)
+	if err != nil {
+		log.Error("Couldn't generate YAML file: %s", err)
+		return err
+	}
 	err = file.SafeWrite(configFile, yamlText)
 	if err != nil {
 		log.Error("Couldn't save YAML config: %s", err)
 		return err
 	}
+	saveConfigSnapshot(yamlText)
+
+	err = pushRemoteConfig(yamlText)
+	if err != nil {
+		log.Error("%s", err)
+		return err
+	}
 
 	return nil
 }
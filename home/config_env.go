@@ -0,0 +1,269 @@
+package home
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The prefix of the environment variables that override the configuration settings
+const envOverridePrefix = "ADGUARD_"
+
+// The separator of the nested keys in the environment variable names,
+// e.g. ADGUARD_DNS__UPSTREAM_DNS overrides "upstream_dns" setting in "dns" section
+const envOverrideSep = "__"
+
+// envOverride - a configuration setting overridden by an environment variable
+type envOverride struct {
+	name   string      // environment variable name
+	path   []string    // path to the setting, e.g. ["dns", "port"]
+	value  interface{} // value from the environment variable
+	orig   interface{} // value from the configuration file
+	exists bool        // true if the setting is present in the configuration file
+}
+
+// getEnvOverrides - get the overrides from the environment variables
+// The values are parsed as YAML, e.g. "true", "53" or "[1.1.1.1, 8.8.8.8]".
+func getEnvOverrides(environ []string) ([]envOverride, error) {
+	overrides := []envOverride{}
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envOverridePrefix) {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i == -1 {
+			continue
+		}
+		name := kv[:i]
+		key := strings.ToLower(name[len(envOverridePrefix):])
+		if len(key) == 0 {
+			continue
+		}
+
+		o := envOverride{
+			name: name,
+			path: strings.Split(key, envOverrideSep),
+		}
+		if !configKeyExists(reflect.TypeOf(configuration{}), o.path) {
+			// e.g. ADGUARD_VERSION set by a container image
+			log.Debug("config: %s doesn't match any setting, ignored", name)
+			continue
+		}
+		err := yaml.Unmarshal([]byte(kv[i+1:]), &o.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		overrides = append(overrides, o)
+	}
+
+	// apply the parent keys first so the nested ones aren't overwritten
+	sort.SliceStable(overrides, func(i, j int) bool {
+		return len(overrides[i].path) < len(overrides[j].path)
+	})
+	return overrides, nil
+}
+
+// configKeyExists - return TRUE if the path is a setting of the configuration type
+// The path elements are the YAML keys of the nested sections and the list indexes.
+func configKeyExists(t reflect.Type, path []string) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(path) == 0 {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i != t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("yaml"), ",")
+			name := tag[0]
+			if len(tag) > 1 && tag[1] == "inline" {
+				if configKeyExists(f.Type, path) {
+					return true
+				}
+				continue
+			}
+			if name == "-" || len(f.PkgPath) != 0 {
+				continue // not a setting or unexported
+			}
+			if len(name) == 0 {
+				name = strings.ToLower(f.Name)
+			}
+			if name == path[0] {
+				return configKeyExists(f.Type, path[1:])
+			}
+		}
+		return false
+	case reflect.Slice, reflect.Array:
+		_, err := strconv.Atoi(path[0])
+		return err == nil && configKeyExists(t.Elem(), path[1:])
+	case reflect.Map:
+		return configKeyExists(t.Elem(), path[1:])
+	case reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// listIndex - parse the list index
+func listIndex(list []interface{}, k string) (int, bool) {
+	idx, err := strconv.Atoi(k)
+	return idx, err == nil && idx >= 0 && idx < len(list)
+}
+
+// getYAMLValue - get the value by path
+func getYAMLValue(node interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return node, true
+	}
+
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		for _, item := range n {
+			if item.Key == path[0] {
+				return getYAMLValue(item.Value, path[1:])
+			}
+		}
+	case []interface{}:
+		idx, ok := listIndex(n, path[0])
+		if ok {
+			return getYAMLValue(n[idx], path[1:])
+		}
+	}
+	return nil, false
+}
+
+// setYAMLValue - set the value by path, or remove it if remove is true
+// Missing sections are created.  Return the updated node.
+func setYAMLValue(node interface{}, path []string, v interface{}, remove bool) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	k := path[0]
+
+	switch n := node.(type) {
+	case nil:
+		if remove {
+			return nil, nil
+		}
+		return setYAMLValue(yaml.MapSlice{}, path, v, remove)
+
+	case yaml.MapSlice:
+		for i, item := range n {
+			if item.Key != k {
+				continue
+			}
+			if remove && len(path) == 1 {
+				return append(n[:i], n[i+1:]...), nil
+			}
+			val, err := setYAMLValue(item.Value, path[1:], v, remove)
+			if err != nil {
+				return nil, err
+			}
+			n[i].Value = val
+			return n, nil
+		}
+		if remove {
+			return n, nil
+		}
+		val, err := setYAMLValue(nil, path[1:], v, remove)
+		if err != nil {
+			return nil, err
+		}
+		return append(n, yaml.MapItem{Key: k, Value: val}), nil
+
+	case []interface{}:
+		idx, ok := listIndex(n, k)
+		if !ok || (remove && len(path) == 1) {
+			return nil, fmt.Errorf("%s: invalid list index", k)
+		}
+		val, err := setYAMLValue(n[idx], path[1:], v, remove)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = val
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("%s: parent is not a section", k)
+}
+
+// applyEnvOverrides - apply the environment variable overrides to the configuration file data
+// The original values are stored in the overrides so they can be restored by restoreEnvOverrides().
+func applyEnvOverrides(data []byte, overrides []envOverride) ([]byte, error) {
+	if len(overrides) == 0 {
+		return data, nil
+	}
+
+	// MapSlice keeps the order of the settings
+	ms := yaml.MapSlice{}
+	err := yaml.Unmarshal(data, &ms)
+	if err != nil {
+		return nil, err
+	}
+	var m interface{} = ms
+
+	for i := range overrides {
+		o := &overrides[i]
+		o.orig, o.exists = getYAMLValue(m, o.path)
+		m, err = setYAMLValue(m, o.path, o.value, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", o.name, err)
+		}
+		log.Debug("config: %s is overridden by %s", strings.Join(o.path, "."), o.name)
+	}
+
+	return yaml.Marshal(m)
+}
+
+// restoreEnvOverrides - restore the values from the configuration file
+// so the values from the environment variables aren't written to the file.
+func restoreEnvOverrides(data []byte, overrides []envOverride) ([]byte, error) {
+	if len(overrides) == 0 {
+		return data, nil
+	}
+
+	ms := yaml.MapSlice{}
+	err := yaml.Unmarshal(data, &ms)
+	if err != nil {
+		return nil, err
+	}
+	var m interface{} = ms
+
+	// restore the nested keys first
+	for i := len(overrides) - 1; i >= 0; i-- {
+		o := overrides[i]
+		m, err = setYAMLValue(m, o.path, o.orig, !o.exists)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", o.name, err)
+		}
+	}
+
+	return yaml.Marshal(m)
+}
+
+// readConfigWithOverrides - read the configuration file and apply the environment variable overrides
+func readConfigWithOverrides() ([]byte, error) {
+	data, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := getEnvOverrides(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	data, err = applyEnvOverrides(data, overrides)
+	if err != nil {
+		return nil, err
+	}
+	config.envOverrides = overrides
+	return data, nil
+}
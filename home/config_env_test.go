@@ -0,0 +1,63 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	data := `bind_host: 0.0.0.0
+bind_port: 3000
+dns:
+  port: 53
+  upstream_dns:
+  - 8.8.8.8
+clients:
+- name: client1
+  ids:
+  - 1.2.3.4
+`
+	env := []string{
+		"PATH=/bin",
+		"ADGUARD_BIND_PORT=8080",
+		"ADGUARD_DNS__UPSTREAM_DNS=[1.1.1.1, 9.9.9.9]",
+		"ADGUARD_DNS__RATELIMIT=0",
+		"ADGUARD_CLIENTS__0__NAME=client2",
+		"ADGUARD_TLS__ENABLED=true",
+		"ADGUARDHOME_SECRETS_KEY=xxx",
+		"ADGUARD_VERSION=v1.2.3",
+		"ADGUARD_DNS__NO_SUCH_SETTING=1",
+		"ADGUARD_BIND_PORT__X=1",
+	}
+	overrides, err := getEnvOverrides(env)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(overrides))
+
+	out, err := applyEnvOverrides([]byte(data), overrides)
+	assert.Nil(t, err)
+	assert.Equal(t, `bind_host: 0.0.0.0
+bind_port: 8080
+dns:
+  port: 53
+  upstream_dns:
+  - 1.1.1.1
+  - 9.9.9.9
+  ratelimit: 0
+clients:
+- name: client2
+  ids:
+  - 1.2.3.4
+tls:
+  enabled: true
+`, string(out))
+
+	out, err = restoreEnvOverrides(out, overrides)
+	assert.Nil(t, err)
+	assert.Equal(t, data+"tls: {}\n", string(out))
+
+	_, err = applyEnvOverrides([]byte(data), []envOverride{{name: "ADGUARD_CLIENTS__5__NAME", path: []string{"clients", "5", "name"}}})
+	assert.NotNil(t, err)
+	_, err = applyEnvOverrides([]byte(data), []envOverride{{name: "ADGUARD_BIND_PORT__X", path: []string{"bind_port", "x"}}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,314 @@
+package home
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/pmezard/go-difflib/difflib"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The directory with the snapshots of the configuration file (in the data directory)
+const configHistoryDir = "config_history"
+
+// The format of the snapshot ID (the time when it was taken):  the IDs are sorted in the order of time
+const configSnapshotIDFormat = "20060102-150405.000000000"
+
+// The format for parsing the snapshot IDs:  the IDs of the older versions have milliseconds only
+const configSnapshotIDParseFormat = "20060102-150405.999999999"
+
+type configSnapshotJSON struct {
+	ID            string    `json:"id"`
+	Time          time.Time `json:"time"`
+	Size          int64     `json:"size"`
+	SchemaVersion int       `json:"schema_version"`
+}
+
+// configHistoryPath - get the directory with the snapshots
+func configHistoryPath() string {
+	return filepath.Join(Context.getDataDir(), configHistoryDir)
+}
+
+// configSnapshotPath - get the file name of the snapshot
+// Return false if the ID is invalid.
+func configSnapshotPath(id string) (string, bool) {
+	_, err := time.Parse(configSnapshotIDParseFormat, id)
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(configHistoryPath(), id+".yaml"), true
+}
+
+// configSnapshotIDs - get the IDs of the snapshots, the newest last
+func configSnapshotIDs() []string {
+	entries, err := ioutil.ReadDir(configHistoryPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("config history: %s", err)
+		}
+		return nil
+	}
+	var ids []string
+	for _, e := range entries {
+		id := strings.TrimSuffix(e.Name(), ".yaml")
+		_, ok := configSnapshotPath(id)
+		if ok && e.Mode().IsRegular() && id != e.Name() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// saveConfigSnapshot - save the configuration file data to the history if it differs from the last snapshot
+// The oldest snapshots are removed so that no more than config_history snapshots are kept.
+func saveConfigSnapshot(data []byte) {
+	saveConfigSnapshotAt(data, time.Now())
+}
+
+// saveConfigSnapshotAt - save the snapshot taken at the specified time
+// The ID of a new snapshot is always greater than the last one:  a snapshot is never overwritten,
+// even if the clock hasn't advanced since the last one or has been set back.
+func saveConfigSnapshotAt(data []byte, now time.Time) {
+	max := int(config.ConfigHistory)
+	if max == 0 {
+		return
+	}
+
+	t := now.UTC()
+	ids := configSnapshotIDs()
+	if len(ids) != 0 {
+		fn, _ := configSnapshotPath(ids[len(ids)-1])
+		last, err := ioutil.ReadFile(fn)
+		if err == nil && bytes.Equal(last, data) {
+			return
+		}
+		lastTime, _ := time.Parse(configSnapshotIDParseFormat, ids[len(ids)-1])
+		if !t.After(lastTime) {
+			t = lastTime.Add(time.Nanosecond)
+		}
+	}
+
+	err := os.MkdirAll(configHistoryPath(), 0700)
+	if err != nil {
+		log.Error("config history: %s", err)
+		return
+	}
+	id := t.Format(configSnapshotIDFormat)
+	fn, _ := configSnapshotPath(id)
+	err = file.SafeWrite(fn, data)
+	if err != nil {
+		log.Error("config history: %s", err)
+		return
+	}
+	log.Debug("config history: saved snapshot %s", id)
+
+	ids = append(ids, id)
+	for len(ids) > max {
+		fn, _ = configSnapshotPath(ids[0])
+		err = os.Remove(fn)
+		if err != nil {
+			log.Error("config history: %s", err)
+		}
+		ids = ids[1:]
+	}
+}
+
+// readConfigSnapshot - read the data of the snapshot
+func readConfigSnapshot(id string) ([]byte, error) {
+	fn, ok := configSnapshotPath(id)
+	if !ok {
+		return nil, fmt.Errorf("invalid snapshot ID %q", id)
+	}
+	return ioutil.ReadFile(fn)
+}
+
+// configSchemaVersion - get the schema version of the configuration file data
+func configSchemaVersion(data []byte) int {
+	v := struct {
+		SchemaVersion int `yaml:"schema_version"`
+	}{}
+	_ = yaml.Unmarshal(data, &v)
+	return v.SchemaVersion
+}
+
+// checkConfigSnapshot - check that the configuration can be loaded from the snapshot
+// The snapshots of older schema versions are upgraded on start, so only the current version is validated.
+func checkConfigSnapshot(data []byte) error {
+	v := configSchemaVersion(data)
+	if v > currentSchemaVersion {
+		return fmt.Errorf("unknown schema_version %d", v)
+	}
+	if v < currentSchemaVersion {
+		return nil
+	}
+
+	errs := checkConfigStrict(data)
+	if len(errs) == 0 {
+		c := configuration{}
+		err := yaml.Unmarshal(data, &c)
+		if err != nil {
+			return err
+		}
+		err = c.decryptSecrets()
+		if err != nil {
+			return err
+		}
+		errs = validateConfig(&c, data)
+	}
+	if len(errs) != 0 {
+		msgs := []string{}
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		return fmt.Errorf("invalid configuration: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// logLastConfigSnapshot - print the latest snapshot that may be used to restore the configuration file
+func logLastConfigSnapshot() {
+	ids := configSnapshotIDs()
+	if len(ids) == 0 {
+		return
+	}
+	fn, _ := configSnapshotPath(ids[len(ids)-1])
+	log.Info("The latest snapshot of the configuration file: %s", fn)
+}
+
+// GET /control/config/history
+func handleConfigHistory(w http.ResponseWriter, r *http.Request) {
+	resp := struct {
+		Max       uint32               `json:"max"`
+		Snapshots []configSnapshotJSON `json:"snapshots"`
+	}{
+		Max:       config.ConfigHistory,
+		Snapshots: []configSnapshotJSON{},
+	}
+
+	ids := configSnapshotIDs()
+	for i := len(ids) - 1; i >= 0; i-- {
+		data, err := readConfigSnapshot(ids[i])
+		if err != nil {
+			continue
+		}
+		t, _ := time.Parse(configSnapshotIDParseFormat, ids[i])
+		resp.Snapshots = append(resp.Snapshots, configSnapshotJSON{
+			ID:            ids[i],
+			Time:          t,
+			Size:          int64(len(data)),
+			SchemaVersion: configSchemaVersion(data),
+		})
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// GET /control/config/history/diff?id=...&id2=...
+// Respond with the unified diff between the snapshot and another snapshot or the current configuration file.
+func handleConfigHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id := q.Get("id")
+	a, err := readConfigSnapshot(id)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	id2 := q.Get("id2")
+	name2 := filepath.Base(config.getConfigFilename())
+	var b []byte
+	if len(id2) != 0 {
+		b, err = readConfigSnapshot(id2)
+		name2 = id2
+	} else {
+		b, err = ioutil.ReadFile(config.getConfigFilename())
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: id,
+		ToFile:   name2,
+		Context:  3,
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "diff: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(diff))
+}
+
+// POST /control/config/history/rollback
+// Replace the configuration file with the snapshot and restart.
+func handleConfigHistoryRollback(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		ID string `json:"id"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+
+	data, err := readConfigSnapshot(req.ID)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	err = checkConfigSnapshot(data)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	config.Lock()
+	// keep the current file so that the rollback may be undone
+	cur, err := ioutil.ReadFile(config.getConfigFilename())
+	if err == nil {
+		saveConfigSnapshot(cur)
+	}
+	err = file.SafeWrite(config.getConfigFilename(), data)
+	if err == nil {
+		// the settings in memory mustn't overwrite the file until restart
+		config.rollbackPending = true
+	}
+	config.Unlock()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	log.Info("config history: rolled back to snapshot %s, restarting", req.ID)
+
+	returnOK(w)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	go func() {
+		cleanup()
+		cleanupAlways()
+		restart()
+	}()
+}
@@ -0,0 +1,75 @@
+package home
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHistory(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	oldWorkDir := Context.workDir
+	oldConfigFilename := Context.configFilename
+	oldMax := config.ConfigHistory
+	defer func() {
+		Context.workDir = oldWorkDir
+		Context.configFilename = oldConfigFilename
+		config.ConfigHistory = oldMax
+	}()
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	config.ConfigHistory = 2
+
+	// the same data isn't saved twice
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	saveConfigSnapshotAt([]byte("bind_port: 1\n"), now)
+	saveConfigSnapshotAt([]byte("bind_port: 1\n"), now)
+	assert.Equal(t, []string{"20200601-100000.000000000"}, configSnapshotIDs())
+
+	// the snapshots taken at the same time don't overwrite each other, the oldest snapshots are removed
+	for _, s := range []string{"bind_port: 2\n", "bind_port: 3\n", "bind_port: 4\n"} {
+		saveConfigSnapshotAt([]byte(s), now)
+	}
+	ids := configSnapshotIDs()
+	assert.Equal(t, []string{"20200601-100000.000000002", "20200601-100000.000000003"}, ids)
+	data, err := readConfigSnapshot(ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "bind_port: 3\n", string(data))
+	data, err = readConfigSnapshot(ids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "bind_port: 4\n", string(data))
+
+	// the IDs of the older versions (milliseconds only) are still valid
+	_, ok := configSnapshotPath("20200601-095959.123")
+	assert.True(t, ok)
+
+	// invalid IDs don't allow reading other files
+	_, err = readConfigSnapshot("../AdGuardHome")
+	assert.NotNil(t, err)
+
+	// diff with the current file
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "AdGuardHome.yaml"), []byte("bind_port: 5\n"), 0644))
+	r := httptest.NewRequest("GET", "/control/config/history/diff?id="+ids[len(ids)-1], nil)
+	w := httptest.NewRecorder()
+	handleConfigHistoryDiff(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "-bind_port: 4\n+bind_port: 5\n"))
+
+	// disabled
+	config.ConfigHistory = 0
+	saveConfigSnapshot([]byte("bind_port: 6\n"))
+	assert.Equal(t, ids, configSnapshotIDs())
+
+	// the snapshots of an unknown schema version can't be restored
+	assert.NotNil(t, checkConfigSnapshot([]byte("schema_version: 1000\n")))
+	assert.NotNil(t, checkConfigSnapshot([]byte(fmt.Sprintf("schema_version: %d\nunknown_key: 1\n", currentSchemaVersion))))
+}
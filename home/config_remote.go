@@ -0,0 +1,345 @@
+package home
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// errRemoteConfigNotFound is returned when there's no configuration in the remote storage yet
+var errRemoteConfigNotFound = errors.New("configuration not found")
+
+// configStorage - remote storage of the configuration file
+type configStorage interface {
+	// read - get the configuration data
+	// Return errRemoteConfigNotFound if it doesn't exist.
+	read() ([]byte, error)
+
+	// write - store the configuration data
+	write(data []byte) error
+}
+
+// newConfigStorage - create a remote configuration storage object from URL,
+// e.g. "consul://host:8500/path/to/key?token=...", "etcd://host:2379/path/to/key" or
+// "s3://bucket/path/to/key?region=us-east-1&endpoint=host:port".
+// "tls=1" parameter enables HTTPS for consul and etcd, "tls=0" disables it for s3.
+func newConfigStorage(rawURL string) (configStorage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	key := strings.TrimPrefix(u.Path, "/")
+	if len(u.Host) == 0 || len(key) == 0 {
+		return nil, fmt.Errorf("%s: host and key must be specified", rawURL)
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	scheme := "http"
+	if q.Get("tls") == "1" {
+		scheme = "https"
+	}
+
+	switch u.Scheme {
+	case "consul":
+		token := q.Get("token")
+		if len(token) == 0 {
+			token = os.Getenv("CONSUL_HTTP_TOKEN")
+		}
+		return &consulConfigStorage{
+			client: client,
+			url:    fmt.Sprintf("%s://%s/v1/kv/%s", scheme, u.Host, key),
+			token:  token,
+		}, nil
+
+	case "etcd":
+		return &etcdConfigStorage{
+			client: client,
+			url:    fmt.Sprintf("%s://%s/v3/kv/", scheme, u.Host),
+			key:    key,
+		}, nil
+
+	case "s3":
+		s := &s3ConfigStorage{
+			client:       client,
+			bucket:       u.Host,
+			key:          key,
+			region:       q.Get("region"),
+			endpoint:     q.Get("endpoint"),
+			scheme:       "https",
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if q.Get("tls") == "0" {
+			s.scheme = "http"
+		}
+		if len(s.region) == 0 {
+			s.region = "us-east-1"
+		}
+		if len(s.endpoint) == 0 {
+			s.endpoint = "s3." + s.region + ".amazonaws.com"
+		}
+		if len(s.accessKey) == 0 || len(s.secretKey) == 0 {
+			return nil, fmt.Errorf("s3: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables must be set")
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("%s: unsupported storage type", u.Scheme)
+}
+
+// doRequest - send HTTP request and check the response status
+// Return errRemoteConfigNotFound if the server responds with 404.
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errRemoteConfigNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: status code %d: %s",
+			req.Method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+// consulConfigStorage - Consul KV store
+type consulConfigStorage struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func (c *consulConfigStorage) request(method, u string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(c.token) != 0 {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	return doRequest(c.client, req)
+}
+
+func (c *consulConfigStorage) read() ([]byte, error) {
+	return c.request(http.MethodGet, c.url+"?raw", nil)
+}
+
+func (c *consulConfigStorage) write(data []byte) error {
+	_, err := c.request(http.MethodPut, c.url, data)
+	return err
+}
+
+// etcdConfigStorage - etcd v3 KV store (via gRPC gateway)
+type etcdConfigStorage struct {
+	client *http.Client
+	url    string
+	key    string
+}
+
+func (e *etcdConfigStorage) request(method string, reqData interface{}) ([]byte, error) {
+	body, err := json.Marshal(reqData)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(e.client, req)
+}
+
+func (e *etcdConfigStorage) read() ([]byte, error) {
+	body, err := e.request("range", map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(e.key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := struct {
+		KVs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}{}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("etcd: range: %s", err)
+	}
+	if len(resp.KVs) == 0 {
+		return nil, errRemoteConfigNotFound
+	}
+	return base64.StdEncoding.DecodeString(resp.KVs[0].Value)
+}
+
+func (e *etcdConfigStorage) write(data []byte) error {
+	_, err := e.request("put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key)),
+		"value": base64.StdEncoding.EncodeToString(data),
+	})
+	return err
+}
+
+// s3ConfigStorage - S3-compatible object storage
+type s3ConfigStorage struct {
+	client       *http.Client
+	bucket       string
+	key          string
+	region       string
+	endpoint     string // host[:port]
+	scheme       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func (s *s3ConfigStorage) request(method string, body []byte) ([]byte, error) {
+	u := fmt.Sprintf("%s://%s/%s/%s", s.scheme, s.endpoint, s.bucket, s.key)
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+	return doRequest(s.client, req)
+}
+
+func (s *s3ConfigStorage) read() ([]byte, error) {
+	return s.request(http.MethodGet, nil)
+}
+
+func (s *s3ConfigStorage) write(data []byte) error {
+	_, err := s.request(http.MethodPut, data)
+	return err
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign - add AWS Signature Version 4 headers to the request
+func (s *s3ConfigStorage) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	payloadHashHex := hex.EncodeToString(payloadHash[:])
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHashHex)
+	if len(s.sessionToken) != 0 {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := []string{}
+	for k := range req.Header {
+		headers = append(headers, strings.ToLower(k))
+	}
+	sort.Strings(headers)
+	canonHeaders := strings.Builder{}
+	for _, h := range headers {
+		canonHeaders.WriteString(h + ":" + strings.TrimSpace(req.Header.Get(h)) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHashHex,
+	}, "\n")
+	canonHash := sha256.Sum256([]byte(canonRequest))
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Del("Host")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// pullRemoteConfig - download the configuration file from the remote storage
+// If there's no configuration in the storage yet, the local file (if any) is uploaded.
+func pullRemoteConfig() error {
+	if Context.configStorage == nil {
+		return nil
+	}
+	configFile := config.getConfigFilename()
+
+	data, err := Context.configStorage.read()
+	if err == errRemoteConfigNotFound {
+		data, err = ioutil.ReadFile(configFile)
+		if os.IsNotExist(err) {
+			log.Info("Remote configuration storage is empty")
+			return nil
+		} else if err != nil {
+			return err
+		}
+		log.Info("Remote configuration storage is empty, uploading %s", configFile)
+		return Context.configStorage.write(data)
+
+	} else if err != nil {
+		return fmt.Errorf("couldn't read remote configuration: %s", err)
+	}
+
+	err = file.SafeWrite(configFile, data)
+	if err != nil {
+		return err
+	}
+	log.Debug("Downloaded remote configuration to %s", configFile)
+	return nil
+}
+
+// pushRemoteConfig - upload the configuration file to the remote storage
+func pushRemoteConfig(data []byte) error {
+	if Context.configStorage == nil {
+		return nil
+	}
+
+	err := Context.configStorage.write(data)
+	if err != nil {
+		return fmt.Errorf("couldn't write remote configuration: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package home
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfigStorage(t *testing.T) {
+	_, err := newConfigStorage("consul://127.0.0.1:8500")
+	assert.NotNil(t, err)
+	_, err = newConfigStorage("ftp://127.0.0.1/key")
+	assert.NotNil(t, err)
+
+	s, err := newConfigStorage("consul://127.0.0.1:8500/agh/config?tls=1&token=t")
+	assert.Nil(t, err)
+	c := s.(*consulConfigStorage)
+	assert.Equal(t, "https://127.0.0.1:8500/v1/kv/agh/config", c.url)
+	assert.Equal(t, "t", c.token)
+}
+
+func TestConsulConfigStorage(t *testing.T) {
+	var stored []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/kv/agh/config", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Consul-Token"))
+		switch r.Method {
+		case http.MethodGet:
+			if stored == nil {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(stored)
+		case http.MethodPut:
+			stored, _ = ioutil.ReadAll(r.Body)
+			_, _ = w.Write([]byte("true"))
+		}
+	}))
+	defer srv.Close()
+
+	s, err := newConfigStorage("consul://" + strings.TrimPrefix(srv.URL, "http://") + "/agh/config?token=secret")
+	assert.Nil(t, err)
+
+	_, err = s.read()
+	assert.Equal(t, errRemoteConfigNotFound, err)
+
+	assert.Nil(t, s.write([]byte("bind_port: 3000\n")))
+	data, err := s.read()
+	assert.Nil(t, err)
+	assert.Equal(t, "bind_port: 3000\n", string(data))
+}
+
+func TestEtcdConfigStorage(t *testing.T) {
+	kv := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			resp := map[string]interface{}{}
+			v, ok := kv[req["key"]]
+			if ok {
+				resp["kvs"] = []map[string]string{{"key": req["key"], "value": v}}
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		case "/v3/kv/put":
+			kv[req["key"]] = req["value"]
+			_, _ = w.Write([]byte("{}"))
+		}
+	}))
+	defer srv.Close()
+
+	s, err := newConfigStorage("etcd://" + strings.TrimPrefix(srv.URL, "http://") + "/agh/config")
+	assert.Nil(t, err)
+
+	_, err = s.read()
+	assert.Equal(t, errRemoteConfigNotFound, err)
+
+	assert.Nil(t, s.write([]byte("bind_port: 3000\n")))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("bind_port: 3000\n")),
+		kv[base64.StdEncoding.EncodeToString([]byte("agh/config"))])
+	data, err := s.read()
+	assert.Nil(t, err)
+	assert.Equal(t, "bind_port: 3000\n", string(data))
+}
@@ -0,0 +1,33 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLogSettings(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	oldWorkDir := Context.workDir
+	oldConfigFilename := Context.configFilename
+	defer func() {
+		Context.workDir = oldWorkDir
+		Context.configFilename = oldConfigFilename
+	}()
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+
+	data := "log_file: agh.log\nlog_max_size: 5\nlog_rotate_interval: 24\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "AdGuardHome.yaml"), []byte(data), 0644))
+
+	ls := getLogSettings()
+	assert.Equal(t, "agh.log", ls.LogFile)
+	assert.Equal(t, 5, ls.LogMaxSize)
+	assert.Equal(t, 24, ls.LogRotateInterval)
+	// not set in the file
+	assert.Equal(t, 3, ls.LogMaxAge)
+}
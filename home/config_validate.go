@@ -0,0 +1,404 @@
+package home
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/tracing"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configError - a configuration error with the line number in the file (0: unknown)
+type configError struct {
+	line int
+	msg  string
+}
+
+func (e configError) Error() string {
+	if e.line == 0 {
+		return e.msg
+	}
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+// configErrors - print all configuration errors and return the error
+func configErrors(configFile string, errs []error) error {
+	for _, e := range errs {
+		log.Error("%s: %s", configFile, e)
+	}
+	return fmt.Errorf("%s: %d configuration error(s)", configFile, len(errs))
+}
+
+// checkConfigStrict - check the configuration file data for unknown keys, duplicate keys and type errors
+// yaml.UnmarshalStrict reports all such errors, each of them with the line number.
+func checkConfigStrict(data []byte) []error {
+	c := configuration{}
+	err := yaml.UnmarshalStrict(data, &c)
+	if err == nil {
+		return nil
+	}
+	te, ok := err.(*yaml.TypeError)
+	if !ok {
+		// a syntax error
+		return []error{err}
+	}
+	errs := []error{}
+	for _, s := range te.Errors {
+		if isObsoleteKeyError(s) {
+			log.Info("Warning: %s: the setting is obsolete and ignored", s)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s", s))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// The settings that were written by the previous versions and aren't used anymore
+var obsoleteConfigKeys = []string{
+	"parental_sensitivity",
+}
+
+// isObsoleteKeyError - return TRUE if the error is about an obsolete setting
+func isObsoleteKeyError(s string) bool {
+	for _, k := range obsoleteConfigKeys {
+		if strings.Contains(s, "field "+k+" not found") {
+			return true
+		}
+	}
+	return false
+}
+
+// yamlKeyLine - get the line number of the key in YAML data (0: not found)
+// The path is a list of the nested keys, e.g. "dns", "port".  The keys inside lists aren't searched.
+func yamlKeyLine(data []byte, path ...string) int {
+	parentIndent := -1
+	childIndent := -1 // the indentation of the keys in the current block (unknown until the first key)
+	for i, line := range strings.Split(string(data), "\n") {
+		t := strings.TrimLeft(line, " ")
+		if len(t) == 0 || t[0] == '#' {
+			continue
+		}
+		indent := len(line) - len(t)
+		if indent <= parentIndent {
+			return 0
+		}
+		if childIndent < 0 {
+			childIndent = indent
+		}
+		if indent != childIndent {
+			continue
+		}
+
+		t = strings.TrimRight(t, " \r")
+		if t != path[0]+":" && !strings.HasPrefix(t, path[0]+": ") {
+			continue
+		}
+		if len(path) == 1 {
+			return i + 1
+		}
+		path = path[1:]
+		parentIndent = indent
+		childIndent = -1
+	}
+	return 0
+}
+
+// validateConfig - check the settings for invalid values and conflicts
+// data is the configuration file data used to get the line numbers.
+func validateConfig(c *configuration, data []byte) []error {
+	errs := []error{}
+	add := func(path string, format string, args ...interface{}) {
+		errs = append(errs, configError{
+			line: yamlKeyLine(data, strings.Split(path, ".")...),
+			msg:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	ports := []struct {
+		path string
+		port int
+	}{
+		{"bind_port", c.BindPort},
+		{"dns.port", c.DNS.Port},
+		{"tls.port_https", c.TLS.PortHTTPS},
+		{"tls.port_dns_over_tls", c.TLS.PortDNSOverTLS},
+	}
+	for _, p := range ports {
+		if p.port < 0 || p.port > 0xffff {
+			add(p.path, "%s: invalid port %d", p.path, p.port)
+		}
+	}
+
+	// TCP ports of the web interface and DNS server
+	sameHost := c.BindHost == c.DNS.BindHost ||
+		util.IsUnspecifiedHost(c.BindHost) || util.IsUnspecifiedHost(c.DNS.BindHost)
+	if sameHost && c.BindPort != 0 && c.BindPort == c.DNS.Port {
+		add("bind_port", "bind_port: port %d is also used by DNS server (dns.port)", c.BindPort)
+	}
+	if c.TLS.Enabled {
+		tcpPorts := []struct {
+			path string
+			port int
+		}{
+			{"tls.port_https", c.TLS.PortHTTPS},
+			{"tls.port_dns_over_tls", c.TLS.PortDNSOverTLS},
+		}
+		used := map[int]string{
+			c.BindPort: "bind_port",
+		}
+		if sameHost {
+			used[c.DNS.Port] = "dns.port"
+		}
+		for _, p := range tcpPorts {
+			if p.port == 0 {
+				continue
+			}
+			if other, ok := used[p.port]; ok {
+				add(p.path, "%s: port %d is also used by %s", p.path, p.port, other)
+				continue
+			}
+			used[p.port] = p.path
+		}
+	}
+
+	if len(c.DNS.UpstreamDNS) != 0 {
+		err := dnsforward.ValidateUpstreams(c.DNS.UpstreamDNS)
+		if err != nil {
+			add("dns.upstream_dns", "dns.upstream_dns: %s", err)
+		}
+	}
+
+	switch c.DNS.BlockingMode {
+	case "", "default", "nxdomain", "null_ip":
+	case "custom_ip":
+		ip := net.ParseIP(c.DNS.BlockingIPv4)
+		if ip == nil || ip.To4() == nil {
+			add("dns.blocking_ipv4", "dns.blocking_ipv4: must be an IPv4 address for custom_ip blocking mode")
+		}
+		if net.ParseIP(c.DNS.BlockingIPv6) == nil {
+			add("dns.blocking_ipv6", "dns.blocking_ipv6: must be an IPv6 address for custom_ip blocking mode")
+		}
+	default:
+		add("dns.blocking_mode", "dns.blocking_mode: unknown mode %q", c.DNS.BlockingMode)
+	}
+
+	switch c.DNS.DnsfilterConf.EncryptedDNSMode {
+	case "", dnsfilter.EncryptedDNSOff, dnsfilter.EncryptedDNSDetect, dnsfilter.EncryptedDNSBlock:
+	default:
+		add("dns.encrypted_dns_mode", "dns.encrypted_dns_mode: unknown mode %q", c.DNS.DnsfilterConf.EncryptedDNSMode)
+	}
+	for _, h := range c.DNS.DnsfilterConf.EncryptedDNSHosts {
+		if !validEncryptedDNSEntry(h) {
+			add("dns.encrypted_dns_hosts", "dns.encrypted_dns_hosts: invalid host name or IP address %q", h)
+		}
+	}
+
+	lists := []struct {
+		path string
+		list []string
+	}{
+		{"dns.allowed_clients", c.DNS.AllowedClients},
+		{"dns.disallowed_clients", c.DNS.DisallowedClients},
+		{"web_allowed_clients", c.WebAllowedClients},
+		{"trusted_proxies", c.TrustedProxies},
+	}
+	for _, l := range lists {
+		_, err := parseIPNets(l.list)
+		if err != nil {
+			add(l.path, "%s: %s", l.path, err)
+		}
+	}
+
+	err := validateListeners(c.Listeners)
+	if err != nil {
+		add("listeners", "listeners: %s", err)
+	}
+
+	if c.DHCP.Enabled && len(c.DHCP.InterfaceName) == 0 {
+		add("dhcp.interface_name", "dhcp.interface_name: must be specified when DHCP server is enabled")
+	}
+
+	if len(c.MaintenanceWindow) != 0 {
+		_, err := parseTimeWindow(c.MaintenanceWindow)
+		if err != nil {
+			add("maintenance_window", "maintenance_window: %s", err)
+		}
+	}
+
+	if c.AutoUpdate.Enabled && (len(c.AutoUpdate.Window) != 0 || len(c.MaintenanceWindow) == 0) {
+		_, err := parseTimeWindow(c.AutoUpdate.Window)
+		if err != nil {
+			add("auto_update.window", "auto_update.window: %s", err)
+		}
+	}
+
+	err = dnsforward.ValidateQNameAccess(c.DNS.QNameAccess)
+	if err != nil {
+		add("dns.qname_access", "dns.%s", err)
+	}
+
+	err = dnsforward.ValidateSpecialUseDomains(c.DNS.SpecialUseDomains)
+	if err != nil {
+		add("dns.special_use_domains", "dns.%s", err)
+	}
+
+	err = dnsforward.ValidateResponseRules(c.DNS.ResponseRules)
+	if err != nil {
+		add("dns.response_rules", "dns.%s", err)
+	}
+
+	err = dnsforward.ValidateUpstreamFailurePolicies(c.DNS.UpstreamFailurePolicies)
+	if err != nil {
+		add("dns.upstream_failure_policies", "dns.%s", err)
+	}
+
+	err = dnsforward.ValidateCacheBypass(c.DNS.CacheBypass)
+	if err != nil {
+		add("dns.cache_bypass", "dns.cache_bypass: %s", err)
+	}
+
+	err = dnsforward.ValidateQueryMirror(c.DNS.QueryMirror)
+	if err != nil {
+		add("dns.query_mirror", "dns.%s", err)
+	}
+
+	err = dnsforward.ValidateUpstreamProxy(c.DNS.UpstreamProxy)
+	if err != nil {
+		add("dns.upstream_proxy", "dns.%s", err)
+	}
+
+	for i, f := range c.Filters {
+		err = validateFilterChecksum(f.SHA256, f.SHA256URL)
+		if err == nil {
+			err = validateFilterHeaders(f.Headers)
+		}
+		if err != nil {
+			add("filters", "filters[%d]: %s", i, err)
+		}
+		err = validateFilterGroup(f.Group)
+		if err != nil {
+			add("filters", "filters[%d]: %s", i, err)
+		}
+	}
+	for i, f := range c.WhitelistFilters {
+		err = validateFilterChecksum(f.SHA256, f.SHA256URL)
+		if err == nil {
+			err = validateFilterHeaders(f.Headers)
+		}
+		if err != nil {
+			add("whitelist_filters", "whitelist_filters[%d]: %s", i, err)
+		}
+		err = validateFilterGroup(f.Group)
+		if err != nil {
+			add("whitelist_filters", "whitelist_filters[%d]: %s", i, err)
+		}
+	}
+
+	if c.DNS.FiltersMaxInvalidPercent > 100 {
+		add("dns.filters_max_invalid_percent", "dns.filters_max_invalid_percent: must be 0..100")
+	}
+	if len(c.DNS.FiltersRegistryURL) != 0 && !isValidURL(c.DNS.FiltersRegistryURL) {
+		add("dns.filters_registry_url", "dns.filters_registry_url: invalid URL or file path %q", c.DNS.FiltersRegistryURL)
+	}
+
+	_, err = parseFiltersProxy(c.DNS.FiltersProxy)
+	if err != nil {
+		add("dns.filters_proxy", "dns.filters_proxy: %s", err)
+	}
+
+	err = dnsforward.ValidateInternetOffResponse(c.DNS.InternetOffResponse)
+	if err != nil {
+		add("dns.internet_off_response", "dns.%s", err)
+	}
+
+	_, err = timeLocation(c.DNS.TimeZone)
+	if err != nil {
+		add("dns.time_zone", "dns.time_zone: %s", err)
+	}
+
+	if len(c.DNS.FiltersUpdateWindow) != 0 {
+		_, err = parseTimeWindow(c.DNS.FiltersUpdateWindow)
+		if err != nil {
+			add("dns.filters_update_window", "dns.filters_update_window: %s", err)
+		}
+	}
+
+	err = dnsforward.ValidateEDNSUDPSize(c.DNS.EDNSUDPSize)
+	if err != nil {
+		add("dns.edns_udp_size", "dns.%s", err)
+	}
+
+	err = dnsforward.ValidateUnverifiedUDPMaxSize(c.DNS.UnverifiedUDPMaxSize)
+	if err != nil {
+		add("dns.unverified_udp_max_size", "dns.%s", err)
+	}
+
+	err = dnsforward.ValidateServerNameAccess(c.TLS.AllowedServerNames, c.TLS.AllowedClientIDs)
+	if err != nil {
+		add("tls.allowed_server_names", "tls: %s", err)
+	}
+
+	for i, line := range c.UserRules {
+		_, _, err = dnsforward.ParseUpstreamRule(line)
+		if err == nil {
+			_, err = normalizeRule(line)
+		}
+		if err != nil {
+			add("user_rules", "user_rules[%d]: %s", i, err)
+		}
+	}
+
+	err = dnsfilter.ValidateRewrites(c.DNS.DnsfilterConf.Rewrites)
+	if err != nil {
+		add("dns.rewrites", "dns.rewrites: %s", err)
+	}
+
+	err = dnsfilter.ValidateSecurityServer(c.DNS.DnsfilterConf.SafeBrowsingServer)
+	if err != nil {
+		add("dns.safebrowsing_server", "dns.safebrowsing_server: %s", err)
+	}
+
+	err = dnsfilter.ValidateSecurityServer(c.DNS.DnsfilterConf.ParentalServer)
+	if err != nil {
+		add("dns.parental_server", "dns.parental_server: %s", err)
+	}
+
+	err = validateHashServerConfig(c.HashServer)
+	if err != nil {
+		add("hash_server", "hash_server: %s", err)
+	}
+
+	err = validateInterceptConfig(c.DNSIntercept)
+	if err != nil {
+		add("dns_intercept", "dns_intercept: %s", err)
+	}
+
+	if c.Tracing.Enabled {
+		_, err = tracing.New(tracing.Config{Endpoint: c.Tracing.Endpoint, SampleRate: c.Tracing.SampleRate})
+		if err != nil {
+			add("tracing", "tracing: %s", err)
+		}
+	}
+
+	err = validateFailoverConfig(c.Failover)
+	if err != nil {
+		add("failover", "failover: %s", err)
+	}
+
+	if len(c.User) != 0 {
+		if users := netAdminUsers(c); len(users) != 0 {
+			log.Info("Warning: user: %s need CAP_NET_ADMIN capability, which is kept after dropping the privileges",
+				strings.Join(users, ", "))
+		}
+	}
+
+	return errs
+}
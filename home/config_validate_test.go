@@ -0,0 +1,94 @@
+package home
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestConfigStrict(t *testing.T) {
+	// the default configuration is valid
+	data, err := yaml.Marshal(&config)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(checkConfigStrict(data)))
+
+	data = []byte(`bind_host: 0.0.0.0
+bind_port: 3000
+dns:
+  port: 53
+  upsteam_dns:
+  - 8.8.8.8
+  ratelimit: abc
+`)
+	errs := checkConfigStrict(data)
+	assert.Equal(t, 2, len(errs))
+	assert.True(t, strings.Contains(errs[0].Error(), "line 5: field upsteam_dns not found"))
+	assert.True(t, strings.Contains(errs[1].Error(), "line 7: cannot unmarshal"))
+
+	// obsolete settings are ignored
+	errs = checkConfigStrict([]byte("dns:\n  parental_sensitivity: 13\n"))
+	assert.Equal(t, 0, len(errs))
+
+	errs = checkConfigStrict([]byte("bind_host: [0.0.0.0\n"))
+	assert.Equal(t, 1, len(errs))
+}
+
+func TestYAMLKeyLine(t *testing.T) {
+	data := []byte(`bind_port: 3000
+# comment
+dns:
+  bind_host: 0.0.0.0
+  port: 53
+tls:
+  enabled: false
+  port_https: 443
+`)
+	assert.Equal(t, 1, yamlKeyLine(data, "bind_port"))
+	assert.Equal(t, 5, yamlKeyLine(data, "dns", "port"))
+	assert.Equal(t, 8, yamlKeyLine(data, "tls", "port_https"))
+	assert.Equal(t, 0, yamlKeyLine(data, "dns", "port_https"))
+	assert.Equal(t, 0, yamlKeyLine(data, "port"))
+}
+
+func TestValidateConfig(t *testing.T) {
+	data := []byte(`bind_host: 0.0.0.0
+bind_port: 53
+dns:
+  bind_host: 0.0.0.0
+  port: 53
+  blocking_mode: block
+  upstream_dns:
+  - 8.8.8.8
+`)
+	c := configuration{}
+	assert.Nil(t, yaml.Unmarshal(data, &c))
+	errs := validateConfig(&c, data)
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, "line 2: bind_port: port 53 is also used by DNS server (dns.port)", errs[0].Error())
+	assert.Equal(t, "line 6: dns.blocking_mode: unknown mode \"block\"", errs[1].Error())
+
+	c.BindPort = 3000
+	c.DNS.BlockingMode = "custom_ip"
+	c.DNS.BlockingIPv4 = "1.2.3.4"
+	c.DNS.BlockingIPv6 = "::1"
+	assert.Equal(t, 0, len(validateConfig(&c, data)))
+
+	c.DNS.BlockingIPv4 = "::1"
+	errs = validateConfig(&c, data)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, "dns.blocking_ipv4: must be an IPv4 address for custom_ip blocking mode", errs[0].Error())
+}
+
+func TestNetAdminUsers(t *testing.T) {
+	c := configuration{}
+	assert.Equal(t, []string{}, netAdminUsers(&c))
+
+	c.DNSIntercept.Enabled = true
+	c.Failover = failoverConfig{Enabled: true, Mode: failoverModeKeepalived, VirtualIP: "192.168.1.2/24"}
+	assert.Equal(t, []string{"dns_intercept"}, netAdminUsers(&c))
+
+	c.Failover.Mode = ""
+	assert.Equal(t, []string{"dns_intercept", "failover.virtual_ip"}, netAdminUsers(&c))
+}
@@ -36,7 +36,7 @@ func httpError(w http.ResponseWriter, code int, format string, args ...interface
 // ---------------
 func addDNSAddress(dnsAddresses *[]string, addr string) {
 	if config.DNS.Port != 53 {
-		addr = fmt.Sprintf("%s:%d", addr, config.DNS.Port)
+		addr = net.JoinHostPort(addr, strconv.Itoa(config.DNS.Port))
 	}
 	*dnsAddresses = append(*dnsAddresses, addr)
 }
@@ -72,12 +72,14 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 
 type profileJSON struct {
 	Name string `json:"name"`
+	Role string `json:"role"`
 }
 
 func handleGetProfile(w http.ResponseWriter, r *http.Request) {
 	pj := profileJSON{}
 	u := Context.auth.GetCurrentUser(r)
 	pj.Name = u.Name
+	pj.Role = u.Role
 
 	data, err := json.Marshal(pj)
 	if err != nil {
@@ -98,6 +100,27 @@ func registerControlHandlers() {
 	httpRegister(http.MethodPost, "/control/update", handleUpdate)
 
 	httpRegister("GET", "/control/profile", handleGetProfile)
+	httpRegister(http.MethodPost, "/control/diagnostics", handleDiagnostics)
+	httpRegister(http.MethodPost, "/control/notifications/test", handleNotificationsTest)
+	httpRegister(http.MethodGet, "/control/ha/state", handleHAState)
+	httpRegister(http.MethodPost, "/control/ha/switch", handleHASwitch)
+	httpRegister(http.MethodGet, "/control/dns_intercept/status", handleInterceptStatus)
+	httpRegister(http.MethodPost, "/control/dns_intercept/config", handleInterceptConfig)
+	httpRegister(http.MethodGet, "/control/encrypted_dns/status", handleEncryptedDNSStatus)
+	httpRegister(http.MethodPost, "/control/encrypted_dns/config", handleEncryptedDNSConfig)
+	httpRegister(http.MethodPost, "/control/encrypted_dns/clear", handleEncryptedDNSClear)
+	httpRegister(http.MethodGet, "/control/failover/status", handleFailoverStatus)
+	httpRegister(http.MethodPost, "/control/failover/state", handleFailoverState)
+	httpRegister(http.MethodGet, "/control/config/history", handleConfigHistory)
+	httpRegister(http.MethodGet, "/control/config/history/diff", handleConfigHistoryDiff)
+	httpRegister(http.MethodPost, "/control/config/history/rollback", handleConfigHistoryRollback)
+	httpRegister(http.MethodGet, "/control/tools/resolve", handleToolsResolve)
+	httpRegister(http.MethodGet, "/control/listeners", handleListeners)
+	httpRegister(http.MethodPost, "/control/listeners/config", handleListenersConfig)
+	// these handlers don't require authentication
+	httpRegister("", "/control/failover/heartbeat", handleFailoverHeartbeat)
+	httpRegister("", "/control/failover/health", handleFailoverHealth)
+	registerUnblockHandlers()
 	RegisterAuthHandlers()
 }
 
@@ -108,7 +131,13 @@ func httpRegister(method string, url string, handler func(http.ResponseWriter, *
 		return
 	}
 
-	http.Handle(url, postInstallHandler(optionalAuthHandler(gziphandler.GzipHandler(ensureHandler(method, handler)))))
+	http.Handle(url, controlHandler(method, handler))
+}
+
+// controlHandler - wrap the handler of the control API:  it's available after the first-run configuration,
+// requires authentication and accepts only one HTTP method
+func controlHandler(method string, handler func(http.ResponseWriter, *http.Request)) http.Handler {
+	return postInstallHandler(optionalAuthHandler(gziphandler.GzipHandler(ensureHandler(method, handler))))
 }
 
 // ----------------------------------
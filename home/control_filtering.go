@@ -8,10 +8,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"reflect"
 	"strings"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/miekg/dns"
@@ -19,9 +21,9 @@ import (
 
 // isValidURL - return TRUE if URL or file path is valid
 func isValidURL(rawurl string) bool {
-	if filepath.IsAbs(rawurl) {
-		// this is a file path
-		return util.FileExists(rawurl)
+	if path, ok := filterLocalPath(rawurl); ok {
+		// this is a file or directory path
+		return util.FileExists(path)
 	}
 
 	url, err := url.ParseRequestURI(rawurl)
@@ -35,9 +37,16 @@ func isValidURL(rawurl string) bool {
 }
 
 type filterAddJSON struct {
-	Name      string `json:"name"`
-	URL       string `json:"url"`
-	Whitelist bool   `json:"whitelist"`
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	Whitelist      bool   `json:"whitelist"`
+	UpdateInterval uint32 `json:"update_interval"` // in hours (0: use the global interval)
+	SHA256         string `json:"sha256"`          // the pinned checksum of the data (optional)
+	SHA256URL      string `json:"sha256_url"`      // the URL of the file with the checksum (optional)
+	Group          string `json:"group"`           // the group of the filter (optional)
+	ClientsOnly    bool   `json:"clients_only"`    // used only for the clients that have it in their filter_ids
+
+	Headers map[string]string `json:"headers"` // additional HTTP request headers (optional)
 }
 
 func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +61,19 @@ func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		http.Error(w, "Invalid URL or file path", http.StatusBadRequest)
 		return
 	}
+	err = validateFilterChecksum(fj.SHA256, fj.SHA256URL)
+	if err == nil {
+		err = validateFilterHeaders(fj.Headers)
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	err = validateFilterGroup(fj.Group)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
 
 	// Check for duplicates
 	if filterExists(fj.URL) {
@@ -65,33 +87,49 @@ func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		URL:     fj.URL,
 		Name:    fj.Name,
 		white:   fj.Whitelist,
+
+		UpdateInterval: fj.UpdateInterval,
+		SHA256:         fj.SHA256,
+		SHA256URL:      fj.SHA256URL,
+		Group:          fj.Group,
+		ClientsOnly:    fj.ClientsOnly && !fj.Whitelist,
+	}
+	if len(fj.Headers) != 0 {
+		filt.Headers = fj.Headers
+	}
+	err = f.addFilter(&filt)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "OK %d rules\n", filt.RulesCount)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Couldn't write body: %s", err)
 	}
+}
+
+// addFilter - download the new filter, add it to the list and apply the settings
+func (f *Filtering) addFilter(filt *filter) error {
 	filt.ID = assignUniqueFilterID()
 
 	// Download the filter contents
-	ok, err := f.update(&filt)
+	ok, err := f.update(filt)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "Couldn't fetch filter from url %s: %s", filt.URL, err)
-		return
+		return fmt.Errorf("Couldn't fetch filter from url %s: %s", filt.URL, err)
 	}
 	if !ok {
-		httpError(w, http.StatusBadRequest, "Filter at the url %s is invalid (maybe it points to blank page?)", filt.URL)
-		return
+		return fmt.Errorf("Filter at the url %s is invalid (maybe it points to blank page?)", filt.URL)
 	}
 
 	// URL is deemed valid, append it to filters, update config, write new filter file and tell dns to reload it
-	if !filterAdd(filt) {
-		httpError(w, http.StatusBadRequest, "Filter URL already added -- %s", filt.URL)
-		return
+	if !filterAdd(*filt) {
+		return fmt.Errorf("Filter URL already added -- %s", filt.URL)
 	}
 
 	onConfigModified()
 	enableFilters(true)
-
-	_, err = fmt.Fprintf(w, "OK %d rules\n", filt.RulesCount)
-	if err != nil {
-		httpError(w, http.StatusInternalServerError, "Couldn't write body: %s", err)
-	}
+	return nil
 }
 
 func (f *Filtering) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Request) {
@@ -122,6 +160,8 @@ func (f *Filtering) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 			if err != nil {
 				log.Error("os.Rename: %s: %s", filter.Path(), err)
 			}
+			removeFilterVersions(filter.Path())
+			_ = os.Remove(filterStatusPath(filter.Path()))
 		}
 	}
 	// Update the configuration after removing filter files
@@ -136,9 +176,17 @@ func (f *Filtering) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 }
 
 type filterURLJSON struct {
-	Name    string `json:"name"`
-	URL     string `json:"url"`
-	Enabled bool   `json:"enabled"`
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	Enabled        bool   `json:"enabled"`
+	UpdateInterval uint32 `json:"update_interval"` // in hours (0: use the global interval)
+	SHA256         string `json:"sha256"`
+	SHA256URL      string `json:"sha256_url"`
+	Group          string `json:"group"`
+	ClientsOnly    bool   `json:"clients_only"`
+
+	// Additional HTTP request headers (not set: the headers aren't changed, empty: remove the headers)
+	Headers map[string]string `json:"headers"`
 }
 
 type filterURLReq struct {
@@ -159,11 +207,31 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 		http.Error(w, "invalid URL or file path", http.StatusBadRequest)
 		return
 	}
+	err = validateFilterChecksum(fj.Data.SHA256, fj.Data.SHA256URL)
+	if err == nil {
+		err = validateFilterHeaders(fj.Data.Headers)
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	err = validateFilterGroup(fj.Data.Group)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
 
 	filt := filter{
 		Enabled: fj.Data.Enabled,
 		Name:    fj.Data.Name,
 		URL:     fj.Data.URL,
+
+		UpdateInterval: fj.Data.UpdateInterval,
+		SHA256:         fj.Data.SHA256,
+		SHA256URL:      fj.Data.SHA256URL,
+		Group:          fj.Data.Group,
+		ClientsOnly:    fj.Data.ClientsOnly && !fj.Whitelist,
+		Headers:        fj.Data.Headers,
 	}
 	status := f.filterSetProperties(fj.URL, filt, fj.Whitelist)
 	if (status & statusFound) == 0 {
@@ -177,7 +245,7 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 
 	onConfigModified()
 	restart := false
-	if (status & statusEnabledChanged) != 0 {
+	if (status & (statusEnabledChanged | statusUsageChanged)) != 0 {
 		// we must add or remove filter rules
 		restart = true
 	}
@@ -207,9 +275,32 @@ func (f *Filtering) handleFilteringSetRules(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	config.UserRules = strings.Split(string(body), "\n")
+	rules, err := normalizeRules(strings.Split(string(body), "\n"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	for _, line := range rules {
+		_, _, err = dnsforward.ParseUpstreamRule(line)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
+	upstreams := userUpstreamRules()
+	config.UserRules = rules
 	onConfigModified()
 	enableFilters(true)
+
+	if !reflect.DeepEqual(upstreams, userUpstreamRules()) {
+		Context.clients.resetUpstreamConfigs()
+		err = reconfigureDNSServer()
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+	}
 }
 
 func (f *Filtering) handleFilteringRefresh(w http.ResponseWriter, r *http.Request) {
@@ -250,21 +341,96 @@ func (f *Filtering) handleFilteringRefresh(w http.ResponseWriter, r *http.Reques
 	_, _ = w.Write(js)
 }
 
-type filterJSON struct {
+type filterRefreshReq struct {
+	ID        int64  `json:"id"`
+	URL       string `json:"url"`
+	Whitelist bool   `json:"whitelist"`
+}
+
+type filterRefreshResp struct {
 	ID          int64  `json:"id"`
-	Enabled     bool   `json:"enabled"`
-	URL         string `json:"url"`
-	Name        string `json:"name"`
+	Updated     bool   `json:"updated"` // the filter data has changed
 	RulesCount  uint32 `json:"rules_count"`
 	LastUpdated string `json:"last_updated"`
 }
 
+// POST /control/filtering/refresh_filter
+func (f *Filtering) handleFilteringRefreshFilter(w http.ResponseWriter, r *http.Request) {
+	req := filterRefreshReq{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+	if (req.ID == 0) == (len(req.URL) == 0) {
+		httpError(w, http.StatusBadRequest, "either id or url must be specified")
+		return
+	}
+
+	// the other requests aren't blocked while the filter is downloaded
+	Context.controlLock.Unlock()
+	flt, updated, err := f.refreshFilter(req.ID, req.URL, req.Whitelist)
+	Context.controlLock.Lock()
+	if err != nil {
+		code := http.StatusInternalServerError
+		if flt.ID == 0 {
+			code = http.StatusBadRequest // the filter isn't found or is disabled
+		}
+		httpError(w, code, "Couldn't update filter: %s", err)
+		return
+	}
+
+	resp := filterRefreshResp{
+		ID:         flt.ID,
+		Updated:    updated,
+		RulesCount: uint32(flt.RulesCount),
+	}
+	if !flt.LastUpdated.IsZero() {
+		resp.LastUpdated = flt.LastUpdated.Format(time.RFC3339)
+	}
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json encode: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+type filterJSON struct {
+	ID             int64  `json:"id"`
+	Enabled        bool   `json:"enabled"`
+	URL            string `json:"url"`
+	Name           string `json:"name"`
+	RulesCount     uint32 `json:"rules_count"`
+	LastUpdated    string `json:"last_updated"`
+	UpdateInterval uint32 `json:"update_interval"` // in hours (0: use the global interval)
+	Versions       int    `json:"versions"`        // the number of previous versions stored on disk
+	SHA256         string `json:"sha256"`          // the pinned checksum of the data
+	SHA256URL      string `json:"sha256_url"`      // the URL of the file with the checksum
+	Group          string `json:"group"`
+	ClientsOnly    bool   `json:"clients_only"`
+
+	HeaderNames []string `json:"header_names"` // the names of the additional request headers (the values aren't returned)
+	Stale       string   `json:"stale"`        // "" | "unchanged" | "failing" | "redirected"
+}
+
 type filteringConfig struct {
 	Enabled          bool         `json:"enabled"`
 	Interval         uint32       `json:"interval"` // in hours
 	Filters          []filterJSON `json:"filters"`
 	WhitelistFilters []filterJSON `json:"whitelist_filters"`
 	UserRules        []string     `json:"user_rules"`
+
+	Groups []filterGroupJSON `json:"groups"` // the filter groups with the aggregated counters
+
+	Dedup *filterDedupJSON `json:"deduplication,omitempty"` // the results of the deduplication of the rules
+}
+
+type filterDedupJSON struct {
+	Enabled    bool                 `json:"enabled"`
+	Blocklists dnsfilter.DedupStats `json:"blocklists"`
+	Whitelists dnsfilter.DedupStats `json:"whitelists"`
 }
 
 func filterToJSON(f filter) filterJSON {
@@ -274,6 +440,15 @@ func filterToJSON(f filter) filterJSON {
 		URL:        f.URL,
 		Name:       f.Name,
 		RulesCount: uint32(f.RulesCount),
+
+		UpdateInterval: f.UpdateInterval,
+		Versions:       filterVersionsCount(f.Path()),
+		SHA256:         f.SHA256,
+		SHA256URL:      f.SHA256URL,
+		Group:          f.Group,
+		ClientsOnly:    f.ClientsOnly,
+		HeaderNames:    filterHeaderNames(f.Headers),
+		Stale:          f.staleReason(time.Now(), config.DNS.FiltersStaleDays),
 	}
 
 	if !f.LastUpdated.IsZero() {
@@ -298,6 +473,148 @@ func (f *Filtering) handleFilteringStatus(w http.ResponseWriter, r *http.Request
 		resp.WhitelistFilters = append(resp.WhitelistFilters, fj)
 	}
 	resp.UserRules = config.UserRules
+	resp.Groups = append(filterGroupsNoLock(config.Filters, false),
+		filterGroupsNoLock(config.WhitelistFilters, true)...)
+	dedup := config.DNS.DnsfilterConf.DeduplicateRules
+	config.RUnlock()
+
+	if Context.dnsFilter != nil {
+		resp.Dedup = &filterDedupJSON{Enabled: dedup}
+		resp.Dedup.Blocklists, resp.Dedup.Whitelists = Context.dnsFilter.DedupStats()
+	}
+
+	jsonVal, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json encode: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(jsonVal)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "http write: %s", err)
+	}
+}
+
+type filterUpdateStatusJSON struct {
+	ID          int64  `json:"id"`
+	URL         string `json:"url"`
+	Name        string `json:"name"`
+	Whitelist   bool   `json:"whitelist"`
+	Enabled     bool   `json:"enabled"`
+	RulesCount  int    `json:"rules_count"`
+	RulesDelta  int    `json:"rules_delta"`
+	Size        int64  `json:"size"`
+	LastUpdated string `json:"last_updated"`
+	LastCheck   string `json:"last_check"`
+	NextUpdate  string `json:"next_update"`
+	HTTPStatus  int    `json:"http_status"`
+	Error       string `json:"error"`
+
+	Failures      int    `json:"failures"`        // the number of consecutive failed attempts
+	LastError     string `json:"last_error"`      // the error of the last failed attempt
+	LastErrorTime string `json:"last_error_time"` // the time of the last failed attempt
+	FailingSince  string `json:"failing_since"`   // the time of the first of the consecutive failed attempts
+	Failure       string `json:"failure"`         // the summary of the consecutive failures ("": the last attempt has succeeded)
+	Bytes         int64  `json:"bytes"`           // the number of bytes received at the last attempt
+
+	SHA256       string `json:"sha256"`       // SHA-256 checksum of the last downloaded data
+	Verification string `json:"verification"` // "" (the checksum isn't pinned) | "ok" | "failed"
+
+	Stale       string `json:"stale"`        // the reason why the filter may be a dead subscription ("": it's not)
+	LastChanged string `json:"last_changed"` // the time of the last change of the data
+	RedirectURL string `json:"redirect_url"` // the URL on another host the filter URL redirects to
+
+	DownloadDuration int64 `json:"download_duration"` // the duration of the last update attempt (in milliseconds)
+
+	// The metadata declared in the header of the filter
+	Title    string `json:"title"`
+	Version  string `json:"version"`
+	Expires  uint32 `json:"expires"` // the declared update interval in hours (0: not declared)
+	Homepage string `json:"homepage"`
+	License  string `json:"license"`
+}
+
+// filterToUpdateStatusJSON - get the update status of the filter
+func filterToUpdateStatusJSON(f filter, whitelist bool) filterUpdateStatusJSON {
+	interval := f.updateInterval()
+	fj := filterUpdateStatusJSON{
+		ID:         f.ID,
+		URL:        f.URL,
+		Name:       f.Name,
+		Whitelist:  whitelist,
+		Enabled:    f.Enabled,
+		RulesCount: f.RulesCount,
+		RulesDelta: f.status.rulesDelta,
+		Size:       f.status.size,
+		HTTPStatus: f.status.HTTPStatus,
+		Error:      f.status.Err,
+		Failures:   f.status.Failures,
+		LastError:  f.status.LastError,
+		Failure:    f.failureText(time.Now()),
+		Bytes:      f.status.Bytes,
+
+		SHA256:       f.status.sha256,
+		Verification: f.status.verification,
+
+		Stale:       f.staleReason(time.Now(), config.DNS.FiltersStaleDays),
+		RedirectURL: f.status.redirectURL,
+
+		DownloadDuration: f.status.Duration.Milliseconds(),
+
+		Title:    f.status.meta.title,
+		Version:  f.status.meta.version,
+		Expires:  f.status.meta.expires,
+		Homepage: f.status.meta.homepage,
+		License:  f.status.meta.license,
+	}
+
+	if !f.LastUpdated.IsZero() {
+		fj.LastUpdated = f.LastUpdated.Format(time.RFC3339)
+	}
+	if !f.status.Checked.IsZero() {
+		fj.LastCheck = f.status.Checked.Format(time.RFC3339)
+	}
+	if !f.status.LastErrorTime.IsZero() {
+		fj.LastErrorTime = f.status.LastErrorTime.Format(time.RFC3339)
+	}
+	if !f.status.Changed.IsZero() {
+		fj.LastChanged = f.status.Changed.Format(time.RFC3339)
+	}
+	if f.status.Failures != 0 && !f.status.FailingSince.IsZero() {
+		fj.FailingSince = f.status.FailingSince.Format(time.RFC3339)
+	}
+	if f.Enabled && interval != 0 {
+		next := f.LastUpdated.Add(time.Duration(interval) * time.Hour)
+		if f.status.Failures != 0 {
+			next = f.status.nextRetry
+		}
+		now := time.Now()
+		if next.Before(now) {
+			// the filter is updated by the next periodic check
+			next = now
+		}
+		fj.NextUpdate = next.Format(time.RFC3339)
+	}
+	return fj
+}
+
+// Get the update status of all filters
+func (f *Filtering) handleFilteringUpdateStatus(w http.ResponseWriter, r *http.Request) {
+	type Resp struct {
+		Interval uint32                   `json:"interval"`      // in hours
+		Window   string                   `json:"update_window"` // "HH:MM-HH:MM" ("": any time)
+		Filters  []filterUpdateStatusJSON `json:"filters"`
+	}
+	resp := Resp{Filters: []filterUpdateStatusJSON{}}
+	config.RLock()
+	resp.Interval = config.DNS.FiltersUpdateIntervalHours
+	resp.Window = config.DNS.FiltersUpdateWindow
+	for _, f := range config.Filters {
+		resp.Filters = append(resp.Filters, filterToUpdateStatusJSON(f, false))
+	}
+	for _, f := range config.WhitelistFilters {
+		resp.Filters = append(resp.Filters, filterToUpdateStatusJSON(f, true))
+	}
 	config.RUnlock()
 
 	jsonVal, err := json.Marshal(resp)
@@ -347,7 +664,11 @@ type checkHostResp struct {
 
 func (f *Filtering) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
-	host := q.Get("name")
+	host, err := util.NormalizeDomain(q.Get("name"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
 
 	setts := Context.dnsFilter.GetConfig()
 	setts.FilteringEnabled = true
@@ -377,11 +698,21 @@ func (f *Filtering) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 // RegisterFilteringHandlers - register handlers
 func (f *Filtering) RegisterFilteringHandlers() {
 	httpRegister("GET", "/control/filtering/status", f.handleFilteringStatus)
+	httpRegister("GET", "/control/filtering/update_status", f.handleFilteringUpdateStatus)
+	httpRegister("GET", "/control/filtering/list", f.handleFilteringList)
 	httpRegister("POST", "/control/filtering/config", f.handleFilteringConfig)
 	httpRegister("POST", "/control/filtering/add_url", f.handleFilteringAddURL)
 	httpRegister("POST", "/control/filtering/remove_url", f.handleFilteringRemoveURL)
 	httpRegister("POST", "/control/filtering/set_url", f.handleFilteringSetURL)
+	httpRegister("GET", "/control/filtering/groups", f.handleFilteringGroups)
+	httpRegister("POST", "/control/filtering/set_group", f.handleFilteringSetGroup)
 	httpRegister("POST", "/control/filtering/refresh", f.handleFilteringRefresh)
+	httpRegister("POST", "/control/filtering/refresh_filter", f.handleFilteringRefreshFilter)
+	httpRegister("POST", "/control/filtering/rollback", f.handleFilteringRollback)
+	httpRegister("GET", "/control/filtering/registry", f.handleFilteringRegistry)
+	httpRegister("POST", "/control/filtering/registry/add", f.handleFilteringRegistryAdd)
+	httpRegister("GET", "/control/filtering/export", f.handleFilteringExport)
+	httpRegister("POST", "/control/filtering/import", f.handleFilteringImport)
 	httpRegister("POST", "/control/filtering/set_rules", f.handleFilteringSetRules)
 	httpRegister("GET", "/control/filtering/check_host", f.handleCheckHost)
 }
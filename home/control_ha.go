@@ -0,0 +1,259 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/stats"
+)
+
+// Home Assistant integration API
+
+// The default and the maximum time to wait for a state change (in seconds)
+const (
+	haWaitDefault = 30
+	haWaitMax     = 60
+)
+
+// haStateVersion - the version of the settings state
+// It's incremented when the settings are changed.
+var haStateVersion = struct {
+	sync.Mutex
+	version uint64
+	changed chan struct{} // closed when the version is incremented
+}{
+	changed: make(chan struct{}),
+}
+
+// haStateChanged - increment the state version and wake up the waiting requests
+func haStateChanged() {
+	haStateVersion.Lock()
+	haStateVersion.version++
+	close(haStateVersion.changed)
+	haStateVersion.changed = make(chan struct{})
+	haStateVersion.Unlock()
+}
+
+// haWait - wait until the state version differs from the specified one
+// Return the current version.
+func haWait(version uint64, timeout time.Duration) uint64 {
+	haStateVersion.Lock()
+	cur := haStateVersion.version
+	ch := haStateVersion.changed
+	haStateVersion.Unlock()
+	if cur != version {
+		return cur
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+	}
+
+	haStateVersion.Lock()
+	cur = haStateVersion.version
+	haStateVersion.Unlock()
+	return cur
+}
+
+type haClientJSON struct {
+	Name                     string   `json:"name"`
+	UseGlobalSettings        bool     `json:"use_global_settings"`
+	FilteringEnabled         bool     `json:"filtering_enabled"`
+	ParentalEnabled          bool     `json:"parental_enabled"`
+	SafeSearchEnabled        bool     `json:"safesearch_enabled"`
+	SafeBrowsingEnabled      bool     `json:"safebrowsing_enabled"`
+	UseGlobalBlockedServices bool     `json:"use_global_blocked_services"`
+	BlockedServices          []string `json:"blocked_services"`
+}
+
+type haStateJSON struct {
+	Version           uint64         `json:"version"`
+	ProtectionEnabled bool           `json:"protection_enabled"`
+	Stats             stats.Summary  `json:"stats"`
+	Clients           []haClientJSON `json:"clients"`
+	Services          []string       `json:"services"` // the names of the known services
+}
+
+// haGetState - get the current state
+func haGetState(version uint64) haStateJSON {
+	st := haStateJSON{
+		Version:  version,
+		Clients:  []haClientJSON{},
+		Services: dnsfilter.BlockedSvcNames(),
+	}
+
+	c := dnsforward.FilteringConfig{}
+	if Context.dnsServer != nil {
+		Context.dnsServer.WriteDiskConfig(&c)
+	} else {
+		c = config.DNS.FilteringConfig
+	}
+	st.ProtectionEnabled = c.ProtectionEnabled
+
+	if Context.stats != nil {
+		st.Stats = Context.stats.GetSummary()
+	}
+
+	Context.clients.lock.Lock()
+	for _, c := range Context.clients.list {
+		st.Clients = append(st.Clients, haClientJSON{
+			Name:                     c.Name,
+			UseGlobalSettings:        !c.UseOwnSettings,
+			FilteringEnabled:         c.FilteringEnabled,
+			ParentalEnabled:          c.ParentalEnabled,
+			SafeSearchEnabled:        c.SafeSearchEnabled,
+			SafeBrowsingEnabled:      c.SafeBrowsingEnabled,
+			UseGlobalBlockedServices: !c.UseOwnBlockedServices,
+			BlockedServices:          c.BlockedServices,
+		})
+	}
+	Context.clients.lock.Unlock()
+	return st
+}
+
+// Get the state
+// If "version" parameter is specified, wait until the settings are changed or "timeout" seconds have passed.
+func handleHAState(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	haStateVersion.Lock()
+	version := haStateVersion.version
+	haStateVersion.Unlock()
+
+	if len(q.Get("version")) != 0 {
+		v, err := strconv.ParseUint(q.Get("version"), 10, 64)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "version: %s", err)
+			return
+		}
+
+		timeout := haWaitDefault
+		if len(q.Get("timeout")) != 0 {
+			timeout, err = strconv.Atoi(q.Get("timeout"))
+			if err != nil || timeout < 0 {
+				httpError(w, http.StatusBadRequest, "invalid timeout")
+				return
+			}
+		}
+		if timeout > haWaitMax {
+			timeout = haWaitMax
+		}
+		version = haWait(v, time.Duration(timeout)*time.Second)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(haGetState(version))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+type haSwitchJSON struct {
+	// "protection" (global only), "filtering", "safebrowsing", "parental", "safesearch" or "service" (per-client only)
+	Switch  string `json:"switch"`
+	Client  string `json:"client"`  // client name
+	Service string `json:"service"` // service name for "service" switch
+	State   bool   `json:"state"`
+}
+
+// haSetClientSwitch - change the client's setting
+// If the client uses the global settings, they are copied to the client's own settings first.
+func haSetClientSwitch(req haSwitchJSON) error {
+	Context.clients.lock.Lock()
+	cp, ok := Context.clients.list[req.Client]
+	var c Client
+	if ok {
+		c = *cp
+	}
+	Context.clients.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("client not found: %s", req.Client)
+	}
+
+	global := dnsfilter.Config{}
+	if Context.dnsFilter != nil {
+		Context.dnsFilter.WriteDiskConfig(&global)
+	}
+
+	if req.Switch == "service" {
+		if !dnsfilter.BlockedSvcKnown(req.Service) {
+			return fmt.Errorf("unknown service: %s", req.Service)
+		}
+		if !c.UseOwnBlockedServices {
+			c.UseOwnBlockedServices = true
+			c.BlockedServices = append([]string{}, global.BlockedServices...)
+		}
+		services := []string{}
+		for _, s := range c.BlockedServices {
+			if s != req.Service {
+				services = append(services, s)
+			}
+		}
+		if req.State {
+			services = append(services, req.Service)
+		}
+		c.BlockedServices = services
+		return Context.clients.Update(c.Name, c)
+	}
+
+	if !c.UseOwnSettings {
+		c.UseOwnSettings = true
+		c.FilteringEnabled = config.DNS.FilteringEnabled
+		c.SafeBrowsingEnabled = global.SafeBrowsingEnabled
+		c.ParentalEnabled = global.ParentalEnabled
+		c.SafeSearchEnabled = global.SafeSearchEnabled
+	}
+	switch req.Switch {
+	case "filtering":
+		c.FilteringEnabled = req.State
+	case "safebrowsing":
+		c.SafeBrowsingEnabled = req.State
+	case "parental":
+		c.ParentalEnabled = req.State
+	case "safesearch":
+		c.SafeSearchEnabled = req.State
+	default:
+		return fmt.Errorf("unknown switch: %s", req.Switch)
+	}
+	return Context.clients.Update(c.Name, c)
+}
+
+// Turn a switch on or off
+func handleHASwitch(w http.ResponseWriter, r *http.Request) {
+	req := haSwitchJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	if len(req.Client) == 0 {
+		if req.Switch != "protection" {
+			httpError(w, http.StatusBadRequest, "unknown switch: %s", req.Switch)
+			return
+		}
+		if Context.dnsServer == nil {
+			httpError(w, http.StatusBadRequest, "DNS server isn't initialized")
+			return
+		}
+		Context.dnsServer.SetProtectionEnabled(req.State)
+		returnOK(w)
+		return
+	}
+
+	err = haSetClientSwitch(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	onConfigModified()
+	returnOK(w)
+}
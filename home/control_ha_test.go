@@ -0,0 +1,55 @@
+package home
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHAWait(t *testing.T) {
+	haStateVersion.Lock()
+	v := haStateVersion.version
+	haStateVersion.Unlock()
+
+	// the state has been changed already
+	assert.Equal(t, v, haWait(v-1, time.Second))
+
+	// timeout
+	assert.Equal(t, v, haWait(v, 10*time.Millisecond))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		haStateChanged()
+	}()
+	assert.Equal(t, v+1, haWait(v, 5*time.Second))
+}
+
+func TestHASetClientSwitch(t *testing.T) {
+	Context.clients.testing = true
+	Context.clients.Init(nil, nil, nil)
+	ok, err := Context.clients.Add(Client{
+		IDs:  []string{"1.1.1.1"},
+		Name: "client1",
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	defer Context.clients.Del("client1")
+
+	config.DNS.FilteringEnabled = true
+	assert.Nil(t, haSetClientSwitch(haSwitchJSON{Client: "client1", Switch: "safebrowsing", State: true}))
+	c, _ := Context.clients.Find("1.1.1.1")
+	assert.True(t, c.UseOwnSettings)
+	assert.True(t, c.SafeBrowsingEnabled)
+	// copied from the global settings
+	assert.True(t, c.FilteringEnabled)
+
+	assert.Nil(t, haSetClientSwitch(haSwitchJSON{Client: "client1", Switch: "filtering", State: false}))
+	c, _ = Context.clients.Find("1.1.1.1")
+	assert.False(t, c.FilteringEnabled)
+	assert.True(t, c.SafeBrowsingEnabled)
+
+	assert.NotNil(t, haSetClientSwitch(haSwitchJSON{Client: "client1", Switch: "unknown"}))
+	assert.NotNil(t, haSetClientSwitch(haSwitchJSON{Client: "client1", Switch: "service", Service: "unknown"}))
+	assert.NotNil(t, haSetClientSwitch(haSwitchJSON{Client: "client2", Switch: "filtering"}))
+}
@@ -101,6 +101,8 @@ func (web *Web) handleInstallCheckConfig(w http.ResponseWriter, r *http.Request)
 		httpError(w, http.StatusBadRequest, "Failed to parse 'check_config' JSON data: %s", err)
 		return
 	}
+	reqData.Web.IP = util.TrimBrackets(reqData.Web.IP)
+	reqData.DNS.IP = util.TrimBrackets(reqData.DNS.IP)
 
 	if reqData.Web.Port != 0 && reqData.Web.Port != config.BindPort {
 		err = util.CheckPortAvailable(reqData.Web.IP, reqData.Web.Port)
@@ -216,7 +218,7 @@ func checkDNSStubListener() bool {
 
 const resolvedConfPath = "/etc/systemd/resolved.conf.d/adguardhome.conf"
 const resolvedConfData = `[Resolve]
-DNS=127.0.0.1
+DNS=%s
 DNSStubListener=no
 `
 const resolvConfPath = "/etc/resolv.conf"
@@ -229,7 +231,8 @@ func disableDNSStubListener() error {
 		return fmt.Errorf("os.MkdirAll: %s: %s", dir, err)
 	}
 
-	err = ioutil.WriteFile(resolvedConfPath, []byte(resolvedConfData), 0644)
+	data := fmt.Sprintf(resolvedConfData, util.LocalhostIP())
+	err = ioutil.WriteFile(resolvedConfPath, []byte(data), 0644)
 	if err != nil {
 		return fmt.Errorf("ioutil.WriteFile: %s: %s", resolvedConfPath, err)
 	}
@@ -264,6 +267,8 @@ type applyConfigReq struct {
 	DNS      applyConfigReqEnt `json:"dns"`
 	Username string            `json:"username"`
 	Password string            `json:"password"`
+
+	LowResourceMode bool `json:"low_resource_mode"` // use the settings for a constrained device
 }
 
 // Copy installation parameters between two configuration objects
@@ -283,11 +288,35 @@ func (web *Web) handleInstallConfigure(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if newSettings.Web.Port == 0 || newSettings.DNS.Port == 0 {
-		httpError(w, http.StatusBadRequest, "port value can't be 0")
+	restartHTTP, code, err := web.install(newSettings)
+	if err != nil {
+		httpError(w, code, "%s", err)
 		return
 	}
 
+	returnOK(w)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	// this needs to be done in a goroutine because Shutdown() is a blocking call, and it will block
+	// until all requests are finished, and _we_ are inside a request right now, so it will block indefinitely
+	if restartHTTP {
+		go func() {
+			_ = Context.web.httpServer.Shutdown(context.TODO())
+		}()
+	}
+}
+
+// install - apply the installation settings:  start DNS server, add the user, write the configuration file
+// Return TRUE if Web server must be restarted on the new address, or HTTP status code and the error.
+func (web *Web) install(newSettings applyConfigReq) (bool, int, error) {
+	if newSettings.Web.Port == 0 || newSettings.DNS.Port == 0 {
+		return false, http.StatusBadRequest, fmt.Errorf("port value can't be 0")
+	}
+	newSettings.Web.IP = util.TrimBrackets(newSettings.Web.IP)
+	newSettings.DNS.IP = util.TrimBrackets(newSettings.DNS.IP)
+
 	restartHTTP := true
 	if config.BindHost == newSettings.Web.IP && config.BindPort == newSettings.Web.Port {
 		// no need to rebind
@@ -296,24 +325,21 @@ func (web *Web) handleInstallConfigure(w http.ResponseWriter, r *http.Request) {
 
 	// validate that hosts and ports are bindable
 	if restartHTTP {
-		err = util.CheckPortAvailable(newSettings.Web.IP, newSettings.Web.Port)
+		err := util.CheckPortAvailable(newSettings.Web.IP, newSettings.Web.Port)
 		if err != nil {
-			httpError(w, http.StatusBadRequest, "Impossible to listen on IP:port %s due to %s",
+			return false, http.StatusBadRequest, fmt.Errorf("Impossible to listen on IP:port %s due to %s",
 				net.JoinHostPort(newSettings.Web.IP, strconv.Itoa(newSettings.Web.Port)), err)
-			return
 		}
 	}
 
-	err = util.CheckPacketPortAvailable(newSettings.DNS.IP, newSettings.DNS.Port)
+	err := util.CheckPacketPortAvailable(newSettings.DNS.IP, newSettings.DNS.Port)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "%s", err)
-		return
+		return false, http.StatusBadRequest, err
 	}
 
 	err = util.CheckPortAvailable(newSettings.DNS.IP, newSettings.DNS.Port)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "%s", err)
-		return
+		return false, http.StatusBadRequest, err
 	}
 
 	var curConfig configuration
@@ -324,13 +350,16 @@ func (web *Web) handleInstallConfigure(w http.ResponseWriter, r *http.Request) {
 	config.BindPort = newSettings.Web.Port
 	config.DNS.BindHost = newSettings.DNS.IP
 	config.DNS.Port = newSettings.DNS.Port
+	if newSettings.LowResourceMode {
+		applyLowResourceProfile(&config)
+		initLowResourceMode()
+	}
 
 	err = StartMods()
 	if err != nil {
 		Context.firstRun = true
 		copyInstallSettings(&config, &curConfig)
-		httpError(w, http.StatusInternalServerError, "%s", err)
-		return
+		return false, http.StatusInternalServerError, err
 	}
 
 	u := User{}
@@ -341,8 +370,7 @@ func (web *Web) handleInstallConfigure(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		Context.firstRun = true
 		copyInstallSettings(&config, &curConfig)
-		httpError(w, http.StatusInternalServerError, "Couldn't write config: %s", err)
-		return
+		return false, http.StatusInternalServerError, fmt.Errorf("Couldn't write config: %s", err)
 	}
 
 	web.conf.firstRun = false
@@ -350,23 +378,13 @@ func (web *Web) handleInstallConfigure(w http.ResponseWriter, r *http.Request) {
 	web.conf.BindPort = newSettings.Web.Port
 
 	registerControlHandlers()
-
-	returnOK(w)
-	if f, ok := w.(http.Flusher); ok {
-		f.Flush()
-	}
-
-	// this needs to be done in a goroutine because Shutdown() is a blocking call, and it will block
-	// until all requests are finished, and _we_ are inside a request right now, so it will block indefinitely
-	if restartHTTP {
-		go func() {
-			_ = Context.web.httpServer.Shutdown(context.TODO())
-		}()
-	}
+	return restartHTTP, 0, nil
 }
 
 func (web *Web) registerInstallHandlers() {
 	http.HandleFunc("/control/install/get_addresses", preInstall(ensureGET(web.handleInstallGetAddresses)))
 	http.HandleFunc("/control/install/check_config", preInstall(ensurePOST(web.handleInstallCheckConfig)))
 	http.HandleFunc("/control/install/configure", preInstall(ensurePOST(web.handleInstallConfigure)))
+	http.HandleFunc("/control/install/import_pihole", preInstall(ensurePOST(handleInstallImportPihole)))
+	http.HandleFunc("/control/install/import_unbound", preInstall(ensurePOST(handleInstallImportUnbound)))
 }
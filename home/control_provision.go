@@ -0,0 +1,256 @@
+package home
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// provisionFilterJSON - a filter list to add
+type provisionFilterJSON struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// provisionReq - the settings of the setup wizard and the initial settings of the modules
+type provisionReq struct {
+	applyConfigReq
+
+	UpstreamDNS      []string              `json:"upstream_dns"`      // optional
+	DHCP             *dhcpd.ServerConfig   `json:"dhcp"`              // optional
+	Filters          []provisionFilterJSON `json:"filters"`           // the filters are added if they don't exist
+	WhitelistFilters []provisionFilterJSON `json:"whitelist_filters"` // the filters are added if they don't exist
+}
+
+type provisionResp struct {
+	Installed        bool `json:"installed"`         // TRUE: the first-run configuration has been done by this request
+	UpstreamsChanged bool `json:"upstreams_changed"` // TRUE: upstream DNS servers have been changed
+	DHCPChanged      bool `json:"dhcp_changed"`      // TRUE: DHCP server has been reconfigured
+	FiltersAdded     int  `json:"filters_added"`     // the number of added filters
+}
+
+// validate - check the settings that don't depend on the state of the instance
+func (req *provisionReq) validate() error {
+	if len(req.Username) == 0 || len(req.Password) == 0 {
+		return fmt.Errorf("username and password must be specified")
+	}
+	if req.Web.Port == 0 || req.DNS.Port == 0 {
+		return fmt.Errorf("port value can't be 0")
+	}
+	if len(req.UpstreamDNS) != 0 {
+		err := dnsforward.ValidateUpstreams(req.UpstreamDNS)
+		if err != nil {
+			return fmt.Errorf("upstream_dns: %s", err)
+		}
+	}
+	for _, list := range [][]provisionFilterJSON{req.Filters, req.WhitelistFilters} {
+		for _, f := range list {
+			if !isValidURL(f.URL) {
+				return fmt.Errorf("invalid URL or file path: %s", f.URL)
+			}
+		}
+	}
+	if req.DHCP != nil {
+		err := Context.dhcpServer.CheckConfig(*req.DHCP)
+		if err != nil {
+			return fmt.Errorf("Invalid DHCP configuration: %s", err)
+		}
+	}
+	return nil
+}
+
+// dhcpConfigEqual - return TRUE if DHCP settings are equal
+func dhcpConfigEqual(a, b dhcpd.ServerConfig) bool {
+	return a.Enabled == b.Enabled &&
+		a.InterfaceName == b.InterfaceName &&
+		a.GatewayIP == b.GatewayIP &&
+		a.SubnetMask == b.SubnetMask &&
+		a.RangeStart == b.RangeStart &&
+		a.RangeEnd == b.RangeEnd &&
+		a.LeaseDuration == b.LeaseDuration &&
+		a.ICMPTimeout == b.ICMPTimeout
+}
+
+// provisionFilters - download and add the filters which don't exist yet
+// Return the number of added filters.
+func provisionFilters(list []provisionFilterJSON, whitelist bool) (int, error) {
+	n := 0
+	for _, fj := range list {
+		if filterExists(fj.URL) {
+			continue
+		}
+		filt := filter{
+			Enabled: true,
+			URL:     fj.URL,
+			Name:    fj.Name,
+			white:   whitelist,
+		}
+		filt.ID = assignUniqueFilterID()
+		ok, err := Context.filters.update(&filt)
+		if err != nil {
+			return n, fmt.Errorf("Couldn't fetch filter from url %s: %s", filt.URL, err)
+		}
+		if !ok {
+			return n, fmt.Errorf("Filter at the url %s is invalid (maybe it points to blank page?)", filt.URL)
+		}
+		if filterAdd(filt) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// provisionHandler - get the handler of the provisioning API
+// Before the first-run configuration it's available without authentication:  the request sets the user name and password.
+// After it the request is authorized like the other control requests, including the user roles.
+// A path can be registered only once, so the handler is chosen on each request.
+func (web *Web) provisionHandler() http.HandlerFunc {
+	install := preInstall(ensurePOST(web.handleInstallProvision))
+	configured := controlHandler(http.MethodPost, handleProvision)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if Context.firstRun {
+			install(w, r)
+			return
+		}
+		configured.ServeHTTP(w, r)
+	}
+}
+
+// decodeProvisionReq - read the provisioning request
+func decodeProvisionReq(w http.ResponseWriter, r *http.Request) (*provisionReq, bool) {
+	req := &provisionReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return nil, false
+	}
+	req.Web.IP = util.TrimBrackets(req.Web.IP)
+	req.DNS.IP = util.TrimBrackets(req.DNS.IP)
+	return req, true
+}
+
+// POST /control/install/provision (first run)
+// Perform the first-run configuration and apply the initial settings in one request.
+func (web *Web) handleInstallProvision(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeProvisionReq(w, r)
+	if !ok {
+		return
+	}
+	if !Context.firstRun {
+		// another request has done the first-run configuration while this one was waiting for the lock
+		httpError(w, http.StatusForbidden, "the instance is already configured")
+		return
+	}
+	err := req.validate()
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	resp := provisionResp{}
+	oldUpstreams := config.DNS.UpstreamDNS
+	if len(req.UpstreamDNS) != 0 {
+		config.DNS.UpstreamDNS = req.UpstreamDNS
+		resp.UpstreamsChanged = true
+	}
+	restartHTTP, code, err := web.install(req.applyConfigReq)
+	if err != nil {
+		config.DNS.UpstreamDNS = oldUpstreams
+		httpError(w, code, "%s", err)
+		return
+	}
+	resp.Installed = true
+	if restartHTTP {
+		// this needs to be done after the response is written (see handleInstallConfigure())
+		defer func() {
+			go func() {
+				_ = Context.web.httpServer.Shutdown(context.TODO())
+			}()
+		}()
+	}
+
+	provision(w, req, resp)
+}
+
+// POST /control/install/provision (the instance is configured)
+// The request is idempotent:  only the settings that differ from the current ones are applied.
+func handleProvision(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeProvisionReq(w, r)
+	if !ok {
+		return
+	}
+	err := req.validate()
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	if config.BindHost != req.Web.IP || config.BindPort != req.Web.Port ||
+		config.DNS.BindHost != req.DNS.IP || config.DNS.Port != req.DNS.Port {
+		httpError(w, http.StatusConflict, "the instance is already configured with other addresses")
+		return
+	}
+
+	resp := provisionResp{}
+	if len(req.UpstreamDNS) != 0 && !arraysEqual(config.DNS.UpstreamDNS, req.UpstreamDNS) {
+		config.DNS.UpstreamDNS = req.UpstreamDNS
+		err = reconfigureDNSServer()
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+		resp.UpstreamsChanged = true
+	}
+
+	provision(w, req, resp)
+}
+
+// provision - apply DHCP settings, add the filters and write the response
+func provision(w http.ResponseWriter, req *provisionReq, resp provisionResp) {
+	if req.DHCP != nil {
+		cur := dhcpd.ServerConfig{}
+		Context.dhcpServer.WriteDiskConfig(&cur)
+		if !dhcpConfigEqual(cur, *req.DHCP) {
+			err := Context.dhcpServer.Reconfigure(*req.DHCP)
+			if err != nil {
+				httpError(w, http.StatusInternalServerError, "%s", err)
+				return
+			}
+			resp.DHCPChanged = true
+		}
+	}
+
+	n, err := provisionFilters(req.Filters, false)
+	resp.FiltersAdded += n
+	if err == nil {
+		n, err = provisionFilters(req.WhitelistFilters, true)
+		resp.FiltersAdded += n
+	}
+	if resp.FiltersAdded != 0 {
+		enableFilters(true)
+	}
+	onConfigModified()
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	log.Info("Provisioning: installed:%v upstreams:%v DHCP:%v filters added:%d",
+		resp.Installed, resp.UpstreamsChanged, resp.DHCPChanged, resp.FiltersAdded)
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
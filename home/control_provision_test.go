@@ -0,0 +1,104 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvisionReq(t *testing.T) {
+	data := `{"web":{"ip":"0.0.0.0","port":3000},"dns":{"ip":"0.0.0.0","port":53},
+"username":"admin","password":"pass",
+"upstream_dns":["tls://1.1.1.1"],
+"filters":[{"name":"list","url":"https://example.org/list.txt"}]}`
+	req := provisionReq{}
+	assert.Nil(t, json.Unmarshal([]byte(data), &req))
+	assert.Equal(t, 3000, req.Web.Port)
+	assert.Equal(t, "admin", req.Username)
+	assert.Nil(t, req.DHCP)
+	assert.Nil(t, req.validate())
+
+	req.Password = ""
+	assert.NotNil(t, req.validate())
+	req.Password = "pass"
+
+	req.UpstreamDNS = []string{"bad://1.1.1.1"}
+	assert.NotNil(t, req.validate())
+	req.UpstreamDNS = nil
+
+	req.Filters[0].URL = "example.org/list.txt"
+	assert.NotNil(t, req.validate())
+}
+
+func TestDHCPConfigEqual(t *testing.T) {
+	a := dhcpd.ServerConfig{
+		Enabled:       true,
+		InterfaceName: "eth0",
+		RangeStart:    "192.168.1.100",
+		RangeEnd:      "192.168.1.200",
+		WorkDir:       "/opt/agh",
+	}
+	b := a
+	b.WorkDir = ""
+	b.ConfigModified = func() {}
+	assert.True(t, dhcpConfigEqual(a, b))
+	b.RangeEnd = "192.168.1.250"
+	assert.False(t, dhcpConfigEqual(a, b))
+}
+
+func TestProvisionAuth(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	oldFirstRun := Context.firstRun
+	oldWeb := Context.web
+	defer func() {
+		Context.firstRun = oldFirstRun
+		Context.web = oldWeb
+	}()
+
+	users := []User{
+		User{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
+	}
+	Context.auth = InitAuth(filepath.Join(dir, "sessions.db"), users, 60, nil)
+	defer func() {
+		Context.auth.Close()
+		Context.auth = nil
+	}()
+	web := &Web{}
+	Context.web = web
+	handler := web.provisionHandler()
+
+	// the first-run configuration doesn't require authentication
+	Context.firstRun = true
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/control/install/provision", strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the configured instance requires authentication:  the user name and password in the request aren't used
+	Context.firstRun = false
+	body := `{"username":"name","password":"password"}`
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/control/install/provision", strings.NewReader(body)))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// read-only users can't provision
+	r := httptest.NewRequest("POST", "/control/install/provision", strings.NewReader(body))
+	r.Header.Set("Cookie", Context.auth.newSessionCookie(User{Name: "viewer", Role: roleViewer}))
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// the request is authorized like the other control requests
+	r = httptest.NewRequest("POST", "/control/install/provision", strings.NewReader("{"))
+	r.SetBasicAuth("name", "password")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,200 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/miekg/dns"
+)
+
+// findIPByName - get an IP address of the persistent client:  its first IP address, CIDR or MAC address with a DHCP lease
+func (clients *clientsContainer) findIPByName(name string) (net.IP, error) {
+	clients.lock.Lock()
+	c, ok := clients.list[name]
+	var ids []string
+	if ok {
+		ids = stringArrayDup(c.IDs)
+	}
+	clients.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("client not found: %s", name)
+	}
+
+	for _, id := range ids {
+		if ip := net.ParseIP(id); ip != nil {
+			return ip, nil
+		}
+		if _, ipnet, err := net.ParseCIDR(id); err == nil {
+			return ipnet.IP, nil
+		}
+		mac, err := net.ParseMAC(id)
+		if err != nil || clients.dhcpServer == nil {
+			continue
+		}
+		for _, l := range clients.dhcpServer.Leases(dhcpd.LeasesAll) {
+			if net.HardwareAddr(l.HWAddr).String() == mac.String() {
+				return l.IP, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("client %s has no IP address", name)
+}
+
+type lookupStageJSON struct {
+	Name    string  `json:"name"`
+	Elapsed float64 `json:"elapsed_ms"`
+}
+
+type lookupFilteringJSON struct {
+	IsFiltered  bool   `json:"is_filtered"`
+	Reason      string `json:"reason"`
+	Rule        string `json:"rule,omitempty"`
+	FilterID    int64  `json:"filter_id,omitempty"`
+	ServiceName string `json:"service_name,omitempty"`
+	CanonName   string `json:"cname,omitempty"`
+}
+
+type lookupJSON struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	ClientIP   string `json:"client_ip"`
+	ClientName string `json:"client_name,omitempty"` // the persistent client
+
+	Dropped        bool     `json:"dropped"` // the request is dropped by access settings
+	Status         string   `json:"status,omitempty"`
+	Answer         []string `json:"answer"`
+	Authority      []string `json:"authority"`
+	OriginalAnswer []string `json:"original_answer,omitempty"` // the answer of upstream servers modified by filtering
+
+	Filtering lookupFilteringJSON `json:"filtering"`
+	Upstream  string              `json:"upstream,omitempty"`
+	Cached    bool                `json:"cached"`
+
+	Elapsed float64           `json:"elapsed_ms"`
+	Stages  []lookupStageJSON `json:"stages"`
+}
+
+// rrStrings - get the records in zone file format
+func rrStrings(rrs []dns.RR) []string {
+	a := []string{}
+	for _, rr := range rrs {
+		a = append(a, strings.ReplaceAll(rr.String(), "\t", " "))
+	}
+	return a
+}
+
+// durationMs - get the duration in milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// lookupToJSON - convert the lookup result
+func lookupToJSON(lr *dnsforward.LookupResult) lookupJSON {
+	j := lookupJSON{
+		Answer:    []string{},
+		Authority: []string{},
+		Dropped:   lr.AccessBlocked || lr.Response == nil,
+		Filtering: lookupFilteringJSON{
+			IsFiltered:  lr.Result.IsFiltered,
+			Reason:      lr.Result.Reason.String(),
+			Rule:        lr.Result.Rule,
+			FilterID:    lr.Result.FilterID,
+			ServiceName: lr.Result.ServiceName,
+			CanonName:   lr.Result.CanonName,
+		},
+		Upstream: lr.Upstream,
+		Cached:   lr.Cached,
+		Elapsed:  durationMs(lr.Elapsed),
+		Stages:   []lookupStageJSON{},
+	}
+	if lr.Response != nil {
+		j.Status = dns.RcodeToString[lr.Response.Rcode]
+		j.Answer = rrStrings(lr.Response.Answer)
+		j.Authority = rrStrings(lr.Response.Ns)
+	}
+	if lr.OrigResponse != nil {
+		j.OriginalAnswer = rrStrings(lr.OrigResponse.Answer)
+	}
+	for _, st := range lr.Stages {
+		j.Stages = append(j.Stages, lookupStageJSON{Name: st.Name, Elapsed: durationMs(st.Elapsed)})
+	}
+	return j
+}
+
+// Resolve the name through the DNS processing pipeline as if the client asked
+// GET /control/tools/resolve?name=...&type=...&client=...
+func handleToolsResolve(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := strings.TrimSpace(q.Get("name"))
+	if len(name) == 0 {
+		httpError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	name, err := util.NormalizeDomain(name)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	typ := strings.ToUpper(q.Get("type"))
+	if len(typ) == 0 {
+		typ = "A"
+	}
+	qtype, ok := dns.StringToType[typ]
+	if !ok {
+		httpError(w, http.StatusBadRequest, "unknown type %q", typ)
+		return
+	}
+
+	// the client's IP address or name;  the web client's address by default
+	var ip net.IP
+	client := q.Get("client")
+	if len(client) != 0 {
+		ip = net.ParseIP(client)
+		if ip == nil {
+			var err error
+			ip, err = Context.clients.findIPByName(client)
+			if err != nil {
+				httpError(w, http.StatusBadRequest, "%s", err)
+				return
+			}
+		}
+	} else if Context.web != nil {
+		ip = Context.web.access.clientIP(r)
+	}
+	if ip == nil {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+
+	if Context.dnsServer == nil {
+		httpError(w, http.StatusServiceUnavailable, "DNS server isn't running")
+		return
+	}
+	lr, err := Context.dnsServer.Lookup(name, qtype, ip)
+	if err != nil {
+		httpError(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+
+	resp := lookupToJSON(lr)
+	resp.Name = name
+	resp.Type = typ
+	resp.ClientIP = ip.String()
+	if c, ok := Context.clients.Find(ip.String()); ok {
+		resp.ClientName = c.Name
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
@@ -0,0 +1,52 @@
+package home
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToolsResolve(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+	_, _ = clients.Add(Client{IDs: []string{"aa:aa:aa:aa:aa:aa", "192.168.1.0/24"}, Name: "net"})
+	_, _ = clients.Add(Client{IDs: []string{"aa:aa:aa:aa:aa:ab"}, Name: "mac"})
+
+	ip, err := clients.findIPByName("net")
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.1.0", ip.String())
+	_, err = clients.findIPByName("mac")
+	assert.NotNil(t, err) // no DHCP lease
+	_, err = clients.findIPByName("unknown")
+	assert.NotNil(t, err)
+
+	resp := &dns.Msg{}
+	resp.SetQuestion("example.org.", dns.TypeA)
+	resp.Rcode = dns.RcodeNameError
+	lr := &dnsforward.LookupResult{
+		Response: resp,
+		Result:   dnsfilter.Result{IsFiltered: true, Reason: dnsfilter.FilteredBlackList, Rule: "||example.org^", FilterID: 1},
+		Elapsed:  1500 * time.Microsecond,
+		Stages:   []dnsforward.LookupStage{{Name: "filtering_request", Elapsed: time.Millisecond}},
+	}
+	j := lookupToJSON(lr)
+	assert.False(t, j.Dropped)
+	assert.Equal(t, "NXDOMAIN", j.Status)
+	assert.Equal(t, "FilteredBlackList", j.Filtering.Reason)
+	assert.Equal(t, "||example.org^", j.Filtering.Rule)
+	assert.Equal(t, 1.5, j.Elapsed)
+	assert.Equal(t, 1, len(j.Stages))
+	assert.Equal(t, []string{}, j.Answer)
+
+	a := &dns.A{Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10}, A: net.IPv4(1, 2, 3, 4)}
+	assert.Equal(t, []string{"example.org. 10 IN A 1.2.3.4"}, rrStrings([]dns.RR{a}))
+
+	j = lookupToJSON(&dnsforward.LookupResult{AccessBlocked: true})
+	assert.True(t, j.Dropped)
+}
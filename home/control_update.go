@@ -87,41 +87,50 @@ func handleUpdate(w http.ResponseWriter, _ *http.Request) {
 // Convert version.json data to our JSON response
 func getVersionResp(info update.VersionInfo) []byte {
 	ret := make(map[string]interface{})
-	ret["can_autoupdate"] = false
 	ret["new_version"] = info.NewVersion
 	ret["announcement"] = info.Announcement
 	ret["announcement_url"] = info.AnnouncementURL
 
-	if info.CanAutoUpdate {
-		canUpdate := true
-
-		tlsConf := tlsConfigSettings{}
-		Context.tls.WriteDiskConfig(&tlsConf)
-
-		if runtime.GOOS != "windows" &&
-			((tlsConf.Enabled && (tlsConf.PortHTTPS < 1024 || tlsConf.PortDNSOverTLS < 1024)) ||
-				config.BindPort < 1024 ||
-				config.DNS.Port < 1024) {
-			// On UNIX, if we're running under a regular user,
-			//  but with CAP_NET_BIND_SERVICE set on a binary file,
-			//  and we're listening on ports <1024,
-			//  we won't be able to restart after we replace the binary file,
-			//  because we'll lose CAP_NET_BIND_SERVICE capability.
-			canUpdate, _ = util.HaveAdminRights()
-		}
-		ret["can_autoupdate"] = canUpdate
-	}
+	ret["can_autoupdate"] = canAutoUpdate(info)
 
 	d, _ := json.Marshal(ret)
 	return d
 }
 
+// canAutoUpdate - return TRUE if we're able to update to the new version and restart
+func canAutoUpdate(info update.VersionInfo) bool {
+	if !info.CanAutoUpdate {
+		return false
+	}
+
+	tlsConf := tlsConfigSettings{}
+	Context.tls.WriteDiskConfig(&tlsConf)
+
+	if runtime.GOOS != "windows" &&
+		((tlsConf.Enabled && (tlsConf.PortHTTPS < 1024 || tlsConf.PortDNSOverTLS < 1024)) ||
+			config.BindPort < 1024 ||
+			config.DNS.Port < 1024) {
+		// On UNIX, if we're running under a regular user,
+		//  but with CAP_NET_BIND_SERVICE set on a binary file,
+		//  and we're listening on ports <1024,
+		//  we won't be able to restart after we replace the binary file,
+		//  because we'll lose CAP_NET_BIND_SERVICE capability.
+		canUpdate, _ := util.HaveAdminRights()
+		return canUpdate
+	}
+	return true
+}
+
 // Complete an update procedure
 func finishUpdate() {
 	log.Info("Stopping all tasks")
 	cleanup()
 	cleanupAlways()
+	restart()
+}
 
+// Start the new instance of the application and exit
+func restart() {
 	exeName := "AdGuardHome"
 	if runtime.GOOS == "windows" {
 		exeName = "AdGuardHome.exe"
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 	"github.com/AdguardTeam/AdGuardHome/dnsforward"
@@ -18,6 +20,16 @@ import (
 // Called by other modules when configuration is changed
 func onConfigModified() {
 	_ = config.write()
+	haStateChanged()
+}
+
+// timeLocation - get the time zone for the statistics and the query log
+// "": the local time zone of the server;  "UTC" or IANA name, e.g. "Europe/Berlin"
+func timeLocation(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
 }
 
 // initDNSServer creates an instance of the dnsforward.Server
@@ -25,12 +37,18 @@ func onConfigModified() {
 // so that we had access to the query log and the stats
 func initDNSServer() error {
 	var err error
-	baseDir := Context.getDataDir()
-
+	loc, err := timeLocation(config.DNS.TimeZone)
+	if err != nil {
+		// the configuration is validated:  this shouldn't happen
+		log.Error("time_zone: %s", err)
+		loc = time.Local
+	}
 	statsConf := stats.Config{
-		Filename:          filepath.Join(baseDir, "stats.db"),
+		Filename:          filepath.Join(Context.getStorageDir(config.DNS.StatsDir), "stats.db"),
 		LimitDays:         config.DNS.StatsInterval,
 		AnonymizeClientIP: config.DNS.AnonymizeClientIP,
+		Location:          loc,
+		MaintenanceWindow: inMaintenanceWindow,
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
 	}
@@ -41,10 +59,13 @@ func initDNSServer() error {
 	conf := querylog.Config{
 		Enabled:           config.DNS.QueryLogEnabled,
 		FileEnabled:       config.DNS.QueryLogFileEnabled,
-		BaseDir:           baseDir,
+		BaseDir:           Context.getStorageDir(config.DNS.QueryLogDir),
 		Interval:          config.DNS.QueryLogInterval,
+		FlushInterval:     time.Duration(config.DNS.QueryLogFlushInterval) * time.Minute,
 		MemSize:           config.DNS.QueryLogMemSize,
 		AnonymizeClientIP: config.DNS.AnonymizeClientIP,
+		Location:          loc,
+		MaintenanceWindow: inMaintenanceWindow,
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
 	}
@@ -52,10 +73,11 @@ func initDNSServer() error {
 
 	filterConf := config.DNS.DnsfilterConf
 	bindhost := config.DNS.BindHost
-	if config.DNS.BindHost == "0.0.0.0" {
-		bindhost = "127.0.0.1"
+	if util.IsUnspecifiedHost(config.DNS.BindHost) {
+		bindhost = util.LocalhostIP()
 	}
-	filterConf.ResolverAddress = fmt.Sprintf("%s:%d", bindhost, config.DNS.Port)
+	filterConf.ResolverAddress = net.JoinHostPort(bindhost, strconv.Itoa(config.DNS.Port))
+	filterConf.SecurityBootstrap = config.DNS.BootstrapDNS
 	filterConf.AutoHosts = &Context.autoHosts
 	filterConf.ConfigModified = onConfigModified
 	filterConf.HTTPRegister = httpRegister
@@ -135,6 +157,12 @@ func isPublicIP(ip net.IP) bool {
 		if ip.IsLoopback() || ip.IsLinkLocalMulticast() || ip.IsLinkLocalUnicast() {
 			return false
 		}
+		if ip[0]&0xfe == 0xfc { // unique local address
+			return false
+		}
+		if ip[0] == 0x20 && ip[1] == 0x01 && ip[2] == 0x0d && ip[3] == 0xb8 { // documentation
+			return false
+		}
 	}
 
 	return true
@@ -147,6 +175,8 @@ func onDNSRequest(d *proxy.DNSContext) {
 		return
 	}
 
+	Context.mqtt.onRequest(ip)
+
 	ipAddr := net.ParseIP(ip)
 	if !ipAddr.IsLoopback() {
 		Context.rdns.Begin(ip)
@@ -164,6 +194,9 @@ func generateServerConfig() dnsforward.ServerConfig {
 		ConfigModified:  onConfigModified,
 		HTTPRegister:    httpRegister,
 		OnDNSRequest:    onDNSRequest,
+		OnDNSResponse:   onDNSResponse,
+		Tracer:          Context.tracer,
+		UpstreamRules:   userUpstreamRules(),
 	}
 
 	tlsConf := tlsConfigSettings{}
@@ -177,10 +210,24 @@ func generateServerConfig() dnsforward.ServerConfig {
 			}
 		}
 	}
+	if hasDNSListeners() {
+		newconfig.Listeners = dnsListeners(tlsConf)
+		if len(newconfig.Listeners) != 0 {
+			newconfig.UDPListenAddr = nil
+			newconfig.TCPListenAddr = nil
+			newconfig.TLSListenAddr = nil
+		} else {
+			log.Error("DNS: none of the listeners is available, listening on %s", config.DNS.BindHost)
+		}
+	}
 	newconfig.TLSv12Roots = Context.tlsRoots
 	newconfig.TLSCiphers = Context.tlsCiphers
 	newconfig.TLSAllowUnencryptedDOH = tlsConf.AllowUnencryptedDOH
 
+	if config.LowResourceMode {
+		newconfig.MaxGoroutines = lowResourceMaxGoroutines
+	}
+
 	newconfig.FilterHandler = applyAdditionalFiltering
 	newconfig.GetCustomUpstreamByClient = Context.clients.FindUpstreams
 	return newconfig
@@ -190,7 +237,24 @@ func generateServerConfig() dnsforward.ServerConfig {
 func getDNSAddresses() []string {
 	dnsAddresses := []string{}
 
-	if config.DNS.BindHost == "0.0.0.0" {
+	if hasDNSListeners() {
+		for _, l := range config.Listeners {
+			if !l.DNS {
+				continue
+			}
+			ips, err := listenerIPs(l)
+			if err != nil {
+				continue
+			}
+			for _, ip := range ips {
+				addr := ip.String()
+				if l.dnsPort() != 53 {
+					addr = net.JoinHostPort(addr, strconv.Itoa(l.dnsPort()))
+				}
+				dnsAddresses = append(dnsAddresses, addr)
+			}
+		}
+	} else if util.IsUnspecifiedHost(config.DNS.BindHost) {
 		ifaces, e := util.GetValidNetInterfacesForWeb()
 		if e != nil {
 			log.Error("Couldn't get network interfaces: %v", e)
@@ -250,6 +314,11 @@ func applyAdditionalFiltering(clientAddr string, setts *dnsfilter.RequestFilteri
 
 	setts.ClientName = c.Name
 	setts.ClientTags = c.Tags
+	setts.EncryptedDNSCheck = !c.EncryptedDNSExempt
+	setts.InternetOff = Context.clients.isInternetOff(c.Name, c.Tags)
+	if len(c.FilterIDs) != 0 {
+		setts.FilterProfile = filterProfileName(c.FilterIDs)
+	}
 
 	if !c.UseOwnSettings {
 		return
@@ -276,6 +345,7 @@ func startDNSServer() error {
 	}
 
 	Context.dnsFilter.Start()
+	encDNSFeeds.start()
 	Context.filters.Start()
 	Context.stats.Start()
 	Context.queryLog.Start()
@@ -305,6 +375,14 @@ func reconfigureDNSServer() error {
 	return nil
 }
 
+// drainDNSServer - stop processing new DNS requests and wait until the requests being processed are completed
+func drainDNSServer() {
+	if !isRunning() || config.DNS.ShutdownTimeout == 0 {
+		return
+	}
+	Context.dnsServer.Drain(time.Duration(config.DNS.ShutdownTimeout) * time.Second)
+}
+
 func stopDNSServer() error {
 	if !isRunning() {
 		return nil
@@ -0,0 +1,259 @@
+package home
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// How often the lists of DoH/DoT servers are downloaded
+const encryptedDNSFeedsInterval = 24 * time.Hour
+
+// The maximum size of a list
+const encryptedDNSFeedMaxSize = 1024 * 1024
+
+// encryptedDNSFeeds - downloads the lists of DoH/DoT servers
+type encryptedDNSFeeds struct {
+	once   sync.Once
+	update chan struct{} // request to download the lists now
+
+	lock    sync.Mutex
+	updated time.Time // the last time the lists were downloaded
+	err     string    // the last error
+}
+
+var encDNSFeeds = encryptedDNSFeeds{
+	update: make(chan struct{}, 1),
+}
+
+// start - start the periodic updates (only once)
+func (f *encryptedDNSFeeds) start() {
+	f.once.Do(func() {
+		go f.loop()
+	})
+}
+
+// refreshNow - download the lists as soon as possible
+func (f *encryptedDNSFeeds) refreshNow() {
+	select {
+	case f.update <- struct{}{}:
+	default:
+	}
+}
+
+func (f *encryptedDNSFeeds) loop() {
+	for {
+		f.refresh()
+		select {
+		case <-f.update:
+		case <-time.After(encryptedDNSFeedsInterval):
+		}
+	}
+}
+
+// refresh - download all lists and pass the entries to dnsfilter
+// The entries of the list that can't be downloaded are lost until the next update.
+func (f *encryptedDNSFeeds) refresh() {
+	if Context.dnsFilter == nil {
+		return
+	}
+	c := dnsfilter.Config{}
+	Context.dnsFilter.WriteDiskConfig(&c)
+
+	entries := []string{}
+	errs := []string{}
+	for _, u := range c.EncryptedDNSFeeds {
+		list, err := downloadEncryptedDNSFeed(u)
+		if err != nil {
+			log.Error("Encrypted DNS: %s: %s", u, err)
+			errs = append(errs, fmt.Sprintf("%s: %s", u, err))
+			continue
+		}
+		log.Debug("Encrypted DNS: %s: %d entries", u, len(list))
+		entries = append(entries, list...)
+	}
+	Context.dnsFilter.SetEncryptedDNSFeedEntries(entries)
+
+	f.lock.Lock()
+	if len(c.EncryptedDNSFeeds) != 0 {
+		f.updated = time.Now()
+	}
+	f.err = strings.Join(errs, "; ")
+	f.lock.Unlock()
+}
+
+func downloadEncryptedDNSFeed(u string) ([]string, error) {
+	resp, err := Context.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, encryptedDNSFeedMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseEncryptedDNSFeed(data), nil
+}
+
+// parseEncryptedDNSFeed - get the host names and IP addresses from the list
+// Each line is a host name or IP address, or "IP host" hosts file entry.
+// '#' starts a comment, the lines starting with '!' are comments too.
+func parseEncryptedDNSFeed(data []byte) []string {
+	entries := []string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '!' {
+			continue
+		}
+		fields := strings.Fields(line)
+		entry := fields[0]
+		if len(fields) == 2 && net.ParseIP(fields[0]) != nil {
+			// hosts file: the address is usually 0.0.0.0, the host name is the entry
+			entry = fields[1]
+		} else if len(fields) != 1 {
+			continue
+		}
+		if validEncryptedDNSEntry(entry) {
+			entries = append(entries, strings.ToLower(entry))
+		}
+	}
+	return entries
+}
+
+// validEncryptedDNSEntry - return TRUE if the string is an IP address or a fully qualified host name
+func validEncryptedDNSEntry(s string) bool {
+	ip := net.ParseIP(s)
+	if ip != nil {
+		return !ip.IsUnspecified()
+	}
+	s = strings.TrimSuffix(s, ".")
+	if len(s) == 0 || len(s) > 253 || strings.IndexByte(s, '.') < 0 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type encryptedDNSConfigJSON struct {
+	Mode  string   `json:"mode"`
+	Hosts []string `json:"hosts"`
+	Feeds []string `json:"feeds"`
+}
+
+type encryptedDNSStatusJSON struct {
+	encryptedDNSConfigJSON
+	ListSize     int                               `json:"list_size"`
+	FeedsUpdated string                            `json:"feeds_updated,omitempty"`
+	FeedsError   string                            `json:"feeds_error,omitempty"`
+	Detections   []dnsfilter.EncryptedDNSDetection `json:"detections"`
+}
+
+func handleEncryptedDNSStatus(w http.ResponseWriter, r *http.Request) {
+	if Context.dnsFilter == nil {
+		httpError(w, http.StatusBadRequest, "DNS filtering module isn't initialized")
+		return
+	}
+	c := dnsfilter.Config{}
+	Context.dnsFilter.WriteDiskConfig(&c)
+
+	resp := encryptedDNSStatusJSON{
+		encryptedDNSConfigJSON: encryptedDNSConfigJSON{
+			Mode:  c.EncryptedDNSMode,
+			Hosts: c.EncryptedDNSHosts,
+			Feeds: c.EncryptedDNSFeeds,
+		},
+		ListSize:   Context.dnsFilter.EncryptedDNSListSize(),
+		Detections: Context.dnsFilter.EncryptedDNSDetections(),
+	}
+	if len(resp.Mode) == 0 {
+		resp.Mode = dnsfilter.EncryptedDNSOff
+	}
+
+	encDNSFeeds.lock.Lock()
+	if !encDNSFeeds.updated.IsZero() {
+		resp.FeedsUpdated = encDNSFeeds.updated.Format(time.RFC3339)
+	}
+	resp.FeedsError = encDNSFeeds.err
+	encDNSFeeds.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+func handleEncryptedDNSConfig(w http.ResponseWriter, r *http.Request) {
+	if Context.dnsFilter == nil {
+		httpError(w, http.StatusBadRequest, "DNS filtering module isn't initialized")
+		return
+	}
+	req := encryptedDNSConfigJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	switch req.Mode {
+	case dnsfilter.EncryptedDNSOff, dnsfilter.EncryptedDNSDetect, dnsfilter.EncryptedDNSBlock:
+	default:
+		httpError(w, http.StatusBadRequest, "invalid mode: %q", req.Mode)
+		return
+	}
+	for _, h := range req.Hosts {
+		if !validEncryptedDNSEntry(h) {
+			httpError(w, http.StatusBadRequest, "invalid host name or IP address: %q", h)
+			return
+		}
+	}
+	for _, u := range req.Feeds {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			httpError(w, http.StatusBadRequest, "invalid URL: %q", u)
+			return
+		}
+	}
+
+	Context.dnsFilter.SetEncryptedDNSConfig(req.Mode, req.Hosts, req.Feeds)
+	encDNSFeeds.refreshNow()
+	onConfigModified()
+	returnOK(w)
+}
+
+func handleEncryptedDNSClear(w http.ResponseWriter, r *http.Request) {
+	if Context.dnsFilter == nil {
+		httpError(w, http.StatusBadRequest, "DNS filtering module isn't initialized")
+		return
+	}
+	Context.dnsFilter.ClearEncryptedDNSDetections()
+	returnOK(w)
+}
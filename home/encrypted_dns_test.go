@@ -0,0 +1,30 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEncryptedDNSFeed(t *testing.T) {
+	data := []byte(`# DoH servers
+! comment
+dns.example.org
+DoH.Example.NET # inline comment
+192.0.2.1
+2001:db8::53
+0.0.0.0 doh.hosts.example
+0.0.0.0 # empty
+invalid host name
+localhost
+https://dns.example.com/dns-query
+`)
+	entries := parseEncryptedDNSFeed(data)
+	assert.Equal(t, []string{
+		"dns.example.org",
+		"doh.example.net",
+		"192.0.2.1",
+		"2001:db8::53",
+		"doh.hosts.example",
+	}, entries)
+}
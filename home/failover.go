@@ -0,0 +1,667 @@
+package home
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Failover modes
+const (
+	failoverModeBuiltin    = "builtin"    // the instances elect the master themselves and manage the virtual IP
+	failoverModeKeepalived = "keepalived" // the state is set by keepalived notify scripts
+)
+
+// Failover states
+const (
+	failoverStateMaster = "master"
+	failoverStateBackup = "backup"
+)
+
+const (
+	failoverSignatureHeader = "X-AdGuard-Failover-Signature"
+	failoverMaxClockSkew    = 30 * time.Second
+	failoverMaxMessageSize  = 16 * 1024 * 1024
+)
+
+// failoverConfig - settings of hot-standby high availability
+type failoverConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Mode     string `yaml:"mode"`     // "builtin" or "keepalived"
+	Priority int    `yaml:"priority"` // the instance with the higher priority becomes master (1..254)
+	Peer     string `yaml:"peer"`     // URL of the web interface of the other instance, e.g. "http://192.168.1.3:3000"
+	Secret   string `yaml:"secret"`   // shared secret to sign the heartbeat messages
+
+	// The virtual IP address (CIDR) that is assigned to the interface of the master instance
+	Interface string `yaml:"interface"`
+	VirtualIP string `yaml:"virtual_ip"`
+
+	Interval     uint32 `yaml:"interval"`      // heartbeat interval (in seconds)
+	DeadInterval uint32 `yaml:"dead_interval"` // the peer is considered dead after this time without heartbeats (in seconds)
+
+	// Shell commands that are executed on state change
+	OnMaster string `yaml:"on_master"`
+	OnBackup string `yaml:"on_backup"`
+}
+
+// failoverMessage - heartbeat message
+// The master instance attaches the replicated data when the peer has an old version of it.
+type failoverMessage struct {
+	ID          string        `json:"id"` // random instance ID
+	Priority    int           `json:"priority"`
+	State       string        `json:"state"`
+	Time        int64         `json:"time"`         // UNIX time
+	DataVersion uint64        `json:"data_version"` // master: current version of the data; backup: the applied version
+	Data        *failoverData `json:"data,omitempty"`
+}
+
+// failoverData - runtime data replicated from the master instance
+type failoverData struct {
+	Leases  []dhcpd.Lease    `json:"leases"`
+	Clients []failoverClient `json:"clients"`
+}
+
+// failoverClient - runtime client (from rDNS or WHOIS)
+type failoverClient struct {
+	IP        string       `json:"ip"`
+	Host      string       `json:"host"`
+	Source    clientSource `json:"source"`
+	WhoisInfo [][]string   `json:"whois_info,omitempty"`
+}
+
+// failoverModule - switches DNS and DHCP services between 2 instances
+type failoverModule struct {
+	conf   failoverConfig
+	id     string
+	client *http.Client
+
+	lock        sync.Mutex
+	state       string
+	changed     time.Time // the time of the last state change
+	peerID      string
+	peerState   string
+	peerPrio    int
+	peerSeen    time.Time // the time of the last heartbeat from the peer
+	peerVersion uint64    // the version of the data the peer has
+	applied     uint64    // the version of the data received from the master
+	err         string
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// validateFailoverConfig - check failover settings
+func validateFailoverConfig(conf failoverConfig) error {
+	if !conf.Enabled {
+		return nil
+	}
+
+	switch conf.Mode {
+	case "", failoverModeBuiltin, failoverModeKeepalived:
+	default:
+		return fmt.Errorf("invalid mode: %q", conf.Mode)
+	}
+
+	if conf.Priority < 1 || conf.Priority > 254 {
+		return fmt.Errorf("invalid priority: %d", conf.Priority)
+	}
+
+	u, err := url.Parse(conf.Peer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid peer URL: %q", conf.Peer)
+	}
+
+	if len(conf.Secret) < 8 {
+		return fmt.Errorf("secret must be at least 8 characters long")
+	}
+
+	if conf.Interval == 0 || conf.DeadInterval <= conf.Interval {
+		return fmt.Errorf("dead_interval must be greater than interval")
+	}
+
+	if len(conf.VirtualIP) != 0 {
+		if !failoverVIPSupported {
+			return fmt.Errorf("virtual IP isn't supported on this OS")
+		}
+		_, _, err = net.ParseCIDR(conf.VirtualIP)
+		if err != nil {
+			return fmt.Errorf("invalid virtual IP: %s", err)
+		}
+		if !ifaceNameRe.MatchString(conf.Interface) {
+			return fmt.Errorf("invalid interface name: %q", conf.Interface)
+		}
+	}
+
+	return nil
+}
+
+// newFailover - create failover module
+// Return nil if it's disabled or the settings are invalid.
+func newFailover(conf failoverConfig) *failoverModule {
+	if !conf.Enabled {
+		return nil
+	}
+	err := validateFailoverConfig(conf)
+	if err != nil {
+		log.Error("Failover: %s", err)
+		return nil
+	}
+	if len(conf.Mode) == 0 {
+		conf.Mode = failoverModeBuiltin
+	}
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	return &failoverModule{
+		conf:   conf,
+		id:     hex.EncodeToString(id),
+		client: &http.Client{Timeout: time.Duration(conf.Interval) * time.Second},
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start - start as backup and begin exchanging heartbeats with the peer
+func (f *failoverModule) Start() {
+	if f == nil {
+		return
+	}
+	log.Info("Failover: starting in %s mode with priority %d, peer: %s", f.conf.Mode, f.conf.Priority, f.conf.Peer)
+
+	f.lock.Lock()
+	f.state = failoverStateBackup
+	f.changed = time.Now()
+	f.lock.Unlock()
+	if f.conf.Mode == failoverModeBuiltin && len(f.conf.VirtualIP) != 0 {
+		// the address may be left from the previous run
+		_ = failoverRemoveVIP(f.conf.Interface, f.conf.VirtualIP)
+	}
+
+	f.wg.Add(1)
+	go f.loop()
+}
+
+// Close - stop and let the peer take over
+func (f *failoverModule) Close() {
+	if f == nil {
+		return
+	}
+	close(f.stop)
+	f.wg.Wait()
+
+	f.lock.Lock()
+	master := f.state == failoverStateMaster
+	f.lock.Unlock()
+	if !master {
+		return
+	}
+
+	f.setState(failoverStateBackup)
+	// announce the lowest priority so that the peer becomes master right away
+	msg := f.message(false)
+	msg.Priority = 0
+	_, err := f.send(msg)
+	if err != nil {
+		log.Debug("Failover: %s", err)
+	}
+}
+
+func (f *failoverModule) loop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(time.Duration(f.conf.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		f.heartbeat()
+		if f.conf.Mode == failoverModeBuiltin {
+			f.setState(f.elect(time.Now()))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// heartbeat - send our state to the peer and process its response
+func (f *failoverModule) heartbeat() {
+	f.lock.Lock()
+	withData := f.state == failoverStateMaster
+	f.lock.Unlock()
+
+	msg := f.message(withData)
+	if msg.Data != nil && msg.DataVersion == f.getPeerVersion() {
+		msg.Data = nil
+	}
+
+	resp, err := f.send(msg)
+	f.lock.Lock()
+	if err != nil {
+		f.err = err.Error()
+	} else {
+		f.err = ""
+	}
+	f.lock.Unlock()
+	if err != nil {
+		log.Debug("Failover: %s", err)
+		return
+	}
+	f.onPeerMessage(resp)
+}
+
+func (f *failoverModule) getPeerVersion() uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.peerVersion
+}
+
+// message - build heartbeat message
+func (f *failoverModule) message(withData bool) failoverMessage {
+	f.lock.Lock()
+	msg := failoverMessage{
+		ID:          f.id,
+		Priority:    f.conf.Priority,
+		State:       f.state,
+		Time:        time.Now().Unix(),
+		DataVersion: f.applied,
+	}
+	f.lock.Unlock()
+
+	if withData {
+		d := failoverGetData()
+		msg.DataVersion = d.version()
+		msg.Data = &d
+	}
+	return msg
+}
+
+// send - send the message to the peer and return its response
+func (f *failoverModule) send(msg failoverMessage) (failoverMessage, error) {
+	resp := failoverMessage{}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return resp, err
+	}
+
+	u := strings.TrimSuffix(f.conf.Peer, "/") + "/control/failover/heartbeat"
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return resp, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(failoverSignatureHeader, failoverSign(f.conf.Secret, body))
+
+	r, err := f.client.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(r.Body, 64*1024))
+		return resp, fmt.Errorf("%s: status code %d", u, r.StatusCode)
+	}
+
+	body, err = ioutil.ReadAll(io.LimitReader(r.Body, failoverMaxMessageSize))
+	if err != nil {
+		return resp, err
+	}
+	resp, err = failoverVerify(f.conf.Secret, body, r.Header.Get(failoverSignatureHeader), time.Now())
+	if err != nil {
+		return resp, fmt.Errorf("%s: %s", u, err)
+	}
+	return resp, nil
+}
+
+// onPeerMessage - update the peer's state and apply the replicated data
+func (f *failoverModule) onPeerMessage(msg failoverMessage) {
+	f.lock.Lock()
+	f.peerID = msg.ID
+	f.peerState = msg.State
+	f.peerPrio = msg.Priority
+	f.peerSeen = time.Now()
+	f.peerVersion = msg.DataVersion
+	apply := msg.Data != nil && msg.State == failoverStateMaster &&
+		f.state != failoverStateMaster && msg.DataVersion != f.applied
+	f.lock.Unlock()
+
+	if !apply {
+		return
+	}
+	failoverApplyData(*msg.Data)
+	f.lock.Lock()
+	f.applied = msg.DataVersion
+	f.lock.Unlock()
+	log.Debug("Failover: applied data version %x: %d leases, %d clients",
+		msg.DataVersion, len(msg.Data.Leases), len(msg.Data.Clients))
+}
+
+// elect - get the state this instance must be in
+func (f *failoverModule) elect(now time.Time) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	alive := !f.peerSeen.IsZero() && now.Sub(f.peerSeen) < time.Duration(f.conf.DeadInterval)*time.Second
+	return failoverElect(f.conf.Priority, f.id, alive, f.peerPrio, f.peerID)
+}
+
+// failoverElect - the instance with the higher priority (or ID, if the priorities are equal) is master
+func failoverElect(prio int, id string, peerAlive bool, peerPrio int, peerID string) string {
+	if !peerAlive {
+		return failoverStateMaster
+	}
+	if prio > peerPrio || (prio == peerPrio && id > peerID) {
+		return failoverStateMaster
+	}
+	return failoverStateBackup
+}
+
+// setState - switch the services to the new state
+func (f *failoverModule) setState(state string) {
+	f.lock.Lock()
+	if f.state == state {
+		f.lock.Unlock()
+		return
+	}
+	f.state = state
+	f.changed = time.Now()
+	f.lock.Unlock()
+
+	log.Info("Failover: switching to %s state", state)
+	builtin := f.conf.Mode == failoverModeBuiltin
+	master := state == failoverStateMaster
+
+	if builtin && len(f.conf.VirtualIP) != 0 {
+		var err error
+		if master {
+			err = failoverAddVIP(f.conf.Interface, f.conf.VirtualIP)
+		} else {
+			err = failoverRemoveVIP(f.conf.Interface, f.conf.VirtualIP)
+		}
+		if err != nil {
+			log.Error("Failover: %s", err)
+		}
+	}
+
+	var err error
+	if master {
+		err = startDHCPServer()
+	} else {
+		err = stopDHCPServer()
+	}
+	if err != nil {
+		log.Error("Failover: %s", err)
+	}
+
+	if builtin {
+		cmd := f.conf.OnBackup
+		if master {
+			cmd = f.conf.OnMaster
+		}
+		failoverRunCommand(cmd)
+	}
+}
+
+// failoverRunCommand - execute the state change hook
+func failoverRunCommand(cmd string) {
+	if len(cmd) == 0 {
+		return
+	}
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", cmd)
+	} else {
+		c = exec.Command("sh", "-c", cmd)
+	}
+	out, err := c.CombinedOutput()
+	if err != nil {
+		log.Error("Failover: %q: %s: %s", cmd, err, strings.TrimSpace(string(out)))
+	}
+}
+
+// failoverSign - get HMAC-SHA256 signature of the message
+func failoverSign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// failoverVerify - check the signature and the time of the message and decode it
+func failoverVerify(secret string, body []byte, sig string, now time.Time) (failoverMessage, error) {
+	msg := failoverMessage{}
+	if !hmac.Equal([]byte(sig), []byte(failoverSign(secret, body))) {
+		return msg, fmt.Errorf("invalid signature")
+	}
+	err := json.Unmarshal(body, &msg)
+	if err != nil {
+		return msg, err
+	}
+	t := time.Unix(msg.Time, 0)
+	if t.Before(now.Add(-failoverMaxClockSkew)) || t.After(now.Add(failoverMaxClockSkew)) {
+		return msg, fmt.Errorf("message time is out of range: %s", t)
+	}
+	return msg, nil
+}
+
+// failoverGetData - get the data to replicate
+func failoverGetData() failoverData {
+	d := failoverData{
+		Leases:  []dhcpd.Lease{},
+		Clients: []failoverClient{},
+	}
+	if Context.dhcpServer != nil {
+		d.Leases = Context.dhcpServer.Leases(dhcpd.LeasesAll)
+		sort.Slice(d.Leases, func(i, j int) bool {
+			return bytes.Compare(d.Leases[i].IP.To16(), d.Leases[j].IP.To16()) < 0
+		})
+	}
+
+	Context.clients.lock.Lock()
+	for ip, ch := range Context.clients.ipHost {
+		if ch.Source != ClientSourceWHOIS && ch.Source != ClientSourceRDNS {
+			continue
+		}
+		d.Clients = append(d.Clients, failoverClient{
+			IP:        ip,
+			Host:      ch.Host,
+			Source:    ch.Source,
+			WhoisInfo: ch.WhoisInfo,
+		})
+	}
+	Context.clients.lock.Unlock()
+	sort.Slice(d.Clients, func(i, j int) bool {
+		return d.Clients[i].IP < d.Clients[j].IP
+	})
+	return d
+}
+
+// version - get the hash of the data
+func (d failoverData) version() uint64 {
+	data, _ := json.Marshal(d)
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return h.Sum64()
+}
+
+// failoverApplyData - replace the local data with the data received from the master instance
+func failoverApplyData(d failoverData) {
+	if Context.dhcpServer != nil {
+		Context.dhcpServer.ReplaceLeases(d.Leases)
+	}
+
+	clients := &Context.clients
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+	for _, c := range d.Clients {
+		if c.Source != ClientSourceWHOIS && c.Source != ClientSourceRDNS {
+			continue
+		}
+		ch, ok := clients.ipHost[c.IP]
+		if ok && ch.Source > c.Source {
+			continue
+		}
+		clients.ipHost[c.IP] = &ClientHost{
+			Host:      c.Host,
+			Source:    c.Source,
+			WhoisInfo: c.WhoisInfo,
+		}
+	}
+}
+
+// POST /control/failover/heartbeat
+// The request is authenticated by its signature.
+func handleFailoverHeartbeat(w http.ResponseWriter, r *http.Request) {
+	f := Context.failover
+	if f == nil {
+		http.Error(w, "Failover is disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "This request must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, failoverMaxMessageSize))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failover: %s", err)
+		return
+	}
+	msg, err := failoverVerify(f.conf.Secret, body, r.Header.Get(failoverSignatureHeader), time.Now())
+	if err != nil {
+		httpError(w, http.StatusForbidden, "failover: %s", err)
+		return
+	}
+	f.onPeerMessage(msg)
+
+	resp, err := json.Marshal(f.message(false))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(failoverSignatureHeader, failoverSign(f.conf.Secret, resp))
+	_, _ = w.Write(resp)
+}
+
+// GET /control/failover/health
+// Health check for keepalived or a load balancer: 200 if DNS server is running
+func handleFailoverHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "This request must be GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isRunning() {
+		http.Error(w, "DNS server isn't running", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = io.WriteString(w, "OK\n")
+}
+
+type failoverStatusJSON struct {
+	Enabled         bool   `json:"enabled"`
+	Mode            string `json:"mode,omitempty"`
+	State           string `json:"state,omitempty"`
+	Priority        int    `json:"priority,omitempty"`
+	Since           string `json:"since,omitempty"`
+	VirtualIP       string `json:"virtual_ip,omitempty"`
+	DataVersion     string `json:"data_version,omitempty"`
+	PeerURL         string `json:"peer_url,omitempty"`
+	PeerAlive       bool   `json:"peer_alive"`
+	PeerState       string `json:"peer_state,omitempty"`
+	PeerPriority    int    `json:"peer_priority,omitempty"`
+	PeerLastSeen    string `json:"peer_last_seen,omitempty"`
+	PeerDataVersion string `json:"peer_data_version,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+func handleFailoverStatus(w http.ResponseWriter, r *http.Request) {
+	resp := failoverStatusJSON{}
+	f := Context.failover
+	if f != nil {
+		f.lock.Lock()
+		resp = failoverStatusJSON{
+			Enabled:      true,
+			Mode:         f.conf.Mode,
+			State:        f.state,
+			Priority:     f.conf.Priority,
+			Since:        f.changed.Format(time.RFC3339),
+			VirtualIP:    f.conf.VirtualIP,
+			PeerURL:      f.conf.Peer,
+			PeerAlive:    !f.peerSeen.IsZero() && time.Since(f.peerSeen) < time.Duration(f.conf.DeadInterval)*time.Second,
+			PeerState:    f.peerState,
+			PeerPriority: f.peerPrio,
+			Error:        f.err,
+		}
+		if !f.peerSeen.IsZero() {
+			resp.PeerLastSeen = f.peerSeen.Format(time.RFC3339)
+			resp.PeerDataVersion = fmt.Sprintf("%016x", f.peerVersion)
+		}
+		master := f.state == failoverStateMaster
+		version := f.applied
+		f.lock.Unlock()
+
+		if master {
+			d := failoverGetData()
+			version = d.version()
+		}
+		resp.DataVersion = fmt.Sprintf("%016x", version)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+type failoverStateJSON struct {
+	State string `json:"state"`
+}
+
+// POST /control/failover/state
+// Used by keepalived notify scripts
+func handleFailoverState(w http.ResponseWriter, r *http.Request) {
+	f := Context.failover
+	if f == nil || f.conf.Mode != failoverModeKeepalived {
+		httpError(w, http.StatusBadRequest, "failover isn't enabled in keepalived mode")
+		return
+	}
+
+	req := failoverStateJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+	state := strings.ToLower(req.State)
+	switch state {
+	case failoverStateMaster, failoverStateBackup:
+	case "fault":
+		state = failoverStateBackup
+	default:
+		httpError(w, http.StatusBadRequest, "invalid state: %q", req.State)
+		return
+	}
+
+	f.setState(state)
+	returnOK(w)
+}
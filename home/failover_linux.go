@@ -0,0 +1,55 @@
+// +build linux
+
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const failoverVIPSupported = true
+
+// ipCommand - run ip command
+func ipCommand(args ...string) error {
+	cmd := exec.Command("ip", args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("ip %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// failoverAddVIP - assign the virtual IP address to the interface and announce it with gratuitous ARP
+func failoverAddVIP(iface, vip string) error {
+	err := ipCommand("addr", "add", vip, "dev", iface)
+	if err != nil && !strings.Contains(err.Error(), "File exists") {
+		return err
+	}
+
+	addr, _, _ := net.ParseCIDR(vip)
+	if addr.To4() == nil {
+		return nil
+	}
+	// update ARP caches of the clients, arping may be not installed
+	out, err := exec.Command("arping", "-U", "-c", "3", "-I", iface, addr.String()).CombinedOutput()
+	if err != nil {
+		log.Debug("Failover: arping: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// failoverRemoveVIP - remove the virtual IP address from the interface
+func failoverRemoveVIP(iface, vip string) error {
+	err := ipCommand("addr", "del", vip, "dev", iface)
+	if err != nil && strings.Contains(err.Error(), "Cannot assign requested address") {
+		return nil
+	}
+	return err
+}
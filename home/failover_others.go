@@ -0,0 +1,15 @@
+// +build !linux
+
+package home
+
+import "fmt"
+
+const failoverVIPSupported = false
+
+func failoverAddVIP(iface, vip string) error {
+	return fmt.Errorf("virtual IP isn't supported on this OS")
+}
+
+func failoverRemoveVIP(iface, vip string) error {
+	return fmt.Errorf("virtual IP isn't supported on this OS")
+}
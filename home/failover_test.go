@@ -0,0 +1,124 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverElect(t *testing.T) {
+	assert.Equal(t, failoverStateMaster, failoverElect(100, "a", false, 200, "b"))
+	assert.Equal(t, failoverStateMaster, failoverElect(200, "a", true, 100, "b"))
+	assert.Equal(t, failoverStateBackup, failoverElect(100, "a", true, 200, "b"))
+	// equal priorities
+	assert.Equal(t, failoverStateMaster, failoverElect(100, "b", true, 100, "a"))
+	assert.Equal(t, failoverStateBackup, failoverElect(100, "a", true, 100, "b"))
+	// the peer is shutting down
+	assert.Equal(t, failoverStateMaster, failoverElect(1, "a", true, 0, "b"))
+}
+
+func TestFailoverVerify(t *testing.T) {
+	now := time.Now()
+	body, _ := json.Marshal(failoverMessage{ID: "a", Priority: 100, State: failoverStateMaster, Time: now.Unix()})
+	sig := failoverSign("secret12", body)
+
+	msg, err := failoverVerify("secret12", body, sig, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", msg.ID)
+	assert.Equal(t, failoverStateMaster, msg.State)
+
+	_, err = failoverVerify("secret34", body, sig, now)
+	assert.NotNil(t, err)
+	_, err = failoverVerify("secret12", body, "", now)
+	assert.NotNil(t, err)
+
+	// replayed message
+	_, err = failoverVerify("secret12", body, sig, now.Add(time.Minute))
+	assert.NotNil(t, err)
+}
+
+func TestValidateFailoverConfig(t *testing.T) {
+	conf := failoverConfig{
+		Enabled:      true,
+		Priority:     100,
+		Peer:         "http://192.168.1.3:3000",
+		Secret:       "secret12",
+		Interval:     1,
+		DeadInterval: 3,
+	}
+	assert.Nil(t, validateFailoverConfig(conf))
+
+	c := conf
+	c.Mode = "vrrp"
+	assert.NotNil(t, validateFailoverConfig(c))
+	c = conf
+	c.Priority = 255
+	assert.NotNil(t, validateFailoverConfig(c))
+	c = conf
+	c.Peer = "192.168.1.3:3000"
+	assert.NotNil(t, validateFailoverConfig(c))
+	c = conf
+	c.Secret = "secret"
+	assert.NotNil(t, validateFailoverConfig(c))
+	c = conf
+	c.DeadInterval = 1
+	assert.NotNil(t, validateFailoverConfig(c))
+	c = conf
+	c.VirtualIP = "192.168.1.2"
+	c.Interface = "eth0"
+	assert.NotNil(t, validateFailoverConfig(c))
+	c.VirtualIP = "192.168.1.2/24"
+	c.Interface = "eth0; reboot"
+	assert.NotNil(t, validateFailoverConfig(c))
+}
+
+func TestFailoverHeartbeat(t *testing.T) {
+	conf := failoverConfig{
+		Enabled:      true,
+		Priority:     200,
+		Peer:         "http://127.0.0.1",
+		Secret:       "secret12",
+		Interval:     1,
+		DeadInterval: 3,
+	}
+	master := newFailover(conf)
+	conf.Priority = 100
+	backup := newFailover(conf)
+	master.state = failoverStateMaster
+	backup.state = failoverStateBackup
+
+	if Context.clients.ipHost == nil {
+		Context.clients.ipHost = map[string]*ClientHost{}
+		defer func() { Context.clients.ipHost = nil }()
+	}
+	Context.failover = backup
+	defer func() { Context.failover = nil }()
+
+	srv := httptest.NewServer(http.HandlerFunc(handleFailoverHeartbeat))
+	defer srv.Close()
+	master.conf.Peer = srv.URL
+
+	// the data is sent because the backup doesn't have it
+	master.heartbeat()
+	assert.Equal(t, "", master.err)
+	version := failoverGetData().version()
+	assert.Equal(t, version, backup.applied)
+	assert.Equal(t, version, master.peerVersion)
+	assert.Equal(t, failoverStateMaster, backup.peerState)
+	assert.Equal(t, failoverStateBackup, master.peerState)
+
+	now := time.Now()
+	assert.Equal(t, failoverStateMaster, master.elect(now))
+	assert.Equal(t, failoverStateBackup, backup.elect(now))
+	// the master is dead
+	assert.Equal(t, failoverStateMaster, backup.elect(now.Add(5*time.Second)))
+
+	// invalid signature
+	master.conf.Secret = "secret34"
+	master.heartbeat()
+	assert.NotEqual(t, "", master.err)
+}
@@ -2,13 +2,18 @@ package home
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"math/rand"
+	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,25 +21,32 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/rules"
 )
 
 var (
 	nextFilterID = time.Now().Unix() // semi-stable way to generate an unique ID
 )
 
+// The delay before the next attempt to update the filter after a failure:
+// it's doubled after each failed attempt, but isn't longer than the filter's update interval
+const (
+	filterRetryMinDelay = 1 * time.Minute
+	filterRetryMaxDelay = 1 * time.Hour
+)
+
 // Filtering - module object
 type Filtering struct {
 	// conf FilteringConf
-	refreshStatus     uint32 // 0:none; 1:in progress
-	refreshLock       sync.Mutex
-	filterTitleRegexp *regexp.Regexp
+	refreshStatus uint32 // 0:none; 1:in progress
+	refreshLock   sync.Mutex
 }
 
 // Init - initialize the module
 func (f *Filtering) Init() {
-	f.filterTitleRegexp = regexp.MustCompile(`^! Title: +(.*)$`)
 	_ = os.MkdirAll(filepath.Join(Context.getDataDir(), filterDir), 0755)
 	f.loadFilters(config.Filters)
 	f.loadFilters(config.WhitelistFilters)
@@ -74,26 +86,147 @@ type filter struct {
 	LastUpdated time.Time `yaml:"-"`
 	checksum    uint32    // checksum of the file data
 	white       bool
+	status      filterUpdateStatus
+
+	// ETag and Last-Modified headers of the last downloaded data:
+	// the data is downloaded again only if it has changed on the server
+	etag         string
+	lastModified string
+
+	// The latest modification time of the local file or directory:
+	// the data is read again only if it has changed
+	sourceModTime time.Time
+
+	// Update interval in hours (0: use the global filters_update_interval)
+	UpdateInterval uint32 `yaml:"update_interval,omitempty"`
+
+	// The pinned SHA-256 checksum of the filter data (hex) and the URL of the file with the checksum:
+	// the data with another checksum isn't installed
+	SHA256    string `yaml:"sha256,omitempty"`
+	SHA256URL string `yaml:"sha256_url,omitempty"`
+
+	// The group of the filter (e.g. "ads", "trackers"):
+	// all filters in the group may be enabled or disabled at once
+	Group string `yaml:"group,omitempty"`
+
+	// The blocklist is used only for the clients that have it in their filter_ids
+	ClientsOnly bool `yaml:"clients_only,omitempty"`
+
+	// Additional HTTP request headers for the downloads (e.g. an access token or User-Agent)
+	Headers map[string]string `yaml:"headers,omitempty"`
 
 	dnsfilter.Filter `yaml:",inline"`
 }
 
+// filterUpdateStatus - the result of the last update of the filter
+// The exported fields are stored in the status file of the filter, so they are kept after restart.
+type filterUpdateStatus struct {
+	Checked    time.Time     `json:"checked"`     // the time of the last update attempt
+	HTTPStatus int           `json:"http_status"` // HTTP status code (0: the filter is a file or the server hasn't responded)
+	Err        string        `json:"error"`       // the error of the last update attempt
+	Bytes      int64         `json:"bytes"`       // the number of bytes received at the last update attempt
+	Duration   time.Duration `json:"duration"`    // the duration of the last update attempt
+	Changed    time.Time     `json:"changed"`     // the time of the last change of the data
+
+	Failures      int       `json:"failures"`        // the number of consecutive failed attempts
+	FailingSince  time.Time `json:"failing_since"`   // the time of the first of the consecutive failed attempts
+	LastError     string    `json:"last_error"`      // the error of the last failed attempt (it's kept after a successful update)
+	LastErrorTime time.Time `json:"last_error_time"` // the time of the last failed attempt
+
+	nextRetry  time.Time // the time of the next attempt after a failure
+	size       int64     // the size of the filter data in bytes
+	rulesDelta int       // the change of the number of rules at the last update that has changed the filter
+
+	sha256       string // SHA-256 checksum of the last downloaded data (hex)
+	verification string // the result of the checksum verification: filterVerify*
+	redirectURL  string // the URL on another host the last request has been redirected to
+
+	meta filterMeta // the metadata declared by the filter
+}
+
+// filterRetryDelay - get the delay before the next attempt after this number of consecutive failures
+// A random jitter of +-25% is added, so the filters from the same server aren't requested at the same time.
+func filterRetryDelay(failures int, interval uint32) time.Duration {
+	max := filterRetryMaxDelay
+	if interval != 0 && time.Duration(interval)*time.Hour < max {
+		max = time.Duration(interval) * time.Hour
+	}
+	d := filterRetryMinDelay
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d*3/4 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// nextFilterRetry - get the earliest time of the next attempt to update a filter that has failed to update
+// Return zero time if there are no such filters.
+func nextFilterRetry() time.Time {
+	config.RLock()
+	defer config.RUnlock()
+	var next time.Time
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, f := range filters {
+			if !f.Enabled || f.status.Failures == 0 || f.updateInterval() == 0 {
+				continue
+			}
+			if next.IsZero() || f.status.nextRetry.Before(next) {
+				next = f.status.nextRetry
+			}
+		}
+	}
+	return next
+}
+
 // Creates a helper object for working with the user rules
 func userFilter() filter {
 	f := filter{
 		// User filter always has constant ID=0
 		Enabled: true,
 	}
-	f.Filter.Data = []byte(strings.Join(config.UserRules, "\n"))
+	var rules []string
+	for _, line := range config.UserRules {
+		_, ok, _ := dnsforward.ParseUpstreamRule(line)
+		if ok {
+			continue
+		}
+		// the rules with invalid names are passed as they are
+		if n, err := normalizeRule(line); err == nil {
+			line = n
+		}
+		rules = append(rules, line)
+	}
+	f.Filter.Data = []byte(strings.Join(rules, "\n"))
 	return f
 }
 
+// userUpstreamRules - get "[/domain/]address" upstream servers from the user rules with $upstream modifier
+// Invalid rules are skipped.
+func userUpstreamRules() []string {
+	var upstreams []string
+	for _, line := range config.UserRules {
+		u, ok, err := dnsforward.ParseUpstreamRule(line)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			log.Debug("filter: user rules: %s", err)
+			continue
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams
+}
+
 const (
 	statusFound          = 1
 	statusEnabledChanged = 2
 	statusURLChanged     = 4
 	statusURLExists      = 8
 	statusUpdateRequired = 0x10
+	statusUsageChanged   = 0x20 // the filter is now used for other clients
 )
 
 // Update properties for a filter specified by its URL
@@ -117,6 +250,32 @@ func (f *Filtering) filterSetProperties(url string, newf filter, whitelist bool)
 		log.Debug("filter: set properties: %s: {%s %s %v}",
 			filt.URL, newf.Name, newf.URL, newf.Enabled)
 		filt.Name = newf.Name
+		filt.Group = newf.Group
+		filt.UpdateInterval = newf.UpdateInterval
+		if filt.ClientsOnly != newf.ClientsOnly {
+			r |= statusUsageChanged
+			filt.ClientsOnly = newf.ClientsOnly
+		}
+
+		headersChanged := newf.Headers != nil && !reflect.DeepEqual(filt.Headers, newf.Headers)
+		if headersChanged {
+			// an empty map removes the headers
+			filt.Headers = nil
+			if len(newf.Headers) != 0 {
+				filt.Headers = newf.Headers
+			}
+		}
+
+		if filt.SHA256 != newf.SHA256 || filt.SHA256URL != newf.SHA256URL || headersChanged {
+			// download the data again to verify it
+			r |= statusUpdateRequired
+			filt.SHA256 = newf.SHA256
+			filt.SHA256URL = newf.SHA256URL
+			filt.LastUpdated = time.Time{}
+			filt.etag = ""
+			filt.lastModified = ""
+			filt.sourceModTime = time.Time{}
+		}
 
 		if filt.URL != newf.URL {
 			r |= statusURLChanged | statusUpdateRequired
@@ -128,6 +287,9 @@ func (f *Filtering) filterSetProperties(url string, newf filter, whitelist bool)
 			filt.LastUpdated = time.Time{}
 			filt.checksum = 0
 			filt.RulesCount = 0
+			filt.status = filterUpdateStatus{}
+			filt.etag = ""
+			filt.lastModified = ""
 		}
 
 		if filt.Enabled != newf.Enabled {
@@ -210,6 +372,7 @@ func (f *Filtering) loadFilters(array []filter) {
 			continue
 		}
 
+		filter.loadStatus()
 		err := f.load(filter)
 		if err != nil {
 			log.Error("Couldn't load filter %d contents due to %s", filter.ID, err)
@@ -249,13 +412,57 @@ func assignUniqueFilterID() int64 {
 	return value
 }
 
+// updateInterval - get the update interval of the filter in hours (0: auto-update is disabled)
+func (filter *filter) updateInterval() uint32 {
+	if filter.UpdateInterval != 0 {
+		return filter.UpdateInterval
+	}
+	if config.DNS.FiltersUpdateIntervalHours != 0 && filter.status.meta.expires != 0 {
+		// the update frequency declared by the filter ("! Expires: 4 days")
+		return filter.status.meta.expires
+	}
+	return config.DNS.FiltersUpdateIntervalHours
+}
+
+// filtersAutoUpdateEnabled - return TRUE if at least one filter is updated automatically
+func filtersAutoUpdateEnabled() bool {
+	config.RLock()
+	defer config.RUnlock()
+	if config.DNS.FiltersUpdateIntervalHours != 0 {
+		return true
+	}
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, f := range filters {
+			if f.Enabled && f.UpdateInterval != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Sets up a timer that will be checking for filters updates periodically
 func (f *Filtering) periodicallyRefreshFilters() {
 	const maxInterval = 1 * 60 * 60
 	intval := 5 // use a dynamically increasing time interval
+	if config.LowResourceMode {
+		time.Sleep(lowResourceFiltersUpdateDelay)
+	}
 	for {
+		w, now := maintenanceWindow(config.DNS.FiltersUpdateWindow)
+		if w != nil && !w.contains(now) {
+			// wait for the update window, but check the settings at least once per hour
+			d := w.untilStart(now)
+			if d > maxInterval*time.Second {
+				d = maxInterval * time.Second
+			}
+			log.Debug("Filters: waiting %s for the update window", d)
+			time.Sleep(d)
+			continue
+		}
+
 		isNetworkErr := false
-		if config.DNS.FiltersUpdateIntervalHours != 0 && atomic.CompareAndSwapUint32(&f.refreshStatus, 0, 1) {
+		if filtersAutoUpdateEnabled() && atomic.CompareAndSwapUint32(&f.refreshStatus, 0, 1) {
 			f.refreshLock.Lock()
 			_, isNetworkErr = f.refreshFiltersIfNecessary(FilterRefreshBlocklists | FilterRefreshAllowlists)
 			f.refreshLock.Unlock()
@@ -272,7 +479,16 @@ func (f *Filtering) periodicallyRefreshFilters() {
 			}
 		}
 
-		time.Sleep(time.Duration(intval) * time.Second)
+		d := time.Duration(intval) * time.Second
+		next := nextFilterRetry()
+		if !next.IsZero() && time.Until(next) < d {
+			// retry to update the filter that has failed to update
+			d = time.Until(next)
+			if d < time.Second {
+				d = time.Second
+			}
+		}
+		time.Sleep(d)
 	}
 }
 
@@ -293,7 +509,9 @@ func (f *Filtering) refreshFilters(flags int, important bool) (int, error) {
 	return nUpdated, nil
 }
 
-func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []filter, []bool, bool) {
+// refreshFiltersArray - update the filters from the list
+// match: update only the filters for which it returns TRUE (nil: all enabled filters)
+func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool, match func(f *filter) bool) (int, []filter, []bool, bool) {
 	var updateFilters []filter
 	var updateFlags []bool // 'true' if filter data has changed
 
@@ -302,12 +520,20 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 	for i := range *filters {
 		f := &(*filters)[i] // otherwise we will be operating on a copy
 
-		if !f.Enabled {
+		if !f.Enabled || match != nil && !match(f) {
 			continue
 		}
 
-		expireTime := f.LastUpdated.Unix() + int64(config.DNS.FiltersUpdateIntervalHours)*60*60
-		if !force && expireTime > now.Unix() {
+		interval := f.updateInterval()
+		expireTime := f.LastUpdated.Unix() + int64(interval)*60*60
+		if f.status.Failures != 0 {
+			// the last attempt has failed:  don't wait for the whole interval
+			expireTime = f.status.nextRetry.Unix()
+		} else if _, local := filterLocalPath(f.URL); local {
+			// the modification time of a local source is checked on each periodic check
+			expireTime = now.Unix()
+		}
+		if !force && (interval == 0 || expireTime > now.Unix()) {
 			continue
 		}
 
@@ -316,6 +542,16 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 		uf.URL = f.URL
 		uf.Name = f.Name
 		uf.checksum = f.checksum
+		uf.RulesCount = f.RulesCount
+		uf.LastUpdated = f.LastUpdated
+		uf.UpdateInterval = f.UpdateInterval
+		uf.SHA256 = f.SHA256
+		uf.SHA256URL = f.SHA256URL
+		uf.Headers = f.Headers
+		uf.status = f.status
+		uf.etag = f.etag
+		uf.lastModified = f.lastModified
+		uf.sourceModTime = f.sourceModTime
 		updateFilters = append(updateFilters, uf)
 	}
 	config.RUnlock()
@@ -327,15 +563,34 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 	nfail := 0
 	for i := range updateFilters {
 		uf := &updateFilters[i]
+		if i != 0 {
+			backgroundJobPause()
+		}
 		updated, err := f.update(uf)
 		updateFlags = append(updateFlags, updated)
 		if err != nil {
 			nfail++
-			log.Printf("Failed to update filter %s: %s\n", uf.URL, err)
+			log.Printf("Failed to update filter #%d %s: %s", uf.ID, uf.URL, uf.failureText(time.Now()))
+			notify(notifyFilterUpdateFailed, uf.URL, fmt.Sprintf("Failed to update filter %q (%s): %s", uf.Name, uf.URL, err))
 			continue
 		}
 	}
 
+	config.Lock()
+	for i := range updateFilters {
+		uf := &updateFilters[i]
+		for k := range *filters {
+			f := &(*filters)[k]
+			if f.ID == uf.ID && f.URL == uf.URL {
+				f.status = uf.status
+				f.etag = uf.etag
+				f.lastModified = uf.lastModified
+				f.sourceModTime = uf.sourceModTime
+			}
+		}
+	}
+	config.Unlock()
+
 	if nfail == len(updateFilters) {
 		return 0, nil, nil, true
 	}
@@ -407,13 +662,13 @@ func (f *Filtering) refreshFiltersIfNecessary(flags int) (int, bool) {
 		force = true
 	}
 	if (flags & FilterRefreshBlocklists) != 0 {
-		updateCount, updateFilters, updateFlags, netError = f.refreshFiltersArray(&config.Filters, force)
+		updateCount, updateFilters, updateFlags, netError = f.refreshFiltersArray(&config.Filters, force, nil)
 	}
 	if (flags & FilterRefreshAllowlists) != 0 {
 		updateCountW := 0
 		var updateFiltersW []filter
 		var updateFlagsW []bool
-		updateCountW, updateFiltersW, updateFlagsW, netErrorW = f.refreshFiltersArray(&config.WhitelistFilters, force)
+		updateCountW, updateFiltersW, updateFlagsW, netErrorW = f.refreshFiltersArray(&config.WhitelistFilters, force, nil)
 		updateCount += updateCountW
 		updateFilters = append(updateFilters, updateFiltersW...)
 		updateFlags = append(updateFlags, updateFlagsW...)
@@ -423,20 +678,72 @@ func (f *Filtering) refreshFiltersIfNecessary(flags int) (int, bool) {
 	}
 
 	if updateCount != 0 {
-		enableFilters(false)
+		applyFilterUpdates(updateFilters, updateFlags)
+	}
 
-		for i := range updateFilters {
-			uf := &updateFilters[i]
-			updated := updateFlags[i]
-			if !updated {
-				continue
+	log.Debug("Filters: update finished")
+	return updateCount, false
+}
+
+// applyFilterUpdates - pass the updated filters to dnsfilter and remove the old files
+func applyFilterUpdates(updateFilters []filter, updateFlags []bool) {
+	enableFilters(false)
+
+	for i := range updateFilters {
+		uf := &updateFilters[i]
+		updated := updateFlags[i]
+		if !updated {
+			continue
+		}
+		_ = os.Remove(uf.Path() + ".old")
+	}
+}
+
+// refreshFilter - download and apply one filter (specified by ID or URL) now
+// Return the filter after the update and TRUE if its data has changed.
+func (f *Filtering) refreshFilter(id int64, url string, whitelist bool) (filter, bool, error) {
+	filters := &config.Filters
+	if whitelist {
+		filters = &config.WhitelistFilters
+	}
+	match := func(flt *filter) bool {
+		return id != 0 && flt.ID == id || len(url) != 0 && flt.URL == url
+	}
+	find := func() (filter, bool) {
+		config.RLock()
+		defer config.RUnlock()
+		for _, flt := range *filters {
+			if match(&flt) {
+				return flt, true
 			}
-			_ = os.Remove(uf.Path() + ".old")
 		}
+		return filter{}, false
 	}
 
-	log.Debug("Filters: update finished")
-	return updateCount, false
+	f.refreshLock.Lock()
+	defer f.refreshLock.Unlock()
+
+	flt, ok := find()
+	if !ok {
+		return filter{}, false, fmt.Errorf("filter not found")
+	}
+	if !flt.Enabled {
+		return filter{}, false, fmt.Errorf("filter %d is disabled", flt.ID)
+	}
+
+	n, updateFilters, updateFlags, _ := f.refreshFiltersArray(filters, true, match)
+	if n != 0 {
+		applyFilterUpdates(updateFilters, updateFlags)
+	}
+
+	flt, ok = find()
+	if !ok {
+		return filter{}, false, fmt.Errorf("filter not found")
+	}
+	if len(flt.status.Err) != 0 {
+		return flt, false, fmt.Errorf("%s", flt.status.Err)
+	}
+	return flt, n != 0, nil
 }
 
 // Allows printable UTF-8 text with CR, LF, TAB characters
@@ -452,10 +759,9 @@ func isPrintableText(data []byte, len int) bool {
 }
 
 // A helper function that parses filter contents and returns a number of rules and a filter name (if there's any)
-func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string) {
+func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, filterMeta) {
 	rulesCount := 0
-	name := ""
-	seenTitle := false
+	meta := filterMeta{}
 	r := bufio.NewReader(file)
 	checksum := uint32(0)
 
@@ -468,11 +774,7 @@ func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string) {
 			//
 
 		} else if line[0] == '!' {
-			m := f.filterTitleRegexp.FindAllStringSubmatch(line, -1)
-			if len(m) > 0 && len(m[0]) >= 2 && !seenTitle {
-				name = m[0][1]
-				seenTitle = true
-			}
+			meta.parseLine(line)
 
 		} else if line[0] == '#' {
 			//
@@ -486,13 +788,105 @@ func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string) {
 		}
 	}
 
-	return rulesCount, checksum, name
+	return rulesCount, checksum, meta
+}
+
+// isValidFilterRule - return TRUE if the line is a valid blocking rule, a hosts file entry or a cosmetic rule
+// urlfilter accepts almost any text as a URL pattern, so the patterns with spaces and HTML tags are invalid too.
+func isValidFilterRule(line string) bool {
+	r, err := rules.NewRule(line, 0)
+	if err != nil {
+		return false
+	}
+	nr, ok := r.(*rules.NetworkRule)
+	if !ok {
+		return true
+	}
+	pattern := nr.RuleText
+	if strings.HasPrefix(pattern, "@@") {
+		pattern = pattern[2:]
+	}
+	i := strings.LastIndexByte(pattern, '$')
+	if i >= 0 {
+		pattern = pattern[:i]
+	}
+	if len(pattern) >= 2 && pattern[0] == '/' && pattern[len(pattern)-1] == '/' {
+		return true // regular expression
+	}
+	return !strings.ContainsAny(pattern, " \t<>")
+}
+
+// checkFilterRules - check the syntax of the filter rules
+// Return an error if the percentage of invalid rules exceeds maxInvalidPercent (0: the check is disabled).
+func checkFilterRules(file io.Reader, maxInvalidPercent uint32) error {
+	if maxInvalidPercent == 0 {
+		return nil
+	}
+
+	total := 0
+	invalid := 0
+	firstInvalid := ""
+	firstInvalidLine := 0
+	lineNum := 0
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadString('\n')
+		lineNum++
+		line = strings.TrimSpace(line)
+		if len(line) != 0 && line[0] != '!' && line[0] != '#' {
+			total++
+			if !isValidFilterRule(line) {
+				if invalid == 0 {
+					firstInvalid = line
+					firstInvalidLine = lineNum
+				}
+				invalid++
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if total != 0 && uint64(invalid)*100 > uint64(total)*uint64(maxInvalidPercent) {
+		return fmt.Errorf("%d of %d rules are invalid (line %d: %q)", invalid, total, firstInvalidLine, firstInvalid)
+	}
+	return nil
 }
 
 // Perform upgrade on a filter and update LastUpdated value
+// If the update has failed, LastUpdated isn't changed and the next attempt is scheduled with exponential backoff.
 func (f *Filtering) update(filter *filter) (bool, error) {
+	start := time.Now()
 	b, err := f.updateIntl(filter)
-	filter.LastUpdated = time.Now()
+	now := time.Now()
+	filter.status.Duration = now.Sub(start)
+	filter.status.Checked = now
+	filter.status.Err = ""
+	if err != nil {
+		filter.status.Err = err.Error()
+		filter.status.LastError = filter.status.Err
+		filter.status.LastErrorTime = now
+		if filter.status.Failures == 0 {
+			filter.status.FailingSince = now
+		}
+		filter.status.Failures++
+		filter.status.nextRetry = now.Add(filterRetryDelay(filter.status.Failures, filter.updateInterval()))
+		filter.saveStatus()
+		return false, err
+	}
+	if filter.status.Failures != 0 {
+		log.Info("Filter #%d %s: updated after %d failed attempts", filter.ID, filter.URL, filter.status.Failures)
+	}
+	filter.status.Failures = 0
+	filter.status.nextRetry = time.Time{}
+	filter.status.FailingSince = time.Time{}
+	if b {
+		filter.status.Changed = now
+	}
+	filter.saveStatus()
+
+	filter.LastUpdated = now
 	if !b {
 		e := os.Chtimes(filter.Path(), filter.LastUpdated, filter.LastUpdated)
 		if e != nil {
@@ -505,6 +899,8 @@ func (f *Filtering) update(filter *filter) (bool, error) {
 // nolint(gocyclo)
 func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 	log.Tracef("Downloading update for filter %d from %s", filter.ID, filter.URL)
+	filter.status.HTTPStatus = 0
+	filter.status.Bytes = 0
 
 	tmpFile, err := ioutil.TempFile(filepath.Join(Context.getDataDir(), filterDir), "")
 	if err != nil {
@@ -518,15 +914,47 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 	}()
 
 	var reader io.Reader
-	if filepath.IsAbs(filter.URL) {
-		f, err := os.Open(filter.URL)
+	var etag, lastModified string
+	var sourceModTime time.Time
+	if path, ok := filterLocalPath(filter.URL); ok {
+		r, closeFiles, modTime, err := openLocalFilter(path)
 		if err != nil {
 			return false, fmt.Errorf("open file: %s", err)
 		}
-		defer f.Close()
-		reader = f
+		defer closeFiles()
+		if filter.checksum != 0 && modTime.Equal(filter.sourceModTime) {
+			log.Tracef("Filter #%d at %s hasn't changed (modification time), not updating it", filter.ID, path)
+			return false, nil
+		}
+		reader, err = decodeFilterData(r, "", isGzipFilterURL(filter.URL))
+		if err != nil {
+			return false, err
+		}
+		sourceModTime = modTime
+	} else if data, unchanged, ok := f.patchFilter(filter); ok {
+		if unchanged {
+			return false, nil
+		}
+		reader = bytes.NewReader(data)
 	} else {
-		resp, err := Context.client.Get(filter.URL)
+		counter := &countingReader{}
+		req, err := http.NewRequest("GET", filter.URL, nil)
+		if err != nil {
+			return false, err
+		}
+		setFilterHeaders(req, filter.URL, filter.Headers)
+		req.Header.Set("Accept-Encoding", filterAcceptEncoding)
+		if filter.checksum != 0 {
+			// we have the data of the filter:  the server doesn't send it again if it hasn't changed
+			if len(filter.etag) != 0 {
+				req.Header.Set("If-None-Match", filter.etag)
+			}
+			if len(filter.lastModified) != 0 {
+				req.Header.Set("If-Modified-Since", filter.lastModified)
+			}
+		}
+
+		resp, err := filtersHTTPClient().Do(req)
 		if resp != nil && resp.Body != nil {
 			defer resp.Body.Close()
 		}
@@ -534,12 +962,26 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 			log.Printf("Couldn't request filter from URL %s, skipping: %s", filter.URL, err)
 			return false, err
 		}
+		filter.status.HTTPStatus = resp.StatusCode
+		filter.status.redirectURL = redirectTarget(req.URL, resp.Request.URL)
 
+		if resp.StatusCode == http.StatusNotModified && filter.checksum != 0 {
+			log.Tracef("Filter #%d at URL %s hasn't changed (not modified), not updating it", filter.ID, filter.URL)
+			return false, nil
+		}
 		if resp.StatusCode != 200 {
 			log.Printf("Got status code %d from URL %s, skipping", resp.StatusCode, filter.URL)
 			return false, fmt.Errorf("got status code != 200: %d", resp.StatusCode)
 		}
-		reader = resp.Body
+		counter.r = resp.Body
+		defer func() { filter.status.Bytes = counter.n }()
+		reader, err = decodeFilterData(counter, resp.Header.Get("Content-Encoding"), isGzipFilterURL(filter.URL))
+		if err != nil {
+			log.Printf("Couldn't decompress filter contents from URL %s, skipping: %s", filter.URL, err)
+			return false, err
+		}
+		etag = resp.Header.Get("ETag")
+		lastModified = resp.Header.Get("Last-Modified")
 	}
 
 	htmlTest := true
@@ -547,9 +989,11 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 	firstChunkLen := 0
 	buf := make([]byte, 64*1024)
 	total := 0
+	hash := sha256.New()
 	for {
 		n, err := reader.Read(buf)
 		total += n
+		_, _ = hash.Write(buf[:n])
 
 		if htmlTest {
 			// gather full buffer firstChunk and perform its data tests
@@ -587,10 +1031,31 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 		}
 	}
 
+	filter.status.sha256 = hex.EncodeToString(hash.Sum(nil))
+	err = filter.verifyChecksum(filter.status.sha256)
+	if err != nil {
+		log.Printf("Filter #%d at URL %s: %s", filter.ID, filter.URL, err)
+		return false, err
+	}
+
 	// Extract filter name and count number of rules
 	_, _ = tmpFile.Seek(0, io.SeekStart)
-	rulesCount, checksum, filterName := f.parseFilterContents(tmpFile)
+	rulesCount, checksum, meta := f.parseFilterContents(tmpFile)
+	filter.status.size = int64(total)
 	// Check if the filter has been really changed
+	if filter.checksum != checksum {
+		// the previous version of the filter is kept if the new data isn't a filter list
+		_, _ = tmpFile.Seek(0, io.SeekStart)
+		err = checkFilterRules(tmpFile, config.DNS.FiltersMaxInvalidPercent)
+		if err != nil {
+			log.Printf("Filter #%d at URL %s has invalid data, not updating it: %s", filter.ID, filter.URL, err)
+			return false, err
+		}
+	}
+	filter.etag = etag
+	filter.lastModified = lastModified
+	filter.sourceModTime = sourceModTime
+	filter.status.meta = meta
 	if filter.checksum == checksum {
 		log.Tracef("Filter #%d at URL %s hasn't changed, not updating it", filter.ID, filter.URL)
 		return false, nil
@@ -599,8 +1064,9 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 	log.Printf("Filter %d has been updated: %d bytes, %d rules",
 		filter.ID, total, rulesCount)
 	if len(filter.Name) == 0 {
-		filter.Name = filterName
+		filter.Name = meta.title
 	}
+	filter.status.rulesDelta = rulesCount - filter.RulesCount
 	filter.RulesCount = rulesCount
 	filter.checksum = checksum
 	filterFilePath := filter.Path()
@@ -608,6 +1074,7 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 
 	// Closing the file before renaming it is necessary on Windows
 	_ = tmpFile.Close()
+	keepFilterVersion(filterFilePath, int(config.DNS.FiltersKeepVersions))
 	err = os.Rename(tmpFile.Name(), filterFilePath)
 	if err != nil {
 		return false, err
@@ -636,11 +1103,24 @@ func (f *Filtering) load(filter *filter) error {
 
 	log.Tracef("File %s, id %d, length %d",
 		filterFilePath, filter.ID, st.Size())
-	rulesCount, checksum, _ := f.parseFilterContents(file)
+	hash := sha256.New()
+	rulesCount, checksum, meta := f.parseFilterContents(io.TeeReader(file, hash))
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if len(filter.SHA256) != 0 && !strings.EqualFold(filter.SHA256, sum) {
+		// the pinned checksum has been changed:  the filter is downloaded and verified again
+		return fmt.Errorf("SHA-256 of %s is %s, expected %s", filterFilePath, sum, filter.SHA256)
+	}
 
 	filter.RulesCount = rulesCount
 	filter.checksum = checksum
+	filter.status.meta = meta
 	filter.LastUpdated = filter.LastTimeUpdated()
+	if filter.status.Changed.IsZero() {
+		// the filter has been downloaded by the older version
+		filter.status.Changed = filter.LastUpdated
+	}
+	filter.status.size = st.Size()
+	filter.status.sha256 = sum
 
 	return nil
 }
@@ -677,6 +1157,7 @@ func (filter *filter) LastTimeUpdated() time.Time {
 func enableFilters(async bool) {
 	var filters []dnsfilter.Filter
 	var whiteFilters []dnsfilter.Filter
+	var profiles []dnsfilter.FilterProfile
 	if config.DNS.FilteringEnabled {
 		// convert array of filters
 
@@ -688,7 +1169,7 @@ func enableFilters(async bool) {
 		filters = append(filters, f)
 
 		for _, filter := range config.Filters {
-			if !filter.Enabled {
+			if !filter.Enabled || filter.ClientsOnly {
 				continue
 			}
 			f := dnsfilter.Filter{
@@ -707,7 +1188,39 @@ func enableFilters(async bool) {
 			}
 			whiteFilters = append(whiteFilters, f)
 		}
+
+		for name, ids := range Context.clients.filterProfiles() {
+			profiles = append(profiles, filterProfile(name, ids, userFilter.Data))
+		}
 	}
 
-	_ = Context.dnsFilter.SetFilters(filters, whiteFilters, async)
+	_ = Context.dnsFilter.SetFilters(filters, whiteFilters, profiles, async)
+}
+
+// filterProfile - get the user rules and the enabled blocklists with the specified IDs
+func filterProfile(name string, ids []int64, userRules []byte) dnsfilter.FilterProfile {
+	p := dnsfilter.FilterProfile{
+		Name:    name,
+		Filters: []dnsfilter.Filter{{ID: 0, Data: userRules}},
+	}
+	for _, filter := range config.Filters {
+		if !filter.Enabled || !int64ArrayContains(ids, filter.ID) {
+			continue
+		}
+		p.Filters = append(p.Filters, dnsfilter.Filter{
+			ID:       filter.ID,
+			FilePath: filter.Path(),
+		})
+	}
+	return p
+}
+
+// int64ArrayContains - return TRUE if the array contains the value
+func int64ArrayContains(a []int64, v int64) bool {
+	for _, i := range a {
+		if i == v {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,409 @@
+package home
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The version of the filters bundle format
+const filterBundleVersion = 1
+
+// The name of the manifest file in the bundle
+const filterBundleManifest = "filters.json"
+
+// The name of the file with the attribution of the filter lists in the bundle
+const filterBundleAttribution = "ATTRIBUTION.txt"
+
+// The maximum size of the uploaded bundle
+const filterBundleMaxSize = 256 * 1024 * 1024
+
+// filterBundleEntry - the filter in the bundle manifest
+type filterBundleEntry struct {
+	Name           string    `json:"name"`
+	URL            string    `json:"url"`
+	Enabled        bool      `json:"enabled"`
+	Whitelist      bool      `json:"whitelist"`
+	UpdateInterval uint32    `json:"update_interval,omitempty"`
+	SHA256         string    `json:"sha256,omitempty"`
+	SHA256URL      string    `json:"sha256_url,omitempty"`
+	Group          string    `json:"group,omitempty"`
+	ClientsOnly    bool      `json:"clients_only,omitempty"`
+	LastUpdated    time.Time `json:"last_updated,omitempty"`
+	File           string    `json:"file,omitempty"` // the cached contents in the bundle ("": not included)
+
+	// The attribution declared in the header of the filter:
+	// it isn't imported, because it's read from the filter contents again
+	Title    string `json:"title,omitempty"`
+	Homepage string `json:"homepage,omitempty"`
+	License  string `json:"license,omitempty"`
+}
+
+// filterBundle - the manifest of the filters bundle
+type filterBundle struct {
+	Version   int                 `json:"version"`
+	Created   time.Time           `json:"created"`
+	Filters   []filterBundleEntry `json:"filters"`
+	UserRules []string            `json:"user_rules"`
+}
+
+// writeFilterBundle - write the filters, their cached contents and the user rules as a ZIP archive
+func writeFilterBundle(w io.Writer) error {
+	config.RLock()
+	bundle := filterBundle{
+		Version:   filterBundleVersion,
+		Created:   time.Now().UTC(),
+		UserRules: append([]string{}, config.UserRules...),
+	}
+	var paths []string
+	add := func(list []filter, whitelist bool) {
+		for _, f := range list {
+			e := filterBundleEntry{
+				Name:           f.Name,
+				URL:            f.URL,
+				Enabled:        f.Enabled,
+				Whitelist:      whitelist,
+				UpdateInterval: f.UpdateInterval,
+				SHA256:         f.SHA256,
+				SHA256URL:      f.SHA256URL,
+				Group:          f.Group,
+				ClientsOnly:    f.ClientsOnly,
+
+				Title:    f.status.meta.title,
+				Homepage: f.status.meta.homepage,
+				License:  f.status.meta.license,
+			}
+			if t := f.LastTimeUpdated(); !t.IsZero() {
+				e.LastUpdated = t.UTC()
+				e.File = "filters/" + strconv.Itoa(len(bundle.Filters)+1) + ".txt"
+			}
+			bundle.Filters = append(bundle.Filters, e)
+			paths = append(paths, f.Path())
+		}
+	}
+	add(config.Filters, false)
+	add(config.WhitelistFilters, true)
+	config.RUnlock()
+
+	zw := zip.NewWriter(w)
+	for i, e := range bundle.Filters {
+		if len(e.File) == 0 {
+			continue
+		}
+		err := writeFilterBundleFile(zw, e.File, paths[i])
+		if err != nil {
+			// the filter is imported without its contents and downloaded again
+			log.Debug("filters export: %s: %s", paths[i], err)
+			bundle.Filters[i].File = ""
+		}
+	}
+
+	err := writeFilterBundleAttribution(zw, bundle.Filters)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "\t")
+	if err != nil {
+		return err
+	}
+	fw, err := zw.Create(filterBundleManifest)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeFilterBundleAttribution - add the list of the sources and licenses of the filter contents to the archive
+// Only the filters whose contents are in the bundle are listed:  their maintainers ask to keep the attribution
+// when the lists are redistributed.
+func writeFilterBundleAttribution(zw *zip.Writer, filters []filterBundleEntry) error {
+	sb := strings.Builder{}
+	for _, e := range filters {
+		if len(e.File) == 0 {
+			continue
+		}
+		name := e.Title
+		if len(name) == 0 {
+			name = e.Name
+		}
+		_, _ = fmt.Fprintf(&sb, "%s: %s\n", e.File, name)
+		_, _ = fmt.Fprintf(&sb, "\tSource: %s\n", e.URL)
+		if len(e.Homepage) != 0 {
+			_, _ = fmt.Fprintf(&sb, "\tHomepage: %s\n", e.Homepage)
+		}
+		license := e.License
+		if len(license) == 0 {
+			license = "not declared"
+		}
+		_, _ = fmt.Fprintf(&sb, "\tLicense: %s\n\n", license)
+	}
+	if sb.Len() == 0 {
+		return nil
+	}
+
+	fw, err := zw.Create(filterBundleAttribution)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write([]byte(sb.String()))
+	return err
+}
+
+// writeFilterBundleFile - add the file to the archive
+func writeFilterBundleFile(zw *zip.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// readFilterBundle - read the manifest of the bundle and check it
+func readFilterBundle(zr *zip.Reader) (*filterBundle, map[string]*zip.File, error) {
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	mf, ok := files[filterBundleManifest]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s not found", filterBundleManifest)
+	}
+	r, err := mf.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	bundle := &filterBundle{}
+	err = json.NewDecoder(r).Decode(bundle)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", filterBundleManifest, err)
+	}
+	if bundle.Version != filterBundleVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	for i, line := range bundle.UserRules {
+		_, _, err = dnsforward.ParseUpstreamRule(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("user_rules[%d]: %s", i, err)
+		}
+	}
+	for i, e := range bundle.Filters {
+		err = validateFilterChecksum(e.SHA256, e.SHA256URL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("filters[%d]: %s", i, err)
+		}
+		err = validateFilterGroup(e.Group)
+		if err != nil {
+			return nil, nil, fmt.Errorf("filters[%d]: %s", i, err)
+		}
+		if len(e.File) != 0 && files[e.File] == nil {
+			return nil, nil, fmt.Errorf("filters[%d]: %s not found", i, e.File)
+		}
+	}
+	return bundle, files, nil
+}
+
+type filterImportResp struct {
+	Filters int `json:"filters"` // the number of the added filters
+	Rules   int `json:"rules"`   // the number of the added user rules
+	Skipped int `json:"skipped"` // the number of the filters that weren't imported
+}
+
+// importFilterEntry - add the filter from the bundle and install its cached contents
+// Return FALSE if it's skipped.
+func (f *Filtering) importFilterEntry(e filterBundleEntry, zf *zip.File) bool {
+	if !isValidURL(e.URL) || filterExists(e.URL) {
+		return false
+	}
+
+	filt := filter{
+		Enabled: e.Enabled,
+		URL:     e.URL,
+		Name:    e.Name,
+		white:   e.Whitelist,
+
+		UpdateInterval: e.UpdateInterval,
+		SHA256:         e.SHA256,
+		SHA256URL:      e.SHA256URL,
+		Group:          e.Group,
+		ClientsOnly:    e.ClientsOnly && !e.Whitelist,
+	}
+	filt.ID = assignUniqueFilterID()
+	if zf != nil {
+		err := installFilterBundleFile(zf, filt.Path(), e.LastUpdated)
+		if err == nil {
+			err = f.load(&filt)
+		}
+		if err != nil {
+			// the filter is downloaded again
+			log.Info("filters import: %s: %s", e.URL, err)
+			_ = os.Remove(filt.Path())
+			filt.unload()
+		}
+	}
+
+	if !filterAdd(filt) {
+		_ = os.Remove(filt.Path())
+		return false
+	}
+	return true
+}
+
+// installFilterBundleFile - write the filter contents from the bundle to the filter file
+// The modification time is set to the time of the last update, so the filter is updated as scheduled.
+func installFilterBundleFile(zf *zip.File, path string, lastUpdated time.Time) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmpFile, r)
+	_ = tmpFile.Close()
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if !lastUpdated.IsZero() && lastUpdated.Before(time.Now()) {
+		_ = os.Chtimes(path, lastUpdated, lastUpdated)
+	}
+	return nil
+}
+
+// importFilterBundle - add the filters and user rules from the bundle
+// The filters that already exist are skipped.  The user rules are appended (only the new ones) or replace the current ones.
+func (f *Filtering) importFilterBundle(bundle *filterBundle, files map[string]*zip.File, replaceRules bool) filterImportResp {
+	resp := filterImportResp{}
+	for _, e := range bundle.Filters {
+		if !f.importFilterEntry(e, files[e.File]) {
+			resp.Skipped++
+			continue
+		}
+		resp.Filters++
+	}
+
+	config.Lock()
+	if replaceRules {
+		config.UserRules = bundle.UserRules
+		resp.Rules = len(bundle.UserRules)
+	} else {
+		existing := map[string]bool{}
+		for _, r := range config.UserRules {
+			existing[r] = true
+		}
+		for _, r := range bundle.UserRules {
+			if len(r) == 0 || existing[r] {
+				continue
+			}
+			existing[r] = true
+			config.UserRules = append(config.UserRules, r)
+			resp.Rules++
+		}
+	}
+	config.Unlock()
+
+	return resp
+}
+
+// GET /control/filtering/export
+func (f *Filtering) handleFilteringExport(w http.ResponseWriter, r *http.Request) {
+	fn := fmt.Sprintf("AdGuardHome-filters-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fn))
+
+	err := writeFilterBundle(w)
+	if err != nil {
+		// the headers are already sent
+		log.Error("filters export: %s", err)
+	}
+}
+
+// POST /control/filtering/import?replace_user_rules=true
+func (f *Filtering) handleFilteringImport(w http.ResponseWriter, r *http.Request) {
+	tmpFile, err := ioutil.TempFile(filepath.Join(Context.getDataDir(), filterDir), "import")
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	size, err := io.Copy(tmpFile, http.MaxBytesReader(w, r.Body, filterBundleMaxSize))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Failed to read request body: %s", err)
+		return
+	}
+	zr, err := zip.NewReader(tmpFile, size)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "zip: %s", err)
+		return
+	}
+	bundle, files, err := readFilterBundle(zr)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	upstreams := userUpstreamRules()
+	resp := f.importFilterBundle(bundle, files, r.URL.Query().Get("replace_user_rules") == "true")
+	log.Info("filters import: added %d filters, %d user rules, skipped %d filters", resp.Filters, resp.Rules, resp.Skipped)
+
+	onConfigModified()
+	enableFilters(true)
+	if !reflect.DeepEqual(upstreams, userUpstreamRules()) {
+		Context.clients.resetUpstreamConfigs()
+		err = reconfigureDNSServer()
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+	}
+	if resp.Filters != 0 {
+		// download the filters without cached contents
+		go func() {
+			_, _ = f.refreshFilters(FilterRefreshBlocklists|FilterRefreshAllowlists, false)
+		}()
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,76 @@
+package home
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// The value of Accept-Encoding header of the filter download requests
+// Setting it disables the transparent decompression in net/http, so the deflate encoding is supported too.
+const filterAcceptEncoding = "gzip, deflate"
+
+// isGzipFilterURL - return TRUE if the filter URL or file path points to a gzip archive (".gz")
+func isGzipFilterURL(rawurl string) bool {
+	p := rawurl
+	if _, ok := filterLocalPath(rawurl); !ok {
+		if u, err := url.Parse(rawurl); err == nil {
+			p = u.Path
+		}
+	}
+	return strings.HasSuffix(strings.ToLower(p), ".gz")
+}
+
+// decodeFilterData - get the reader of the decompressed filter data
+// contentEncoding: the value of Content-Encoding header ("" for local files)
+// gzFile: the data is a gzip archive (".gz" URL);  it's decompressed if it has gzip header
+func decodeFilterData(r io.Reader, contentEncoding string, gzFile bool) (io.Reader, error) {
+	var err error
+	switch enc := strings.ToLower(strings.TrimSpace(contentEncoding)); enc {
+	case "", "identity":
+		// not compressed
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %s", err)
+		}
+	case "deflate":
+		r, err = newDeflateReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("deflate: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", contentEncoding)
+	}
+
+	if !gzFile {
+		return r, nil
+	}
+	// some servers also set "Content-Encoding: gzip" for .gz files, then the data is already decompressed
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %s", err)
+		}
+		return gr, nil
+	}
+	return br, nil
+}
+
+// newDeflateReader - get the reader of "deflate" content encoding
+// It must be zlib format, but some servers send raw deflate data.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	hdr, _ := br.Peek(2)
+	if len(hdr) == 2 && hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
@@ -0,0 +1,210 @@
+package home
+
+import (
+	"bytes"
+	"crypto/sha1" // #nosec: the checksum in the patch is SHA-1
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The maximum size of the patch file
+const filterDiffMaxSize = 4 * 1024 * 1024
+
+// diffPatchURL - get the URL of the next patch of the filter from "! Diff-Path: ../patches/list-1.patch#name"
+// The patch must be on the same host as the filter.
+func diffPatchURL(filterURL, diffPath string) (string, string, error) {
+	name := ""
+	if i := strings.IndexByte(diffPath, '#'); i >= 0 {
+		name = diffPath[i+1:]
+		diffPath = diffPath[:i]
+	}
+	base, err := url.Parse(filterURL)
+	if err != nil {
+		return "", "", err
+	}
+	u, err := base.Parse(diffPath)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.EqualFold(u.Host, base.Host) || u.Scheme != base.Scheme {
+		return "", "", fmt.Errorf("patch %s isn't on the host of the filter", u)
+	}
+	return u.String(), name, nil
+}
+
+// diffSection - get the commands of the patch for the resource
+// The patch may start with "diff name:<name> checksum:<SHA-1 of the result> lines:<number of lines>" line,
+// and it may contain the sections for several resources.
+// Return the commands and the expected checksum ("": not specified).
+func diffSection(patch []string, name string) ([]string, string, error) {
+	if len(patch) == 0 || !strings.HasPrefix(patch[0], "diff ") {
+		if len(name) != 0 {
+			return nil, "", fmt.Errorf("no diff for %s", name)
+		}
+		return patch, "", nil
+	}
+
+	for i := 0; i < len(patch); {
+		fields := map[string]string{}
+		for _, f := range strings.Fields(patch[i])[1:] {
+			kv := strings.SplitN(f, ":", 2)
+			if len(kv) == 2 {
+				fields[kv[0]] = kv[1]
+			}
+		}
+		n, err := strconv.Atoi(fields["lines"])
+		if err != nil || n < 0 || i+1+n > len(patch) {
+			return nil, "", fmt.Errorf("invalid diff header %q", patch[i])
+		}
+		if len(name) == 0 || fields["name"] == name {
+			return patch[i+1 : i+1+n], fields["checksum"], nil
+		}
+		i += 1 + n
+	}
+	return nil, "", fmt.Errorf("no diff for %s", name)
+}
+
+// applyRCSDiff - apply the commands of the patch in RCS format to the lines of the file:
+// "aN M" adds the next M lines after line N, "dN M" deletes M lines starting from line N.
+// The line numbers are those of the original file.
+func applyRCSDiff(orig, cmds []string) ([]string, error) {
+	result := []string{}
+	cur := 0 // the number of the lines of the original file that have been processed
+	for i := 0; i < len(cmds); i++ {
+		c := cmds[i]
+		if len(c) == 0 {
+			continue
+		}
+		var pos, n int
+		_, err := fmt.Sscanf(c[1:], "%d %d", &pos, &n)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid command %q", c)
+		}
+
+		switch c[0] {
+		case 'a':
+			if pos < cur || pos > len(orig) || i+n >= len(cmds) {
+				return nil, fmt.Errorf("invalid command %q", c)
+			}
+			result = append(result, orig[cur:pos]...)
+			result = append(result, cmds[i+1:i+1+n]...)
+			cur = pos
+			i += n
+		case 'd':
+			if pos < 1 || pos-1 < cur || pos-1+n > len(orig) {
+				return nil, fmt.Errorf("invalid command %q", c)
+			}
+			result = append(result, orig[cur:pos-1]...)
+			cur = pos - 1 + n
+		default:
+			return nil, fmt.Errorf("invalid command %q", c)
+		}
+	}
+	return append(result, orig[cur:]...), nil
+}
+
+// splitLines - split the data into lines
+// Return TRUE if the data ends with a newline.
+func splitLines(data []byte) ([]string, bool) {
+	s := string(data)
+	nl := strings.HasSuffix(s, "\n")
+	s = strings.TrimSuffix(s, "\n")
+	if len(s) == 0 {
+		return []string{}, nl
+	}
+	return strings.Split(s, "\n"), nl
+}
+
+// patchFilter - get the new data of the filter by applying the patch declared by the current data
+// unchanged: the patch doesn't exist yet, so the filter hasn't changed
+// ok: FALSE if the patch can't be used and the whole filter must be downloaded
+func (f *Filtering) patchFilter(filter *filter) (data []byte, unchanged, ok bool) {
+	diffPath := filter.status.meta.diffPath
+	if len(diffPath) == 0 || filter.checksum == 0 ||
+		len(filter.SHA256) != 0 || len(filter.SHA256URL) != 0 {
+		return nil, false, false
+	}
+
+	data, unchanged, err := f.patchFilterData(filter, diffPath)
+	if err != nil {
+		log.Info("Filter #%d: couldn't apply patch %s, downloading the whole filter: %s", filter.ID, diffPath, err)
+		return nil, false, false
+	}
+	return data, unchanged, true
+}
+
+func (f *Filtering) patchFilterData(filter *filter, diffPath string) ([]byte, bool, error) {
+	patchURL, name, err := diffPatchURL(filter.URL, diffPath)
+	if err != nil {
+		return nil, false, err
+	}
+	req, err := http.NewRequest("GET", patchURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	setFilterHeaders(req, filter.URL, filter.Headers)
+	resp, err := filtersHTTPClient().Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	filter.status.HTTPStatus = resp.StatusCode
+	if resp.StatusCode == http.StatusNotFound {
+		// the next patch hasn't been published yet
+		log.Tracef("Filter #%d: patch %s doesn't exist yet, the filter hasn't changed", filter.ID, patchURL)
+		return nil, true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("got status code %d", resp.StatusCode)
+	}
+	patch, err := ioutil.ReadAll(io.LimitReader(resp.Body, filterDiffMaxSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(patch) > filterDiffMaxSize {
+		return nil, false, fmt.Errorf("patch is too large")
+	}
+	filter.status.Bytes = int64(len(patch))
+
+	lines, _ := splitLines(patch)
+	cmds, checksum, err := diffSection(lines, name)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(cmds) == 0 {
+		return nil, true, nil
+	}
+
+	cur, err := ioutil.ReadFile(filter.Path())
+	if err != nil {
+		return nil, false, err
+	}
+	orig, nl := splitLines(cur)
+	result, err := applyRCSDiff(orig, cmds)
+	if err != nil {
+		return nil, false, err
+	}
+	data := []byte(strings.Join(result, "\n"))
+	if nl {
+		data = append(data, '\n')
+	}
+	if len(checksum) != 0 {
+		sum := sha1.Sum(data) // #nosec
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), checksum) {
+			return nil, false, fmt.Errorf("checksum of the patched data doesn't match")
+		}
+	}
+	if bytes.Equal(data, cur) {
+		return nil, true, nil
+	}
+	log.Debug("Filter #%d has been patched with %s", filter.ID, patchURL)
+	return data, false, nil
+}
@@ -0,0 +1,160 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"unicode"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The maximum length of the filter group name
+const filterGroupMaxLen = 64
+
+// validateFilterGroup - check the name of the filter group ("": the filter isn't in a group)
+func validateFilterGroup(group string) error {
+	if len(group) > filterGroupMaxLen {
+		return fmt.Errorf("group name is longer than %d characters", filterGroupMaxLen)
+	}
+	for _, c := range group {
+		if unicode.IsControl(c) {
+			return fmt.Errorf("invalid group name %q", group)
+		}
+	}
+	return nil
+}
+
+type filterGroupJSON struct {
+	Name           string `json:"name"`
+	Whitelist      bool   `json:"whitelist"`
+	Filters        int    `json:"filters"`         // the number of filters in the group
+	EnabledFilters int    `json:"enabled_filters"` // the number of enabled filters in the group
+	RulesCount     int    `json:"rules_count"`     // the number of rules in the enabled filters
+}
+
+// filterGroupsNoLock - get the groups of the filters with the aggregated counters
+func filterGroupsNoLock(list []filter, whitelist bool) []filterGroupJSON {
+	groups := []filterGroupJSON{}
+	index := map[string]int{}
+	for _, f := range list {
+		if len(f.Group) == 0 {
+			continue
+		}
+		i, ok := index[f.Group]
+		if !ok {
+			i = len(groups)
+			index[f.Group] = i
+			groups = append(groups, filterGroupJSON{Name: f.Group, Whitelist: whitelist})
+		}
+		g := &groups[i]
+		g.Filters++
+		if f.Enabled {
+			g.EnabledFilters++
+			g.RulesCount += f.RulesCount
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// filterGroups - get the groups of blocklists and allowlists
+func filterGroups() []filterGroupJSON {
+	config.RLock()
+	defer config.RUnlock()
+	return append(filterGroupsNoLock(config.Filters, false),
+		filterGroupsNoLock(config.WhitelistFilters, true)...)
+}
+
+// filterGroupSetEnabled - enable or disable all filters in the group
+// Return the combined status* flags of the changed filters (0: the group isn't found).
+func (f *Filtering) filterGroupSetEnabled(group string, whitelist, enabled bool) int {
+	config.RLock()
+	list := config.Filters
+	if whitelist {
+		list = config.WhitelistFilters
+	}
+	var members []filter
+	for _, filt := range list {
+		if filt.Group == group {
+			members = append(members, filt)
+		}
+	}
+	config.RUnlock()
+
+	r := 0
+	for _, filt := range members {
+		if filt.Enabled == enabled {
+			r |= statusFound
+			continue
+		}
+		// the rest of the properties stay the same
+		newf := filt
+		newf.Enabled = enabled
+		r |= f.filterSetProperties(filt.URL, newf, whitelist)
+	}
+	return r
+}
+
+type filterGroupsResp struct {
+	Groups []filterGroupJSON `json:"groups"`
+}
+
+// GET /control/filtering/groups
+func (f *Filtering) handleFilteringGroups(w http.ResponseWriter, r *http.Request) {
+	resp := filterGroupsResp{Groups: filterGroups()}
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+type filterGroupReq struct {
+	Name      string `json:"name"`
+	Whitelist bool   `json:"whitelist"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// POST /control/filtering/set_group
+func (f *Filtering) handleFilteringSetGroup(w http.ResponseWriter, r *http.Request) {
+	req := filterGroupReq{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+	if len(req.Name) == 0 {
+		httpError(w, http.StatusBadRequest, "group name is required")
+		return
+	}
+
+	status := f.filterGroupSetEnabled(req.Name, req.Whitelist, req.Enabled)
+	if (status & statusFound) == 0 {
+		httpError(w, http.StatusBadRequest, "group not found: %s", req.Name)
+		return
+	}
+	log.Info("filter group %s: enabled: %v", req.Name, req.Enabled)
+
+	onConfigModified()
+	restart := (status & statusEnabledChanged) != 0
+	if (status&statusUpdateRequired) != 0 && req.Enabled {
+		// download the filters that have no data on disk
+		flags := FilterRefreshBlocklists
+		if req.Whitelist {
+			flags = FilterRefreshAllowlists
+		}
+		nUpdated, _ := f.refreshFilters(flags, true)
+		// if at least 1 filter has been updated, refreshFilters() restarts the filtering automatically
+		restart = nUpdated == 0
+	}
+	if restart {
+		enableFilters(true)
+	}
+	returnOK(w)
+}
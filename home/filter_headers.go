@@ -0,0 +1,68 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// The request headers that are set by the filter update and can't be overridden
+var filterReservedHeaders = []string{
+	"Accept-Encoding",
+	"Connection",
+	"Content-Length",
+	"Host",
+	"If-Modified-Since",
+	"If-None-Match",
+	"Transfer-Encoding",
+}
+
+// validateFilterHeaders - check the additional request headers of the filter
+func validateFilterHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		for _, r := range filterReservedHeaders {
+			if strings.EqualFold(name, r) {
+				return fmt.Errorf("header %s can't be set", name)
+			}
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			// the value isn't printed: it may be a secret
+			return fmt.Errorf("invalid value of header %s", name)
+		}
+	}
+	return nil
+}
+
+// filterHeaderNames - get the sorted names of the additional request headers
+// The values (e.g. access tokens) aren't returned by the API.
+func filterHeaderNames(headers map[string]string) []string {
+	names := []string{}
+	for name := range headers {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setFilterHeaders - add the filter's headers to the request for the URL
+// The headers are sent only to the host of the filter URL (e.g. to the same server for sha256_url),
+// so the access tokens aren't disclosed to the other servers.
+func setFilterHeaders(req *http.Request, filterURL string, headers map[string]string) {
+	if len(headers) == 0 {
+		return
+	}
+	u, err := url.Parse(filterURL)
+	if err != nil || !strings.EqualFold(u.Host, req.URL.Host) {
+		return
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+}
@@ -0,0 +1,83 @@
+package home
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+)
+
+// isDomainNameRune - return TRUE if the character may be a part of the domain name in the rule
+func isDomainNameRune(c rune) bool {
+	return c == '.' || c == '-' || c == '_' || c >= utf8.RuneSelf && !unicode.IsSpace(c) ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// normalizeRule - convert the internationalized domain names in the filtering rule to punycode
+// and the rest of its pattern to lower case, so the rule matches the names in DNS requests
+// (e.g. /etc/hosts-style rules are case-sensitive).
+// Comments, regular expressions and modifiers aren't changed.
+// "||Пример.РФ^$important" -> "||xn--e1afmkfd.xn--p1ai^$important"
+func normalizeRule(line string) (string, error) {
+	s := strings.TrimSpace(line)
+	if len(s) == 0 || s[0] == '!' || s[0] == '#' || s[0] == '/' || strings.HasPrefix(s, "@@/") ||
+		util.IsASCII(s) && strings.ToLower(s) == s {
+		return line, nil
+	}
+
+	// the modifiers are kept as they are
+	pattern := line
+	modifiers := ""
+	if i := strings.LastIndexByte(line, '$'); i >= 0 {
+		pattern = line[:i]
+		modifiers = line[i:]
+	}
+
+	var b strings.Builder
+	for len(pattern) != 0 {
+		i := strings.IndexFunc(pattern, isDomainNameRune)
+		if i < 0 {
+			b.WriteString(pattern)
+			break
+		}
+		b.WriteString(pattern[:i])
+		pattern = pattern[i:]
+
+		n := strings.IndexFunc(pattern, func(c rune) bool { return !isDomainNameRune(c) })
+		if n < 0 {
+			n = len(pattern)
+		}
+		name := pattern[:n]
+		pattern = pattern[n:]
+		if util.IsASCII(name) {
+			b.WriteString(strings.ToLower(name))
+			continue
+		}
+		a, err := util.NormalizeDomain(name)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasSuffix(name, ".") {
+			a += "."
+		}
+		b.WriteString(a)
+	}
+	b.WriteString(modifiers)
+	return b.String(), nil
+}
+
+// normalizeRules - normalize the filtering rules
+// Return an error with the number of the line (starting with 1) if there's an invalid name.
+func normalizeRules(lines []string) ([]string, error) {
+	r := make([]string, len(lines))
+	for i, line := range lines {
+		n, err := normalizeRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		r[i] = n
+	}
+	return r, nil
+}
@@ -0,0 +1,84 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	filterListDefaultLimit = 100
+	filterListMaxLimit     = 1000
+)
+
+type filterListJSON struct {
+	Total   int                      `json:"total"` // the number of filters matching the query
+	Filters []filterUpdateStatusJSON `json:"filters"`
+}
+
+// listFilters - get the status of the filters in the order of the configuration
+// whitelist: "true": only the whitelist filters, "false": only the blocklist filters, "": both
+func listFilters(whitelist string) []filterUpdateStatusJSON {
+	list := []filterUpdateStatusJSON{}
+	config.RLock()
+	if whitelist != "true" {
+		for _, f := range config.Filters {
+			list = append(list, filterToUpdateStatusJSON(f, false))
+		}
+	}
+	if whitelist != "false" {
+		for _, f := range config.WhitelistFilters {
+			list = append(list, filterToUpdateStatusJSON(f, true))
+		}
+	}
+	config.RUnlock()
+	return list
+}
+
+// GET /control/filtering/list?whitelist=false&offset=0&limit=100
+func (f *Filtering) handleFilteringList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset := 0
+	limit := filterListDefaultLimit
+	var err error
+	if s := q.Get("offset"); len(s) != 0 {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			httpError(w, http.StatusBadRequest, "invalid offset %q", s)
+			return
+		}
+	}
+	if s := q.Get("limit"); len(s) != 0 {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > filterListMaxLimit {
+			httpError(w, http.StatusBadRequest, "invalid limit %q", s)
+			return
+		}
+	}
+	whitelist := q.Get("whitelist")
+	if whitelist != "" && whitelist != "true" && whitelist != "false" {
+		httpError(w, http.StatusBadRequest, "invalid whitelist %q", whitelist)
+		return
+	}
+
+	found := listFilters(whitelist)
+	resp := filterListJSON{
+		Total:   len(found),
+		Filters: []filterUpdateStatusJSON{},
+	}
+	if offset < len(found) {
+		end := offset + limit
+		if end > len(found) {
+			end = len(found)
+		}
+		resp.Filters = found[offset:end]
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
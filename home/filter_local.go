@@ -0,0 +1,99 @@
+package home
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// filterLocalPath - get the path of the local filter source (an absolute path to the file or directory, or "file://" URL)
+// Return false if the filter is downloaded by HTTP.
+func filterLocalPath(source string) (string, bool) {
+	if filepath.IsAbs(source) {
+		return source, true
+	}
+	if !strings.HasPrefix(source, "file://") {
+		return "", false
+	}
+	u, err := url.Parse(source)
+	if err != nil || (len(u.Host) != 0 && u.Host != "localhost") {
+		return "", false
+	}
+	p := filepath.FromSlash(u.Path)
+	if !filepath.IsAbs(p) {
+		// e.g. "file:///C:/rules.txt" on Windows
+		p = strings.TrimPrefix(p, string(filepath.Separator))
+	}
+	if !filepath.IsAbs(p) {
+		return "", false
+	}
+	return p, true
+}
+
+// localFilterFiles - get the files of the local filter source and their latest modification time
+// The files of the directory are sorted by name;  hidden files and subdirectories are skipped.
+func localFilterFiles(path string) ([]string, time.Time, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !st.IsDir() {
+		return []string{path}, st.ModTime(), nil
+	}
+
+	// the directory's time is changed when a file is added, removed or renamed
+	modTime := st.ModTime()
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.Mode().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		files = append(files, filepath.Join(path, e.Name()))
+		if e.ModTime().After(modTime) {
+			modTime = e.ModTime()
+		}
+	}
+	sort.Strings(files)
+	return files, modTime, nil
+}
+
+// openLocalFilter - open the files of the local filter source
+// The data of the files are joined with a new line between them.
+// Return the reader, the function that closes the files and the latest modification time.
+func openLocalFilter(path string) (io.Reader, func(), time.Time, error) {
+	files, modTime, err := localFilterFiles(path)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	var opened []*os.File
+	closeAll := func() {
+		for _, f := range opened {
+			_ = f.Close()
+		}
+	}
+	var readers []io.Reader
+	for _, fn := range files {
+		f, err := os.Open(fn)
+		if err != nil {
+			closeAll()
+			return nil, nil, time.Time{}, err
+		}
+		opened = append(opened, f)
+		if len(readers) != 0 {
+			readers = append(readers, bytes.NewReader([]byte("\n")))
+		}
+		readers = append(readers, f)
+	}
+	return io.MultiReader(readers...), closeAll, modTime, nil
+}
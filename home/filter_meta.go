@@ -0,0 +1,96 @@
+package home
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The limits of the update interval declared by the filter (in hours)
+const (
+	filterExpiresMin = 1
+	filterExpiresMax = 30 * 24
+)
+
+// filterMeta - the metadata declared in the comments in the header of the filter list
+// (e.g. "! Title: AdGuard DNS filter", "! Expires: 4 days (update frequency)")
+type filterMeta struct {
+	title    string
+	version  string
+	expires  uint32 // the update interval in hours (0: not declared)
+	homepage string
+	license  string // the license of the list, usually its name or URL (e.g. "GPLv3", "https://.../LICENSE")
+	diffPath string // the path of the next patch relative to the filter URL (see "Differential updates")
+}
+
+// parseLine - parse the comment line of the filter
+// Only the first value of each field is used.
+func (m *filterMeta) parseLine(line string) {
+	line = strings.TrimSpace(strings.TrimLeft(line, "!#"))
+	i := strings.IndexByte(line, ':')
+	if i <= 0 {
+		return
+	}
+	val := strings.TrimSpace(line[i+1:])
+	if len(val) == 0 {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(line[:i])) {
+	case "title":
+		if len(m.title) == 0 {
+			m.title = val
+		}
+	case "version":
+		if len(m.version) == 0 {
+			m.version = val
+		}
+	case "expires":
+		if m.expires == 0 {
+			m.expires = parseFilterExpires(val)
+		}
+	case "homepage":
+		if len(m.homepage) == 0 {
+			m.homepage = val
+		}
+	case "license", "licence":
+		if len(m.license) == 0 {
+			m.license = val
+		}
+	case "diff-path":
+		if len(m.diffPath) == 0 {
+			m.diffPath = val
+		}
+	}
+}
+
+// parseFilterExpires - get the update interval in hours from the value of "Expires" field
+// The value is a number of days or hours: "4 days (update frequency)", "12 hours", "1d", "6 h".
+// Return 0 if the value is invalid.
+func parseFilterExpires(s string) uint32 {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.ParseUint(s[:i], 10, 32)
+	if err != nil || n == 0 {
+		return 0
+	}
+	unit := strings.TrimSpace(s[i:])
+	if j := strings.IndexAny(unit, " ("); j >= 0 {
+		unit = unit[:j]
+	}
+	switch strings.ToLower(unit) {
+	case "", "d", "day", "days":
+		n *= 24
+	case "h", "hour", "hours":
+		//
+	default:
+		return 0
+	}
+
+	if n < filterExpiresMin {
+		n = filterExpiresMin
+	} else if n > filterExpiresMax {
+		n = filterExpiresMax
+	}
+	return uint32(n)
+}
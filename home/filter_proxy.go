@@ -0,0 +1,67 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// parseFiltersProxy - parse the proxy URL for the filter downloads: "(http|https|socks5)://[user:password@]host:port"
+// Return nil if the URL is empty.
+func parseFiltersProxy(s string) (*url.URL, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if len(u.Hostname()) == 0 || len(u.Port()) == 0 {
+		return nil, fmt.Errorf("host and port must be specified")
+	}
+	return u, nil
+}
+
+// The HTTP client for the filter downloads through the proxy
+var filtersProxyClient struct {
+	sync.Mutex
+	proxy  string // the proxy URL the client has been created for
+	client *http.Client
+}
+
+// filtersHTTPClient - get the HTTP client for the filter downloads
+// If dns.filters_proxy is set, the client connects through this proxy, otherwise the common client is used.
+func filtersHTTPClient() *http.Client {
+	config.RLock()
+	proxy := config.DNS.FiltersProxy
+	config.RUnlock()
+	if len(proxy) == 0 {
+		return Context.client
+	}
+
+	c := &filtersProxyClient
+	c.Lock()
+	defer c.Unlock()
+	if c.client != nil && c.proxy == proxy {
+		return c.client
+	}
+	u, err := parseFiltersProxy(proxy)
+	if err != nil {
+		// the configuration is validated:  this shouldn't happen
+		return Context.client
+	}
+	transport := Context.transport.Clone()
+	transport.Proxy = http.ProxyURL(u)
+	c.proxy = proxy
+	c.client = &http.Client{
+		Timeout:   Context.client.Timeout,
+		Transport: transport,
+	}
+	return c.client
+}
@@ -0,0 +1,286 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The time after which the registry is downloaded again
+const filterRegistryTTL = 6 * time.Hour
+
+// The maximum size of the registry data
+const filterRegistryMaxSize = 1024 * 1024
+
+// registryList - the filter list in the registry
+type registryList struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	URL         string   `json:"url"`
+	Homepage    string   `json:"homepage,omitempty"`
+	Category    string   `json:"category"`            // e.g. "general", "security", "privacy", "regional"
+	Languages   []string `json:"languages,omitempty"` // ISO 639-1 codes of the regional lists
+	RulesCount  int      `json:"rules_count"`         // as stated by the registry (0: unknown)
+}
+
+// filterRegistry - the registry of the filter lists
+type filterRegistry struct {
+	Lists []registryList `json:"lists"`
+}
+
+// The registry used if dns.filters_registry_url isn't set
+var builtinFilterRegistry = filterRegistry{
+	Lists: []registryList{
+		{ID: "adguard_dns", Name: "AdGuard DNS filter", URL: "https://adguardteam.github.io/AdGuardSDNSFilter/Filters/filter.txt",
+			Homepage: "https://github.com/AdguardTeam/AdGuardSDNSFilter", Category: "general"},
+		{ID: "adaway", Name: "AdAway", URL: "https://adaway.org/hosts.txt",
+			Homepage: "https://adaway.org/", Category: "general"},
+		{ID: "stevenblack_hosts", Name: "Steven Black's Unified Hosts", URL: "https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts",
+			Homepage: "https://github.com/StevenBlack/hosts", Category: "general"},
+		{ID: "urlhaus", Name: "Online Malicious URL Blocklist", URL: "https://curben.gitlab.io/malware-filter/urlhaus-filter-agh-online.txt",
+			Homepage: "https://gitlab.com/curben/urlhaus-filter", Category: "security"},
+		{ID: "phishing_army", Name: "Phishing Army", URL: "https://phishing.army/download/phishing_army_blocklist_extended.txt",
+			Homepage: "https://phishing.army/", Category: "security"},
+		{ID: "windows_spy", Name: "WindowsSpyBlocker - Hosts spy rules", URL: "https://raw.githubusercontent.com/crazy-max/WindowsSpyBlocker/master/data/hosts/spy.txt",
+			Homepage: "https://github.com/crazy-max/WindowsSpyBlocker", Category: "privacy"},
+		{ID: "easylist_china", Name: "EasyList China", URL: "https://easylist-downloads.adblockplus.org/easylistchina.txt",
+			Homepage: "https://github.com/easylist/easylistchina", Category: "regional", Languages: []string{"zh"}},
+		{ID: "ru_adlist", Name: "RU AdList", URL: "https://easylist-downloads.adblockplus.org/advblock.txt",
+			Homepage: "https://forums.lanik.us/viewforum.php?f=102", Category: "regional", Languages: []string{"ru"}},
+	},
+}
+
+// The downloaded registry
+var filterRegistryCache struct {
+	sync.Mutex
+	url     string
+	data    *filterRegistry
+	updated time.Time
+}
+
+// validate - check the registry data
+func (reg *filterRegistry) validate() error {
+	ids := map[string]bool{}
+	for i, l := range reg.Lists {
+		if len(l.ID) == 0 || len(l.URL) == 0 {
+			return fmt.Errorf("lists[%d]: id and url are required", i)
+		}
+		if ids[l.ID] {
+			return fmt.Errorf("lists[%d]: duplicate id %q", i, l.ID)
+		}
+		ids[l.ID] = true
+	}
+	return nil
+}
+
+// find - get the list by its ID
+func (reg *filterRegistry) find(id string) *registryList {
+	for i := range reg.Lists {
+		if reg.Lists[i].ID == id {
+			return &reg.Lists[i]
+		}
+	}
+	return nil
+}
+
+// fetchFilterRegistry - download the registry from URL or read it from the local file
+func fetchFilterRegistry(regURL string) (*filterRegistry, error) {
+	var data []byte
+	if p, ok := filterLocalPath(regURL); ok {
+		var err error
+		data, err = ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		resp, err := filtersHTTPClient().Get(regURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("got status code != 200: %d", resp.StatusCode)
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(resp.Body, filterRegistryMaxSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reg := &filterRegistry{}
+	err := json.Unmarshal(data, reg)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %s", err)
+	}
+	err = reg.validate()
+	if err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// getFilterRegistry - get the registry; it's downloaded again after filterRegistryTTL or if force is set
+// If the download fails, the previous data is used.
+func getFilterRegistry(force bool) (*filterRegistry, error) {
+	config.RLock()
+	regURL := config.DNS.FiltersRegistryURL
+	config.RUnlock()
+	if len(regURL) == 0 {
+		return &builtinFilterRegistry, nil
+	}
+
+	c := &filterRegistryCache
+	c.Lock()
+	defer c.Unlock()
+	if c.url == regURL && c.data != nil && !force && time.Since(c.updated) < filterRegistryTTL {
+		return c.data, nil
+	}
+
+	reg, err := fetchFilterRegistry(regURL)
+	if err != nil {
+		if c.url == regURL && c.data != nil {
+			log.Error("filters registry: %s: %s (using the previous data)", regURL, err)
+			return c.data, nil
+		}
+		return nil, fmt.Errorf("filters registry: %s: %s", regURL, err)
+	}
+	c.url = regURL
+	c.data = reg
+	c.updated = time.Now()
+	log.Debug("filters registry: %s: %d lists", regURL, len(reg.Lists))
+	return reg, nil
+}
+
+type registryListJSON struct {
+	registryList
+	Added bool `json:"added"` // the list is already in the filters or whitelist filters
+}
+
+type registryJSON struct {
+	Lists      []registryListJSON `json:"lists"`
+	Categories []string           `json:"categories"`
+	Languages  []string           `json:"languages"`
+}
+
+// filterRegistryToJSON - get the lists matching the category and language (empty: any)
+func filterRegistryToJSON(reg *filterRegistry, category, lang string) registryJSON {
+	resp := registryJSON{
+		Lists:      []registryListJSON{},
+		Categories: []string{},
+		Languages:  []string{},
+	}
+	cats := map[string]bool{}
+	langs := map[string]bool{}
+	for _, l := range reg.Lists {
+		if len(l.Category) != 0 && !cats[l.Category] {
+			cats[l.Category] = true
+			resp.Categories = append(resp.Categories, l.Category)
+		}
+		for _, ln := range l.Languages {
+			if !langs[ln] {
+				langs[ln] = true
+				resp.Languages = append(resp.Languages, ln)
+			}
+		}
+
+		if len(category) != 0 && !strings.EqualFold(l.Category, category) {
+			continue
+		}
+		if len(lang) != 0 && !containsFold(l.Languages, lang) {
+			continue
+		}
+		resp.Lists = append(resp.Lists, registryListJSON{
+			registryList: l,
+			Added:        filterExists(l.URL),
+		})
+	}
+	return resp
+}
+
+// containsFold - return TRUE if the list contains the string (case-insensitive)
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /control/filtering/registry?category=...&language=...&refresh=true
+func (f *Filtering) handleFilteringRegistry(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	reg, err := getFilterRegistry(q.Get("refresh") == "true")
+	if err != nil {
+		httpError(w, http.StatusBadGateway, "%s", err)
+		return
+	}
+
+	resp := filterRegistryToJSON(reg, q.Get("category"), q.Get("language"))
+	data, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+type registryAddJSON struct {
+	ID        string `json:"id"`
+	Whitelist bool   `json:"whitelist"`
+}
+
+// POST /control/filtering/registry/add
+func (f *Filtering) handleFilteringRegistryAdd(w http.ResponseWriter, r *http.Request) {
+	req := registryAddJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+
+	reg, err := getFilterRegistry(false)
+	if err != nil {
+		httpError(w, http.StatusBadGateway, "%s", err)
+		return
+	}
+	l := reg.find(req.ID)
+	if l == nil {
+		httpError(w, http.StatusBadRequest, "list %q not found in the registry", req.ID)
+		return
+	}
+	if filterExists(l.URL) {
+		httpError(w, http.StatusBadRequest, "Filter URL already added -- %s", l.URL)
+		return
+	}
+
+	filt := filter{
+		Enabled: true,
+		URL:     l.URL,
+		Name:    l.Name,
+		white:   req.Whitelist,
+	}
+	if validateFilterGroup(l.Category) == nil {
+		// the lists of the same category may be enabled or disabled at once
+		filt.Group = l.Category
+	}
+	err = f.addFilter(&filt)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "OK %d rules\n", filt.RulesCount)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Couldn't write body: %s", err)
+	}
+}
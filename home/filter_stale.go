@@ -0,0 +1,95 @@
+package home
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The reasons why a filter is considered stale (it may be a dead subscription)
+const (
+	filterStaleNone       = ""
+	filterStaleUnchanged  = "unchanged"  // the data hasn't changed for filters_stale_days
+	filterStaleFailing    = "failing"    // the updates have been failing for filterFailingAlertTime
+	filterStaleRedirected = "redirected" // the URL redirects to another host (e.g. a parking page)
+)
+
+// The filter is considered failing if its updates fail for this period
+const filterFailingAlertTime = 24 * time.Hour
+
+// redirectTarget - get the URL the request has been redirected to if it's on another host ("": not redirected)
+// The user name and password aren't returned.
+func redirectTarget(orig, final *url.URL) string {
+	if final == nil || strings.EqualFold(orig.Hostname(), final.Hostname()) {
+		return ""
+	}
+	u := *final
+	u.User = nil
+	return u.String()
+}
+
+// staleReason - get the reason why the filter is considered stale (filterStale*)
+// staleDays: the maximum number of days the data may stay unchanged (0: not checked)
+func (filter *filter) staleReason(now time.Time, staleDays uint32) string {
+	if !filter.Enabled {
+		return filterStaleNone
+	}
+	if len(filter.status.redirectURL) != 0 {
+		return filterStaleRedirected
+	}
+	if filter.status.Failures != 0 && !filter.status.FailingSince.IsZero() &&
+		now.Sub(filter.status.FailingSince) >= filterFailingAlertTime {
+		return filterStaleFailing
+	}
+	// the filters that aren't updated automatically aren't expected to change
+	if staleDays != 0 && filter.updateInterval() != 0 && !filter.status.Changed.IsZero() &&
+		now.Sub(filter.status.Changed) >= time.Duration(staleDays)*24*time.Hour {
+		return filterStaleUnchanged
+	}
+	return filterStaleNone
+}
+
+// staleText - get the description of the stale filter for the notification
+func (filter *filter) staleText(reason string) string {
+	name := filter.Name
+	if len(name) == 0 {
+		name = fmt.Sprintf("#%d", filter.ID)
+	}
+	switch reason {
+	case filterStaleRedirected:
+		return fmt.Sprintf("Filter %q (%s) is redirected to %s, the subscription may be dead.",
+			name, filter.URL, filter.status.redirectURL)
+	case filterStaleFailing:
+		return fmt.Sprintf("Filter %q (%s) has been failing to update since %s: %s",
+			name, filter.URL, filter.status.FailingSince.Format(time.RFC1123), filter.status.LastError)
+	default:
+		return fmt.Sprintf("Filter %q (%s) hasn't changed since %s, the list may be abandoned.",
+			name, filter.URL, filter.status.Changed.Format(time.RFC1123))
+	}
+}
+
+// checkFilters - notify about the stale filters
+func (n *notifier) checkFilters() {
+	now := time.Now()
+	type staleFilter struct {
+		url  string
+		text string
+	}
+	var list []staleFilter
+	config.RLock()
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for i := range filters {
+			f := &filters[i]
+			reason := f.staleReason(now, config.DNS.FiltersStaleDays)
+			if reason != filterStaleNone {
+				list = append(list, staleFilter{url: f.URL, text: f.staleText(reason)})
+			}
+		}
+	}
+	config.RUnlock()
+
+	for _, s := range list {
+		notify(notifyFilterStale, s.url, s.text)
+	}
+}
@@ -0,0 +1,76 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// filterStatusPath - get the path to the file with the update status of the filter
+func filterStatusPath(path string) string {
+	return path + ".status"
+}
+
+// saveStatus - store the update status, so the failures and the time of the last change are known after restart
+func (filter *filter) saveStatus() {
+	data, _ := json.Marshal(&filter.status)
+	err := ioutil.WriteFile(filterStatusPath(filter.Path()), data, 0644)
+	if err != nil {
+		log.Debug("filter: %s", err)
+	}
+}
+
+// loadStatus - restore the update status
+func (filter *filter) loadStatus() {
+	data, err := ioutil.ReadFile(filterStatusPath(filter.Path()))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Debug("filter: %s", err)
+		}
+		return
+	}
+	err = json.Unmarshal(data, &filter.status)
+	if err != nil {
+		log.Debug("filter: %s: %s", filterStatusPath(filter.Path()), err)
+		return
+	}
+
+	st := &filter.status
+	if st.Failures != 0 {
+		st.nextRetry = st.Checked.Add(filterRetryDelay(st.Failures, filter.updateInterval()))
+	}
+}
+
+// failureText - describe the consecutive failures of the filter updates, e.g.
+// "failing for 12 days (5 attempts): got status code != 200: 404"
+func (filter *filter) failureText(now time.Time) string {
+	st := &filter.status
+	if st.Failures == 0 {
+		return ""
+	}
+	d := now.Sub(st.FailingSince)
+	period := fmt.Sprintf("%d hours", int(d/time.Hour))
+	if d >= 48*time.Hour {
+		period = fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+	} else if d < time.Hour {
+		period = fmt.Sprintf("%d minutes", int(d/time.Minute))
+	}
+	return fmt.Sprintf("failing for %s (%d attempts): %s", period, st.Failures, st.LastError)
+}
+
+// countingReader - count the bytes read from the reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
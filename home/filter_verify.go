@@ -0,0 +1,124 @@
+package home
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// The result of the checksum verification of the last downloaded filter data
+const (
+	filterVerifyNone   = ""       // the filter has no pinned checksum
+	filterVerifyOK     = "ok"     // the checksum matches
+	filterVerifyFailed = "failed" // the checksum doesn't match or couldn't be downloaded
+)
+
+// The maximum size of the file with the checksum
+const filterChecksumMaxSize = 64 * 1024
+
+// isValidSHA256 - return TRUE if the string is a hex-encoded SHA-256 checksum
+func isValidSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// validateFilterChecksum - check the pinned checksum settings of the filter
+func validateFilterChecksum(sum, sumURL string) error {
+	if len(sum) != 0 && !isValidSHA256(sum) {
+		return fmt.Errorf("invalid sha256 %q", sum)
+	}
+	if len(sumURL) != 0 && !isValidURL(sumURL) {
+		return fmt.Errorf("invalid sha256_url %q", sumURL)
+	}
+	return nil
+}
+
+// parseSHA256Sum - get the checksum from the data in "sha256sum" format ("<hex>  <file name>" lines)
+// If there are several lines, the one with the file name of the filter is used.
+func parseSHA256Sum(data []byte, fileName string) (string, error) {
+	first := ""
+	r := bufio.NewScanner(bytes.NewReader(data))
+	for r.Scan() {
+		fields := strings.Fields(r.Text())
+		if len(fields) == 0 || !isValidSHA256(fields[0]) {
+			continue
+		}
+		if len(fields) >= 2 && strings.TrimPrefix(fields[1], "*") == fileName {
+			return strings.ToLower(fields[0]), nil
+		}
+		if len(first) == 0 {
+			first = strings.ToLower(fields[0])
+		}
+	}
+	if len(first) == 0 {
+		return "", fmt.Errorf("no SHA-256 checksum found")
+	}
+	return first, nil
+}
+
+// fetchFilterChecksum - download the file with the checksum of the filter data
+func fetchFilterChecksum(sumURL, filterURL string, headers map[string]string) (string, error) {
+	var data []byte
+	if p, ok := filterLocalPath(sumURL); ok {
+		var err error
+		data, err = ioutil.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		req, err := http.NewRequest("GET", sumURL, nil)
+		if err != nil {
+			return "", err
+		}
+		setFilterHeaders(req, filterURL, headers)
+		resp, err := filtersHTTPClient().Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("got status code != 200: %d", resp.StatusCode)
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(resp.Body, filterChecksumMaxSize))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	fileName := path.Base(filterURL)
+	if u, err := url.Parse(filterURL); err == nil && len(u.Path) != 0 {
+		fileName = path.Base(u.Path)
+	}
+	return parseSHA256Sum(data, fileName)
+}
+
+// verifyChecksum - check the SHA-256 checksum of the downloaded filter data against the pinned one
+func (filter *filter) verifyChecksum(sum string) error {
+	if len(filter.SHA256) == 0 && len(filter.SHA256URL) == 0 {
+		filter.status.verification = filterVerifyNone
+		return nil
+	}
+
+	filter.status.verification = filterVerifyFailed
+	if len(filter.SHA256) != 0 && !strings.EqualFold(filter.SHA256, sum) {
+		return fmt.Errorf("verification failed: SHA-256 is %s, expected %s", sum, strings.ToLower(filter.SHA256))
+	}
+	if len(filter.SHA256URL) != 0 {
+		expected, err := fetchFilterChecksum(filter.SHA256URL, filter.URL, filter.Headers)
+		if err != nil {
+			return fmt.Errorf("verification failed: %s: %s", filter.SHA256URL, err)
+		}
+		if expected != sum {
+			return fmt.Errorf("verification failed: SHA-256 is %s, expected %s", sum, expected)
+		}
+	}
+	filter.status.verification = filterVerifyOK
+	return nil
+}
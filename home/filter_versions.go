@@ -0,0 +1,160 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// filterVersionPath - get the path to the previous version of the filter (1: the latest one)
+func filterVersionPath(path string, version int) string {
+	return path + "." + strconv.Itoa(version)
+}
+
+// keepFilterVersion - move the current filter file to "<id>.txt.1" and shift the older versions
+// The versions older than keep are removed.
+func keepFilterVersion(path string, keep int) {
+	if keep == 0 {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	_ = os.Remove(filterVersionPath(path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		err := os.Rename(filterVersionPath(path, i), filterVersionPath(path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("os.Rename: %s", err)
+		}
+	}
+	err := os.Rename(path, filterVersionPath(path, 1))
+	if err != nil {
+		log.Error("os.Rename: %s", err)
+	}
+}
+
+// removeFilterVersions - remove the previous versions of the filter
+func removeFilterVersions(path string) {
+	files, _ := filepath.Glob(path + ".[0-9]*")
+	for _, fn := range files {
+		_ = os.Remove(fn)
+	}
+}
+
+// filterVersionsCount - get the number of the previous versions of the filter stored on disk
+func filterVersionsCount(path string) int {
+	n := 0
+	for {
+		_, err := os.Stat(filterVersionPath(path, n+1))
+		if err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+type filterRollbackReq struct {
+	URL       string `json:"url"`
+	Whitelist bool   `json:"whitelist"`
+	Version   int    `json:"version"` // 1: the latest previous version (default)
+}
+
+// rollback - swap the current filter file with its previous version and load it
+// Rolling back to the same version again restores the file that was current before.
+func (f *Filtering) rollback(req filterRollbackReq) error {
+	f.refreshLock.Lock()
+	defer f.refreshLock.Unlock()
+
+	config.Lock()
+	defer config.Unlock()
+
+	filters := &config.Filters
+	if req.Whitelist {
+		filters = &config.WhitelistFilters
+	}
+	var filt *filter
+	for i := range *filters {
+		if (*filters)[i].URL == req.URL {
+			filt = &(*filters)[i]
+			break
+		}
+	}
+	if filt == nil {
+		return fmt.Errorf("filter %s not found", req.URL)
+	}
+
+	path := filt.Path()
+	verPath := filterVersionPath(path, req.Version)
+	if _, err := os.Stat(verPath); err != nil {
+		return fmt.Errorf("version %d of filter %s not found", req.Version, req.URL)
+	}
+
+	tmpPath := path + ".tmp"
+	err := os.Rename(path, tmpPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Rename(verPath, path)
+	if err != nil {
+		_ = os.Rename(tmpPath, path)
+		return err
+	}
+	err = os.Rename(tmpPath, verPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error("os.Rename: %s", err)
+	}
+
+	// the next update is done after the update interval
+	now := time.Now()
+	err = os.Chtimes(path, now, now)
+	if err != nil {
+		log.Error("os.Chtimes: %s", err)
+	}
+
+	filt.status.Changed = now
+	filt.saveStatus()
+	err = f.load(filt)
+	if err != nil {
+		return err
+	}
+	// the data must be downloaded again, the checksum tells if it has changed
+	filt.etag = ""
+	filt.lastModified = ""
+	filt.sourceModTime = time.Time{}
+	filt.status.rulesDelta = 0
+	log.Info("Filter #%d has been rolled back to version %d: %d rules", filt.ID, req.Version, filt.RulesCount)
+	return nil
+}
+
+// POST /control/filtering/rollback
+func (f *Filtering) handleFilteringRollback(w http.ResponseWriter, r *http.Request) {
+	req := filterRollbackReq{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+	if req.Version == 0 {
+		req.Version = 1
+	}
+	if req.Version < 0 {
+		httpError(w, http.StatusBadRequest, "invalid version %d", req.Version)
+		return
+	}
+
+	err = f.rollback(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	enableFilters(true)
+	returnOK(w)
+}
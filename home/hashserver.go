@@ -0,0 +1,79 @@
+package home
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/AdGuardHome/hashserver"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// hashServerConfig - settings of the self-hosted Safe Browsing and Parental Control hash server
+type hashServerConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"` // UDP and TCP address to listen on
+
+	// Files with the host names (relative to the working directory)
+	SafeBrowsingFiles []string `yaml:"safebrowsing_files"`
+	ParentalFiles     []string `yaml:"parental_files"`
+}
+
+// validateHashServerConfig - check the settings of the hash server
+func validateHashServerConfig(c hashServerConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	_, _, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %s", c.Address, err)
+	}
+	if len(c.SafeBrowsingFiles) == 0 && len(c.ParentalFiles) == 0 {
+		return fmt.Errorf("no host lists")
+	}
+	return nil
+}
+
+// hashServerModule - serves the hashes for the Safe Browsing and Parental Control checks
+type hashServerModule struct {
+	srv *hashserver.Server
+}
+
+// newHashServer - create the hash server module
+// Return nil if it's disabled or the settings are invalid.
+func newHashServer(conf hashServerConfig) *hashServerModule {
+	if !conf.Enabled {
+		return nil
+	}
+	hconf := hashserver.Config{Addr: conf.Address}
+	for _, fn := range conf.SafeBrowsingFiles {
+		hconf.SafeBrowsingFiles = append(hconf.SafeBrowsingFiles, Context.getStorageDir(fn))
+	}
+	for _, fn := range conf.ParentalFiles {
+		hconf.ParentalFiles = append(hconf.ParentalFiles, Context.getStorageDir(fn))
+	}
+	srv, err := hashserver.New(hconf)
+	if err != nil {
+		log.Error("hash server: %s", err)
+		return nil
+	}
+	return &hashServerModule{srv: srv}
+}
+
+// Start - start listening
+func (m *hashServerModule) Start() {
+	if m == nil {
+		return
+	}
+	err := m.srv.Start()
+	if err != nil {
+		log.Error("hash server: %s", err)
+	}
+}
+
+// Close - stop the server
+func (m *hashServerModule) Close() {
+	if m == nil {
+		return
+	}
+	m.srv.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -32,6 +33,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/querylog"
 	"github.com/AdguardTeam/AdGuardHome/stats"
+	"github.com/AdguardTeam/AdGuardHome/tracing"
 	"github.com/AdguardTeam/golibs/log"
 )
 
@@ -65,17 +67,29 @@ type homeContext struct {
 	filters    Filtering            // DNS filtering module
 	web        *Web                 // Web (HTTP, HTTPS) module
 	tls        *TLSMod              // TLS module
+	notifier   *notifier            // Notification module
+	mqtt       *mqttModule          // MQTT module
+	snmp       *snmpModule          // SNMP agent
+	ipfix      *ipfixModule         // IPFIX exporter
+	hashServer *hashServerModule    // Safe Browsing and Parental Control hash server
+	tracer     *tracing.Tracer      // OpenTelemetry tracer
+	failover   *failoverModule      // hot-standby failover
+	intercept  *interceptModule     // transparent DNS interception
+	unblock    *unblockModule       // the unblock requests waiting for approval
 	autoHosts  util.AutoHosts       // IP-hostname pairs taken from system configuration (e.g. /etc/hosts) files
 	updater    *update.Updater
 
 	// Runtime properties
 	// --
 
-	configFilename   string // Config filename (can be overridden via the command line arguments)
-	workDir          string // Location of our directory, used to protect against CWD being somewhere else
-	firstRun         bool   // if set to true, don't run any services except HTTP web inteface, and serve only first-run html
-	pidFileName      string // PID file name.  Empty if no PID file was created.
-	disableUpdate    bool   // If set, don't check for updates
+	configFilename   string        // Config filename (can be overridden via the command line arguments)
+	configStorage    configStorage // Remote configuration storage (nil: the local file only)
+	workDir          string        // Location of our directory, used to protect against CWD being somewhere else
+	firstRun         bool          // if set to true, don't run any services except HTTP web inteface, and serve only first-run html
+	pidFileName      string        // PID file name.  Empty if no PID file was created.
+	logFilePath      string        // Path to the log file.  Empty if the log isn't written to a file.
+	disableUpdate    bool          // If set, don't check for updates
+	netAdminDropped  bool          // CAP_NET_ADMIN capability has been dropped with the privileges
 	controlLock      sync.Mutex
 	tlsRoots         *x509.CertPool // list of root CAs for TLSv1.2
 	tlsCiphers       []uint16       // list of TLS ciphers to use
@@ -91,6 +105,18 @@ func (c *homeContext) getDataDir() string {
 	return filepath.Join(c.workDir, dataDir)
 }
 
+// getStorageDir returns path to the directory where we store query log or statistics
+// dir: the configured path (relative to the working directory);  if empty, the data directory is used
+func (c *homeContext) getStorageDir(dir string) string {
+	if len(dir) == 0 {
+		return c.getDataDir()
+	}
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(c.workDir, dir)
+}
+
 // Context - a global context object
 var Context homeContext
 
@@ -102,6 +128,11 @@ func Main(version string, channel string, armVer string) {
 	ARMVersion = armVer
 	versionCheckURL = "https://static.adguard.com/adguardhome/" + updateChannel + "/version.json"
 
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+
 	// config can be specified, which reads options from there, but other command line flags have to override config values
 	// therefore, we must do it manually instead of using a lib
 	args := loadOptions()
@@ -158,6 +189,19 @@ func run(args options) {
 	// configure working dir and config path
 	initWorkingDir(args)
 
+	// the local config file is replaced with the remote one before anything reads it
+	if len(args.configRemote) != 0 {
+		var err error
+		Context.configStorage, err = newConfigStorage(args.configRemote)
+		if err != nil {
+			log.Fatalf("Remote configuration storage: %s", err)
+		}
+		err = pullRemoteConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// configure log level and output
 	configureLogger(args)
 
@@ -201,6 +245,7 @@ func run(args options) {
 
 		err = parseConfig()
 		if err != nil {
+			logLastConfigSnapshot()
 			log.Error("Failed to parse configuration, exiting")
 			os.Exit(1)
 		}
@@ -209,6 +254,14 @@ func run(args options) {
 			log.Info("Configuration file is OK")
 			os.Exit(0)
 		}
+
+		// the file may have been edited manually
+		data, err := readConfigFile()
+		if err == nil {
+			saveConfigSnapshot(data)
+		}
+
+		initLowResourceMode()
 	}
 
 	// 'clients' module uses 'dnsfilter' module's static data (dnsfilter.BlockedSvcKnown()),
@@ -236,8 +289,18 @@ func run(args options) {
 		ARMVersion:    ARMVersion,
 		ConfigName:    config.getConfigFilename(),
 	})
+	verify, rollback := Context.updater.BeginVerification()
+	if rollback {
+		// the new version has failed to start last time
+		err := Context.updater.Rollback()
+		if err != nil {
+			log.Fatalf("Couldn't roll back the update: %s", err)
+		}
+		restart()
+	}
 
 	Context.clients.Init(config.Clients, Context.dhcpServer, &Context.autoHosts)
+	Context.clients.setInternetOff(config.InternetOff)
 	config.Clients = nil
 
 	if (runtime.GOOS == "linux" || runtime.GOOS == "darwin") &&
@@ -247,7 +310,7 @@ func run(args options) {
 
 	// override bind host/port from the console
 	if args.bindHost != "" {
-		config.BindHost = args.bindHost
+		config.BindHost = util.TrimBrackets(args.bindHost)
 	}
 	if args.bindPort != 0 {
 		config.BindPort = args.bindPort
@@ -278,13 +341,26 @@ func run(args options) {
 	if err != nil {
 		log.Fatalf("Cannot create DNS data dir at %s: %s", Context.getDataDir(), err)
 	}
+	for _, dir := range []string{config.DNS.QueryLogDir, config.DNS.StatsDir} {
+		dir = Context.getStorageDir(dir)
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			log.Fatalf("Cannot create storage dir at %s: %s", dir, err)
+		}
+	}
+
+	if !Context.firstRun {
+		dropPrivileges()
+	}
 
 	sessFilename := filepath.Join(Context.getDataDir(), "sessions.db")
 	GLMode = args.glinetMode
-	Context.auth = InitAuth(sessFilename, config.Users, config.WebSessionTTLHours*60*60)
+	rateLimiter := newAuthRateLimiter(time.Duration(config.AuthBlockMin)*time.Minute, config.AuthAttempts)
+	Context.auth = InitAuth(sessFilename, config.Users, config.WebSessionTTLHours*60*60, rateLimiter)
 	if Context.auth == nil {
 		log.Fatalf("Couldn't initialize Auth module")
 	}
+	Context.auth.InitBackends(config.LDAP, config.OIDC)
 	config.Users = nil
 
 	Context.tls = tlsCreate(config.TLS)
@@ -296,12 +372,26 @@ func run(args options) {
 		firstRun: Context.firstRun,
 		BindHost: config.BindHost,
 		BindPort: config.BindPort,
+
+		AllowedClients: config.WebAllowedClients,
+		TrustedProxies: config.TrustedProxies,
+		Listeners:      webListeners(),
 	}
 	Context.web = CreateWeb(&webConf)
 	if Context.web == nil {
 		log.Fatalf("Can't initialize Web module")
 	}
 
+	Context.notifier = newNotifier(config.Notifications, Context.client)
+	Context.mqtt = newMQTT(config.MQTT)
+	Context.snmp = newSNMP(config.SNMP)
+	Context.ipfix = newIPFIX(config.IPFIX)
+	Context.hashServer = newHashServer(config.HashServer)
+	Context.tracer = newTracer(config.Tracing)
+	Context.failover = newFailover(config.Failover)
+	Context.intercept = newIntercept(config.DNSIntercept)
+	Context.unblock = newUnblock(config.UnblockRequests)
+
 	if !Context.firstRun {
 		err := initDNSServer()
 		if err != nil {
@@ -317,10 +407,28 @@ func run(args options) {
 			}
 		}()
 
-		err = startDHCPServer()
-		if err != nil {
-			log.Fatal(err)
+		// with failover enabled, DHCP server is started when this instance becomes master
+		if Context.failover == nil {
+			err = startDHCPServer()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if verify {
+			go verifyUpdate()
 		}
+		startAutoUpdate()
+		Context.notifier.Start()
+		Context.mqtt.Start()
+		Context.snmp.Start()
+		Context.ipfix.Start()
+		Context.hashServer.Start()
+		Context.tracer.Start()
+		Context.failover.Start()
+		Context.intercept.Start()
+	} else if verify {
+		Context.updater.ConfirmUpdate()
 	}
 
 	Context.web.Start()
@@ -438,13 +546,6 @@ func configureLogger(args options) {
 		ls.LogFile = config.LogFile
 	}
 
-	// Handle default log settings overrides
-	ls.LogCompress = config.LogCompress
-	ls.LogLocalTime = config.LogLocalTime
-	ls.LogMaxBackups = config.LogMaxBackups
-	ls.LogMaxSize = config.LogMaxSize
-	ls.LogMaxAge = config.LogMaxAge
-
 	// log.SetLevel(log.INFO) - default
 	if ls.Verbose {
 		log.SetLevel(log.DEBUG)
@@ -473,25 +574,61 @@ func configureLogger(args options) {
 			logFilePath = ls.LogFile
 		}
 
-		_, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		f, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 		if err != nil {
 			log.Fatalf("cannot create a log file: %s", err)
 		}
+		_ = f.Close()
+		Context.logFilePath = logFilePath
 
-		log.SetOutput(&lumberjack.Logger{
+		logger := &lumberjack.Logger{
 			Filename:   logFilePath,
 			Compress:   ls.LogCompress, // disabled by default
 			LocalTime:  ls.LogLocalTime,
 			MaxBackups: ls.LogMaxBackups,
 			MaxSize:    ls.LogMaxSize, // megabytes
 			MaxAge:     ls.LogMaxAge,  //days
-		})
+		}
+		var w io.Writer = logger
+		if args.runningAsService && runtime.GOOS == "windows" {
+			// Errors are also written to the Event Log where administrators expect them
+			elw, err := util.ErrorLogWriter(serviceName)
+			if err != nil {
+				log.Error("cannot open the Event Log: %s", err)
+			} else {
+				w = io.MultiWriter(logger, elw)
+			}
+		}
+		log.SetOutput(w)
+
+		if ls.LogRotateInterval > 0 {
+			go logRotateLoop(logger, time.Duration(ls.LogRotateInterval)*time.Hour)
+		}
+	}
+}
+
+// logRotateLoop - rotate the log file periodically
+func logRotateLoop(logger *lumberjack.Logger, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := logger.Rotate()
+		if err != nil {
+			log.Error("cannot rotate the log file: %s", err)
+		}
 	}
 }
 
 func cleanup() {
 	log.Info("Stopping AdGuard Home")
 
+	// DNS-over-HTTPS requests are processed by Web module, so it's stopped after draining
+	drainDNSServer()
+
+	// the virtual IP is released after the requests are drained
+	if Context.failover != nil {
+		Context.failover.Close()
+		Context.failover = nil
+	}
+
 	if Context.web != nil {
 		Context.web.Close()
 		Context.web = nil
@@ -512,6 +649,35 @@ func cleanup() {
 
 	Context.autoHosts.Close()
 
+	if Context.mqtt != nil {
+		Context.mqtt.Close()
+		Context.mqtt = nil
+	}
+
+	if Context.snmp != nil {
+		Context.snmp.Close()
+		Context.snmp = nil
+	}
+
+	if Context.ipfix != nil {
+		Context.ipfix.Close()
+		Context.ipfix = nil
+	}
+
+	if Context.hashServer != nil {
+		Context.hashServer.Close()
+		Context.hashServer = nil
+	}
+
+	if Context.tracer != nil {
+		Context.tracer.Close()
+		Context.tracer = nil
+	}
+
+	if Context.intercept != nil {
+		Context.intercept.Close()
+	}
+
 	if Context.tls != nil {
 		Context.tls.Close()
 		Context.tls = nil
@@ -530,6 +696,7 @@ func cleanupAlways() {
 type options struct {
 	verbose        bool   // is verbose logging enabled
 	configFilename string // path to the config file
+	configRemote   string // URL of the remote configuration storage
 	workDir        string // path to the working directory where we will store the filters data and the querylog
 	bindHost       string // host address to bind HTTP server on
 	bindPort       int    // port to serve HTTP pages on
@@ -560,6 +727,9 @@ func loadOptions() options {
 		callbackNoValue   func()
 	}{
 		{"config", "c", "Path to the config file", func(value string) { o.configFilename = value }, nil},
+		{"config-remote", "", "URL of the remote config storage: consul://host:port/key, etcd://host:port/key or s3://bucket/key", func(value string) {
+			o.configRemote = value
+		}, nil},
 		{"work-dir", "w", "Path to the working directory", func(value string) { o.workDir = value }, nil},
 		{"host", "h", "Host address to bind HTTP server on", func(value string) { o.bindHost = value }, nil},
 		{"port", "p", "Port to serve HTTP pages on", func(value string) {
@@ -591,7 +761,8 @@ func loadOptions() options {
 	}
 	printHelp = func() {
 		fmt.Printf("Usage:\n\n")
-		fmt.Printf("%s [options]\n\n", os.Args[0])
+		fmt.Printf("%s [options]\n", os.Args[0])
+		fmt.Printf("%s bench [options]   Load-test DNS server, see '%s bench --help'\n\n", os.Args[0], os.Args[0])
 		fmt.Printf("Options:\n")
 		for _, opt := range opts {
 			val := ""
@@ -655,7 +826,7 @@ func printHTTPAddresses(proto string) {
 		} else {
 			log.Printf("Go to https://%s:%s", tlsConf.ServerName, port)
 		}
-	} else if config.BindHost == "0.0.0.0" {
+	} else if util.IsUnspecifiedHost(config.BindHost) {
 		log.Println("AdGuard Home is available on the following addresses:")
 		ifaces, err := util.GetValidNetInterfacesForWeb()
 		if err != nil {
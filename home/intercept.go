@@ -0,0 +1,251 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// interceptConfig - transparent DNS interception settings
+type interceptConfig struct {
+	Enabled    bool     `yaml:"enabled" json:"enabled"`
+	Interfaces []string `yaml:"interfaces" json:"interfaces"` // the interfaces to intercept DNS traffic on (empty: all)
+	Exclude    []string `yaml:"exclude" json:"exclude"`       // client IP addresses or subnets whose traffic isn't redirected
+}
+
+// The name of nftables table with the redirect rules
+const interceptTable = "adguardhome_intercept"
+
+// interceptModule - redirects DNS traffic of the clients that ignore DHCP-provided DNS server to our DNS server
+type interceptModule struct {
+	lock   sync.Mutex
+	conf   interceptConfig
+	active bool   // the rules are installed
+	err    string // the last error
+}
+
+// newIntercept - create the module
+func newIntercept(conf interceptConfig) *interceptModule {
+	return &interceptModule{conf: conf}
+}
+
+// Start - install the rules if enabled
+func (m *interceptModule) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.conf.Enabled {
+		m.applyLocked()
+	}
+}
+
+// Close - remove the rules
+func (m *interceptModule) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.removeLocked()
+}
+
+// WriteDiskConfig - write configuration
+func (m *interceptModule) WriteDiskConfig(c *interceptConfig) {
+	m.lock.Lock()
+	*c = m.conf
+	c.Interfaces = stringArrayDup(m.conf.Interfaces)
+	c.Exclude = stringArrayDup(m.conf.Exclude)
+	m.lock.Unlock()
+}
+
+// setConfig - apply new configuration
+func (m *interceptModule) setConfig(conf interceptConfig) error {
+	err := validateInterceptConfig(conf)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.conf = conf
+	m.removeLocked()
+	if m.conf.Enabled {
+		m.applyLocked()
+		if len(m.err) != 0 {
+			return fmt.Errorf("%s", m.err)
+		}
+	}
+	return nil
+}
+
+func (m *interceptModule) applyLocked() {
+	bindHost := net.ParseIP(config.DNS.BindHost)
+	err := nftApply(nftRuleset(m.conf, bindHost, config.DNS.Port))
+	if err != nil {
+		m.err = err.Error()
+		log.Error("DNS intercept: %s", err)
+		return
+	}
+	m.active = true
+	m.err = ""
+	log.Info("DNS intercept: redirecting DNS traffic to port %d", config.DNS.Port)
+}
+
+func (m *interceptModule) removeLocked() {
+	if !m.active {
+		return
+	}
+	err := nftRemove()
+	if err != nil {
+		log.Error("DNS intercept: %s", err)
+	}
+	m.active = false
+	log.Info("DNS intercept: removed the redirect rules")
+}
+
+// Linux interface name: up to 15 characters
+var ifaceNameRe = regexp.MustCompile(`^[A-Za-z0-9_.@:+-]{1,15}$`)
+
+func validateInterceptConfig(c interceptConfig) error {
+	for _, iface := range c.Interfaces {
+		if !ifaceNameRe.MatchString(iface) {
+			return fmt.Errorf("invalid interface name: %q", iface)
+		}
+	}
+	for _, s := range c.Exclude {
+		if strings.IndexByte(s, '/') >= 0 {
+			_, _, err := net.ParseCIDR(s)
+			if err != nil {
+				return fmt.Errorf("invalid subnet: %q", s)
+			}
+		} else if net.ParseIP(s) == nil {
+			return fmt.Errorf("invalid IP address: %q", s)
+		}
+	}
+	return nil
+}
+
+// nftRuleset - get nftables script that (re)creates the table with the redirect rules
+// DNS traffic is redirected to the port of our DNS server on the incoming interface address,
+// or to bind_host if DNS server listens on a specific address.
+func nftRuleset(c interceptConfig, bindHost net.IP, port int) string {
+	exclude4 := []string{}
+	exclude6 := []string{}
+	for _, s := range c.Exclude {
+		if strings.Contains(s, ":") {
+			exclude6 = append(exclude6, s)
+		} else {
+			exclude4 = append(exclude4, s)
+		}
+	}
+
+	match := "meta l4proto { tcp, udp } th dport 53"
+	if len(c.Interfaces) != 0 {
+		names := []string{}
+		for _, iface := range c.Interfaces {
+			names = append(names, strconv.Quote(iface))
+		}
+		match = "iifname { " + strings.Join(names, ", ") + " } " + match
+	}
+
+	b := &strings.Builder{}
+	// the table is created (if doesn't exist) and deleted so the new rules replace the old ones atomically
+	fmt.Fprintf(b, "table inet %s\n", interceptTable)
+	fmt.Fprintf(b, "delete table inet %s\n", interceptTable)
+	fmt.Fprintf(b, "table inet %s {\n", interceptTable)
+	fmt.Fprintf(b, "\tchain prerouting {\n")
+	fmt.Fprintf(b, "\t\ttype nat hook prerouting priority -100; policy accept;\n")
+	if len(exclude4) != 0 {
+		fmt.Fprintf(b, "\t\tip saddr { %s } return\n", strings.Join(exclude4, ", "))
+	}
+	if len(exclude6) != 0 {
+		fmt.Fprintf(b, "\t\tip6 saddr { %s } return\n", strings.Join(exclude6, ", "))
+	}
+	switch {
+	case bindHost == nil || bindHost.IsUnspecified():
+		fmt.Fprintf(b, "\t\t%s counter redirect to :%d\n", match, port)
+	case bindHost.To4() != nil:
+		fmt.Fprintf(b, "\t\tmeta nfproto ipv4 %s counter dnat ip to %s:%d\n", match, bindHost, port)
+	default:
+		fmt.Fprintf(b, "\t\tmeta nfproto ipv6 %s counter dnat ip6 to [%s]:%d\n", match, bindHost, port)
+	}
+	fmt.Fprintf(b, "\t}\n")
+	fmt.Fprintf(b, "}\n")
+	return b.String()
+}
+
+var nftCounterRe = regexp.MustCompile(`counter packets (\d+) bytes \d+`)
+
+// nftParseCounter - get the number of packets from "nft list table" output
+func nftParseCounter(s string) uint64 {
+	n := uint64(0)
+	for _, m := range nftCounterRe.FindAllStringSubmatch(s, -1) {
+		v, _ := strconv.ParseUint(m[1], 10, 64)
+		n += v
+	}
+	return n
+}
+
+type interceptStatusJSON struct {
+	interceptConfig
+	Supported         bool   `json:"supported"`
+	Active            bool   `json:"active"`
+	RedirectedPackets uint64 `json:"redirected_packets"`
+	Error             string `json:"error,omitempty"`
+}
+
+func handleInterceptStatus(w http.ResponseWriter, r *http.Request) {
+	m := Context.intercept
+	resp := interceptStatusJSON{Supported: interceptSupported}
+	m.WriteDiskConfig(&resp.interceptConfig)
+
+	m.lock.Lock()
+	resp.Active = m.active
+	resp.Error = m.err
+	m.lock.Unlock()
+
+	if resp.Active {
+		out, err := nftList()
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.RedirectedPackets = nftParseCounter(out)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+func handleInterceptConfig(w http.ResponseWriter, r *http.Request) {
+	req := interceptConfig{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+	if req.Enabled && !interceptSupported {
+		httpError(w, http.StatusBadRequest, "DNS interception isn't supported on this OS")
+		return
+	}
+	if req.Enabled && Context.netAdminDropped {
+		httpError(w, http.StatusBadRequest, "DNS interception needs CAP_NET_ADMIN capability, which has been dropped with the privileges:"+
+			"  enable dns_intercept in the configuration file and restart")
+		return
+	}
+
+	err = Context.intercept.setConfig(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	onConfigModified()
+	returnOK(w)
+}
@@ -0,0 +1,42 @@
+// +build linux
+
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const interceptSupported = true
+
+// nft - run nft command with the script on stdin
+func nft(stdin string, args ...string) (string, error) {
+	cmd := exec.Command("nft", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("nft %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// nftApply - load the ruleset
+func nftApply(ruleset string) error {
+	_, err := nft(ruleset, "-f", "-")
+	return err
+}
+
+// nftRemove - delete the table with the redirect rules
+func nftRemove() error {
+	_, err := nft("", "delete", "table", "inet", interceptTable)
+	return err
+}
+
+// nftList - get the table with the redirect rules
+func nftList() (string, error) {
+	return nft("", "list", "table", "inet", interceptTable)
+}
@@ -0,0 +1,21 @@
+// +build !linux
+
+package home
+
+import "fmt"
+
+const interceptSupported = false
+
+var errInterceptNotSupported = fmt.Errorf("DNS interception is supported only on Linux")
+
+func nftApply(ruleset string) error {
+	return errInterceptNotSupported
+}
+
+func nftRemove() error {
+	return errInterceptNotSupported
+}
+
+func nftList() (string, error) {
+	return "", errInterceptNotSupported
+}
@@ -0,0 +1,54 @@
+package home
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNftRuleset(t *testing.T) {
+	c := interceptConfig{
+		Interfaces: []string{"br-lan", "eth1"},
+		Exclude:    []string{"192.168.1.5", "10.0.0.0/8", "fd00::/8"},
+	}
+	s := nftRuleset(c, net.ParseIP("0.0.0.0"), 5353)
+	assert.True(t, strings.HasPrefix(s, "table inet adguardhome_intercept\ndelete table inet adguardhome_intercept\n"))
+	assert.True(t, strings.Contains(s, "\t\tip saddr { 192.168.1.5, 10.0.0.0/8 } return\n"))
+	assert.True(t, strings.Contains(s, "\t\tip6 saddr { fd00::/8 } return\n"))
+	assert.True(t, strings.Contains(s,
+		"\t\tiifname { \"br-lan\", \"eth1\" } meta l4proto { tcp, udp } th dport 53 counter redirect to :5353\n"))
+
+	// all interfaces, DNS server listens on a specific address
+	s = nftRuleset(interceptConfig{}, net.ParseIP("192.168.1.1"), 53)
+	assert.False(t, strings.Contains(s, "saddr"))
+	assert.True(t, strings.Contains(s,
+		"\t\tmeta nfproto ipv4 meta l4proto { tcp, udp } th dport 53 counter dnat ip to 192.168.1.1:53\n"))
+
+	s = nftRuleset(interceptConfig{}, net.ParseIP("2001:db8::1"), 53)
+	assert.True(t, strings.Contains(s, "counter dnat ip6 to [2001:db8::1]:53\n"))
+}
+
+func TestValidateInterceptConfig(t *testing.T) {
+	assert.Nil(t, validateInterceptConfig(interceptConfig{
+		Interfaces: []string{"eth0", "br-lan", "eth0.100"},
+		Exclude:    []string{"192.168.1.5", "10.0.0.0/8", "::1"},
+	}))
+	assert.NotNil(t, validateInterceptConfig(interceptConfig{Interfaces: []string{"eth0\" accept"}}))
+	assert.NotNil(t, validateInterceptConfig(interceptConfig{Interfaces: []string{"averyveryverylongname"}}))
+	assert.NotNil(t, validateInterceptConfig(interceptConfig{Exclude: []string{"host"}}))
+	assert.NotNil(t, validateInterceptConfig(interceptConfig{Exclude: []string{"10.0.0.0/33"}}))
+}
+
+func TestNftParseCounter(t *testing.T) {
+	out := `table inet adguardhome_intercept {
+	chain prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+		ip saddr { 192.168.1.5 } return
+		meta l4proto { tcp, udp } th dport 53 counter packets 1234 bytes 98765 redirect to :53
+	}
+}`
+	assert.Equal(t, uint64(1234), nftParseCounter(out))
+	assert.Equal(t, uint64(0), nftParseCounter(""))
+}
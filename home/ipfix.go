@@ -0,0 +1,93 @@
+package home
+
+import (
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/ipfix"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// ipfixConfig - settings of DNS query records export to a flow collector
+type ipfixConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Collector string `yaml:"collector"` // UDP address of the collector
+	Version   int    `yaml:"version"`   // 10: IPFIX, 9: NetFlow v9
+
+	// How the host name is exported:
+	//  "hash": 8 bytes of SHA-256 hash of hash_salt and the host name
+	//  "plain": the host name as is
+	//  "none": the host name isn't exported
+	QName    string `yaml:"qname"`
+	HashSalt string `yaml:"hash_salt"`
+
+	ObservationDomain uint32 `yaml:"observation_domain"`
+	EnterpriseNumber  uint32 `yaml:"enterprise_number"` // for the DNS information elements
+}
+
+// ipfixModule - exports the processed DNS requests
+type ipfixModule struct {
+	exporter *ipfix.Exporter
+}
+
+// newIPFIX - create IPFIX module
+// Return nil if it's disabled or the settings are invalid.
+func newIPFIX(conf ipfixConfig) *ipfixModule {
+	if !conf.Enabled {
+		return nil
+	}
+	e, err := ipfix.New(ipfix.Config{
+		Collector:         conf.Collector,
+		Version:           conf.Version,
+		QName:             conf.QName,
+		HashSalt:          conf.HashSalt,
+		ObservationDomain: conf.ObservationDomain,
+		EnterpriseNumber:  conf.EnterpriseNumber,
+	})
+	if err != nil {
+		log.Error("IPFIX: %s", err)
+		return nil
+	}
+	return &ipfixModule{exporter: e}
+}
+
+// Start - connect to the collector
+func (m *ipfixModule) Start() {
+	if m == nil {
+		return
+	}
+	err := m.exporter.Start()
+	if err != nil {
+		log.Error("IPFIX: %s", err)
+	}
+}
+
+// Close - send the pending records and stop
+func (m *ipfixModule) Close() {
+	if m == nil {
+		return
+	}
+	m.exporter.Close()
+}
+
+// onResponse - add the record of the processed request
+func (m *ipfixModule) onResponse(q dnsforward.QueryInfo) {
+	if m == nil {
+		return
+	}
+	m.exporter.Add(ipfix.Record{
+		Time:       q.Time,
+		ClientIP:   q.ClientIP,
+		ClientPort: q.ClientPort,
+		TCP:        q.Proto != "udp",
+		QName:      q.Host,
+		QType:      q.QType,
+		Rcode:      q.Rcode,
+		ReqSize:    q.ReqSize,
+		RespSize:   q.RespSize,
+		Filtered:   q.Filtered,
+		Elapsed:    q.Elapsed,
+	})
+}
+
+func onDNSResponse(q dnsforward.QueryInfo) {
+	Context.ipfix.onResponse(q)
+}
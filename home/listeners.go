@@ -0,0 +1,229 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// listenerConfig - the addresses on one network interface (e.g. VLAN) with their own access settings
+type listenerConfig struct {
+	Interface string   `yaml:"interface" json:"interface"` // listen on all IP addresses of this interface
+	Addresses []string `yaml:"addresses" json:"addresses"` // or on these IP addresses
+
+	DNS bool `yaml:"dns" json:"dns"` // plain DNS on dns.port
+	TLS bool `yaml:"tls" json:"tls"` // DNS-over-TLS on tls.port_dns_over_tls
+	Web bool `yaml:"web" json:"web"` // web interface and DNS-over-HTTPS on bind_port and tls.port_https
+
+	// The ports used instead of dns.port and tls.port_dns_over_tls on these addresses (0: the global port)
+	DNSPort int `yaml:"dns_port,omitempty" json:"dns_port"`
+	TLSPort int `yaml:"tls_port,omitempty" json:"tls_port"`
+
+	// The clients that may (may not) use DNS server and web interface on these addresses
+	AllowedClients    []string `yaml:"allowed_clients" json:"allowed_clients"`
+	DisallowedClients []string `yaml:"disallowed_clients" json:"disallowed_clients"`
+}
+
+// dnsPort - get the port of plain DNS on the listener's addresses
+func (l *listenerConfig) dnsPort() int {
+	if l.DNSPort != 0 {
+		return l.DNSPort
+	}
+	return config.DNS.Port
+}
+
+// tlsPort - get the port of DNS-over-TLS on the listener's addresses
+func (l *listenerConfig) tlsPort(tlsConf tlsConfigSettings) int {
+	if l.TLSPort != 0 {
+		return l.TLSPort
+	}
+	return tlsConf.PortDNSOverTLS
+}
+
+// validateListeners - check the listeners settings
+func validateListeners(list []listenerConfig) error {
+	for i, l := range list {
+		if (len(l.Interface) == 0) == (len(l.Addresses) == 0) {
+			return fmt.Errorf("#%d: either interface or addresses must be specified", i)
+		}
+		if len(l.Interface) != 0 && !ifaceNameRe.MatchString(l.Interface) {
+			return fmt.Errorf("#%d: invalid interface name: %q", i, l.Interface)
+		}
+		for _, a := range l.Addresses {
+			if net.ParseIP(util.TrimBrackets(a)) == nil {
+				return fmt.Errorf("#%d: invalid IP address: %q", i, a)
+			}
+		}
+		if !l.DNS && !l.TLS && !l.Web {
+			return fmt.Errorf("#%d: none of dns, tls and web is enabled", i)
+		}
+		if l.DNSPort < 0 || l.DNSPort > 0xffff {
+			return fmt.Errorf("#%d: invalid dns_port %d", i, l.DNSPort)
+		}
+		if l.TLSPort < 0 || l.TLSPort > 0xffff {
+			return fmt.Errorf("#%d: invalid tls_port %d", i, l.TLSPort)
+		}
+		if l.DNSPort != 0 && l.DNSPort == l.TLSPort {
+			return fmt.Errorf("#%d: port %d is used by both plain DNS and DNS-over-TLS", i, l.DNSPort)
+		}
+		_, err := parseIPNets(l.AllowedClients)
+		if err == nil {
+			_, err = parseIPNets(l.DisallowedClients)
+		}
+		if err != nil {
+			return fmt.Errorf("#%d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// listenerIPs - get IP addresses of the listener
+func listenerIPs(l listenerConfig) ([]net.IP, error) {
+	ips := []net.IP{}
+	if len(l.Interface) == 0 {
+		for _, a := range l.Addresses {
+			ip := net.ParseIP(util.TrimBrackets(a))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %q", a)
+			}
+			ips = append(ips, ip)
+		}
+		return ips, nil
+	}
+
+	iface, err := net.InterfaceByName(l.Interface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", l.Interface, err)
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			// link-local IPv6 addresses require the zone
+			continue
+		}
+		ips = append(ips, ipnet.IP)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s: no IP addresses", l.Interface)
+	}
+	return ips, nil
+}
+
+// hasDNSListeners - return TRUE if DNS server must listen on the listeners' addresses instead of dns.bind_host
+func hasDNSListeners() bool {
+	for _, l := range config.Listeners {
+		if l.DNS || l.TLS {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsListeners - get the listen addresses of DNS server
+func dnsListeners(tlsConf tlsConfigSettings) []dnsforward.Listener {
+	list := []dnsforward.Listener{}
+	for _, l := range config.Listeners {
+		if !l.DNS && !l.TLS {
+			continue
+		}
+		ips, err := listenerIPs(l)
+		if err != nil {
+			log.Error("DNS: listener: %s", err)
+			continue
+		}
+		for _, ip := range ips {
+			dl := dnsforward.Listener{
+				AllowedClients:    l.AllowedClients,
+				DisallowedClients: l.DisallowedClients,
+			}
+			if l.DNS {
+				dl.UDPListenAddr = &net.UDPAddr{IP: ip, Port: l.dnsPort()}
+				dl.TCPListenAddr = &net.TCPAddr{IP: ip, Port: l.dnsPort()}
+			}
+			if l.TLS && tlsConf.Enabled && l.tlsPort(tlsConf) != 0 {
+				dl.TLSListenAddr = &net.TCPAddr{IP: ip, Port: l.tlsPort(tlsConf)}
+			}
+			list = append(list, dl)
+		}
+	}
+	return list
+}
+
+// webListeners - get the listen addresses of the web interface
+func webListeners() []*listenerAccess {
+	list := []*listenerAccess{}
+	for _, l := range config.Listeners {
+		if !l.Web {
+			continue
+		}
+		ips, err := listenerIPs(l)
+		if err != nil {
+			log.Error("Web: listener: %s", err)
+			continue
+		}
+		la, err := newListenerAccess(ips, l.AllowedClients, l.DisallowedClients)
+		if err != nil {
+			log.Error("Web: listener: %s", err)
+			continue
+		}
+		list = append(list, la)
+	}
+	return list
+}
+
+type listenersJSON struct {
+	Listeners []listenerConfig `json:"listeners"`
+}
+
+// GET /control/listeners
+func handleListeners(w http.ResponseWriter, r *http.Request) {
+	config.RLock()
+	resp := listenersJSON{Listeners: append([]listenerConfig{}, config.Listeners...)}
+	config.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+// POST /control/listeners/config
+// DNS server starts listening on the new addresses immediately, the web interface - after restart.
+func handleListenersConfig(w http.ResponseWriter, r *http.Request) {
+	req := listenersJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+	err = validateListeners(req.Listeners)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	config.Lock()
+	config.Listeners = req.Listeners
+	config.Unlock()
+	onConfigModified()
+
+	if isRunning() {
+		err = reconfigureDNSServer()
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+	}
+	returnOK(w)
+}
@@ -0,0 +1,53 @@
+package home
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateListeners(t *testing.T) {
+	assert.Nil(t, validateListeners([]listenerConfig{
+		{Interface: "br-lan", DNS: true, Web: true},
+		{Addresses: []string{"192.168.20.1", "[fd00::1]"}, DNS: true, TLS: true, AllowedClients: []string{"192.168.20.0/24"}},
+	}))
+
+	assert.NotNil(t, validateListeners([]listenerConfig{{DNS: true}}))
+	assert.NotNil(t, validateListeners([]listenerConfig{{Interface: "eth0", Addresses: []string{"192.168.1.1"}, DNS: true}}))
+	assert.NotNil(t, validateListeners([]listenerConfig{{Interface: "eth0; reboot", DNS: true}}))
+	assert.NotNil(t, validateListeners([]listenerConfig{{Addresses: []string{"192.168.1"}, DNS: true}}))
+	assert.NotNil(t, validateListeners([]listenerConfig{{Interface: "eth0"}}))
+	assert.NotNil(t, validateListeners([]listenerConfig{{Interface: "eth0", DNS: true, DisallowedClients: []string{"1.2.3.4/33"}}}))
+	assert.NotNil(t, validateListeners([]listenerConfig{{Interface: "eth0", DNS: true, DNSPort: 65536}}))
+	assert.NotNil(t, validateListeners([]listenerConfig{{Interface: "eth0", DNS: true, TLS: true, DNSPort: 5353, TLSPort: 5353}}))
+}
+
+func TestDNSListeners(t *testing.T) {
+	config.Listeners = []listenerConfig{
+		{Addresses: []string{"192.168.10.1", "fd00::1"}, DNS: true, TLS: true, AllowedClients: []string{"192.168.10.0/24"}},
+		{Addresses: []string{"192.168.20.1"}, Web: true},
+		{Interface: "nonexistent0", DNS: true},
+		{Addresses: []string{"192.168.30.1"}, DNS: true, TLS: true, DNSPort: 5353, TLSPort: 8853},
+	}
+	defer func() { config.Listeners = nil }()
+	config.DNS.Port = 53
+
+	assert.True(t, hasDNSListeners())
+	list := dnsListeners(tlsConfigSettings{})
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, "192.168.10.1:53", list[0].UDPListenAddr.String())
+	assert.Equal(t, "[fd00::1]:53", list[1].TCPListenAddr.String())
+	assert.Nil(t, list[0].TLSListenAddr)
+	assert.Equal(t, []string{"192.168.10.0/24"}, list[0].AllowedClients)
+	assert.Equal(t, "192.168.30.1:5353", list[2].UDPListenAddr.String())
+
+	tlsConf := tlsConfigSettings{Enabled: true, PortDNSOverTLS: 853}
+	list = dnsListeners(tlsConf)
+	assert.Equal(t, "192.168.10.1:853", list[0].TLSListenAddr.String())
+	assert.Equal(t, "192.168.30.1:8853", list[2].TLSListenAddr.String())
+
+	web := webListeners()
+	assert.Equal(t, 1, len(web))
+	assert.True(t, web[0].ips[0].Equal(net.IP{192, 168, 20, 1}))
+}
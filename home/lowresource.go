@@ -0,0 +1,61 @@
+package home
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Constrained device profile settings
+const (
+	lowResourceCacheSize       = 512 * 1024 // DNS cache size (in bytes)
+	lowResourceFilterCacheSize = 128 * 1024 // safebrowsing, parental and safesearch cache size (in bytes)
+	lowResourceQueryLogMemSize = 100        // query log entries kept in memory before they are flushed to disk
+	lowResourceMaxGoroutines   = 50         // the maximum number of goroutines processing DNS requests
+	lowResourceGCPercent       = 50         // run garbage collector more often to keep the heap small
+
+	// pause between the background jobs (e.g. WHOIS and rDNS lookups)
+	lowResourceJobPause = 500 * time.Millisecond
+	// the first filters update check is delayed, so it doesn't compete with the start-up
+	lowResourceFiltersUpdateDelay = 5 * time.Minute
+)
+
+// applyLowResourceProfile - tune the settings for a constrained device
+// The settings are stored in configuration file, so the user may change them later.
+func applyLowResourceProfile(c *configuration) {
+	c.LowResourceMode = true
+
+	if c.DNS.CacheSize == 0 || c.DNS.CacheSize > lowResourceCacheSize {
+		c.DNS.CacheSize = lowResourceCacheSize
+	}
+	dc := &c.DNS.DnsfilterConf
+	if dc.SafeBrowsingCacheSize > lowResourceFilterCacheSize {
+		dc.SafeBrowsingCacheSize = lowResourceFilterCacheSize
+	}
+	if dc.SafeSearchCacheSize > lowResourceFilterCacheSize {
+		dc.SafeSearchCacheSize = lowResourceFilterCacheSize
+	}
+	if dc.ParentalCacheSize > lowResourceFilterCacheSize {
+		dc.ParentalCacheSize = lowResourceFilterCacheSize
+	}
+	if c.DNS.QueryLogMemSize > lowResourceQueryLogMemSize {
+		c.DNS.QueryLogMemSize = lowResourceQueryLogMemSize
+	}
+}
+
+// initLowResourceMode - apply the run-time settings of constrained device profile
+func initLowResourceMode() {
+	if !config.LowResourceMode {
+		return
+	}
+	debug.SetGCPercent(lowResourceGCPercent)
+	log.Info("Low resource mode is enabled")
+}
+
+// backgroundJobPause - slow down the background jobs in low resource mode
+func backgroundJobPause() {
+	if config.LowResourceMode {
+		time.Sleep(lowResourceJobPause)
+	}
+}
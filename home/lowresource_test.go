@@ -0,0 +1,28 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyLowResourceProfile(t *testing.T) {
+	c := configuration{}
+	c.DNS.CacheSize = 4 * 1024 * 1024
+	c.DNS.DnsfilterConf.SafeBrowsingCacheSize = 1024 * 1024
+	c.DNS.DnsfilterConf.ParentalCacheSize = 64 * 1024
+	c.DNS.QueryLogMemSize = 1000
+
+	applyLowResourceProfile(&c)
+	assert.True(t, c.LowResourceMode)
+	assert.Equal(t, uint32(lowResourceCacheSize), c.DNS.CacheSize)
+	assert.Equal(t, uint(lowResourceFilterCacheSize), c.DNS.DnsfilterConf.SafeBrowsingCacheSize)
+	// the smaller values are kept
+	assert.Equal(t, uint(64*1024), c.DNS.DnsfilterConf.ParentalCacheSize)
+	assert.Equal(t, uint32(lowResourceQueryLogMemSize), c.DNS.QueryLogMemSize)
+
+	// cache is enabled
+	c.DNS.CacheSize = 0
+	applyLowResourceProfile(&c)
+	assert.Equal(t, uint32(lowResourceCacheSize), c.DNS.CacheSize)
+}
@@ -0,0 +1,42 @@
+package home
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// maintenanceWindow - get the window when a background job may run and the current time in the configured time zone
+// jobWindow: the window of the job ("": use the common maintenance window)
+// Return nil if no window is set:  the job may run at any time.
+func maintenanceWindow(jobWindow string) (*timeWindow, time.Time) {
+	config.RLock()
+	s := config.MaintenanceWindow
+	tz := config.DNS.TimeZone
+	config.RUnlock()
+	if len(jobWindow) != 0 {
+		s = jobWindow
+	}
+
+	now := time.Now()
+	loc, err := timeLocation(tz)
+	if err == nil {
+		now = now.In(loc)
+	}
+	if len(s) == 0 {
+		return nil, now
+	}
+	w, err := parseTimeWindow(s)
+	if err != nil {
+		// the configuration is validated:  this shouldn't happen
+		log.Error("maintenance window: %s", err)
+		return nil, now
+	}
+	return &w, now
+}
+
+// inMaintenanceWindow - return TRUE if the background jobs may run now
+func inMaintenanceWindow() bool {
+	w, now := maintenanceWindow("")
+	return w == nil || w.contains(now)
+}
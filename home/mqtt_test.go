@@ -0,0 +1,84 @@
+package home
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMQTTLength(t *testing.T) {
+	assert.Equal(t, []byte{0}, appendMQTTLength(nil, 0))
+	assert.Equal(t, []byte{127}, appendMQTTLength(nil, 127))
+	assert.Equal(t, []byte{0x80, 0x01}, appendMQTTLength(nil, 128))
+	assert.Equal(t, []byte{0xff, 0x7f}, appendMQTTLength(nil, 16383))
+	assert.Equal(t, []byte{0x80, 0x80, 0x01}, appendMQTTLength(nil, 16384))
+}
+
+func TestMQTTClient(t *testing.T) {
+	_, err := newMQTTClient("http://host", "id")
+	assert.NotNil(t, err)
+
+	c, err := newMQTTClient("mqtts://broker.example.org", "id")
+	assert.Nil(t, err)
+	assert.Equal(t, "broker.example.org:8883", c.addr)
+	assert.NotNil(t, c.tlsConf)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	type packet struct {
+		typ  byte
+		body []byte
+	}
+	packets := make(chan packet, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		typ, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		packets <- packet{typ, body}
+		_, _ = conn.Write([]byte{mqttConnAck, 2, 0, 0})
+		for {
+			typ, body, err = readMQTTPacket(r)
+			if err != nil {
+				return
+			}
+			packets <- packet{typ, body}
+		}
+	}()
+
+	c, err = newMQTTClient("mqtt://user:pass@"+l.Addr().String(), "agh")
+	assert.Nil(t, err)
+	c.willTopic = "agh/status"
+	c.willPayload = []byte("offline")
+	assert.Nil(t, c.connect())
+	assert.True(t, c.connected())
+
+	p := <-packets
+	assert.Equal(t, byte(mqttConnect), p.typ)
+	// protocol name and level
+	assert.Equal(t, []byte{0, 4, 'M', 'Q', 'T', 'T', 4}, p.body[:7])
+	flags := p.body[7]
+	assert.Equal(t, byte(mqttFlagCleanSession|mqttFlagWill|mqttFlagWillRetain|mqttFlagUsername|mqttFlagPassword), flags)
+	// client ID
+	assert.Equal(t, []byte{0, 3, 'a', 'g', 'h'}, p.body[10:15])
+
+	assert.Nil(t, c.publish("agh/protection", []byte("ON"), true))
+	p = <-packets
+	assert.Equal(t, byte(mqttPublish), p.typ)
+	assert.Equal(t, append([]byte{0, 14}, "agh/protectionON"...), p.body)
+
+	c.close()
+	assert.False(t, c.connected())
+	p = <-packets
+	assert.Equal(t, byte(mqttDisconnect), p.typ)
+}
@@ -0,0 +1,136 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/pihole"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The default Pi-hole configuration directory
+const piholeDefaultDir = "/etc/pihole"
+
+type piholeImportReq struct {
+	Path string `json:"path"` // Pi-hole configuration directory
+}
+
+type piholeImportResp struct {
+	Filters  int `json:"filters"`  // the number of the added filters
+	Rules    int `json:"rules"`    // the number of the added user rules
+	Rewrites int `json:"rewrites"` // the number of the added DNS rewrites
+	Skipped  int `json:"skipped"`  // the number of the entries that weren't imported
+}
+
+// importPihole - add Pi-hole block lists, allowed and blocked domains and local DNS records to the configuration:
+// . block list -> filter
+// . allowed or blocked domain, regular expression -> user rule
+// . local DNS record (A, AAAA, CNAME) -> DNS rewrite
+// The disabled lists are added as disabled filters, the disabled domains are skipped.
+func importPihole(d *pihole.Data) piholeImportResp {
+	resp := piholeImportResp{}
+
+	for _, a := range d.Adlists {
+		f := filter{
+			Enabled: a.Enabled,
+			URL:     a.URL,
+			Name:    a.Comment,
+		}
+		if len(f.Name) == 0 || len(f.Name) > 100 {
+			f.Name = f.URL
+		}
+		f.ID = assignUniqueFilterID()
+		if !filterAdd(f) {
+			resp.Skipped++
+			continue
+		}
+		resp.Filters++
+	}
+
+	config.Lock()
+	defer config.Unlock()
+
+	existing := map[string]bool{}
+	for _, r := range config.UserRules {
+		existing[r] = true
+	}
+	rules := []string{}
+	for _, dom := range d.Domains {
+		rule, ok := dom.Rule()
+		if !dom.Enabled || !ok {
+			resp.Skipped++
+			continue
+		}
+		if existing[rule] {
+			continue
+		}
+		existing[rule] = true
+		rules = append(rules, rule)
+	}
+	if len(rules) != 0 {
+		config.UserRules = append(config.UserRules, "! Imported from Pi-hole")
+		config.UserRules = append(config.UserRules, rules...)
+		resp.Rules = len(rules)
+	}
+
+	for _, r := range d.Records {
+		if addRewriteNoLock(r.Domain, r.Answer) {
+			resp.Rewrites++
+		}
+	}
+
+	return resp
+}
+
+// addRewriteNoLock - add DNS rewrite to the configuration
+// Return FALSE if it already exists.
+func addRewriteNoLock(domain, answer string) bool {
+	dc := &config.DNS.DnsfilterConf
+	ent := dnsfilter.RewriteEntry{
+		Domain: domain,
+		Answer: answer,
+	}
+	if n, err := util.NormalizeDomain(domain); err == nil {
+		ent.Domain = n
+	}
+	for _, e := range dc.Rewrites {
+		if e.Domain == ent.Domain && e.Answer == ent.Answer {
+			return false
+		}
+	}
+	dc.Rewrites = append(dc.Rewrites, ent)
+	return true
+}
+
+// Import Pi-hole settings (the first-run setup only)
+// The configuration is written when the setup is completed.
+func handleInstallImportPihole(w http.ResponseWriter, r *http.Request) {
+	req := piholeImportReq{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+	if len(req.Path) == 0 {
+		req.Path = piholeDefaultDir
+	}
+
+	d, err := pihole.Import(req.Path)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Couldn't read Pi-hole settings: %s", err)
+		return
+	}
+
+	resp := importPihole(d)
+	log.Info("Imported Pi-hole settings from %s: %d filters, %d rules, %d rewrites, %d entries skipped",
+		req.Path, resp.Filters, resp.Rules, resp.Rewrites, resp.Skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
@@ -0,0 +1,67 @@
+package home
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/pihole"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportPihole(t *testing.T) {
+	oldFilters := config.Filters
+	oldRules := config.UserRules
+	oldRewrites := config.DNS.DnsfilterConf.Rewrites
+	defer func() {
+		config.Filters = oldFilters
+		config.UserRules = oldRules
+		config.DNS.DnsfilterConf.Rewrites = oldRewrites
+	}()
+	config.Filters = nil
+	config.UserRules = []string{"@@|allowed.example.org^"}
+	config.DNS.DnsfilterConf.Rewrites = nil
+
+	d, err := pihole.Import("../pihole/testdata/pihole")
+	assert.Nil(t, err)
+	resp := importPihole(d)
+	assert.Equal(t, piholeImportResp{Filters: 2, Rules: 3, Rewrites: 5, Skipped: 201}, resp)
+
+	assert.Equal(t, 2, len(config.Filters))
+	assert.Equal(t, "Example list", config.Filters[0].Name)
+	assert.True(t, config.Filters[0].Enabled)
+	assert.Equal(t, "https://example.net/disabled.txt", config.Filters[1].Name)
+	assert.False(t, config.Filters[1].Enabled)
+	assert.NotEqual(t, config.Filters[0].ID, config.Filters[1].ID)
+
+	assert.Equal(t, []string{
+		"@@|allowed.example.org^",
+		"! Imported from Pi-hole",
+		"|blocked.example.org^",
+		`@@/(\.|^)good\.example$/`,
+		`/^ads[0-9]*\./`,
+	}, config.UserRules)
+	assert.Equal(t, dnsfilter.RewriteEntry{Domain: "files.lan", Answer: "nas.lan"}, config.DNS.DnsfilterConf.Rewrites[3])
+
+	// the second import doesn't add duplicates
+	resp = importPihole(d)
+	assert.Equal(t, 0, resp.Filters+resp.Rules+resp.Rewrites)
+
+	// the rules work as in Pi-hole
+	rules := strings.Join(config.UserRules, "\n") + "\n||example.org^\n||good.example^\n"
+	f := dnsfilter.New(nil, []dnsfilter.Filter{{Data: []byte(rules)}})
+	defer f.Close()
+	setts := dnsfilter.RequestFilteringSettings{FilteringEnabled: true}
+	check := func(host string, filtered bool) {
+		res, err := f.CheckHost(host, dns.TypeA, &setts)
+		assert.Nil(t, err)
+		assert.Equal(t, filtered, res.IsFiltered, host)
+	}
+	check("blocked.example.org", true)
+	check("allowed.example.org", false)
+	check("sub.allowed.example.org", true)
+	check("ads1.example.com", true)
+	check("good.example", false)
+	check("www.good.example", false)
+}
@@ -0,0 +1,67 @@
+package home
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// dropPrivileges - switch to the unprivileged user specified in the configuration
+// The configuration file, the data directory and the log file are given to the user first.
+func dropPrivileges() {
+	if len(config.User) == 0 {
+		return
+	}
+
+	uid, gid, err := util.LookupUser(config.User, config.Group)
+	if err != nil {
+		log.Fatalf("Couldn't drop privileges: %s", err)
+	}
+	if os.Getuid() == uid {
+		// e.g. the process has been restarted after an update
+		return
+	}
+
+	paths := []string{config.getConfigFilename(), Context.getDataDir(),
+		Context.getStorageDir(config.DNS.QueryLogDir), Context.getStorageDir(config.DNS.StatsDir)}
+	if len(config.LogFile) != 0 && config.LogFile != configSyslog {
+		logFile := config.LogFile
+		if !filepath.IsAbs(logFile) {
+			logFile = filepath.Join(Context.workDir, logFile)
+		}
+		paths = append(paths, logFile)
+	}
+	for _, p := range paths {
+		err = util.ChownR(p, uid, gid)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Couldn't drop privileges: %s", err)
+		}
+	}
+
+	netAdmin := netAdminUsers(&config)
+	err = util.DropPrivileges(uid, gid, len(netAdmin) != 0)
+	if err != nil {
+		log.Fatalf("Couldn't drop privileges: %s", err)
+	}
+	Context.netAdminDropped = len(netAdmin) == 0
+	log.Info("Running as user %s (uid:%d gid:%d)", config.User, uid, gid)
+	if len(netAdmin) != 0 {
+		log.Info("Keeping CAP_NET_ADMIN capability for %s", strings.Join(netAdmin, ", "))
+	}
+}
+
+// netAdminUsers - get the settings that need CAP_NET_ADMIN capability after the privileges are dropped
+// DNS interception changes the firewall rules, and the failover adds and removes the virtual IP address.
+func netAdminUsers(c *configuration) []string {
+	users := []string{}
+	if c.DNSIntercept.Enabled {
+		users = append(users, "dns_intercept")
+	}
+	if c.Failover.Enabled && c.Failover.Mode != failoverModeKeepalived && len(c.Failover.VirtualIP) != 0 {
+		users = append(users, "failover.virtual_ip")
+	}
+	return users
+}
@@ -120,6 +120,7 @@ func (r *RDNS) workerLoop() {
 		ip = <-r.ipChannel
 
 		host := r.resolve(ip)
+		backgroundJobPause()
 		if len(host) == 0 {
 			continue
 		}
@@ -196,6 +196,14 @@ func handleServiceInstallCommand(s service.Service) {
 		log.Fatal(err)
 	}
 
+	if runtime.GOOS == "windows" {
+		// Restart the service automatically if it crashes
+		err = util.SetServiceRecoveryActions(serviceName)
+		if err != nil {
+			log.Error("Failed to set service recovery actions: %s", err)
+		}
+	}
+
 	if util.IsOpenWrt() {
 		// On OpenWrt it is important to run enable after the service installation
 		// Otherwise, the service won't start on the system startup
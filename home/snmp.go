@@ -0,0 +1,129 @@
+package home
+
+import (
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/snmp"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The default base OID of AdGuard Home variables (netSnmpPlaypen)
+const snmpDefaultBaseOID = "1.3.6.1.4.1.8072.9999.9999"
+
+// snmpConfig - SNMP agent settings
+type snmpConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Address   string `yaml:"address"`   // UDP address to listen on
+	Community string `yaml:"community"` // read-only community
+	BaseOID   string `yaml:"base_oid"`  // the root of AdGuard Home variables
+}
+
+// SNMP system group (RFC 1213)
+var (
+	snmpSysDescr    = snmp.MustParseOID("1.3.6.1.2.1.1.1.0")
+	snmpSysObjectID = snmp.MustParseOID("1.3.6.1.2.1.1.2.0")
+	snmpSysUpTime   = snmp.MustParseOID("1.3.6.1.2.1.1.3.0")
+	snmpSysName     = snmp.MustParseOID("1.3.6.1.2.1.1.5.0")
+)
+
+// snmpModule - SNMP agent exposing the core metrics
+type snmpModule struct {
+	agent *snmp.Agent
+	base  snmp.OID
+	start time.Time
+}
+
+// newSNMP - create SNMP module
+// Return nil if it's disabled or the settings are invalid.
+func newSNMP(conf snmpConfig) *snmpModule {
+	if !conf.Enabled {
+		return nil
+	}
+	if len(conf.BaseOID) == 0 {
+		conf.BaseOID = snmpDefaultBaseOID
+	}
+	base, err := snmp.ParseOID(conf.BaseOID)
+	if err != nil {
+		log.Error("SNMP: %s", err)
+		return nil
+	}
+
+	m := &snmpModule{
+		base:  base,
+		start: time.Now(),
+	}
+	m.agent = snmp.Create(snmp.Config{
+		Addr:      conf.Address,
+		Community: conf.Community,
+		GetVars:   m.getVars,
+	})
+	return m
+}
+
+// Start - start the agent
+func (m *snmpModule) Start() {
+	if m == nil {
+		return
+	}
+	err := m.agent.Start()
+	if err != nil {
+		log.Error("SNMP: %s", err)
+	}
+}
+
+// Close - stop the agent
+func (m *snmpModule) Close() {
+	if m == nil {
+		return
+	}
+	m.agent.Close()
+}
+
+// getVars - get the current values of the variables
+func (m *snmpModule) getVars() []snmp.Var {
+	uptime := snmp.TimeTicks(time.Since(m.start) / (10 * time.Millisecond))
+	hostname, _ := os.Hostname()
+
+	vars := []snmp.Var{
+		{OID: snmpSysDescr, Value: "AdGuard Home " + versionString},
+		{OID: snmpSysObjectID, Value: m.base},
+		{OID: snmpSysUpTime, Value: uptime},
+		{OID: snmpSysName, Value: hostname},
+		{OID: m.base.Append(1, 0), Value: versionString},
+		{OID: m.base.Append(8, 0), Value: uptime},
+	}
+
+	c := dnsforward.FilteringConfig{}
+	if Context.dnsServer != nil {
+		counters := Context.dnsServer.GetCounters()
+		vars = append(vars,
+			snmp.Var{OID: m.base.Append(2, 0), Value: snmp.Counter64(counters.Requests)},
+			snmp.Var{OID: m.base.Append(3, 0), Value: snmp.Counter64(counters.Blocked)},
+			snmp.Var{OID: m.base.Append(4, 0), Value: snmp.Counter64(counters.CacheHits)},
+		)
+		Context.dnsServer.WriteDiskConfig(&c)
+	} else {
+		c = config.DNS.FilteringConfig
+	}
+	// TruthValue: true(1), false(2)
+	protection := 2
+	if c.ProtectionEnabled {
+		protection = 1
+	}
+	vars = append(vars, snmp.Var{OID: m.base.Append(7, 0), Value: protection})
+
+	if Context.stats != nil {
+		// microseconds
+		avg := Context.stats.GetSummary().AvgProcessingTime * 1000000
+		vars = append(vars, snmp.Var{OID: m.base.Append(5, 0), Value: snmp.Gauge32(avg)})
+	}
+
+	if Context.dhcpServer != nil && config.DHCP.Enabled {
+		n := len(Context.dhcpServer.Leases(dhcpd.LeasesAll))
+		vars = append(vars, snmp.Var{OID: m.base.Append(6, 0), Value: snmp.Gauge32(n)})
+	}
+	return vars
+}
@@ -31,6 +31,11 @@ type TLSMod struct {
 	conf        tlsConfigSettings
 	confLock    sync.Mutex
 	status      tlsConfigStatus
+	extStatus   tlsExtStatus // certificate chain completion and OCSP stapling
+
+	ocspStarted bool
+	ocspWake    chan struct{}
+	ocspStop    chan struct{}
 }
 
 // Create TLS module
@@ -40,16 +45,19 @@ func tlsCreate(conf tlsConfigSettings) *TLSMod {
 	if t.conf.Enabled {
 		if !t.load() {
 			// Something is not valid - return an empty TLS config
-			return &TLSMod{conf: tlsConfigSettings{
+			t = &TLSMod{conf: tlsConfigSettings{
 				Enabled:             conf.Enabled,
 				ServerName:          conf.ServerName,
 				PortHTTPS:           conf.PortHTTPS,
 				PortDNSOverTLS:      conf.PortDNSOverTLS,
 				AllowUnencryptedDOH: conf.AllowUnencryptedDOH,
 			}}
+		} else {
+			t.setCertFileTime()
 		}
-		t.setCertFileTime()
 	}
+	t.ocspWake = make(chan struct{}, 1)
+	t.ocspStop = make(chan struct{})
 	return t
 }
 
@@ -66,11 +74,15 @@ func (t *TLSMod) load() bool {
 		return false
 	}
 	t.status = data
+	t.extStatus = tlsExtStatus{}
 	return true
 }
 
 // Close - close module
 func (t *TLSMod) Close() {
+	if t.ocspStarted {
+		close(t.ocspStop)
+	}
 }
 
 // WriteDiskConfig - write config
@@ -80,6 +92,17 @@ func (t *TLSMod) WriteDiskConfig(conf *tlsConfigSettings) {
 	t.confLock.Unlock()
 }
 
+// certNotAfter - get the expiration time of the certificate
+// Return zero time if encryption is disabled.
+func (t *TLSMod) certNotAfter() time.Time {
+	t.confLock.Lock()
+	defer t.confLock.Unlock()
+	if !t.conf.Enabled || !t.status.ValidCert {
+		return time.Time{}
+	}
+	return t.status.NotAfter
+}
+
 func (t *TLSMod) setCertFileTime() {
 	if len(t.conf.CertificatePath) == 0 {
 		return
@@ -99,6 +122,11 @@ func (t *TLSMod) Start() {
 		t.registerWebHandlers()
 	}
 
+	if !t.ocspStarted {
+		t.ocspStarted = true
+		go t.ocspLoop()
+	}
+
 	t.confLock.Lock()
 	tlsConf := t.conf
 	t.confLock.Unlock()
@@ -134,14 +162,19 @@ func (t *TLSMod) Reload() {
 
 	t.certLastMod = fi.ModTime().UTC()
 
+	t.confLock.Lock()
+	tlsConf = t.conf
+	t.confLock.Unlock()
 	_ = reconfigureDNSServer()
 	Context.web.TLSConfigChanged(tlsConf)
+	t.wakeOCSP()
 }
 
 // Set certificate and private key data
 func tlsLoadConfig(tls *tlsConfigSettings, status *tlsConfigStatus) bool {
 	tls.CertificateChainData = []byte(tls.CertificateChain)
 	tls.PrivateKeyData = []byte(tls.PrivateKey)
+	tls.OCSPStaple = nil
 
 	var err error
 	if tls.CertificatePath != "" {
@@ -273,8 +306,11 @@ func (t *TLSMod) handleTLSConfigure(w http.ResponseWriter, r *http.Request) {
 	t.conf.PrivateKey = data.PrivateKey
 	t.conf.PrivateKeyPath = data.PrivateKeyPath
 	t.conf.PrivateKeyData = data.PrivateKeyData
+	t.conf.OCSPStaple = nil
 	t.status = status
+	t.extStatus = tlsExtStatus{}
 	t.confLock.Unlock()
+	t.wakeOCSP()
 	t.setCertFileTime()
 	onConfigModified()
 	err = reconfigureDNSServer()
@@ -542,4 +578,5 @@ func (t *TLSMod) registerWebHandlers() {
 	httpRegister("GET", "/control/tls/status", t.handleTLSStatus)
 	httpRegister("POST", "/control/tls/configure", t.handleTLSConfigure)
 	httpRegister("POST", "/control/tls/validate", t.handleTLSValidate)
+	httpRegister("GET", "/control/tls/health", t.handleTLSHealth)
 }
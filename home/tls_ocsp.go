@@ -0,0 +1,329 @@
+package home
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	tlsMaxDownloadedIntermediates = 3         // the maximum length of the chain of downloaded intermediate certificates
+	tlsMaxResponseSize            = 64 * 1024 // the maximum size of a downloaded certificate or OCSP response
+	tlsOCSPRetryInterval          = 1 * time.Hour
+	tlsOCSPCheckInterval          = 24 * time.Hour
+	tlsOCSPMinInterval            = 1 * time.Minute
+)
+
+// tlsExtStatus - the status of certificate chain completion and OCSP stapling
+type tlsExtStatus struct {
+	Intermediates  int       `json:"intermediates"`     // the number of downloaded intermediate certificates
+	OCSPStatus     string    `json:"ocsp_status"`       // "good", "revoked", "unknown" or "" (no OCSP response)
+	OCSPThisUpdate time.Time `json:"ocsp_this_update"`  // ThisUpdate field of the stapled OCSP response
+	OCSPNextUpdate time.Time `json:"ocsp_next_update"`  // NextUpdate field of the stapled OCSP response
+	Error          string    `json:"error,omitempty"`   // the error of the last attempt
+	Checked        time.Time `json:"checked,omitempty"` // the time of the last attempt
+}
+
+// parsePEMCertificates - parse all certificates from PEM data
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+	return certs, nil
+}
+
+// tlsDownload - download the data of a certificate or OCSP response
+// The request is POST if body isn't nil.
+func tlsDownload(client *http.Client, u string, contentType string, body []byte) ([]byte, error) {
+	var resp *http.Response
+	var err error
+	if body != nil {
+		resp, err = client.Post(u, contentType, bytes.NewReader(body))
+	} else {
+		resp, err = client.Get(u)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status code %d", u, resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, tlsMaxResponseSize))
+}
+
+// isIssuedByRoot - return TRUE if the certificate is self-signed or is signed by a trusted root CA
+func isIssuedByRoot(c *x509.Certificate, roots *x509.CertPool) bool {
+	if bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil {
+		return true
+	}
+	_, err := c.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+// completeChain - download the missing intermediate certificates by the URLs in Authority Information Access extension
+// Return the downloaded certificates.
+func completeChain(client *http.Client, certs []*x509.Certificate, roots *x509.CertPool) ([]*x509.Certificate, error) {
+	var added []*x509.Certificate
+	last := certs[len(certs)-1]
+	for !isIssuedByRoot(last, roots) {
+		if len(added) == tlsMaxDownloadedIntermediates {
+			return added, fmt.Errorf("certificate chain is too long")
+		}
+		if len(last.IssuingCertificateURL) == 0 {
+			return added, fmt.Errorf("no issuer URL in certificate %q", last.Subject)
+		}
+
+		data, err := tlsDownload(client, last.IssuingCertificateURL[0], "", nil)
+		if err != nil {
+			return added, fmt.Errorf("issuer of %q: %s", last.Subject, err)
+		}
+		issuer, err := x509.ParseCertificate(data)
+		if err != nil {
+			// some CAs serve PEM data
+			var certs []*x509.Certificate
+			certs, err = parsePEMCertificates(data)
+			if err != nil {
+				return added, fmt.Errorf("issuer of %q: %s", last.Subject, err)
+			}
+			issuer = certs[0]
+		}
+		err = last.CheckSignatureFrom(issuer)
+		if err != nil {
+			return added, fmt.Errorf("issuer of %q: %s", last.Subject, err)
+		}
+
+		added = append(added, issuer)
+		last = issuer
+	}
+	return added, nil
+}
+
+// fetchOCSP - get OCSP response for the certificate from its OCSP server
+// Return the raw response and the parsed one.
+func fetchOCSP(client *http.Client, cert, issuer *x509.Certificate) ([]byte, *ocsp.Response, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("no OCSP server in certificate")
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ocsp.CreateRequest: %s", err)
+	}
+	data, err := tlsDownload(client, cert.OCSPServer[0], "application/ocsp-request", req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OCSP: %s", err)
+	}
+	resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OCSP: %s", err)
+	}
+	return data, resp, nil
+}
+
+// ocspStatusString - get the name of OCSP certificate status
+func ocspStatusString(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// Periodically complete the certificate chain and refresh OCSP staple
+func (t *TLSMod) ocspLoop() {
+	for {
+		d := t.refreshOCSP()
+		log.Debug("TLS: next OCSP check in %s", d)
+		select {
+		case <-t.ocspWake:
+		case <-time.After(d):
+		case <-t.ocspStop:
+			return
+		}
+	}
+}
+
+// wakeOCSP - refresh OCSP staple and certificate chain now (e.g. the certificate has changed)
+func (t *TLSMod) wakeOCSP() {
+	select {
+	case t.ocspWake <- struct{}{}:
+	default:
+	}
+}
+
+// refreshOCSP - complete the certificate chain and get a new OCSP response
+// Apply the new certificate settings if they have changed.
+// Return the time until the next check.
+func (t *TLSMod) refreshOCSP() time.Duration {
+	t.confLock.Lock()
+	conf := t.conf
+	valid := t.status.ValidPair
+	t.confLock.Unlock()
+	if !conf.Enabled || !valid || (!conf.OCSPStapling && !conf.CompleteChain) {
+		t.confLock.Lock()
+		t.extStatus = tlsExtStatus{}
+		t.confLock.Unlock()
+		return tlsOCSPCheckInterval
+	}
+
+	st := tlsExtStatus{Checked: time.Now()}
+	chainData := append([]byte(nil), conf.CertificateChainData...)
+	var staple []byte
+	interval := tlsOCSPCheckInterval
+	err := func() error {
+		certs, err := parsePEMCertificates(chainData)
+		if err != nil {
+			return err
+		}
+
+		if conf.CompleteChain {
+			added, err := completeChain(Context.client, certs, Context.tlsRoots)
+			for _, c := range added {
+				chainData = append(chainData, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+			}
+			certs = append(certs, added...)
+			st.Intermediates = len(added)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !conf.OCSPStapling {
+			return nil
+		}
+		if len(certs) < 2 {
+			return fmt.Errorf("OCSP: no issuer certificate in the chain")
+		}
+		data, resp, err := fetchOCSP(Context.client, certs[0], certs[1])
+		if err != nil {
+			return err
+		}
+		st.OCSPStatus = ocspStatusString(resp.Status)
+		st.OCSPThisUpdate = resp.ThisUpdate
+		st.OCSPNextUpdate = resp.NextUpdate
+		if resp.Status != ocsp.Good {
+			return fmt.Errorf("OCSP: certificate status is %s", st.OCSPStatus)
+		}
+		staple = data
+
+		if !resp.NextUpdate.IsZero() {
+			// refresh the response when a half of its validity period has passed
+			interval = time.Until(resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2))
+		}
+		return nil
+	}()
+	if err != nil {
+		log.Error("TLS: %s", err)
+		st.Error = err.Error()
+		interval = tlsOCSPRetryInterval
+	}
+	if interval < tlsOCSPMinInterval {
+		interval = tlsOCSPMinInterval
+	}
+
+	t.confLock.Lock()
+	if !bytes.Equal(t.conf.CertificateChainData, conf.CertificateChainData) {
+		// the certificate has been changed while we were downloading
+		t.confLock.Unlock()
+		return tlsOCSPMinInterval
+	}
+	if staple == nil && len(t.conf.OCSPStaple) != 0 && !t.extStatus.OCSPNextUpdate.IsZero() && time.Now().Before(t.extStatus.OCSPNextUpdate) {
+		// the previous response is still valid:  keep it until the next attempt
+		staple = t.conf.OCSPStaple
+		st.OCSPStatus = t.extStatus.OCSPStatus
+		st.OCSPThisUpdate = t.extStatus.OCSPThisUpdate
+		st.OCSPNextUpdate = t.extStatus.OCSPNextUpdate
+	}
+	if st.Intermediates == 0 && bytes.Equal(t.conf.CertificateChainData, chainData) {
+		// the chain has been completed before
+		st.Intermediates = t.extStatus.Intermediates
+	}
+	changed := !bytes.Equal(t.conf.CertificateChainData, chainData) || !bytes.Equal(t.conf.OCSPStaple, staple)
+	t.conf.CertificateChainData = chainData
+	t.conf.OCSPStaple = staple
+	t.extStatus = st
+	conf = t.conf
+	t.confLock.Unlock()
+
+	if changed {
+		log.Info("TLS: applying the new certificate chain or OCSP staple")
+		_ = reconfigureDNSServer()
+		Context.web.TLSConfigChanged(conf)
+	}
+	return interval
+}
+
+type tlsHealthJSON struct {
+	Enabled       bool      `json:"enabled"`
+	NotAfter      time.Time `json:"not_after"`
+	ExpiresInDays int       `json:"expires_in_days"`
+	OCSPStapling  bool      `json:"ocsp_stapling"`
+	CompleteChain bool      `json:"complete_chain"`
+	tlsExtStatus
+}
+
+// GET /control/tls/health
+// 200 if the certificate is valid and isn't expired and OCSP response is stapled (if enabled);  503 otherwise
+func (t *TLSMod) handleTLSHealth(w http.ResponseWriter, r *http.Request) {
+	t.confLock.Lock()
+	resp := tlsHealthJSON{
+		Enabled:       t.conf.Enabled,
+		OCSPStapling:  t.conf.OCSPStapling,
+		CompleteChain: t.conf.CompleteChain,
+		tlsExtStatus:  t.extStatus,
+	}
+	valid := t.status.ValidPair
+	if t.conf.Enabled && t.status.ValidCert {
+		resp.NotAfter = t.status.NotAfter
+		resp.ExpiresInDays = int(time.Until(t.status.NotAfter).Hours() / 24)
+	}
+	stapled := len(t.conf.OCSPStaple) != 0
+	t.confLock.Unlock()
+
+	now := time.Now()
+	healthy := !resp.Enabled ||
+		(valid && now.Before(resp.NotAfter) &&
+			(!resp.OCSPStapling || (stapled && (resp.OCSPNextUpdate.IsZero() || now.Before(resp.OCSPNextUpdate)))))
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,124 @@
+package home
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCert - create the certificate signed by the parent (self-signed if parent is nil)
+func testCert(t *testing.T, serial int64, name string, ca bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+	tmpl func(c *x509.Certificate)) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	c := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		DNSNames:              []string{name},
+	}
+	if tmpl != nil {
+		tmpl(c)
+	}
+	if parent == nil {
+		parent, parentKey = c, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, c, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	c, err = x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return c, key
+}
+
+func TestTLSChainOCSP(t *testing.T) {
+	root, rootKey := testCert(t, 1, "root", true, nil, nil, nil)
+	var inter *x509.Certificate
+	var interKey *ecdsa.PrivateKey
+
+	revoked := false
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/inter.der", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(inter.Raw)
+	})
+	mux.HandleFunc("/ocsp", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		assert.Nil(t, err)
+		tmpl := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(24 * time.Hour),
+		}
+		if revoked {
+			tmpl.Status = ocsp.Revoked
+			tmpl.RevokedAt = time.Now()
+		}
+		resp, err := ocsp.CreateResponse(inter, inter, tmpl, interKey)
+		assert.Nil(t, err)
+		_, _ = w.Write(resp)
+	})
+
+	inter, interKey = testCert(t, 2, "intermediate", true, root, rootKey, nil)
+	leaf, _ := testCert(t, 3, "dns.example.org", false, inter, interKey, func(c *x509.Certificate) {
+		c.IssuingCertificateURL = []string{srv.URL + "/inter.der"}
+		c.OCSPServer = []string{srv.URL + "/ocsp"}
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	// the chain contains the leaf certificate only
+	certs, err := parsePEMCertificates(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(certs))
+	added, err := completeChain(client, certs, roots)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(added))
+	assert.Equal(t, "intermediate", added[0].Subject.CommonName)
+
+	// the chain is complete
+	added, err = completeChain(client, []*x509.Certificate{leaf, inter}, roots)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(added))
+
+	// unknown root
+	_, err = completeChain(client, certs, x509.NewCertPool())
+	assert.NotNil(t, err)
+
+	data, resp, err := fetchOCSP(client, leaf, inter)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, len(data))
+	assert.Equal(t, "good", ocspStatusString(resp.Status))
+
+	revoked = true
+	_, resp, err = fetchOCSP(client, leaf, inter)
+	assert.Nil(t, err)
+	assert.Equal(t, "revoked", ocspStatusString(resp.Status))
+
+	_, _, err = fetchOCSP(client, inter, root)
+	assert.NotNil(t, err)
+
+	_, err = parsePEMCertificates([]byte("not a certificate"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,38 @@
+package home
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/tracing"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// tracingConfig - settings of OpenTelemetry tracing of DNS requests
+type tracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Endpoint    string            `yaml:"endpoint"` // OTLP/HTTP traces endpoint, e.g. "http://localhost:4318/v1/traces"
+	Headers     map[string]string `yaml:"headers"`  // additional HTTP headers, e.g. for authorization
+	ServiceName string            `yaml:"service_name"`
+	SampleRate  float64           `yaml:"sample_rate"`     // the share of requests that are traced (0..1)
+	MinDuration uint32            `yaml:"min_duration_ms"` // export only the requests that took longer than this
+}
+
+// newTracer - create the tracer
+// Return nil if it's disabled or the settings are invalid.
+func newTracer(conf tracingConfig) *tracing.Tracer {
+	if !conf.Enabled {
+		return nil
+	}
+	t, err := tracing.New(tracing.Config{
+		Endpoint:    conf.Endpoint,
+		Headers:     conf.Headers,
+		ServiceName: conf.ServiceName,
+		SampleRate:  conf.SampleRate,
+		MinDuration: time.Duration(conf.MinDuration) * time.Millisecond,
+	})
+	if err != nil {
+		log.Error("tracing: %s", err)
+		return nil
+	}
+	return t
+}
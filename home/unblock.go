@@ -0,0 +1,376 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/utils"
+)
+
+// The limits of the unblock requests
+const (
+	unblockMaxPending   = 100  // the maximum number of the pending requests
+	unblockMaxPerClient = 5    // the maximum number of the pending requests from one client
+	unblockMaxResolved  = 100  // the number of the approved and rejected requests that are kept
+	unblockMaxReason    = 500  // the maximum length of the reason (in characters)
+	unblockMaxBody      = 4096 // the maximum size of the submitted request
+)
+
+// The states of an unblock request
+const (
+	unblockPending  = "pending"
+	unblockApproved = "approved"
+	unblockRejected = "rejected"
+)
+
+const unblockFilename = "unblock_requests.json"
+
+// unblockConfig - settings of the unblock requests
+type unblockConfig struct {
+	// Accept the requests to unblock a domain from the users without authentication
+	Enabled bool `yaml:"enabled"`
+}
+
+// unblockRequest - the request of a user to unblock a domain
+type unblockRequest struct {
+	ID         int64     `json:"id"`
+	Domain     string    `json:"domain"`
+	Client     string    `json:"client"`      // IP address
+	ClientName string    `json:"client_name"` // the name of the persistent or runtime client
+	Reason     string    `json:"reason"`
+	Time       time.Time `json:"time"`
+	Status     string    `json:"status"`
+	Resolved   time.Time `json:"resolved,omitempty"` // the time when the request was approved or rejected
+	Rule       string    `json:"rule,omitempty"`     // the rule added when the request was approved
+}
+
+// unblockModule - the queue of the unblock requests waiting for admin approval
+type unblockModule struct {
+	lock     sync.Mutex
+	filename string
+	lastID   int64
+	requests []unblockRequest
+}
+
+// newUnblock - create the module
+// Return nil if the unblock requests are disabled.
+func newUnblock(conf unblockConfig) *unblockModule {
+	if !conf.Enabled {
+		return nil
+	}
+	u := &unblockModule{filename: filepath.Join(Context.getDataDir(), unblockFilename)}
+	u.load()
+	return u
+}
+
+// load - read the requests from the file
+func (u *unblockModule) load() {
+	data, err := ioutil.ReadFile(u.filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("unblock: %s", err)
+		}
+		return
+	}
+	err = json.Unmarshal(data, &u.requests)
+	if err != nil {
+		log.Error("unblock: %s: %s", u.filename, err)
+		return
+	}
+	for _, r := range u.requests {
+		if r.ID > u.lastID {
+			u.lastID = r.ID
+		}
+	}
+}
+
+// save - write the requests to the file
+func (u *unblockModule) save() {
+	data, _ := json.MarshalIndent(u.requests, "", "\t")
+	err := ioutil.WriteFile(u.filename, data, 0644)
+	if err != nil {
+		log.Error("unblock: %s", err)
+	}
+}
+
+// add - add the request to the queue
+// Return the request's ID.
+func (u *unblockModule) add(r unblockRequest, now time.Time) (int64, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	pending := 0
+	perClient := 0
+	for _, it := range u.requests {
+		if it.Status != unblockPending {
+			continue
+		}
+		if it.Client == r.Client && it.Domain == r.Domain {
+			return it.ID, nil // the same request is already in the queue
+		}
+		pending++
+		if it.Client == r.Client {
+			perClient++
+		}
+	}
+	if pending >= unblockMaxPending || perClient >= unblockMaxPerClient {
+		return 0, fmt.Errorf("too many pending requests")
+	}
+
+	u.lastID++
+	r.ID = u.lastID
+	r.Time = now
+	r.Status = unblockPending
+	u.requests = append(u.requests, r)
+	u.save()
+	return r.ID, nil
+}
+
+// get - get the request by its ID
+func (u *unblockModule) get(id int64) (unblockRequest, bool) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, r := range u.requests {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return unblockRequest{}, false
+}
+
+// resolve - set the status of the pending request and remove the oldest resolved requests
+func (u *unblockModule) resolve(id int64, status, rule string, now time.Time) (unblockRequest, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	var res unblockRequest
+	found := false
+	resolved := 0
+	for i := range u.requests {
+		r := &u.requests[i]
+		if r.ID == id {
+			if r.Status != unblockPending {
+				return *r, fmt.Errorf("request %d is already %s", id, r.Status)
+			}
+			r.Status = status
+			r.Resolved = now
+			r.Rule = rule
+			res = *r
+			found = true
+		}
+		if r.Status != unblockPending {
+			resolved++
+		}
+	}
+	if !found {
+		return res, fmt.Errorf("request %d not found", id)
+	}
+
+	// the requests are stored in the order of their creation
+	list := u.requests[:0]
+	for _, r := range u.requests {
+		if r.Status != unblockPending && resolved > unblockMaxResolved {
+			resolved--
+			continue
+		}
+		list = append(list, r)
+	}
+	u.requests = list
+	u.save()
+	return res, nil
+}
+
+// list - get all requests, the newest first
+func (u *unblockModule) list() []unblockRequest {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	list := make([]unblockRequest, 0, len(u.requests))
+	for i := len(u.requests) - 1; i >= 0; i-- {
+		list = append(list, u.requests[i])
+	}
+	return list
+}
+
+// unblockRule - get the allowlist rule for the domain
+// client: the client name or IP address the rule applies to ("": all clients)
+func unblockRule(domain, client string) string {
+	rule := "@@||" + domain + "^"
+	if len(client) != 0 {
+		rule += "$client='" + client + "'"
+	}
+	return rule
+}
+
+// unblockClientID - get the identifier of the client for $client modifier:
+// the name of the persistent client if it can be used in the rule, otherwise the IP address
+func unblockClientID(r unblockRequest) string {
+	if len(r.ClientName) != 0 && !strings.ContainsAny(r.ClientName, "'\",|$\\") {
+		if _, ok := Context.clients.Find(r.Client); ok {
+			return r.ClientName
+		}
+	}
+	return r.Client
+}
+
+type unblockSubmitJSON struct {
+	Domain string `json:"domain"`
+	Reason string `json:"reason"`
+}
+
+// POST /control/unblock_request (no authentication)
+// GET /control/unblock_request?id=1 (no authentication, only for the client that has submitted the request)
+func handleUnblockRequest(w http.ResponseWriter, r *http.Request) {
+	u := Context.unblock
+	if u == nil {
+		http.Error(w, "Unblock requests are disabled", http.StatusNotFound)
+		return
+	}
+	ip := remoteIP(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		var id int64
+		_, err := fmt.Sscanf(r.URL.Query().Get("id"), "%d", &id)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		req, ok := u.get(id)
+		if !ok || req.Client != ip {
+			httpError(w, http.StatusNotFound, "request %d not found", id)
+			return
+		}
+		writeUnblockJSON(w, map[string]interface{}{"id": req.ID, "status": req.Status})
+
+	case http.MethodPost:
+		req := unblockSubmitJSON{}
+		err := json.NewDecoder(io.LimitReader(r.Body, unblockMaxBody)).Decode(&req)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+			return
+		}
+		domain := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Domain), "."))
+		if utils.IsValidHostname(domain) != nil {
+			httpError(w, http.StatusBadRequest, "invalid domain %q", req.Domain)
+			return
+		}
+		reason := strings.TrimSpace(req.Reason)
+		if len([]rune(reason)) > unblockMaxReason {
+			httpError(w, http.StatusBadRequest, "the reason is longer than %d characters", unblockMaxReason)
+			return
+		}
+
+		ur := unblockRequest{Domain: domain, Client: ip, Reason: reason}
+		if c, ok := Context.clients.Find(ip); ok {
+			ur.ClientName = c.Name
+		} else if ch, ok := Context.clients.FindAutoClient(ip); ok {
+			ur.ClientName = ch.Host
+		}
+		id, err := u.add(ur, time.Now())
+		if err != nil {
+			httpError(w, http.StatusTooManyRequests, "%s", err)
+			return
+		}
+		log.Info("unblock: request %d from %s for %s", id, ip, domain)
+		notify(notifyUnblockRequest, fmt.Sprintf("%d", id),
+			fmt.Sprintf("%s (%s) asks to unblock %s: %s", ip, ur.ClientName, domain, reason))
+		writeUnblockJSON(w, map[string]interface{}{"id": id, "status": unblockPending})
+
+	default:
+		http.Error(w, "This request must be GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeUnblockJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// GET /control/unblock_requests
+func handleUnblockList(w http.ResponseWriter, r *http.Request) {
+	u := Context.unblock
+	if u == nil {
+		httpError(w, http.StatusNotFound, "Unblock requests are disabled")
+		return
+	}
+	writeUnblockJSON(w, u.list())
+}
+
+type unblockResolveJSON struct {
+	ID        int64 `json:"id"`
+	ForClient bool  `json:"for_client"` // approve: unblock the domain only for the client that has submitted the request
+}
+
+// POST /control/unblock_requests/approve
+// POST /control/unblock_requests/reject
+func handleUnblockResolve(approve bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := Context.unblock
+		if u == nil {
+			httpError(w, http.StatusNotFound, "Unblock requests are disabled")
+			return
+		}
+		req := unblockResolveJSON{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+			return
+		}
+
+		if !approve {
+			res, err := u.resolve(req.ID, unblockRejected, "", time.Now())
+			if err != nil {
+				httpError(w, http.StatusBadRequest, "%s", err)
+				return
+			}
+			writeUnblockJSON(w, res)
+			return
+		}
+
+		ur, ok := u.get(req.ID)
+		if !ok {
+			httpError(w, http.StatusBadRequest, "request %d not found", req.ID)
+			return
+		}
+		client := ""
+		if req.ForClient {
+			client = unblockClientID(ur)
+		}
+		rule := unblockRule(ur.Domain, client)
+		res, err := u.resolve(req.ID, unblockApproved, rule, time.Now())
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+
+		config.Lock()
+		config.UserRules = append(config.UserRules, rule)
+		config.Unlock()
+		onConfigModified()
+		enableFilters(true)
+		log.Info("unblock: request %d is approved: %s", req.ID, rule)
+		writeUnblockJSON(w, res)
+	}
+}
+
+func registerUnblockHandlers() {
+	httpRegister(http.MethodGet, "/control/unblock_requests", handleUnblockList)
+	httpRegister(http.MethodPost, "/control/unblock_requests/approve", handleUnblockResolve(true))
+	httpRegister(http.MethodPost, "/control/unblock_requests/reject", handleUnblockResolve(false))
+	// the users submit the requests without authentication
+	httpRegister("", "/control/unblock_request", handleUnblockRequest)
+}
@@ -0,0 +1,69 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnblockRequests(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context.unblock = &unblockModule{filename: filepath.Join(dir, unblockFilename)}
+	defer func() { Context.unblock = nil }()
+
+	submit := func(ip, body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/control/unblock_request", strings.NewReader(body))
+		r.RemoteAddr = ip + ":12345"
+		w := httptest.NewRecorder()
+		handleUnblockRequest(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, submit("192.168.1.2", `{"domain":"Example.org.","reason":"school site"}`))
+	// the same request isn't added again
+	assert.Equal(t, http.StatusOK, submit("192.168.1.2", `{"domain":"example.org"}`))
+	assert.Equal(t, http.StatusBadRequest, submit("192.168.1.2", `{"domain":"bad domain"}`))
+	for i := 0; i != unblockMaxPerClient-1; i++ {
+		assert.Equal(t, http.StatusOK, submit("192.168.1.2", `{"domain":"`+string(rune('a'+i))+`.example.org"}`))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, submit("192.168.1.2", `{"domain":"x.example.org"}`))
+	assert.Equal(t, http.StatusOK, submit("192.168.1.3", `{"domain":"x.example.org"}`))
+
+	list := Context.unblock.list()
+	assert.Equal(t, unblockMaxPerClient+1, len(list))
+	first := list[len(list)-1]
+	assert.Equal(t, "example.org", first.Domain)
+	assert.Equal(t, "192.168.1.2", first.Client)
+	assert.Equal(t, "school site", first.Reason)
+	assert.Equal(t, unblockPending, first.Status)
+
+	// the status is only returned to the client that has submitted the request
+	r := httptest.NewRequest(http.MethodGet, "/control/unblock_request?id=1", nil)
+	r.RemoteAddr = "192.168.1.3:12345"
+	w := httptest.NewRecorder()
+	handleUnblockRequest(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	rule := unblockRule(first.Domain, first.Client)
+	assert.Equal(t, "@@||example.org^$client='192.168.1.2'", rule)
+	assert.Equal(t, "@@||example.org^", unblockRule("example.org", ""))
+	res, err := Context.unblock.resolve(first.ID, unblockApproved, rule, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, rule, res.Rule)
+	_, err = Context.unblock.resolve(first.ID, unblockRejected, "", time.Now())
+	assert.NotNil(t, err)
+
+	// the requests are stored on disk
+	u := &unblockModule{filename: Context.unblock.filename}
+	u.load()
+	assert.Equal(t, int64(unblockMaxPerClient+1), u.lastID)
+	req, ok := u.get(first.ID)
+	assert.True(t, ok)
+	assert.Equal(t, unblockApproved, req.Status)
+}
@@ -0,0 +1,85 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/unbound"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+type unboundImportReq struct {
+	Data string `json:"data"` // pfSense/OPNsense config.xml or unbound.conf contents
+}
+
+type unboundImportResp struct {
+	Rewrites  int `json:"rewrites"`  // the number of the added DNS rewrites
+	Upstreams int `json:"upstreams"` // the number of the added upstream servers for domains
+	Skipped   int `json:"skipped"`   // the number of the entries that weren't imported
+}
+
+// importUnbound - add Unbound settings to the configuration:
+// . host override -> DNS rewrite
+// . domain override -> "[/domain/]server" upstream
+func importUnbound(d *unbound.Data) unboundImportResp {
+	resp := unboundImportResp{Skipped: d.Skipped}
+
+	config.Lock()
+	defer config.Unlock()
+
+	for _, r := range d.Records {
+		if !r.Valid() {
+			resp.Skipped++
+			continue
+		}
+		if addRewriteNoLock(r.Domain, r.Answer) {
+			resp.Rewrites++
+		}
+	}
+
+	existing := map[string]bool{}
+	for _, u := range config.DNS.UpstreamDNS {
+		existing[u] = true
+	}
+	for _, f := range d.Forwards {
+		for _, s := range f.Servers {
+			u := fmt.Sprintf("[/%s/]%s", f.Domain, s)
+			if existing[u] {
+				continue
+			}
+			existing[u] = true
+			config.DNS.UpstreamDNS = append(config.DNS.UpstreamDNS, u)
+			resp.Upstreams++
+		}
+	}
+	return resp
+}
+
+// Import Unbound host and domain overrides (the first-run setup only)
+// The configuration is written when the setup is completed.
+func handleInstallImportUnbound(w http.ResponseWriter, r *http.Request) {
+	req := unboundImportReq{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	d, err := unbound.Import([]byte(req.Data))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Couldn't read Unbound settings: %s", err)
+		return
+	}
+
+	resp := importUnbound(d)
+	log.Info("Imported Unbound settings: %d rewrites, %d upstreams, %d entries skipped",
+		resp.Rewrites, resp.Upstreams, resp.Skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
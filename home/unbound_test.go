@@ -0,0 +1,38 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/unbound"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportUnbound(t *testing.T) {
+	oldUpstreams := config.DNS.UpstreamDNS
+	oldRewrites := config.DNS.DnsfilterConf.Rewrites
+	defer func() {
+		config.DNS.UpstreamDNS = oldUpstreams
+		config.DNS.DnsfilterConf.Rewrites = oldRewrites
+	}()
+	config.DNS.UpstreamDNS = []string{"1.1.1.1"}
+	config.DNS.DnsfilterConf.Rewrites = nil
+
+	d := &unbound.Data{
+		Records: []unbound.Record{
+			{Domain: "NAS.lan", Answer: "192.168.1.10"},
+			{Domain: "bad name.lan", Answer: "192.168.1.11"},
+		},
+		Forwards: []unbound.Forward{
+			{Domain: "corp.example", Servers: []string{"10.0.0.1", "10.0.0.2:5353"}},
+		},
+		Skipped: 1,
+	}
+	resp := importUnbound(d)
+	assert.Equal(t, unboundImportResp{Rewrites: 1, Upstreams: 2, Skipped: 2}, resp)
+	assert.Equal(t, "nas.lan", config.DNS.DnsfilterConf.Rewrites[0].Domain)
+	assert.Equal(t, []string{"1.1.1.1", "[/corp.example/]10.0.0.1", "[/corp.example/]10.0.0.2:5353"}, config.DNS.UpstreamDNS)
+
+	// duplicates aren't added
+	resp = importUnbound(d)
+	assert.Equal(t, 0, resp.Rewrites+resp.Upstreams)
+}
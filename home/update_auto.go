@@ -0,0 +1,211 @@
+package home
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// How often we check whether it's time to update
+const autoUpdateCheckInterval = 15 * time.Minute
+
+// For how long the new version is checked after an update before it's rolled back
+const updateVerifyTimeout = 2 * time.Minute
+
+// autoUpdateConfig - settings of the scheduled updates
+type autoUpdateConfig struct {
+	// Install the new versions automatically
+	Enabled bool `yaml:"enabled"`
+
+	// Maintenance window in the time zone set by dns.time_zone, e.g. "03:00-05:00" ("": use maintenance_window).
+	// The window may cross midnight, e.g. "23:00-01:00".
+	Window string `yaml:"window"`
+}
+
+// timeWindow - the time interval within a day
+type timeWindow struct {
+	start time.Duration // since midnight
+	end   time.Duration // since midnight
+}
+
+// parseDayTime - parse "HH:MM" string
+func parseDayTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("%s: invalid time", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 23 {
+		return 0, fmt.Errorf("%s: invalid hour", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 {
+		return 0, fmt.Errorf("%s: invalid minute", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseTimeWindow - parse "HH:MM-HH:MM" string
+func parseTimeWindow(s string) (timeWindow, error) {
+	w := timeWindow{}
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 2 {
+		return w, fmt.Errorf("%s: invalid time window", s)
+	}
+	var err error
+	w.start, err = parseDayTime(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return w, err
+	}
+	w.end, err = parseDayTime(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("%s: empty time window", s)
+	}
+	return w, nil
+}
+
+// contains - return TRUE if the time is within the window
+func (w timeWindow) contains(t time.Time) bool {
+	y, mo, d := t.Date()
+	offset := t.Sub(time.Date(y, mo, d, 0, 0, 0, 0, t.Location()))
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	// the window crosses midnight
+	return offset >= w.start || offset < w.end
+}
+
+// untilStart - get the time until the window is opened
+func (w timeWindow) untilStart(t time.Time) time.Duration {
+	y, mo, d := t.Date()
+	offset := t.Sub(time.Date(y, mo, d, 0, 0, 0, 0, t.Location()))
+	until := w.start - offset
+	if until <= 0 {
+		until += 24 * time.Hour
+	}
+	return until
+}
+
+// autoUpdateLoop - install the new version during the maintenance window
+func autoUpdateLoop() {
+	lastDay := ""
+	for {
+		time.Sleep(autoUpdateCheckInterval)
+
+		w, now := maintenanceWindow(config.AutoUpdate.Window)
+		if w == nil {
+			continue
+		}
+		// the window may cross midnight, so the day it started is used
+		day := now.Add(-w.start).Format("2006-01-02")
+		if !w.contains(now) || day == lastDay {
+			continue
+		}
+		lastDay = day
+
+		if autoUpdate() {
+			finishUpdate()
+		}
+	}
+}
+
+// autoUpdate - check for the new version and update to it
+// Return TRUE if the application must be restarted.
+func autoUpdate() bool {
+	Context.controlLock.Lock()
+	defer Context.controlLock.Unlock()
+
+	info, err := Context.updater.GetVersionResponse(true)
+	if err != nil {
+		log.Error("Auto-update: couldn't check for updates: %s", err)
+		return false
+	}
+	if len(info.NewVersion) == 0 {
+		log.Debug("Auto-update: no new version")
+		return false
+	}
+	if !canAutoUpdate(info) {
+		log.Info("Auto-update: version %s is available but can't be installed automatically", info.NewVersion)
+		return false
+	}
+
+	log.Info("Auto-update: updating to %s", info.NewVersion)
+	err = Context.updater.DoUpdate()
+	if err != nil {
+		log.Error("Auto-update: %s", err)
+		return false
+	}
+	return true
+}
+
+// startAutoUpdate - start the scheduled updates if they are enabled
+func startAutoUpdate() {
+	if !config.AutoUpdate.Enabled || Context.disableUpdate {
+		return
+	}
+	w := config.AutoUpdate.Window
+	if len(w) == 0 {
+		w = config.MaintenanceWindow
+	}
+	_, err := parseTimeWindow(w)
+	if err != nil {
+		log.Error("Auto-update: %s", err)
+		return
+	}
+	log.Info("Auto-update: maintenance window is %s", w)
+	go autoUpdateLoop()
+}
+
+// checkDNS - send a DNS request to our DNS server
+// Any response means that the server works.
+func checkDNS() error {
+	host := config.DNS.BindHost
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		host = util.LocalhostIP()
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(config.DNS.Port))
+
+	req := dns.Msg{}
+	req.SetQuestion("version.bind.", dns.TypeA)
+	c := dns.Client{Timeout: 5 * time.Second}
+	_, _, err := c.Exchange(&req, addr)
+	return err
+}
+
+// verifyUpdate - make sure the new version serves DNS requests, otherwise roll back to the previous version
+func verifyUpdate() {
+	var err error
+	deadline := time.Now().Add(updateVerifyTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		err = checkDNS()
+		if err == nil {
+			Context.updater.ConfirmUpdate()
+			return
+		}
+		log.Debug("Update verification: %s", err)
+	}
+
+	log.Error("Update verification failed: DNS server doesn't respond: %s", err)
+	rollbackUpdate()
+}
+
+// rollbackUpdate - restore the previous version and restart
+func rollbackUpdate() {
+	err := Context.updater.Rollback()
+	if err != nil {
+		log.Error("Couldn't roll back the update: %s", err)
+		return
+	}
+	finishUpdate()
+}
@@ -0,0 +1,57 @@
+package home
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	w, err := parseTimeWindow("03:00-05:30")
+	assert.Nil(t, err)
+	assert.Equal(t, 3*time.Hour, w.start)
+	assert.Equal(t, 5*time.Hour+30*time.Minute, w.end)
+
+	day := func(h, m int) time.Time {
+		return time.Date(2020, 7, 1, h, m, 0, 0, time.Local)
+	}
+	assert.False(t, w.contains(day(2, 59)))
+	assert.True(t, w.contains(day(3, 0)))
+	assert.True(t, w.contains(day(5, 29)))
+	assert.False(t, w.contains(day(5, 30)))
+
+	// crosses midnight
+	w, err = parseTimeWindow("23:00 - 01:00")
+	assert.Nil(t, err)
+	assert.True(t, w.contains(day(23, 30)))
+	assert.True(t, w.contains(day(0, 30)))
+	assert.False(t, w.contains(day(1, 0)))
+	assert.False(t, w.contains(day(22, 59)))
+
+	assert.Equal(t, 30*time.Minute, w.untilStart(day(22, 30)))
+	assert.Equal(t, 24*time.Hour, w.untilStart(day(23, 0)))
+	assert.Equal(t, 23*time.Hour, w.untilStart(day(0, 0)))
+
+	_, err = parseTimeWindow("03:00")
+	assert.NotNil(t, err)
+	_, err = parseTimeWindow("25:00-05:00")
+	assert.NotNil(t, err)
+	_, err = parseTimeWindow("03:00-03:00")
+	assert.NotNil(t, err)
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	config.MaintenanceWindow = ""
+	mw, _ := maintenanceWindow("")
+	assert.Nil(t, mw)
+	assert.True(t, inMaintenanceWindow())
+
+	// the window of the job overrides the common one
+	config.MaintenanceWindow = "03:00-05:00"
+	defer func() { config.MaintenanceWindow = "" }()
+	mw, _ = maintenanceWindow("23:00-01:00")
+	assert.Equal(t, 23*time.Hour, mw.start)
+	mw, _ = maintenanceWindow("")
+	assert.Equal(t, 3*time.Hour, mw.start)
+}
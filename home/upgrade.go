@@ -96,6 +96,12 @@ func upgradeConfigSchema(oldVersion int, diskConfig *map[string]interface{}) err
 		return err
 	}
 
+	// keep the file of the previous version
+	oldBody, err := readConfigFile()
+	if err == nil {
+		saveConfigSnapshot(oldBody)
+	}
+
 	configFile := config.getConfigFilename()
 	body, err := yaml.Marshal(diskConfig)
 	if err != nil {
@@ -110,6 +116,12 @@ func upgradeConfigSchema(oldVersion int, diskConfig *map[string]interface{}) err
 		return err
 	}
 
+	err = pushRemoteConfig(body)
+	if err != nil {
+		log.Printf("%s", err)
+		return err
+	}
+
 	return nil
 }
 
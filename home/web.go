@@ -20,6 +20,11 @@ type WebConfig struct {
 	BindHost  string
 	BindPort  int
 	PortHTTPS int
+
+	AllowedClients []string // IP addresses or CIDRs of the clients that may use the web interface (empty: all)
+	TrustedProxies []string // IP addresses or CIDRs of the reverse proxies
+
+	Listeners []*listenerAccess // if set, listen on these addresses instead of BindHost
 }
 
 // HTTPSServer - HTTPS Server
@@ -40,6 +45,7 @@ type Web struct {
 	httpServer  *http.Server // HTTP module
 	httpsServer HTTPSServer  // HTTPS module
 	errLogger   *golog.Logger
+	access      *webAccess // access restrictions (nil: none)
 }
 
 // Proxy between Go's "log" and "golibs/log"
@@ -59,6 +65,19 @@ func CreateWeb(conf *WebConfig) *Web {
 	w := Web{}
 	w.conf = conf
 
+	var err error
+	w.access, err = newWebAccess(conf.AllowedClients, conf.TrustedProxies)
+	if err != nil {
+		log.Error("Web: %s", err)
+		return nil
+	}
+	if len(conf.Listeners) != 0 {
+		if w.access == nil {
+			w.access = &webAccess{}
+		}
+		w.access.listeners = conf.Listeners
+	}
+
 	lw := logWriter{}
 	w.errLogger = golog.New(&lw, "", 0)
 
@@ -68,6 +87,9 @@ func CreateWeb(conf *WebConfig) *Web {
 	// if not configured, redirect / to /install.html, otherwise redirect /install.html to /
 	http.Handle("/", postInstallHandler(optionalAuthHandler(gziphandler.GzipHandler(http.FileServer(box)))))
 
+	// the provisioning API is available before and after the first-run configuration
+	http.HandleFunc("/control/install/provision", w.provisionHandler())
+
 	// add handlers for /install paths, we only need them when we're not configured yet
 	if conf.firstRun {
 		log.Info("This is the first launch of AdGuard Home, redirecting everything to /install.html ")
@@ -115,6 +137,7 @@ func (web *Web) TLSConfigChanged(tlsConf tlsConfigSettings) {
 		if err != nil {
 			log.Fatal(err)
 		}
+		cert.OCSPStaple = tlsConf.OCSPStaple
 	}
 
 	web.httpsServer.cond.L.Lock()
@@ -134,15 +157,14 @@ func (web *Web) Start() {
 
 	// this loop is used as an ability to change listening host and/or port
 	for !web.httpsServer.shutdown {
-		printHTTPAddresses("http")
-
 		// we need to have new instance, because after Shutdown() the Server is not usable
 		address := net.JoinHostPort(web.conf.BindHost, strconv.Itoa(web.conf.BindPort))
 		web.httpServer = &http.Server{
 			ErrorLog: web.errLogger,
 			Addr:     address,
+			Handler:  web.access.handler(http.DefaultServeMux),
 		}
-		err := web.httpServer.ListenAndServe()
+		err := web.listenAndServe(web.httpServer, web.conf.BindPort, false)
 		if err != http.ErrServerClosed {
 			cleanupAlways()
 			log.Fatal(err)
@@ -191,6 +213,7 @@ func (web *Web) tlsServerLoop() {
 		web.httpsServer.server = &http.Server{
 			ErrorLog: web.errLogger,
 			Addr:     address,
+			Handler:  web.access.handler(http.DefaultServeMux),
 			TLSConfig: &tls.Config{
 				Certificates: []tls.Certificate{web.httpsServer.cert},
 				MinVersion:   tls.VersionTLS12,
@@ -199,11 +222,65 @@ func (web *Web) tlsServerLoop() {
 			},
 		}
 
-		printHTTPAddresses("https")
-		err := web.httpsServer.server.ListenAndServeTLS("", "")
+		err := web.listenAndServe(web.httpsServer.server, web.conf.PortHTTPS, true)
 		if err != http.ErrServerClosed {
 			cleanupAlways()
 			log.Fatal(err)
 		}
 	}
 }
+
+// listenAndServe - listen on BindHost or on the listeners' addresses and serve the requests
+// Return http.ErrServerClosed after the server is shut down.
+func (web *Web) listenAndServe(srv *http.Server, port int, useTLS bool) error {
+	proto := "http"
+	if useTLS {
+		proto = "https"
+	}
+
+	if len(web.conf.Listeners) == 0 {
+		printHTTPAddresses(proto)
+		if useTLS {
+			return srv.ListenAndServeTLS("", "")
+		}
+		return srv.ListenAndServe()
+	}
+
+	listeners := []net.Listener{}
+	for _, l := range web.conf.Listeners {
+		for _, ip := range l.ips {
+			addr := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				for _, ln := range listeners {
+					_ = ln.Close()
+				}
+				return err
+			}
+			log.Printf("Go to %s://%s", proto, addr)
+			listeners = append(listeners, ln)
+		}
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if useTLS {
+				errs <- srv.ServeTLS(ln, "", "")
+			} else {
+				errs <- srv.Serve(ln)
+			}
+		}(ln)
+	}
+
+	// all listeners stop when the server is shut down
+	var err error = http.ErrServerClosed
+	for range listeners {
+		e := <-errs
+		if e != http.ErrServerClosed {
+			err = e
+			_ = srv.Close()
+		}
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package home
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// webAccess - restricts access to the web interface by the client's IP address
+type webAccess struct {
+	allowedNets    []*net.IPNet      // the clients that may use the web interface (empty: all)
+	trustedProxies []*net.IPNet      // the proxies whose X-Forwarded-For and X-Real-IP headers we trust
+	listeners      []*listenerAccess // access settings of the listen addresses
+}
+
+// listenerAccess - the addresses the web interface listens on with their own access settings
+type listenerAccess struct {
+	ips        []net.IP
+	allowed    []*net.IPNet // the clients that may connect to these addresses (empty: all)
+	disallowed []*net.IPNet // the clients that may not connect to these addresses
+}
+
+// newListenerAccess - create a new object
+func newListenerAccess(ips []net.IP, allowedClients, disallowedClients []string) (*listenerAccess, error) {
+	l := &listenerAccess{ips: ips}
+	var err error
+	l.allowed, err = parseIPNets(allowedClients)
+	if err != nil {
+		return nil, fmt.Errorf("allowed_clients: %s", err)
+	}
+	l.disallowed, err = parseIPNets(disallowedClients)
+	if err != nil {
+		return nil, fmt.Errorf("disallowed_clients: %s", err)
+	}
+	return l, nil
+}
+
+// isAllowed - check if the client may connect to the listener's addresses
+func (l *listenerAccess) isAllowed(ip net.IP) bool {
+	if len(l.allowed) != 0 && (ip == nil || !ipNetsContain(l.allowed, ip)) {
+		return false
+	}
+	return ip == nil || !ipNetsContain(l.disallowed, ip)
+}
+
+// listener - find the listener by the local address of the connection
+func (a *webAccess) listener(r *http.Request) *listenerAccess {
+	if len(a.listeners) == 0 {
+		return nil
+	}
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	for _, l := range a.listeners {
+		for _, ip := range l.ips {
+			if ip.Equal(addr.IP) {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// newWebAccess - create a new object
+// Return nil if there are no restrictions and no trusted proxies.
+func newWebAccess(allowedClients, trustedProxies []string) (*webAccess, error) {
+	if len(allowedClients) == 0 && len(trustedProxies) == 0 {
+		return nil, nil
+	}
+
+	a := &webAccess{}
+	var err error
+	a.allowedNets, err = parseIPNets(allowedClients)
+	if err != nil {
+		return nil, fmt.Errorf("web_allowed_clients: %s", err)
+	}
+	a.trustedProxies, err = parseIPNets(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %s", err)
+	}
+	return a, nil
+}
+
+// parseIPNets - parse the list of IP addresses and CIDRs
+func parseIPNets(list []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range list {
+		ip := net.ParseIP(util.TrimBrackets(s))
+		if ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			} else {
+				ip = ip.To4()
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR: %s", s)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// ipNetsContain - check if any of the networks contains the IP address
+func ipNetsContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostIP - parse IP address that may be in square brackets and may have a port
+// e.g. "2001:db8::1", "[2001:db8::1]", "[2001:db8::1]:1234" or "192.168.1.1:1234"
+func parseHostIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	host, _, err := net.SplitHostPort(s)
+	if err == nil {
+		s = host
+	}
+	return net.ParseIP(util.TrimBrackets(s))
+}
+
+// clientIP - get the client's IP address
+// If the request comes from a trusted proxy, the address is taken from X-Forwarded-For or X-Real-IP header.
+func (a *webAccess) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if a == nil || ip == nil || !ipNetsContain(a.trustedProxies, ip) {
+		return ip
+	}
+
+	// the last addresses are added by the proxies closest to us:
+	//  take the rightmost address that isn't a trusted proxy
+	xff := r.Header.Values("X-Forwarded-For")
+	addrs := []string{}
+	for _, v := range xff {
+		addrs = append(addrs, strings.Split(v, ",")...)
+	}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		fwdIP := parseHostIP(addrs[i])
+		if fwdIP == nil {
+			log.Debug("Web: invalid X-Forwarded-For value from %s: %q", ip, addrs[i])
+			return ip
+		}
+		ip = fwdIP
+		if !ipNetsContain(a.trustedProxies, ip) {
+			return ip
+		}
+	}
+	if len(addrs) != 0 {
+		return ip
+	}
+
+	realIP := parseHostIP(r.Header.Get("X-Real-IP"))
+	if realIP != nil {
+		return realIP
+	}
+	return ip
+}
+
+// isAllowed - check if the client may use the web interface
+func (a *webAccess) isAllowed(ip net.IP) bool {
+	if a == nil || len(a.allowedNets) == 0 {
+		return true
+	}
+	return ip != nil && ipNetsContain(a.allowedNets, ip)
+}
+
+// handler - wrap the HTTP handler so it responds with 403 to the clients that aren't allowed
+// DNS-over-HTTPS requests are controlled by DNS access settings and aren't restricted by web_allowed_clients,
+// but the access settings of the listener apply to them.
+func (a *webAccess) handler(h http.Handler) http.Handler {
+	if a == nil || (len(a.allowedNets) == 0 && len(a.listeners) == 0) {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := a.clientIP(r)
+		allowed := r.URL.Path == "/dns-query" || a.isAllowed(ip)
+		if allowed {
+			l := a.listener(r)
+			allowed = l == nil || l.isAllowed(ip)
+		}
+		if !allowed {
+			log.Debug("Web: access denied for %s: %s %s", ip, r.Method, r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// remoteIP - get the client's IP address
+func remoteIP(r *http.Request) string {
+	var a *webAccess
+	if Context.web != nil {
+		a = Context.web.access
+	}
+	ip := a.clientIP(r)
+	if ip == nil {
+		return r.RemoteAddr
+	}
+	return ip.String()
+}
@@ -0,0 +1,95 @@
+package home
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebAccess(t *testing.T) {
+	a, err := newWebAccess(nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, a)
+	assert.True(t, a.isAllowed(net.ParseIP("1.2.3.4")))
+
+	_, err = newWebAccess([]string{"1.2.3.4/33"}, nil)
+	assert.NotNil(t, err)
+
+	a, err = newWebAccess([]string{"192.168.1.0/24", "10.0.0.1", "fe80::/64", "[2001:db8::5]"}, []string{"127.0.0.1"})
+	assert.Nil(t, err)
+	assert.True(t, a.isAllowed(net.ParseIP("2001:db8::5")))
+	assert.True(t, a.isAllowed(net.ParseIP("192.168.1.5")))
+	assert.True(t, a.isAllowed(net.ParseIP("10.0.0.1")))
+	assert.True(t, a.isAllowed(net.ParseIP("fe80::1")))
+	assert.False(t, a.isAllowed(net.ParseIP("10.0.0.2")))
+	assert.False(t, a.isAllowed(nil))
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "1.2.3.4:1234"
+	r.Header.Set("X-Forwarded-For", "192.168.1.5")
+	// not a trusted proxy
+	assert.Equal(t, "1.2.3.4", a.clientIP(r).String())
+
+	r.RemoteAddr = "127.0.0.1:1234"
+	assert.Equal(t, "192.168.1.5", a.clientIP(r).String())
+
+	// the leftmost value may be spoofed by the client
+	r.Header.Set("X-Forwarded-For", "192.168.1.5, 1.2.3.4, 127.0.0.1")
+	assert.Equal(t, "1.2.3.4", a.clientIP(r).String())
+
+	// IPv6 addresses in square brackets, with or without port
+	r.Header.Set("X-Forwarded-For", "[2001:db8::1]:1234")
+	assert.Equal(t, "2001:db8::1", a.clientIP(r).String())
+	r.Header.Set("X-Forwarded-For", "[2001:db8::1]")
+	assert.Equal(t, "2001:db8::1", a.clientIP(r).String())
+
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("X-Real-IP", "10.0.0.1")
+	assert.Equal(t, "10.0.0.1", a.clientIP(r).String())
+
+	handler := a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	r.Header.Set("X-Real-IP", "10.0.0.2")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// DNS-over-HTTPS isn't restricted
+	r.URL.Path = "/dns-query"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestWebAccessListener(t *testing.T) {
+	l, err := newListenerAccess([]net.IP{{192, 168, 10, 1}}, []string{"192.168.10.0/24"}, []string{"192.168.10.5"})
+	assert.Nil(t, err)
+	_, err = newListenerAccess(nil, nil, []string{"192.168.10"})
+	assert.NotNil(t, err)
+
+	a := &webAccess{listeners: []*listenerAccess{l}}
+	handler := a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	check := func(localIP, clientIP, path string) int {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.RemoteAddr = net.JoinHostPort(clientIP, "1234")
+		ctx := context.WithValue(r.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.ParseIP(localIP), Port: 80})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, check("192.168.10.1", "192.168.10.2", "/"))
+	assert.Equal(t, http.StatusForbidden, check("192.168.10.1", "192.168.20.2", "/"))
+	assert.Equal(t, http.StatusForbidden, check("192.168.10.1", "192.168.10.5", "/"))
+	// the listener's settings apply to DNS-over-HTTPS too
+	assert.Equal(t, http.StatusForbidden, check("192.168.10.1", "192.168.20.2", "/dns-query"))
+	// another address
+	assert.Equal(t, http.StatusOK, check("192.168.20.1", "192.168.20.2", "/"))
+}
@@ -228,6 +228,7 @@ func (w *Whois) workerLoop() {
 		ip = <-w.ipChan
 
 		info := w.process(ip)
+		backgroundJobPause()
 		if len(info) == 0 {
 			continue
 		}
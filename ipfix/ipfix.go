@@ -0,0 +1,416 @@
+// Package ipfix exports DNS query records to a flow collector (IPFIX or NetFlow v9 over UDP)
+package ipfix
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Export protocol versions
+const (
+	VersionNetFlow9 = 9
+	VersionIPFIX    = 10
+)
+
+// Query name export policy
+const (
+	QNamePlain = "plain" // host name
+	QNameHash  = "hash"  // 8 bytes of SHA-256 hash of the salt and host name
+	QNameNone  = "none"  // host name isn't exported
+)
+
+// DefaultEnterpriseNumber - the enterprise number for the DNS information elements:
+// 32473 is reserved for documentation (RFC 5612).
+const DefaultEnterpriseNumber = 32473
+
+// Standard information elements (IANA)
+const (
+	ieProtocolIdentifier    = 4
+	ieSourceTransportPort   = 7
+	ieSourceIPv4Address     = 8
+	ieSourceIPv6Address     = 27
+	ieFlowStartMilliseconds = 152
+)
+
+// Enterprise-specific information elements (with the enterprise bit set)
+// IPFIX: followed by the enterprise number in the template; NetFlow v9: the field type as is.
+const (
+	enterpriseBit = 0x8000
+
+	ieDNSQName          = enterpriseBit | 1 // string
+	ieDNSQNameHash      = enterpriseBit | 2 // 8 bytes
+	ieDNSQType          = enterpriseBit | 3 // unsigned16
+	ieDNSRcode          = enterpriseBit | 4 // unsigned8 (255: no response)
+	ieDNSRequestBytes   = enterpriseBit | 5 // unsigned16
+	ieDNSResponseBytes  = enterpriseBit | 6 // unsigned16
+	ieDNSFiltered       = enterpriseBit | 7 // unsigned8 (boolean)
+	ieDNSProcessingTime = enterpriseBit | 8 // unsigned32 (microseconds)
+)
+
+// NetFlow v9 doesn't support variable-length fields: the host name is truncated or padded with zeros
+const netflow9QNameLen = 64
+
+const varLen = 0xffff
+
+// Template IDs
+const (
+	templateIPv4 = 256
+	templateIPv6 = 257
+)
+
+// The maximum size of a packet
+const maxPacketSize = 1400
+
+// How often the templates are sent
+const templateInterval = 1 * time.Minute
+
+// How often the buffered records are sent
+const flushInterval = 1 * time.Second
+
+// Config - exporter configuration
+type Config struct {
+	Collector         string // UDP address of the collector
+	Version           int    // VersionNetFlow9 or VersionIPFIX
+	QName             string // QNamePlain, QNameHash or QNameNone
+	HashSalt          string // salt for QNameHash policy
+	ObservationDomain uint32 // IPFIX observation domain ID, NetFlow v9 source ID
+	EnterpriseNumber  uint32 // the enterprise number for the DNS information elements
+}
+
+// Record - DNS query record
+type Record struct {
+	Time       time.Time
+	ClientIP   net.IP
+	ClientPort int
+	TCP        bool
+	QName      string
+	QType      uint16
+	Rcode      int // -1: no response
+	ReqSize    int
+	RespSize   int
+	Filtered   bool
+	Elapsed    time.Duration
+}
+
+type field struct {
+	id     uint16
+	length uint16
+}
+
+// Exporter - sends the records to the collector
+type Exporter struct {
+	conf  Config
+	conn  net.Conn
+	start time.Time // NetFlow v9 system uptime
+
+	lock         sync.Mutex
+	fields       [2][]field // IPv4 and IPv6 templates
+	sets         [2][]byte  // the pending data records of IPv4 and IPv6 templates
+	nRecords     [2]int
+	seq          uint32 // IPFIX: the number of the sent data records, NetFlow v9: the number of the sent packets
+	lastTemplate time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New - create the exporter
+func New(conf Config) (*Exporter, error) {
+	if conf.Version != VersionNetFlow9 && conf.Version != VersionIPFIX {
+		return nil, fmt.Errorf("unsupported version %d", conf.Version)
+	}
+	switch conf.QName {
+	case QNamePlain, QNameHash, QNameNone:
+	default:
+		return nil, fmt.Errorf("invalid qname policy %q", conf.QName)
+	}
+	if conf.EnterpriseNumber == 0 {
+		conf.EnterpriseNumber = DefaultEnterpriseNumber
+	}
+
+	e := &Exporter{
+		conf:  conf,
+		start: time.Now(),
+	}
+	for i, ipField := range []field{{ieSourceIPv4Address, 4}, {ieSourceIPv6Address, 16}} {
+		fields := []field{
+			{ieFlowStartMilliseconds, 8},
+			ipField,
+			{ieSourceTransportPort, 2},
+			{ieProtocolIdentifier, 1},
+		}
+		switch conf.QName {
+		case QNamePlain:
+			l := uint16(varLen)
+			if conf.Version == VersionNetFlow9 {
+				l = netflow9QNameLen
+			}
+			fields = append(fields, field{ieDNSQName, l})
+		case QNameHash:
+			fields = append(fields, field{ieDNSQNameHash, 8})
+		}
+		fields = append(fields,
+			field{ieDNSQType, 2},
+			field{ieDNSRcode, 1},
+			field{ieDNSRequestBytes, 2},
+			field{ieDNSResponseBytes, 2},
+			field{ieDNSFiltered, 1},
+			field{ieDNSProcessingTime, 4},
+		)
+		e.fields[i] = fields
+	}
+	return e, nil
+}
+
+// Start - connect to the collector and start sending the records
+func (e *Exporter) Start() error {
+	conn, err := net.Dial("udp", e.conf.Collector)
+	if err != nil {
+		return err
+	}
+	e.conn = conn
+	e.done = make(chan struct{})
+	e.wg.Add(1)
+	go e.flushLoop()
+	log.Info("IPFIX: exporting DNS records to %s (version %d)", e.conf.Collector, e.conf.Version)
+	return nil
+}
+
+// Close - send the pending records and stop
+func (e *Exporter) Close() {
+	if e.conn == nil {
+		return
+	}
+	close(e.done)
+	e.wg.Wait()
+	e.flush()
+
+	e.lock.Lock()
+	_ = e.conn.Close()
+	e.conn = nil
+	e.lock.Unlock()
+}
+
+func (e *Exporter) flushLoop() {
+	defer e.wg.Done()
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-t.C:
+			e.flush()
+		}
+	}
+}
+
+// qnameHash - get the hash of the host name
+func (e *Exporter) qnameHash(host string) []byte {
+	h := sha256.Sum256([]byte(e.conf.HashSalt + host))
+	return h[:8]
+}
+
+// encodeRecord - encode the data record using the template fields
+func (e *Exporter) encodeRecord(fields []field, r Record) []byte {
+	b := []byte{}
+	for _, f := range fields {
+		switch f.id {
+		case ieFlowStartMilliseconds:
+			b = appendUint(b, uint64(r.Time.UnixNano()/int64(time.Millisecond)), 8)
+		case ieSourceIPv4Address:
+			b = append(b, r.ClientIP.To4()...)
+		case ieSourceIPv6Address:
+			b = append(b, r.ClientIP.To16()...)
+		case ieSourceTransportPort:
+			b = appendUint(b, uint64(r.ClientPort), 2)
+		case ieProtocolIdentifier:
+			proto := uint64(17)
+			if r.TCP {
+				proto = 6
+			}
+			b = appendUint(b, proto, 1)
+		case ieDNSQName:
+			if f.length == varLen {
+				b = appendVarLen(b, []byte(r.QName))
+			} else {
+				v := make([]byte, f.length)
+				copy(v, r.QName)
+				b = append(b, v...)
+			}
+		case ieDNSQNameHash:
+			b = append(b, e.qnameHash(r.QName)...)
+		case ieDNSQType:
+			b = appendUint(b, uint64(r.QType), 2)
+		case ieDNSRcode:
+			rcode := uint64(255)
+			if r.Rcode >= 0 && r.Rcode < 255 {
+				rcode = uint64(r.Rcode)
+			}
+			b = appendUint(b, rcode, 1)
+		case ieDNSRequestBytes:
+			b = appendUint(b, uint64(r.ReqSize), 2)
+		case ieDNSResponseBytes:
+			b = appendUint(b, uint64(r.RespSize), 2)
+		case ieDNSFiltered:
+			filtered := uint64(0)
+			if r.Filtered {
+				filtered = 1
+			}
+			b = appendUint(b, filtered, 1)
+		case ieDNSProcessingTime:
+			b = appendUint(b, uint64(r.Elapsed/time.Microsecond), 4)
+		}
+	}
+	return b
+}
+
+// Add - add the record to the export queue
+func (e *Exporter) Add(r Record) {
+	i := 0
+	if r.ClientIP.To4() == nil {
+		if r.ClientIP.To16() == nil {
+			return
+		}
+		i = 1
+	}
+	rec := e.encodeRecord(e.fields[i], r)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	// the size of the packet with the pending records: header + 2 set headers + paddings
+	size := 20 + 8 + len(e.sets[0]) + len(e.sets[1]) + 6 + len(rec)
+	if size > maxPacketSize {
+		e.sendLocked()
+	}
+	e.sets[i] = append(e.sets[i], rec...)
+	e.nRecords[i]++
+}
+
+// flush - send the pending records
+func (e *Exporter) flush() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.nRecords[0]+e.nRecords[1] == 0 && time.Since(e.lastTemplate) < templateInterval {
+		return
+	}
+	e.sendLocked()
+}
+
+// sendLocked - send the pending records (and the templates if necessary)
+func (e *Exporter) sendLocked() {
+	if e.conn == nil {
+		return
+	}
+	now := time.Now()
+	withTemplates := now.Sub(e.lastTemplate) >= templateInterval
+	if withTemplates {
+		e.lastTemplate = now
+	}
+	pkt := e.packet(now, withTemplates)
+	_, err := e.conn.Write(pkt)
+	if err != nil {
+		log.Debug("IPFIX: %s", err)
+	}
+	e.sets[0] = e.sets[0][:0]
+	e.sets[1] = e.sets[1][:0]
+	e.nRecords = [2]int{}
+}
+
+// packet - encode the packet with the pending records
+func (e *Exporter) packet(now time.Time, withTemplates bool) []byte {
+	v9 := e.conf.Version == VersionNetFlow9
+	body := []byte{}
+	count := 0 // NetFlow v9: the number of templates and data records
+
+	if withTemplates {
+		tset := []byte{}
+		for i, fields := range e.fields {
+			tset = appendUint(tset, uint64(templateIPv4+i), 2)
+			tset = appendUint(tset, uint64(len(fields)), 2)
+			for _, f := range fields {
+				tset = appendUint(tset, uint64(f.id), 2)
+				tset = appendUint(tset, uint64(f.length), 2)
+				if f.id&enterpriseBit != 0 && !v9 {
+					tset = appendUint(tset, uint64(e.conf.EnterpriseNumber), 4)
+				}
+			}
+			count++
+		}
+		setID := 2 // IPFIX template set
+		if v9 {
+			setID = 0 // NetFlow v9 template flowset
+		}
+		body = appendSet(body, setID, tset, v9)
+	}
+
+	nData := 0
+	for i := range e.sets {
+		if e.nRecords[i] == 0 {
+			continue
+		}
+		body = appendSet(body, templateIPv4+i, e.sets[i], v9)
+		count += e.nRecords[i]
+		nData += e.nRecords[i]
+	}
+
+	hdr := []byte{}
+	if v9 {
+		e.seq++
+		hdr = appendUint(hdr, VersionNetFlow9, 2)
+		hdr = appendUint(hdr, uint64(count), 2)
+		hdr = appendUint(hdr, uint64(now.Sub(e.start)/time.Millisecond), 4)
+		hdr = appendUint(hdr, uint64(now.Unix()), 4)
+		hdr = appendUint(hdr, uint64(e.seq), 4)
+		hdr = appendUint(hdr, uint64(e.conf.ObservationDomain), 4)
+	} else {
+		hdr = appendUint(hdr, VersionIPFIX, 2)
+		hdr = appendUint(hdr, uint64(16+len(body)), 2)
+		hdr = appendUint(hdr, uint64(now.Unix()), 4)
+		hdr = appendUint(hdr, uint64(e.seq), 4)
+		hdr = appendUint(hdr, uint64(e.conf.ObservationDomain), 4)
+		e.seq += uint32(nData)
+	}
+	return append(hdr, body...)
+}
+
+// appendSet - append the set (flowset) with header and padding
+func appendSet(b []byte, id int, data []byte, pad bool) []byte {
+	n := 4 + len(data)
+	padding := 0
+	if pad && n%4 != 0 {
+		// NetFlow v9 flowsets are padded to 4 bytes boundary
+		padding = 4 - n%4
+	}
+	b = appendUint(b, uint64(id), 2)
+	b = appendUint(b, uint64(n+padding), 2)
+	b = append(b, data...)
+	return append(b, make([]byte, padding)...)
+}
+
+// appendUint - append the unsigned integer in network byte order
+func appendUint(b []byte, v uint64, size int) []byte {
+	tmp := make([]byte, 8)
+	binary.BigEndian.PutUint64(tmp, v)
+	return append(b, tmp[8-size:]...)
+}
+
+// appendVarLen - append the variable-length value (RFC 7011, 7.)
+func appendVarLen(b []byte, v []byte) []byte {
+	if len(v) > 0xffff {
+		v = v[:0xffff]
+	}
+	if len(v) < 255 {
+		b = append(b, byte(len(v)))
+	} else {
+		b = append(b, 255)
+		b = appendUint(b, uint64(len(v)), 2)
+	}
+	return append(b, v...)
+}
@@ -0,0 +1,153 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSet struct {
+	id   uint16
+	data []byte
+}
+
+// parseSets - split the packet body into sets
+func parseSets(t *testing.T, b []byte) []testSet {
+	sets := []testSet{}
+	for len(b) != 0 {
+		assert.True(t, len(b) >= 4)
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		assert.True(t, n >= 4 && n <= len(b))
+		sets = append(sets, testSet{binary.BigEndian.Uint16(b), b[4:n]})
+		b = b[n:]
+	}
+	return sets
+}
+
+func testRecord() Record {
+	return Record{
+		Time:       time.Unix(1600000000, 0),
+		ClientIP:   net.ParseIP("192.168.1.2"),
+		ClientPort: 12345,
+		QName:      "example.org",
+		QType:      1,
+		Rcode:      0,
+		ReqSize:    29,
+		RespSize:   45,
+		Filtered:   true,
+		Elapsed:    1500 * time.Microsecond,
+	}
+}
+
+func TestIPFIX(t *testing.T) {
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	e, err := New(Config{
+		Collector:         c.LocalAddr().String(),
+		Version:           VersionIPFIX,
+		QName:             QNamePlain,
+		ObservationDomain: 7,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, e.Start())
+	e.Add(testRecord())
+	e.Close()
+
+	buf := make([]byte, 65536)
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := c.Read(buf)
+	assert.Nil(t, err)
+	pkt := buf[:n]
+
+	// header
+	assert.Equal(t, uint16(10), binary.BigEndian.Uint16(pkt))
+	assert.Equal(t, uint16(n), binary.BigEndian.Uint16(pkt[2:]))
+	assert.Equal(t, uint32(0), binary.BigEndian.Uint32(pkt[8:]))
+	assert.Equal(t, uint32(7), binary.BigEndian.Uint32(pkt[12:]))
+
+	sets := parseSets(t, pkt[16:])
+	assert.Equal(t, 2, len(sets))
+
+	// templates
+	assert.Equal(t, uint16(2), sets[0].id)
+	tmpl := sets[0].data
+	assert.Equal(t, uint16(templateIPv4), binary.BigEndian.Uint16(tmpl))
+	assert.Equal(t, uint16(11), binary.BigEndian.Uint16(tmpl[2:]))
+	// the 5th field is the host name with the enterprise number
+	f := tmpl[4+4*4:]
+	assert.Equal(t, uint16(ieDNSQName), binary.BigEndian.Uint16(f))
+	assert.Equal(t, uint16(varLen), binary.BigEndian.Uint16(f[2:]))
+	assert.Equal(t, uint32(DefaultEnterpriseNumber), binary.BigEndian.Uint32(f[4:]))
+
+	// data record
+	assert.Equal(t, uint16(templateIPv4), sets[1].id)
+	d := sets[1].data
+	assert.Equal(t, uint64(1600000000000), binary.BigEndian.Uint64(d))
+	assert.Equal(t, net.IP{192, 168, 1, 2}, net.IP(d[8:12]))
+	assert.Equal(t, uint16(12345), binary.BigEndian.Uint16(d[12:]))
+	assert.Equal(t, byte(17), d[14])
+	assert.Equal(t, byte(len("example.org")), d[15])
+	assert.Equal(t, "example.org", string(d[16:27]))
+	d = d[27:]
+	assert.Equal(t, uint16(1), binary.BigEndian.Uint16(d))
+	assert.Equal(t, byte(0), d[2])
+	assert.Equal(t, uint16(29), binary.BigEndian.Uint16(d[3:]))
+	assert.Equal(t, uint16(45), binary.BigEndian.Uint16(d[5:]))
+	assert.Equal(t, byte(1), d[7])
+	assert.Equal(t, uint32(1500), binary.BigEndian.Uint32(d[8:]))
+	assert.Equal(t, 12, len(d))
+}
+
+func TestNetFlow9(t *testing.T) {
+	e, err := New(Config{
+		Version:           VersionNetFlow9,
+		QName:             QNameHash,
+		HashSalt:          "salt",
+		ObservationDomain: 7,
+	})
+	assert.Nil(t, err)
+
+	r := testRecord()
+	r.ClientIP = net.ParseIP("2001:db8::1")
+	r.TCP = true
+	r.Rcode = -1
+	e.sets[1] = e.encodeRecord(e.fields[1], r)
+	e.nRecords[1] = 1
+	pkt := e.packet(time.Now(), true)
+
+	// header: version, count (2 templates and 1 record), uptime, time, sequence, source ID
+	assert.Equal(t, uint16(9), binary.BigEndian.Uint16(pkt))
+	assert.Equal(t, uint16(3), binary.BigEndian.Uint16(pkt[2:]))
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(pkt[12:]))
+	assert.Equal(t, uint32(7), binary.BigEndian.Uint32(pkt[16:]))
+
+	sets := parseSets(t, pkt[20:])
+	assert.Equal(t, 2, len(sets))
+	assert.Equal(t, uint16(0), sets[0].id)
+	assert.Equal(t, uint16(templateIPv6), sets[1].id)
+	// the flowsets are padded to 4 bytes
+	assert.Equal(t, 0, (len(sets[0].data)+4)%4)
+	assert.Equal(t, 0, (len(sets[1].data)+4)%4)
+
+	d := sets[1].data
+	assert.Equal(t, net.ParseIP("2001:db8::1"), net.IP(d[8:24]))
+	assert.Equal(t, byte(6), d[26])
+	assert.Equal(t, e.qnameHash("example.org"), d[27:35])
+	assert.Equal(t, byte(255), d[37])
+
+	// the host name is hashed with the salt
+	e2, _ := New(Config{Version: VersionNetFlow9, QName: QNameHash, HashSalt: "other"})
+	assert.NotEqual(t, e.qnameHash("example.org"), e2.qnameHash("example.org"))
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New(Config{Version: 5, QName: QNamePlain})
+	assert.NotNil(t, err)
+	_, err = New(Config{Version: VersionIPFIX, QName: "md5"})
+	assert.NotNil(t, err)
+}
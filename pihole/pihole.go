@@ -0,0 +1,301 @@
+// Package pihole reads the settings of Pi-hole: block lists, allowed and blocked domains, local DNS records
+package pihole
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Adlist - block list
+type Adlist struct {
+	URL     string
+	Comment string
+	Enabled bool
+}
+
+// Domain list entry types (domainlist.type)
+const (
+	domainAllowExact = 0
+	domainDenyExact  = 1
+	domainAllowRegex = 2
+	domainDenyRegex  = 3
+)
+
+// Domain - allowed or blocked domain or regular expression
+type Domain struct {
+	Domain  string
+	Regex   bool // Domain is a regular expression
+	Allow   bool // the domain is allowed, otherwise it's blocked
+	Enabled bool
+	Comment string
+}
+
+// Record - local DNS record
+type Record struct {
+	Domain string
+	Answer string // IP address or canonical name (CNAME)
+}
+
+// Data - Pi-hole settings
+type Data struct {
+	Adlists []Adlist
+	Domains []Domain
+	Records []Record
+}
+
+// Import - read Pi-hole settings from its configuration directory (e.g. /etc/pihole)
+// Pi-hole v5 stores the lists in gravity.db, the older versions store them in text files.
+// Local DNS records are read from custom.list and dnsmasq.d/05-pihole-custom-cname.conf.
+func Import(dir string) (*Data, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dir)
+	}
+
+	d := &Data{}
+	fn := filepath.Join(dir, "gravity.db")
+	if fileExists(fn) {
+		err = d.readGravity(fn)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fn, err)
+		}
+	} else {
+		err = d.readLegacyLists(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = d.readHosts(filepath.Join(dir, "custom.list"))
+	if err != nil {
+		return nil, err
+	}
+	err = d.readCNAMEs(filepath.Join(dir, "..", "dnsmasq.d", "05-pihole-custom-cname.conf"))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("Pi-hole: %s: %d adlists, %d domains, %d records",
+		dir, len(d.Adlists), len(d.Domains), len(d.Records))
+	return d, nil
+}
+
+func fileExists(fn string) bool {
+	_, err := os.Stat(fn)
+	return err == nil
+}
+
+func rowString(row sqliteRow, col string) string {
+	s, _ := row[col].(string)
+	return s
+}
+
+func rowInt(row sqliteRow, col string, def int64) int64 {
+	n, ok := row[col].(int64)
+	if !ok {
+		return def
+	}
+	return n
+}
+
+// readGravity - read the lists from gravity.db
+func (d *Data) readGravity(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	db, err := openSQLite(f, st.Size())
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.readTable("adlist")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		a := Adlist{
+			URL:     rowString(row, "address"),
+			Comment: rowString(row, "comment"),
+			Enabled: rowInt(row, "enabled", 1) != 0,
+		}
+		if len(a.URL) != 0 {
+			d.Adlists = append(d.Adlists, a)
+		}
+	}
+
+	rows, err = db.readTable("domainlist")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		t := rowInt(row, "type", domainAllowExact)
+		dom := Domain{
+			Domain:  rowString(row, "domain"),
+			Regex:   t == domainAllowRegex || t == domainDenyRegex,
+			Allow:   t == domainAllowExact || t == domainAllowRegex,
+			Enabled: rowInt(row, "enabled", 1) != 0,
+			Comment: rowString(row, "comment"),
+		}
+		if len(dom.Domain) != 0 && t >= domainAllowExact && t <= domainDenyRegex {
+			d.Domains = append(d.Domains, dom)
+		}
+	}
+	return nil
+}
+
+// readLines - read non-empty lines from the file (nil if it doesn't exist)
+func readLines(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) != 0 {
+			lines = append(lines, line)
+		}
+	}
+	if sc.Err() != nil {
+		return nil, fmt.Errorf("%s: %s", fn, sc.Err())
+	}
+	return lines, nil
+}
+
+// readLegacyLists - read the lists from the text files of Pi-hole v4
+func (d *Data) readLegacyLists(dir string) error {
+	lines, err := readLines(filepath.Join(dir, "adlists.list"))
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		// the disabled lists are commented out
+		a := Adlist{Enabled: true}
+		if line[0] == '#' {
+			a.Enabled = false
+			line = strings.TrimSpace(line[1:])
+		}
+		if strings.Contains(line, "://") {
+			a.URL = line
+			d.Adlists = append(d.Adlists, a)
+		}
+	}
+
+	files := []struct {
+		name  string
+		allow bool
+		regex bool
+	}{
+		{"whitelist.txt", true, false},
+		{"blacklist.txt", false, false},
+		{"regex.list", false, true},
+	}
+	for _, file := range files {
+		lines, err := readLines(filepath.Join(dir, file.name))
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if line[0] == '#' {
+				continue
+			}
+			d.Domains = append(d.Domains, Domain{
+				Domain:  line,
+				Regex:   file.regex,
+				Allow:   file.allow,
+				Enabled: true,
+			})
+		}
+	}
+	return nil
+}
+
+// readHosts - read local DNS records: "IP host" lines
+func (d *Data) readHosts(fn string) error {
+	lines, err := readLines(fn)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		for _, host := range fields[1:] {
+			if host[0] == '#' {
+				break
+			}
+			d.Records = append(d.Records, Record{Domain: host, Answer: fields[0]})
+		}
+	}
+	return nil
+}
+
+// readCNAMEs - read local CNAME records: "cname=alias[,alias...],target" lines
+func (d *Data) readCNAMEs(fn string) error {
+	lines, err := readLines(fn)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "cname=") {
+			continue
+		}
+		names := strings.Split(line[len("cname="):], ",")
+		if len(names) < 2 {
+			continue
+		}
+		target := strings.TrimSpace(names[len(names)-1])
+		for _, alias := range names[:len(names)-1] {
+			alias = strings.TrimSpace(alias)
+			if len(alias) != 0 && len(target) != 0 {
+				d.Records = append(d.Records, Record{Domain: alias, Answer: target})
+			}
+		}
+	}
+	return nil
+}
+
+// Rule - get the filtering rule for the domain list entry
+// Return false if the entry can't be converted (e.g. regular expression with Pi-hole extensions).
+func (dom Domain) Rule() (string, bool) {
+	prefix := ""
+	if dom.Allow {
+		prefix = "@@"
+	}
+
+	if !dom.Regex {
+		// Pi-hole exact match: the subdomains aren't matched
+		return prefix + "|" + strings.ToLower(dom.Domain) + "^", true
+	}
+
+	// ";querytype=", ";invert", ";reply=" are Pi-hole extensions
+	if strings.Contains(dom.Domain, ";") {
+		return "", false
+	}
+	return prefix + "/" + dom.Domain + "/", true
+}
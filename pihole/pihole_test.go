@@ -0,0 +1,116 @@
+package pihole
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportGravity(t *testing.T) {
+	d, err := Import("testdata/pihole")
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, len(d.Adlists))
+	assert.Equal(t, Adlist{URL: "https://example.org/hosts.txt", Comment: "Example list", Enabled: true}, d.Adlists[0])
+	// the comment is stored in overflow pages
+	assert.Equal(t, 2000, len(d.Adlists[1].Comment))
+	assert.False(t, d.Adlists[1].Enabled)
+
+	// the table consists of several pages
+	assert.Equal(t, 205, len(d.Domains))
+	assert.Equal(t, Domain{Domain: "allowed.example.org", Allow: true, Enabled: true}, d.Domains[0])
+	assert.Equal(t, Domain{Domain: "blocked.example.org", Enabled: true}, d.Domains[1])
+	assert.Equal(t, Domain{Domain: `(\.|^)good\.example$`, Regex: true, Allow: true, Enabled: true}, d.Domains[2])
+	assert.Equal(t, "host199.example.com", d.Domains[204].Domain)
+	assert.False(t, d.Domains[204].Enabled)
+
+	assert.Equal(t, []Record{
+		{Domain: "nas.lan", Answer: "192.168.1.10"},
+		{Domain: "nas.lan", Answer: "fd00::10"},
+		{Domain: "printer.lan", Answer: "fd00::10"},
+		{Domain: "files.lan", Answer: "nas.lan"},
+		{Domain: "media.lan", Answer: "nas.lan"},
+	}, d.Records)
+}
+
+func TestImportLegacy(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "pihole-test")
+	_ = os.RemoveAll(dir)
+	assert.Nil(t, os.MkdirAll(dir, 0755))
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	files := map[string]string{
+		"adlists.list":  "https://example.org/hosts.txt\n#https://example.net/hosts.txt\n# comment\n",
+		"whitelist.txt": "allowed.example.org\n",
+		"blacklist.txt": "blocked.example.org\n",
+		"regex.list":    "# comment\n^ads\\.\n",
+	}
+	for name, data := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	d, err := Import(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []Adlist{
+		{URL: "https://example.org/hosts.txt", Enabled: true},
+		{URL: "https://example.net/hosts.txt", Enabled: false},
+	}, d.Adlists)
+	assert.Equal(t, []Domain{
+		{Domain: "allowed.example.org", Allow: true, Enabled: true},
+		{Domain: "blocked.example.org", Enabled: true},
+		{Domain: `^ads\.`, Regex: true, Enabled: true},
+	}, d.Domains)
+	assert.Equal(t, 0, len(d.Records))
+
+	_, err = Import(filepath.Join(dir, "adlists.list"))
+	assert.NotNil(t, err)
+}
+
+func TestDomainRule(t *testing.T) {
+	r, ok := Domain{Domain: "Blocked.example.org"}.Rule()
+	assert.True(t, ok)
+	assert.Equal(t, "|blocked.example.org^", r)
+
+	r, ok = Domain{Domain: "allowed.example.org", Allow: true}.Rule()
+	assert.True(t, ok)
+	assert.Equal(t, "@@|allowed.example.org^", r)
+
+	r, ok = Domain{Domain: `^ads\.`, Regex: true}.Rule()
+	assert.True(t, ok)
+	assert.Equal(t, `/^ads\./`, r)
+
+	_, ok = Domain{Domain: "tracker;querytype=AAAA", Regex: true}.Rule()
+	assert.False(t, ok)
+}
+
+func TestSQLiteInvalid(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/pihole/gravity.db")
+	assert.Nil(t, err)
+
+	_, err = openSQLite(bytesReaderAt(data[:50]), 50)
+	assert.NotNil(t, err)
+
+	// corrupted root page of sqlite_master
+	bad := append([]byte{}, data...)
+	bad[100] = 0xff
+	db, err := openSQLite(bytesReaderAt(bad), int64(len(bad)))
+	assert.Nil(t, err)
+	_, err = db.readTable("adlist")
+	assert.NotNil(t, err)
+}
+
+type bytesReaderAt []byte
+
+func (b bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b)) {
+		return 0, os.ErrInvalid
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, os.ErrInvalid
+	}
+	return n, nil
+}
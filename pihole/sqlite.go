@@ -0,0 +1,397 @@
+package pihole
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// A minimal read-only SQLite database reader: it reads the rows of the tables.
+// Only UTF-8 databases are supported.  The changes in WAL file aren't seen.
+// https://www.sqlite.org/fileformat.html
+
+var errInvalidDB = errors.New("invalid SQLite database")
+
+// B-tree page types
+const (
+	pageTableInterior = 0x05
+	pageTableLeaf     = 0x0d
+)
+
+// The maximum depth of a table B-tree (protects from loops in a corrupted file)
+const maxTreeDepth = 32
+
+type sqliteDB struct {
+	r        io.ReaderAt
+	pageSize int
+	usable   int // usable size of a page
+	nPages   uint32
+}
+
+// sqliteRow - table row: column name -> value
+// Value types: nil, int64, float64, string, []byte
+type sqliteRow map[string]interface{}
+
+// openSQLite - read the database header
+func openSQLite(r io.ReaderAt, size int64) (*sqliteDB, error) {
+	hdr := make([]byte, 100)
+	_, err := r.ReadAt(hdr, 0)
+	if err != nil {
+		return nil, errInvalidDB
+	}
+	if string(hdr[:16]) != "SQLite format 3\x00" {
+		return nil, errInvalidDB
+	}
+
+	db := &sqliteDB{r: r}
+	db.pageSize = int(binary.BigEndian.Uint16(hdr[16:]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, errInvalidDB
+	}
+	db.usable = db.pageSize - int(hdr[20])
+	if db.usable < 480 {
+		return nil, errInvalidDB
+	}
+	db.nPages = uint32(size / int64(db.pageSize))
+
+	enc := binary.BigEndian.Uint32(hdr[56:])
+	if enc != 0 && enc != 1 {
+		return nil, fmt.Errorf("unsupported text encoding %d", enc)
+	}
+	return db, nil
+}
+
+// readPage - read the page by its number (starting from 1)
+func (db *sqliteDB) readPage(n uint32) ([]byte, error) {
+	if n == 0 || n > db.nPages {
+		return nil, errInvalidDB
+	}
+	p := make([]byte, db.pageSize)
+	_, err := db.r.ReadAt(p, int64(n-1)*int64(db.pageSize))
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readVarint - read a variable-length integer, return the number of bytes read (0: error)
+func readVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i != 9; i++ {
+		if i == len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			v = v<<8 | uint64(b[i])
+			return int64(v), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	return 0, 0
+}
+
+// walkTable - call the function for each record of the table B-tree
+func (db *sqliteDB) walkTable(root uint32, depth int, f func(rowid int64, payload []byte) error) error {
+	if depth == maxTreeDepth {
+		return errInvalidDB
+	}
+	p, err := db.readPage(root)
+	if err != nil {
+		return err
+	}
+	off := 0
+	if root == 1 {
+		off = 100 // database header
+	}
+	if len(p) < off+12 {
+		return errInvalidDB
+	}
+	hdr := p[off:]
+	pageType := hdr[0]
+	nCells := int(binary.BigEndian.Uint16(hdr[3:]))
+
+	hdrSize := 8
+	if pageType == pageTableInterior {
+		hdrSize = 12
+	} else if pageType != pageTableLeaf {
+		return errInvalidDB
+	}
+	if off+hdrSize+nCells*2 > len(p) {
+		return errInvalidDB
+	}
+	ptrs := hdr[hdrSize:]
+
+	for i := 0; i != nCells; i++ {
+		cell := int(binary.BigEndian.Uint16(ptrs[i*2:]))
+		if cell >= len(p) {
+			return errInvalidDB
+		}
+		c := p[cell:]
+
+		if pageType == pageTableInterior {
+			if len(c) < 4 {
+				return errInvalidDB
+			}
+			err = db.walkTable(binary.BigEndian.Uint32(c), depth+1, f)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		size, n := readVarint(c)
+		if n == 0 || size < 0 {
+			return errInvalidDB
+		}
+		c = c[n:]
+		rowid, n := readVarint(c)
+		if n == 0 {
+			return errInvalidDB
+		}
+		c = c[n:]
+		payload, err := db.readPayload(c, int(size))
+		if err != nil {
+			return err
+		}
+		err = f(rowid, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	if pageType == pageTableInterior {
+		return db.walkTable(binary.BigEndian.Uint32(hdr[8:]), depth+1, f)
+	}
+	return nil
+}
+
+// readPayload - get the cell payload, read the overflow pages if necessary
+func (db *sqliteDB) readPayload(c []byte, size int) ([]byte, error) {
+	u := db.usable
+	x := u - 35
+	local := size
+	if size > x {
+		m := ((u-12)*32/255 - 23)
+		k := m + (size-m)%(u-4)
+		local = m
+		if k <= x {
+			local = k
+		}
+	}
+	if len(c) < local {
+		return nil, errInvalidDB
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, c[:local]...)
+	if local == size {
+		return payload, nil
+	}
+
+	if len(c) < local+4 {
+		return nil, errInvalidDB
+	}
+	next := binary.BigEndian.Uint32(c[local:])
+	for len(payload) != size {
+		if next == 0 {
+			return nil, errInvalidDB
+		}
+		p, err := db.readPage(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(p)
+		n := size - len(payload)
+		if n > u-4 {
+			n = u - 4
+		}
+		payload = append(payload, p[4:4+n]...)
+	}
+	return payload, nil
+}
+
+// parseRecord - get the column values of the record
+func parseRecord(b []byte) ([]interface{}, error) {
+	hdrSize, n := readVarint(b)
+	if n == 0 || hdrSize < int64(n) || hdrSize > int64(len(b)) {
+		return nil, errInvalidDB
+	}
+	types := b[n:hdrSize]
+	data := b[hdrSize:]
+
+	vals := []interface{}{}
+	for len(types) != 0 {
+		t, n := readVarint(types)
+		if n == 0 {
+			return nil, errInvalidDB
+		}
+		types = types[n:]
+
+		size := 0
+		switch {
+		case t >= 1 && t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t >= 12:
+			size = int((t - 12) / 2)
+		}
+		if len(data) < size {
+			return nil, errInvalidDB
+		}
+		v := data[:size]
+		data = data[size:]
+
+		switch {
+		case t == 0:
+			vals = append(vals, nil)
+		case t >= 1 && t <= 6:
+			// big-endian two's complement
+			n := int64(int8(v[0]))
+			for _, c := range v[1:] {
+				n = n<<8 | int64(c)
+			}
+			vals = append(vals, n)
+		case t == 7:
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case t == 8:
+			vals = append(vals, int64(0))
+		case t == 9:
+			vals = append(vals, int64(1))
+		case t >= 12 && t%2 == 0:
+			vals = append(vals, append([]byte{}, v...))
+		case t >= 13:
+			vals = append(vals, string(v))
+		default:
+			return nil, errInvalidDB
+		}
+	}
+	return vals, nil
+}
+
+// tableColumns - get the column names from CREATE TABLE statement
+// Also return the index of INTEGER PRIMARY KEY column (it's the alias for rowid), or -1.
+func tableColumns(sql string) ([]string, int) {
+	start := strings.IndexByte(sql, '(')
+	end := strings.LastIndexByte(sql, ')')
+	if start < 0 || end < start {
+		return nil, -1
+	}
+
+	// split the definitions by commas outside of parentheses and quotes
+	defs := []string{}
+	level := 0
+	quote := byte(0)
+	last := start + 1
+	for i := start + 1; i < end; i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			level++
+		case c == ')':
+			level--
+		case c == ',' && level == 0:
+			defs = append(defs, sql[last:i])
+			last = i + 1
+		}
+	}
+	defs = append(defs, sql[last:end])
+
+	cols := []string{}
+	rowidCol := -1
+	for _, d := range defs {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		name := strings.Trim(fields[0], "\"`[]'")
+		upper := strings.ToUpper(strings.Join(fields[1:], " "))
+		if strings.HasPrefix(upper, "INTEGER PRIMARY KEY") {
+			rowidCol = len(cols)
+		}
+		cols = append(cols, name)
+	}
+	return cols, rowidCol
+}
+
+// readTable - read all rows of the table
+// Return nil if there's no such table.
+func (db *sqliteDB) readTable(name string) ([]sqliteRow, error) {
+	var root uint32
+	sql := ""
+	err := db.walkTable(1, 0, func(_ int64, payload []byte) error {
+		vals, err := parseRecord(payload)
+		if err != nil {
+			return err
+		}
+		// sqlite_master: type, name, tbl_name, rootpage, sql
+		if len(vals) != 5 || vals[0] != "table" || vals[1] != name {
+			return nil
+		}
+		rootpage, ok := vals[3].(int64)
+		if !ok {
+			return errInvalidDB
+		}
+		root = uint32(rootpage)
+		sql, _ = vals[4].(string)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root == 0 {
+		return nil, nil
+	}
+
+	cols, rowidCol := tableColumns(sql)
+	if len(cols) == 0 {
+		return nil, errInvalidDB
+	}
+	rows := []sqliteRow{}
+	err = db.walkTable(root, 0, func(rowid int64, payload []byte) error {
+		vals, err := parseRecord(payload)
+		if err != nil {
+			return err
+		}
+		row := sqliteRow{}
+		for i, c := range cols {
+			// the columns added by ALTER TABLE may be missing
+			if i < len(vals) {
+				row[c] = vals[i]
+			} else {
+				row[c] = nil
+			}
+		}
+		if rowidCol >= 0 {
+			row[cols[rowidCol]] = rowid
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -29,6 +29,14 @@ func (l *queryLog) getClientIP(clientIP string) string {
 	return clientIP
 }
 
+// timeString - format the time in the configured time zone
+func (l *queryLog) timeString(t time.Time) string {
+	if l.conf.Location != nil {
+		t = t.In(l.conf.Location)
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
 // entriesToJSON - converts log entries to JSON
 func (l *queryLog) entriesToJSON(entries []*logEntry, oldest time.Time) map[string]interface{} {
 	// init the response object
@@ -44,7 +52,7 @@ func (l *queryLog) entriesToJSON(entries []*logEntry, oldest time.Time) map[stri
 	var result = map[string]interface{}{}
 	result["oldest"] = ""
 	if !oldest.IsZero() {
-		result["oldest"] = oldest.Format(time.RFC3339Nano)
+		result["oldest"] = l.timeString(oldest)
 	}
 	result["data"] = data
 
@@ -65,7 +73,7 @@ func (l *queryLog) logEntryToJSONEntry(entry *logEntry) map[string]interface{} {
 	jsonEntry := map[string]interface{}{
 		"reason":       entry.Result.Reason.String(),
 		"elapsedMs":    strconv.FormatFloat(entry.Elapsed.Seconds()*1000, 'f', -1, 64),
-		"time":         entry.Time.Format(time.RFC3339Nano),
+		"time":         l.timeString(entry.Time),
 		"client":       l.getClientIP(entry.IP),
 		"client_proto": entry.ClientProto,
 	}
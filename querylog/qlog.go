@@ -65,6 +65,9 @@ func (l *queryLog) Start() {
 		l.initWeb()
 	}
 	go l.periodicRotate()
+	if l.conf.FlushInterval != 0 {
+		go l.periodicFlush()
+	}
 }
 
 func (l *queryLog) Close() {
@@ -4,6 +4,7 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/dnsproxy/proxyutil"
 
@@ -208,6 +209,31 @@ func TestQueryLogFileDisabled(t *testing.T) {
 	assert.Equal(t, "example2.org", ll[1].QHost)
 }
 
+// Check that the entries kept in memory are written to the file periodically
+func TestQueryLogPeriodicFlush(t *testing.T) {
+	conf := Config{
+		Enabled:       true,
+		FileEnabled:   true,
+		Interval:      1,
+		MemSize:       100,
+		FlushInterval: 10 * time.Millisecond,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	go l.periodicFlush()
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	time.Sleep(100 * time.Millisecond)
+
+	l.bufferLock.Lock()
+	assert.Equal(t, 0, len(l.buffer))
+	l.bufferLock.Unlock()
+	st, err := os.Stat(l.logFile)
+	assert.Nil(t, err)
+	assert.NotEqual(t, int64(0), st.Size())
+}
+
 func addEntry(l *queryLog, host, answerStr, client string) {
 	q := dns.Msg{}
 	q.Question = append(q.Question, dns.Question{
@@ -32,6 +32,15 @@ type Config struct {
 	MemSize           uint32 // number of entries kept in memory before they are flushed to disk
 	AnonymizeClientIP bool   // anonymize clients' IP addresses
 
+	// The time zone of the timestamps returned by the API (nil: as they are stored)
+	Location *time.Location
+
+	// Write the entries kept in memory to the file at least this often (0: only when the memory buffer is full)
+	FlushInterval time.Duration
+
+	// Return TRUE if the log may be rotated now (nil: at any time)
+	MaintenanceWindow func() bool
+
 	// Called when the configuration is changed by HTTP request
 	ConfigModified func()
 
@@ -102,8 +102,15 @@ func (l *queryLog) rotate() error {
 	return nil
 }
 
+// How often it's checked whether the maintenance window is opened
+const maintenanceCheckInterval = 5 * time.Minute
+
 func (l *queryLog) periodicRotate() {
 	for range time.Tick(time.Duration(l.conf.Interval) * 24 * time.Hour) {
+		// wait for the maintenance window
+		for l.conf.MaintenanceWindow != nil && !l.conf.MaintenanceWindow() {
+			time.Sleep(maintenanceCheckInterval)
+		}
 		err := l.rotate()
 		if err != nil {
 			log.Error("Failed to rotate querylog: %s", err)
@@ -111,3 +118,10 @@ func (l *queryLog) periodicRotate() {
 		}
 	}
 }
+
+// periodicFlush - write the entries kept in memory to the file even if the buffer isn't full
+func (l *queryLog) periodicFlush() {
+	for range time.Tick(l.conf.FlushInterval) {
+		_ = l.flushLogBuffer(true)
+	}
+}
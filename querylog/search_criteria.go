@@ -112,7 +112,9 @@ func (c *searchCriteria) match(entry *logEntry) bool {
 		case filteringStatusBlocked:
 			return res.IsFiltered &&
 				(res.Reason == dnsfilter.FilteredBlackList ||
-					res.Reason == dnsfilter.FilteredBlockedService)
+					res.Reason == dnsfilter.FilteredBlockedService ||
+					res.Reason == dnsfilter.FilteredEncryptedDNS ||
+					res.Reason == dnsfilter.FilteredInternetOff)
 		case filteringStatusBlockedParental:
 			return res.IsFiltered && res.Reason == dnsfilter.FilteredParental
 		case filteringStatusBlockedSafebrowsing:
@@ -128,6 +130,8 @@ func (c *searchCriteria) match(entry *logEntry) bool {
 		case filteringStatusProcessed:
 			return !(res.Reason == dnsfilter.FilteredBlackList ||
 				res.Reason == dnsfilter.FilteredBlockedService ||
+				res.Reason == dnsfilter.FilteredEncryptedDNS ||
+				res.Reason == dnsfilter.FilteredInternetOff ||
 				res.Reason == dnsfilter.NotFilteredWhiteList)
 
 		default:
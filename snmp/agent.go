@@ -0,0 +1,403 @@
+// Package snmp implements a read-only SNMP v1/v2c agent
+package snmp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// SNMP versions
+const (
+	version1  = 0
+	version2c = 1
+)
+
+// PDU types
+const (
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduSet      = 0xa3
+	pduGetBulk  = 0xa5
+)
+
+// Error status codes
+const (
+	errNoError     = 0
+	errNoSuchName  = 2 // v1 only
+	errReadOnly    = 4 // v1 only
+	errNotWritable = 17
+)
+
+// The maximum number of variables in GetBulk response
+const maxBulkVars = 64
+
+// The maximum size of a request
+const maxMsgSize = 65507
+
+// Counter32 - 32-bit counter
+type Counter32 uint32
+
+// Gauge32 - 32-bit gauge
+type Gauge32 uint32
+
+// TimeTicks - time in hundredths of a second
+type TimeTicks uint32
+
+// Counter64 - 64-bit counter (SNMP v2c only)
+type Counter64 uint64
+
+// Var - a variable
+// Value types: int, string, OID, Counter32, Gauge32, TimeTicks, Counter64
+type Var struct {
+	OID   OID
+	Value interface{}
+}
+
+// Config - agent configuration
+type Config struct {
+	Addr      string // UDP address to listen on, e.g. "0.0.0.0:161"
+	Community string // read-only community
+
+	// GetVars - get the current values of all variables
+	GetVars func() []Var
+}
+
+// Agent - SNMP agent
+type Agent struct {
+	conf Config
+	conn *net.UDPConn
+	wg   sync.WaitGroup
+}
+
+// Create - create SNMP agent
+func Create(conf Config) *Agent {
+	return &Agent{conf: conf}
+}
+
+// Start - start listening
+func (a *Agent) Start() error {
+	addr, err := net.ResolveUDPAddr("udp", a.conf.Addr)
+	if err != nil {
+		return err
+	}
+	a.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	log.Info("SNMP: listening on udp://%s", a.conn.LocalAddr())
+
+	a.wg.Add(1)
+	go a.serve()
+	return nil
+}
+
+// Close - stop the agent
+func (a *Agent) Close() {
+	if a.conn == nil {
+		return
+	}
+	_ = a.conn.Close()
+	a.wg.Wait()
+	a.conn = nil
+}
+
+func (a *Agent) serve() {
+	defer a.wg.Done()
+	buf := make([]byte, maxMsgSize)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		resp, err := a.handle(buf[:n])
+		if err != nil {
+			log.Debug("SNMP: %s: %s", addr, err)
+			continue
+		}
+		_, err = a.conn.WriteToUDP(resp, addr)
+		if err != nil {
+			log.Debug("SNMP: %s: %s", addr, err)
+		}
+	}
+}
+
+// request - decoded request
+type request struct {
+	version   int64
+	community []byte
+	pduType   byte
+	id        int64
+	nonRep    int64 // GetBulk: non-repeaters
+	maxRep    int64 // GetBulk: max-repetitions
+	oids      []OID
+}
+
+// parseRequest - decode the request message
+func parseRequest(data []byte) (*request, error) {
+	msg, _, err := readTLV(data)
+	if err != nil {
+		return nil, err
+	}
+	if msg.tag != tagSequence {
+		return nil, errInvalidBER
+	}
+	items, err := readSequence(msg.val)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) != 3 || items[0].tag != tagInteger || items[1].tag != tagOctetString {
+		return nil, errInvalidBER
+	}
+
+	r := &request{}
+	r.version, err = decodeInt(items[0].val)
+	if err != nil {
+		return nil, err
+	}
+	if r.version != version1 && r.version != version2c {
+		return nil, fmt.Errorf("unsupported version %d", r.version)
+	}
+	r.community = items[1].val
+	r.pduType = items[2].tag
+
+	pdu, err := readSequence(items[2].val)
+	if err != nil {
+		return nil, err
+	}
+	if len(pdu) != 4 || pdu[3].tag != tagSequence {
+		return nil, errInvalidBER
+	}
+	r.id, err = decodeInt(pdu[0].val)
+	if err != nil {
+		return nil, err
+	}
+	r.nonRep, err = decodeInt(pdu[1].val)
+	if err != nil {
+		return nil, err
+	}
+	r.maxRep, err = decodeInt(pdu[2].val)
+	if err != nil {
+		return nil, err
+	}
+
+	vbs, err := readSequence(pdu[3].val)
+	if err != nil {
+		return nil, err
+	}
+	for _, vb := range vbs {
+		items, err := readSequence(vb.val)
+		if err != nil {
+			return nil, err
+		}
+		if vb.tag != tagSequence || len(items) != 2 || items[0].tag != tagOID {
+			return nil, errInvalidBER
+		}
+		oid, err := decodeOID(items[0].val)
+		if err != nil {
+			return nil, err
+		}
+		r.oids = append(r.oids, oid)
+	}
+	return r, nil
+}
+
+// encodeValue - encode the variable value
+func encodeValue(v interface{}) []byte {
+	switch val := v.(type) {
+	case int:
+		return appendTLV(nil, tagInteger, encodeInt(int64(val)))
+	case string:
+		return appendTLV(nil, tagOctetString, []byte(val))
+	case OID:
+		return appendTLV(nil, tagOID, encodeOID(val))
+	case Counter32:
+		return appendTLV(nil, tagCounter32, encodeUint(uint64(val)))
+	case Gauge32:
+		return appendTLV(nil, tagGauge32, encodeUint(uint64(val)))
+	case TimeTicks:
+		return appendTLV(nil, tagTimeTicks, encodeUint(uint64(val)))
+	case Counter64:
+		return appendTLV(nil, tagCounter64, encodeUint(uint64(val)))
+	case byte: // exception: noSuchObject, noSuchInstance or endOfMibView
+		return []byte{val, 0}
+	}
+	return []byte{tagNull, 0}
+}
+
+// encodeResponse - encode the response message
+func encodeResponse(r *request, errStatus, errIndex int, vars []Var) []byte {
+	vbs := []byte{}
+	for _, v := range vars {
+		vb := appendTLV(nil, tagOID, encodeOID(v.OID))
+		vb = append(vb, encodeValue(v.Value)...)
+		vbs = appendTLV(vbs, tagSequence, vb)
+	}
+
+	pdu := appendTLV(nil, tagInteger, encodeInt(r.id))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(int64(errStatus)))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(int64(errIndex)))
+	pdu = appendTLV(pdu, tagSequence, vbs)
+
+	msg := appendTLV(nil, tagInteger, encodeInt(r.version))
+	msg = appendTLV(msg, tagOctetString, r.community)
+	msg = appendTLV(msg, pduResponse, pdu)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// handle - process the request and return the response
+func (a *Agent) handle(data []byte) ([]byte, error) {
+	r, err := parseRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	if string(r.community) != a.conf.Community {
+		return nil, fmt.Errorf("invalid community")
+	}
+
+	vars := a.conf.GetVars()
+	if r.version == version1 {
+		// Counter64 isn't supported by SNMP v1
+		v1vars := []Var{}
+		for _, v := range vars {
+			if _, ok := v.Value.(Counter64); !ok {
+				v1vars = append(v1vars, v)
+			}
+		}
+		vars = v1vars
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].OID.Compare(vars[j].OID) < 0
+	})
+
+	switch r.pduType {
+	case pduGet:
+		resp := []Var{}
+		for i, oid := range r.oids {
+			v, ok := findVar(vars, oid)
+			if !ok {
+				if r.version == version1 {
+					return encodeResponse(r, errNoSuchName, i+1, varsNull(r.oids)), nil
+				}
+				v = Var{OID: oid, Value: byte(tagNoSuchObject)}
+			}
+			resp = append(resp, v)
+		}
+		return encodeResponse(r, errNoError, 0, resp), nil
+
+	case pduGetNext:
+		resp := []Var{}
+		for i, oid := range r.oids {
+			v, ok := nextVar(vars, oid)
+			if !ok {
+				if r.version == version1 {
+					return encodeResponse(r, errNoSuchName, i+1, varsNull(r.oids)), nil
+				}
+				v = Var{OID: oid, Value: byte(tagEndOfMibView)}
+			}
+			resp = append(resp, v)
+		}
+		return encodeResponse(r, errNoError, 0, resp), nil
+
+	case pduGetBulk:
+		if r.version == version1 {
+			return nil, fmt.Errorf("GetBulk isn't supported by SNMP v1")
+		}
+		return encodeResponse(r, errNoError, 0, getBulk(vars, r)), nil
+
+	case pduSet:
+		status := errNotWritable
+		if r.version == version1 {
+			status = errReadOnly
+		}
+		return encodeResponse(r, status, 1, varsNull(r.oids)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported PDU type %#x", r.pduType)
+}
+
+// getBulk - process GetBulk request
+func getBulk(vars []Var, r *request) []Var {
+	nonRep := int(r.nonRep)
+	if nonRep < 0 {
+		nonRep = 0
+	} else if nonRep > len(r.oids) {
+		nonRep = len(r.oids)
+	}
+	maxRep := int(r.maxRep)
+	if maxRep < 0 {
+		maxRep = 0
+	}
+
+	next := func(oid OID) Var {
+		v, ok := nextVar(vars, oid)
+		if !ok {
+			return Var{OID: oid, Value: byte(tagEndOfMibView)}
+		}
+		return v
+	}
+
+	resp := []Var{}
+	for _, oid := range r.oids[:nonRep] {
+		resp = append(resp, next(oid))
+	}
+
+	cur := append([]OID{}, r.oids[nonRep:]...)
+	for i := 0; i != maxRep && len(cur) != 0; i++ {
+		end := true
+		for j, oid := range cur {
+			if len(resp) == maxBulkVars {
+				return resp
+			}
+			v := next(oid)
+			resp = append(resp, v)
+			cur[j] = v.OID
+			if _, ok := v.Value.(byte); !ok {
+				end = false
+			}
+		}
+		if end {
+			break
+		}
+	}
+	return resp
+}
+
+// findVar - search for the variable with the OID
+func findVar(vars []Var, oid OID) (Var, bool) {
+	i := sort.Search(len(vars), func(i int) bool {
+		return vars[i].OID.Compare(oid) >= 0
+	})
+	if i != len(vars) && vars[i].OID.Compare(oid) == 0 {
+		return vars[i], true
+	}
+	return Var{}, false
+}
+
+// nextVar - get the variable that follows the OID
+func nextVar(vars []Var, oid OID) (Var, bool) {
+	i := sort.Search(len(vars), func(i int) bool {
+		return vars[i].OID.Compare(oid) > 0
+	})
+	if i != len(vars) {
+		return vars[i], true
+	}
+	return Var{}, false
+}
+
+// varsNull - get the variables with NULL values
+func varsNull(oids []OID) []Var {
+	vars := []Var{}
+	for _, oid := range oids {
+		vars = append(vars, Var{OID: oid})
+	}
+	return vars
+}
@@ -0,0 +1,183 @@
+package snmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makeRequest - encode the request message
+func makeRequest(version int64, community string, pduType byte, nonRep, maxRep int64, oids ...string) []byte {
+	vbs := []byte{}
+	for _, s := range oids {
+		vb := appendTLV(nil, tagOID, encodeOID(MustParseOID(s)))
+		vb = appendTLV(vb, tagNull, nil)
+		vbs = appendTLV(vbs, tagSequence, vb)
+	}
+	pdu := appendTLV(nil, tagInteger, encodeInt(1234))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(nonRep))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(maxRep))
+	pdu = appendTLV(pdu, tagSequence, vbs)
+
+	msg := appendTLV(nil, tagInteger, encodeInt(version))
+	msg = appendTLV(msg, tagOctetString, []byte(community))
+	msg = appendTLV(msg, pduType, pdu)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+type testResponse struct {
+	errStatus int64
+	errIndex  int64
+	oids      []string
+	values    []tlv
+}
+
+// parseResponse - decode the response message
+func parseResponse(t *testing.T, data []byte) testResponse {
+	msg, _, err := readTLV(data)
+	assert.Nil(t, err)
+	items, err := readSequence(msg.val)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(items))
+	assert.Equal(t, byte(pduResponse), items[2].tag)
+
+	pdu, err := readSequence(items[2].val)
+	assert.Nil(t, err)
+	id, _ := decodeInt(pdu[0].val)
+	assert.Equal(t, int64(1234), id)
+
+	r := testResponse{}
+	r.errStatus, _ = decodeInt(pdu[1].val)
+	r.errIndex, _ = decodeInt(pdu[2].val)
+	vbs, err := readSequence(pdu[3].val)
+	assert.Nil(t, err)
+	for _, vb := range vbs {
+		items, err := readSequence(vb.val)
+		assert.Nil(t, err)
+		oid, err := decodeOID(items[0].val)
+		assert.Nil(t, err)
+		r.oids = append(r.oids, oid.String())
+		r.values = append(r.values, items[1])
+	}
+	return r
+}
+
+func testAgent() *Agent {
+	return Create(Config{
+		Community: "public",
+		GetVars: func() []Var {
+			return []Var{
+				{OID: MustParseOID("1.3.6.1.4.1.99.2.0"), Value: Counter64(1 << 40)},
+				{OID: MustParseOID("1.3.6.1.4.1.99.1.0"), Value: "v1.0"},
+				{OID: MustParseOID("1.3.6.1.4.1.99.3.0"), Value: Gauge32(7)},
+			}
+		},
+	})
+}
+
+func TestOID(t *testing.T) {
+	oid, err := ParseOID(".1.3.6.1.2.1.1.1.0")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.3.6.1.2.1.1.1.0", oid.String())
+
+	dec, err := decodeOID(encodeOID(MustParseOID("1.3.6.1.4.1.8072.9999.9999")))
+	assert.Nil(t, err)
+	assert.Equal(t, "1.3.6.1.4.1.8072.9999.9999", dec.String())
+
+	assert.Equal(t, -1, MustParseOID("1.3.6").Compare(MustParseOID("1.3.6.1")))
+	assert.Equal(t, 1, MustParseOID("1.3.7").Compare(MustParseOID("1.3.6.1")))
+	assert.Equal(t, 0, MustParseOID("1.3.6").Compare(MustParseOID("1.3.6")))
+
+	_, err = ParseOID("1.x.3")
+	assert.NotNil(t, err)
+}
+
+func TestInt(t *testing.T) {
+	for _, n := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40} {
+		v, err := decodeInt(encodeInt(n))
+		assert.Nil(t, err)
+		assert.Equal(t, n, v)
+	}
+	assert.Equal(t, []byte{0, 0x80}, encodeUint(0x80))
+}
+
+func TestAgentGet(t *testing.T) {
+	a := testAgent()
+
+	data, err := a.handle(makeRequest(version2c, "public", pduGet, 0, 0, "1.3.6.1.4.1.99.1.0", "1.3.6.1.4.1.99.9.0"))
+	assert.Nil(t, err)
+	r := parseResponse(t, data)
+	assert.Equal(t, int64(errNoError), r.errStatus)
+	assert.Equal(t, []string{"1.3.6.1.4.1.99.1.0", "1.3.6.1.4.1.99.9.0"}, r.oids)
+	assert.Equal(t, tlv{tag: tagOctetString, val: []byte("v1.0")}, r.values[0])
+	assert.Equal(t, byte(tagNoSuchObject), r.values[1].tag)
+
+	// SNMP v1: noSuchName error
+	data, err = a.handle(makeRequest(version1, "public", pduGet, 0, 0, "1.3.6.1.4.1.99.1.0", "1.3.6.1.4.1.99.9.0"))
+	assert.Nil(t, err)
+	r = parseResponse(t, data)
+	assert.Equal(t, int64(errNoSuchName), r.errStatus)
+	assert.Equal(t, int64(2), r.errIndex)
+
+	// invalid community: no response
+	_, err = a.handle(makeRequest(version2c, "private", pduGet, 0, 0, "1.3.6.1.4.1.99.1.0"))
+	assert.NotNil(t, err)
+
+	// Set is rejected
+	data, err = a.handle(makeRequest(version2c, "public", pduSet, 0, 0, "1.3.6.1.4.1.99.1.0"))
+	assert.Nil(t, err)
+	r = parseResponse(t, data)
+	assert.Equal(t, int64(errNotWritable), r.errStatus)
+}
+
+func TestAgentWalk(t *testing.T) {
+	a := testAgent()
+
+	// GetNext
+	data, err := a.handle(makeRequest(version2c, "public", pduGetNext, 0, 0, "1.3.6.1.4.1.99"))
+	assert.Nil(t, err)
+	r := parseResponse(t, data)
+	assert.Equal(t, []string{"1.3.6.1.4.1.99.1.0"}, r.oids)
+
+	data, err = a.handle(makeRequest(version2c, "public", pduGetNext, 0, 0, "1.3.6.1.4.1.99.3.0"))
+	assert.Nil(t, err)
+	r = parseResponse(t, data)
+	assert.Equal(t, byte(tagEndOfMibView), r.values[0].tag)
+
+	// Counter64 is skipped in SNMP v1
+	data, err = a.handle(makeRequest(version1, "public", pduGetNext, 0, 0, "1.3.6.1.4.1.99.1.0"))
+	assert.Nil(t, err)
+	r = parseResponse(t, data)
+	assert.Equal(t, []string{"1.3.6.1.4.1.99.3.0"}, r.oids)
+	assert.Equal(t, tlv{tag: tagGauge32, val: []byte{7}}, r.values[0])
+
+	// GetBulk
+	data, err = a.handle(makeRequest(version2c, "public", pduGetBulk, 0, 10, "1.3.6.1.4.1.99"))
+	assert.Nil(t, err)
+	r = parseResponse(t, data)
+	assert.Equal(t, []string{"1.3.6.1.4.1.99.1.0", "1.3.6.1.4.1.99.2.0", "1.3.6.1.4.1.99.3.0", "1.3.6.1.4.1.99.3.0"}, r.oids)
+	assert.Equal(t, byte(tagCounter64), r.values[1].tag)
+	assert.Equal(t, byte(tagEndOfMibView), r.values[3].tag)
+}
+
+func TestAgentUDP(t *testing.T) {
+	a := testAgent()
+	a.conf.Addr = "127.0.0.1:0"
+	assert.Nil(t, a.Start())
+	defer a.Close()
+
+	conn, err := net.Dial("udp", a.conn.LocalAddr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(makeRequest(version2c, "public", pduGet, 0, 0, "1.3.6.1.4.1.99.3.0"))
+	assert.Nil(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	r := parseResponse(t, buf[:n])
+	assert.Equal(t, []string{"1.3.6.1.4.1.99.3.0"}, r.oids)
+}
@@ -0,0 +1,244 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagIPAddress   = 0x40
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+	tagCounter64   = 0x46
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+)
+
+var errInvalidBER = errors.New("invalid BER data")
+
+// OID - object identifier
+type OID []uint32
+
+// ParseOID - parse "1.3.6.1.2.1.1.1.0" string
+func ParseOID(s string) (OID, error) {
+	s = strings.TrimPrefix(s, ".")
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%s: invalid OID", s)
+	}
+	oid := make(OID, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid OID", s)
+		}
+		oid[i] = uint32(n)
+	}
+	return oid, nil
+}
+
+// MustParseOID - parse OID string, panic on error
+func MustParseOID(s string) OID {
+	oid, err := ParseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+func (oid OID) String() string {
+	parts := make([]string, len(oid))
+	for i, n := range oid {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Append - get the new OID with the sub-identifiers added
+func (oid OID) Append(ids ...uint32) OID {
+	r := make(OID, 0, len(oid)+len(ids))
+	r = append(r, oid...)
+	return append(r, ids...)
+}
+
+// Compare - compare OIDs lexicographically: -1, 0 or 1
+func (oid OID) Compare(other OID) int {
+	for i := 0; i != len(oid) && i != len(other); i++ {
+		if oid[i] < other[i] {
+			return -1
+		} else if oid[i] > other[i] {
+			return 1
+		}
+	}
+	if len(oid) < len(other) {
+		return -1
+	} else if len(oid) > len(other) {
+		return 1
+	}
+	return 0
+}
+
+// appendTLV - append tag, length and value
+func appendTLV(b []byte, tag byte, val []byte) []byte {
+	b = append(b, tag)
+	n := len(val)
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, val...)
+}
+
+// encodeInt - encode a signed integer (two's complement, minimal length)
+func encodeInt(n int64) []byte {
+	b := []byte{}
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if (n == 0 && b[0]&0x80 == 0) || (n == -1 && b[0]&0x80 != 0) {
+			return b
+		}
+	}
+}
+
+// encodeUint - encode an unsigned integer (minimal length)
+func encodeUint(n uint64) []byte {
+	b := []byte{}
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// encodeOID - encode object identifier
+func encodeOID(oid OID) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	b := []byte{}
+	ids := append(OID{oid[0]*40 + oid[1]}, oid[2:]...)
+	for _, n := range ids {
+		tmp := []byte{byte(n & 0x7f)}
+		n >>= 7
+		for n != 0 {
+			tmp = append([]byte{byte(n&0x7f) | 0x80}, tmp...)
+			n >>= 7
+		}
+		b = append(b, tmp...)
+	}
+	return b
+}
+
+// tlv - decoded BER element
+type tlv struct {
+	tag byte
+	val []byte
+}
+
+// readTLV - read the element, return the rest of the data
+func readTLV(b []byte) (tlv, []byte, error) {
+	if len(b) < 2 {
+		return tlv{}, nil, errInvalidBER
+	}
+	t := tlv{tag: b[0]}
+	n := int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		nb := n & 0x7f
+		if nb == 0 || nb > 3 || len(b) < nb {
+			return tlv{}, nil, errInvalidBER
+		}
+		n = 0
+		for i := 0; i != nb; i++ {
+			n = n<<8 | int(b[i])
+		}
+		b = b[nb:]
+	}
+	if len(b) < n {
+		return tlv{}, nil, errInvalidBER
+	}
+	t.val = b[:n]
+	return t, b[n:], nil
+}
+
+// readSequence - read all elements from the data
+func readSequence(b []byte) ([]tlv, error) {
+	items := []tlv{}
+	for len(b) != 0 {
+		t, rest, err := readTLV(b)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, t)
+		b = rest
+	}
+	return items, nil
+}
+
+// decodeInt - decode a signed integer
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errInvalidBER
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+// decodeOID - decode object identifier
+func decodeOID(b []byte) (OID, error) {
+	if len(b) == 0 {
+		return nil, errInvalidBER
+	}
+	oid := OID{}
+	var n uint32
+	for i, c := range b {
+		if n > 0x1ffffff {
+			return nil, errInvalidBER
+		}
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, errInvalidBER
+			}
+			continue
+		}
+		if len(oid) == 0 {
+			if n < 80 {
+				oid = append(oid, n/40, n%40)
+			} else {
+				oid = append(oid, 2, n-80)
+			}
+		} else {
+			oid = append(oid, n)
+		}
+		n = 0
+	}
+	return oid, nil
+}
@@ -5,6 +5,7 @@ package stats
 import (
 	"net"
 	"net/http"
+	"time"
 )
 
 type unitIDCallback func() uint32
@@ -21,6 +22,12 @@ type Config struct {
 	UnitID            unitIDCallback // user function to get the current unit ID.  If nil, the current time hour is used.
 	AnonymizeClientIP bool           // anonymize clients' IP addresses
 
+	// The time zone for the per-day counters (nil: UTC)
+	Location *time.Location
+
+	// Return TRUE if the database may be compacted now (nil: the database isn't compacted)
+	MaintenanceWindow func() bool
+
 	// Called when the configuration is changed by HTTP request
 	ConfigModified func()
 
@@ -50,10 +57,27 @@ type Stats interface {
 	// Get IP addresses of the clients with the most number of requests
 	GetTopClientsIP(limit uint) []string
 
+	// Get the number of DNS requests per hour
+	// The last element is the current hour.
+	GetHourlyQueries() []uint64
+
+	// Get the total counters for the whole period
+	GetSummary() Summary
+
 	// WriteDiskConfig - write configuration
 	WriteDiskConfig(dc *DiskConfig)
 }
 
+// Summary - the total counters
+type Summary struct {
+	DNSQueries           uint64  `json:"num_dns_queries"`
+	BlockedFiltering     uint64  `json:"num_blocked_filtering"`
+	ReplacedSafeBrowsing uint64  `json:"num_replaced_safebrowsing"`
+	ReplacedSafeSearch   uint64  `json:"num_replaced_safesearch"`
+	ReplacedParental     uint64  `json:"num_replaced_parental"`
+	AvgProcessingTime    float64 `json:"avg_processing_time"` // in seconds
+}
+
 // TimeUnit - time unit
 type TimeUnit int
 
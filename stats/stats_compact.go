@@ -0,0 +1,105 @@
+package stats
+
+import (
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// How often it's checked whether the database must be compacted
+const compactCheckInterval = 10 * time.Minute
+
+// The database is compacted if the free pages take at least this size and 1/4 of the file
+const compactMinFree = 256 * 1024
+
+// compactIfNecessary - compact the database in the maintenance window if the deleted units have left much free space
+// bolt doesn't shrink the file when the data is deleted.
+func (s *statsCtx) compactIfNecessary(now time.Time) {
+	if s.conf.MaintenanceWindow == nil || now.Sub(s.compactChecked) < compactCheckInterval {
+		return
+	}
+	s.compactChecked = now
+	db := s.db
+	if db == nil || !s.conf.MaintenanceWindow() {
+		return
+	}
+
+	st := db.Stats()
+	free := int64(st.FreePageN+st.PendingPageN) * int64(db.Info().PageSize)
+	fi, err := os.Stat(s.conf.Filename)
+	if err != nil || free < compactMinFree || free < fi.Size()/4 {
+		return
+	}
+	s.compact()
+}
+
+// compact - rewrite the database file without the free pages
+func (s *statsCtx) compact() {
+	tmp := s.conf.Filename + ".tmp"
+	tx := s.beginTxn(true) // no other changes are made while the data is copied
+	if tx == nil {
+		return
+	}
+	before := tx.Size()
+	err := copyDB(tx, tmp)
+	if err != nil {
+		_ = tx.Rollback()
+		_ = os.Remove(tmp)
+		log.Error("Stats: compact: %s", err)
+		return
+	}
+
+	db := s.db
+	s.db = nil
+	_ = tx.Rollback()
+	// the active transactions can continue using database,
+	//  but no new transactions will be opened
+	_ = db.Close()
+
+	err = os.Rename(tmp, s.conf.Filename)
+	if err != nil {
+		log.Error("Stats: compact: %s", err)
+		_ = os.Remove(tmp)
+	}
+	if !s.dbOpen() {
+		return
+	}
+	log.Info("Stats: compacted the database: %d -> %d bytes", before, s.dbSize())
+}
+
+// dbSize - get the size of the database file
+func (s *statsCtx) dbSize() int64 {
+	tx := s.beginTxn(false)
+	if tx == nil {
+		return 0
+	}
+	defer func() { _ = tx.Rollback() }()
+	return tx.Size()
+}
+
+// copyDB - copy the buckets to a new database file
+func copyDB(src *bolt.Tx, path string) error {
+	_ = os.Remove(path)
+	dst, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return err
+	}
+	err = dst.Update(func(tx *bolt.Tx) error {
+		return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+			nb, err := tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+			return b.ForEach(func(k, v []byte) error {
+				return nb.Put(k, v)
+			})
+		})
+	})
+	err2 := dst.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -77,6 +78,14 @@ func TestStats(t *testing.T) {
 	topClients := s.GetTopClientsIP(2)
 	assert.True(t, topClients[0] == "127.0.0.1")
 
+	sum := s.GetSummary()
+	assert.Equal(t, uint64(2), sum.DNSQueries)
+	assert.Equal(t, uint64(1), sum.BlockedFiltering)
+
+	hourly := s.GetHourlyQueries()
+	assert.Equal(t, 24, len(hourly))
+	assert.Equal(t, uint64(2), hourly[23])
+
 	s.clear()
 	s.Close()
 	os.Remove(conf.Filename)
@@ -150,3 +159,54 @@ func TestAggregateDataPerTimeUnit(t *testing.T) {
 		assert.True(t, alen == 30, "i=%d", i)
 	}
 }
+
+func TestDayOffset(t *testing.T) {
+	s := statsCtx{conf: &Config{}}
+	assert.Equal(t, uint32(0), s.dayOffset())
+
+	s.conf.Location = time.FixedZone("", 3*3600)
+	assert.Equal(t, uint32(3), s.dayOffset())
+	s.conf.Location = time.FixedZone("", -5*3600)
+	assert.Equal(t, uint32(19), s.dayOffset())
+	s.conf.Location = time.FixedZone("", 5*3600+1800)
+	assert.Equal(t, uint32(5), s.dayOffset())
+	s.conf.Location = time.FixedZone("", -(3*3600 + 1800))
+	assert.Equal(t, uint32(20), s.dayOffset())
+}
+
+func TestCompact(t *testing.T) {
+	conf := Config{
+		Filename:          "./stats_compact.db",
+		LimitDays:         1,
+		MaintenanceWindow: func() bool { return true },
+	}
+	_ = os.Remove(conf.Filename)
+	defer func() { _ = os.Remove(conf.Filename) }()
+	s, err := createObject(conf)
+	assert.Nil(t, err)
+
+	// fill the database and delete most of the units
+	udb := &unitDB{NTotal: 1}
+	for i := 0; i != 500; i++ {
+		udb.Domains = append(udb.Domains, countPair{Name: fmt.Sprintf("domain%d.example.org", i), Count: 1})
+	}
+	tx := s.beginTxn(true)
+	for id := uint32(1); id <= 100; id++ {
+		s.flushUnitToDB(tx, id, udb)
+	}
+	s.commitTxn(tx)
+	tx = s.beginTxn(true)
+	for id := uint32(1); id < 100; id++ {
+		s.deleteUnit(tx, id)
+	}
+	s.commitTxn(tx)
+	before := s.dbSize()
+
+	s.compactIfNecessary(time.Now())
+	assert.True(t, s.dbSize() < before/4)
+	tx = s.beginTxn(false)
+	assert.NotNil(t, s.loadUnitFromDB(tx, 100))
+	assert.Nil(t, s.loadUnitFromDB(tx, 99))
+	_ = tx.Rollback()
+	s.Close()
+}
@@ -27,6 +27,8 @@ type statsCtx struct {
 
 	unit     *unit      // the current unit
 	unitLock sync.Mutex // protect 'unit'
+
+	compactChecked time.Time // the last time it was checked whether the database must be compacted
 }
 
 // data for 1 time unit
@@ -153,6 +155,20 @@ func (s *statsCtx) swapUnit(new *unit) *unit {
 	return u
 }
 
+// dayOffset - get the number of hours to add to a unit ID so the days start at midnight in the configured time zone
+// The current offset is used for all days, and it's rounded down to the whole hours (the units are hourly).
+func (s *statsCtx) dayOffset() uint32 {
+	if s.conf.Location == nil {
+		return 0
+	}
+	_, off := time.Now().In(s.conf.Location).Zone()
+	h := off / 3600
+	if off < 0 && off%3600 != 0 {
+		h--
+	}
+	return uint32((h + 24) % 24)
+}
+
 // Get unit ID for the current hour
 func newUnitID() uint32 {
 	return uint32(time.Now().Unix() / (60 * 60))
@@ -229,6 +245,7 @@ func (s *statsCtx) periodicFlush() {
 
 		id := s.conf.UnitID()
 		if ptr.id == id {
+			s.compactIfNecessary(time.Now())
 			time.Sleep(time.Second)
 			continue
 		}
@@ -566,7 +583,8 @@ func (s *statsCtx) getData() map[string]interface{} {
 	// per time unit counters:
 
 	// 720 hours may span 31 days, so we skip data for the first day in this case
-	firstDayID := (firstID + 24 - 1) / 24 * 24 // align_ceil(24)
+	off := s.dayOffset()
+	firstDayID := (firstID+off+24-1)/24*24 - off // align_ceil(24) in the configured time zone
 
 	a := []uint64{}
 	if timeUnit == Hours {
@@ -698,32 +716,13 @@ func (s *statsCtx) getData() map[string]interface{} {
 
 	// total counters:
 
-	sum := unitDB{}
-	sum.NResult = make([]uint64, rLast)
-	timeN := 0
-	for _, u := range units {
-		sum.NTotal += u.NTotal
-		sum.TimeAvg += u.TimeAvg
-		if u.TimeAvg != 0 {
-			timeN++
-		}
-		sum.NResult[RFiltered] += u.NResult[RFiltered]
-		sum.NResult[RSafeBrowsing] += u.NResult[RSafeBrowsing]
-		sum.NResult[RSafeSearch] += u.NResult[RSafeSearch]
-		sum.NResult[RParental] += u.NResult[RParental]
-	}
-
-	d["num_dns_queries"] = sum.NTotal
-	d["num_blocked_filtering"] = sum.NResult[RFiltered]
-	d["num_replaced_safebrowsing"] = sum.NResult[RSafeBrowsing]
-	d["num_replaced_safesearch"] = sum.NResult[RSafeSearch]
-	d["num_replaced_parental"] = sum.NResult[RParental]
-
-	avgTime := float64(0)
-	if timeN != 0 {
-		avgTime = float64(sum.TimeAvg/uint32(timeN)) / 1000000
-	}
-	d["avg_processing_time"] = avgTime
+	sum := sumUnits(units)
+	d["num_dns_queries"] = sum.DNSQueries
+	d["num_blocked_filtering"] = sum.BlockedFiltering
+	d["num_replaced_safebrowsing"] = sum.ReplacedSafeBrowsing
+	d["num_replaced_safesearch"] = sum.ReplacedSafeSearch
+	d["num_replaced_parental"] = sum.ReplacedParental
+	d["avg_processing_time"] = sum.AvgProcessingTime
 
 	d["time_units"] = "hours"
 	if timeUnit == Days {
@@ -753,3 +752,47 @@ func (s *statsCtx) GetTopClientsIP(maxCount uint) []string {
 	}
 	return d
 }
+
+func (s *statsCtx) GetHourlyQueries() []uint64 {
+	units, _ := s.loadUnits(s.conf.limit)
+	if units == nil {
+		return nil
+	}
+
+	a := make([]uint64, 0, len(units))
+	for _, u := range units {
+		a = append(a, u.NTotal)
+	}
+	return a
+}
+
+// sumUnits - get the total counters for the units
+func sumUnits(units []*unitDB) Summary {
+	sum := Summary{}
+	var timeSum uint32
+	timeN := 0
+	for _, u := range units {
+		sum.DNSQueries += u.NTotal
+		timeSum += u.TimeAvg
+		if u.TimeAvg != 0 {
+			timeN++
+		}
+		sum.BlockedFiltering += u.NResult[RFiltered]
+		sum.ReplacedSafeBrowsing += u.NResult[RSafeBrowsing]
+		sum.ReplacedSafeSearch += u.NResult[RSafeSearch]
+		sum.ReplacedParental += u.NResult[RParental]
+	}
+
+	if timeN != 0 {
+		sum.AvgProcessingTime = float64(timeSum/uint32(timeN)) / 1000000
+	}
+	return sum
+}
+
+func (s *statsCtx) GetSummary() Summary {
+	units, _ := s.loadUnits(s.conf.limit)
+	if units == nil {
+		return Summary{}
+	}
+	return sumUnits(units)
+}
@@ -0,0 +1,262 @@
+// Package tracing implements a minimal OpenTelemetry tracer
+// which sends the spans to a collector using OTLP/HTTP protocol (JSON encoding).
+package tracing
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Config - tracer settings
+type Config struct {
+	Endpoint    string            // OTLP/HTTP traces endpoint, e.g. "http://localhost:4318/v1/traces"
+	Headers     map[string]string // additional HTTP headers, e.g. for authorization
+	ServiceName string            // "service.name" resource attribute
+	SampleRate  float64           // the share of traces that are recorded (0..1)
+	MinDuration time.Duration     // export only the traces that took longer than this
+
+	Client *http.Client // HTTP client (if nil, a default client is used)
+}
+
+// Span kinds
+const (
+	kindInternal = 1
+	kindServer   = 2
+)
+
+const (
+	exportInterval = 5 * time.Second
+	exportBatch    = 512  // send the spans as soon as there are this many in the queue
+	maxQueue       = 8192 // the spans that don't fit in the queue are dropped
+)
+
+// Tracer - records the spans and exports them to a collector
+// All methods are safe to call on a nil object (tracing is disabled).
+type Tracer struct {
+	conf   Config
+	client *http.Client
+
+	lock    sync.Mutex
+	queue   []*Span // ended spans waiting for export
+	dropped uint64  // the number of dropped spans
+
+	kick chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Attr - span attribute
+type Attr struct {
+	Key   string
+	Value interface{} // string, bool, int, int64, uint32, uint64, float64
+}
+
+// Span - a unit of work inside a trace
+type Span struct {
+	t     *Tracer
+	trace *trace
+
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []Attr
+	err      string
+}
+
+// trace - the spans of one trace, they're exported when the root span ends
+type trace struct {
+	lock  sync.Mutex
+	spans []*Span
+}
+
+// New - create a tracer
+func New(conf Config) (*Tracer, error) {
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid endpoint: %q", conf.Endpoint)
+	}
+	if conf.SampleRate < 0 || conf.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid sample rate: %f", conf.SampleRate)
+	}
+	if len(conf.ServiceName) == 0 {
+		conf.ServiceName = "AdGuardHome"
+	}
+
+	t := &Tracer{
+		conf:   conf,
+		client: conf.Client,
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	if t.client == nil {
+		t.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return t, nil
+}
+
+// Start - start exporting the spans
+func (t *Tracer) Start() {
+	if t == nil {
+		return
+	}
+	t.wg.Add(1)
+	go t.loop()
+}
+
+// Close - export the pending spans and stop
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	t.wg.Wait()
+}
+
+func (t *Tracer) loop() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.kick:
+		case <-t.stop:
+			t.flush()
+			return
+		}
+		t.flush()
+	}
+}
+
+// flush - send the queued spans to the collector
+func (t *Tracer) flush() {
+	t.lock.Lock()
+	spans := t.queue
+	t.queue = nil
+	dropped := t.dropped
+	t.dropped = 0
+	t.lock.Unlock()
+
+	if dropped != 0 {
+		log.Debug("tracing: %d spans were dropped", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	err := t.export(spans)
+	if err != nil {
+		log.Error("tracing: %s: %s", t.conf.Endpoint, err)
+	}
+}
+
+// enqueue - add the spans of the finished trace to the export queue
+func (t *Tracer) enqueue(spans []*Span) {
+	t.lock.Lock()
+	n := len(spans)
+	if len(t.queue)+n > maxQueue {
+		n = maxQueue - len(t.queue)
+		t.dropped += uint64(len(spans) - n)
+	}
+	t.queue = append(t.queue, spans[:n]...)
+	full := len(t.queue) >= exportBatch
+	t.lock.Unlock()
+
+	if full {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// NewTrace - start a new trace; return nil if the trace isn't sampled
+func (t *Tracer) NewTrace(name string) *Span {
+	if t == nil || rand.Float64() >= t.conf.SampleRate {
+		return nil
+	}
+	s := &Span{
+		t:     t,
+		trace: &trace{},
+		name:  name,
+		kind:  kindServer,
+		start: time.Now(),
+	}
+	_, _ = rand.Read(s.traceID[:])
+	_, _ = rand.Read(s.spanID[:])
+	return s
+}
+
+// NewChild - start a child span
+func (s *Span) NewChild(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	c := &Span{
+		t:        s.t,
+		trace:    s.trace,
+		traceID:  s.traceID,
+		parentID: s.spanID,
+		name:     name,
+		kind:     kindInternal,
+		start:    time.Now(),
+	}
+	_, _ = rand.Read(c.spanID[:])
+	return c
+}
+
+// SetAttr - set span attribute
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, Attr{Key: key, Value: value})
+}
+
+// SetError - mark the span as failed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+func (s *Span) isRoot() bool {
+	return s.parentID == [8]byte{}
+}
+
+// End - finish the span
+// When the root span ends, the whole trace is queued for export
+// (unless it took less time than MinDuration).
+// The child spans that end after the root span are lost.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+
+	tr := s.trace
+	tr.lock.Lock()
+	if !s.isRoot() {
+		tr.spans = append(tr.spans, s)
+		tr.lock.Unlock()
+		return
+	}
+	spans := append(tr.spans, s)
+	tr.spans = nil
+	tr.lock.Unlock()
+
+	if s.end.Sub(s.start) < s.t.conf.MinDuration {
+		return
+	}
+	s.t.enqueue(spans)
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracer(t *testing.T) {
+	reqs := make(chan otlpRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer 123", r.Header.Get("Authorization"))
+		req := otlpRequest{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		reqs <- req
+	}))
+	defer srv.Close()
+
+	tr, err := New(Config{
+		Endpoint:   srv.URL + "/v1/traces",
+		Headers:    map[string]string{"Authorization": "Bearer 123"},
+		SampleRate: 1,
+	})
+	assert.Nil(t, err)
+	tr.Start()
+
+	root := tr.NewTrace("dns.request")
+	root.SetAttr("dns.question.name", "example.org")
+	child := root.NewChild("dns.upstream")
+	child.SetAttr("dns.cache_hit", false)
+	child.SetAttr("dns.ttl", uint32(300))
+	child.SetError(errors.New("timeout"))
+	child.End()
+	root.End()
+	tr.Close()
+
+	req := <-reqs
+	assert.Equal(t, 1, len(req.ResourceSpans))
+	rs := req.ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "AdGuardHome", *rs.Resource.Attributes[0].Value.StringValue)
+	spans := rs.ScopeSpans[0].Spans
+	assert.Equal(t, 2, len(spans))
+
+	c := spans[0]
+	r := spans[1]
+	assert.Equal(t, "dns.upstream", c.Name)
+	assert.Equal(t, "dns.request", r.Name)
+	assert.Equal(t, 32, len(r.TraceID))
+	assert.Equal(t, r.TraceID, c.TraceID)
+	assert.Equal(t, r.SpanID, c.ParentSpanID)
+	assert.Equal(t, "", r.ParentSpanID)
+	assert.Equal(t, kindServer, r.Kind)
+	assert.Equal(t, kindInternal, c.Kind)
+	assert.Equal(t, "example.org", *r.Attributes[0].Value.StringValue)
+	assert.False(t, *c.Attributes[0].Value.BoolValue)
+	assert.Equal(t, "300", *c.Attributes[1].Value.IntValue)
+	assert.Equal(t, 2, c.Status.Code)
+	assert.Equal(t, "timeout", c.Status.Message)
+	assert.Nil(t, r.Status)
+}
+
+func TestTracerFilter(t *testing.T) {
+	tr, err := New(Config{Endpoint: "http://localhost:4318/v1/traces", SampleRate: 1, MinDuration: time.Hour})
+	assert.Nil(t, err)
+
+	// the trace is shorter than MinDuration
+	root := tr.NewTrace("dns.request")
+	root.NewChild("dns.upstream").End()
+	root.End()
+	assert.Equal(t, 0, len(tr.queue))
+
+	tr.conf.MinDuration = 0
+	root = tr.NewTrace("dns.request")
+	root.NewChild("dns.upstream").End()
+	root.End()
+	assert.Equal(t, 2, len(tr.queue))
+
+	// not sampled
+	tr.conf.SampleRate = 0
+	assert.Nil(t, tr.NewTrace("dns.request"))
+
+	// disabled
+	tr = nil
+	root = tr.NewTrace("dns.request")
+	root.NewChild("dns.upstream").End()
+	root.SetAttr("key", "value")
+	root.End()
+	tr.Start()
+	tr.Close()
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New(Config{Endpoint: "localhost:4318"})
+	assert.NotNil(t, err)
+	_, err = New(Config{Endpoint: "http://localhost:4318/v1/traces", SampleRate: 2})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,322 @@
+// Package unbound reads Unbound host overrides and domain overrides
+// from pfSense or OPNsense configuration backup (config.xml) or from unbound.conf.
+package unbound
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Record - local DNS record (host override)
+type Record struct {
+	Domain string
+	Answer string // IP address or canonical name (CNAME)
+}
+
+// Forward - domain override: the queries for the domain and its subdomains are sent to the servers
+type Forward struct {
+	Domain  string
+	Servers []string // IP address with optional port, e.g. "10.0.0.1" or "10.0.0.1:5353"
+}
+
+// Data - Unbound settings
+type Data struct {
+	Records  []Record
+	Forwards []Forward
+	Skipped  int // the number of the entries that can't be converted
+}
+
+// Import - parse configuration data: pfSense/OPNsense config.xml or unbound.conf
+func Import(data []byte) (*Data, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no data")
+	}
+	d := &Data{}
+	if data[0] == '<' {
+		err := d.parseXML(data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		d.parseConf(data)
+	}
+	if len(d.Records) == 0 && len(d.Forwards) == 0 {
+		return nil, fmt.Errorf("no host overrides or domain overrides found")
+	}
+	return d, nil
+}
+
+// pfSense and OPNsense (before 21.1): unbound section
+type xmlHost struct {
+	Host    string `xml:"host"`
+	Domain  string `xml:"domain"`
+	IP      string `xml:"ip"` // comma-separated list
+	Aliases []struct {
+		Host   string `xml:"host"`
+		Domain string `xml:"domain"`
+	} `xml:"aliases>item"`
+}
+
+type xmlDomainOverride struct {
+	Domain string `xml:"domain"`
+	IP     string `xml:"ip"`
+}
+
+// OPNsense: unboundplus section
+type xmlPlusHost struct {
+	UUID     string `xml:"uuid,attr"`
+	Enabled  string `xml:"enabled"`
+	Hostname string `xml:"hostname"`
+	Domain   string `xml:"domain"`
+	RR       string `xml:"rr"`
+	Server   string `xml:"server"`
+}
+
+type xmlPlusAlias struct {
+	Enabled  string `xml:"enabled"`
+	Host     string `xml:"host"` // UUID of the host override
+	Hostname string `xml:"hostname"`
+	Domain   string `xml:"domain"`
+}
+
+type xmlPlusDomain struct {
+	Enabled string `xml:"enabled"`
+	Domain  string `xml:"domain"`
+	Server  string `xml:"server"`
+}
+
+type xmlConfig struct {
+	Unbound struct {
+		Hosts           []xmlHost           `xml:"hosts"`
+		DomainOverrides []xmlDomainOverride `xml:"domainoverrides"`
+	} `xml:"unbound"`
+	OPNsense struct {
+		UnboundPlus struct {
+			Hosts   []xmlPlusHost   `xml:"hosts>host"`
+			Aliases []xmlPlusAlias  `xml:"aliases>alias"`
+			Domains []xmlPlusDomain `xml:"domains>domain"`
+		} `xml:"unboundplus"`
+	} `xml:"OPNsense"`
+}
+
+// hostName - get the fully qualified host name: "host" + "." + "domain"
+// "*" host name is kept as a wildcard.
+func hostName(host, domain string) string {
+	host = strings.TrimSpace(host)
+	domain = strings.Trim(strings.TrimSpace(domain), ".")
+	if len(host) == 0 {
+		return strings.ToLower(domain)
+	}
+	if len(domain) == 0 {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(host + "." + domain)
+}
+
+// parseServer - convert Unbound server address ("IP[@port][#tls-name]") to upstream address
+func parseServer(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		// DNS-over-TLS server name isn't supported
+		return "", false
+	}
+	port := ""
+	if i := strings.IndexByte(s, '@'); i >= 0 {
+		port = s[i+1:]
+		s = s[:i]
+	}
+	if net.ParseIP(s) == nil {
+		return "", false
+	}
+	if len(port) == 0 || port == "53" {
+		return s, true
+	}
+	return net.JoinHostPort(s, port), true
+}
+
+func (d *Data) addForward(domain string, servers []string) {
+	domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+	f := Forward{Domain: domain}
+	for _, s := range servers {
+		addr, ok := parseServer(s)
+		if !ok {
+			d.Skipped++
+			continue
+		}
+		f.Servers = append(f.Servers, addr)
+	}
+	if len(domain) == 0 || len(f.Servers) == 0 {
+		d.Skipped++
+		return
+	}
+	d.Forwards = append(d.Forwards, f)
+}
+
+func (d *Data) parseXML(data []byte) error {
+	c := xmlConfig{}
+	err := xml.Unmarshal(data, &c)
+	if err != nil {
+		return fmt.Errorf("xml.Unmarshal: %s", err)
+	}
+
+	for _, h := range c.Unbound.Hosts {
+		names := []string{hostName(h.Host, h.Domain)}
+		for _, a := range h.Aliases {
+			names = append(names, hostName(a.Host, a.Domain))
+		}
+		for _, ip := range strings.Split(h.IP, ",") {
+			ip = strings.TrimSpace(ip)
+			if net.ParseIP(ip) == nil {
+				d.Skipped++
+				continue
+			}
+			for _, name := range names {
+				d.Records = append(d.Records, Record{Domain: name, Answer: ip})
+			}
+		}
+	}
+	for _, o := range c.Unbound.DomainOverrides {
+		d.addForward(o.Domain, []string{o.IP})
+	}
+
+	plus := c.OPNsense.UnboundPlus
+	hosts := map[string]string{} // UUID -> answer
+	for _, h := range plus.Hosts {
+		if h.Enabled == "0" {
+			continue
+		}
+		rr := strings.ToUpper(h.RR)
+		if (rr != "" && rr != "A" && rr != "AAAA") || net.ParseIP(h.Server) == nil {
+			d.Skipped++
+			continue
+		}
+		hosts[h.UUID] = h.Server
+		d.Records = append(d.Records, Record{Domain: hostName(h.Hostname, h.Domain), Answer: h.Server})
+	}
+	for _, a := range plus.Aliases {
+		if a.Enabled == "0" {
+			continue
+		}
+		answer, ok := hosts[a.Host]
+		if !ok {
+			d.Skipped++
+			continue
+		}
+		d.Records = append(d.Records, Record{Domain: hostName(a.Hostname, a.Domain), Answer: answer})
+	}
+	for _, dom := range plus.Domains {
+		if dom.Enabled == "0" {
+			continue
+		}
+		d.addForward(dom.Domain, []string{dom.Server})
+	}
+	return nil
+}
+
+// confValue - get the value of "name: value" line without quotes
+func confValue(line string) (string, string) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return "", ""
+	}
+	return strings.TrimSpace(line[:i]), strings.Trim(strings.TrimSpace(line[i+1:]), `"'`)
+}
+
+// parseLocalData - parse "name [TTL] [IN] type value" string
+func (d *Data) parseLocalData(s string) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		d.Skipped++
+		return
+	}
+	name := strings.ToLower(strings.TrimSuffix(fields[0], "."))
+	rest := fields[1:]
+	for len(rest) > 2 {
+		// skip TTL and class
+		rest = rest[1:]
+	}
+
+	switch strings.ToUpper(rest[0]) {
+	case "A", "AAAA":
+		if net.ParseIP(rest[1]) == nil {
+			d.Skipped++
+			return
+		}
+		d.Records = append(d.Records, Record{Domain: name, Answer: rest[1]})
+	case "CNAME":
+		d.Records = append(d.Records, Record{Domain: name, Answer: strings.TrimSuffix(rest[1], ".")})
+	default:
+		d.Skipped++
+	}
+}
+
+// stripComment - remove the comment from the line
+// '#' inside a value isn't a comment, e.g. "forward-addr: 1.1.1.1@853#cloudflare-dns.com"
+func stripComment(line string) string {
+	for i := 0; i != len(line); i++ {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			line = line[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// parseConf - parse unbound.conf: local-data records and forward-zone/stub-zone sections
+func (d *Data) parseConf(data []byte) {
+	zone := ""
+	servers := []string{}
+	endZone := func() {
+		if len(zone) != 0 && zone != "." {
+			d.addForward(zone, servers)
+		}
+		zone = ""
+		servers = nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := stripComment(sc.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		name, val := confValue(line)
+		switch name {
+		case "forward-zone", "stub-zone", "server", "remote-control", "auth-zone", "view":
+			endZone()
+		case "name":
+			zone = val
+		case "forward-addr", "stub-addr":
+			servers = append(servers, val)
+		case "local-data":
+			d.parseLocalData(val)
+		}
+	}
+	endZone()
+}
+
+// Valid - return TRUE if the record is a valid DNS rewrite
+// The labels of the host name may contain letters, digits, '-' and '_'.
+func (r Record) Valid() bool {
+	host := strings.TrimPrefix(r.Domain, "*.")
+	if len(host) == 0 || len(host) > 253 || len(r.Answer) == 0 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package unbound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportPfSense(t *testing.T) {
+	data := `<?xml version="1.0"?>
+<pfsense>
+	<unbound>
+		<enable></enable>
+		<hosts>
+			<host>nas</host>
+			<domain>lan</domain>
+			<ip>192.168.1.10,fd00::10</ip>
+			<descr><![CDATA[NAS]]></descr>
+			<aliases>
+				<item>
+					<host>files</host>
+					<domain>home.arpa</domain>
+					<description></description>
+				</item>
+			</aliases>
+		</hosts>
+		<hosts>
+			<host>printer</host>
+			<domain>lan</domain>
+			<ip>192.168.1.11</ip>
+			<aliases></aliases>
+		</hosts>
+		<domainoverrides>
+			<domain>corp.example</domain>
+			<ip>10.0.0.1@5353</ip>
+		</domainoverrides>
+		<domainoverrides>
+			<domain>tls.example</domain>
+			<ip>10.0.0.2@853#dns.example</ip>
+		</domainoverrides>
+	</unbound>
+</pfsense>`
+	d, err := Import([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{
+		{Domain: "nas.lan", Answer: "192.168.1.10"},
+		{Domain: "files.home.arpa", Answer: "192.168.1.10"},
+		{Domain: "nas.lan", Answer: "fd00::10"},
+		{Domain: "files.home.arpa", Answer: "fd00::10"},
+		{Domain: "printer.lan", Answer: "192.168.1.11"},
+	}, d.Records)
+	assert.Equal(t, []Forward{
+		{Domain: "corp.example", Servers: []string{"10.0.0.1:5353"}},
+	}, d.Forwards)
+	assert.Equal(t, 2, d.Skipped)
+}
+
+func TestImportOPNsense(t *testing.T) {
+	data := `<?xml version="1.0"?>
+<opnsense>
+	<OPNsense>
+		<unboundplus version="1.0.1">
+			<hosts>
+				<host uuid="1111">
+					<enabled>1</enabled>
+					<hostname>nas</hostname>
+					<domain>lan</domain>
+					<rr>A</rr>
+					<server>192.168.1.10</server>
+				</host>
+				<host uuid="2222">
+					<enabled>0</enabled>
+					<hostname>old</hostname>
+					<domain>lan</domain>
+					<rr>A</rr>
+					<server>192.168.1.20</server>
+				</host>
+				<host uuid="3333">
+					<enabled>1</enabled>
+					<hostname></hostname>
+					<domain>lan</domain>
+					<rr>MX</rr>
+					<mx>mail.lan</mx>
+				</host>
+			</hosts>
+			<aliases>
+				<alias uuid="4444">
+					<enabled>1</enabled>
+					<host>1111</host>
+					<hostname>*</hostname>
+					<domain>nas.lan</domain>
+				</alias>
+			</aliases>
+			<domains>
+				<domain uuid="5555">
+					<enabled>1</enabled>
+					<domain>corp.example</domain>
+					<server>10.0.0.1</server>
+				</domain>
+			</domains>
+		</unboundplus>
+	</OPNsense>
+</opnsense>`
+	d, err := Import([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{
+		{Domain: "nas.lan", Answer: "192.168.1.10"},
+		{Domain: "*.nas.lan", Answer: "192.168.1.10"},
+	}, d.Records)
+	assert.Equal(t, []Forward{
+		{Domain: "corp.example", Servers: []string{"10.0.0.1"}},
+	}, d.Forwards)
+	assert.Equal(t, 1, d.Skipped)
+	assert.True(t, d.Records[1].Valid())
+}
+
+func TestImportConf(t *testing.T) {
+	data := `server:
+	local-zone: "lan." static
+	local-data: "nas.lan. IN A 192.168.1.10"
+	local-data: "nas.lan. 3600 IN AAAA fd00::10"
+	local-data: 'files.lan CNAME nas.lan.'
+	local-data: "nas.lan. TXT text"
+	local-data-ptr: "192.168.1.10 nas.lan"
+
+# comment
+forward-zone:
+	name: "corp.example."
+	forward-addr: 10.0.0.1
+	forward-addr: 10.0.0.2@5353 # the second server
+	forward-addr: 10.0.0.3@853#dns.example
+
+forward-zone:
+	name: "."
+	forward-addr: 1.1.1.1
+
+stub-zone:
+	name: "10.in-addr.arpa."
+	stub-addr: 10.0.0.1
+`
+	d, err := Import([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{
+		{Domain: "nas.lan", Answer: "192.168.1.10"},
+		{Domain: "nas.lan", Answer: "fd00::10"},
+		{Domain: "files.lan", Answer: "nas.lan"},
+	}, d.Records)
+	assert.Equal(t, []Forward{
+		{Domain: "corp.example", Servers: []string{"10.0.0.1", "10.0.0.2:5353"}},
+		{Domain: "10.in-addr.arpa", Servers: []string{"10.0.0.1"}},
+	}, d.Forwards)
+	assert.Equal(t, 2, d.Skipped)
+
+	_, err = Import([]byte("server:\n\tverbosity: 1\n"))
+	assert.NotNil(t, err)
+	_, err = Import([]byte("<opnsense><broken>"))
+	assert.NotNil(t, err)
+}
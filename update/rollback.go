@@ -0,0 +1,125 @@
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The number of times the new version may start before it's rolled back.
+// If the new version crashes, it's restarted by the service manager
+// and the next start rolls it back.
+const maxStartAttempts = 1
+
+// pendingUpdate - information about the update that isn't verified yet
+// It's stored in "work_dir/agh-backup/update.json".
+type pendingUpdate struct {
+	PrevVersion string `json:"prev_version"`
+	NewVersion  string `json:"new_version"`
+	Attempts    int    `json:"attempts"` // the number of starts of the new version
+}
+
+func (u *Updater) pendingUpdateFile() string {
+	return filepath.Join(u.WorkDir, "agh-backup", "update.json")
+}
+
+func (u *Updater) writePendingUpdate(pu pendingUpdate) error {
+	data, err := json.Marshal(pu)
+	if err != nil {
+		return err
+	}
+	return file.SafeWrite(u.pendingUpdateFile(), data)
+}
+
+// markPending - store the information about the update that must be verified after restart
+func (u *Updater) markPending() error {
+	return u.writePendingUpdate(pendingUpdate{
+		PrevVersion: u.VersionString,
+		NewVersion:  u.NewVersion,
+	})
+}
+
+// BeginVerification - check if the application is started after an update
+// verify: the update must be verified by calling ConfirmUpdate() or Rollback()
+// rollback: the new version has already failed to start, Rollback() must be called
+func (u *Updater) BeginVerification() (verify bool, rollback bool) {
+	data, err := ioutil.ReadFile(u.pendingUpdateFile())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("updater: %s", err)
+		}
+		return false, false
+	}
+
+	pu := pendingUpdate{}
+	err = json.Unmarshal(data, &pu)
+	if err != nil {
+		log.Error("updater: %s: %s", u.pendingUpdateFile(), err)
+		_ = os.Remove(u.pendingUpdateFile())
+		return false, false
+	}
+
+	if pu.Attempts >= maxStartAttempts {
+		log.Error("updater: version %s has failed to start, rolling back to %s", pu.NewVersion, pu.PrevVersion)
+		return true, true
+	}
+
+	pu.Attempts++
+	err = u.writePendingUpdate(pu)
+	if err != nil {
+		log.Error("updater: %s", err)
+	}
+	log.Info("updater: verifying the update from %s to %s", pu.PrevVersion, pu.NewVersion)
+	return true, false
+}
+
+// ConfirmUpdate - the new version works, the update is complete
+func (u *Updater) ConfirmUpdate() {
+	err := os.Remove(u.pendingUpdateFile())
+	if err != nil && !os.IsNotExist(err) {
+		log.Error("updater: %s", err)
+		return
+	}
+	log.Info("updater: the update is verified")
+}
+
+// Rollback - restore the previous version of the executable file and the configuration from the backup
+// The application must be restarted after that.
+func (u *Updater) Rollback() error {
+	exeName := "AdGuardHome"
+	if u.OS == "windows" {
+		exeName = "AdGuardHome.exe"
+	}
+	backupDir := filepath.Join(u.WorkDir, "agh-backup")
+	backupExeName := filepath.Join(backupDir, exeName)
+	currentExeName := filepath.Join(u.WorkDir, exeName)
+
+	err := copyFile(filepath.Join(backupDir, "AdGuardHome.yaml"), u.ConfigName)
+	if err != nil {
+		return fmt.Errorf("copyFile() failed: %s", err)
+	}
+
+	log.Debug("updater: restoring: %s -> %s", backupExeName, currentExeName)
+	if u.OS == "windows" {
+		// rename fails with "File in use" error
+		err = copyFile(backupExeName, currentExeName)
+	} else {
+		err = os.Rename(backupExeName, currentExeName)
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(currentExeName, 0755)
+	if err != nil {
+		return err
+	}
+
+	_ = os.Remove(u.pendingUpdateFile())
+	log.Info("updater: rolled back to the previous version")
+	return nil
+}
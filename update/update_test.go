@@ -208,3 +208,57 @@ func TestUpdateWindows(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "AdGuardHome.yaml", string(d))
 }
+
+func TestUpdateRollback(t *testing.T) {
+	_ = os.MkdirAll("aghtest/agh-backup", 0755)
+	defer func() {
+		_ = os.RemoveAll("aghtest")
+	}()
+
+	// the new version and the backup of the previous one
+	assert.Nil(t, ioutil.WriteFile("aghtest/AdGuardHome", []byte("new"), 0755))
+	assert.Nil(t, ioutil.WriteFile("aghtest/AdGuardHome.yaml", []byte("new config"), 0644))
+	assert.Nil(t, ioutil.WriteFile("aghtest/agh-backup/AdGuardHome", []byte("old"), 0755))
+	assert.Nil(t, ioutil.WriteFile("aghtest/agh-backup/AdGuardHome.yaml", []byte("old config"), 0644))
+
+	u := NewUpdater(Config{
+		VersionString: "v0.103.0",
+		NewVersion:    "v0.103.1",
+		ConfigName:    "aghtest/AdGuardHome.yaml",
+		WorkDir:       "aghtest",
+	})
+
+	// no update
+	verify, rollback := u.BeginVerification()
+	assert.False(t, verify)
+	assert.False(t, rollback)
+
+	// confirmed update
+	assert.Nil(t, u.markPending())
+	verify, rollback = u.BeginVerification()
+	assert.True(t, verify)
+	assert.False(t, rollback)
+	u.ConfirmUpdate()
+	verify, _ = u.BeginVerification()
+	assert.False(t, verify)
+
+	// the new version has failed to start
+	assert.Nil(t, u.markPending())
+	verify, rollback = u.BeginVerification()
+	assert.True(t, verify)
+	assert.False(t, rollback)
+	verify, rollback = u.BeginVerification()
+	assert.True(t, verify)
+	assert.True(t, rollback)
+
+	assert.Nil(t, u.Rollback())
+	d, err := ioutil.ReadFile("aghtest/AdGuardHome")
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(d))
+	d, err = ioutil.ReadFile("aghtest/AdGuardHome.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, "old config", string(d))
+
+	verify, _ = u.BeginVerification()
+	assert.False(t, verify)
+}
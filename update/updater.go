@@ -62,6 +62,7 @@ func NewUpdater(cfg Config) *Updater {
 // 2. Unpacks it and checks the contents
 // 3. Backups the current version and configuration
 // 4. Replaces the old files
+// 5. Marks the update as pending verification (see BeginVerification())
 func (u *Updater) DoUpdate() error {
 	err := u.prepare()
 	if err != nil {
@@ -96,6 +97,12 @@ func (u *Updater) DoUpdate() error {
 		return err
 	}
 
+	// the new version must confirm that it works, otherwise it's rolled back
+	err = u.markPending()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
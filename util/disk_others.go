@@ -0,0 +1,10 @@
+// +build !darwin,!freebsd,!linux,!windows
+
+package util
+
+import "fmt"
+
+// DiskUsage - get the available and the total space of the file system where the path is located (in bytes)
+func DiskUsage(path string) (avail uint64, total uint64, err error) {
+	return 0, 0, fmt.Errorf("not supported")
+}
@@ -0,0 +1,15 @@
+// +build darwin freebsd linux
+
+package util
+
+import "syscall"
+
+// DiskUsage - get the available and the total space of the file system where the path is located (in bytes)
+func DiskUsage(path string) (avail uint64, total uint64, err error) {
+	st := syscall.Statfs_t{}
+	err = syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
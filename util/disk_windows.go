@@ -0,0 +1,14 @@
+package util
+
+import "golang.org/x/sys/windows"
+
+// DiskUsage - get the available and the total space of the disk where the path is located (in bytes)
+func DiskUsage(path string) (avail uint64, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var free uint64
+	err = windows.GetDiskFreeSpaceEx(p, &avail, &total, &free)
+	return avail, total, err
+}
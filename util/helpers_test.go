@@ -12,3 +12,49 @@ func TestSplitNext(t *testing.T) {
 	assert.True(t, SplitNext(&s, ',') == "b")
 	assert.True(t, SplitNext(&s, ',') == "c" && len(s) == 0)
 }
+
+func TestTrimBrackets(t *testing.T) {
+	assert.Equal(t, "::1", TrimBrackets("[::1]"))
+	assert.Equal(t, "::1", TrimBrackets("::1"))
+	assert.Equal(t, "127.0.0.1", TrimBrackets("127.0.0.1"))
+	assert.Equal(t, "[]", TrimBrackets("[]"))
+
+	assert.True(t, IsUnspecifiedHost("0.0.0.0"))
+	assert.True(t, IsUnspecifiedHost("::"))
+	assert.True(t, IsUnspecifiedHost("[::]"))
+	assert.False(t, IsUnspecifiedHost("::1"))
+	assert.False(t, IsUnspecifiedHost("localhost"))
+}
+
+func TestNormalizeUpstream(t *testing.T) {
+	assert.Equal(t, "2001:db8::1", NormalizeUpstream("[2001:db8::1]"))
+	assert.Equal(t, "[2001:db8::1]:5353", NormalizeUpstream("[2001:db8::1]:5353"))
+	assert.Equal(t, "tls://[2001:db8::1]", NormalizeUpstream("tls://[2001:db8::1]"))
+	assert.Equal(t, "[/example.org/]2001:db8::1", NormalizeUpstream("[/example.org/][2001:db8::1]"))
+	assert.Equal(t, "[/example.org/]1.1.1.1", NormalizeUpstream("[/example.org/]1.1.1.1"))
+	assert.Equal(t, "1.1.1.1", NormalizeUpstream("1.1.1.1"))
+	assert.Equal(t, "[/xn--e1afmkfd.xn--p1ai/example.org/]1.1.1.1", NormalizeUpstream("[/пример.рф/Example.ORG/]1.1.1.1"))
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	n, err := NormalizeDomain(" Пример.РФ. ")
+	assert.Nil(t, err)
+	assert.Equal(t, "xn--e1afmkfd.xn--p1ai", n)
+
+	n, err = NormalizeDomain("*.Bücher.example")
+	assert.Nil(t, err)
+	assert.Equal(t, "*.xn--bcher-kva.example", n)
+
+	n, err = NormalizeDomain("_dmarc.Example.org")
+	assert.Nil(t, err)
+	assert.Equal(t, "_dmarc.example.org", n)
+
+	_, err = NormalizeDomain("")
+	assert.NotNil(t, err)
+	_, err = NormalizeDomain("exa mple.рф")
+	assert.NotNil(t, err)
+	_, err = NormalizeDomain("пример..рф")
+	assert.NotNil(t, err)
+	_, err = NormalizeDomain("пример-.рф")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// The profile for converting the domain names entered by users:
+// like idna.Lookup, but the underscores are allowed (e.g. "_dmarc.example.org")
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// IsASCII - return TRUE if the string contains only ASCII characters
+func IsASCII(s string) bool {
+	for i := 0; i != len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeDomain - get the domain name in the form it has in DNS requests:
+// lower case, punycode for internationalized names ("Пример.РФ" -> "xn--e1afmkfd.xn--p1ai"), no trailing dot.
+// The wildcard prefix "*." is kept.
+func NormalizeDomain(host string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimSpace(host), ".")
+	wildcard := strings.HasPrefix(host, "*.")
+	if wildcard {
+		host = host[2:]
+	}
+	if len(host) == 0 {
+		return "", fmt.Errorf("empty domain name")
+	}
+
+	if IsASCII(host) {
+		host = strings.ToLower(host)
+	} else {
+		a, err := idnaProfile.ToASCII(host)
+		if err != nil {
+			return "", fmt.Errorf("invalid domain name %q: %s", host, err)
+		}
+		host = a
+	}
+	for i := 0; i != len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", fmt.Errorf("invalid domain name %q", host)
+		}
+	}
+	if host[0] == '.' || strings.Contains(host, "..") {
+		return "", fmt.Errorf("invalid domain name %q: empty label", host)
+	}
+
+	if wildcard {
+		host = "*." + host
+	}
+	return host, nil
+}
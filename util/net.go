@@ -1,13 +1,14 @@
 package util
 
 import (
-	"fmt"
 	"net"
+	"strconv"
+	"strings"
 )
 
 // CanBindPort - checks if we can bind to this port or not
 func CanBindPort(port int) (bool, error) {
-	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(LocalhostIP(), strconv.Itoa(port)))
 	if err != nil {
 		return false, err
 	}
@@ -19,3 +20,77 @@ func CanBindPort(port int) (bool, error) {
 	_ = l.Close()
 	return true, nil
 }
+
+// TrimBrackets - remove square brackets around IPv6 address: "[::1]" -> "::1"
+func TrimBrackets(host string) string {
+	if len(host) > 2 && host[0] == '[' && host[len(host)-1] == ']' {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// IsUnspecifiedHost - return TRUE if host is an unspecified address, i.e. "0.0.0.0" or "::"
+func IsUnspecifiedHost(host string) bool {
+	ip := net.ParseIP(TrimBrackets(host))
+	return ip != nil && ip.IsUnspecified()
+}
+
+// LocalhostIP - get the loopback address: "127.0.0.1" or "::1" if there's no IPv4 loopback (IPv6-only host)
+func LocalhostIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	has6 := false
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || !ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return "127.0.0.1"
+		}
+		has6 = true
+	}
+	if has6 {
+		return "::1"
+	}
+	return "127.0.0.1"
+}
+
+// NormalizeUpstream - remove square brackets around IPv6 address of a plain DNS upstream without port
+// and normalize the domain names (see NormalizeDomain)
+// "[2001:db8::1]" -> "2001:db8::1", "[/Example.org/][2001:db8::1]" -> "[/example.org/]2001:db8::1"
+func NormalizeUpstream(u string) string {
+	domains := ""
+	if strings.HasPrefix(u, "[/") {
+		i := strings.Index(u, "/]")
+		if i < 0 {
+			return u
+		}
+		hosts := strings.Split(u[2:i], "/")
+		for j, h := range hosts {
+			if n, err := NormalizeDomain(h); err == nil {
+				hosts[j] = n
+			}
+		}
+		domains = "[/" + strings.Join(hosts, "/") + "/]"
+		u = u[i+2:]
+	}
+	if !strings.Contains(u, "://") {
+		_, _, err := net.SplitHostPort(u)
+		if err != nil {
+			u = TrimBrackets(u)
+		}
+	}
+	return domains + u
+}
+
+// NormalizeUpstreams - normalize the list of upstreams
+func NormalizeUpstreams(list []string) []string {
+	r := make([]string, len(list))
+	for i, u := range list {
+		r[i] = NormalizeUpstream(u)
+	}
+	return r
+}
@@ -0,0 +1,54 @@
+// +build !windows
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// LookupUser - get UID and GID by the user and group names or numeric IDs
+// If group is empty, the user's primary group is used.
+func LookupUser(userName, groupName string) (int, int, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		u, err = user.LookupId(userName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("user %s: %s", userName, err)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %s: invalid UID %s", userName, u.Uid)
+	}
+
+	gidStr := u.Gid
+	if len(groupName) != 0 {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+			if err != nil {
+				return 0, 0, fmt.Errorf("group %s: %s", groupName, err)
+			}
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid GID %s", gidStr)
+	}
+	return uid, gid, nil
+}
+
+// ChownR - change the owner of the file or the directory with all its contents
+func ChownR(path string, uid, gid int) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+}
@@ -0,0 +1,10 @@
+// +build !go1.16
+
+package util
+
+import "errors"
+
+// DropPrivileges - switch the process to the user and the group
+func DropPrivileges(uid, gid int, netAdmin bool) error {
+	return errors.New("dropping privileges requires a build with Go 1.16 or newer")
+}
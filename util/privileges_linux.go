@@ -0,0 +1,66 @@
+// +build go1.16
+
+package util
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/sys/unix"
+)
+
+// The capabilities that are retained after the privileges are dropped:
+// the privileged ports may be bound again, e.g. when DNS server is reconfigured
+var retainedCaps = []uintptr{unix.CAP_NET_BIND_SERVICE, unix.CAP_NET_RAW}
+
+// DropPrivileges - switch the process to the user and the group
+// netAdmin: also retain CAP_NET_ADMIN, e.g. to change the firewall rules and the network addresses
+// Note: this doesn't work if the binary is built with cgo.
+func DropPrivileges(uid, gid int, netAdmin bool) error {
+	caps := retainedCaps
+	if netAdmin {
+		caps = append([]uintptr{unix.CAP_NET_ADMIN}, caps...)
+	}
+
+	_, _, e := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 1, 0)
+	if e != 0 {
+		return fmt.Errorf("prctl(PR_SET_KEEPCAPS): %s", e)
+	}
+
+	err := syscall.Setgroups([]int{gid})
+	if err != nil {
+		return fmt.Errorf("setgroups: %s", err)
+	}
+	err = syscall.Setgid(gid)
+	if err != nil {
+		return fmt.Errorf("setgid: %s", err)
+	}
+	err = syscall.Setuid(uid)
+	if err != nil {
+		return fmt.Errorf("setuid: %s", err)
+	}
+
+	var mask uint32
+	for _, c := range caps {
+		mask |= 1 << c
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{{Effective: mask, Permitted: mask, Inheritable: mask}}
+	_, _, e = syscall.AllThreadsSyscall(syscall.SYS_CAPSET,
+		uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if e != 0 {
+		return fmt.Errorf("capset: %s", e)
+	}
+
+	// ambient capabilities are inherited by the new process after restart
+	for _, c := range caps {
+		_, _, e = syscall.AllThreadsSyscall(syscall.SYS_PRCTL, unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, c)
+		if e != 0 {
+			// not supported by Linux < 4.3
+			log.Debug("prctl(PR_CAP_AMBIENT_RAISE, %d): %s", c, e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+// +build !linux,!windows
+
+package util
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// DropPrivileges - switch the process to the user and the group
+// Not supported: the privileged ports couldn't be bound again after the settings are changed.
+func DropPrivileges(uid, gid int, netAdmin bool) error {
+	return fmt.Errorf("dropping privileges isn't supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,26 @@
+// +build linux
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupUser(t *testing.T) {
+	uid, gid, err := LookupUser("root", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, uid)
+	assert.Equal(t, 0, gid)
+
+	uid, gid, err = LookupUser("0", "0")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, uid)
+	assert.Equal(t, 0, gid)
+
+	_, _, err = LookupUser("no-such-user-agh", "")
+	assert.NotNil(t, err)
+	_, _, err = LookupUser("root", "no-such-group-agh")
+	assert.NotNil(t, err)
+}
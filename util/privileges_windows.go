@@ -0,0 +1,20 @@
+package util
+
+import "errors"
+
+var errPrivilegesWindows = errors.New("dropping privileges isn't supported on Windows")
+
+// LookupUser - get UID and GID by the user and group names or numeric IDs
+func LookupUser(userName, groupName string) (int, int, error) {
+	return 0, 0, errPrivilegesWindows
+}
+
+// ChownR - change the owner of the file or the directory with all its contents
+func ChownR(path string, uid, gid int) error {
+	return errPrivilegesWindows
+}
+
+// DropPrivileges - switch the process to the user and the group
+func DropPrivileges(uid, gid int, netAdmin bool) error {
+	return errPrivilegesWindows
+}
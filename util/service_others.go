@@ -0,0 +1,9 @@
+// +build !windows
+
+package util
+
+// SetServiceRecoveryActions configures the service manager to restart the service if it fails.
+// Not supported on this OS: the service configuration files already contain the restart settings.
+func SetServiceRecoveryActions(serviceName string) error {
+	return nil
+}
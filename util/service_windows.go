@@ -0,0 +1,30 @@
+package util
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// SetServiceRecoveryActions configures the Service Control Manager to restart the service if it fails.
+// The failure counter is reset after one day without failures.
+func SetServiceRecoveryActions(serviceName string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	actions := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 60 * time.Second},
+	}
+	return s.SetRecoveryActions(actions, uint32((24 * time.Hour).Seconds()))
+}
@@ -3,6 +3,8 @@
 package util
 
 import (
+	"bytes"
+	"io"
 	"log"
 	"log/syslog"
 )
@@ -16,3 +18,25 @@ func ConfigureSyslog(serviceName string) error {
 	log.SetOutput(w)
 	return nil
 }
+
+// ErrorLogWriter returns the writer that sends error messages to syslog.
+// Other messages are ignored.
+func ErrorLogWriter(serviceName string) (io.Writer, error) {
+	w, err := syslog.New(syslog.LOG_ERR|syslog.LOG_USER, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return &errorLogWriter{w: w}, nil
+}
+
+type errorLogWriter struct {
+	w io.Writer
+}
+
+// Write sends error messages to syslog
+func (w *errorLogWriter) Write(b []byte) (int, error) {
+	if !bytes.Contains(b, []byte("[error] ")) && !bytes.Contains(b, []byte("[fatal] ")) {
+		return len(b), nil
+	}
+	return w.w.Write(b)
+}
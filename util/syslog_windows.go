@@ -1,6 +1,8 @@
 package util
 
 import (
+	"bytes"
+	"io"
 	"log"
 	"strings"
 
@@ -10,14 +12,28 @@ import (
 
 type eventLogWriter struct {
 	el *eventlog.Log
+
+	// If true, only errors are sent to the Event Log
+	errorsOnly bool
+}
+
+// isErrorMessage - check if the log message is an error
+func isErrorMessage(b []byte) bool {
+	return bytes.Contains(b, []byte("[error] ")) || bytes.Contains(b, []byte("[fatal] "))
 }
 
 // Write sends a log message to the Event Log.
 func (w *eventLogWriter) Write(b []byte) (int, error) {
+	if isErrorMessage(b) {
+		return len(b), w.el.Error(1, string(b))
+	}
+	if w.errorsOnly {
+		return len(b), nil
+	}
 	return len(b), w.el.Info(1, string(b))
 }
 
-func ConfigureSyslog(serviceName string) error {
+func newEventLogWriter(serviceName string) (*eventLogWriter, error) {
 	// Note that the eventlog src is the same as the service name
 	// Otherwise, we will get "the description for event id cannot be found" warning in every log record
 
@@ -26,14 +42,33 @@ func ConfigureSyslog(serviceName string) error {
 	// for pre-existing eventlog sources.
 	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Info|eventlog.Warning|eventlog.Error); err != nil {
 		if !strings.Contains(err.Error(), "registry key already exists") && err != windows.ERROR_ACCESS_DENIED {
-			return err
+			return nil, err
 		}
 	}
 	el, err := eventlog.Open(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogWriter{el: el}, nil
+}
+
+func ConfigureSyslog(serviceName string) error {
+	w, err := newEventLogWriter(serviceName)
 	if err != nil {
 		return err
 	}
 
-	log.SetOutput(&eventLogWriter{el: el})
+	log.SetOutput(w)
 	return nil
 }
+
+// ErrorLogWriter returns the writer that sends error messages to the Event Log.
+// Other messages are ignored.
+func ErrorLogWriter(serviceName string) (io.Writer, error) {
+	w, err := newEventLogWriter(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	w.errorsOnly = true
+	return w, nil
+}